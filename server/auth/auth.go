@@ -0,0 +1,126 @@
+/*
+Package auth implements authentication for the server's HTTP requests.
+
+Requests are authenticated by an Authenticator, which identifies the client
+that sent the request as a Principal. The Principal then decides what the
+client may do (e.g., to which channels it may post messages).
+
+Currently, it implements authentication through JWT bearer tokens issued
+by an external identity provider, verified with the keys published by the
+provider's JWKS endpoint (see "NewJWT()"), through a single static bearer
+token, shared with a few trusted clients (see "NewToken()"), and through
+the username and password of a few known users (see "NewBasic()"). Any
+of these may be wrapped in a Swappable, so they may be replaced at runtime.
+
+Example:
+
+	a, err := auth.NewJWT(auth.JWTOptions{
+		JWKSURL: "https://idp.example.com/.well-known/jwks.json",
+		Issuer: "https://idp.example.com/",
+		Audience: "sqs-issue-notifier",
+	})
+	if err != nil {
+		// handle err
+	}
+
+	p, err := a.Authenticate(req)
+	if err != nil {
+		// reply with 401
+	} else if !p.CanPost("general") {
+		// reply with 403
+	}
+*/
+package auth
+
+import (
+	"context"
+	"github.com/SirGFM/sqs-issue-notifier/server/loglevel"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// logger retrieves the logger for the authenticators' entries, identified
+// as the "web" component.
+func logger() *slog.Logger {
+	return slog.Default().With(loglevel.ComponentKey, "web")
+}
+
+// Authenticator identifies the client that sent a request.
+type Authenticator interface {
+	// Authenticate the request, returning the client that sent it. Fails
+	// with ErrNoCredentials if the request doesn't carry any credentials,
+	// and with ErrInvalidCredentials if its credentials can't be verified.
+	Authenticate(req *http.Request) (*Principal, error)
+}
+
+// Challenger is implemented by Authenticators that don't use bearer
+// tokens, to tell clients how they must authenticate. An empty challenge
+// means that bearer tokens are used.
+type Challenger interface {
+	// Challenge retrieves the WWW-Authenticate header sent with
+	// unauthorized responses.
+	Challenge() string
+}
+
+// bearerToken retrieves the bearer token in the request's Authorization
+// header.
+func bearerToken(req *http.Request) (string, error) {
+	hdr := req.Header.Get("Authorization")
+	if len(hdr) == 0 {
+		return "", ErrNoCredentials
+	}
+
+	const prefix = "bearer "
+	if len(hdr) <= len(prefix) || strings.ToLower(hdr[:len(prefix)]) != prefix {
+		return "", ErrInvalidCredentials
+	}
+	return strings.TrimSpace(hdr[len(prefix):]), nil
+}
+
+// Principal is an authenticated client.
+type Principal struct {
+	// Identifies the client (e.g., the token's subject).
+	Subject string
+
+	// Whether the client may post to any channel.
+	AllChannels bool
+
+	// Channels to which the client may post. Ignored if AllChannels is
+	// set.
+	Channels []string
+
+	// Whether the client may use administrative endpoints (e.g., to
+	// inspect pending messages).
+	Admin bool
+}
+
+// CanPost checks whether the client may post messages to channel.
+func (p *Principal) CanPost(channel string) bool {
+	if p.AllChannels {
+		return true
+	}
+
+	for _, c := range p.Channels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// principalKey is the key associating a request's context to its
+// Principal.
+type principalKey struct{}
+
+// WithPrincipal associates p to the context ctx.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext retrieves the Principal associated to ctx by WithPrincipal.
+// ok is false if there's none (e.g., if authentication is disabled).
+func FromContext(ctx context.Context) (p *Principal, ok bool) {
+	p, ok = ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
@@ -0,0 +1,323 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/crypto/bcrypt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testIdP is an identity provider publishing its keys in a JWKS endpoint.
+type testIdP struct {
+	// The signing keys, by their ID.
+	keys map[string]*rsa.PrivateKey
+
+	// Number of times the keys were fetched.
+	fetches int32
+}
+
+func (idp *testIdP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	atomic.AddInt32(&idp.fetches, 1)
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	for kid, key := range idp.keys {
+		set.Keys = append(set.Keys, jwk{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			N: base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+	json.NewEncoder(w).Encode(&set)
+}
+
+// sign claims with the key kid.
+func (idp *testIdP) sign(t *testing.T, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+
+	s, err := token.SignedString(idp.keys[kid])
+	if err != nil {
+		t.Fatalf("Failed to sign the token: %+v", err)
+	}
+	return s
+}
+
+// newKey generates a RSA key for testing.
+func newKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate a key: %+v", err)
+	}
+	return key
+}
+
+// authenticate a request carrying the Authorization header hdr.
+func authenticate(a Authenticator, hdr string) (*Principal, error) {
+	req := httptest.NewRequest(http.MethodPost, "/message", nil)
+	if len(hdr) > 0 {
+		req.Header.Set("Authorization", hdr)
+	}
+	return a.Authenticate(req)
+}
+
+// TestJWT checks that only valid tokens are accepted, and that the
+// channels are mapped from the token's claims.
+func TestJWT(t *testing.T) {
+	idp := &testIdP{
+		keys: map[string]*rsa.PrivateKey{"key-1": newKey(t)},
+	}
+	srv := httptest.NewServer(idp)
+	defer srv.Close()
+
+	a, err := NewJWT(JWTOptions{
+		JWKSURL: srv.URL,
+		Issuer: "test-idp",
+		Audience: "notifier",
+		ChannelsClaim: "channels",
+		AdminClaim: "admin",
+	})
+	if err != nil {
+		t.Fatalf("NewJWT: Failed to create the authenticator: %+v", err)
+	}
+
+	claims := func(extra jwt.MapClaims) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"iss": "test-idp",
+			"aud": "notifier",
+			"sub": "client",
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+
+	test_cases := []struct{
+		hdr string
+		err error
+		channel string
+		canPost bool
+		admin bool
+	} {
+		{ hdr: "", err: ErrNoCredentials },
+		{ hdr: "Basic dXNlcjpwYXNz", err: ErrInvalidCredentials },
+		{ hdr: "Bearer not-a-token", err: ErrInvalidCredentials },
+		{
+			hdr: "Bearer " + idp.sign(t, "key-1", claims(jwt.MapClaims{"channels": []string{"general", "alerts"}})),
+			channel: "alerts",
+			canPost: true,
+		},
+		{
+			hdr: "Bearer " + idp.sign(t, "key-1", claims(jwt.MapClaims{"channels": "general alerts"})),
+			channel: "random",
+			canPost: false,
+		},
+		{
+			hdr: "bearer " + idp.sign(t, "key-1", claims(jwt.MapClaims{"channels": "*"})),
+			channel: "random",
+			canPost: true,
+		},
+		{
+			hdr: "Bearer " + idp.sign(t, "key-1", claims(jwt.MapClaims{"admin": true})),
+			admin: true,
+		},
+		{
+			hdr: "Bearer " + idp.sign(t, "key-1", claims(jwt.MapClaims{"admin": "true"})),
+			admin: false,
+		},
+		{
+			hdr: "Bearer " + idp.sign(t, "key-1", claims(nil)),
+			channel: "general",
+			canPost: false,
+		},
+		{
+			hdr: "Bearer " + idp.sign(t, "key-1", claims(jwt.MapClaims{"iss": "other-idp"})),
+			err: ErrInvalidCredentials,
+		},
+		{
+			hdr: "Bearer " + idp.sign(t, "key-1", claims(jwt.MapClaims{"aud": "other"})),
+			err: ErrInvalidCredentials,
+		},
+		{
+			hdr: "Bearer " + idp.sign(t, "key-1", claims(jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()})),
+			err: ErrInvalidCredentials,
+		},
+		{
+			hdr: "Bearer " + idp.sign(t, "key-1", jwt.MapClaims{"iss": "test-idp", "aud": "notifier", "sub": "client"}),
+			err: ErrInvalidCredentials,
+		},
+	}
+	for i, tc := range test_cases {
+		p, err := authenticate(a, tc.hdr)
+		if want, got := tc.err, err; want != got {
+			t.Errorf("%d: Authenticate: Expected error '%+v' but got '%+v'", i, want, got)
+		} else if err == nil && p.CanPost(tc.channel) != tc.canPost {
+			t.Errorf("%d: CanPost('%s'): Expected '%+v' but got '%+v' (%+v)", i, tc.channel, tc.canPost, !tc.canPost, p)
+		} else if err == nil && p.Admin != tc.admin {
+			t.Errorf("%d: Admin: Expected '%+v' but got '%+v'", i, tc.admin, p.Admin)
+		}
+	}
+
+	if want, got := int32(1), atomic.LoadInt32(&idp.fetches); want != got {
+		t.Errorf("Expected the keys to be fetched %d time(s), but they were fetched %d time(s)", want, got)
+	}
+}
+
+// TestJWKSRotation checks that the keys are fetched again when a token is
+// signed by an unknown key.
+func TestJWKSRotation(t *testing.T) {
+	idp := &testIdP{
+		keys: map[string]*rsa.PrivateKey{"old": newKey(t)},
+	}
+	srv := httptest.NewServer(idp)
+	defer srv.Close()
+
+	a, err := NewJWT(JWTOptions{JWKSURL: srv.URL})
+	if err != nil {
+		t.Fatalf("NewJWT: Failed to create the authenticator: %+v", err)
+	}
+	// Allow fetching the keys again right away.
+	a.(*jwtAuthenticator).keys.minRefresh = 0
+
+	claims := jwt.MapClaims{"exp": time.Now().Add(time.Hour).Unix()}
+	if _, err := authenticate(a, "Bearer " + idp.sign(t, "old", claims)); err != nil {
+		t.Errorf("Authenticate: Failed to authenticate with the old key: %+v", err)
+	}
+
+	idp.keys["new"] = newKey(t)
+	if _, err := authenticate(a, "Bearer " + idp.sign(t, "new", claims)); err != nil {
+		t.Errorf("Authenticate: Failed to authenticate with the new key: %+v", err)
+	}
+}
+
+// TestToken checks that only the static token is accepted.
+func TestToken(t *testing.T) {
+	if _, err := NewToken("", Principal{}); err != ErrInvalidConfig {
+		t.Errorf("NewToken: Expected error '%+v' but got '%+v'", ErrInvalidConfig, err)
+	}
+
+	a, err := NewToken("s3cr3t", Principal{Subject: "operator", Admin: true})
+	if err != nil {
+		t.Fatalf("NewToken: Failed to create the authenticator: %+v", err)
+	}
+
+	test_cases := []struct{
+		hdr string
+		err error
+	}{
+		{ hdr: "", err: ErrNoCredentials },
+		{ hdr: "Basic s3cr3t", err: ErrInvalidCredentials },
+		{ hdr: "Bearer s3cr3", err: ErrInvalidCredentials },
+		{ hdr: "Bearer s3cr3t", err: nil },
+		{ hdr: "bearer  s3cr3t ", err: nil },
+	}
+
+	for _, tc := range test_cases {
+		p, err := authenticate(a, tc.hdr)
+		if err != tc.err {
+			t.Errorf("(%s) Authenticate: Expected error '%+v' but got '%+v'", tc.hdr, tc.err, err)
+		} else if err == nil && (p.Subject != "operator" || !p.Admin) {
+			t.Errorf("(%s) Authenticate: Unexpected principal '%+v'", tc.hdr, p)
+		}
+	}
+}
+
+// TestSwappable checks that a Swappable uses the latest Authenticator.
+func TestSwappable(t *testing.T) {
+	old, err := NewToken("old", Principal{Subject: "operator"})
+	if err != nil {
+		t.Fatalf("NewToken: Failed to create the authenticator: %+v", err)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cr3t"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Failed to hash the password: %+v", err)
+	}
+	basic, err := NewBasic(map[string]BasicUser{"alice": {PasswordHash: string(hash)}})
+	if err != nil {
+		t.Fatalf("NewBasic: Failed to create the authenticator: %+v", err)
+	}
+
+	s := NewSwappable(old)
+	if _, err := authenticate(s, "Bearer old"); err != nil {
+		t.Errorf("Authenticate: Failed to authenticate with the initial authenticator: %+v", err)
+	} else if got := s.Challenge(); got != "" {
+		t.Errorf("Challenge: Expected '' but got '%s'", got)
+	}
+
+	s.Swap(basic)
+	if _, err := authenticate(s, "Bearer old"); err != ErrInvalidCredentials {
+		t.Errorf("Authenticate: Expected error '%+v' but got '%+v'", ErrInvalidCredentials, err)
+	}
+	if _, err := authenticate(s, "Basic YWxpY2U6czNjcjN0"); err != nil {
+		t.Errorf("Authenticate: Failed to authenticate with the swapped authenticator: %+v", err)
+	} else if got, want := s.Challenge(), basic.(Challenger).Challenge(); got != want {
+		t.Errorf("Challenge: Expected '%s' but got '%s'", want, got)
+	}
+}
+
+// TestBasic checks that only known users with their password are accepted.
+func TestBasic(t *testing.T) {
+	if _, err := NewBasic(nil); err != ErrInvalidConfig {
+		t.Errorf("NewBasic: Expected error '%+v' but got '%+v'", ErrInvalidConfig, err)
+	}
+	if _, err := NewBasic(map[string]BasicUser{"alice": {PasswordHash: "plain"}}); err != ErrInvalidConfig {
+		t.Errorf("NewBasic: Expected error '%+v' but got '%+v'", ErrInvalidConfig, err)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cr3t"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Failed to hash the password: %+v", err)
+	}
+	a, err := NewBasic(map[string]BasicUser{
+		"alice": {PasswordHash: string(hash), Admin: true},
+		"bob": {PasswordHash: string(hash)},
+	})
+	if err != nil {
+		t.Fatalf("NewBasic: Failed to create the authenticator: %+v", err)
+	}
+
+	basic := func(username, password string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	}
+	test_cases := []struct{
+		hdr string
+		err error
+		admin bool
+	}{
+		{ hdr: "", err: ErrNoCredentials },
+		{ hdr: "Bearer s3cr3t", err: ErrInvalidCredentials },
+		{ hdr: basic("alice", "s3cr3"), err: ErrInvalidCredentials },
+		{ hdr: basic("carol", "s3cr3t"), err: ErrInvalidCredentials },
+		{ hdr: basic("alice", "s3cr3t"), err: nil, admin: true },
+		// The same password again, now verified from the cache.
+		{ hdr: basic("alice", "s3cr3t"), err: nil, admin: true },
+		{ hdr: basic("alice", "s3cr3"), err: ErrInvalidCredentials },
+		{ hdr: basic("bob", "s3cr3t"), err: nil },
+	}
+
+	for _, tc := range test_cases {
+		p, err := authenticate(a, tc.hdr)
+		if err != tc.err {
+			t.Errorf("(%s) Authenticate: Expected error '%+v' but got '%+v'", tc.hdr, tc.err, err)
+		} else if err == nil && (!p.CanPost("anything") || p.Admin != tc.admin) {
+			t.Errorf("(%s) Authenticate: Unexpected principal '%+v'", tc.hdr, p)
+		}
+	}
+
+	if _, ok := a.(Challenger); !ok {
+		t.Errorf("Expected the authenticator to challenge clients")
+	}
+}
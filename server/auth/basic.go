@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"sync"
+)
+
+// BasicUser is a client authenticated through HTTP Basic authentication.
+type BasicUser struct {
+	// The bcrypt hash of the user's password (e.g., as generated by
+	// "htpasswd -nB").
+	PasswordHash string
+
+	// Whether the user may use administrative endpoints.
+	Admin bool
+}
+
+// basicAuthenticator accepts the username and password of a few known
+// users.
+type basicAuthenticator struct {
+	// The known users, by their username.
+	users map[string]BasicUser
+
+	// Compared against passwords of unknown users, so they take as long
+	// to be rejected as known users.
+	dummyHash []byte
+
+	// Synchronizes access to verified.
+	mutex sync.Mutex
+
+	// SHA-256 of the latest password verified for each user. As bcrypt
+	// is slow on purpose, this avoids verifying the same password on
+	// every request.
+	verified map[string][sha256.Size]byte
+}
+
+// NewBasic creates an Authenticator that accepts the username and password
+// of users, sent through HTTP Basic authentication. Users may post to any
+// channel. This is meant for small deployments, instead of tokens issued
+// by an identity provider.
+func NewBasic(users map[string]BasicUser) (Authenticator, error) {
+	if len(users) == 0 {
+		return nil, ErrInvalidConfig
+	}
+
+	cost := bcrypt.MinCost
+	for _, u := range users {
+		c, err := bcrypt.Cost([]byte(u.PasswordHash))
+		if err != nil {
+			return nil, ErrInvalidConfig
+		} else if c > cost {
+			cost = c
+		}
+	}
+
+	dummyHash, err := bcrypt.GenerateFromPassword([]byte("dummy"), cost)
+	if err != nil {
+		return nil, err
+	}
+
+	return &basicAuthenticator{
+		users: users,
+		dummyHash: dummyHash,
+		verified: make(map[string][sha256.Size]byte),
+	}, nil
+}
+
+func (a *basicAuthenticator) Authenticate(req *http.Request) (*Principal, error) {
+	if len(req.Header.Get("Authorization")) == 0 {
+		return nil, ErrNoCredentials
+	}
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	u, found := a.users[username]
+	if !found {
+		bcrypt.CompareHashAndPassword(a.dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+
+	sum := sha256.Sum256([]byte(password))
+	a.mutex.Lock()
+	cached, isCached := a.verified[username]
+	a.mutex.Unlock()
+
+	if !isCached || subtle.ConstantTimeCompare(sum[:], cached[:]) != 1 {
+		err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password))
+		if err != nil {
+			return nil, ErrInvalidCredentials
+		}
+
+		a.mutex.Lock()
+		a.verified[username] = sum
+		a.mutex.Unlock()
+	}
+
+	return &Principal{
+		Subject: username,
+		AllChannels: true,
+		Admin: u.Admin,
+	}, nil
+}
+
+// Challenge asks clients for their username and password.
+func (a *basicAuthenticator) Challenge() string {
+	return `Basic realm="sqs-issue-notifier", charset="UTF-8"`
+}
@@ -0,0 +1,29 @@
+package auth
+
+type error_code uint
+
+const (
+	// The request doesn't carry any credentials.
+	ErrNoCredentials error_code = iota
+	// The request's credentials are invalid.
+	ErrInvalidCredentials
+	// The authenticator's configuration is invalid.
+	ErrInvalidConfig
+	// Failed to retrieve the keys used to verify credentials.
+	ErrKeysUnavailable
+)
+
+func (e error_code) Error() string {
+	switch e {
+	case ErrNoCredentials:
+		return "The request doesn't carry any credentials."
+	case ErrInvalidCredentials:
+		return "The request's credentials are invalid."
+	case ErrInvalidConfig:
+		return "The authenticator's configuration is invalid."
+	case ErrKeysUnavailable:
+		return "Failed to retrieve the keys used to verify credentials."
+	default:
+		return "Invalid auth error."
+	}
+}
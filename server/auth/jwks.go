@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// jwk is a single key, as published in a JWKS (RFC 7517). Only the fields
+// used to verify signatures are decoded.
+type jwk struct {
+	// The key's type ("RSA" or "EC").
+	Kty string `json:"kty"`
+
+	// Identifies the key.
+	Kid string `json:"kid"`
+
+	// What the key is used for. Only signing keys ("sig") are used.
+	Use string `json:"use"`
+
+	// RSA modulus and exponent.
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// EC curve and coordinates.
+	Crv string `json:"crv"`
+	X string `json:"x"`
+	Y string `json:"y"`
+}
+
+// publicKey decodes the public key described by k.
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		} else if !e.IsInt64() {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve '%s'", k.Crv)
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		} else if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("the point isn't on the curve")
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type '%s'", k.Kty)
+	}
+}
+
+// decodeBigInt decodes a base64url encoded, big endian, unsigned integer.
+func decodeBigInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	} else if len(data) == 0 {
+		return nil, fmt.Errorf("empty integer")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// jwks caches the keys published by a JWKS endpoint. The keys are fetched
+// again once they expire, or whenever an unknown key is requested (at most
+// once every minRefresh), so rotated keys are picked up.
+type jwks struct {
+	// The JWKS endpoint.
+	url string
+
+	// Client used to fetch the keys.
+	client *http.Client
+
+	// For how long the keys are cached.
+	ttl time.Duration
+
+	// Minimum interval between fetching the keys.
+	minRefresh time.Duration
+
+	// Synchronizes access to the fields below.
+	mutex sync.Mutex
+
+	// The cached keys, by their ID.
+	keys map[string]interface{}
+
+	// When the keys were last fetched (successfully or not).
+	fetchedAt time.Time
+
+	// When the keys were last fetched successfully.
+	updatedAt time.Time
+}
+
+// key retrieves the key identified by kid, fetching the keys if needed.
+func (k *jwks) key(kid string) (interface{}, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	key, ok := k.keys[kid]
+	expired := time.Since(k.updatedAt) >= k.ttl
+	if (!ok || expired) && time.Since(k.fetchedAt) >= k.minRefresh {
+		k.fetchedAt = time.Now()
+
+		keys, err := k.fetch()
+		if err != nil {
+			// Keep using the previous keys, as the endpoint may
+			// be only temporarily unavailable.
+			logger().Error("auth/jwks: Failed to fetch the keys", "url", k.url, "err", err)
+		} else {
+			k.keys = keys
+			k.updatedAt = k.fetchedAt
+		}
+		key, ok = k.keys[kid]
+	}
+
+	if !ok && k.keys == nil {
+		return nil, ErrKeysUnavailable
+	} else if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	return key, nil
+}
+
+// fetch the keys from the JWKS endpoint.
+func (k *jwks) fetch() (map[string]interface{}, error) {
+	resp, err := k.client.Get(k.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status '%s'", resp.Status)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&set)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]interface{})
+	for _, raw := range set.Keys {
+		if len(raw.Use) > 0 && raw.Use != "sig" {
+			continue
+		}
+
+		key, err := raw.publicKey()
+		if err != nil {
+			logger().Warn("auth/jwks: Ignoring the key", "kid", raw.Kid, "err", err)
+			continue
+		}
+		keys[raw.Kid] = key
+	}
+
+	return keys, nil
+}
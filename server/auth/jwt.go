@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// JWTOptions configures how JWTs are verified.
+type JWTOptions struct {
+	// URL of the JWKS endpoint publishing the keys that sign the tokens.
+	JWKSURL string
+
+	// Required issuer ("iss" claim) of the tokens. Leave empty to accept
+	// any issuer.
+	Issuer string
+
+	// Required audience ("aud" claim) of the tokens. Leave empty to accept
+	// any audience.
+	Audience string
+
+	// Claim listing the channels to which the token's subject may post,
+	// either as an array of strings or as a space separated string. The
+	// channel "*" allows posting to any channel. Leave empty to allow
+	// every token to post to any channel.
+	ChannelsClaim string
+
+	// Claim that, when set to true, grants the token's subject
+	// administrative access. Leave empty to never grant it.
+	AdminClaim string
+
+	// For how long the keys fetched from the JWKS endpoint are cached.
+	// Defaults to 1 hour.
+	CacheTTL time.Duration
+
+	// Timeout for fetching the keys. Defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+// jwtAuthenticator implements Authenticator for JWT bearer tokens.
+type jwtAuthenticator struct {
+	// The authenticator's options.
+	opts JWTOptions
+
+	// The keys that sign the tokens.
+	keys *jwks
+
+	// Parses and verifies the tokens.
+	parser *jwt.Parser
+}
+
+// Default values for JWTOptions.
+const (
+	defaultJWKSCacheTTL = time.Hour
+	defaultJWKSTimeout = 10 * time.Second
+	// Minimum interval between fetching the keys after seeing an unknown
+	// key.
+	jwksMinRefresh = time.Minute
+)
+
+// NewJWT creates an Authenticator that accepts requests with a valid JWT in
+// their "Authorization: Bearer" header. Tokens must be signed (with either
+// RSA or ECDSA) by one of the keys published in the JWKS endpoint.
+//
+// The keys are only fetched when the first token is verified, so the
+// identity provider doesn't have to be reachable on start up.
+func NewJWT(opts JWTOptions) (Authenticator, error) {
+	if len(opts.JWKSURL) == 0 {
+		return nil, fmt.Errorf("auth/NewJWT: No JWKS URL was specified: %w", ErrInvalidConfig)
+	}
+	if opts.CacheTTL <= 0 {
+		opts.CacheTTL = defaultJWKSCacheTTL
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultJWKSTimeout
+	}
+
+	a := &jwtAuthenticator{
+		opts: opts,
+		keys: &jwks{
+			url: opts.JWKSURL,
+			client: &http.Client{Timeout: opts.Timeout},
+			ttl: opts.CacheTTL,
+			minRefresh: jwksMinRefresh,
+		},
+		parser: jwt.NewParser(jwt.WithValidMethods([]string{
+			"RS256", "RS384", "RS512",
+			"PS256", "PS384", "PS512",
+			"ES256", "ES384", "ES512",
+		})),
+	}
+	if opts.CacheTTL < a.keys.minRefresh {
+		a.keys.minRefresh = opts.CacheTTL
+	}
+
+	return a, nil
+}
+
+// keyFunc retrieves the key that signed token.
+func (a *jwtAuthenticator) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	return a.keys.key(kid)
+}
+
+func (a *jwtAuthenticator) Authenticate(req *http.Request) (*Principal, error) {
+	token, err := bearerToken(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims jwt.MapClaims
+	_, err = a.parser.ParseWithClaims(token, &claims, a.keyFunc)
+	if err != nil {
+		logger().Debug("auth/Authenticate: Invalid token", "err", err)
+
+		if errors.Is(err, ErrKeysUnavailable) {
+			return nil, ErrKeysUnavailable
+		}
+		return nil, ErrInvalidCredentials
+	}
+
+	// The parser only checks the expiration if it's set, so tokens that
+	// never expire must be rejected explicitly.
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		logger().Debug("auth/Authenticate: Missing expiration", "exp", claims["exp"])
+		return nil, ErrInvalidCredentials
+	}
+	if len(a.opts.Issuer) > 0 && !claims.VerifyIssuer(a.opts.Issuer, true) {
+		logger().Debug("auth/Authenticate: Invalid issuer", "iss", claims["iss"])
+		return nil, ErrInvalidCredentials
+	}
+	if len(a.opts.Audience) > 0 && !claims.VerifyAudience(a.opts.Audience, true) {
+		logger().Debug("auth/Authenticate: Invalid audience", "aud", claims["aud"])
+		return nil, ErrInvalidCredentials
+	}
+
+	p := &Principal{}
+	p.Subject, _ = claims["sub"].(string)
+	if len(a.opts.AdminClaim) > 0 {
+		p.Admin, _ = claims[a.opts.AdminClaim].(bool)
+	}
+	if len(a.opts.ChannelsClaim) == 0 {
+		p.AllChannels = true
+		return p, nil
+	}
+
+	switch channels := claims[a.opts.ChannelsClaim].(type) {
+	case string:
+		p.Channels = strings.Fields(channels)
+	case []interface{}:
+		for _, c := range channels {
+			if s, ok := c.(string); ok {
+				p.Channels = append(p.Channels, s)
+			}
+		}
+	}
+	for _, c := range p.Channels {
+		if c == "*" {
+			p.AllChannels = true
+		}
+	}
+
+	return p, nil
+}
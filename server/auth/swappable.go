@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// Swappable is an Authenticator that delegates to another Authenticator,
+// which may be replaced at any time (e.g., to rotate credentials when the
+// server's configuration is reloaded), without disturbing requests being
+// authenticated.
+type Swappable struct {
+	// The current Authenticator.
+	cur atomic.Pointer[swapped]
+}
+
+// swapped wraps an Authenticator, so it may be stored in an atomic.Pointer.
+type swapped struct {
+	Authenticator
+}
+
+// NewSwappable creates a Swappable that delegates to a, until it's swapped.
+func NewSwappable(a Authenticator) *Swappable {
+	var s Swappable
+	s.Swap(a)
+	return &s
+}
+
+// Swap replaces the Authenticator used by s with a. Requests already being
+// authenticated finish with the previous one.
+func (s *Swappable) Swap(a Authenticator) {
+	s.cur.Store(&swapped{a})
+}
+
+func (s *Swappable) Authenticate(req *http.Request) (*Principal, error) {
+	return s.cur.Load().Authenticate(req)
+}
+
+// Challenge retrieves the challenge of the current Authenticator, if it's a
+// Challenger, or an empty string otherwise.
+func (s *Swappable) Challenge() string {
+	if c, ok := s.cur.Load().Authenticator.(Challenger); ok {
+		return c.Challenge()
+	}
+	return ""
+}
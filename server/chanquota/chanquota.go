@@ -0,0 +1,273 @@
+/*
+Package chanquota limits the messages accepted for each channel, so a single
+noisy channel can't starve the others of space in the local storage.
+
+Each channel may be limited both on how many of its messages may be pending
+at once and on how many messages it may receive per minute. Once a channel
+has too many pending messages, new messages are either rejected or accepted
+in place of the channel's oldest pending messages, depending on the
+channel's Policy. Messages received too fast are always rejected.
+
+The Quotas must be told whenever a message is stored or removed, so they
+keep track of each channel's pending messages.
+
+Example:
+
+	q := chanquota.New(chanquota.Limits{MaxPending: 100}, nil)
+
+	drop, retryAfter, err := q.Admit("general")
+	if err != nil {
+		// reply with 429, asking the client to retry after retryAfter
+	}
+	for _, id := range drop {
+		// remove the message identified by id
+	}
+
+	// store the message, then:
+	q.Add("general", id)
+*/
+package chanquota
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang.org/x/time/rate"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Policy defines what happens to messages sent to a channel that has as
+// many pending messages as it may have.
+type Policy int
+
+const (
+	// The new message is rejected.
+	PolicyReject Policy = iota
+	// The channel's oldest pending messages are dropped, making room for
+	// the new message.
+	PolicyDropOldest
+)
+
+func (p Policy) String() string {
+	switch p {
+	case PolicyReject:
+		return "reject"
+	case PolicyDropOldest:
+		return "drop-oldest"
+	default:
+		return "invalid"
+	}
+}
+
+// MarshalText encodes the policy as its name (e.g., "drop-oldest").
+func (p Policy) MarshalText() ([]byte, error) {
+	if p != PolicyReject && p != PolicyDropOldest {
+		return nil, ErrInvalidPolicy
+	}
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText decodes a policy from its name (e.g., "drop-oldest").
+func (p *Policy) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "reject":
+		*p = PolicyReject
+	case "drop-oldest":
+		*p = PolicyDropOldest
+	default:
+		return ErrInvalidPolicy
+	}
+	return nil
+}
+
+// Limits configures the quota of a channel.
+type Limits struct {
+	// How many of the channel's messages may be pending at once. If 0,
+	// it's unlimited.
+	MaxPending int
+
+	// How many messages the channel may receive per minute. If 0, it's
+	// unlimited.
+	MaxPerMinute int
+
+	// What happens to new messages once MaxPending is reached.
+	Policy Policy
+}
+
+// Quotas limits the messages accepted for each channel.
+type Quotas struct {
+	// Synchronizes access to the fields below.
+	mutex sync.Mutex
+
+	// Limits of channels that aren't configured explicitly.
+	defaults Limits
+
+	// Limits of each configured channel.
+	channels map[string]Limits
+
+	// IDs of each channel's pending messages, sorted. As the local
+	// storage's IDs start with when the message was stored, they're
+	// sorted oldest first.
+	pending map[string][]string
+
+	// Channel of each pending message, by its ID.
+	channelOf map[string]string
+
+	// The rate limiter of each channel limited by MaxPerMinute.
+	limiters map[string]*rate.Limiter
+}
+
+// New creates Quotas that limit each channel by its entry in channels, or
+// by defaults if it doesn't have one.
+func New(defaults Limits, channels map[string]Limits) *Quotas {
+	return &Quotas{
+		defaults: defaults,
+		channels: channels,
+		pending: make(map[string][]string),
+		channelOf: make(map[string]string),
+		limiters: make(map[string]*rate.Limiter),
+	}
+}
+
+// Load the limits of each channel from the JSON file at path, which maps
+// each channel to its Limits (e.g., {"general": {"MaxPending": 100}}).
+// Limits omitted from a channel are copied from defaults.
+func Load(path string, defaults Limits) (map[string]Limits, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]json.RawMessage
+	err = json.Unmarshal(data, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse '%s': %w", path, err)
+	}
+
+	channels := make(map[string]Limits, len(raw))
+	for channel, value := range raw {
+		limits := defaults
+		err = json.Unmarshal(value, &limits)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't parse the limits of '%s': %w", channel, err)
+		}
+		channels[channel] = limits
+	}
+
+	return channels, nil
+}
+
+// Limits retrieves the limits of channel.
+func (q *Quotas) Limits(channel string) Limits {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return q.limitsOf(channel)
+}
+
+// limitsOf retrieves the limits of channel. The mutex must be held.
+func (q *Quotas) limitsOf(channel string) Limits {
+	if limits, ok := q.channels[channel]; ok {
+		return limits
+	}
+	return q.defaults
+}
+
+// SetLimits replaces the limits of every channel, as in New. The pending
+// messages are kept, but the channels' rates start over.
+func (q *Quotas) SetLimits(defaults Limits, channels map[string]Limits) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.defaults = defaults
+	q.channels = channels
+	q.limiters = make(map[string]*rate.Limiter)
+}
+
+// Admit checks whether a new message may be accepted for channel.
+//
+// If the channel has too many pending messages and its policy is
+// PolicyDropOldest, drop lists the IDs of the messages that must be
+// removed to make room for the new one, in sorted order (i.e., oldest
+// first, for the local storage's IDs). Otherwise, it fails
+// with ErrPendingExceeded. If the channel received too many messages, it
+// fails with ErrRateExceeded, and retryAfter is how long the client should
+// wait before trying again.
+func (q *Quotas) Admit(channel string) (drop []string, retryAfter time.Duration, err error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	limits := q.limitsOf(channel)
+	pending := q.pending[channel]
+	if limits.MaxPending > 0 && len(pending) >= limits.MaxPending {
+		if limits.Policy != PolicyDropOldest {
+			return nil, 0, ErrPendingExceeded
+		}
+		drop = slices.Clone(pending[:len(pending) - limits.MaxPending + 1])
+	}
+
+	if limits.MaxPerMinute > 0 {
+		l, ok := q.limiters[channel]
+		if !ok {
+			perSecond := rate.Limit(float64(limits.MaxPerMinute) / 60)
+			l = rate.NewLimiter(perSecond, limits.MaxPerMinute)
+			q.limiters[channel] = l
+		}
+
+		now := time.Now()
+		r := l.ReserveN(now, 1)
+		if delay := r.DelayFrom(now); delay > 0 {
+			// Don't consume the token, as the message is refused.
+			r.CancelAt(now)
+			return nil, delay, ErrRateExceeded
+		}
+	}
+
+	return drop, 0, nil
+}
+
+// Add a pending message, identified by id, to channel.
+func (q *Quotas) Add(channel, id string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if _, ok := q.channelOf[id]; ok {
+		return
+	}
+	q.channelOf[id] = channel
+
+	pending := q.pending[channel]
+	i, _ := slices.BinarySearch(pending, id)
+	q.pending[channel] = slices.Insert(pending, i, id)
+}
+
+// Remove the message identified by id, as it's no longer pending.
+func (q *Quotas) Remove(id string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	channel, ok := q.channelOf[id]
+	if !ok {
+		return
+	}
+	delete(q.channelOf, id)
+
+	pending := slices.DeleteFunc(q.pending[channel], func(p string) bool {
+		return p == id
+	})
+	if len(pending) == 0 {
+		delete(q.pending, channel)
+	} else {
+		q.pending[channel] = pending
+	}
+}
+
+// Pending retrieves how many of channel's messages are pending.
+func (q *Quotas) Pending(channel string) int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return len(q.pending[channel])
+}
@@ -0,0 +1,138 @@
+package chanquota
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestPending checks that channels are limited by their pending messages,
+// according to their policy.
+func TestPending(t *testing.T) {
+	q := New(Limits{MaxPending: 2}, map[string]Limits{
+		"noisy": {MaxPending: 2, Policy: PolicyDropOldest},
+	})
+
+	for _, channel := range []string{"quiet", "noisy"} {
+		for _, id := range []string{"2", "1"} {
+			drop, _, err := q.Admit(channel)
+			if err != nil || len(drop) != 0 {
+				t.Errorf("%s: Admit: Expected the message to be accepted but got '%+v' (drop: %+v)", channel, err, drop)
+			}
+			q.Add(channel, channel + "-" + id)
+		}
+	}
+
+	_, _, err := q.Admit("quiet")
+	if err != ErrPendingExceeded {
+		t.Errorf("Admit: Expected error '%+v' but got '%+v'", ErrPendingExceeded, err)
+	}
+
+	drop, _, err := q.Admit("noisy")
+	if err != nil {
+		t.Errorf("Admit: Expected the message to be accepted but got '%+v'", err)
+	} else if len(drop) != 1 || drop[0] != "noisy-1" {
+		t.Errorf("Admit: Expected to drop the oldest message but got '%+v'", drop)
+	}
+
+	// Other channels aren't affected.
+	if _, _, err := q.Admit("other"); err != nil {
+		t.Errorf("Admit: Another channel was refused due to the noisy ones: %+v", err)
+	}
+
+	q.Remove("noisy-1")
+	q.Remove("noisy-1")
+	if want, got := 1, q.Pending("noisy"); want != got {
+		t.Errorf("Pending: Expected '%d' but got '%d'", want, got)
+	}
+	if want, got := 2, q.Pending("quiet"); want != got {
+		t.Errorf("Pending: Expected '%d' but got '%d'", want, got)
+	}
+}
+
+// TestRate checks that channels are limited by how many messages they
+// receive per minute, regardless of their policy.
+func TestRate(t *testing.T) {
+	q := New(Limits{MaxPerMinute: 2, Policy: PolicyDropOldest}, nil)
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := q.Admit("noisy"); err != nil {
+			t.Errorf("%d: Admit: The message was refused within the limit: %+v", i, err)
+		}
+	}
+
+	_, retryAfter, err := q.Admit("noisy")
+	if err != ErrRateExceeded {
+		t.Errorf("Admit: Expected error '%+v' but got '%+v'", ErrRateExceeded, err)
+	} else if retryAfter <= 0 || retryAfter > time.Minute {
+		t.Errorf("Admit: Expected to retry within a minute but got '%s'", retryAfter)
+	}
+
+	if _, _, err := q.Admit("quiet"); err != nil {
+		t.Errorf("Admit: Another channel was refused due to the noisy one: %+v", err)
+	}
+}
+
+// TestSetLimits checks that replaced limits apply to the pending messages,
+// and restart the channels' rates.
+func TestSetLimits(t *testing.T) {
+	q := New(Limits{MaxPerMinute: 1}, nil)
+	q.Add("general", "general-1")
+	q.Add("general", "general-2")
+
+	if _, _, err := q.Admit("general"); err != nil {
+		t.Errorf("Admit: The message was refused within the limit: %+v", err)
+	}
+	if _, _, err := q.Admit("general"); err != ErrRateExceeded {
+		t.Errorf("Admit: Expected error '%+v' but got '%+v'", ErrRateExceeded, err)
+	}
+
+	q.SetLimits(Limits{MaxPerMinute: 1}, map[string]Limits{
+		"general": {MaxPending: 2},
+	})
+	if _, _, err := q.Admit("general"); err != ErrPendingExceeded {
+		t.Errorf("Admit: Expected error '%+v' but got '%+v'", ErrPendingExceeded, err)
+	}
+	if _, _, err := q.Admit("other"); err != nil {
+		t.Errorf("Admit: The message was refused within the new limit: %+v", err)
+	}
+	if got, want := q.Limits("general").MaxPending, 2; got != want {
+		t.Errorf("Limits: Expected '%d' but got '%d'", want, got)
+	}
+}
+
+// TestLoad checks that omitted limits are copied from the defaults.
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "quotas.json")
+	data := `{"alerts": {"MaxPending": 10, "Policy": "drop-oldest"}, "chat": {"MaxPerMinute": 5}}`
+	err := os.WriteFile(path, []byte(data), 0600)
+	if err != nil {
+		t.Fatalf("Failed to write the quotas: %+v", err)
+	}
+
+	defaults := Limits{MaxPending: 100, MaxPerMinute: 60}
+	channels, err := Load(path, defaults)
+	if err != nil {
+		t.Fatalf("Load: Failed to load the quotas: %+v", err)
+	}
+
+	want := map[string]Limits{
+		"alerts": {MaxPending: 10, MaxPerMinute: 60, Policy: PolicyDropOldest},
+		"chat": {MaxPending: 100, MaxPerMinute: 5, Policy: PolicyReject},
+	}
+	for channel, limits := range want {
+		if got := channels[channel]; got != limits {
+			t.Errorf("%s: Expected '%+v' but got '%+v'", channel, limits, got)
+		}
+	}
+
+	err = os.WriteFile(path, []byte(`{"alerts": {"Policy": "drop-newest"}}`), 0600)
+	if err != nil {
+		t.Fatalf("Failed to write the quotas: %+v", err)
+	}
+	if _, err := Load(path, defaults); err == nil {
+		t.Errorf("Load: Expected an invalid policy to fail")
+	}
+}
@@ -0,0 +1,25 @@
+package chanquota
+
+type error_code uint
+
+const (
+	// The channel has as many pending messages as it may have.
+	ErrPendingExceeded error_code = iota
+	// The channel received as many messages as it may in a minute.
+	ErrRateExceeded
+	// The quota policy is invalid.
+	ErrInvalidPolicy
+)
+
+func (e error_code) Error() string {
+	switch e {
+	case ErrPendingExceeded:
+		return "The channel has as many pending messages as it may have."
+	case ErrRateExceeded:
+		return "The channel received as many messages as it may in a minute."
+	case ErrInvalidPolicy:
+		return "The quota policy is invalid."
+	default:
+		return "Invalid chanquota error."
+	}
+}
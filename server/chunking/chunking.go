@@ -0,0 +1,220 @@
+/*
+Package chunking splits messages too large for a queue into numbered
+chunks, and reassembles them on the consumer's side.
+
+Each chunk starts with a single header line, followed by a slice of the
+original message:
+
+	sqs-issue-notifier-chunk:<id>:<index>:<total>
+	<data>
+
+The id is derived from the message's contents, so sending the same message
+again (e.g., after a partial failure) generates the very same chunks.
+Messages are only ever split on UTF-8 boundaries, so every chunk is valid
+text.
+
+Example (consumer):
+
+	r := chunking.NewReassembler(time.Hour)
+
+	for body := range received {
+		msg, ok, err := r.Add(body)
+		if err != nil {
+			// handle err
+		} else if !ok {
+			// Wait for the remaining chunks.
+			continue
+		}
+
+		// handle msg
+	}
+*/
+package chunking
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// HeaderPrefix starts the header of every chunk.
+const HeaderPrefix = "sqs-issue-notifier-chunk:"
+
+// maxHeaderSize is the longest header generated by Split.
+const maxHeaderSize = len(HeaderPrefix) + 16 + 1 + 10 + 1 + 10 + 1
+
+// Header identifies a chunk.
+type Header struct {
+	// Identifies the message the chunk belongs to.
+	ID string
+
+	// The chunk's position in the message, starting at 0.
+	Index int
+
+	// Number of chunks in the message.
+	Total int
+}
+
+// Split body into chunks of at most maxSize bytes (including the header).
+// If body already fits in maxSize, it's returned as is.
+func Split(body string, maxSize int) ([]string, error) {
+	if len(body) <= maxSize {
+		return []string{body}, nil
+	}
+
+	dataSize := maxSize - maxHeaderSize
+	if dataSize < utf8.UTFMax {
+		return nil, fmt.Errorf("chunking: chunks of %d bytes are too small", maxSize)
+	}
+
+	hash := sha256.Sum256([]byte(body))
+	id := hex.EncodeToString(hash[:8])
+
+	var parts []string
+	for len(body) > 0 {
+		n := dataSize
+		if n >= len(body) {
+			n = len(body)
+		} else {
+			// Move back to the start of the last rune that fits.
+			for n > 0 && !utf8.RuneStart(body[n]) {
+				n--
+			}
+		}
+
+		parts = append(parts, body[:n])
+		body = body[n:]
+	}
+
+	chunks := make([]string, len(parts))
+	for i, part := range parts {
+		chunks[i] = fmt.Sprintf("%s%s:%d:%d\n%s", HeaderPrefix, id, i, len(parts), part)
+	}
+	return chunks, nil
+}
+
+// Parse a chunk, returning its header and its data. ok is false if body
+// isn't a chunk.
+func Parse(body string) (hdr Header, data string, ok bool) {
+	if !strings.HasPrefix(body, HeaderPrefix) {
+		return hdr, "", false
+	}
+
+	end := strings.IndexByte(body, '\n')
+	if end == -1 {
+		return hdr, "", false
+	}
+
+	fields := strings.Split(body[len(HeaderPrefix):end], ":")
+	if len(fields) != 3 || len(fields[0]) == 0 {
+		return hdr, "", false
+	}
+
+	index, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return hdr, "", false
+	}
+	total, err := strconv.Atoi(fields[2])
+	if err != nil || index < 0 || total < 1 || index >= total {
+		return hdr, "", false
+	}
+
+	hdr = Header{
+		ID: fields[0],
+		Index: index,
+		Total: total,
+	}
+	return hdr, body[end+1:], true
+}
+
+// partial is a message still being reassembled.
+type partial struct {
+	// Received chunks, indexed by their position.
+	chunks []string
+
+	// Number of distinct chunks received.
+	received int
+
+	// When the first chunk was received.
+	started time.Time
+}
+
+// Reassembler joins chunks back into their original messages. It's safe
+// for concurrent use.
+type Reassembler struct {
+	// Synchronizes access to pending.
+	mutex sync.Mutex
+
+	// Messages still missing chunks, by ID.
+	pending map[string]*partial
+
+	// For how long incomplete messages are kept.
+	maxAge time.Duration
+}
+
+// NewReassembler creates a Reassembler that drops incomplete messages
+// after maxAge. Set maxAge to 0 to keep them forever.
+func NewReassembler(maxAge time.Duration) *Reassembler {
+	return &Reassembler{
+		pending: make(map[string]*partial),
+		maxAge: maxAge,
+	}
+}
+
+// Add a received body. If body completes a message (or if it isn't a chunk
+// at all), the whole message is returned and ok is true. Duplicated chunks
+// are ignored.
+func (r *Reassembler) Add(body string) (msg string, ok bool, err error) {
+	hdr, data, isChunk := Parse(body)
+	if !isChunk {
+		return body, true, nil
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	if r.maxAge > 0 {
+		for id, p := range r.pending {
+			if now.Sub(p.started) > r.maxAge {
+				delete(r.pending, id)
+			}
+		}
+	}
+
+	p, found := r.pending[hdr.ID]
+	if !found {
+		p = &partial{
+			chunks: make([]string, hdr.Total),
+			started: now,
+		}
+		r.pending[hdr.ID] = p
+	} else if len(p.chunks) != hdr.Total {
+		return "", false, fmt.Errorf("chunking: chunk %d of '%s' expected %d chunks, but got %d",
+				hdr.Index, hdr.ID, len(p.chunks), hdr.Total)
+	}
+
+	if len(p.chunks[hdr.Index]) == 0 && len(data) > 0 {
+		p.chunks[hdr.Index] = data
+		p.received++
+	}
+	if p.received < hdr.Total {
+		return "", false, nil
+	}
+
+	delete(r.pending, hdr.ID)
+	return strings.Join(p.chunks, ""), true, nil
+}
+
+// Pending retrieves the number of incomplete messages.
+func (r *Reassembler) Pending() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return len(r.pending)
+}
@@ -0,0 +1,80 @@
+package chunking
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// TestSplitReassemble splits messages and reassembles them, delivering
+// chunks out of order and duplicated.
+func TestSplitReassemble(t *testing.T) {
+	const maxSize = 128
+
+	test_cases := []string{
+		"short message",
+		strings.Repeat("The quick brown fox jumps over the lazy old dog. ", 20),
+		strings.Repeat("Ação, coração, pão e feijão! 日本語のテキスト ", 20),
+	}
+
+	r := NewReassembler(time.Hour)
+	for i, msg := range test_cases {
+		chunks, err := Split(msg, maxSize)
+		if err != nil {
+			t.Fatalf("%d: Split: Failed to split the message: %+v", i, err)
+		}
+
+		for j, chunk := range chunks {
+			if len(chunk) > maxSize {
+				t.Errorf("%d-%d: Split: Chunk has %d bytes, more than %d", i, j, len(chunk), maxSize)
+			} else if !utf8.ValidString(chunk) {
+				t.Errorf("%d-%d: Split: Chunk isn't valid UTF-8", i, j)
+			}
+		}
+
+		// Deliver every chunk but the first, then duplicate the last
+		// one and finally deliver the first.
+		order := append(chunks[1:], chunks[len(chunks) - 1], chunks[0])
+		if len(chunks) == 1 {
+			order = chunks
+		}
+
+		var got string
+		for j, chunk := range order {
+			out, ok, err := r.Add(chunk)
+			if err != nil {
+				t.Errorf("%d-%d: Add: Failed to add the chunk: %+v", i, j, err)
+			} else if ok && j != len(order) - 1 {
+				t.Errorf("%d-%d: Add: Message completed too early", i, j)
+			} else if ok {
+				got = out
+			}
+		}
+
+		if want := msg; want != got {
+			t.Errorf("%d: Reassembled message doesn't match! Want '%s' but got '%s'", i, want, got)
+		}
+	}
+
+	if want, got := 0, r.Pending(); want != got {
+		t.Errorf("Pending: Expected '%d' messages but got '%d'", want, got)
+	}
+}
+
+// TestParse checks that malformed headers aren't treated as chunks.
+func TestParse(t *testing.T) {
+	test_cases := []string{
+		"not a chunk",
+		HeaderPrefix + "id:0:1",
+		HeaderPrefix + "id:1:1\ndata",
+		HeaderPrefix + "id:a:1\ndata",
+		HeaderPrefix + ":0:1\ndata",
+	}
+
+	for i, tc := range test_cases {
+		if _, _, ok := Parse(tc); ok {
+			t.Errorf("%d: Parse: '%s' shouldn't be a valid chunk", i, tc)
+		}
+	}
+}
@@ -0,0 +1,730 @@
+/*
+Package client implements a client for the notifier's HTTP API.
+
+Messages are posted to the server by "Client.Notify()" (or, in bulk, by
+"Client.NotifyBatch()"). Requests that fail because the server couldn't be
+reached, is overloaded (429) or failed (5xx) are retried, backing off
+exponentially between attempts. Every message is identified by a random
+request ID, sent as both its X-Request-Id and its Idempotency-Key, so
+retries never store a message twice.
+
+If the server itself is down (i.e., it's unreachable, or a proxy in front
+of it replies with 502, 503 or 504), messages may be spooled to a local
+directory (through local_storage), instead of being lost, by creating the
+client with "WithSpool()". Spooled messages are sent in the background as
+soon as the server is back, backing off while it's down, just like the
+server forwards its own local storage. Since the spool is kept on disk,
+messages spooled before the program exits are sent once a client is
+created again for the same directory. Spooled messages may also be sent
+right away by "Client.Flush()" (which is the only way they're sent if the
+client is created with "WithManualFlush()"). Since messages posted while
+the spool is being flushed aren't held back, the server may receive them
+before the spooled ones.
+
+Failures are reported as an *Error, which matches its error code through
+"errors.Is()" (e.g., ErrRejected) while wrapping its cause.
+
+Example:
+
+	c, err := client.New("http://localhost:8888",
+			client.WithToken("some-token"),
+			client.WithSpool("some-dir"))
+	if err != nil {
+		// handle err
+	}
+	defer c.Close()
+
+	res, err := c.Notify(ctx, "general", "Deploy finished")
+	if err != nil {
+		// handle err
+	} else if res.Spooled {
+		// the message will be sent once the server is back
+	}
+*/
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/SirGFM/sqs-issue-notifier/server/local_storage"
+	"github.com/SirGFM/sqs-issue-notifier/server/loglevel"
+	"io"
+	"log/slog"
+	mrand "math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxResponseSize limits how much of a response is read.
+const maxResponseSize = 4 << 20
+
+// logger retrieves the logger for the client's entries, identified as the
+// "client" component.
+func logger() *slog.Logger {
+	return slog.Default().With(loglevel.ComponentKey, "client")
+}
+
+// Priority defines the order in which the server sends pending messages:
+// messages of a higher priority are sent first.
+type Priority string
+
+const (
+	// Sent after every other message.
+	PriorityLow Priority = "low"
+	// The default priority.
+	PriorityNormal Priority = "normal"
+	// Sent before every other message.
+	PriorityHigh Priority = "high"
+)
+
+// storePriority converts p into the local storage's priority.
+func (p Priority) storePriority() local_storage.Priority {
+	switch p {
+	case PriorityLow:
+		return local_storage.PriorityLow
+	case PriorityHigh:
+		return local_storage.PriorityHigh
+	default:
+		return local_storage.PriorityNormal
+	}
+}
+
+// Message is a message posted to the server.
+type Message struct {
+	// The channel that should receive the message.
+	Channel string `json:"channel"`
+
+	// The message itself.
+	Message string `json:"message"`
+
+	// Requested delivery delay, in seconds.
+	DelaySeconds int64 `json:"delaySeconds,omitempty"`
+
+	// Priority of the message. Defaults to PriorityNormal.
+	Priority Priority `json:"priority,omitempty"`
+
+	// Identifies the message, so retries aren't stored twice. Generated
+	// if empty.
+	RequestID string `json:"-"`
+}
+
+// spooledMessage is the format of messages kept in the spool.
+type spooledMessage struct {
+	Message
+
+	// Identifies the message, so it's stored only once even if it's
+	// flushed more than once.
+	RequestID string
+}
+
+// Result describes a message accepted by the server (or spooled).
+type Result struct {
+	// Identifies the message in the server's local storage, or in the
+	// spool if the message was spooled.
+	ID string
+
+	// Whether an identical message was already stored, in which case it
+	// wasn't stored again.
+	Duplicate bool
+
+	// How many pending messages will be sent before this one.
+	Position int
+
+	// Whether the server was down, so the message was spooled to be sent
+	// later.
+	Spooled bool
+
+	// Identifies the message, as sent in its X-Request-Id.
+	RequestID string
+}
+
+// Entry is a message waiting to be sent by the server.
+type Entry struct {
+	// Identifies the message in the server's local storage.
+	ID string
+
+	// When the message was received.
+	StoredAt time.Time
+
+	// Whether the message is currently being sent.
+	InFlight bool
+
+	// The message, as stored.
+	Body string
+}
+
+// RetryPolicy defines how failed requests are retried.
+type RetryPolicy struct {
+	// How many times a request is attempted, including the first
+	// attempt. Requests are never retried if this is 1 or less.
+	Attempts int
+
+	// Delay after the first failure, doubled after each consecutive
+	// failure.
+	BaseDelay time.Duration
+
+	// Upper bound of the delay.
+	MaxDelay time.Duration
+
+	// Fraction (between 0 and 1) of the delay that's randomly removed, so
+	// many clients don't retry in lockstep.
+	Jitter float64
+}
+
+// DefaultRetryPolicy is used by clients created without WithRetry().
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts: 4,
+	BaseDelay: 500 * time.Millisecond,
+	MaxDelay: 10 * time.Second,
+	Jitter: 0.2,
+}
+
+// delay retrieves for how long to wait after the given number of
+// consecutive failures.
+func (p RetryPolicy) delay(failures int) time.Duration {
+	if p.BaseDelay <= 0 || failures <= 0 {
+		return 0
+	}
+
+	d := p.BaseDelay
+	for i := 1; i < failures && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		d -= time.Duration(mrand.Float64() * min(p.Jitter, 1) * float64(d))
+	}
+
+	return d
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithToken authenticates every request with token, as a bearer token.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithHTTPClient sends the requests through hc, instead of
+// http.DefaultClient.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithRetry retries failed requests as defined by p, instead of
+// DefaultRetryPolicy.
+func WithRetry(p RetryPolicy) Option {
+	return func(c *Client) {
+		c.retry = p
+	}
+}
+
+// WithSpool spools messages to dir whenever the server is down, so they're
+// sent once it's back. Each directory must only be used by a single client
+// at a time.
+func WithSpool(dir string) Option {
+	return func(c *Client) {
+		c.spoolDir = dir
+	}
+}
+
+// WithManualFlush only sends spooled messages when Client.Flush() is
+// called, instead of in the background.
+func WithManualFlush() Option {
+	return func(c *Client) {
+		c.manualFlush = true
+	}
+}
+
+// Client posts messages to the notifier's HTTP API. It's safe to use from
+// many goroutines at once.
+type Client struct {
+	// The server's base URL (e.g., "http://localhost:8888").
+	baseURL *url.URL
+
+	// Sends the requests.
+	httpClient *http.Client
+
+	// Bearer token sent in every request, if not empty.
+	token string
+
+	// How failed requests are retried.
+	retry RetryPolicy
+
+	// Directory where messages are spooled while the server is
+	// unreachable. Empty if spooling is disabled.
+	spoolDir string
+
+	// Messages spooled while the server was down. Nil if spooling is
+	// disabled.
+	spool local_storage.Store
+
+	// Whether spooled messages are only sent by Client.Flush().
+	manualFlush bool
+
+	// Serializes Client.Flush(), so each spooled message is sent once.
+	flushMutex sync.Mutex
+
+	// Consecutive failures to flush the spool in the background.
+	flushFailures atomic.Int32
+
+	// Cancels the background flush's requests once the client is closed.
+	cancelFlush context.CancelFunc
+
+	// Closed to stop flushing the spool in the background.
+	stop chan struct{}
+
+	// Closed once the background flush stops.
+	flushDone chan struct{}
+
+	// Ensures that the client is only closed once.
+	closeOnce sync.Once
+}
+
+// New creates a client for the server at baseURL (e.g.,
+// "http://localhost:8888"), configured by opts.
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, wrap("New", ErrInvalidURL, err)
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, wrap("New", ErrInvalidURL, errors.New("the URL's scheme must be either http or https"))
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+
+	c := &Client{
+		baseURL: u,
+		httpClient: http.DefaultClient,
+		retry: DefaultRetryPolicy,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if len(c.spoolDir) > 0 {
+		err = os.MkdirAll(c.spoolDir, 0755)
+		if err != nil {
+			return nil, wrap("New", ErrSpoolFailed, err)
+		}
+		c.spool = local_storage.NewFS(c.spoolDir, 0)
+
+		if !c.manualFlush {
+			c.startFlush()
+		}
+	}
+
+	return c, nil
+}
+
+// startFlush launches a goroutine that flushes the spool whenever
+// something is spooled (or, on start, if it already has messages), backing
+// off as defined by the client's RetryPolicy while the server is down.
+func (c *Client) startFlush() {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancelFlush = cancel
+	c.stop = make(chan struct{})
+	c.flushDone = make(chan struct{})
+
+	go func() {
+		defer close(c.flushDone)
+
+		for {
+			err := c.spool.Wait()
+			if errors.Is(err, local_storage.ErrStoreClosed) {
+				return
+			}
+
+			sent, err := c.Flush(ctx)
+			if err == nil && (sent > 0 || c.spool.Count() == 0) {
+				c.flushFailures.Store(0)
+				continue
+			} else if err != nil {
+				logger().Warn("client/Flush: Couldn't flush the spool", "sent", sent, "spooled", c.spool.Count(), "err", err)
+			}
+
+			// Either the server is still down, or the spooled
+			// messages couldn't be retrieved (e.g., they're locked).
+			// Either way, wait before trying again.
+			delay := c.retry.delay(int(c.flushFailures.Add(1)))
+			if delay <= 0 {
+				delay = time.Second
+			}
+			timer := time.NewTimer(delay)
+			select {
+			case <-c.stop:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+	} ()
+}
+
+// request is a request sent to the server.
+type request struct {
+	// The request's method.
+	method string
+
+	// The requested resource, relative to the base URL (e.g., "message").
+	resource string
+
+	// The request's query parameters, if any.
+	query url.Values
+
+	// The request's JSON body, if any.
+	body []byte
+
+	// Identifies the request, if set.
+	requestID string
+
+	// Status codes, other than 200 and 201, considered successful.
+	accept []int
+}
+
+// do sends r, retrying it as defined by the client's RetryPolicy, and
+// decodes the response's JSON body into out (if not nil).
+func (c *Client) do(ctx context.Context, op string, r request, out any) error {
+	for failures := 1; ; failures++ {
+		retryAfter, err := c.try(ctx, op, r, out)
+		if err == nil || !retryable(err) || failures >= c.retry.Attempts {
+			return err
+		}
+
+		timer := time.NewTimer(max(c.retry.delay(failures), retryAfter))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// try sends r once, decoding the response's JSON body into out (if not
+// nil). If the server asked to retry later, it also returns for how long
+// to wait.
+func (c *Client) try(ctx context.Context, op string, r request, out any) (time.Duration, error) {
+	u := c.baseURL.JoinPath(r.resource)
+	u.RawQuery = r.query.Encode()
+
+	var body io.Reader
+	if r.body != nil {
+		body = bytes.NewReader(r.body)
+	}
+	req, err := http.NewRequestWithContext(ctx, r.method, u.String(), body)
+	if err != nil {
+		return 0, wrap(op, ErrInvalidURL, err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if r.body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if len(c.token) > 0 {
+		req.Header.Set("Authorization", "Bearer " + c.token)
+	}
+	if len(r.requestID) > 0 {
+		req.Header.Set("X-Request-Id", r.requestID)
+		req.Header.Set("Idempotency-Key", r.requestID)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, wrap(op, ErrUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return 0, wrap(op, ErrUnreachable, err)
+	}
+
+	ok := resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated
+	for _, status := range r.accept {
+		ok = ok || resp.StatusCode == status
+	}
+	if !ok {
+		code := ErrRejected
+		if resp.StatusCode >= 500 {
+			code = ErrServer
+		}
+
+		var retryAfter time.Duration
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+			retryAfter = time.Duration(secs) * time.Second
+		}
+
+		return retryAfter, &Error{
+			Op: op,
+			Code: code,
+			Status: resp.StatusCode,
+			Message: strings.TrimSpace(string(data)),
+		}
+	}
+
+	if out != nil {
+		err = json.Unmarshal(data, out)
+		if err != nil {
+			return 0, wrap(op, ErrInvalidResponse, err)
+		}
+	}
+
+	return 0, nil
+}
+
+// serverDown checks whether the request that failed with err didn't reach
+// the server, either because it's unreachable or because a proxy in front
+// of it couldn't reach it.
+func serverDown(err error) bool {
+	var cerr *Error
+	if !errors.As(err, &cerr) {
+		return false
+	}
+
+	switch cerr.Status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return cerr.Code == ErrUnreachable
+	}
+}
+
+// retryable checks whether the request that failed with err may be
+// retried: either the server couldn't be reached, it's overloaded or it
+// failed to handle the request.
+func retryable(err error) bool {
+	var cerr *Error
+	if !errors.As(err, &cerr) {
+		return false
+	}
+
+	switch {
+	case cerr.Code == ErrUnreachable:
+		return true
+	case cerr.Status == http.StatusTooManyRequests:
+		return true
+	case cerr.Status == http.StatusNotImplemented:
+		return false
+	default:
+		return cerr.Status >= 500
+	}
+}
+
+// newRequestID generates a random request ID.
+func newRequestID() string {
+	var buf [16]byte
+
+	// crypto/rand never fails on supported platforms.
+	rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
+
+// Notify posts msg to channel, with the default priority and no delay.
+func (c *Client) Notify(ctx context.Context, channel, msg string) (Result, error) {
+	return c.Send(ctx, Message{Channel: channel, Message: msg})
+}
+
+// Send posts msg to the server. If the server is down and the client spools
+// messages, msg is spooled instead, to be sent once the server is back.
+func (c *Client) Send(ctx context.Context, msg Message) (Result, error) {
+	if len(msg.RequestID) == 0 {
+		msg.RequestID = newRequestID()
+	}
+
+	res, err := c.post(ctx, "Send", msg)
+	if serverDown(err) && c.spool != nil {
+		return c.spoolMessage(msg)
+	}
+	return res, err
+}
+
+// post msg to the server.
+func (c *Client) post(ctx context.Context, op string, msg Message) (Result, error) {
+	data, err := json.Marshal(&msg)
+	if err != nil {
+		return Result{}, wrap(op, ErrRejected, err)
+	}
+
+	res := Result{RequestID: msg.RequestID}
+	err = c.do(ctx, op, request{
+		method: http.MethodPost,
+		resource: "message",
+		body: data,
+		requestID: msg.RequestID,
+		// Duplicates may be replied with a 409 Conflict.
+		accept: []int{http.StatusConflict},
+	}, &res)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return res, nil
+}
+
+// spoolMessage keeps msg in the spool, to be sent later.
+func (c *Client) spoolMessage(msg Message) (Result, error) {
+	data, err := json.Marshal(&spooledMessage{
+		Message: msg,
+		RequestID: msg.RequestID,
+	})
+	if err != nil {
+		return Result{}, wrap("Send", ErrSpoolFailed, err)
+	}
+
+	id, err := c.spool.StorePriority(data, msg.Priority.storePriority())
+	if err != nil && !errors.Is(err, local_storage.ErrDuplicatedStore) {
+		return Result{}, wrap("Send", ErrSpoolFailed, err)
+	}
+
+	return Result{
+		ID: id,
+		Duplicate: err != nil,
+		Spooled: true,
+		RequestID: msg.RequestID,
+	}, nil
+}
+
+// NotifyBatch posts every message in msgs, in order, as Client.Send()
+// does. It stops on the first message that fails, returning the results of
+// the messages posted (or spooled) before it.
+func (c *Client) NotifyBatch(ctx context.Context, msgs []Message) ([]Result, error) {
+	results := make([]Result, 0, len(msgs))
+	for _, msg := range msgs {
+		res, err := c.Send(ctx, msg)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, res)
+	}
+
+	return results, nil
+}
+
+// Count retrieves how many messages are waiting to be sent by the server.
+func (c *Client) Count(ctx context.Context) (int, error) {
+	var resp struct{
+		MessageCount int
+	}
+
+	err := c.do(ctx, "Count", request{
+		method: http.MethodGet,
+		resource: "message",
+	}, &resp)
+	if err != nil {
+		return 0, err
+	}
+
+	return resp.MessageCount, nil
+}
+
+// List retrieves every message waiting to be sent by the server, oldest
+// first. It requires an administrator's token.
+func (c *Client) List(ctx context.Context) ([]Entry, error) {
+	var entries []Entry
+
+	err := c.do(ctx, "List", request{
+		method: http.MethodGet,
+		resource: "message",
+		query: url.Values{"list": {""}},
+	}, &entries)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// Spooled retrieves how many messages are spooled, waiting to be sent. It's
+// always 0 if the client doesn't spool messages.
+func (c *Client) Spooled() int {
+	if c.spool == nil {
+		return 0
+	}
+	return c.spool.Count()
+}
+
+// Flush sends every spooled message to the server, returning how many were
+// sent. It stops on the first message that can't be sent, which is kept in
+// the spool. Messages rejected by the server are moved to the spool's
+// dead-letter area, so they may be inspected.
+func (c *Client) Flush(ctx context.Context) (int, error) {
+	if c.spool == nil {
+		return 0, nil
+	}
+
+	c.flushMutex.Lock()
+	defer c.flushMutex.Unlock()
+
+	sent := 0
+	for {
+		data, err := c.spool.Get()
+		if errors.Is(err, local_storage.ErrGetEmpty) {
+			return sent, nil
+		} else if err != nil {
+			return sent, wrap("Flush", ErrSpoolFailed, err)
+		}
+
+		var msg spooledMessage
+		err = json.Unmarshal(data.Bytes(), &msg)
+		if err != nil {
+			logger().Error("client/Flush: Dead-lettering an invalid message", "id", data.ID(), "err", err)
+			data.DeadLetter()
+			continue
+		}
+		msg.Message.RequestID = msg.RequestID
+
+		_, err = c.post(ctx, "Flush", msg.Message)
+		if errors.Is(err, ErrRejected) && !retryable(err) {
+			logger().Error("client/Flush: Dead-lettering a rejected message", "id", data.ID(), "channel", msg.Channel, "err", err)
+			data.DeadLetter()
+			continue
+		} else if err != nil {
+			data.Close()
+			return sent, err
+		}
+
+		data.Remove()
+		sent++
+	}
+}
+
+// Close releases the client's resources, stopping the background flush
+// (and cancelling its requests). Spooled messages are kept, and sent by the
+// next client that spools to the same directory. It's safe to call more than
+// once.
+func (c *Client) Close() error {
+	var err error
+
+	c.closeOnce.Do(func() {
+		if c.spool == nil {
+			return
+		}
+
+		if c.stop != nil {
+			c.cancelFlush()
+			close(c.stop)
+		}
+		err = c.spool.Close()
+		if c.flushDone != nil {
+			<-c.flushDone
+		}
+	})
+	return err
+}
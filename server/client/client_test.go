@@ -0,0 +1,319 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fastRetry retries quickly, so tests don't wait.
+var fastRetry = RetryPolicy{
+	Attempts: 3,
+	BaseDelay: time.Millisecond,
+	MaxDelay: 5 * time.Millisecond,
+}
+
+// received is a message received by a fake server.
+type received struct {
+	Message
+
+	// The request's X-Request-Id.
+	requestID string
+}
+
+// fakeServer imitates the notifier's HTTP API, failing the first 'fail'
+// posts with 'status'.
+type fakeServer struct {
+	// Synchronizes access to msgs.
+	mutex sync.Mutex
+
+	// Every message received, including the failed ones.
+	msgs []received
+
+	// How many posts should still fail.
+	fail atomic.Int32
+
+	// Status replied to failed posts.
+	status atomic.Int32
+}
+
+func (fs *fakeServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Authorization") != "Bearer some-token" {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case req.Method == http.MethodGet && req.URL.Query().Has("list"):
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"ID": "1-abc", "StoredAt": "2024-01-02T03:04:05Z", "Body": "{}"}]`))
+	case req.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"MessageCount": 3}`))
+	case req.Method == http.MethodPost:
+		var msg received
+		err := json.NewDecoder(req.Body).Decode(&msg.Message)
+		if err != nil {
+			http.Error(w, "Invalid message", http.StatusBadRequest)
+			return
+		}
+		msg.requestID = req.Header.Get("X-Request-Id")
+
+		fs.mutex.Lock()
+		fs.msgs = append(fs.msgs, msg)
+		num := len(fs.msgs)
+		fs.mutex.Unlock()
+
+		if fs.fail.Add(-1) >= 0 {
+			http.Error(w, "Failed", int(fs.status.Load()))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{"ID": msg.requestID, "Position": num})
+	}
+}
+
+// received retrieves every message received.
+func (fs *fakeServer) received() []received {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	return append([]received(nil), fs.msgs...)
+}
+
+// newClient creates a client for fs, closed once the test ends.
+func newClient(t *testing.T, fs *fakeServer, opts ...Option) *Client {
+	srv := httptest.NewServer(fs)
+	t.Cleanup(srv.Close)
+
+	opts = append([]Option{WithToken("some-token"), WithRetry(fastRetry)}, opts...)
+	c, err := New(srv.URL, opts...)
+	if err != nil {
+		t.Fatalf("New: Failed to create the client: %+v", err)
+	}
+	t.Cleanup(func() {
+		c.Close()
+	})
+	return c
+}
+
+// TestNotify checks that messages are posted to the server, and that their
+// results are decoded.
+func TestNotify(t *testing.T) {
+	fs := &fakeServer{}
+	c := newClient(t, fs)
+	ctx := context.Background()
+
+	res, err := c.Notify(ctx, "general", "Deploy finished")
+	if err != nil {
+		t.Fatalf("Notify: Failed to post the message: %+v", err)
+	} else if len(res.RequestID) == 0 || res.ID != res.RequestID || res.Position != 1 || res.Spooled {
+		t.Errorf("Notify: Unexpected result '%+v'", res)
+	}
+
+	results, err := c.NotifyBatch(ctx, []Message{
+		{ Channel: "alerts", Message: "Disk full", Priority: PriorityHigh },
+		{ Channel: "general", Message: "Lunch", DelaySeconds: 60 },
+	})
+	if err != nil {
+		t.Fatalf("NotifyBatch: Failed to post the messages: %+v", err)
+	} else if len(results) != 2 || results[1].Position != 3 {
+		t.Errorf("NotifyBatch: Unexpected results '%+v'", results)
+	}
+
+	msgs := fs.received()
+	if len(msgs) != 3 {
+		t.Fatalf("Notify: Expected 3 messages but got %d", len(msgs))
+	}
+	if msgs[0].Channel != "general" || msgs[0].Message.Message != "Deploy finished" {
+		t.Errorf("Notify: Unexpected message '%+v'", msgs[0])
+	}
+	if msgs[1].Priority != PriorityHigh || msgs[2].DelaySeconds != 60 {
+		t.Errorf("NotifyBatch: Unexpected messages '%+v'", msgs[1:])
+	}
+
+	if num, err := c.Count(ctx); err != nil || num != 3 {
+		t.Errorf("Count: Expected 3 but got %d (%+v)", num, err)
+	}
+	if entries, err := c.List(ctx); err != nil || len(entries) != 1 || entries[0].ID != "1-abc" {
+		t.Errorf("List: Unexpected entries '%+v' (%+v)", entries, err)
+	}
+}
+
+// TestRetry checks that failed requests are retried with the same request
+// ID, and that rejected requests aren't retried.
+func TestRetry(t *testing.T) {
+	fs := &fakeServer{}
+	fs.status.Store(http.StatusServiceUnavailable)
+	fs.fail.Store(2)
+	c := newClient(t, fs)
+
+	_, err := c.Notify(context.Background(), "general", "Third time's the charm")
+	if err != nil {
+		t.Fatalf("Notify: Failed to post the message: %+v", err)
+	}
+	msgs := fs.received()
+	if len(msgs) != 3 {
+		t.Fatalf("Notify: Expected 3 attempts but got %d", len(msgs))
+	}
+	for i, msg := range msgs {
+		if msg.requestID != msgs[0].requestID {
+			t.Errorf("%d: Notify: Expected request ID '%s' but got '%s'", i, msgs[0].requestID, msg.requestID)
+		}
+	}
+
+	fs.status.Store(http.StatusBadRequest)
+	fs.fail.Store(1)
+	_, err = c.Notify(context.Background(), "general", "Never again")
+	var cerr *Error
+	if !errors.Is(err, ErrRejected) || !errors.As(err, &cerr) || cerr.Status != http.StatusBadRequest {
+		t.Errorf("Notify: Expected the message to be rejected but got %+v", err)
+	}
+	if got := len(fs.received()); got != 4 {
+		t.Errorf("Notify: Expected the rejected message to be attempted once but got %d attempts", got - 3)
+	}
+
+	policy := RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 30 * time.Millisecond}
+	for i, want := range []time.Duration{10, 20, 30, 30} {
+		if got := policy.delay(i + 1); got != want * time.Millisecond {
+			t.Errorf("%d: delay: Expected %s but got %s", i, want * time.Millisecond, got)
+		}
+	}
+}
+
+// roundTripper fails every request while down.
+type roundTripper struct {
+	// Whether the server is unreachable.
+	down atomic.Bool
+}
+
+func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if rt.down.Load() {
+		return nil, errors.New("connection refused")
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// TestSpool checks that messages are spooled while the server is
+// unreachable, and flushed once it's back.
+func TestSpool(t *testing.T) {
+	fs := &fakeServer{}
+	rt := &roundTripper{}
+	rt.down.Store(true)
+	dir := t.TempDir()
+	c := newClient(t, fs, WithSpool(dir), WithManualFlush(), WithHTTPClient(&http.Client{Transport: rt}))
+	ctx := context.Background()
+
+	res, err := c.Notify(ctx, "general", "Nobody's home")
+	if err != nil {
+		t.Fatalf("Notify: Failed to spool the message: %+v", err)
+	} else if !res.Spooled || len(res.ID) == 0 {
+		t.Errorf("Notify: Expected the message to be spooled but got '%+v'", res)
+	}
+	if got := c.Spooled(); got != 1 {
+		t.Errorf("Spooled: Expected 1 message but got %d", got)
+	}
+
+	if sent, err := c.Flush(ctx); !errors.Is(err, ErrUnreachable) || sent != 0 {
+		t.Errorf("Flush: Expected the server to be unreachable but sent %d (%+v)", sent, err)
+	}
+	if got := c.Spooled(); got != 1 {
+		t.Errorf("Flush: Expected the message to be kept but got %d messages", got)
+	}
+
+	rt.down.Store(false)
+	if sent, err := c.Flush(ctx); err != nil || sent != 1 {
+		t.Errorf("Flush: Expected 1 message to be sent but sent %d (%+v)", sent, err)
+	}
+	if got := c.Spooled(); got != 0 {
+		t.Errorf("Flush: Expected the spool to be empty but got %d messages", got)
+	}
+	msgs := fs.received()
+	if len(msgs) != 1 || msgs[0].Message.Message != "Nobody's home" || msgs[0].requestID != res.RequestID {
+		t.Errorf("Flush: Unexpected messages '%+v'", msgs)
+	}
+
+	// Spooling is disabled by default.
+	plain := newClient(t, fs, WithHTTPClient(&http.Client{Transport: rt}))
+	rt.down.Store(true)
+	if _, err := plain.Notify(ctx, "general", "Lost"); !errors.Is(err, ErrUnreachable) {
+		t.Errorf("Notify: Expected the server to be unreachable but got %+v", err)
+	}
+}
+
+// TestNew checks that only valid URLs are accepted.
+func TestNew(t *testing.T) {
+	for _, u := range []string{"localhost:8888", "ftp://localhost", "http://[::1"} {
+		if _, err := New(u); !errors.Is(err, ErrInvalidURL) {
+			t.Errorf("New: Expected '%s' to be invalid but got %+v", u, err)
+		}
+	}
+}
+
+// waitReceived waits until fs received num messages, reporting whether it
+// did in time.
+func waitReceived(fs *fakeServer, num int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for len(fs.received()) < num && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return len(fs.received()) >= num
+}
+
+// TestAutoFlush checks that spooled messages are sent in the background
+// once the server is back, even by a new client.
+func TestAutoFlush(t *testing.T) {
+	fs := &fakeServer{}
+	rt := &roundTripper{}
+	rt.down.Store(true)
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	// Spool a message and exit before the server is back.
+	c := newClient(t, fs, WithSpool(dir), WithHTTPClient(&http.Client{Transport: rt}))
+	if res, err := c.Notify(ctx, "general", "Are you there?"); err != nil || !res.Spooled {
+		t.Fatalf("Notify: Expected the message to be spooled but got '%+v' (%+v)", res, err)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("Close: Failed to close the client: %+v", err)
+	}
+	// Closing again does nothing.
+	c.Close()
+
+	// Proxies in front of the server are also considered down.
+	rt.down.Store(false)
+	fs.status.Store(http.StatusBadGateway)
+	fs.fail.Store(int32(fastRetry.Attempts))
+	c = newClient(t, fs, WithSpool(dir), WithHTTPClient(&http.Client{Transport: rt}))
+	if !waitReceived(fs, fastRetry.Attempts + 1, 2 * time.Second) {
+		t.Fatalf("Flush: Expected the spooled message to be sent once the server is back but got %d attempts", len(fs.received()))
+	}
+	deadline := time.Now().Add(time.Second)
+	for c.Spooled() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := c.Spooled(); got != 0 {
+		t.Errorf("Flush: Expected the spool to be empty but got %d messages", got)
+	}
+
+	fs.fail.Store(int32(fastRetry.Attempts))
+	if res, err := c.Notify(ctx, "general", "Hello?"); err != nil || !res.Spooled {
+		t.Fatalf("Notify: Expected the message to be spooled but got '%+v' (%+v)", res, err)
+	}
+	if !waitReceived(fs, 2 * fastRetry.Attempts + 2, 2 * time.Second) {
+		t.Errorf("Flush: Expected the spooled message to be sent once the server is back but got %d attempts", len(fs.received()))
+	}
+
+	msgs := fs.received()
+	if last := msgs[len(msgs) - 1]; last.Message.Message != "Hello?" || last.requestID != msgs[len(msgs) - 2].requestID {
+		t.Errorf("Flush: Unexpected messages '%+v'", msgs)
+	}
+}
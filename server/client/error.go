@@ -0,0 +1,85 @@
+package client
+
+import (
+	"fmt"
+)
+
+type error_code uint
+
+const (
+	// The server's URL is invalid.
+	ErrInvalidURL error_code = iota
+	// Couldn't reach the server.
+	ErrUnreachable
+	// The server rejected the request.
+	ErrRejected
+	// The server failed to handle the request.
+	ErrServer
+	// The server's response couldn't be decoded.
+	ErrInvalidResponse
+	// Couldn't spool the message locally.
+	ErrSpoolFailed
+)
+
+func (e error_code) Error() string {
+	switch e {
+	case ErrInvalidURL:
+		return "The server's URL is invalid."
+	case ErrUnreachable:
+		return "Couldn't reach the server."
+	case ErrRejected:
+		return "The server rejected the request."
+	case ErrServer:
+		return "The server failed to handle the request."
+	case ErrInvalidResponse:
+		return "The server's response couldn't be decoded."
+	case ErrSpoolFailed:
+		return "Couldn't spool the message locally."
+	default:
+		return "Invalid client error."
+	}
+}
+
+// Error is the reason why a request failed. It matches its error code
+// through "errors.Is()" (e.g., ErrRejected), while wrapping its cause (if
+// any).
+type Error struct {
+	// The operation that failed.
+	Op string
+
+	// What failed.
+	Code error_code
+
+	// The response's status code, or 0 if there was no response.
+	Status int
+
+	// The response's body (e.g., why the request was rejected), if any.
+	Message string
+
+	// What caused the failure, if anything.
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Status != 0 {
+		return fmt.Sprintf("client/%s: %s %d %s", e.Op, e.Code, e.Status, e.Message)
+	}
+	return fmt.Sprintf("client/%s: %s %+v", e.Op, e.Code, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Code
+}
+
+// wrap err, the reason why op failed with code.
+func wrap(op string, code error_code, err error) error {
+	return &Error{
+		Op: op,
+		Code: code,
+		Err: err,
+	}
+}
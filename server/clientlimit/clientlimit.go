@@ -0,0 +1,120 @@
+/*
+Package clientlimit limits the rate of requests from each client.
+
+Each client, identified by an arbitrary key (e.g., its IP address or its
+authenticated identity), gets its own token bucket, so a single misbehaving
+client can't starve the others. Buckets of clients that stay idle for a
+while are discarded, so the memory used is bounded by the number of active
+clients.
+
+Example:
+
+	l := clientlimit.New(5, 10)
+
+	if ok, retryAfter := l.Allow(clientIP); !ok {
+		// reply with 429, asking the client to retry after retryAfter
+	}
+*/
+package clientlimit
+
+import (
+	"golang.org/x/time/rate"
+	"sync"
+	"time"
+)
+
+// Limiter limits the rate of requests from each client.
+type Limiter struct {
+	// How many requests each client may do per second, on average.
+	perSecond rate.Limit
+
+	// How many requests each client may do at once.
+	burst int
+
+	// Synchronizes access to the fields below.
+	mutex sync.Mutex
+
+	// Each client's bucket, by their key.
+	clients map[string]*client
+
+	// When the idle clients were last discarded.
+	cleanedAt time.Time
+}
+
+// client is the state of a single client.
+type client struct {
+	// The client's bucket.
+	limiter *rate.Limiter
+
+	// When the client last made a request.
+	lastSeen time.Time
+}
+
+// New creates a Limiter that allows each client perSecond requests per
+// second, on average, and up to burst requests at once.
+func New(perSecond float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &Limiter{
+		perSecond: rate.Limit(perSecond),
+		burst: burst,
+		clients: make(map[string]*client),
+		cleanedAt: time.Now(),
+	}
+}
+
+// idleTime retrieves for how long a client must stay idle to be discarded.
+// By then, its bucket is full again, so discarding it doesn't change how
+// many requests it's allowed to do.
+func (l *Limiter) idleTime() time.Duration {
+	refill := time.Duration(float64(l.burst) / float64(l.perSecond) * float64(time.Second))
+	if refill < time.Minute {
+		return time.Minute
+	}
+	return refill
+}
+
+// Allow checks whether the client identified by key may do a request right
+// now. If not, retryAfter is how long the client should wait before trying
+// again.
+func (l *Limiter) Allow(key string) (ok bool, retryAfter time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	if idle := l.idleTime(); now.Sub(l.cleanedAt) >= idle {
+		for k, c := range l.clients {
+			if now.Sub(c.lastSeen) >= idle {
+				delete(l.clients, k)
+			}
+		}
+		l.cleanedAt = now
+	}
+
+	c, found := l.clients[key]
+	if !found {
+		c = &client{
+			limiter: rate.NewLimiter(l.perSecond, l.burst),
+		}
+		l.clients[key] = c
+	}
+	c.lastSeen = now
+
+	r := c.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		// Don't consume the token, as the request is refused.
+		r.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// Clients retrieves how many clients are currently tracked.
+func (l *Limiter) Clients() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return len(l.clients)
+}
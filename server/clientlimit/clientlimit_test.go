@@ -0,0 +1,47 @@
+package clientlimit
+
+import (
+	"testing"
+	"time"
+)
+
+// TestAllow checks that each client has its own limit, and that refused
+// clients are told how long to wait.
+func TestAllow(t *testing.T) {
+	l := New(1, 2)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("noisy"); !ok {
+			t.Errorf("%d: Allow: The request was refused within the burst", i)
+		}
+	}
+
+	ok, retryAfter := l.Allow("noisy")
+	if ok {
+		t.Errorf("Allow: The request was accepted after the burst")
+	} else if retryAfter <= 0 || retryAfter > time.Second {
+		t.Errorf("Allow: Expected to retry within 1s but got '%s'", retryAfter)
+	}
+
+	if ok, _ := l.Allow("quiet"); !ok {
+		t.Errorf("Allow: Another client was refused due to the noisy one")
+	}
+	if want, got := 2, l.Clients(); want != got {
+		t.Errorf("Clients: Expected '%d' but got '%d'", want, got)
+	}
+}
+
+// TestIdleClients checks that idle clients are discarded.
+func TestIdleClients(t *testing.T) {
+	l := New(1000, 1)
+	l.Allow("idle")
+
+	// Pretend that a long time went by.
+	l.clients["idle"].lastSeen = time.Now().Add(-time.Hour)
+	l.cleanedAt = time.Now().Add(-time.Hour)
+
+	l.Allow("active")
+	if want, got := 1, l.Clients(); want != got {
+		t.Errorf("Clients: Expected '%d' but got '%d'", want, got)
+	}
+}
@@ -0,0 +1,29 @@
+package flagoverride
+
+type error_code uint
+
+const (
+	// The destination isn't a pointer to a struct.
+	ErrNotStruct error_code = iota
+	// A flag was set, but the struct doesn't have a field for it.
+	ErrNoField
+	// The flag's value can't be retrieved (it isn't a flag.Getter).
+	ErrNoGetter
+	// The flag's value can't be assigned to its field.
+	ErrType
+)
+
+func (e error_code) Error() string {
+	switch e {
+	case ErrNotStruct:
+		return "The destination isn't a pointer to a struct."
+	case ErrNoField:
+		return "A flag was set, but the struct doesn't have a field for it."
+	case ErrNoGetter:
+		return "The flag's value can't be retrieved (it isn't a flag.Getter)."
+	case ErrType:
+		return "The flag's value can't be assigned to its field."
+	default:
+		return "Invalid flagoverride error."
+	}
+}
@@ -0,0 +1,156 @@
+/*
+Package flagoverride assigns the flags set on the command line to the
+fields of a struct, so options loaded from somewhere else (e.g., a
+configuration file) may be overridden by the command line.
+
+Each flag is assigned to the exported field with the same name, unless the
+field is tagged with another name (e.g., `flag:"port"`). Fields tagged with
+`flag:"-"` are never assigned, and fields tagged as secrets (e.g.,
+`flag:",secret"`) are reported without their values, so they may be logged
+safely. Only the flags actually set on the command line are assigned.
+
+Example:
+
+	type Options struct {
+		Port int
+		Token string `flag:",secret"`
+	}
+
+	var opts Options
+	// Load opts from somewhere else...
+
+	overrides, err := flagoverride.Apply(&opts, flag.CommandLine, "confFile")
+	if err != nil {
+		// handle err
+	}
+	for _, o := range overrides {
+		log.Printf("%s overridden by the command line", o.Name)
+	}
+*/
+package flagoverride
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Override describes a field assigned from a flag.
+type Override struct {
+	// The flag's name.
+	Name string
+	// The field's value before it was overridden. Nil for secrets.
+	Old any
+	// The field's new value. Nil for secrets.
+	New any
+	// Whether the field is tagged as a secret.
+	Secret bool
+}
+
+// field is a struct field that may be assigned by a flag.
+type field struct {
+	// The field's index in the struct.
+	index int
+	// Whether the field is tagged as a secret.
+	secret bool
+}
+
+// fields maps the name of each flag to the field of t that it assigns.
+func fields(t reflect.Type) map[string]field {
+	m := make(map[string]field)
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(sf.Tag.Get("flag"), ",")
+		if name == "-" {
+			continue
+		} else if len(name) == 0 {
+			name = sf.Name
+		}
+
+		m[name] = field{
+			index: i,
+			secret: Secret(sf),
+		}
+	}
+	return m
+}
+
+// Secret checks whether sf is tagged as a secret, whose value shouldn't be
+// displayed (e.g., `flag:",secret"`).
+func Secret(sf reflect.StructField) bool {
+	_, opts, _ := strings.Cut(sf.Tag.Get("flag"), ",")
+	return opts == "secret"
+}
+
+// Apply assigns every flag set in fs to its field in dst, which must be a
+// pointer to a struct, returning the fields that were overridden, in the
+// order of the flags' names. Flags listed in skip are ignored.
+//
+// Every other flag that was set must have a field, so options aren't
+// silently ignored. If any flag can't be assigned, dst may be left with
+// only some of the flags assigned.
+func Apply(dst any, fs *flag.FlagSet, skip ...string) ([]Override, error) {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return nil, ErrNotStruct
+	}
+	v = v.Elem()
+	byName := fields(v.Type())
+
+	var overrides []Override
+	var err error
+	fs.Visit(func (f *flag.Flag) {
+		if err != nil {
+			return
+		}
+		for _, name := range skip {
+			if f.Name == name {
+				return
+			}
+		}
+
+		fld, ok := byName[f.Name]
+		if !ok {
+			err = fmt.Errorf("'%s': %w", f.Name, ErrNoField)
+			return
+		}
+		get, ok := f.Value.(flag.Getter)
+		if !ok {
+			err = fmt.Errorf("'%s': %w", f.Name, ErrNoGetter)
+			return
+		}
+
+		dv := v.Field(fld.index)
+		val := reflect.ValueOf(get.Get())
+		if !val.IsValid() {
+			err = fmt.Errorf("'%s': %w", f.Name, ErrType)
+			return
+		} else if !val.Type().AssignableTo(dv.Type()) {
+			// Accept flags of the field's underlying type (e.g., a string
+			// flag for a field of type `type Mode string`).
+			if val.Kind() != dv.Kind() || !val.CanConvert(dv.Type()) {
+				err = fmt.Errorf("'%s' (%s into %s): %w", f.Name, val.Type(), dv.Type(), ErrType)
+				return
+			}
+			val = val.Convert(dv.Type())
+		}
+
+		o := Override{
+			Name: f.Name,
+			Secret: fld.secret,
+		}
+		if !fld.secret {
+			o.Old = dv.Interface()
+			o.New = val.Interface()
+		}
+		dv.Set(val)
+		overrides = append(overrides, o)
+	})
+
+	return overrides, err
+}
@@ -0,0 +1,143 @@
+package flagoverride
+
+import (
+	"errors"
+	"flag"
+	"reflect"
+	"testing"
+)
+
+type mode string
+
+type options struct {
+	IP string
+	Port int
+	Rate float64
+	Debug bool
+	Token string `flag:",secret"`
+	Mode mode `flag:"mode"`
+	Ignored string `flag:"-"`
+	hidden string
+}
+
+// newFlagSet creates a flag set for options, assigning the flags in args.
+func newFlagSet(t *testing.T, args ...string) *flag.FlagSet {
+	var tmp options
+	var mode string
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.StringVar(&tmp.IP, "IP", "0.0.0.0", "")
+	fs.IntVar(&tmp.Port, "Port", 8888, "")
+	fs.Float64Var(&tmp.Rate, "Rate", 0, "")
+	fs.BoolVar(&tmp.Debug, "Debug", false, "")
+	fs.StringVar(&tmp.Token, "Token", "", "")
+	fs.StringVar(&mode, "mode", "", "")
+	fs.StringVar(&tmp.Ignored, "Ignored", "", "")
+	fs.StringVar(&tmp.hidden, "hidden", "", "")
+	fs.String("confFile", "", "")
+
+	err := fs.Parse(args)
+	if err != nil {
+		t.Fatalf("Parse: Failed to parse '%+v': %+v", args, err)
+	}
+	return fs
+}
+
+// TestApply checks that only the flags set on the command line are
+// assigned, and that secrets aren't reported.
+func TestApply(t *testing.T) {
+	test_cases := []struct{
+		args []string
+		want options
+		overrides []Override
+	}{
+		{
+			args: nil,
+			want: options{ IP: "127.0.0.1", Port: 80 },
+		},
+		{
+			args: []string{"-Port", "9999", "-confFile", "conf.json"},
+			want: options{ IP: "127.0.0.1", Port: 9999 },
+			overrides: []Override{
+				{ Name: "Port", Old: 80, New: 9999 },
+			},
+		},
+		{
+			args: []string{"-Debug", "-Rate", "1.5", "-IP", "0.0.0.0", "-mode", "fast"},
+			want: options{ IP: "0.0.0.0", Port: 80, Rate: 1.5, Debug: true, Mode: "fast" },
+			overrides: []Override{
+				{ Name: "Debug", Old: false, New: true },
+				{ Name: "IP", Old: "127.0.0.1", New: "0.0.0.0" },
+				{ Name: "Rate", Old: 0.0, New: 1.5 },
+				{ Name: "mode", Old: mode(""), New: mode("fast") },
+			},
+		},
+		{
+			args: []string{"-Token", "s3cr3t"},
+			want: options{ IP: "127.0.0.1", Port: 80, Token: "s3cr3t" },
+			overrides: []Override{
+				{ Name: "Token", Secret: true },
+			},
+		},
+	}
+
+	for i, tc := range test_cases {
+		opts := options{ IP: "127.0.0.1", Port: 80 }
+
+		overrides, err := Apply(&opts, newFlagSet(t, tc.args...), "confFile")
+		if err != nil {
+			t.Errorf("%d: Apply: Expected no error but got '%+v'", i, err)
+		}
+		if opts != tc.want {
+			t.Errorf("%d: Apply: Expected '%+v' but got '%+v'", i, tc.want, opts)
+		}
+		if !reflect.DeepEqual(overrides, tc.overrides) {
+			t.Errorf("%d: Apply: Expected overrides '%+v' but got '%+v'", i, tc.overrides, overrides)
+		}
+	}
+}
+
+// TestApplyErrors checks that flags without a field (or with an
+// incompatible one) are reported, instead of silently ignored.
+func TestApplyErrors(t *testing.T) {
+	test_cases := []struct{
+		dst any
+		args []string
+		want error
+	}{
+		{ dst: options{}, want: ErrNotStruct },
+		{ dst: new(int), want: ErrNotStruct },
+		{ dst: &options{}, args: []string{"-Ignored", "x"}, want: ErrNoField },
+		{ dst: &options{}, args: []string{"-hidden", "x"}, want: ErrNoField },
+		{ dst: &options{}, args: []string{"-confFile", "conf.json"}, want: ErrNoField },
+		{ dst: &struct{ Port string }{}, args: []string{"-Port", "1"}, want: ErrType },
+	}
+
+	for i, tc := range test_cases {
+		_, err := Apply(tc.dst, newFlagSet(t, tc.args...))
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%d: Apply: Expected error '%+v' but got '%+v'", i, tc.want, err)
+		}
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Func("Port", "", func (string) error { return nil })
+	fs.Parse([]string{"-Port", "1"})
+	if _, err := Apply(&options{}, fs); !errors.Is(err, ErrNoGetter) {
+		t.Errorf("Apply: Expected error '%+v' but got '%+v'", ErrNoGetter, err)
+	}
+}
+
+// TestSecret checks that only the fields tagged as secrets are reported as
+// such.
+func TestSecret(t *testing.T) {
+	typ := reflect.TypeOf(options{})
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+
+		want := sf.Name == "Token"
+		if got := Secret(sf); got != want {
+			t.Errorf("Secret(%s): Expected '%+v' but got '%+v'", sf.Name, want, got)
+		}
+	}
+}
@@ -0,0 +1,785 @@
+/*
+Package forwarder implements the workers that move messages from a local
+storage to a sender.
+
+A Forwarder retrieves each stored message, sends it and, once it's
+accepted, removes it from the local storage. Messages that fail to be sent
+are kept, so they're retried later, and messages the receiver will never
+accept (i.e., sender.ErrRejected or sender.ErrInvalidInput) are either
+dead-lettered or discarded. While the circuit breaker (if any) is open,
+workers wait for it instead of spinning over the local storage.
+
+After consecutive failures, the workers back off (as configured by
+Options.Backoff) before retrieving the next message, so an unreachable
+receiver isn't retried in a tight loop. The delay is doubled after every
+failure, up to its cap, and reset once a message is sent.
+
+Messages are forwarded by a pool of workers, so more than one message may
+be sent at once (in which case, they may be delivered out of order).
+Forwarding may be paused, keeping the messages in the local storage until
+it's resumed.
+
+The Forwarder doesn't know the format of the stored messages: they're
+converted into the messages sent by Options.Decode, and every message sent
+(or failed) is reported to Options.OnEvent.
+
+Example:
+
+	fw := forwarder.New(store, s, forwarder.Options{
+		Workers: 2,
+		DeadLetter: true,
+		Backoff: forwarder.BackoffPolicy{
+			BaseDelay: time.Second,
+			MaxDelay: time.Minute,
+			Jitter: 0.2,
+		},
+	})
+	fw.Start()
+	defer fw.Stop()
+
+	// Send the backlog right away, waiting up to a minute for it.
+	res := fw.Drain(time.Minute)
+	if !res.Drained {
+		log.Printf("%d messages are still pending", res.Remaining)
+	}
+*/
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"github.com/SirGFM/sqs-issue-notifier/server/health"
+	"github.com/SirGFM/sqs-issue-notifier/server/local_storage"
+	"github.com/SirGFM/sqs-issue-notifier/server/sender"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// How often a drain checks whether the backlog has drained.
+const drainPollInterval = 100 * time.Millisecond
+
+// How often a paused worker checks whether the forwarder was stopped.
+const pausePollInterval = time.Second
+
+// How long an idle worker waits before looking for messages again, while
+// the other workers are forwarding every stored message.
+const workerIdleInterval = 50 * time.Millisecond
+
+// How often the workers are woken while the forwarder is stopping.
+const stopPollInterval = 10 * time.Millisecond
+
+// DefaultComponent is the component whose health is reported, unless set
+// in Options.
+const DefaultComponent = "forwarder"
+
+// Creates the forwarder's spans. Until tracing is set up, its spans are
+// discarded.
+var tracer = otel.Tracer("github.com/SirGFM/sqs-issue-notifier/server/forwarder")
+
+// Extracts the trace context kept in the messages' attributes, in the W3C
+// Trace Context format.
+var tracePropagator = propagation.TraceContext{}
+
+// EventType identifies what happened to a message in an Event.
+type EventType int
+
+const (
+	// The message was sent.
+	EventSent EventType = iota
+	// Sending the message failed. It's retried later, unless it was
+	// rejected.
+	EventFailed
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventSent:
+		return "sent"
+	case EventFailed:
+		return "failed"
+	default:
+		return "invalid"
+	}
+}
+
+// Event describes a message that was sent (or that failed to be sent).
+type Event struct {
+	// What happened to the message.
+	Type EventType
+
+	// Identifies the message in the local storage.
+	ID string
+
+	// The message, as sent.
+	Message sender.Message
+
+	// How the receiver accepted the message, on EventSent.
+	Result sender.SendResult
+
+	// Why sending the message failed, on EventFailed.
+	Err error
+}
+
+// BackoffPolicy defines for how long the workers wait after consecutive
+// messages fail to be sent, before retrieving the next one.
+//
+// The zero value disables backing off.
+type BackoffPolicy struct {
+	// Delay after the first failure. It's doubled after every consecutive
+	// failure.
+	BaseDelay time.Duration
+
+	// Upper bound for the delay. Ignored if 0.
+	MaxDelay time.Duration
+
+	// Fraction of each delay, between 0.0 and 1.0, that's randomized to
+	// avoid every worker retrying in lockstep.
+	Jitter float64
+}
+
+// delay returns how long to wait after the given number of consecutive
+// failures (starting at 1).
+func (p BackoffPolicy) delay(failures int) time.Duration {
+	d := p.BaseDelay
+	// Stop doubling before it overflows, as MaxDelay may be unbounded.
+	for i := 1; i < failures && d > 0 && d <= math.MaxInt64 / 2 && (p.MaxDelay == 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay != 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+
+	jitter := min(p.Jitter, 1.0)
+	if jitter > 0.0 && d > 0 {
+		// Remove up to 'jitter' of the delay, so the cap is never
+		// exceeded.
+		d -= time.Duration(float64(d) * jitter * rand.Float64())
+	}
+
+	return d
+}
+
+// Options configures a Forwarder. The zero value is a single worker that
+// discards rejected messages.
+type Options struct {
+	// Number of workers forwarding messages at once. Defaults to 1.
+	Workers int
+
+	// Maximum number of messages being sent at once, across every worker.
+	// 0 only limits it by Workers.
+	MaxInFlight int
+
+	// Whether messages rejected by the receiver are dead-lettered, instead
+	// of discarded.
+	DeadLetter bool
+
+	// Start with forwarding paused, until resumed.
+	Paused bool
+
+	// How the workers back off after consecutive failures. Defaults to
+	// not backing off.
+	Backoff BackoffPolicy
+
+	// The circuit breaker wrapped by the sender, if any, so workers wait
+	// while it's open, and so Drain may probe it.
+	Breaker *sender.CircuitBreaker
+
+	// Converts the data retrieved from the local storage into the message
+	// sent. Defaults to sending the data as the message's body.
+	Decode func(data []byte) sender.Message
+
+	// Called for every message sent (or that failed to be sent), if set.
+	OnEvent func(Event)
+
+	// The message attribute that identifies the request that sent the
+	// message (if any), logged along with the message.
+	RequestIDAttr string
+
+	// Where the forwarder's health is reported, if set.
+	Health *health.Registry
+
+	// The component whose health is reported. Defaults to
+	// DefaultComponent.
+	Component string
+
+	// Logs the forwarder's entries. Defaults to slog's default logger.
+	Logger *slog.Logger
+}
+
+// DrainResult describes what happened during a drain.
+type DrainResult struct {
+	// Number of messages sent since the drain started.
+	Sent int
+
+	// Number of messages still in the backlog.
+	Remaining int
+
+	// Whether the backlog was drained.
+	Drained bool
+}
+
+// Forwarder retrieves messages from a local storage, forwarding them
+// through a sender. It must be created by New.
+type Forwarder struct {
+	// The local storage where messages are kept until they're sent.
+	store local_storage.Store
+
+	// Sends the messages.
+	sender sender.Sender
+
+	// How messages are forwarded.
+	opts Options
+
+	// Slots of the messages that may be sent at once. Nil if limited
+	// only by the number of workers.
+	inFlight chan struct{}
+
+	// Closed to stop the workers.
+	stop chan struct{}
+
+	// Waits for every worker to stop.
+	workers sync.WaitGroup
+
+	// Ensures the workers are only started once.
+	startOnce sync.Once
+
+	// Ensures the workers are only stopped once.
+	stopOnce sync.Once
+
+	// Synchronizes access to opts.Backoff, wake, resume, sent, failures,
+	// backoff, busySince and lastActive.
+	mutex sync.Mutex
+
+	// Closed once forwarding is resumed. Nil while it isn't paused.
+	resume chan struct{}
+
+	// Closed (and replaced) to wake every worker waiting for the circuit
+	// breaker (or backing off).
+	wake chan struct{}
+
+	// Number of messages sent since the forwarder started.
+	sent int
+
+	// Number of consecutive messages that failed to be sent.
+	failures int
+
+	// For how long the workers back off after the latest failure. 0 once
+	// a message is sent.
+	backoff time.Duration
+
+	// When each worker started forwarding its current message. Zero while
+	// the worker is waiting for messages.
+	busySince []time.Time
+
+	// When any worker last started or finished forwarding a message.
+	lastActive time.Time
+}
+
+// New creates a Forwarder that forwards every message in store through s,
+// once started.
+func New(store local_storage.Store, s sender.Sender, opts Options) *Forwarder {
+	opts.Workers = max(opts.Workers, 1)
+	if opts.Decode == nil {
+		opts.Decode = func(data []byte) sender.Message {
+			return sender.Message{Body: string(data)}
+		}
+	}
+	if len(opts.Component) == 0 {
+		opts.Component = DefaultComponent
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	fw := &Forwarder{
+		store: store,
+		sender: s,
+		opts: opts,
+		stop: make(chan struct{}),
+		wake: make(chan struct{}),
+		busySince: make([]time.Time, opts.Workers),
+		lastActive: time.Now(),
+	}
+	if opts.MaxInFlight > 0 && opts.MaxInFlight < opts.Workers {
+		fw.inFlight = make(chan struct{}, opts.MaxInFlight)
+	}
+	fw.report(health.OK, "")
+	if opts.Paused {
+		fw.Pause()
+	}
+
+	return fw
+}
+
+// Start the workers, which forward messages until either the forwarder is
+// stopped or the local storage is closed.
+func (fw *Forwarder) Start() {
+	fw.startOnce.Do(func() {
+		for i := 0; i < fw.opts.Workers; i++ {
+			fw.workers.Add(1)
+			go func(worker int) {
+				defer fw.workers.Done()
+				fw.run(worker)
+			} (i)
+		}
+	})
+}
+
+// Stop the workers, waiting for them to finish the messages they're
+// currently sending. Pending messages are kept in the local storage, which
+// should only be closed once the forwarder is stopped. It's safe to call
+// more than once, and from any goroutine (even before Start).
+func (fw *Forwarder) Stop() {
+	fw.stopOnce.Do(func() {
+		close(fw.stop)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		fw.workers.Wait()
+		close(done)
+	} ()
+
+	// The local storage only wakes a single waiting worker at a time.
+	for {
+		fw.store.Wake()
+		select {
+		case <-done:
+			return
+		case <-time.After(stopPollInterval):
+		}
+	}
+}
+
+// stopped checks whether the forwarder was stopped.
+func (fw *Forwarder) stopped() bool {
+	select {
+	case <-fw.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// run forwards messages, as the worker identified by worker, until either
+// the forwarder is stopped or the local storage is closed.
+func (fw *Forwarder) run(worker int) {
+	store := fw.store
+
+	for !fw.stopped() {
+		err := store.Wait()
+		if err == local_storage.ErrStoreClosed {
+			return
+		} else if fw.stopped() {
+			return
+		} else if err != nil && err != local_storage.ErrTimedOut {
+			fw.opts.Logger.Error("local_store.Wait failed", "err", err)
+			continue
+		}
+
+		if resume := fw.resumeChan(); resume != nil {
+			// Messages are kept in the local storage until resumed.
+			select {
+			case <-resume:
+			case <-fw.stop:
+			case <-time.After(pausePollInterval):
+			}
+			continue
+		}
+
+		getStart := time.Now()
+		fw.setBusy(worker, getStart)
+		data, err := store.Get()
+		if err == local_storage.ErrGetEmpty {
+			fw.setBusy(worker, time.Time{})
+			if fw.opts.Workers > 1 {
+				// Every stored message is being forwarded by
+				// another worker, so Wait wouldn't block.
+				time.Sleep(workerIdleInterval)
+			}
+			continue
+		} else if err != nil {
+			fw.opts.Logger.Error("local_store.Get failed", "err", err)
+			fw.setBusy(worker, time.Time{})
+			continue
+		}
+
+		err = fw.forward(worker, data, getStart)
+		fw.setBusy(worker, time.Time{})
+
+		if delay := fw.nextBackoff(err); delay > 0 {
+			// Drain stops backing off right away.
+			select {
+			case <-time.After(delay):
+			case <-fw.wakeChan():
+			case <-fw.stop:
+			}
+		}
+	}
+}
+
+// nextBackoff updates the consecutive failures with the result of sending
+// a message, which failed with err (if not nil), retrieving for how long
+// the worker should back off.
+func (fw *Forwarder) nextBackoff(err error) time.Duration {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+
+	switch {
+	case errors.Is(err, sender.ErrCircuitOpen):
+		// The worker already waited for the circuit breaker.
+		return 0
+	case err == nil, errors.Is(err, sender.ErrRejected), errors.Is(err, sender.ErrInvalidInput):
+		// The receiver was reached (even if the message itself was
+		// rejected).
+		if fw.failures > 0 {
+			fw.opts.Logger.Info("Stopped backing off", "failures", fw.failures)
+		}
+		fw.failures = 0
+		fw.backoff = 0
+		return 0
+	default:
+		fw.failures++
+		fw.backoff = fw.opts.Backoff.delay(fw.failures)
+		if fw.backoff > 0 {
+			fw.opts.Logger.Warn("Backing off after consecutive failures", "failures", fw.failures, "backoff", fw.backoff)
+		}
+		return fw.backoff
+	}
+}
+
+// Backoff retrieves for how long the workers back off after the latest
+// failure. It's 0 once a message is sent.
+func (fw *Forwarder) Backoff() time.Duration {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+
+	return fw.backoff
+}
+
+// SetBackoff changes how the workers back off after consecutive failures.
+// It's applied from the next failure on.
+func (fw *Forwarder) SetBackoff(p BackoffPolicy) {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+
+	fw.opts.Backoff = p
+}
+
+// setBusy records since when worker has been forwarding its current
+// message, or, if since is zero, that it's waiting for messages.
+func (fw *Forwarder) setBusy(worker int, since time.Time) {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+
+	fw.busySince[worker] = since
+	fw.lastActive = time.Now()
+}
+
+// InFlight retrieves the number of workers currently forwarding a message.
+func (fw *Forwarder) InFlight() int {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+
+	var n int
+	for _, since := range fw.busySince {
+		if !since.IsZero() {
+			n++
+		}
+	}
+	return n
+}
+
+// LastActive retrieves when any worker last started or finished forwarding
+// a message (i.e., retrieving it from the local storage and sending it).
+// If the backlog isn't empty but the forwarder has been inactive for a
+// while, the workers may have missed being woken.
+func (fw *Forwarder) LastActive() time.Time {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+
+	return fw.lastActive
+}
+
+// Busy retrieves for how long the forwarder has been forwarding its
+// current message (i.e., the longest of any worker). It's 0 while every
+// worker is waiting for messages (or for the circuit breaker).
+func (fw *Forwarder) Busy() time.Duration {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+
+	var busy time.Duration
+	for _, since := range fw.busySince {
+		if !since.IsZero() {
+			busy = max(busy, time.Since(since))
+		}
+	}
+	return busy
+}
+
+// wakeChan retrieves the channel closed once the workers waiting for the
+// circuit breaker (or backing off) should wake.
+func (fw *Forwarder) wakeChan() <-chan struct{} {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+
+	return fw.wake
+}
+
+// Wake every worker, whether it's waiting for messages, for the circuit
+// breaker or backing off, so they look for messages right away.
+func (fw *Forwarder) Wake() {
+	fw.wakeAll()
+	// The local storage only wakes a single waiting worker at a time.
+	for i := 0; i < fw.opts.Workers; i++ {
+		fw.store.Wake()
+	}
+}
+
+// wakeAll wakes every worker waiting for the circuit breaker (or backing
+// off).
+func (fw *Forwarder) wakeAll() {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+
+	close(fw.wake)
+	fw.wake = make(chan struct{})
+}
+
+// resumeChan retrieves the channel closed once forwarding is resumed, or
+// nil if it isn't paused.
+func (fw *Forwarder) resumeChan() <-chan struct{} {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+
+	return fw.resume
+}
+
+// Pause forwarding messages (e.g., during the destination's maintenance),
+// keeping them in the local storage until resumed. Messages already being
+// sent aren't interrupted.
+func (fw *Forwarder) Pause() {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+
+	if fw.resume == nil {
+		fw.resume = make(chan struct{})
+		fw.opts.Logger.Warn("Paused forwarding messages")
+		fw.report(health.Degraded, "paused, buffering locally")
+	}
+}
+
+// Resume forwarding messages, once paused.
+func (fw *Forwarder) Resume() {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+
+	if fw.resume != nil {
+		close(fw.resume)
+		fw.resume = nil
+		fw.opts.Logger.Info("Resumed forwarding messages")
+		fw.report(health.OK, "")
+	}
+}
+
+// report the forwarder's health, if enabled.
+func (fw *Forwarder) report(status health.Status, reason string) {
+	if fw.opts.Health != nil {
+		fw.opts.Health.Set(fw.opts.Component, status, reason)
+	}
+}
+
+// Paused checks whether forwarding is paused.
+func (fw *Forwarder) Paused() bool {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+
+	return fw.resume != nil
+}
+
+// send msg through the sender, waiting for a free slot if too many
+// messages are already being sent.
+func (fw *Forwarder) send(msg sender.Message) (sender.SendResult, error) {
+	if fw.inFlight != nil {
+		fw.inFlight <- struct{}{}
+		defer func() { <-fw.inFlight }()
+	}
+
+	return fw.sender.Send(msg)
+}
+
+// forward a single message, retrieved from the local storage at getStart
+// by worker, to the sender, returning why sending it failed (if it did).
+// The message is traced as part of the request that stored it, if its
+// attributes carry the request's trace context.
+func (fw *Forwarder) forward(worker int, data local_storage.Data, getStart time.Time) error {
+	log := fw.opts.Logger
+	msg := fw.opts.Decode(data.Bytes())
+
+	parent := tracePropagator.Extract(context.Background(), propagation.MapCarrier(msg.Attributes))
+	ctx, span := tracer.Start(parent, "forward",
+			trace.WithTimestamp(getStart),
+			trace.WithAttributes(attribute.String("local_storage.id", data.ID())))
+	defer span.End()
+	if stored, err := local_storage.StoredAt(data.ID()); err == nil {
+		span.SetAttributes(attribute.Int64("local_storage.age_ms", time.Since(stored).Milliseconds()))
+	}
+	_, getSpan := tracer.Start(ctx, "local_storage.Get", trace.WithTimestamp(getStart))
+	getSpan.End()
+
+	_, sendSpan := tracer.Start(ctx, "sender.Send")
+	res, err := fw.send(msg)
+	if err == nil {
+		sendSpan.SetAttributes(
+			attribute.String("message_id", res.MessageID),
+			attribute.Int("attempts", res.Attempts),
+		)
+	}
+	endSpan(sendSpan, err)
+
+	rejected := errors.Is(err, sender.ErrInvalidInput) || errors.Is(err, sender.ErrRejected)
+	if errors.Is(err, sender.ErrCircuitOpen) {
+		// Release the data and wait until the breaker may be
+		// probed again (or until drained), instead of spinning
+		// over the local storage. While another worker probes the
+		// breaker (i.e., it's half-open), there's no cool-down
+		// left, so wait a little before trying again.
+		data.Close()
+		fw.setBusy(worker, time.Time{})
+		retryIn := workerIdleInterval
+		if fw.opts.Breaker != nil {
+			retryIn = max(fw.opts.Breaker.RetryIn(), workerIdleInterval)
+		}
+		select {
+		case <-time.After(retryIn):
+		case <-fw.wakeChan():
+		case <-fw.stop:
+		}
+		return err
+	} else if rejected && fw.opts.DeadLetter {
+		// The message will never be accepted, so keep it aside
+		// instead of retrying it forever.
+		log.Warn("sender.Send rejected the message, dead-lettering it",
+				"id", data.ID(), "request_id", msg.Attributes[fw.opts.RequestIDAttr], "err", err)
+		fw.publish(EventFailed, data.ID(), msg, res, err)
+
+		_, dlSpan := tracer.Start(ctx, "local_storage.DeadLetter")
+		dlErr := data.DeadLetter()
+		endSpan(dlSpan, dlErr)
+		if dlErr != nil {
+			log.Error("local_store.DeadLetter failed", "err", dlErr)
+			data.Close()
+		}
+		return err
+	} else if rejected {
+		// The message will never be accepted, so discard it
+		// instead of retrying it forever.
+		log.Warn("sender.Send rejected the message, discarding it",
+				"id", data.ID(), "request_id", msg.Attributes[fw.opts.RequestIDAttr], "body", msg.Body, "err", err)
+		fw.publish(EventFailed, data.ID(), msg, res, err)
+	} else if err != nil {
+		log.Error("sender.Send failed", "id", data.ID(), "err", err)
+		fw.publish(EventFailed, data.ID(), msg, res, err)
+		// Release this data so it may be retrieved again at a
+		// later time.
+		data.Close()
+		return err
+	} else {
+		log.Info("Sent the message",
+				"id", data.ID(),
+				"message_id", res.MessageID,
+				"request_id", msg.Attributes[fw.opts.RequestIDAttr],
+				"sequence", res.SequenceNumber,
+				"attempts", res.Attempts,
+				"duration", res.Duration,
+		)
+
+		fw.mutex.Lock()
+		fw.sent++
+		fw.mutex.Unlock()
+		fw.publish(EventSent, data.ID(), msg, res, nil)
+	}
+
+	_, removeSpan := tracer.Start(ctx, "local_storage.Remove")
+	removeErr := data.Remove()
+	endSpan(removeSpan, removeErr)
+	if removeErr != nil {
+		log.Error("local_store.Remove failed", "err", removeErr)
+		// Release the data, although it's already been sent.
+		data.Close()
+	}
+	return err
+}
+
+// publish an event of type typ for the message identified by id, which was
+// sent as res, or failed with err (if not nil).
+func (fw *Forwarder) publish(typ EventType, id string, msg sender.Message, res sender.SendResult, err error) {
+	if fw.opts.OnEvent == nil {
+		return
+	}
+
+	fw.opts.OnEvent(Event{
+		Type: typ,
+		ID: id,
+		Message: msg,
+		Result: res,
+		Err: err,
+	})
+}
+
+// endSpan ends span, recording err (if any).
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Sent retrieves the number of messages sent since the forwarder started.
+func (fw *Forwarder) Sent() int {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+
+	return fw.sent
+}
+
+// Drain wakes the forwarder immediately, probing the receiver even if the
+// circuit breaker is open. If timeout is positive, Drain blocks until
+// either the backlog drains or timeout expires. Nothing is sent while
+// forwarding is paused, so Drain returns right away.
+func (fw *Forwarder) Drain(timeout time.Duration) DrainResult {
+	start := fw.Sent()
+	if fw.Paused() {
+		remaining := fw.store.Count()
+		return DrainResult{
+			Remaining: remaining,
+			Drained: remaining == 0,
+		}
+	}
+
+	if fw.opts.Breaker != nil {
+		fw.opts.Breaker.Probe()
+	}
+	fw.Wake()
+
+	deadline := time.Now().Add(timeout)
+	for fw.store.Count() > 0 && time.Now().Before(deadline) {
+		time.Sleep(drainPollInterval)
+	}
+
+	remaining := fw.store.Count()
+	return DrainResult{
+		Sent: fw.Sent() - start,
+		Remaining: remaining,
+		Drained: remaining == 0,
+	}
+}
@@ -0,0 +1,490 @@
+package forwarder
+
+import (
+	"errors"
+	"github.com/SirGFM/sqs-issue-notifier/server/health"
+	"github.com/SirGFM/sqs-issue-notifier/server/local_storage"
+	"github.com/SirGFM/sqs-issue-notifier/server/sender"
+	"github.com/SirGFM/sqs-issue-notifier/server/sendertest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newStore creates an empty local storage, closed once the test ends.
+func newStore(t *testing.T) local_storage.Store {
+	store := local_storage.NewFS(t.TempDir(), 0)
+	t.Cleanup(func() {
+		store.Close()
+	})
+	return store
+}
+
+// storeAll stores every message in store.
+func storeAll(t *testing.T, store local_storage.Store, msgs ...string) {
+	for _, msg := range msgs {
+		if err := store.Store([]byte(msg)); err != nil {
+			t.Fatalf("Store: Failed to store '%s': %+v", msg, err)
+		}
+	}
+}
+
+// waitEmpty waits until store is empty, reporting whether it emptied in
+// time.
+func waitEmpty(store local_storage.Store, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for store.Count() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return store.Count() == 0
+}
+
+// events records the events reported by a Forwarder.
+type events struct {
+	// Synchronizes access to list.
+	mutex sync.Mutex
+
+	// Every event, in order.
+	list []Event
+}
+
+func (e *events) record(ev Event) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.list = append(e.list, ev)
+}
+
+// types retrieves the type of every event, in order.
+func (e *events) types() []EventType {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	var types []EventType
+	for _, ev := range e.list {
+		types = append(types, ev.Type)
+	}
+	return types
+}
+
+// TestForward checks that every stored message is sent and removed from the
+// local storage.
+func TestForward(t *testing.T) {
+	store := newStore(t)
+	s := sendertest.New()
+	var evs events
+
+	fw := New(store, s, Options{OnEvent: evs.record})
+	storeAll(t, store, "'Twas brillig, and the slithy toves", "Did gyre and gimble in the wabe;")
+	fw.Start()
+	defer fw.Stop()
+
+	if !s.WaitFor(2, time.Second) {
+		t.Fatalf("Send: Expected 2 messages but got %d", len(s.Messages()))
+	}
+	if !waitEmpty(store, time.Second) {
+		t.Errorf("Remove: Expected the local storage to be empty but it has %d messages", store.Count())
+	}
+	sent := make(map[string]bool)
+	for _, msg := range s.Messages() {
+		sent[msg.Body] = true
+	}
+	for _, want := range []string{"'Twas brillig, and the slithy toves", "Did gyre and gimble in the wabe;"} {
+		if !sent[want] {
+			t.Errorf("Send: Expected '%s' to be sent", want)
+		}
+	}
+
+	if got := fw.Sent(); got != 2 {
+		t.Errorf("Sent: Expected 2 but got %d", got)
+	}
+	evs.mutex.Lock()
+	for i, ev := range evs.list {
+		if ev.Type != EventSent || len(ev.Result.MessageID) == 0 {
+			t.Errorf("%d: OnEvent: Expected a sent message but got '%+v'", i, ev)
+		}
+	}
+	evs.mutex.Unlock()
+}
+
+// TestFailures checks that messages that fail to be sent are retried, and
+// that rejected messages are either dead-lettered or discarded.
+func TestFailures(t *testing.T) {
+	test_cases := []struct{
+		err error
+		deadLetter bool
+		sent int
+		deadLetters int
+	}{
+		{ err: sender.ErrTemporary, sent: 1 },
+		{ err: sender.ErrUnreachable, deadLetter: true, sent: 1 },
+		{ err: sender.ErrRejected, sent: 0 },
+		{ err: sender.ErrInvalidInput, deadLetter: true, sent: 0, deadLetters: 1 },
+	}
+
+	for i, tc := range test_cases {
+		store := newStore(t)
+		s := sendertest.New()
+		s.FailNext(1, tc.err)
+		var evs events
+
+		fw := New(store, s, Options{
+			DeadLetter: tc.deadLetter,
+			OnEvent: evs.record,
+		})
+		storeAll(t, store, "All mimsy were the borogoves,")
+		fw.Start()
+
+		if !waitEmpty(store, time.Second) {
+			t.Errorf("%d: Expected the local storage to be empty but it has %d messages", i, store.Count())
+		}
+		fw.Stop()
+
+		if got := len(s.Messages()); got != tc.sent {
+			t.Errorf("%d: Send: Expected %d messages but got %d", i, tc.sent, got)
+		}
+		if dl, err := store.DeadLetters(); err != nil {
+			t.Errorf("%d: DeadLetters: Failed to list the dead letters: %+v", i, err)
+		} else if len(dl) != tc.deadLetters {
+			t.Errorf("%d: DeadLetters: Expected %d dead letters but got %d", i, tc.deadLetters, len(dl))
+		}
+		if types := evs.types(); len(types) == 0 || types[0] != EventFailed {
+			t.Errorf("%d: OnEvent: Expected the failure to be reported first but got %v", i, types)
+		}
+	}
+}
+
+// TestPause checks that nothing is sent while paused, and that the health
+// reflects it.
+func TestPause(t *testing.T) {
+	store := newStore(t)
+	s := sendertest.New()
+	hr := health.NewRegistry()
+
+	fw := New(store, s, Options{
+		Paused: true,
+		Health: hr,
+	})
+	storeAll(t, store, "And the mome raths outgrabe.")
+	fw.Start()
+	defer fw.Stop()
+
+	if s.WaitFor(1, 100 * time.Millisecond) {
+		t.Errorf("Send: Sent a message while paused")
+	}
+	if got, _ := hr.Get(DefaultComponent); got.Status != health.Degraded {
+		t.Errorf("Pause: Expected the forwarder to be '%s' but got '%+v'", health.Degraded, got)
+	}
+	if res := fw.Drain(time.Second); res.Drained || res.Remaining != 1 {
+		t.Errorf("Drain: Expected nothing to drain while paused but got '%+v'", res)
+	}
+
+	fw.Resume()
+	if !s.WaitFor(1, 2 * time.Second) {
+		t.Errorf("Send: The message wasn't sent once resumed")
+	}
+	if got, _ := hr.Get(DefaultComponent); got.Status != health.OK {
+		t.Errorf("Resume: Expected the forwarder to be '%s' but got '%+v'", health.OK, got)
+	}
+}
+
+// TestDrainBreaker checks that draining probes an open circuit breaker,
+// instead of waiting for its cooldown.
+func TestDrainBreaker(t *testing.T) {
+	store := newStore(t)
+	s := sendertest.New()
+	s.FailNext(1, sender.ErrUnreachable)
+	breaker := sender.NewCircuitBreaker(s, 1, time.Hour)
+
+	fw := New(store, breaker, Options{Breaker: breaker})
+	storeAll(t, store, "Beware the Jabberwock, my son!")
+	fw.Start()
+	defer fw.Stop()
+
+	// The first attempt opens the breaker.
+	deadline := time.Now().Add(time.Second)
+	for breaker.State() != sender.BreakerOpen && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if breaker.State() != sender.BreakerOpen {
+		t.Fatalf("Send: Expected the breaker to open")
+	}
+
+	res := fw.Drain(2 * time.Second)
+	if !res.Drained || res.Sent != 1 || res.Remaining != 0 {
+		t.Errorf("Drain: Expected the message to be sent but got '%+v'", res)
+	}
+}
+
+// slowProbe fails its first message, opening the circuit breaker, and then
+// takes delay to send each message.
+type slowProbe struct {
+	// How long each message after the first takes to be sent.
+	delay time.Duration
+
+	// Synchronizes access to calls.
+	mutex sync.Mutex
+
+	// Number of calls to Send.
+	calls int
+}
+
+func (s *slowProbe) Send(msg sender.Message) (sender.SendResult, error) {
+	s.mutex.Lock()
+	s.calls++
+	first := s.calls == 1
+	s.mutex.Unlock()
+
+	if first {
+		return sender.SendResult{}, sender.ErrUnreachable
+	}
+	time.Sleep(s.delay)
+	return sender.SendResult{}, nil
+}
+
+// refusals counts the messages refused by an open (or half-open) circuit
+// breaker.
+type refusals struct {
+	// The circuit breaker.
+	s sender.Sender
+
+	// Synchronizes access to count.
+	mutex sync.Mutex
+
+	// Number of messages refused.
+	count int
+}
+
+func (r *refusals) Send(msg sender.Message) (sender.SendResult, error) {
+	res, err := r.s.Send(msg)
+	if errors.Is(err, sender.ErrCircuitOpen) {
+		r.mutex.Lock()
+		r.count++
+		r.mutex.Unlock()
+	}
+	return res, err
+}
+
+// TestHalfOpenBreaker checks that the workers refused by a half-open
+// circuit breaker wait while it's being probed, instead of spinning over
+// the local storage.
+func TestHalfOpenBreaker(t *testing.T) {
+	store := newStore(t)
+	probe := &slowProbe{delay: 300 * time.Millisecond}
+	breaker := sender.NewCircuitBreaker(probe, 1, 10 * time.Millisecond)
+	r := &refusals{s: breaker}
+
+	fw := New(store, r, Options{Workers: 3, Breaker: breaker})
+	storeAll(t, store,
+		"One, two! One, two! And through and through",
+		"The vorpal blade went snicker-snack!",
+		"He left it dead, and with its head",
+	)
+	fw.Start()
+	defer fw.Stop()
+
+	if !waitEmpty(store, 5 * time.Second) {
+		t.Fatalf("Send: Expected every message to be sent")
+	}
+
+	// Each idle worker retries at most every workerIdleInterval while
+	// the slow messages are sent.
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if max := 3 * int(4 * probe.delay / workerIdleInterval); r.count > max {
+		t.Errorf("Send: Expected at most %d refused messages but got %d", max, r.count)
+	}
+}
+
+// TestStop checks that nothing is sent once stopped, even though the local
+// storage is still open.
+func TestStop(t *testing.T) {
+	store := newStore(t)
+	s := sendertest.New()
+
+	fw := New(store, s, Options{Workers: 3})
+	fw.Start()
+
+	done := make(chan struct{})
+	go func() {
+		fw.Stop()
+		close(done)
+	} ()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Stop: The workers didn't stop")
+	}
+
+	storeAll(t, store, "The jaws that bite, the claws that catch!")
+	if s.WaitFor(1, 100 * time.Millisecond) {
+		t.Errorf("Send: Sent a message after being stopped")
+	}
+
+	// Stopping again does nothing.
+	fw.Stop()
+}
+
+// TestBackoff checks that the workers back off after consecutive failures,
+// up to the policy's cap, and that sending a message resets it.
+func TestBackoff(t *testing.T) {
+	policy := BackoffPolicy{
+		BaseDelay: 10 * time.Millisecond,
+		MaxDelay: 30 * time.Millisecond,
+	}
+	for i, want := range []time.Duration{10, 20, 30, 30} {
+		if got := policy.delay(i + 1); got != want * time.Millisecond {
+			t.Errorf("%d: delay: Expected %s but got %s", i, want * time.Millisecond, got)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 1; i < 10; i++ {
+		if got := policy.delay(i); got < 5 * time.Millisecond || got > 30 * time.Millisecond {
+			t.Errorf("%d: delay: Expected the jittered delay to be within [5ms, 30ms] but got %s", i, got)
+		}
+	}
+
+	// Without an upper bound, the delay stops doubling before it
+	// overflows.
+	unbounded := BackoffPolicy{BaseDelay: time.Second}
+	if got, want := unbounded.delay(100), time.Second << 33; got != want {
+		t.Errorf("delay: Expected %s but got %s", want, got)
+	}
+
+	store := newStore(t)
+	s := sendertest.New()
+	s.FailNext(3, sender.ErrUnreachable)
+
+	fw := New(store, s, Options{
+		Backoff: BackoffPolicy{
+			BaseDelay: 100 * time.Millisecond,
+			MaxDelay: 200 * time.Millisecond,
+		},
+	})
+	storeAll(t, store, "He took his vorpal sword in hand;")
+	start := time.Now()
+	fw.Start()
+	defer fw.Stop()
+
+	// Wait for the second failure.
+	deadline := time.Now().Add(time.Second)
+	for fw.Backoff() < 200 * time.Millisecond && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := fw.Backoff(); got != 200 * time.Millisecond {
+		t.Errorf("Backoff: Expected 200ms after the second failure but got %s", got)
+	}
+
+	if !s.WaitFor(1, 2 * time.Second) {
+		t.Fatalf("Send: The message wasn't sent after backing off")
+	}
+	// Backing off after each failure: 100ms + 200ms + 200ms.
+	if took := time.Since(start); took < 500 * time.Millisecond {
+		t.Errorf("Send: Expected to back off for at least 500ms but took %s", took)
+	}
+	deadline = time.Now().Add(time.Second)
+	for fw.Backoff() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := fw.Backoff(); got != 0 {
+		t.Errorf("Backoff: Expected it to be reset after sending but got %s", got)
+	}
+}
+
+// TestSetBackoff checks that changing the backoff policy applies to the
+// next failure.
+func TestSetBackoff(t *testing.T) {
+	store := newStore(t)
+	s := sendertest.New()
+	s.FailNext(1, sender.ErrUnreachable)
+
+	fw := New(store, s, Options{
+		Backoff: BackoffPolicy{BaseDelay: 10 * time.Millisecond},
+	})
+	fw.SetBackoff(BackoffPolicy{BaseDelay: 300 * time.Millisecond})
+	storeAll(t, store, "One, two! One, two! And through and through")
+	fw.Start()
+	defer fw.Stop()
+
+	deadline := time.Now().Add(time.Second)
+	for fw.Backoff() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if want, got := 300 * time.Millisecond, fw.Backoff(); want != got {
+		t.Errorf("Backoff: Expected %s after the failure but got %s", want, got)
+	}
+	if !s.WaitFor(1, 2 * time.Second) {
+		t.Fatalf("Send: The message wasn't sent after backing off")
+	}
+}
+
+// TestWake checks that waking the forwarder stops it from backing off, and
+// that its activity is tracked.
+func TestWake(t *testing.T) {
+	store := newStore(t)
+	s := sendertest.New()
+	s.FailNext(1, sender.ErrTemporary)
+
+	fw := New(store, s, Options{
+		Backoff: BackoffPolicy{BaseDelay: time.Hour},
+	})
+	start := fw.LastActive()
+	storeAll(t, store, "Long time the manxome foe he sought—")
+	fw.Start()
+	defer fw.Stop()
+
+	deadline := time.Now().Add(time.Second)
+	for fw.Backoff() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if fw.Backoff() != time.Hour {
+		t.Fatalf("Backoff: Expected to back off for 1h but got %s", fw.Backoff())
+	}
+	if !fw.LastActive().After(start) {
+		t.Errorf("LastActive: Expected the failure to count as activity")
+	}
+
+	fw.Wake()
+	if !s.WaitFor(1, time.Second) {
+		t.Errorf("Wake: The message wasn't sent once woken")
+	}
+}
+
+// TestStopConcurrent checks that the forwarder may be stopped from many
+// goroutines at once, even before it's started.
+func TestStopConcurrent(t *testing.T) {
+	store := newStore(t)
+	s := sendertest.New()
+
+	idle := New(store, s, Options{})
+	idle.Stop()
+
+	fw := New(store, s, Options{Workers: 2})
+	fw.Start()
+	storeAll(t, store, "The frumious Bandersnatch!")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fw.Stop()
+		} ()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	} ()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Stop: The workers didn't stop")
+	}
+
+	// Starting a stopped forwarder does nothing.
+	fw.Start()
+	fw.Stop()
+}
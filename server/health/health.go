@@ -0,0 +1,188 @@
+/*
+Package health implements a registry where components report how healthy
+they are, so the state of the whole service may be inspected (e.g., by a
+readiness probe) instead of inferred from its logs.
+
+Each component reports its Status (OK, Degraded or Failed) along with the
+reason, through "Registry.Set()". The registry keeps since when each
+component has been on its current status, so a report may read as
+"sender: degraded for 32m0s (buffering locally, the queue is unreachable)".
+The service as a whole is as healthy as its least healthy component.
+
+Example:
+
+	reg := health.NewRegistry()
+	reg.OnChange(func(r health.Report) {
+		log.Println(r)
+	})
+
+	reg.Set("sender", health.Degraded, "the queue is unreachable")
+
+	if reg.Overall() != health.OK {
+		for _, r := range reg.Reports() {
+			fmt.Println(r)
+		}
+	}
+*/
+package health
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Status is how healthy a component is.
+type Status int
+
+const (
+	// The component works as expected.
+	OK Status = iota
+	// The component works, but not as expected (e.g., messages are kept
+	// locally as the receiver is unreachable).
+	Degraded
+	// The component doesn't work.
+	Failed
+)
+
+func (s Status) String() string {
+	switch s {
+	case OK:
+		return "ok"
+	case Degraded:
+		return "degraded"
+	case Failed:
+		return "failed"
+	default:
+		return fmt.Sprintf("Status(%d)", int(s))
+	}
+}
+
+func (s Status) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Report is the latest health reported by a component.
+type Report struct {
+	// The reporting component.
+	Component string
+
+	// How healthy the component is.
+	Status Status
+
+	// Why the component isn't healthy. Empty if it's OK.
+	Reason string `json:",omitempty"`
+
+	// Since when the component has been on its current status.
+	Since time.Time
+}
+
+// String describes the report (e.g., "sender: degraded for 32m0s (the
+// queue is unreachable)").
+func (r Report) String() string {
+	msg := r.Component + ": " + r.Status.String()
+	if r.Status != OK {
+		msg += " for " + time.Since(r.Since).Round(time.Second).String()
+	}
+	if len(r.Reason) > 0 {
+		msg += " (" + r.Reason + ")"
+	}
+	return msg
+}
+
+// Registry keeps the latest report of every component.
+type Registry struct {
+	// Synchronizes access to the other fields.
+	mutex sync.Mutex
+
+	// The latest report of each component, by its name.
+	reports map[string]Report
+
+	// Called whenever a component's status changes.
+	hooks []func(Report)
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		reports: make(map[string]Report),
+	}
+}
+
+// Set the status of component, along with the reason (which should be
+// empty if it's OK). The component is considered on this status since the
+// first time it was reported, regardless of the reason.
+func (r *Registry) Set(component string, status Status, reason string) {
+	r.mutex.Lock()
+	prev, ok := r.reports[component]
+	report := Report{
+		Component: component,
+		Status: status,
+		Reason: reason,
+		Since: prev.Since,
+	}
+	changed := !ok || prev.Status != status
+	if changed {
+		report.Since = time.Now()
+	}
+	r.reports[component] = report
+	hooks := r.hooks
+	r.mutex.Unlock()
+
+	if changed {
+		for _, fn := range hooks {
+			fn(report)
+		}
+	}
+}
+
+// Get the latest report of component, if it was ever reported.
+func (r *Registry) Get(component string) (Report, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	report, ok := r.reports[component]
+	return report, ok
+}
+
+// Reports retrieves the latest report of every component, sorted by their
+// names.
+func (r *Registry) Reports() []Report {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	reports := make([]Report, 0, len(r.reports))
+	for _, report := range r.reports {
+		reports = append(reports, report)
+	}
+	slices.SortFunc(reports, func(a, b Report) int {
+		return strings.Compare(a.Component, b.Component)
+	})
+	return reports
+}
+
+// Overall retrieves the status of the least healthy component (or OK, if
+// none was reported).
+func (r *Registry) Overall() Status {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	status := OK
+	for _, report := range r.reports {
+		status = max(status, report.Status)
+	}
+	return status
+}
+
+// OnChange registers fn to be called whenever a component's status changes
+// (including when it's first reported).
+func (r *Registry) OnChange(fn func(Report)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// Copy the hooks, so Set may call them without holding the lock.
+	hooks := make([]func(Report), 0, len(r.hooks) + 1)
+	r.hooks = append(append(hooks, r.hooks...), fn)
+}
@@ -0,0 +1,76 @@
+package health
+
+import (
+	"testing"
+	"time"
+)
+
+// TestRegistry checks that reports are kept since their status changed, and
+// aggregated into the least healthy status.
+func TestRegistry(t *testing.T) {
+	reg := NewRegistry()
+	if got := reg.Overall(); got != OK {
+		t.Errorf("Overall: Expected '%s' but got '%s'", OK, got)
+	}
+
+	var changes []Report
+	reg.OnChange(func(r Report) {
+		changes = append(changes, r)
+	})
+
+	reg.Set("store", OK, "")
+	reg.Set("sender", Degraded, "the queue is unreachable")
+	first, _ := reg.Get("sender")
+
+	time.Sleep(10 * time.Millisecond)
+	reg.Set("sender", Degraded, "the queue is still unreachable")
+	got, ok := reg.Get("sender")
+	if !ok {
+		t.Fatalf("Get: Expected a report for 'sender'")
+	} else if !got.Since.Equal(first.Since) {
+		t.Errorf("Get: Expected the report since '%s' but got '%s'", first.Since, got.Since)
+	} else if want := "the queue is still unreachable"; got.Reason != want {
+		t.Errorf("Get: Expected reason '%s' but got '%s'", want, got.Reason)
+	}
+	if got := reg.Overall(); got != Degraded {
+		t.Errorf("Overall: Expected '%s' but got '%s'", Degraded, got)
+	}
+
+	reg.Set("store", Failed, "no space left on device")
+	if got := reg.Overall(); got != Failed {
+		t.Errorf("Overall: Expected '%s' but got '%s'", Failed, got)
+	}
+
+	reports := reg.Reports()
+	if len(reports) != 2 || reports[0].Component != "sender" || reports[1].Component != "store" {
+		t.Errorf("Reports: Expected 'sender' and 'store' but got '%+v'", reports)
+	}
+
+	// Only changes of status are reported.
+	if len(changes) != 3 {
+		t.Errorf("OnChange: Expected 3 changes but got '%+v'", changes)
+	}
+}
+
+// TestReportString checks how reports are described.
+func TestReportString(t *testing.T) {
+	test_cases := []struct{
+		report Report
+		want string
+	}{
+		{
+			report: Report{Component: "store", Status: OK, Since: time.Now()},
+			want: "store: ok",
+		},
+		{
+			report: Report{Component: "sender", Status: Degraded, Reason: "the queue is unreachable", Since: time.Now().Add(-32 * time.Minute)},
+			want: "sender: degraded for 32m0s (the queue is unreachable)",
+		},
+	}
+
+	for i, tc := range test_cases {
+		if got := tc.report.String(); got != tc.want {
+			t.Errorf("%d: String: Expected '%s' but got '%s'", i, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package idempotency
+
+type error_code uint
+
+const (
+	// A request with the same key is still being handled.
+	ErrInFlight error_code = iota
+	// The key was already used by a different request.
+	ErrMismatch
+)
+
+func (e error_code) Error() string {
+	switch e {
+	case ErrInFlight:
+		return "A request with the same key is still being handled."
+	case ErrMismatch:
+		return "The key was already used by a different request."
+	default:
+		return "Invalid idempotency error."
+	}
+}
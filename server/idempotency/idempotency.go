@@ -0,0 +1,147 @@
+/*
+Package idempotency remembers the responses of requests identified by an
+idempotency key, so retried requests may be answered with the original
+response instead of being handled again.
+
+Each key is reserved by "Begin()" while its request is handled, and then
+either completed with the request's response, by "Complete()", or released,
+by "Abort()" (e.g., if the request failed and may be retried). Responses are
+remembered for a fixed window, after which the key may be reused.
+
+Example:
+
+	c := idempotency.New(time.Hour)
+
+	resp, err := c.Begin(key, bodyHash)
+	if err != nil {
+		// reply with a conflict
+	} else if resp != nil {
+		// reply with resp
+	} else {
+		// handle the request, then either call c.Complete(key, resp) or
+		// c.Abort(key)
+	}
+*/
+package idempotency
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Response is a response remembered for a key.
+type Response struct {
+	// The response's status code.
+	Status int
+
+	// The response's headers.
+	Header http.Header
+
+	// The response's body.
+	Body []byte
+}
+
+// entry is the state of a single key.
+type entry struct {
+	// Identifies the request that used the key (e.g., a hash of its body).
+	fingerprint string
+
+	// The response. Nil while the request is being handled.
+	resp *Response
+
+	// When the entry may be discarded. Only set once completed.
+	expiresAt time.Time
+}
+
+// Cache remembers the responses for each key.
+type Cache struct {
+	// For how long responses are remembered.
+	window time.Duration
+
+	// Synchronizes access to the fields below.
+	mutex sync.Mutex
+
+	// The state of each key.
+	entries map[string]*entry
+
+	// When the expired entries were last discarded.
+	cleanedAt time.Time
+}
+
+// New creates a Cache that remembers responses for window.
+func New(window time.Duration) *Cache {
+	return &Cache{
+		window: window,
+		entries: make(map[string]*entry),
+		cleanedAt: time.Now(),
+	}
+}
+
+// Begin reserves key for a request identified by fingerprint. If the key
+// was already completed by the same request, the original response is
+// returned. Otherwise, it returns nil and the request should be handled.
+//
+// Fails with ErrInFlight if the key is still reserved, and with
+// ErrMismatch if the key was used by a different request.
+func (c *Cache) Begin(key, fingerprint string) (*Response, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.cleanedAt) >= c.window {
+		for k, e := range c.entries {
+			if e.resp != nil && now.After(e.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		c.cleanedAt = now
+	}
+
+	e, found := c.entries[key]
+	if found && e.resp != nil && now.After(e.expiresAt) {
+		found = false
+	}
+
+	if !found {
+		c.entries[key] = &entry{
+			fingerprint: fingerprint,
+		}
+		return nil, nil
+	} else if e.fingerprint != fingerprint {
+		return nil, ErrMismatch
+	} else if e.resp == nil {
+		return nil, ErrInFlight
+	}
+
+	return e.resp, nil
+}
+
+// Complete the request that reserved key, remembering its response.
+func (c *Cache) Complete(key string, resp Response) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		e.resp = &resp
+		e.expiresAt = time.Now().Add(c.window)
+	}
+}
+
+// Abort releases key, so the request may be retried.
+func (c *Cache) Abort(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if e, ok := c.entries[key]; ok && e.resp == nil {
+		delete(c.entries, key)
+	}
+}
+
+// Len retrieves how many keys are currently remembered.
+func (c *Cache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return len(c.entries)
+}
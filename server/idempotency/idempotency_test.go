@@ -0,0 +1,46 @@
+package idempotency
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// TestCache checks that completed keys return the original response, while
+// in-flight and mismatched keys are refused.
+func TestCache(t *testing.T) {
+	c := New(50 * time.Millisecond)
+
+	if resp, err := c.Begin("a", "body-1"); resp != nil || err != nil {
+		t.Fatalf("Begin: Expected a new key but got '%+v' (%+v)", resp, err)
+	}
+	if _, err := c.Begin("a", "body-1"); err != ErrInFlight {
+		t.Errorf("Begin: Expected error '%+v' but got '%+v'", ErrInFlight, err)
+	}
+
+	want := Response{Status: 204, Body: []byte("done")}
+	c.Complete("a", want)
+
+	resp, err := c.Begin("a", "body-1")
+	if err != nil || resp == nil {
+		t.Fatalf("Begin: Expected the original response but got '%+v' (%+v)", resp, err)
+	} else if resp.Status != want.Status || !bytes.Equal(resp.Body, want.Body) {
+		t.Errorf("Begin: Expected '%+v' but got '%+v'", want, *resp)
+	}
+	if _, err := c.Begin("a", "body-2"); err != ErrMismatch {
+		t.Errorf("Begin: Expected error '%+v' but got '%+v'", ErrMismatch, err)
+	}
+
+	// Aborted keys may be retried.
+	c.Begin("b", "body-1")
+	c.Abort("b")
+	if resp, err := c.Begin("b", "body-1"); resp != nil || err != nil {
+		t.Errorf("Begin: Expected the aborted key to be reusable but got '%+v' (%+v)", resp, err)
+	}
+
+	// Expired keys may be reused, even by a different request.
+	time.Sleep(60 * time.Millisecond)
+	if resp, err := c.Begin("a", "body-2"); resp != nil || err != nil {
+		t.Errorf("Begin: Expected the expired key to be reusable but got '%+v' (%+v)", resp, err)
+	}
+}
@@ -0,0 +1,17 @@
+package ipfilter
+
+type error_code uint
+
+const (
+	// The address (or network) couldn't be parsed.
+	ErrInvalidAddress error_code = iota
+)
+
+func (e error_code) Error() string {
+	switch e {
+	case ErrInvalidAddress:
+		return "The address (or network) couldn't be parsed."
+	default:
+		return "Invalid ipfilter error."
+	}
+}
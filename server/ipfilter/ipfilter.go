@@ -0,0 +1,147 @@
+/*
+Package ipfilter restricts which clients may access the server, based on
+their IP address.
+
+Clients are checked against a list of allowed networks and a list of denied
+networks, in CIDR notation (e.g., "10.0.0.0/8"). Denied networks take
+precedence over allowed ones, and, if no network is allowed, every client
+that isn't denied is accepted.
+
+Clients behind a reverse proxy reach the server from the proxy's address.
+So, the client's actual address may be retrieved from the X-Forwarded-For
+(or X-Real-IP) header, but only if the request came from a trusted proxy,
+since anyone may set the header.
+
+Example:
+
+	allow, err := ipfilter.ParsePrefixes([]string{"10.0.0.0/8"})
+	if err != nil {
+		// handle err
+	}
+	proxies, err := ipfilter.ParsePrefixes([]string{"127.0.0.1"})
+	if err != nil {
+		// handle err
+	}
+	f := ipfilter.New(allow, nil)
+
+	ip, err := ipfilter.ClientIP(req, proxies)
+	if err != nil || !f.Allows(ip) {
+		// reply with 403
+	}
+*/
+package ipfilter
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ParsePrefixes parses a list of networks in CIDR notation. Addresses
+// without a prefix length (e.g., "192.168.0.1") match only themselves.
+func ParsePrefixes(list []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, item := range list {
+		if strings.Contains(item, "/") {
+			prefix, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, ErrInvalidAddress
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, ErrInvalidAddress
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// contains checks whether any of prefixes contains addr.
+func contains(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter accepts clients based on their IP address.
+type Filter struct {
+	// Networks of the accepted clients. If empty, every client that isn't
+	// denied is accepted.
+	allow []netip.Prefix
+
+	// Networks of the rejected clients.
+	deny []netip.Prefix
+}
+
+// New creates a Filter that accepts clients in the networks allow (or any
+// client, if it's empty), unless they are in the networks deny.
+func New(allow, deny []netip.Prefix) *Filter {
+	return &Filter{
+		allow: allow,
+		deny: deny,
+	}
+}
+
+// Allows checks whether the client with the address addr is accepted.
+func (f *Filter) Allows(addr netip.Addr) bool {
+	if contains(f.deny, addr) {
+		return false
+	}
+	return len(f.allow) == 0 || contains(f.allow, addr)
+}
+
+// ClientIP retrieves the IP address of the client that sent req. If the
+// request came from one of the trusted proxies, the address is taken from
+// the X-Forwarded-For header: the last address in it that isn't a trusted
+// proxy (i.e., the one appended by the outermost trusted proxy), as the
+// addresses before it could've been set by the client itself. Proxies that
+// only set X-Real-IP, instead of X-Forwarded-For, are also supported.
+func ClientIP(req *http.Request, trusted []netip.Prefix) (netip.Addr, error) {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, ErrInvalidAddress
+	}
+	addr = addr.Unmap()
+
+	if !contains(trusted, addr) {
+		return addr, nil
+	}
+
+	// Every proxy appends the address it received the request from, so
+	// walk the header backwards, until the first untrusted address.
+	var hops []string
+	for _, hdr := range req.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(hdr, ",")...)
+	}
+	if len(hops) == 0 {
+		hops = req.Header.Values("X-Real-IP")
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// Whatever is left can't be trusted.
+			break
+		}
+		addr = hop.Unmap()
+
+		if !contains(trusted, addr) {
+			break
+		}
+	}
+
+	return addr, nil
+}
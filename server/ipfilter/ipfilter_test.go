@@ -0,0 +1,106 @@
+package ipfilter
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+// mustParse parses list, failing the test on errors.
+func mustParse(t *testing.T, list ...string) []netip.Prefix {
+	prefixes, err := ParsePrefixes(list)
+	if err != nil {
+		t.Fatalf("ParsePrefixes: Failed to parse '%+v': %+v", list, err)
+	}
+	return prefixes
+}
+
+// TestParsePrefixes checks that both networks and single addresses are
+// accepted.
+func TestParsePrefixes(t *testing.T) {
+	prefixes := mustParse(t, "10.1.2.3/8", "192.168.0.1", "2001:db8::/32")
+
+	want := []string{"10.0.0.0/8", "192.168.0.1/32", "2001:db8::/32"}
+	for i, p := range prefixes {
+		if p.String() != want[i] {
+			t.Errorf("%d: Expected '%s' but got '%s'", i, want[i], p)
+		}
+	}
+
+	for _, item := range []string{"10.0.0.0/33", "localhost", ""} {
+		if _, err := ParsePrefixes([]string{item}); err != ErrInvalidAddress {
+			t.Errorf("(%s) ParsePrefixes: Expected error '%+v' but got '%+v'", item, ErrInvalidAddress, err)
+		}
+	}
+}
+
+// TestAllows checks that denied networks take precedence over allowed
+// ones.
+func TestAllows(t *testing.T) {
+	test_cases := []struct{
+		filter *Filter
+		addr string
+		want bool
+	}{
+		{ filter: New(nil, nil), addr: "203.0.113.1", want: true },
+		{ filter: New(mustParse(t, "10.0.0.0/8"), nil), addr: "10.1.1.1", want: true },
+		{ filter: New(mustParse(t, "10.0.0.0/8"), nil), addr: "203.0.113.1", want: false },
+		{ filter: New(mustParse(t, "10.0.0.0/8"), nil), addr: "::ffff:10.1.1.1", want: true },
+		{ filter: New(nil, mustParse(t, "203.0.113.0/24")), addr: "203.0.113.1", want: false },
+		{ filter: New(nil, mustParse(t, "203.0.113.0/24")), addr: "198.51.100.1", want: true },
+		{ filter: New(mustParse(t, "10.0.0.0/8"), mustParse(t, "10.6.6.6")), addr: "10.6.6.6", want: false },
+	}
+
+	for i, tc := range test_cases {
+		if got := tc.filter.Allows(netip.MustParseAddr(tc.addr)); got != tc.want {
+			t.Errorf("%d: Allows(%s): Expected '%t' but got '%t'", i, tc.addr, tc.want, got)
+		}
+	}
+}
+
+// TestClientIP checks that X-Forwarded-For (and X-Real-IP) is only used for
+// requests from trusted proxies, and only up to the first untrusted address.
+func TestClientIP(t *testing.T) {
+	trusted := mustParse(t, "127.0.0.1", "10.0.0.0/8")
+
+	test_cases := []struct{
+		remote string
+		xff []string
+		realIP string
+		want string
+	}{
+		{ remote: "203.0.113.1:1234", want: "203.0.113.1" },
+		// Untrusted clients can't spoof their address.
+		{ remote: "203.0.113.1:1234", xff: []string{"198.51.100.1"}, want: "203.0.113.1" },
+		{ remote: "127.0.0.1:1234", want: "127.0.0.1" },
+		{ remote: "127.0.0.1:1234", xff: []string{"198.51.100.1"}, want: "198.51.100.1" },
+		// The client prepended a fake address.
+		{ remote: "127.0.0.1:1234", xff: []string{"192.0.2.1, 198.51.100.1"}, want: "198.51.100.1" },
+		// Through a chain of trusted proxies.
+		{ remote: "127.0.0.1:1234", xff: []string{"198.51.100.1, 10.0.0.2", "10.0.0.3"}, want: "198.51.100.1" },
+		{ remote: "127.0.0.1:1234", xff: []string{"garbage, 10.0.0.2"}, want: "10.0.0.2" },
+		{ remote: "[::1]:1234", xff: []string{"198.51.100.1"}, want: "::1" },
+		{ remote: "127.0.0.1:1234", realIP: "198.51.100.1", want: "198.51.100.1" },
+		{ remote: "203.0.113.1:1234", realIP: "198.51.100.1", want: "203.0.113.1" },
+		// X-Forwarded-For takes precedence.
+		{ remote: "127.0.0.1:1234", xff: []string{"198.51.100.1"}, realIP: "192.0.2.1", want: "198.51.100.1" },
+	}
+
+	for i, tc := range test_cases {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tc.remote
+		for _, hdr := range tc.xff {
+			req.Header.Add("X-Forwarded-For", hdr)
+		}
+		if len(tc.realIP) > 0 {
+			req.Header.Set("X-Real-IP", tc.realIP)
+		}
+
+		addr, err := ClientIP(req, trusted)
+		if err != nil {
+			t.Errorf("%d: ClientIP: Failed to retrieve the address: %+v", i, err)
+		} else if addr.String() != tc.want {
+			t.Errorf("%d: ClientIP: Expected '%s' but got '%s'", i, tc.want, addr)
+		}
+	}
+}
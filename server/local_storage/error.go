@@ -1,5 +1,9 @@
 package local_storage
 
+import (
+	"fmt"
+)
+
 type error_code uint
 
 const (
@@ -21,6 +25,14 @@ const (
 	ErrTimedOut
 	// The local storage was closed.
 	ErrStoreClosed
+	// The requested data doesn't exist.
+	ErrNotFound
+	// The requested ID is invalid.
+	ErrInvalidID
+	// The data is currently retrieved, so it can't be modified.
+	ErrInFlight
+	// The requested priority is invalid.
+	ErrInvalidPriority
 )
 
 func (e error_code) Error() string {
@@ -43,7 +55,53 @@ func (e error_code) Error() string {
 		return "Wait timed out."
 	case ErrStoreClosed:
 		return "The local storage was closed."
+	case ErrNotFound:
+		return "The requested data doesn't exist."
+	case ErrInvalidID:
+		return "The requested ID is invalid."
+	case ErrInFlight:
+		return "The data is currently retrieved, so it can't be modified."
+	case ErrInvalidPriority:
+		return "The requested priority is invalid."
 	default:
 		return "Invalid local_storage error."
 	}
 }
+
+// Error reports why an operation on the local storage failed. It matches
+// its Code on errors.Is (e.g., ErrStoreFailed, as returned before), and
+// unwraps to its underlying cause, so callers may tell failures apart
+// (e.g., errors.Is(err, syscall.ENOSPC) if the disk is full, or
+// errors.Is(err, fs.ErrPermission)). Corrupted data isn't reported, as it's
+// quarantined instead.
+type Error struct {
+	// The operation that failed.
+	Op string
+
+	// What failed.
+	Code error_code
+
+	// The underlying cause.
+	Err error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("local_storage/%s: %s %+v", e.Op, e.Code, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Code
+}
+
+// wrap err, the reason why op failed with code.
+func wrap(op string, code error_code, err error) error {
+	return &Error{
+		Op: op,
+		Code: code,
+		Err: err,
+	}
+}
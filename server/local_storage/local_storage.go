@@ -17,6 +17,28 @@ goroutine stores new data.
 If the local storage isn't empty on boot, the next local storage will be
 properly signaled on start.
 
+Every change to the stored data (e.g., data being stored or removed) is
+reported as an Event to the hooks registered through "Store.OnEvent()", so
+the store may be monitored.
+
+Data may be stored with a Priority, through "Store.StorePriority()". Data
+of a higher priority is always retrieved first, regardless of how long
+lower priority data has been waiting.
+
+Data that can never be processed (e.g., a message permanently rejected by
+the SQS) may be moved to a dead-letter area, by calling
+"Data.DeadLetter()", instead of being removed. Dead letters are kept until
+they are either requeued or removed.
+
+Corrupted files (i.e., whose contents don't match their hash) are moved to a
+quarantine area when found, so they may be inspected, and are otherwise
+ignored. How much is stored, along with other internals, is reported by
+"Store.Stats()".
+
+Failures caused by the file system (e.g., a full disk) are reported as an
+*Error, which matches its error code through "errors.Is()" (e.g.,
+ErrStoreFailed) while wrapping its cause (e.g., syscall.ENOSPC).
+
 Example:
 
 	store := local_storage.NewFS("some-dir")
@@ -50,51 +72,304 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
+	"github.com/SirGFM/sqs-issue-notifier/server/loglevel"
 	flock "github.com/theckman/go-flock"
 	"fmt"
 	"io/fs"
-	"log"
+	"log/slog"
 	"os"
 	"sync"
+	"sync/atomic"
 	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 )
 
+// logger retrieves the logger for the local storage's entries, identified
+// as the "store" component.
+func logger() *slog.Logger {
+	return slog.Default().With(loglevel.ComponentKey, "store")
+}
+
 // Store defines the API to manage data in a local storage.
 type Store interface {
-	// Store data in the local storage.
+	// Store data in the local storage, with PriorityNormal.
 	Store(data []byte) error
 
+	// StorePriority stores data in the local storage with the given
+	// priority, returning its ID (as returned by Data.ID()). If the data
+	// is a duplicate, it fails with ErrDuplicatedStore, but still returns
+	// the ID of the data already stored.
+	StorePriority(data []byte, priority Priority) (string, error)
+
+	// Position retrieves how many data will be retrieved before the data
+	// identified by id: data of a higher priority, and older data of the
+	// same priority. Fails with ErrNotFound if the data isn't stored.
+	Position(id string) (int, error)
+
 	// Get a node from the local storage. This node won't be retrieved
-	// again until it's either Close()'d or Remove()'d.
+	// again until it's either Close()'d or Remove()'d. Nodes of a higher
+	// priority are retrieved first, and then the oldest ones.
 	Get() (Data, error)
 
 	// Count the number of known stored messages.
 	Count() int
 
+	// Lookup the data identified by id (as returned by Data.ID()), without
+	// retrieving it for processing.
+	Lookup(id string) (Entry, error)
+
+	// Oldest looks up the oldest data in the local storage, without
+	// retrieving it for processing. Fails with ErrGetEmpty if there's
+	// nothing stored.
+	Oldest() (Entry, error)
+
+	// Entries lists the data in the local storage, oldest first
+	// (regardless of its priority), without retrieving it for
+	// processing.
+	Entries() ([]Entry, error)
+
+	// RemoveByID removes the data identified by id from the local storage.
+	// Fails with ErrInFlight if the data is currently retrieved.
+	RemoveByID(id string) error
+
+	// Purge removes every data from the local storage, except for data
+	// currently retrieved, returning how many were removed.
+	Purge() (int, error)
+
+	// DeadLetters lists the data moved to the dead-letter area by
+	// Data.DeadLetter(), oldest first.
+	DeadLetters() ([]Entry, error)
+
+	// Requeue moves the dead-lettered data identified by id back into
+	// the local storage, so it's retrieved again.
+	Requeue(id string) error
+
+	// RequeueAll moves every dead-lettered data back into the local
+	// storage, returning how many were requeued.
+	RequeueAll() (int, error)
+
+	// RemoveDeadLetter removes the dead-lettered data identified by id.
+	RemoveDeadLetter(id string) error
+
+	// PurgeDeadLetters removes every dead-lettered data, returning how many
+	// were removed.
+	PurgeDeadLetters() (int, error)
+
+	// OnEvent registers hook to be called on every event. Hooks are called
+	// synchronously by whoever caused the event, so they must not block.
+	OnEvent(hook Hook)
+
 	// Wait blocks until anything was stored in the local storage. Returns
 	// ErrStoreClosed if the Store was closed, and ErrTimedOut if no
 	// message was received in a timely manner. A 'nil' return indicates
 	// that there is something ready to be retrieved.
 	Wait() error
 
-	// Close this store.
+	// Wake forcefully wakes up a Waiting goroutine, as if Wait timed out,
+	// so the local storage is checked right away.
+	Wake()
+
+	// SetTimeout changes how often a Waiting goroutine times out (if the
+	// store isn't signaled). Set this to 0 to ignore the timeout.
+	SetTimeout(timeout time.Duration)
+
+	// Stats retrieves the local storage's internals, for monitoring.
+	Stats() (Stats, error)
+
+	// Close this store, waking every goroutine blocked on Wait (which
+	// then fails with ErrStoreClosed). It's safe to call more than once,
+	// and from any goroutine.
 	Close() error
 }
 
+// Stats describes the local storage's internals.
+type Stats struct {
+	// Total size, in bytes, of the stored data (excluding dead letters).
+	Bytes int64
+
+	// Number of corrupted files moved to the quarantine area.
+	Quarantined int
+
+	// Number of times data couldn't be retrieved because it was locked
+	// by another consumer.
+	LockContention uint64
+}
+
 // Data defines the API to read and erase data retrieved from the local
 // storage.
 type Data interface {
+	// ID uniquely identifies this object in the local storage.
+	ID() string
+
 	// The contents of this object.
 	Bytes() []byte
 
 	// Remove this object from the local storage.
 	Remove() error
 
+	// DeadLetter moves this object to the local storage's dead-letter
+	// area, where it's kept (but never retrieved again) until it's either
+	// requeued or removed.
+	DeadLetter() error
+
 	// Close this object, allowing it to be retrieved again.
 	Close() error
 }
 
+// Entry describes data kept in the local storage.
+type Entry struct {
+	// Uniquely identifies the data in the local storage.
+	ID string
+
+	// When the data was stored.
+	StoredAt time.Time
+
+	// The data's priority.
+	Priority Priority
+
+	// Whether the data is currently retrieved (e.g., being sent).
+	InFlight bool
+
+	// The data itself.
+	Bytes []byte
+}
+
+// Priority defines the order in which data is retrieved from the local
+// storage: data of a higher priority is retrieved first.
+type Priority int
+
+const (
+	// Retrieved after every other data.
+	PriorityLow Priority = -1
+	// The default priority.
+	PriorityNormal Priority = 0
+	// Retrieved before every other data.
+	PriorityHigh Priority = 1
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	default:
+		return "invalid"
+	}
+}
+
+// MarshalText encodes the priority as its name (e.g., "high").
+func (p Priority) MarshalText() ([]byte, error) {
+	if p < PriorityLow || p > PriorityHigh {
+		return nil, ErrInvalidPriority
+	}
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText decodes a priority either from its name (e.g., "high"),
+// ignoring its case, or from the values of e-mail's X-Priority header,
+// from 1 (the highest) to 5 (the lowest).
+func (p *Priority) UnmarshalText(text []byte) error {
+	switch strings.ToLower(strings.TrimSpace(string(text))) {
+	case "low", "4", "5":
+		*p = PriorityLow
+	case "normal", "3":
+		*p = PriorityNormal
+	case "high", "1", "2":
+		*p = PriorityHigh
+	default:
+		return ErrInvalidPriority
+	}
+	return nil
+}
+
+// PriorityOf retrieves the priority of the data identified by id (as
+// returned by Data.ID()).
+func PriorityOf(id string) Priority {
+	switch {
+	case strings.HasSuffix(id, PriorityLow.suffix()):
+		return PriorityLow
+	case strings.HasSuffix(id, PriorityHigh.suffix()):
+		return PriorityHigh
+	default:
+		return PriorityNormal
+	}
+}
+
+// suffix is appended to the name of files with the priority p. Files with
+// PriorityNormal don't have a suffix, so files stored before priorities
+// existed keep their priority.
+func (p Priority) suffix() string {
+	if p == PriorityNormal {
+		return ""
+	}
+	return "." + p.String()
+}
+
+// EventType identifies what happened to the data in an Event.
+type EventType int
+
+const (
+	// The data was stored.
+	EventStored EventType = iota
+	// The data was removed (e.g., after being sent).
+	EventRemoved
+	// The data was moved to the dead-letter area.
+	EventDeadLettered
+	// The data was moved from the dead-letter area back into the store.
+	EventRequeued
+	// The data was removed from the dead-letter area.
+	EventDeadLetterRemoved
+	// The data was corrupted, so it was moved to the quarantine area.
+	EventQuarantined
+	// The data was removed by its ID (e.g., by an administrator), instead
+	// of after being retrieved.
+	EventPurged
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventStored:
+		return "stored"
+	case EventRemoved:
+		return "removed"
+	case EventDeadLettered:
+		return "dead-lettered"
+	case EventRequeued:
+		return "requeued"
+	case EventDeadLetterRemoved:
+		return "dead-letter-removed"
+	case EventQuarantined:
+		return "quarantined"
+	case EventPurged:
+		return "purged"
+	default:
+		return "invalid"
+	}
+}
+
+// Event describes a change to the data in the local storage.
+type Event struct {
+	// What happened to the data.
+	Type EventType
+
+	// Identifies the data (as returned by Data.ID()).
+	ID string
+
+	// Number of known stored messages, right after the event.
+	Queued int
+
+	// When the event happened.
+	Time time.Time
+}
+
+// Hook is called on every event in the local storage.
+type Hook func(Event)
+
 // notifier handles events and synchronization between the store and nodes.
 type notifier struct {
 	// Notify the waiting goroutine that something was added. Although
@@ -102,8 +377,14 @@ type notifier struct {
 	// receiver (which may not exist).
 	cond *sync.Cond
 
-	// Timer used to signal that a Wait should timeout.
-	timer *time.Timer
+	// Closed to stop the goroutine that times out Wait.
+	stop chan struct{}
+
+	// Changes how often the goroutine that times out Wait does so.
+	timeout chan time.Duration
+
+	// Ensures that the store is only closed once.
+	closeOnce sync.Once
 
 	// Number of known queued messages.
 	queued int
@@ -113,6 +394,30 @@ type notifier struct {
 
 	// Forcefully wakeup a Waiting goroutine.
 	forceWake bool
+
+	// Hooks called on every event.
+	hooks []Hook
+
+	// Number of times data couldn't be retrieved because it was locked.
+	contention atomic.Uint64
+}
+
+// emit an event of type typ for the data identified by id, calling every
+// hook. Must be called without holding the notifier's lock.
+func (n *notifier) emit(typ EventType, id string) {
+	n.cond.L.Lock()
+	hooks := n.hooks
+	ev := Event{
+		Type: typ,
+		ID: id,
+		Queued: n.queued,
+		Time: time.Now(),
+	}
+	n.cond.L.Unlock()
+
+	for _, hook := range hooks {
+		hook(ev)
+	}
 }
 
 // fsStore store data in the file system.
@@ -123,6 +428,12 @@ type fsStore struct {
 	// The directory were lock files are kept.
 	lock_dir string
 
+	// The directory were dead-lettered data is kept.
+	dead_dir string
+
+	// The directory were corrupted data is kept.
+	quarantine_dir string
+
 	// Handles waiting and walking the store.
 	wait *notifier
 }
@@ -131,22 +442,32 @@ type fsStore struct {
 const time_format = "2006-01-02-15-04-05-"
 
 func (f fsStore) Store(data []byte) error {
-	// Store the data as the file "<time>-<hash>".
+	_, err := f.StorePriority(data, PriorityNormal)
+	return err
+}
+
+func (f fsStore) StorePriority(data []byte, priority Priority) (string, error) {
+	if priority < PriorityLow || priority > PriorityHigh {
+		return "", ErrInvalidPriority
+	}
+
+	// Store the data as the file "<time>-<hash>", followed by the
+	// priority's suffix (if any).
 	now := time.Now().Format(time_format)
 
 	hash := sha256.Sum256(data)
 	hash_hex := hex.EncodeToString(hash[:])
 
-	filename := now + hash_hex
+	filename := now + hash_hex + priority.suffix()
 
 	// Lock the file to ensure that even if two identical events were
 	// received at the same time, only one would be stored.
 	lock := flock.New(filepath.Join(f.lock_dir, filename))
 	if locked, err := lock.TryLock(); err != nil {
-		log.Printf("local_storage/Store: TryLock failed: %+v\n", err)
-		return ErrStoreLockFailed
+		logger().Error("local_storage/Store: TryLock failed", "err", err)
+		return "", wrap("Store", ErrStoreLockFailed, err)
 	} else if !locked {
-		return ErrDuplicatedStore
+		return filename, ErrDuplicatedStore
 	}
 	// TODO: (*Flock)Unlock() simply unlocks the flock, but does not erase
 	// the lock file. Keep the lock file around until the service is
@@ -159,98 +480,487 @@ func (f fsStore) Store(data []byte) error {
 	// same event may have arrived duplicated (but after the first message
 	// was properly handled).
 	if _, err := os.Stat(file); !errors.Is(err, fs.ErrNotExist) {
-		return ErrDuplicatedStore
+		return filename, ErrDuplicatedStore
 	}
 
 	err := os.WriteFile(file, data, 0600)
 	if err != nil {
-		log.Printf("local_storage/Store: Write failed: %+v\n", err)
-		return ErrStoreFailed
+		logger().Error("local_storage/Store: Write failed", "err", err)
+		return "", wrap("Store", ErrStoreFailed, err)
 	}
 
 	f.wait.cond.L.Lock()
 	f.wait.queued++
 	f.wait.cond.L.Unlock()
 	f.wait.cond.Signal()
-	return nil
+	f.wait.emit(EventStored, filename)
+	return filename, nil
 }
 
 func (f fsStore) Get() (Data, error) {
-	var data Data
+	files, err := os.ReadDir(f.dir)
+	if err != nil {
+		logger().Error("local_storage/Get: Couldn't read any file", "err", err)
+		return nil, wrap("Get", ErrGetFailed, err)
+	}
 
-	// Walk over every file in f.dir returning the first valid Data.
+	// ReadDir sorts the files by name, and thus by their storage time.
+	// Stably sorting them by priority retrieves the oldest data of the
+	// highest priority first.
+	slices.SortStableFunc(files, func(a, b fs.DirEntry) int {
+		return int(PriorityOf(b.Name()) - PriorityOf(a.Name()))
+	})
+
+	// Return the first valid Data.
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
 
-	walk := func (path string, d fs.DirEntry, err error)  (ret_err error) {
-		if d.IsDir() && path != f.dir {
-			return fs.SkipDir
-		} else if d.IsDir() {
-			return err
+		data, err := f.tryGet(filepath.Join(f.dir, file.Name()))
+		if err != nil {
+			logger().Error("local_storage/Get: Couldn't read any file", "err", err)
+			return nil, wrap("Get", ErrGetFailed, err)
+		} else if data != nil {
+			return data, nil
 		}
+	}
+
+	return nil, ErrGetEmpty
+}
+
+// tryGet retrieves the data on path, unless it's either already retrieved
+// or invalid, in which case it returns nil.
+func (f fsStore) tryGet(path string) (Data, error) {
+	// Try to lock the current file, so it may be used exclusively.
+	filename := filepath.Base(path)
+	lock := flock.New(filepath.Join(f.lock_dir, filename))
+	if locked, err := lock.TryLock(); err != nil {
+		logger().Error("local_storage/Get: TryLock failed", "err", err)
+		return nil, wrap("Get", ErrGetLockFailed, err)
+	} else if !locked {
+		// This file is already being read.
+		f.wait.contention.Add(1)
+		return nil, nil
+	}
+
+	// Try to read the file and check its integrity.
+	hash_str, ok := nameHash(filename)
+	if !ok {
+		logger().Warn("local_storage/Get: Invalid file", "path", path)
+		f.quarantine(path, lock)
+		return nil, nil
+	}
+
+	file_data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		// Another consumer removed the file after it was listed.
+		lock.Unlock()
+		os.Remove(lock.Path())
+		return nil, nil
+	} else if err != nil {
+		// TODO: Remove the file?
+		logger().Error("local_storage/Get: Couldn't read the file", "path", path, "err", err)
+		lock.Unlock()
+		return nil, nil
+	}
+
+	hash := sha256.Sum256(file_data)
+	hash_hex := hex.EncodeToString(hash[:])
+	// This is only used for integrity (as in, data corruption), so no
+	// need to use subtle.
+	if hash_hex != hash_str {
+		logger().Warn("local_storage/Get: Corrupted file", "path", path)
+		f.quarantine(path, lock)
+		return nil, nil
+	}
+
+	return fsData {
+		data: file_data,
+		file_path: path,
+		lock: lock,
+		wait: f.wait,
+		dead_dir: f.dead_dir,
+	}, nil
+}
+
+// quarantine moves the corrupted file on path, locked by lock, to the
+// quarantine area, so it's no longer retrieved.
+func (f fsStore) quarantine(path string, lock *flock.Flock) {
+	defer func() {
+		lock.Unlock()
+		os.Remove(lock.Path())
+	} ()
+
+	id := filepath.Base(path)
+	err := os.Rename(path, filepath.Join(f.quarantine_dir, id))
+	if err != nil {
+		logger().Error("local_storage/Get: Couldn't quarantine the file", "path", path, "err", err)
+		return
+	}
 
-		// Try to lock the current file, so it may be used exclusively.
-		filename := filepath.Base(path)
-		lock := flock.New(filepath.Join(f.lock_dir, filename))
-		if locked, err := lock.TryLock(); err != nil {
-			log.Printf("local_storage/Get: TryLock failed: %+v\n", err)
-			return ErrGetLockFailed
-		} else if !locked {
-			// This file is already being read. Continue walking.
-			return nil
+	f.wait.cond.L.Lock()
+	if f.wait.queued > 0 {
+		f.wait.queued--
+	}
+	f.wait.cond.L.Unlock()
+	f.wait.emit(EventQuarantined, id)
+}
+
+func (f fsStore) Stats() (Stats, error) {
+	var st Stats
+
+	files, err := os.ReadDir(f.dir)
+	if err != nil {
+		logger().Error("local_storage/Stats: Couldn't read the directory", "err", err)
+		return st, wrap("Stats", ErrGetFailed, err)
+	}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		if info, err := file.Info(); err == nil {
+			st.Bytes += info.Size()
 		}
+	}
 
-		// SkipDir indicates a success, as no further processing is done.
-		defer func() {
-			if ret_err != fs.SkipDir {
-				lock.Unlock()
-			}
-		} ()
-
-		// Try to read the file and check its integrity.
-		hash_offset := len(time_format)
-		if len(filename) < hash_offset {
-			// TODO: Remove the file?
-			log.Printf("local_storage/Get: Invalid file: %s\n", path)
-			return nil
+	quarantined, err := os.ReadDir(f.quarantine_dir)
+	if err != nil {
+		logger().Error("local_storage/Stats: Couldn't read the quarantine", "err", err)
+		return st, wrap("Stats", ErrGetFailed, err)
+	}
+	st.Quarantined = len(quarantined)
+	st.LockContention = f.wait.contention.Load()
+
+	return st, nil
+}
+
+// StoredAt retrieves when the data identified by id (as returned by
+// Data.ID()) was stored.
+func StoredAt(id string) (time.Time, error) {
+	if !validID(id) {
+		return time.Time{}, ErrInvalidID
+	}
+	return time.ParseInLocation(time_format, id[:len(time_format)], time.Local)
+}
+
+// max_id_len is the longest name accepted for data files. Data stored by
+// Store has a much shorter name, but files may be added by hand, so this is
+// simply the longest name most file systems accept.
+const max_id_len = 255
+
+// validID checks whether id may be the name of a data file, so it may be
+// safely joined to the store's directory. Besides not escaping the store's
+// directory, it must start with a valid storage time, and it must not have
+// any character that the file system would reject (so invalid IDs are
+// reported as such, instead of as failures to access the store).
+func validID(id string) bool {
+	if len(id) <= len(time_format) || len(id) > max_id_len ||
+			filepath.Base(id) != id || id[0] == '.' ||
+			strings.IndexByte(id, 0) != -1 {
+		return false
+	}
+
+	_, err := time.ParseInLocation(time_format, id[:len(time_format)], time.Local)
+	return err == nil
+}
+
+// nameHash retrieves the hash of the data in the file named filename, as
+// stored by Store. It fails if filename doesn't have a hash (i.e., it's too
+// short to have one).
+func nameHash(filename string) (string, bool) {
+	hash_offset := len(time_format)
+	if len(filename) < hash_offset {
+		return "", false
+	}
+	return strings.TrimSuffix(filename[hash_offset:], PriorityOf(filename).suffix()), true
+}
+
+func (f fsStore) Lookup(id string) (Entry, error) {
+	if !validID(id) {
+		return Entry{}, ErrInvalidID
+	}
+
+	path := filepath.Join(f.dir, id)
+	file_data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Entry{}, ErrNotFound
+	} else if err != nil {
+		logger().Error("local_storage/Lookup: Couldn't read the file", "path", path, "err", err)
+		return Entry{}, wrap("Lookup", ErrGetFailed, err)
+	}
+
+	entry := Entry{
+		ID: id,
+		Priority: PriorityOf(id),
+		Bytes: file_data,
+	}
+
+	stored, err := StoredAt(id)
+	if err == nil {
+		entry.StoredAt = stored
+	}
+
+	// The data is locked while it's retrieved.
+	lock := flock.New(filepath.Join(f.lock_dir, id))
+	if locked, err := lock.TryLock(); err != nil {
+		logger().Error("local_storage/Lookup: TryLock failed", "err", err)
+	} else if !locked {
+		entry.InFlight = true
+	} else {
+		lock.Unlock()
+	}
+
+	return entry, nil
+}
+
+func (f fsStore) Oldest() (Entry, error) {
+	// Files are named after when they were stored, and ReadDir sorts
+	// them by name, so the first valid file is the oldest one.
+	files, err := os.ReadDir(f.dir)
+	if err != nil {
+		logger().Error("local_storage/Oldest: Couldn't read the directory", "err", err)
+		return Entry{}, wrap("Oldest", ErrGetFailed, err)
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !validID(file.Name()) {
+			continue
+		}
+
+		entry, err := f.Lookup(file.Name())
+		if err == ErrNotFound {
+			// Removed since the directory was read.
+			continue
+		}
+		return entry, err
+	}
+
+	return Entry{}, ErrGetEmpty
+}
+
+func (f fsStore) Entries() ([]Entry, error) {
+	files, err := os.ReadDir(f.dir)
+	if err != nil {
+		logger().Error("local_storage/Entries: Couldn't read the directory", "err", err)
+		return nil, wrap("Entries", ErrGetFailed, err)
+	}
+
+	// ReadDir sorts the files by name, and thus by their storage time.
+	var entries []Entry
+	for _, file := range files {
+		if file.IsDir() || !validID(file.Name()) {
+			continue
+		}
+
+		entry, err := f.Lookup(file.Name())
+		if err == ErrNotFound {
+			// Removed since the directory was read.
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func (f fsStore) Position(id string) (int, error) {
+	if !validID(id) {
+		return 0, ErrInvalidID
+	}
+
+	files, err := os.ReadDir(f.dir)
+	if err != nil {
+		logger().Error("local_storage/Position: Couldn't read the directory", "err", err)
+		return 0, wrap("Position", ErrGetFailed, err)
+	}
+
+	// Only the file names are needed, as they encode both when the data
+	// was stored and its priority.
+	priority := PriorityOf(id)
+	found := false
+	position := 0
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !validID(name) {
+			continue
+		} else if name == id {
+			found = true
+		} else if p := PriorityOf(name); p > priority || (p == priority && name < id) {
+			position++
+		}
+	}
+
+	if !found {
+		return 0, ErrNotFound
+	}
+	return position, nil
+}
+
+func (f fsStore) RemoveByID(id string) error {
+	if !validID(id) {
+		return ErrInvalidID
+	}
+
+	path := filepath.Join(f.dir, id)
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+
+	// Lock the file, so it isn't removed while being retrieved.
+	lock := flock.New(filepath.Join(f.lock_dir, id))
+	if locked, err := lock.TryLock(); err != nil {
+		logger().Error("local_storage/RemoveByID: TryLock failed", "err", err)
+		return wrap("RemoveByID", ErrRemoveFailed, err)
+	} else if !locked {
+		return ErrInFlight
+	}
+
+	data := fsData {
+		file_path: path,
+		lock: lock,
+		wait: f.wait,
+	}
+	err := data.remove(EventPurged)
+	if err != nil {
+		lock.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (f fsStore) Purge() (int, error) {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		logger().Error("local_storage/Purge: Couldn't list the stored data", "err", err)
+		return 0, wrap("Purge", ErrRemoveFailed, err)
+	}
+
+	count := 0
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
 		}
-		hash_str := filename[hash_offset:]
 
+		err := f.RemoveByID(entry.Name())
+		if err == nil {
+			count++
+		} else if err != ErrInFlight && err != ErrNotFound {
+			logger().Error("local_storage/Purge: Couldn't remove the file", "file", entry.Name(), "err", err)
+		}
+	}
+
+	return count, nil
+}
+
+func (f fsStore) DeadLetters() ([]Entry, error) {
+	files, err := os.ReadDir(f.dead_dir)
+	if err != nil {
+		logger().Error("local_storage/DeadLetters: Couldn't list the dead letters", "err", err)
+		return nil, wrap("DeadLetters", ErrGetFailed, err)
+	}
+
+	// ReadDir sorts the files by name, and thus by their storage time.
+	var entries []Entry
+	for _, file := range files {
+		if file.IsDir() || !validID(file.Name()) {
+			continue
+		}
+
+		path := filepath.Join(f.dead_dir, file.Name())
 		file_data, err := os.ReadFile(path)
 		if err != nil {
-			// TODO: Remove the file?
-			log.Printf("local_storage/Get: Couldn't read file %s: %+v\n", path, err)
-			return nil
+			logger().Error("local_storage/DeadLetters: Couldn't read the file", "path", path, "err", err)
+			continue
 		}
 
-		hash := sha256.Sum256(file_data)
-		hash_hex := hex.EncodeToString(hash[:])
-		// This is only used for integrity (as in, data corruption), so no
-		// need to use subtle.
-		if hash_hex != hash_str {
-			// TODO: Remove the file?
-			log.Printf("local_storage/Get: Corrupted file: %s\n", path)
-			return nil
+		entry := Entry{
+			ID: file.Name(),
+			Priority: PriorityOf(file.Name()),
+			Bytes: file_data,
 		}
-
-		// On success, return SkipDir to stop further processing and
-		// assign the data captured by closure.
-		data = fsData {
-			data: file_data,
-			file_path: path,
-			lock: lock,
-			wait: f.wait,
+		stored, err := StoredAt(entry.ID)
+		if err == nil {
+			entry.StoredAt = stored
 		}
-		return fs.SkipDir
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func (f fsStore) Requeue(id string) error {
+	if !validID(id) {
+		return ErrInvalidID
+	}
+
+	err := os.Rename(filepath.Join(f.dead_dir, id), filepath.Join(f.dir, id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	} else if err != nil {
+		logger().Error("local_storage/Requeue: Couldn't move the data file", "err", err)
+		return wrap("Requeue", ErrStoreFailed, err)
+	}
+
+	f.wait.cond.L.Lock()
+	f.wait.queued++
+	f.wait.cond.L.Unlock()
+	f.wait.cond.Signal()
+	f.wait.emit(EventRequeued, id)
+	return nil
+}
+
+func (f fsStore) RequeueAll() (int, error) {
+	return f.eachDeadLetter(f.Requeue)
+}
+
+func (f fsStore) RemoveDeadLetter(id string) error {
+	if !validID(id) {
+		return ErrInvalidID
+	}
+
+	err := os.Remove(filepath.Join(f.dead_dir, id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	} else if err != nil {
+		logger().Error("local_storage/RemoveDeadLetter: Couldn't remove the data file", "err", err)
+		return wrap("RemoveDeadLetter", ErrRemoveFailed, err)
 	}
 
-	err := filepath.WalkDir(f.dir, walk)
+	f.wait.emit(EventDeadLetterRemoved, id)
+	return nil
+}
+
+func (f fsStore) PurgeDeadLetters() (int, error) {
+	return f.eachDeadLetter(f.RemoveDeadLetter)
+}
+
+// eachDeadLetter calls fn for every dead-lettered data, returning for how
+// many it succeeded.
+func (f fsStore) eachDeadLetter(fn func(id string) error) (int, error) {
+	files, err := os.ReadDir(f.dead_dir)
 	if err != nil {
-		log.Printf("local_storage/Get: Couldn't read any file: %+v\n", err)
-		return nil, ErrGetFailed
-	} else if data == nil {
-		return nil, ErrGetEmpty
+		logger().Error("local_storage/eachDeadLetter: Couldn't list the dead letters", "err", err)
+		return 0, wrap("eachDeadLetter", ErrGetFailed, err)
+	}
+
+	count := 0
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+
+		err := fn(file.Name())
+		if err == nil {
+			count++
+		} else if err != ErrNotFound {
+			logger().Error("local_storage/eachDeadLetter: Failed on a dead letter", "file", file.Name(), "err", err)
+		}
 	}
 
-	return data, nil
+	return count, nil
 }
 
 func (f fsStore) Wait() error {
@@ -271,6 +981,28 @@ func (f fsStore) Wait() error {
 	return err
 }
 
+func (f fsStore) Wake() {
+	f.wait.cond.L.Lock()
+	f.wait.forceWake = true
+	f.wait.cond.L.Unlock()
+	f.wait.cond.Signal()
+}
+
+func (f fsStore) SetTimeout(timeout time.Duration) {
+	select {
+	case f.wait.timeout <- timeout:
+	case <-f.wait.stop:
+	}
+}
+
+func (f fsStore) OnEvent(hook Hook) {
+	f.wait.cond.L.Lock()
+	// Copy the hooks, so emit may call them without holding the lock.
+	hooks := make([]Hook, 0, len(f.wait.hooks) + 1)
+	f.wait.hooks = append(append(hooks, f.wait.hooks...), hook)
+	f.wait.cond.L.Unlock()
+}
+
 func (f fsStore) Count() int {
 	f.wait.cond.L.Lock()
 	n := f.wait.queued
@@ -280,13 +1012,15 @@ func (f fsStore) Count() int {
 }
 
 func (f fsStore) Close() error {
-	f.wait.cond.L.Lock()
-	f.wait.run = false
-	if f.wait.timer != nil {
-		f.wait.timer.Stop()
-	}
-	f.wait.cond.L.Unlock()
-	f.wait.cond.Signal()
+	f.wait.closeOnce.Do(func() {
+		f.wait.cond.L.Lock()
+		f.wait.run = false
+		f.wait.cond.L.Unlock()
+		// Every waiting goroutine must notice that the store was
+		// closed, not just one of them.
+		f.wait.cond.Broadcast()
+		close(f.wait.stop)
+	})
 	return nil
 }
 
@@ -304,6 +1038,13 @@ type fsData struct {
 
 	// Notifies the store that this data was removed.
 	wait *notifier
+
+	// The directory were dead-lettered data is kept.
+	dead_dir string
+}
+
+func (fd fsData) ID() string {
+	return filepath.Base(fd.file_path)
 }
 
 func (fd fsData) Bytes() []byte {
@@ -313,10 +1054,15 @@ func (fd fsData) Bytes() []byte {
 }
 
 func (fd fsData) Remove() error {
+	return fd.remove(EventRemoved)
+}
+
+// remove the data, emitting an event of type typ.
+func (fd fsData) remove(typ EventType) error {
 	err := os.Remove(fd.file_path)
 	if err != nil {
-		log.Printf("local_storage/Remove: Couldn't remove the data file: %+v\n", err)
-		return ErrRemoveFailed
+		logger().Error("local_storage/Remove: Couldn't remove the data file", "err", err)
+		return wrap("Remove", ErrRemoveFailed, err)
 	}
 
 	fd.lock.Unlock()
@@ -324,7 +1070,30 @@ func (fd fsData) Remove() error {
 	if err != nil {
 		// No need to return this error, as it's useless for the rest of
 		// the application.
-		log.Printf("local_storage/Remove: Couldn't remove the lock file: %+v\n", err)
+		logger().Error("local_storage/Remove: Couldn't remove the lock file", "err", err)
+	}
+
+	fd.wait.cond.L.Lock()
+	if fd.wait.queued > 0 {
+		fd.wait.queued--
+	}
+	fd.wait.cond.L.Unlock()
+	fd.wait.emit(typ, fd.ID())
+
+	return nil
+}
+
+func (fd fsData) DeadLetter() error {
+	err := os.Rename(fd.file_path, filepath.Join(fd.dead_dir, filepath.Base(fd.file_path)))
+	if err != nil {
+		logger().Error("local_storage/DeadLetter: Couldn't move the data file", "err", err)
+		return wrap("DeadLetter", ErrRemoveFailed, err)
+	}
+
+	fd.lock.Unlock()
+	err = os.Remove(fd.lock.Path())
+	if err != nil {
+		logger().Error("local_storage/DeadLetter: Couldn't remove the lock file", "err", err)
 	}
 
 	fd.wait.cond.L.Lock()
@@ -332,6 +1101,7 @@ func (fd fsData) Remove() error {
 		fd.wait.queued--
 	}
 	fd.wait.cond.L.Unlock()
+	fd.wait.emit(EventDeadLettered, fd.ID())
 
 	return nil
 }
@@ -348,9 +1118,13 @@ func NewFS(dir string, timeout time.Duration) Store {
 	s := fsStore {
 		dir: dir,
 		lock_dir: filepath.Join(dir, ".lock"),
+		dead_dir: filepath.Join(dir, ".dead"),
+		quarantine_dir: filepath.Join(dir, ".quarantine"),
 		wait: &notifier{
 			cond: sync.NewCond(&sync.Mutex{}),
 			run: true,
+			stop: make(chan struct{}),
+			timeout: make(chan time.Duration),
 		},
 	}
 
@@ -363,6 +1137,14 @@ func NewFS(dir string, timeout time.Duration) Store {
 	if err != nil {
 		panic(fmt.Sprintf("local_storage/NewFS: Failed to create the lock dir: %+v", err))
 	}
+	err = os.MkdirAll(s.dead_dir, 0755)
+	if err != nil {
+		panic(fmt.Sprintf("local_storage/NewFS: Failed to create the dead-letter dir: %+v", err))
+	}
+	err = os.MkdirAll(s.quarantine_dir, 0755)
+	if err != nil {
+		panic(fmt.Sprintf("local_storage/NewFS: Failed to create the quarantine dir: %+v", err))
+	}
 
 	// Pre-fill the wait channel with as many files as there are in the
 	// directory.
@@ -383,24 +1165,43 @@ func NewFS(dir string, timeout time.Duration) Store {
 		panic(fmt.Sprintf("local_storage/NewFS: Failed to initialize the local storage: %+v", err))
 	}
 
-	// Spawn a goroutine to wake up a Waiting goroutine (if any).
-	if timeout != time.Duration(0) {
-		s.wait.timer = time.NewTimer(timeout)
-
-		go func(n *notifier) {
-			for n.run {
-				n.timer.Reset(timeout)
-				<-n.timer.C
+	// Spawn a goroutine to wake up a Waiting goroutine (if any). It's
+	// spawned even if the timeout is ignored, as it may be changed later.
+	go func(n *notifier) {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+
+		reset := func(timeout time.Duration) {
+			ticker.Stop()
+			// Drop any tick of the previous timeout.
+			select {
+			case <-ticker.C:
+			default:
+			}
+			if timeout != time.Duration(0) {
+				ticker.Reset(timeout)
+			}
+		}
+		reset(timeout)
+
+		for {
+			select {
+			case <-n.stop:
+				return
+			case timeout := <-n.timeout:
+				reset(timeout)
+				continue
+			case <-ticker.C:
+			}
 
-				n.cond.L.Lock()
-				if n.queued == 0 {
-					n.forceWake = true
-				}
-				n.cond.L.Unlock()
-				n.cond.Signal()
+			n.cond.L.Lock()
+			if n.queued == 0 {
+				n.forceWake = true
 			}
-		} (s.wait)
-	}
+			n.cond.L.Unlock()
+			n.cond.Signal()
+		}
+	} (s.wait)
 
 	return s
 }
@@ -2,9 +2,14 @@ package local_storage
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"testing"
 	"time"
 	"os"
+	"path/filepath"
+	"syscall"
 )
 
 // TestLocalFS tests the basic behaviour for a local storage.
@@ -76,6 +81,8 @@ func TestLocalFS(t *testing.T) {
 	} else if bytes.Compare(msg, repData.Bytes()) != 0 {
 		t.Errorf("Get: Repeated message does not match! Want '%s' but got '%s'",
 				string(msg), string(repData.Bytes()))
+	} else if want, got := data.ID(), repData.ID(); want != got {
+		t.Errorf("ID: Repeated message has a different ID! Want '%s' but got '%s'", want, got)
 	}
 
 	// Remove the message, checking that both Get and Wait properly signals
@@ -266,3 +273,766 @@ func TestPrepopulated(t *testing.T) {
 		}
 	}
 }
+
+// TestLookup checks that stored data may be inspected by its ID, without
+// affecting its processing.
+func TestLookup(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "local-lookup-fs*")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewFS(dir, 0)
+	defer store.Close()
+
+	msg := []byte("One, two! One, two! And through and through")
+	err = store.Store(msg)
+	if err != nil {
+		t.Fatalf("Store: Failed to store the message '%s': %+v", msg, err)
+	}
+
+	data, err := store.Get()
+	if err != nil {
+		t.Fatalf("Get: Failed to retrieve the message '%s': %+v", msg, err)
+	}
+
+	id := data.ID()
+	entry, err := store.Lookup(id)
+	if err != nil {
+		t.Errorf("Lookup: Failed to find the message: %+v", err)
+	} else if bytes.Compare(msg, entry.Bytes) != 0 {
+		t.Errorf("Lookup: Message does not match! Want '%s' but got '%s'", msg, entry.Bytes)
+	} else if !entry.InFlight {
+		t.Errorf("Lookup: Expected the message to be in flight")
+	} else if time.Since(entry.StoredAt) > time.Minute {
+		t.Errorf("Lookup: Invalid storage time '%s'", entry.StoredAt)
+	}
+
+	data.Close()
+	entry, err = store.Lookup(id)
+	if err != nil {
+		t.Errorf("Lookup: Failed to find the message: %+v", err)
+	} else if entry.InFlight {
+		t.Errorf("Lookup: Expected the message not to be in flight")
+	}
+
+	// Lookup must not hold the message.
+	data, err = store.Get()
+	if err != nil {
+		t.Errorf("Get: Failed to retrieve the message after Lookup: %+v", err)
+	} else {
+		data.Remove()
+	}
+
+	test_cases := []struct{ id string; err error } {
+		{ id: id, err: ErrNotFound },
+		{ id: "../" + id, err: ErrInvalidID },
+		{ id: ".lock", err: ErrInvalidID },
+		{ id: "", err: ErrInvalidID },
+	}
+	for i, tc := range test_cases {
+		_, err := store.Lookup(tc.id)
+		if want, got := tc.err, err; want != got {
+			t.Errorf("%d: Lookup: Expected error '%+v' but got '%+v'", i, want, got)
+		}
+	}
+}
+
+// TestOldest checks that the oldest data may be looked up without
+// retrieving it.
+func TestOldest(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "local-oldest-fs*")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewFS(dir, 0)
+	defer store.Close()
+
+	_, err = store.Oldest()
+	if err != ErrGetEmpty {
+		t.Errorf("Oldest: Expected error '%+v' but got '%+v'", ErrGetEmpty, err)
+	}
+
+	msg := []byte("He left it dead, and with its head")
+	err = store.Store(msg)
+	if err != nil {
+		t.Fatalf("Store: Failed to store the message '%s': %+v", msg, err)
+	}
+
+	entry, err := store.Oldest()
+	if err != nil {
+		t.Errorf("Oldest: Failed to find the message: %+v", err)
+	} else if bytes.Compare(msg, entry.Bytes) != 0 {
+		t.Errorf("Oldest: Message does not match! Want '%s' but got '%s'", msg, entry.Bytes)
+	} else if time.Since(entry.StoredAt) > time.Minute {
+		t.Errorf("Oldest: Invalid storage time '%s'", entry.StoredAt)
+	}
+
+	// Simulate a message stored long ago.
+	old := "2000-01-01-00-00-00-old"
+	err = os.WriteFile(filepath.Join(dir, old), msg, 0600)
+	if err != nil {
+		t.Fatalf("Failed to write the old message: %+v", err)
+	}
+
+	entry, err = store.Oldest()
+	if err != nil {
+		t.Errorf("Oldest: Failed to find the message: %+v", err)
+	} else if entry.ID != old {
+		t.Errorf("Oldest: Expected the message '%s' but got '%s'", old, entry.ID)
+	}
+}
+
+// TestEntries checks that every data may be listed, oldest first, without
+// retrieving it.
+func TestEntries(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "local-entries-fs*")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewFS(dir, 0)
+	defer store.Close()
+
+	msg := []byte("The jaws that bite, the claws that catch!")
+	_, err = store.StorePriority(msg, PriorityHigh)
+	if err != nil {
+		t.Fatalf("StorePriority: Failed to store the message '%s': %+v", msg, err)
+	}
+
+	// Simulate a message stored long ago.
+	old := "2000-01-01-00-00-00-old"
+	err = os.WriteFile(filepath.Join(dir, old), msg, 0600)
+	if err != nil {
+		t.Fatalf("Failed to write the old message: %+v", err)
+	}
+
+	entries, err := store.Entries()
+	if err != nil {
+		t.Fatalf("Entries: Failed to list the messages: %+v", err)
+	} else if len(entries) != 2 {
+		t.Fatalf("Entries: Expected 2 messages but got %d", len(entries))
+	}
+
+	if entries[0].ID != old {
+		t.Errorf("Entries: Expected the message '%s' first but got '%s'", old, entries[0].ID)
+	}
+	if entries[1].Priority != PriorityHigh {
+		t.Errorf("Entries: Expected priority '%s' but got '%s'", PriorityHigh, entries[1].Priority)
+	} else if bytes.Compare(msg, entries[1].Bytes) != 0 {
+		t.Errorf("Entries: Message does not match! Want '%s' but got '%s'", msg, entries[1].Bytes)
+	}
+}
+
+// TestPriority checks that data of a higher priority is retrieved first,
+// and that its position reflects that.
+func TestPriority(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "local-priority-fs*")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewFS(dir, 0)
+	defer store.Close()
+
+	test_cases := []struct{ msg string; priority Priority } {
+		{ msg: "The time has come,", priority: PriorityLow },
+		{ msg: "the Walrus said,", priority: PriorityNormal },
+		{ msg: "To talk of many things:", priority: PriorityHigh },
+		{ msg: "Of shoes - and ships - and sealing-wax -", priority: PriorityNormal },
+	}
+	ids := make(map[Priority]string)
+	for i, tc := range test_cases {
+		id, err := store.StorePriority([]byte(tc.msg), tc.priority)
+		if err != nil {
+			t.Fatalf("%d: StorePriority: Failed to store the message '%s': %+v", i, tc.msg, err)
+		} else if got := PriorityOf(id); got != tc.priority {
+			t.Errorf("%d: StorePriority: Expected an ID with priority '%s' but got '%s'", i, tc.priority, got)
+		}
+		ids[tc.priority] = id
+	}
+
+	positions := map[Priority]int{ PriorityHigh: 0, PriorityLow: 3 }
+	for priority, want := range positions {
+		got, err := store.Position(ids[priority])
+		if err != nil {
+			t.Errorf("Position: Failed to find the '%s' message: %+v", priority, err)
+		} else if want != got {
+			t.Errorf("Position: Expected the '%s' message at '%d' but got '%d'", priority, want, got)
+		}
+	}
+	if _, err := store.Position("2000-01-01-00-00-00-missing"); err != ErrNotFound {
+		t.Errorf("Position: Expected error '%+v' but got '%+v'", ErrNotFound, err)
+	}
+
+	_, err = store.StorePriority([]byte("Of cabbages - and kings -"), Priority(2))
+	if err != ErrInvalidPriority {
+		t.Errorf("StorePriority: Expected error '%+v' but got '%+v'", ErrInvalidPriority, err)
+	}
+
+	// Messages of the same priority are retrieved in the order they were
+	// stored, as long as they were stored in different seconds. Since
+	// that's not the case, only check the priorities.
+	want := []Priority{ PriorityHigh, PriorityNormal, PriorityNormal, PriorityLow }
+	for i, priority := range want {
+		data, err := store.Get()
+		if err != nil {
+			t.Fatalf("%d: Get: Failed to retrieve the message: %+v", i, err)
+		}
+
+		if got := PriorityOf(data.ID()); got != priority {
+			t.Errorf("%d: Get: Expected a message with priority '%s' but got '%s'", i, priority, got)
+		}
+
+		entry, err := store.Lookup(data.ID())
+		if err != nil {
+			t.Errorf("%d: Lookup: Failed to find the message: %+v", i, err)
+		} else if entry.Priority != priority {
+			t.Errorf("%d: Lookup: Expected priority '%s' but got '%s'", i, priority, entry.Priority)
+		}
+		data.Remove()
+	}
+}
+
+// TestPriorityText checks that priorities may be decoded from their names
+// and from e-mail's X-Priority values.
+func TestPriorityText(t *testing.T) {
+	test_cases := []struct{ text string; priority Priority; err error } {
+		{ text: "high", priority: PriorityHigh },
+		{ text: "HIGH", priority: PriorityHigh },
+		{ text: "1", priority: PriorityHigh },
+		{ text: "normal", priority: PriorityNormal },
+		{ text: "3", priority: PriorityNormal },
+		{ text: " low ", priority: PriorityLow },
+		{ text: "5", priority: PriorityLow },
+		{ text: "urgent", err: ErrInvalidPriority },
+		{ text: "0", err: ErrInvalidPriority },
+	}
+	for i, tc := range test_cases {
+		var p Priority
+		err := p.UnmarshalText([]byte(tc.text))
+		if err != tc.err {
+			t.Errorf("%d: UnmarshalText: Expected error '%+v' but got '%+v'", i, tc.err, err)
+		} else if err == nil && p != tc.priority {
+			t.Errorf("%d: UnmarshalText: Expected priority '%s' but got '%s'", i, tc.priority, p)
+		}
+	}
+}
+
+// TestRemoveByID checks that data may be removed by its ID, or purged
+// altogether, except while it's retrieved.
+func TestRemoveByID(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "local-remove-fs*")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewFS(dir, 0)
+	defer store.Close()
+
+	test_cases := [][]byte{
+		[]byte("He took his vorpal sword in hand;"),
+		[]byte("Long time the manxome foe he sought—"),
+		[]byte("So rested he by the Tumtum tree"),
+		[]byte("And stood awhile in thought."),
+	}
+	for i, msg := range test_cases {
+		err = store.Store(msg)
+		if err != nil {
+			t.Errorf("%d: Store: Failed to store the message '%s': %+v", i, msg, err)
+		}
+	}
+
+	held, err := store.Get()
+	if err != nil {
+		t.Fatalf("Get: Failed to retrieve a message: %+v", err)
+	}
+	err = store.RemoveByID(held.ID())
+	if want, got := ErrInFlight, err; want != got {
+		t.Errorf("RemoveByID: Expected error '%+v' but got '%+v'", want, got)
+	}
+
+	removed, err := store.Get()
+	if err != nil {
+		t.Fatalf("Get: Failed to retrieve a message: %+v", err)
+	}
+	removed.Close()
+	err = store.RemoveByID(removed.ID())
+	if err != nil {
+		t.Errorf("RemoveByID: Failed to remove the message: %+v", err)
+	}
+	err = store.RemoveByID(removed.ID())
+	if want, got := ErrNotFound, err; want != got {
+		t.Errorf("RemoveByID: Expected error '%+v' but got '%+v'", want, got)
+	}
+
+	// Only the messages that aren't held may be purged.
+	n, err := store.Purge()
+	if err != nil {
+		t.Errorf("Purge: Failed to purge the messages: %+v", err)
+	} else if want, got := len(test_cases) - 2, n; want != got {
+		t.Errorf("Purge: Expected '%d' messages to be removed but got '%d'", want, got)
+	}
+	if want, got := 1, store.Count(); want != got {
+		t.Errorf("Count: Expected '%+d' messages but got '%+d'", want, got)
+	}
+
+	held.Close()
+	n, err = store.Purge()
+	if err != nil {
+		t.Errorf("Purge: Failed to purge the messages: %+v", err)
+	} else if want, got := 1, n; want != got {
+		t.Errorf("Purge: Expected '%d' messages to be removed but got '%d'", want, got)
+	}
+	if want, got := 0, store.Count(); want != got {
+		t.Errorf("Count: Expected '%+d' messages but got '%+d'", want, got)
+	}
+}
+
+// TestDeadLetter checks that dead-lettered data isn't retrieved again until
+// it's requeued.
+func TestDeadLetter(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "local-dead-letter-fs*")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewFS(dir, 0)
+	defer store.Close()
+
+	test_cases := [][]byte{
+		[]byte("One, two! One, two! And through and through"),
+		[]byte("The vorpal blade went snicker-snack!"),
+	}
+	for i, msg := range test_cases {
+		err = store.Store(msg)
+		if err != nil {
+			t.Errorf("%d: Store: Failed to store the message '%s': %+v", i, msg, err)
+		}
+
+		data, err := store.Get()
+		if err != nil {
+			t.Fatalf("%d: Get: Failed to retrieve the message: %+v", i, err)
+		}
+		err = data.DeadLetter()
+		if err != nil {
+			t.Errorf("%d: DeadLetter: Failed to dead-letter the message: %+v", i, err)
+		}
+	}
+
+	if want, got := 0, store.Count(); want != got {
+		t.Errorf("Count: Expected '%+d' messages but got '%+d'", want, got)
+	}
+	if _, err := store.Get(); err != ErrGetEmpty {
+		t.Errorf("Get: Expected error '%+v' but got '%+v'", ErrGetEmpty, err)
+	}
+
+	dead, err := store.DeadLetters()
+	if err != nil {
+		t.Fatalf("DeadLetters: Failed to list the dead letters: %+v", err)
+	} else if want, got := len(test_cases), len(dead); want != got {
+		t.Fatalf("DeadLetters: Expected '%d' dead letters but got '%d'", want, got)
+	}
+
+	err = store.Requeue(dead[0].ID)
+	if err != nil {
+		t.Errorf("Requeue: Failed to requeue the message: %+v", err)
+	}
+	if want, got := 1, store.Count(); want != got {
+		t.Errorf("Count: Expected '%+d' messages but got '%+d'", want, got)
+	}
+	data, err := store.Get()
+	if err != nil {
+		t.Errorf("Get: Failed to retrieve the requeued message: %+v", err)
+	} else if bytes.Compare(dead[0].Bytes, data.Bytes()) != 0 {
+		t.Errorf("Get: Message does not match! Want '%s' but got '%s'", dead[0].Bytes, data.Bytes())
+	}
+
+	err = store.RemoveDeadLetter(dead[0].ID)
+	if want, got := ErrNotFound, err; want != got {
+		t.Errorf("RemoveDeadLetter: Expected error '%+v' but got '%+v'", want, got)
+	}
+	n, err := store.PurgeDeadLetters()
+	if err != nil {
+		t.Errorf("PurgeDeadLetters: Failed to purge the dead letters: %+v", err)
+	} else if want, got := 1, n; want != got {
+		t.Errorf("PurgeDeadLetters: Expected '%d' dead letters to be removed but got '%d'", want, got)
+	}
+}
+
+// TestWake checks that Wake forcefully wakes up a Waiting goroutine.
+func TestWake(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "local-wake-fs*")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewFS(dir, 0)
+	defer store.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- store.Wait()
+	} ()
+
+	store.Wake()
+	select {
+	case err := <-done:
+		if want, got := ErrTimedOut, err; want != got {
+			t.Errorf("Wait: Expected error '%+v' but got '%+v'", want, got)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Wait: Wasn't woken up")
+	}
+}
+
+// TestSetTimeout checks that changing the timeout makes a Waiting
+// goroutine time out (or not) accordingly.
+func TestSetTimeout(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "local-timeout-fs*")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewFS(dir, time.Hour)
+	defer store.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- store.Wait()
+	} ()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Wait: Returned '%+v' before the timeout was changed", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	store.SetTimeout(10 * time.Millisecond)
+	select {
+	case err := <-done:
+		if want, got := ErrTimedOut, err; want != got {
+			t.Errorf("Wait: Expected error '%+v' but got '%+v'", want, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Wait: Didn't time out after the timeout was changed")
+	}
+
+	store.SetTimeout(0)
+	go func() {
+		done <- store.Wait()
+	} ()
+	select {
+	case err := <-done:
+		t.Errorf("Wait: Returned '%+v' after the timeout was disabled", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	store.Close()
+	<-done
+}
+
+// TestClose checks that closing the store wakes every waiting goroutine,
+// and that it may be closed more than once, concurrently.
+func TestClose(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "local-close-fs*")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewFS(dir, 0)
+
+	const waiters = 4
+	done := make(chan error, waiters)
+	for i := 0; i < waiters; i++ {
+		go func() {
+			done <- store.Wait()
+		} ()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	closed := make(chan struct{})
+	for i := 0; i < 3; i++ {
+		go func() {
+			if err := store.Close(); err != nil {
+				t.Errorf("Close: Failed to close the store: %+v", err)
+			}
+			closed <- struct{}{}
+		} ()
+	}
+	for i := 0; i < 3; i++ {
+		<-closed
+	}
+
+	for i := 0; i < waiters; i++ {
+		select {
+		case err := <-done:
+			if want, got := ErrStoreClosed, err; want != got {
+				t.Errorf("%d: Wait: Expected error '%+v' but got '%+v'", i, want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%d: Wait: Wasn't woken up once closed", i)
+		}
+	}
+
+	if want, got := ErrStoreClosed, store.Wait(); want != got {
+		t.Errorf("Wait: Expected error '%+v' after closing but got '%+v'", want, got)
+	}
+}
+
+// TestEvents checks that every change to the stored data is reported to the
+// registered hooks.
+func TestEvents(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "local-events-fs*")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewFS(dir, 0)
+	defer store.Close()
+
+	var events []Event
+	store.OnEvent(func(ev Event) {
+		events = append(events, ev)
+	})
+
+	err = store.Store([]byte("Beware the Jubjub bird, and shun"))
+	if err != nil {
+		t.Fatalf("Store: Failed to store the message: %+v", err)
+	}
+	data, err := store.Get()
+	if err != nil {
+		t.Fatalf("Get: Failed to retrieve the message: %+v", err)
+	}
+	id := data.ID()
+	data.DeadLetter()
+	store.Requeue(id)
+	data, err = store.Get()
+	if err != nil {
+		t.Fatalf("Get: Failed to retrieve the requeued message: %+v", err)
+	}
+	data.Remove()
+	purgedID, err := store.StorePriority([]byte("The frumious Bandersnatch!"), PriorityNormal)
+	if err != nil {
+		t.Fatalf("StorePriority: Failed to store the message: %+v", err)
+	}
+	store.RemoveByID(purgedID)
+
+	want := []struct{
+		typ EventType
+		queued int
+		id string
+	}{
+		{EventStored, 1, id},
+		{EventDeadLettered, 0, id},
+		{EventRequeued, 1, id},
+		{EventRemoved, 0, id},
+		{EventStored, 1, purgedID},
+		{EventPurged, 0, purgedID},
+	}
+	if len(want) != len(events) {
+		t.Fatalf("OnEvent: Expected '%d' events but got '%d' (%+v)", len(want), len(events), events)
+	}
+	for i, ev := range events {
+		if want[i].typ != ev.Type || want[i].queued != ev.Queued {
+			t.Errorf("%d: OnEvent: Expected '%s' (queued: %d) but got '%s' (queued: %d)", i, want[i].typ, want[i].queued, ev.Type, ev.Queued)
+		} else if want[i].id != ev.ID {
+			t.Errorf("%d: OnEvent: Expected ID '%s' but got '%s'", i, want[i].id, ev.ID)
+		}
+	}
+}
+
+// TestQuarantine checks that corrupted files are moved to the quarantine
+// area, and reported by Stats.
+func TestQuarantine(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "local-quarantine-fs*")
+	if err != nil {
+		t.Errorf("Failed to create temporary directory: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The name's hash doesn't match the contents.
+	corrupted := "2024-01-02-03-04-05-0000000000000000000000000000000000000000000000000000000000000000"
+	err = os.WriteFile(filepath.Join(dir, corrupted), []byte("Long time the manxome foe he sought"), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: Failed to write the corrupted file: %+v", err)
+	}
+
+	store := NewFS(dir, 0)
+	defer store.Close()
+
+	data := []byte("So rested he by the Tumtum tree")
+	err = store.Store(data)
+	if err != nil {
+		t.Fatalf("Store: Failed to store the message: %+v", err)
+	}
+	if n := store.Count(); n != 2 {
+		t.Errorf("Count: Expected '2' but got '%d'", n)
+	}
+
+	// The corrupted file is the oldest, so it's found first.
+	got, err := store.Get()
+	if err != nil {
+		t.Fatalf("Get: Failed to retrieve the message: %+v", err)
+	} else if !bytes.Equal(got.Bytes(), data) {
+		t.Errorf("Get: Expected '%s' but got '%s'", data, got.Bytes())
+	}
+	if n := store.Count(); n != 1 {
+		t.Errorf("Count: Expected '1' but got '%d'", n)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".quarantine", corrupted)); err != nil {
+		t.Errorf("Stat: Expected the corrupted file to be quarantined: %+v", err)
+	}
+
+	// The retrieved data is locked, so it can't be retrieved again.
+	if _, err := store.Get(); err != ErrGetEmpty {
+		t.Errorf("Get: Expected error '%+v' but got '%+v'", ErrGetEmpty, err)
+	}
+
+	st, err := store.Stats()
+	if err != nil {
+		t.Fatalf("Stats: Failed to retrieve the stats: %+v", err)
+	}
+	want := Stats{
+		Bytes: int64(len(data)),
+		Quarantined: 1,
+		LockContention: 1,
+	}
+	if st != want {
+		t.Errorf("Stats: Expected '%+v' but got '%+v'", want, st)
+	}
+	got.Close()
+}
+
+// TestWrappedError checks that failures match their error code, while
+// still wrapping their cause.
+func TestWrappedError(t *testing.T) {
+	dir := t.TempDir()
+
+	store := NewFS(dir, 0)
+	defer store.Close()
+
+	// A directory in the way of a requeued dead letter can't be replaced.
+	id := "2000-01-01-00-00-00-in-the-way"
+	err := os.WriteFile(filepath.Join(dir, ".dead", id), []byte("Long time the manxome foe he sought"), 0600)
+	if err != nil {
+		t.Fatalf("Failed to write the dead letter: %+v", err)
+	}
+	err = os.MkdirAll(filepath.Join(dir, id, "child"), 0755)
+	if err != nil {
+		t.Fatalf("Failed to create the directory: %+v", err)
+	}
+
+	err = store.Requeue(id)
+	var lsErr *Error
+	if !errors.Is(err, ErrStoreFailed) {
+		t.Errorf("Requeue: Expected error '%+v' but got '%+v'", ErrStoreFailed, err)
+	} else if !errors.As(err, &lsErr) || lsErr.Op != "Requeue" {
+		t.Errorf("Requeue: Expected an *Error for 'Requeue' but got '%+v'", err)
+	} else if !errors.Is(err, syscall.EISDIR) && !errors.Is(err, syscall.ENOTEMPTY) && !errors.Is(err, syscall.EEXIST) {
+		t.Errorf("Requeue: Expected the error to wrap the cause but got '%+v'", err)
+	}
+}
+
+// FuzzID checks that IDs that could escape the store's directory, or that
+// the file system would reject, are reported as invalid.
+func FuzzID(f *testing.F) {
+	dir := f.TempDir()
+	store := NewFS(dir, 0)
+	f.Cleanup(func() {
+		store.Close()
+	})
+
+	f.Add("2024-01-02-03-04-05-0000000000000000000000000000000000000000000000000000000000000000")
+	f.Add("2024-01-02-03-04-05-0000000000000000000000000000000000000000000000000000000000000000.high")
+	f.Add("2000-01-01-00-00-00-old")
+	f.Add("../2000-01-01-00-00-00-old")
+	f.Add("2000-01-01-00-00-00-/../../etc/passwd")
+	f.Add("2000-01-01-00-00-00-\x00")
+	f.Add("2000-99-99-00-00-00-old")
+	f.Add(".lock")
+	f.Add("")
+
+	f.Fuzz(func(t *testing.T, id string) {
+		valid := validID(id)
+		if valid && filepath.Dir(filepath.Join(dir, id)) != dir {
+			t.Errorf("validID: '%q' escapes the store's directory", id)
+		}
+		PriorityOf(id)
+
+		if _, err := StoredAt(id); valid != (err == nil) {
+			t.Errorf("StoredAt: Expected '%q' to be valid (%t) but got %+v", id, valid, err)
+		}
+
+		want := ErrNotFound
+		if !valid {
+			want = ErrInvalidID
+		}
+		if _, err := store.Lookup(id); err != want {
+			t.Errorf("Lookup: Expected error '%+v' for '%q' but got '%+v'", want, id, err)
+		}
+		if _, err := store.Position(id); err != want {
+			t.Errorf("Position: Expected error '%+v' for '%q' but got '%+v'", want, id, err)
+		}
+		if err := store.RemoveByID(id); err != want {
+			t.Errorf("RemoveByID: Expected error '%+v' for '%q' but got '%+v'", want, id, err)
+		}
+		if err := store.Requeue(id); err != want {
+			t.Errorf("Requeue: Expected error '%+v' for '%q' but got '%+v'", want, id, err)
+		}
+		if err := store.RemoveDeadLetter(id); err != want {
+			t.Errorf("RemoveDeadLetter: Expected error '%+v' for '%q' but got '%+v'", want, id, err)
+		}
+	})
+}
+
+// FuzzStorePriority checks that the name of every stored file is a valid ID
+// that encodes the data's priority and hash.
+func FuzzStorePriority(f *testing.F) {
+	store := NewFS(f.TempDir(), 0)
+	f.Cleanup(func() {
+		store.Close()
+	})
+
+	f.Add([]byte("One, two! One, two! And through and through"), int(PriorityNormal))
+	f.Add([]byte{}, int(PriorityLow))
+	f.Add([]byte("\xff\xfe\x00"), int(PriorityHigh))
+
+	f.Fuzz(func(t *testing.T, data []byte, p int) {
+		priority := Priority(p)
+		id, err := store.StorePriority(data, priority)
+		if priority < PriorityLow || priority > PriorityHigh {
+			if err != ErrInvalidPriority {
+				t.Errorf("StorePriority: Expected error '%+v' but got '%+v'", ErrInvalidPriority, err)
+			}
+			return
+		} else if err != nil && err != ErrDuplicatedStore {
+			t.Fatalf("StorePriority: Failed to store the data: %+v", err)
+		}
+		defer store.RemoveByID(id)
+
+		hash := sha256.Sum256(data)
+		if !validID(id) {
+			t.Errorf("validID: Expected '%s' to be valid", id)
+		}
+		if got := PriorityOf(id); got != priority {
+			t.Errorf("PriorityOf: Expected '%s' but got '%s'", priority, got)
+		}
+		if got, ok := nameHash(id); !ok || got != hex.EncodeToString(hash[:]) {
+			t.Errorf("nameHash: Expected '%x' but got '%s'", hash, got)
+		}
+		if entry, err := store.Lookup(id); err != nil || !bytes.Equal(entry.Bytes, data) {
+			t.Errorf("Lookup: Expected '%q' but got '%q' (%+v)", data, entry.Bytes, err)
+		}
+	})
+}
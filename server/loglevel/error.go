@@ -0,0 +1,21 @@
+package loglevel
+
+type error_code uint
+
+const (
+	// The level isn't one of debug, info, warn or error.
+	ErrInvalidLevel error_code = iota
+	// A component's level isn't set as <component>=<level>.
+	ErrInvalidComponent
+)
+
+func (e error_code) Error() string {
+	switch e {
+	case ErrInvalidLevel:
+		return "The level isn't one of debug, info, warn or error."
+	case ErrInvalidComponent:
+		return "A component's level isn't set as <component>=<level>."
+	default:
+		return "Invalid loglevel error."
+	}
+}
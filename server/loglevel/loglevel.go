@@ -0,0 +1,196 @@
+/*
+Package loglevel filters the entries of a slog.Handler by their level,
+which may be set for each component of the application.
+
+An entry's component is set by its ComponentKey attribute, usually added
+to a logger with slog.Logger.With. Entries of components without their own
+level (or without a component) are filtered by the default level. The
+levels may be changed at any time, affecting every logger derived from the
+handler.
+
+Example:
+
+	levels, err := loglevel.Parse("info", "store=debug,web=warn")
+	if err != nil {
+		// handle err
+	}
+	h := loglevel.NewHandler(slog.NewTextHandler(os.Stderr, nil), levels)
+	slog.SetDefault(slog.New(h))
+
+	storeLog := slog.Default().With(loglevel.ComponentKey, "store")
+	storeLog.Debug("Logged")
+	slog.Debug("Not logged")
+
+	// Later, e.g., on SIGHUP.
+	h.SetLevels(loglevel.Levels{Default: slog.LevelDebug})
+*/
+package loglevel
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+)
+
+// ComponentKey is the attribute that identifies the component that logged
+// an entry.
+const ComponentKey = "component"
+
+// Levels are the minimum levels of the entries that are logged.
+type Levels struct {
+	// The minimum level of the entries of components without their own
+	// level, or without a component.
+	Default slog.Level
+
+	// The minimum level of each component's entries.
+	Components map[string]slog.Level
+}
+
+// of retrieves the minimum level of component's entries.
+func (l *Levels) of(component string) slog.Level {
+	if level, ok := l.Components[component]; ok {
+		return level
+	}
+	return l.Default
+}
+
+// Parse parses the default level (e.g., "info") and a comma separated list
+// of each component's level (e.g., "store=debug,web=warn"). Levels are
+// either debug, info, warn or error, case-insensitively.
+func Parse(level, components string) (Levels, error) {
+	var levels Levels
+
+	err := levels.Default.UnmarshalText([]byte(level))
+	if err != nil {
+		return levels, ErrInvalidLevel
+	}
+
+	for _, entry := range strings.Split(components, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+
+		name, value, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || len(name) == 0 {
+			return levels, ErrInvalidComponent
+		}
+
+		var level slog.Level
+		err := level.UnmarshalText([]byte(strings.TrimSpace(value)))
+		if err != nil {
+			return levels, ErrInvalidLevel
+		}
+
+		if levels.Components == nil {
+			levels.Components = make(map[string]slog.Level)
+		}
+		levels.Components[name] = level
+	}
+
+	return levels, nil
+}
+
+// Handler wraps a slog.Handler, only handling the entries whose level is
+// at least their component's minimum level.
+type Handler struct {
+	// The wrapped handler. It must handle entries of every level.
+	inner slog.Handler
+
+	// The levels, shared by every handler derived from the same one.
+	levels *atomic.Pointer[Levels]
+
+	// The component of the entries handled by this handler, if set
+	// through WithAttrs.
+	component string
+
+	// Whether the handler's attributes are within a group, in which case
+	// they don't set the component.
+	grouped bool
+}
+
+// NewHandler wraps inner, filtering its entries by levels. inner must
+// handle entries of every level (e.g., with slog.HandlerOptions.Level set
+// to slog.LevelDebug), as it's only called for the entries that should be
+// logged.
+func NewHandler(inner slog.Handler, levels Levels) *Handler {
+	h := &Handler{
+		inner: inner,
+		levels: &atomic.Pointer[Levels]{},
+	}
+	h.levels.Store(&levels)
+	return h
+}
+
+// SetLevels replaces the levels of h and of every handler derived from it
+// (or from the handler it was derived from).
+func (h *Handler) SetLevels(levels Levels) {
+	h.levels.Store(&levels)
+}
+
+// lowest retrieves the lowest level of any component (or the default).
+func (l *Levels) lowest() slog.Level {
+	lowest := l.Default
+	for _, level := range l.Components {
+		lowest = min(lowest, level)
+	}
+	return lowest
+}
+
+// Enabled checks whether entries of level are logged by the handler's
+// component. If it doesn't have a component, the entry's own attributes
+// may set it, so its level is only checked by Handle.
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	levels := h.levels.Load()
+
+	minLevel := levels.of(h.component)
+	if len(h.component) == 0 && !h.grouped {
+		minLevel = levels.lowest()
+	}
+	return level >= minLevel && h.inner.Enabled(ctx, level)
+}
+
+// Handle logs r, unless its own attributes set a component whose level is
+// higher than r's.
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	if len(h.component) == 0 && !h.grouped {
+		component := ""
+		r.Attrs(func (a slog.Attr) bool {
+			if a.Key == ComponentKey {
+				component = a.Value.String()
+				return false
+			}
+			return true
+		})
+		if r.Level < h.levels.Load().of(component) {
+			return nil
+		}
+	}
+
+	return h.inner.Handle(ctx, r)
+}
+
+// WithAttrs creates a handler with attrs, which may set the component of
+// its entries.
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	derived := *h
+	derived.inner = h.inner.WithAttrs(attrs)
+	if !h.grouped {
+		for _, a := range attrs {
+			if a.Key == ComponentKey {
+				derived.component = a.Value.String()
+			}
+		}
+	}
+	return &derived
+}
+
+// WithGroup creates a handler whose attributes are within the group name.
+func (h *Handler) WithGroup(name string) slog.Handler {
+	derived := *h
+	derived.inner = h.inner.WithGroup(name)
+	derived.grouped = derived.grouped || len(name) > 0
+	return &derived
+}
@@ -0,0 +1,97 @@
+package loglevel
+
+import (
+	"bytes"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestParse checks that both the default level and each component's
+// level are parsed.
+func TestParse(t *testing.T) {
+	test_cases := []struct{
+		level string
+		components string
+		want Levels
+		err error
+	}{
+		{ level: "info", want: Levels{ Default: slog.LevelInfo } },
+		{ level: "DEBUG", components: " , ", want: Levels{ Default: slog.LevelDebug } },
+		{
+			level: "warn",
+			components: "store=debug, web = error",
+			want: Levels{
+				Default: slog.LevelWarn,
+				Components: map[string]slog.Level{
+					"store": slog.LevelDebug,
+					"web": slog.LevelError,
+				},
+			},
+		},
+		{ level: "", err: ErrInvalidLevel },
+		{ level: "verbose", err: ErrInvalidLevel },
+		{ level: "info", components: "store", err: ErrInvalidComponent },
+		{ level: "info", components: "=debug", err: ErrInvalidComponent },
+		{ level: "info", components: "store=loud", err: ErrInvalidLevel },
+	}
+
+	for i, tc := range test_cases {
+		got, err := Parse(tc.level, tc.components)
+		if err != tc.err {
+			t.Errorf("%d: Parse: Expected error '%+v' but got '%+v'", i, tc.err, err)
+		} else if err == nil && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%d: Parse: Expected '%+v' but got '%+v'", i, tc.want, got)
+		}
+	}
+}
+
+// newTestLogger creates a logger, filtered by levels, whose entries are
+// written to the returned buffer.
+func newTestLogger(levels Levels) (*slog.Logger, *Handler, *bytes.Buffer) {
+	var buf bytes.Buffer
+	inner := slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})
+	h := NewHandler(inner, levels)
+	return slog.New(h), h, &buf
+}
+
+// TestHandler checks that entries are filtered by their component's level,
+// however the component is set.
+func TestHandler(t *testing.T) {
+	logger, h, buf := newTestLogger(Levels{
+		Default: slog.LevelInfo,
+		Components: map[string]slog.Level{
+			"store": slog.LevelDebug,
+			"web": slog.LevelWarn,
+		},
+	})
+	store := logger.With(ComponentKey, "store")
+	web := logger.With(ComponentKey, "web")
+	grouped := logger.WithGroup("g").With(ComponentKey, "store")
+
+	test_cases := []struct{
+		log func()
+		want bool
+	}{
+		{ log: func() { logger.Debug("default-debug") }, want: false },
+		{ log: func() { logger.Info("default-info") }, want: true },
+		{ log: func() { store.Debug("store-debug") }, want: true },
+		{ log: func() { web.Info("web-info") }, want: false },
+		{ log: func() { web.Warn("web-warn") }, want: true },
+		{ log: func() { logger.Debug("inline-store-debug", ComponentKey, "store") }, want: true },
+		{ log: func() { logger.Info("inline-web-info", ComponentKey, "web") }, want: false },
+		{ log: func() { logger.With(ComponentKey, "sender").Debug("unknown-debug") }, want: false },
+		{ log: func() { grouped.Debug("grouped-debug") }, want: false },
+		{ log: func() { h.SetLevels(Levels{ Default: slog.LevelDebug }); web.Debug("reloaded-debug") }, want: true },
+	}
+
+	for i, tc := range test_cases {
+		buf.Reset()
+		tc.log()
+
+		if got := buf.Len() > 0; got != tc.want {
+			t.Errorf("%d: Expected logged '%+v' but got '%+v' (%s)", i, tc.want, got, strings.TrimSpace(buf.String()))
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package logrotate
+
+type error_code uint
+
+const (
+	// The file was already closed.
+	ErrClosed error_code = iota
+)
+
+func (e error_code) Error() string {
+	switch e {
+	case ErrClosed:
+		return "The log file was closed."
+	default:
+		return "Invalid logrotate error."
+	}
+}
@@ -0,0 +1,280 @@
+/*
+Package logrotate implements a log file that rotates itself, once it grows
+too large or once a new period (e.g., a new day) starts, so services may log
+to a file without an external logrotate configuration.
+
+Rotated files are kept in the same directory as the log, with the time of
+the rotation added to their names (e.g., "server.log" is rotated to
+"server-2006-01-02T15-04-05.000.log"). The oldest ones are removed, so at
+most Options.MaxBackups files are kept, and none older than Options.MaxAge.
+
+Example:
+
+	f, err := logrotate.Open("/var/log/server.log", logrotate.Options{
+		MaxSize: 100 << 20,
+		Period: 24 * time.Hour,
+		MaxBackups: 7,
+	})
+	if err != nil {
+		// handle err
+	}
+	defer f.Close()
+
+	logger := slog.New(slog.NewTextHandler(f, nil))
+*/
+package logrotate
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timeFormat is the format of the time added to the rotated files' names.
+const timeFormat = "2006-01-02T15-04-05.000"
+
+// Options configures when a log file is rotated and how many rotated files
+// are kept. Zero disables each option.
+type Options struct {
+	// Size, in bytes, after which the file is rotated.
+	MaxSize int64
+
+	// The file is rotated once a new period starts (e.g., on every UTC
+	// midnight, for 24 hours).
+	Period time.Duration
+
+	// How many rotated files are kept.
+	MaxBackups int
+
+	// How long rotated files are kept.
+	MaxAge time.Duration
+}
+
+// File is a log file that rotates itself. It's safe for concurrent use.
+type File struct {
+	// Synchronizes access to every field below.
+	mu sync.Mutex
+
+	// Path to the log file.
+	path string
+
+	// When the file is rotated.
+	opts Options
+
+	// The log file, or nil if closed.
+	f *os.File
+
+	// The file's current size.
+	size int64
+
+	// The period in which the file was last written.
+	period time.Time
+
+	// Retrieves the current time.
+	now func() time.Time
+}
+
+// Open the log file in path, creating it if it doesn't exist. If the file
+// already exists, new entries are appended to it, unless it must be rotated
+// (e.g., it was last written in a previous period).
+func Open(path string, opts Options) (*File, error) {
+	f := &File{
+		path: path,
+		opts: opts,
+		now: time.Now,
+	}
+
+	err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open the log file, appending to it.
+func (f *File) open() error {
+	err := os.MkdirAll(filepath.Dir(f.path), 0755)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.f = file
+	f.size = info.Size()
+	f.period = f.periodOf(info.ModTime())
+	if f.size == 0 {
+		f.period = f.periodOf(f.now())
+	}
+	return nil
+}
+
+// periodOf retrieves the start of the period that contains t.
+func (f *File) periodOf(t time.Time) time.Time {
+	if f.opts.Period <= 0 {
+		return time.Time{}
+	}
+	return t.Truncate(f.opts.Period)
+}
+
+// Write p to the log file, rotating it first if p doesn't fit in it, or
+// if a new period started.
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.f == nil {
+		return 0, ErrClosed
+	}
+
+	full := f.opts.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.opts.MaxSize
+	if full || !f.periodOf(f.now()).Equal(f.period) {
+		err := f.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.f.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Rotate the log file, regardless of its size and age.
+func (f *File) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.f == nil {
+		return ErrClosed
+	}
+	return f.rotate()
+}
+
+// rotate the log file, then remove the rotated files that shouldn't be
+// kept anymore. f.mu must be held.
+func (f *File) rotate() error {
+	err := f.f.Close()
+	f.f = nil
+	if err != nil {
+		return err
+	}
+
+	if f.size > 0 {
+		err = os.Rename(f.path, f.backupName(f.now()))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = f.open()
+	if err != nil {
+		return err
+	}
+	return f.removeOld()
+}
+
+// backupName retrieves the name of the log file, if rotated at t.
+func (f *File) backupName(t time.Time) string {
+	ext := filepath.Ext(f.path)
+	base := strings.TrimSuffix(f.path, ext)
+	return base + "-" + t.UTC().Format(timeFormat) + ext
+}
+
+// backup is a rotated log file.
+type backup struct {
+	// Path to the file.
+	path string
+
+	// When the file was rotated.
+	rotated time.Time
+}
+
+// backups lists the rotated log files, from the newest to the oldest.
+func (f *File) backups() ([]backup, error) {
+	ext := filepath.Ext(f.path)
+	prefix := strings.TrimSuffix(filepath.Base(f.path), ext) + "-"
+
+	entries, err := os.ReadDir(filepath.Dir(f.path))
+	if err != nil {
+		return nil, err
+	}
+
+	var list []backup
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+
+		ts := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext)
+		rotated, err := time.Parse(timeFormat, ts)
+		if err != nil {
+			// Some other file, with a similar name.
+			continue
+		}
+		list = append(list, backup{
+			path: filepath.Join(filepath.Dir(f.path), name),
+			rotated: rotated,
+		})
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].rotated.After(list[j].rotated)
+	})
+	return list, nil
+}
+
+// removeOld removes the rotated log files over Options.MaxBackups, and the
+// ones older than Options.MaxAge.
+func (f *File) removeOld() error {
+	if f.opts.MaxBackups <= 0 && f.opts.MaxAge <= 0 {
+		return nil
+	}
+
+	list, err := f.backups()
+	if err != nil {
+		return err
+	}
+
+	now := f.now()
+	for i, b := range list {
+		tooMany := f.opts.MaxBackups > 0 && i >= f.opts.MaxBackups
+		tooOld := f.opts.MaxAge > 0 && now.Sub(b.rotated) > f.opts.MaxAge
+		if !tooMany && !tooOld {
+			continue
+		}
+
+		err = os.Remove(b.path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close the log file.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.f == nil {
+		return ErrClosed
+	}
+
+	err := f.f.Close()
+	f.f = nil
+	return err
+}
@@ -0,0 +1,145 @@
+package logrotate
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openAt opens a log file in a temporary directory, whose clock is
+// controlled by the returned pointer.
+func openAt(t *testing.T, opts Options, start time.Time) (*File, *time.Time) {
+	now := start
+	path := filepath.Join(t.TempDir(), "server.log")
+
+	f, err := Open(path, opts)
+	if err != nil {
+		t.Fatalf("Open: Failed to open '%s': %+v", path, err)
+	}
+	f.now = func() time.Time { return now }
+	// Start the period with the fake clock.
+	f.period = f.periodOf(now)
+
+	t.Cleanup(func() { f.Close() })
+	return f, &now
+}
+
+// logFiles lists the contents of every file in f's directory, from the
+// newest rotated file to the current one.
+func logFiles(t *testing.T, f *File) []string {
+	list, err := f.backups()
+	if err != nil {
+		t.Fatalf("backups: Failed to list the rotated files: %+v", err)
+	}
+
+	var got []string
+	for i := len(list) - 1; i >= 0; i-- {
+		data, _ := os.ReadFile(list[i].path)
+		got = append(got, string(data))
+	}
+	data, _ := os.ReadFile(f.path)
+	return append(got, string(data))
+}
+
+// TestRotate checks that the log file is rotated by its size and by its
+// period, and that old files are removed.
+func TestRotate(t *testing.T) {
+	start := time.Date(2024, 5, 10, 23, 59, 0, 0, time.UTC)
+
+	type test_case struct {
+		name string
+		opts Options
+		// Entries written, each one a minute after the other.
+		writes []string
+		// The files' contents, from the oldest to the current one.
+		expect []string
+	}
+
+	test_cases := []test_case{
+		{
+			name: "no rotation",
+			writes: []string{"aaa\n", "bbb\n"},
+			expect: []string{"aaa\nbbb\n"},
+		},
+		{
+			name: "by size",
+			opts: Options{MaxSize: 8},
+			writes: []string{"aaa\n", "bbb\n", "ccc\n", "a very long entry\n", "ddd\n"},
+			expect: []string{"aaa\nbbb\n", "ccc\n", "a very long entry\n", "ddd\n"},
+		},
+		{
+			name: "by period",
+			opts: Options{Period: 24 * time.Hour},
+			writes: []string{"aaa\n", "bbb\n", "ccc\n"},
+			expect: []string{"aaa\n", "bbb\nccc\n"},
+		},
+		{
+			name: "max backups",
+			opts: Options{MaxSize: 4, MaxBackups: 2},
+			writes: []string{"aaa\n", "bbb\n", "ccc\n", "ddd\n"},
+			expect: []string{"bbb\n", "ccc\n", "ddd\n"},
+		},
+		{
+			name: "max age",
+			opts: Options{MaxSize: 4, MaxAge: 90 * time.Second},
+			writes: []string{"aaa\n", "bbb\n", "ccc\n", "ddd\n"},
+			expect: []string{"bbb\n", "ccc\n", "ddd\n"},
+		},
+	}
+
+	for _, tc := range test_cases {
+		f, now := openAt(t, tc.opts, start)
+
+		for _, entry := range tc.writes {
+			n, err := f.Write([]byte(entry))
+			if n != len(entry) || err != nil {
+				t.Errorf("(%s) Write: Expected '%d, <nil>' but got '%d, %+v'", tc.name, len(entry), n, err)
+			}
+			*now = now.Add(time.Minute)
+		}
+
+		got := logFiles(t, f)
+		if strings.Join(got, "|") != strings.Join(tc.expect, "|") {
+			t.Errorf("(%s) Write: Expected '%q' but got '%q'", tc.name, tc.expect, got)
+		}
+	}
+}
+
+// TestOpen checks that an existing log file is appended to, unless it was
+// last written in a previous period.
+func TestOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.log")
+	err := os.WriteFile(path, []byte("old\n"), 0644)
+	if err != nil {
+		t.Fatalf("WriteFile: Failed to create '%s': %+v", path, err)
+	}
+
+	f, err := Open(path, Options{Period: time.Hour})
+	if err != nil {
+		t.Fatalf("Open: Failed to open '%s': %+v", path, err)
+	}
+	f.Write([]byte("new\n"))
+	if got := logFiles(t, f); len(got) != 1 || got[0] != "old\nnew\n" {
+		t.Errorf("Open: Expected the entry to be appended but got '%q'", got)
+	}
+	f.Close()
+
+	past := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(path, past, past)
+	f, err = Open(path, Options{Period: time.Hour})
+	if err != nil {
+		t.Fatalf("Open: Failed to reopen '%s': %+v", path, err)
+	}
+	defer f.Close()
+	f.Write([]byte("newer\n"))
+	if got := logFiles(t, f); len(got) != 2 || got[0] != "old\nnew\n" || got[1] != "newer\n" {
+		t.Errorf("Open: Expected the file to be rotated but got '%q'", got)
+	}
+
+	f.Close()
+	if _, err := f.Write([]byte("closed\n")); err != ErrClosed {
+		t.Errorf("Write: Expected '%+v' but got '%+v'", ErrClosed, err)
+	}
+}
@@ -0,0 +1,17 @@
+package metrics
+
+type error_code uint
+
+const (
+	// The interval between pushes must be positive.
+	ErrInvalidInterval error_code = iota
+)
+
+func (e error_code) Error() string {
+	switch e {
+	case ErrInvalidInterval:
+		return "The interval between pushes must be positive."
+	default:
+		return "Invalid metrics error."
+	}
+}
@@ -0,0 +1,323 @@
+/*
+Package metrics implements a minimal registry of Prometheus metrics, exposed
+in Prometheus' text format.
+
+Counters and gauges are registered by name in a Registry, optionally split
+by labels, and updated as things happen. Values that are expensive to keep
+up to date (e.g., the size of a directory) may instead be updated right
+before the metrics are written, by a function registered through
+"Registry.OnCollect()".
+
+Registering the same name twice returns the same metric, so independent
+components may share it, as long as they agree on its type and labels.
+Otherwise, registering it panics, as that's a programming error.
+
+The metrics may also be pushed to a StatsD (or DogStatsD) server, by a
+StatsD created through "NewStatsD()", for setups that don't scrape them.
+
+Example:
+
+	reg := metrics.NewRegistry()
+
+	sent := reg.Counter("app_sent_total", "Messages sent.", "result")
+	queued := reg.Gauge("app_queued", "Messages waiting to be sent.")
+	reg.OnCollect(func() {
+		queued.Set(float64(store.Count()))
+	})
+
+	sent.Inc("ok")
+
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", metrics.ContentType)
+		reg.WriteText(w)
+	})
+
+	// Or, alternatively, push them to StatsD every 10 seconds.
+	statsd, err := metrics.NewStatsD(reg, "127.0.0.1:8125", false, 10 * time.Second)
+	if err != nil {
+		// handle err
+	}
+	defer statsd.Close()
+*/
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the metrics written by WriteText.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// The types of metric.
+const (
+	typeCounter = "counter"
+	typeGauge = "gauge"
+)
+
+// family is a metric, with a value for each combination of its labels.
+type family struct {
+	// The metric's name.
+	name string
+
+	// Describes the metric.
+	help string
+
+	// Either typeCounter or typeGauge.
+	typ string
+
+	// The names of the metric's labels.
+	labels []string
+
+	// Synchronizes access to values.
+	mutex sync.Mutex
+
+	// The metric's value for each combination of label values, keyed by
+	// the values joined by a NUL.
+	values map[string]float64
+}
+
+// key identifies the combination of labelValues, which must match the
+// family's labels.
+func (f *family) key(labelValues []string) string {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: '%s' expects %d label values but got %d", f.name, len(f.labels), len(labelValues)))
+	}
+	return strings.Join(labelValues, "\x00")
+}
+
+// add v to the value identified by labelValues.
+func (f *family) add(v float64, labelValues []string) {
+	k := f.key(labelValues)
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.values[k] += v
+}
+
+// set the value identified by labelValues to v.
+func (f *family) set(v float64, labelValues []string) {
+	k := f.key(labelValues)
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.values[k] = v
+}
+
+// write the family in Prometheus' text format, with its values sorted by
+// their labels.
+func (f *family) write(w *bufio.Writer) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+
+	if len(f.labels) == 0 {
+		// Unlabeled metrics always have a value.
+		fmt.Fprintf(w, "%s %s\n", f.name, formatValue(f.values[""]))
+		return
+	}
+
+	for _, k := range sortedKeys(f.values) {
+		var pairs []string
+		for i, value := range strings.Split(k, "\x00") {
+			pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", f.labels[i], escapeLabel(value)))
+		}
+		fmt.Fprintf(w, "%s{%s} %s\n", f.name, strings.Join(pairs, ","), formatValue(f.values[k]))
+	}
+}
+
+// each calls fn for each of the family's values, sorted by their labels.
+func (f *family) each(fn func(labelValues []string, v float64)) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if len(f.labels) == 0 {
+		fn(nil, f.values[""])
+		return
+	}
+
+	for _, k := range sortedKeys(f.values) {
+		fn(strings.Split(k, "\x00"), f.values[k])
+	}
+}
+
+// sortedKeys returns the keys of values, sorted.
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// escapeHelp escapes a metric's description.
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+// escapeLabel escapes a label's value.
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
+
+// formatValue formats v as expected by Prometheus.
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counter is a metric that only goes up (e.g., the number of messages
+// sent).
+type Counter struct {
+	f *family
+}
+
+// Add v (which must not be negative) to the counter identified by
+// labelValues (one for each of its labels, in order).
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("metrics: '%s' can't be decreased", c.f.name))
+	}
+	c.f.add(v, labelValues)
+}
+
+// Inc increments the counter identified by labelValues.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Set the counter identified by labelValues to v, for counters kept
+// elsewhere (e.g., updated by a function registered through OnCollect). v
+// must never be lower than the previous value.
+func (c *Counter) Set(v float64, labelValues ...string) {
+	c.f.set(v, labelValues)
+}
+
+// Gauge is a metric that may go up and down (e.g., the number of messages
+// waiting to be sent).
+type Gauge struct {
+	f *family
+}
+
+// Set the gauge identified by labelValues (one for each of its labels, in
+// order) to v.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.f.set(v, labelValues)
+}
+
+// Add v (which may be negative) to the gauge identified by labelValues.
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.f.add(v, labelValues)
+}
+
+// Registry keeps the registered metrics.
+type Registry struct {
+	// Synchronizes access to the other fields.
+	mutex sync.Mutex
+
+	// The registered metrics, by their names.
+	families map[string]*family
+
+	// Called before the metrics are written.
+	collectors []func()
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		families: make(map[string]*family),
+	}
+}
+
+// register the metric name, or retrieve it if it was already registered
+// with the same type and labels.
+func (r *Registry) register(name, help, typ string, labels []string) *family {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if f, ok := r.families[name]; ok {
+		if f.typ != typ || !slices.Equal(f.labels, labels) {
+			panic(fmt.Sprintf("metrics: '%s' was already registered as a different metric", name))
+		}
+		return f
+	}
+
+	f := &family{
+		name: name,
+		help: help,
+		typ: typ,
+		labels: labels,
+		values: make(map[string]float64),
+	}
+	r.families[name] = f
+	return f
+}
+
+// Counter registers the counter name, described by help and split by
+// labels.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{r.register(name, help, typeCounter, labels)}
+}
+
+// Gauge registers the gauge name, described by help and split by labels.
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.register(name, help, typeGauge, labels)}
+}
+
+// OnCollect registers fn to be called before the metrics are written, so
+// it may update them.
+func (r *Registry) OnCollect(fn func()) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.collectors = append(r.collectors, fn)
+}
+
+// collect updates the metrics, through the registered functions, and
+// returns every metric, sorted by their names.
+func (r *Registry) collect() []*family {
+	r.mutex.Lock()
+	collectors := slices.Clone(r.collectors)
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mutex.Unlock()
+
+	for _, fn := range collectors {
+		fn()
+	}
+
+	slices.SortFunc(families, func(a, b *family) int {
+		return strings.Compare(a.name, b.name)
+	})
+	return families
+}
+
+// WriteText writes every metric to w, in Prometheus' text format, sorted by
+// their names.
+func (r *Registry) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range r.collect() {
+		f.write(bw)
+	}
+	return bw.Flush()
+}
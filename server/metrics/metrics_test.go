@@ -0,0 +1,144 @@
+package metrics
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestWriteText checks that metrics are written in Prometheus' text format,
+// sorted and escaped, after being updated by the collectors.
+func TestWriteText(t *testing.T) {
+	reg := NewRegistry()
+
+	sent := reg.Counter("test_sent_total", "Messages sent.", "result")
+	sent.Inc("ok")
+	sent.Add(2, "ok")
+	sent.Inc("fail \"quoted\"")
+
+	queued := reg.Gauge("test_queued", "Messages\nqueued.")
+	n := 0
+	reg.OnCollect(func() {
+		n++
+		queued.Set(float64(10 * n))
+	})
+
+	// Registering the same metric again shares it.
+	reg.Counter("test_sent_total", "Messages sent.", "result").Inc("ok")
+
+	var buf bytes.Buffer
+	err := reg.WriteText(&buf)
+	if err != nil {
+		t.Fatalf("WriteText: Failed to write the metrics: %+v", err)
+	}
+
+	want := `# HELP test_queued Messages\nqueued.
+# TYPE test_queued gauge
+test_queued 10
+# HELP test_sent_total Messages sent.
+# TYPE test_sent_total counter
+test_sent_total{result="fail \"quoted\""} 1
+test_sent_total{result="ok"} 4
+`
+	if got := buf.String(); got != want {
+		t.Errorf("WriteText: Expected:\n%s\nbut got:\n%s", want, got)
+	}
+}
+
+// TestRegisterConflict checks that registering a name as a different metric
+// panics.
+func TestRegisterConflict(t *testing.T) {
+	test_cases := []func(reg *Registry){
+		func(reg *Registry) { reg.Gauge("test_total", "") },
+		func(reg *Registry) { reg.Counter("test_total", "", "other") },
+	}
+
+	for i, tc := range test_cases {
+		reg := NewRegistry()
+		reg.Counter("test_total", "", "label")
+
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%d: Register: Expected a panic", i)
+				}
+			} ()
+			tc(reg)
+		} ()
+	}
+}
+
+// TestStatsD checks that metrics are pushed to StatsD, with counters sent as
+// their increase since the previous push, on both flavors of StatsD.
+func TestStatsD(t *testing.T) {
+	test_cases := []struct{
+		dogStatsD bool
+		first string
+		second string
+	}{
+		{
+			dogStatsD: false,
+			first: `test_queued:5|g
+test_sent_total.fail__quoted_:1|c
+test_sent_total.ok:3|c`,
+			second: `test_queued:0|g
+test_queued:-2|g
+test_sent_total.ok:1.5|c`,
+		},
+		{
+			dogStatsD: true,
+			first: `test_queued:5|g
+test_sent_total:1|c|#result:fail "quoted"
+test_sent_total:3|c|#result:ok`,
+			second: `test_queued:0|g
+test_queued:-2|g
+test_sent_total:1.5|c|#result:ok`,
+		},
+	}
+
+	for i, tc := range test_cases {
+		func() {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("%d: ListenPacket: Failed to listen: %+v", i, err)
+			}
+			defer conn.Close()
+
+			reg := NewRegistry()
+			sent := reg.Counter("test_sent_total", "Messages sent.", "result")
+			sent.Add(3, "ok")
+			sent.Inc("fail \"quoted\"")
+			queued := reg.Gauge("test_queued", "Messages queued.")
+			queued.Set(5)
+
+			statsd, err := NewStatsD(reg, conn.LocalAddr().String(), tc.dogStatsD, time.Hour)
+			if err != nil {
+				t.Fatalf("%d: NewStatsD: Failed to create the StatsD: %+v", i, err)
+			}
+			defer statsd.Close()
+
+			read := func(want string) {
+				err := statsd.Push()
+				if err != nil {
+					t.Fatalf("%d: Push: Failed to push the metrics: %+v", i, err)
+				}
+
+				buf := make([]byte, maxPacketSize)
+				conn.SetReadDeadline(time.Now().Add(time.Second))
+				n, _, err := conn.ReadFrom(buf)
+				if err != nil {
+					t.Fatalf("%d: ReadFrom: Failed to receive the metrics: %+v", i, err)
+				}
+				if got := string(buf[:n]); got != want {
+					t.Errorf("%d: Push: Expected:\n%s\nbut got:\n%s", i, want, got)
+				}
+			}
+
+			read(tc.first)
+			sent.Add(1.5, "ok")
+			queued.Set(-2)
+			read(tc.second)
+		} ()
+	}
+}
@@ -0,0 +1,217 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxPacketSize is the size, in bytes, of the largest packet sent to StatsD,
+// so it fits in a single Ethernet frame (along with the IP and UDP
+// headers).
+const maxPacketSize = 1432
+
+// StatsD pushes the metrics in a Registry to a StatsD (or DogStatsD) server,
+// over UDP, periodically.
+//
+// Counters are sent as StatsD counters, with how much they increased since
+// the previous push, and gauges are sent as StatsD gauges. Labels are sent
+// as DogStatsD tags or, for plain StatsD, appended to the metric's name
+// (e.g., "app_sent_total.ok").
+type StatsD struct {
+	// The metrics that are pushed.
+	reg *Registry
+
+	// Connection to the StatsD server.
+	conn net.Conn
+
+	// Whether labels are sent as DogStatsD tags.
+	dogStatsD bool
+
+	// Synchronizes pushes.
+	mutex sync.Mutex
+
+	// The counters' values on the previous push, by their StatsD lines
+	// (without the values).
+	last map[string]float64
+
+	// Closed to stop pushing the metrics.
+	stop chan struct{}
+
+	// Closed once the metrics stop being pushed.
+	done chan struct{}
+
+	// Ensures the StatsD is only closed once.
+	closeOnce sync.Once
+}
+
+// NewStatsD creates a StatsD that pushes the metrics in reg to the StatsD
+// server at addr (e.g., "127.0.0.1:8125") every interval, until it's
+// closed. If dogStatsD is set, labels are sent as DogStatsD tags.
+func NewStatsD(reg *Registry, addr string, dogStatsD bool, interval time.Duration) (*StatsD, error) {
+	if interval <= 0 {
+		return nil, ErrInvalidInterval
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &StatsD{
+		reg: reg,
+		conn: conn,
+		dogStatsD: dogStatsD,
+		last: make(map[string]float64),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				// Failures are transient (e.g., the server isn't
+				// running yet), so the next push is simply tried.
+				s.Push()
+			}
+		}
+	} ()
+
+	return s, nil
+}
+
+// Push the metrics to the StatsD server right away.
+func (s *StatsD) Push() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var lines []string
+	for _, f := range s.reg.collect() {
+		f.each(func(labelValues []string, v float64) {
+			lines = append(lines, s.lines(f, labelValues, v)...)
+		})
+	}
+
+	var packet bytes.Buffer
+	var firstErr error
+	send := func() {
+		if packet.Len() == 0 {
+			return
+		}
+		if _, err := s.conn.Write(packet.Bytes()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		packet.Reset()
+	}
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len() + 1 + len(line) > maxPacketSize {
+			send()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	send()
+
+	return firstErr
+}
+
+// lines formats the value of f identified by labelValues as StatsD lines,
+// if there's anything to send.
+func (s *StatsD) lines(f *family, labelValues []string, v float64) []string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+
+	name := f.name
+	var tags string
+	if s.dogStatsD {
+		var pairs []string
+		for i, value := range labelValues {
+			pairs = append(pairs, f.labels[i] + ":" + escapeTag(value))
+		}
+		if len(pairs) > 0 {
+			tags = "|#" + strings.Join(pairs, ",")
+		}
+	} else {
+		for _, value := range labelValues {
+			name += "." + escapeName(value)
+		}
+	}
+
+	switch f.typ {
+	case typeCounter:
+		id := name + "|c" + tags
+		delta := v - s.last[id]
+		if delta < 0 {
+			// The counter was reset.
+			delta = v
+		}
+		s.last[id] = v
+		if delta == 0 {
+			return nil
+		}
+		return []string{fmt.Sprintf("%s:%s|c%s", name, formatStatsD(delta), tags)}
+	default:
+		line := fmt.Sprintf("%s:%s|g%s", name, formatStatsD(v), tags)
+		if v < 0 {
+			// Signed values change the gauge, instead of setting it,
+			// so it's zeroed first.
+			return []string{fmt.Sprintf("%s:0|g%s", name, tags), line}
+		}
+		return []string{line}
+	}
+}
+
+// Close stops pushing the metrics, pushing them one last time, and closes
+// the connection to the StatsD server.
+func (s *StatsD) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		<-s.done
+
+		err = s.Push()
+		if cerr := s.conn.Close(); err == nil {
+			err = cerr
+		}
+	})
+	return err
+}
+
+// escapeName replaces anything in s that can't be part of a StatsD metric's
+// name.
+func escapeName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// escapeTag replaces anything in s that can't be part of a DogStatsD tag.
+func escapeTag(s string) string {
+	return strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_").Replace(s)
+}
+
+// formatStatsD formats v as expected by StatsD, which doesn't accept
+// exponents.
+func formatStatsD(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
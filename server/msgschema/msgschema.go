@@ -0,0 +1,156 @@
+/*
+Package msgschema validates messages against a JSON Schema configured for
+their channel.
+
+Schemas are loaded from a directory, where each channel's schema is named
+"<channel>.json". Channels without a schema accept any message.
+
+A message is validated as the JSON value it holds, if it's valid JSON, or as
+a string otherwise. So, channels may require structured messages (e.g., an
+object with some required properties) as well as plain text ones (e.g., a
+string with a maximum length).
+
+Example:
+
+	v, err := msgschema.Load("schemas")
+	if err != nil {
+		// handle err
+	}
+
+	err = v.Validate("general", `{"title": "Something broke"}`)
+	if verr, ok := err.(*msgschema.Error); ok {
+		// report every verr.Details
+	}
+*/
+package msgschema
+
+import (
+	"encoding/json"
+	"fmt"
+	jsonschema "github.com/santhosh-tekuri/jsonschema/v5"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Detail describes a single invalid value in a message.
+type Detail struct {
+	// JSON pointer to the invalid value.
+	Location string
+
+	// Why the value is invalid.
+	Message string
+}
+
+// Error is returned by Validate for messages that don't match the
+// channel's schema.
+type Error struct {
+	// The message's channel.
+	Channel string
+
+	// Every invalid value in the message.
+	Details []Detail
+}
+
+func (e *Error) Error() string {
+	msgs := make([]string, 0, len(e.Details))
+	for _, d := range e.Details {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", d.Location, d.Message))
+	}
+	return fmt.Sprintf("the message doesn't match the schema of '%s' (%s)", e.Channel, strings.Join(msgs, "; "))
+}
+
+// Validator validates messages against their channel's schema.
+type Validator struct {
+	// The schema of each channel.
+	schemas map[string]*jsonschema.Schema
+}
+
+// Load the schema of every channel in dir.
+func Load(dir string) (*Validator, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	v := &Validator{
+		schemas: make(map[string]*jsonschema.Schema),
+	}
+	for _, file := range files {
+		channel := strings.TrimSuffix(filepath.Base(file), ".json")
+
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+
+		c := jsonschema.NewCompiler()
+		err = c.AddResource(file, f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't load the schema of '%s': %w", channel, err)
+		}
+
+		schema, err := c.Compile(file)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't compile the schema of '%s': %w", channel, err)
+		}
+		v.schemas[channel] = schema
+	}
+
+	return v, nil
+}
+
+// Channels lists every channel with a schema.
+func (v *Validator) Channels() []string {
+	channels := make([]string, 0, len(v.schemas))
+	for channel := range v.schemas {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+// Validate message against the schema of channel. It returns an *Error if
+// the message is invalid, and nil if it's valid or if the channel doesn't
+// have a schema.
+func (v *Validator) Validate(channel, message string) error {
+	schema, ok := v.schemas[channel]
+	if !ok {
+		return nil
+	}
+
+	var data interface{}
+	dec := json.NewDecoder(strings.NewReader(message))
+	dec.UseNumber()
+	if dec.Decode(&data) != nil || dec.More() {
+		data = message
+	}
+
+	err := schema.Validate(data)
+	if ve, ok := err.(*jsonschema.ValidationError); ok {
+		verr := &Error{Channel: channel}
+		collectDetails(ve, &verr.Details)
+		return verr
+	}
+	return err
+}
+
+// collectDetails appends the innermost causes of ve to details, as those
+// describe what's actually wrong with the message.
+func collectDetails(ve *jsonschema.ValidationError, details *[]Detail) {
+	if len(ve.Causes) == 0 {
+		loc := ve.InstanceLocation
+		if len(loc) == 0 {
+			loc = "/"
+		}
+		*details = append(*details, Detail{
+			Location: loc,
+			Message: ve.Message,
+		})
+		return
+	}
+
+	for _, cause := range ve.Causes {
+		collectDetails(cause, details)
+	}
+}
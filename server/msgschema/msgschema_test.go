@@ -0,0 +1,61 @@
+package msgschema
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestValidate checks that messages are validated against their channel's
+// schema, either as JSON or as plain text.
+func TestValidate(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "msgschema*")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	schemas := map[string]string{
+		"alerts": `{"type": "object", "required": ["title"], "properties": {"title": {"type": "string"}}}`,
+		"short": `{"type": "string", "maxLength": 5}`,
+	}
+	for channel, schema := range schemas {
+		err = os.WriteFile(filepath.Join(dir, channel + ".json"), []byte(schema), 0600)
+		if err != nil {
+			t.Fatalf("Failed to write the schema of '%s': %+v", channel, err)
+		}
+	}
+
+	v, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: Failed to load the schemas: %+v", err)
+	} else if want, got := len(schemas), len(v.Channels()); want != got {
+		t.Errorf("Channels: Expected '%d' channels but got '%d'", want, got)
+	}
+
+	test_cases := []struct{
+		channel string
+		message string
+		valid bool
+	}{
+		{"alerts", `{"title": "Something broke"}`, true},
+		{"alerts", `{"title": 42}`, false},
+		{"alerts", `{}`, false},
+		{"alerts", `not json`, false},
+		{"short", `hi`, true},
+		{"short", `way too long`, false},
+		{"general", `anything goes`, true},
+	}
+	for i, tc := range test_cases {
+		err := v.Validate(tc.channel, tc.message)
+		if tc.valid && err != nil {
+			t.Errorf("%d: Validate: Expected '%s' to be valid but got '%+v'", i, tc.message, err)
+		} else if !tc.valid {
+			if verr, ok := err.(*Error); !ok {
+				t.Errorf("%d: Validate: Expected an *Error for '%s' but got '%+v'", i, tc.message, err)
+			} else if len(verr.Details) == 0 {
+				t.Errorf("%d: Validate: The error for '%s' has no details", i, tc.message)
+			}
+		}
+	}
+}
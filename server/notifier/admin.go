@@ -0,0 +1,75 @@
+package notifier
+
+import (
+	"context"
+	"github.com/SirGFM/sqs-issue-notifier/server/auth"
+	"log/slog"
+	"net/http"
+)
+
+// adminListenerKey is the key marking the context of requests received by
+// the admin listener.
+type adminListenerKey struct{}
+
+// fromAdminListener checks whether req was received by the admin listener.
+func fromAdminListener(req *http.Request) bool {
+	ok, _ := req.Context().Value(adminListenerKey{}).(bool)
+	return ok
+}
+
+// startAdmin launches the admin listener on args.AdminAddr, serving every
+// endpoint (including the administrative ones) and pprof. Its requests are
+// authenticated by args.AdminToken, if set, or as on the main listener.
+// It uses the same timeouts as base.
+func (s *server) startAdmin(args Args, base *http.Server) *http.Server {
+	s.adminAuth = s.auth
+	if len(args.AdminToken) > 0 {
+		s.adminAuth, _ = auth.NewToken(args.AdminToken, auth.Principal{
+			Subject: "admin-token",
+			AllChannels: true,
+			Admin: true,
+		})
+	} else if s.adminAuth == nil {
+		slog.Warn("The admin listener isn't authenticated! Make sure it isn't publicly reachable", "addr", args.AdminAddr)
+	}
+
+	mux := pprofMux()
+	mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := context.WithValue(req.Context(), adminListenerKey{}, true)
+		s.ServeHTTP(w, req.WithContext(ctx))
+	}))
+
+	maxBody := int64(args.MaxBodyBytes)
+	handler := chainMiddleware(mux, []Middleware{
+		accessLog,
+		traceRequests,
+		func(next http.Handler) http.Handler {
+			return withGzip(next, maxBody)
+		},
+		authenticateWith(s.adminAuth),
+		recoverPanics,
+	})
+
+	srv := &http.Server {
+		Addr: args.AdminAddr,
+		Handler: handler,
+		ReadHeaderTimeout: base.ReadHeaderTimeout,
+		ReadTimeout: base.ReadTimeout,
+		WriteTimeout: base.WriteTimeout,
+		IdleTimeout: base.IdleTimeout,
+		MaxHeaderBytes: base.MaxHeaderBytes,
+	}
+
+	go func() {
+		slog.Info("Serving the admin listener", "addr", args.AdminAddr)
+		l, err := listen(args.AdminAddr)
+		if err == nil {
+			err = srv.Serve(l)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			slog.Error("The admin listener failed", "err", err)
+		}
+	} ()
+
+	return srv
+}
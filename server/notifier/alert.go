@@ -0,0 +1,246 @@
+package notifier
+
+import (
+	"errors"
+	"fmt"
+	"github.com/SirGFM/sqs-issue-notifier/server/local_storage"
+	"github.com/SirGFM/sqs-issue-notifier/server/sender"
+	"github.com/SirGFM/sqs-issue-notifier/server/sendermw"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// alertTimeout is the timeout for posting each alert to Slack.
+const alertTimeout = 10 * time.Second
+
+// The conditions that raise an alert.
+const (
+	alertBacklog = "backlog"
+	alertOldestAge = "oldest-age"
+	alertSendFailures = "send-failures"
+)
+
+// alerter periodically checks the local storage and the messages sent
+// against the configured thresholds, alerting about the notifier's own
+// distress through a secondary sender (Slack and/or email), which doesn't
+// depend on the queue.
+type alerter struct {
+	// Sends the alerts.
+	s sender.Sender
+
+	// Host where the server is running, identifying it in the alerts.
+	host string
+
+	// Backlog over which an alert is raised, or 0.
+	backlog int
+
+	// Age of the oldest message over which an alert is raised, or 0.
+	oldestAge time.Duration
+
+	// Consecutive failed messages over which an alert is raised, or 0.
+	sendFailures int
+
+	// For how long an alert is raised before being sent again, or 0 to
+	// only send it once.
+	repeat time.Duration
+
+	// Synchronizes access to failures and lastErr.
+	mutex sync.Mutex
+
+	// Number of consecutive messages that failed to be sent.
+	failures int
+
+	// Why the latest message failed to be sent.
+	lastErr error
+
+	// When each raised alert was last sent, by its condition. Only
+	// accessed by the goroutine checking the thresholds.
+	raised map[string]time.Time
+
+	// Closed to stop checking the thresholds.
+	stop chan struct{}
+
+	// Ensures that the alerter is only stopped once.
+	closeOnce sync.Once
+}
+
+// newAlerter creates the alerter configured by args. It returns nil if no
+// threshold is set.
+func newAlerter(args Args) (*alerter, error) {
+	if args.AlertBacklog <= 0 && args.AlertOldestAgeS <= 0 && args.AlertSendFailures <= 0 {
+		return nil, nil
+	}
+
+	var dests []sender.Destination
+	if len(args.AlertSlackWebhookURL) > 0 {
+		s, err := sender.NewSlackSender(args.AlertSlackWebhookURL, alertTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't create the alerts' Slack sender: %w", err)
+		}
+		dests = append(dests, sender.Destination{Name: "slack", Sender: s})
+	}
+	if len(args.AlertSMTPAddr) > 0 {
+		s, err := sender.NewEmailSender(sender.EmailOptions{
+			Addr: args.AlertSMTPAddr,
+			User: args.AlertSMTPUser,
+			Password: args.AlertSMTPPassword,
+			From: args.AlertEmailFrom,
+			To: splitList(args.AlertEmailTo),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("couldn't create the alerts' email sender: %w", err)
+		}
+		dests = append(dests, sender.Destination{Name: "email", Sender: s})
+	}
+	if len(dests) == 0 {
+		return nil, errors.New("alert thresholds are set, but no alert destination (AlertSlackWebhookURL or AlertSMTPAddr) is")
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown host"
+	}
+
+	al := &alerter{
+		host: host,
+		backlog: args.AlertBacklog,
+		oldestAge: time.Duration(args.AlertOldestAgeS) * time.Second,
+		sendFailures: args.AlertSendFailures,
+		repeat: time.Duration(args.AlertRepeatMinutes) * time.Minute,
+		raised: make(map[string]time.Time),
+		stop: make(chan struct{}),
+	}
+	if len(dests) == 1 {
+		al.s = dests[0].Sender
+	} else {
+		al.s = sender.NewFanout(dests...)
+	}
+
+	return al, nil
+}
+
+// watch wraps s so the messages that fail to be sent through it are
+// counted. If the alerter is nil, s is returned as is.
+func (al *alerter) watch(s sender.Sender) sender.Sender {
+	if al == nil {
+		return s
+	}
+	return sendermw.WithMetrics(al)(s)
+}
+
+func (al *alerter) ObserveSend(res sender.SendResult, err error, took time.Duration) {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	switch {
+	case err == nil:
+		al.failures = 0
+		al.lastErr = nil
+	case errors.Is(err, sender.ErrRejected), errors.Is(err, sender.ErrInvalidInput):
+		// The message itself is the problem, not the destination.
+	default:
+		al.failures++
+		al.lastErr = err
+	}
+}
+
+// start checking the thresholds against store every interval, until the
+// alerter is closed.
+func (al *alerter) start(store local_storage.Store, interval time.Duration) {
+	if al == nil {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-al.stop:
+				return
+			case <-ticker.C:
+				al.check(store)
+			}
+		}
+	} ()
+}
+
+// check every threshold once, raising or resolving its alert.
+func (al *alerter) check(store local_storage.Store) {
+	if al.backlog > 0 {
+		n := store.Count()
+		al.update(alertBacklog, n >= al.backlog,
+				fmt.Sprintf("%d messages are waiting in the local storage (threshold: %d)", n, al.backlog))
+	}
+
+	if al.oldestAge > 0 {
+		var age time.Duration
+		if entry, err := store.Oldest(); err == nil {
+			age = time.Since(entry.StoredAt).Truncate(time.Second)
+		}
+		al.update(alertOldestAge, age >= al.oldestAge,
+				fmt.Sprintf("The oldest message has been waiting for %s (threshold: %s)", age, al.oldestAge))
+	}
+
+	if al.sendFailures > 0 {
+		al.mutex.Lock()
+		failures, lastErr := al.failures, al.lastErr
+		al.mutex.Unlock()
+
+		text := fmt.Sprintf("%d messages in a row failed to be sent (threshold: %d)", failures, al.sendFailures)
+		if lastErr != nil {
+			text += fmt.Sprintf(": %+v", lastErr)
+		}
+		al.update(alertSendFailures, failures >= al.sendFailures, text)
+	}
+}
+
+// update the alert for condition, sending it if it was just raised (or if
+// it has been raised for long enough to be repeated), or sending its
+// resolution once it's no longer raised.
+func (al *alerter) update(condition string, raised bool, text string) {
+	last, wasRaised := al.raised[condition]
+
+	switch {
+	case raised && (!wasRaised || (al.repeat > 0 && time.Since(last) >= al.repeat)):
+		// Unsent alerts are retried on the next check.
+		if al.send("ALERT", text) {
+			al.raised[condition] = time.Now()
+		}
+	case !raised && wasRaised:
+		al.send("RESOLVED", text)
+		delete(al.raised, condition)
+	}
+}
+
+// send an alert, prefixed by its kind (e.g., "ALERT"), reporting whether
+// it was sent.
+func (al *alerter) send(kind, text string) bool {
+	subject := fmt.Sprintf("[%s] sqs-issue-notifier on %s", kind, al.host)
+	_, err := al.s.Send(sender.Message{
+		Body: subject + ": " + text,
+		Attributes: map[string]string{sender.EmailSubjectAttr: subject},
+	})
+	if err != nil {
+		slog.Error("Couldn't send the alert", "kind", kind, "alert", text, "err", err)
+		return false
+	}
+
+	slog.Warn("Sent an alert", "kind", kind, "alert", text)
+	return true
+}
+
+// Close stops checking the thresholds.
+func (al *alerter) Close() error {
+	if al == nil {
+		return nil
+	}
+
+	al.closeOnce.Do(func() {
+		close(al.stop)
+	})
+	return nil
+}
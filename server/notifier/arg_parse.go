@@ -0,0 +1,980 @@
+package notifier
+
+import (
+	"encoding/json"
+	"flag"
+	"github.com/BurntSushi/toml"
+	"github.com/SirGFM/sqs-issue-notifier/server/flagoverride"
+	"github.com/SirGFM/sqs-issue-notifier/server/local_storage"
+	"github.com/SirGFM/sqs-issue-notifier/server/vault"
+	"gopkg.in/yaml.v3"
+	"log"
+	"log/slog"
+	"reflect"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type Args struct {
+	// IP on which the server will accept connections. Defaults to 0.0.0.0
+	IP string
+	// Port on which the server will accept connections. Defaults to 8888
+	Port int
+	// Port where the gRPC ingest API (notifierpb.Notifier) is served, on the same
+	// IP as the web server. 0 disables it
+	GRPCPort int
+	// PEM file with the TLS certificate chain. If set (along with
+	// KeyFile), the server only accepts HTTPS connections
+	CertFile string
+	// PEM file with the TLS certificate's private key
+	KeyFile string
+	// Interval, in seconds, between checks for a rotated certificate. Set
+	// to 0 to never reload the certificate. Defaults to 60
+	CertReloadS int
+	// Comma separated list of hostnames for which a certificate is
+	// obtained automatically, from Let's Encrypt. Can't be used along with
+	// CertFile. Leave empty to disable it
+	ACMEHosts string
+	// Contact email sent to the CA when obtaining certificates
+	ACMEEmail string
+	// Directory where the obtained certificates are cached. Defaults to
+	// "/tmp/autocert"
+	ACMECacheDir string
+	// Port that answers the CA's HTTP challenges (and redirects everything
+	// else to HTTPS). Set to 0 to only use TLS challenges, which requires
+	// Port to be 443. Defaults to 80
+	ACMEHTTPPort int
+	// Address ("host:port") of a separate admin server exposing the
+	// net/http/pprof handlers. Should only be reachable internally (e.g.,
+	// "127.0.0.1:6060"). Leave empty to disable it
+	PprofAddr string
+	// Address (e.g., "127.0.0.1:9090") of a separate listener for the
+	// administrative endpoints (e.g., flushing and managing dead letters) and
+	// pprof. If set, those endpoints are no longer served on the main address.
+	AdminAddr string
+	// Bearer token required by the admin listener. If empty, administrators are
+	// authenticated as on the main address.
+	AdminToken string `flag:",secret"`
+	// Whether the main address serves the backlog's count (GET message).
+	// Defaults to true
+	EnableCount bool
+	// Whether the main address serves stored messages (GET message/<id>)
+	// and lists the dead letters (GET deadletter). Defaults to true
+	EnableListing bool
+	// Whether the main address serves the administrative endpoints. Has no
+	// effect if AdminAddr is set, as they're only served by the admin
+	// listener. Defaults to true
+	EnableAdmin bool
+	// Whether the main address accepts webhooks (on /webhook) and
+	// PagerDuty's events (on /v2/enqueue). Defaults to true
+	EnableWebhooks bool
+	// Whether the main address streams the pipeline's events (on /events)
+	// and serves the dashboard built on them. Defaults to true
+	EnableEvents bool
+	// Whether the main address exports the local storage's and the
+	// forwarder's metrics (on /metrics), in Prometheus' text format.
+	// Defaults to true
+	EnableMetrics bool
+	// Where the metrics are exported: either "prometheus" (scraped from
+	// /metrics), "statsd" or "dogstatsd" (pushed to StatsDAddr, in which
+	// case /metrics isn't served). Defaults to "prometheus"
+	MetricsSink string
+	// Address ("host:port") of the StatsD server receiving the metrics,
+	// over UDP. Defaults to "127.0.0.1:8125"
+	StatsDAddr string
+	// Interval, in seconds, between the metrics pushed to StatsD.
+	// Defaults to 10
+	StatsDIntervalS int
+	// Format of the log: either "text" (key=value pairs) or "json" (one
+	// object per line). Defaults to "text"
+	LogFormat string
+	// Where the log is written: either "stderr" or "stdout" (e.g., for
+	// container log pipelines). Defaults to "stderr"
+	LogOutput string
+	// Minimum level of the logged entries: either "debug", "info", "warn" or
+	// "error". Defaults to "info"
+	LogLevel string
+	// Comma separated list of <component>=<level> (e.g., "store=debug,web=warn"),
+	// overriding LogLevel for the entries of each component: "web", "store",
+	// "sender" or "forwarder"
+	LogLevels string
+	// File where the server's log is written, instead of LogOutput, rotating it
+	// as set by LogMaxSizeMB and LogRotateHours. Commands other than "serve"
+	// still log to LogOutput
+	LogFile string
+	// Size, in megabytes, after which LogFile is rotated. 0 disables it.
+	// Defaults to 100
+	LogMaxSizeMB int
+	// LogFile is rotated once every period of this many hours (e.g., on every
+	// UTC midnight, for 24). 0 disables it. Defaults to 24
+	LogRotateHours int
+	// How many rotated log files are kept. 0 keeps every one. Defaults to 7
+	LogMaxBackups int
+	// How many days rotated log files are kept. 0 keeps them regardless of
+	// their age. Defaults to 30
+	LogMaxAgeDays int
+	// File where every event about each message (e.g., stored, sent with the
+	// SQS's MessageId, dead-lettered or purged) is appended, as JSON lines,
+	// rotating it as set by the AuditLogMax* and AuditLogRotateHours options.
+	// Leave empty to disable it
+	AuditLogFile string
+	// Size, in megabytes, after which AuditLogFile is rotated. 0 disables it.
+	// Defaults to 100
+	AuditLogMaxSizeMB int
+	// AuditLogFile is rotated once every period of this many hours. 0
+	// disables it. Defaults to 24
+	AuditLogRotateHours int
+	// How many rotated audit logs are kept. 0 keeps every one. Defaults to 0
+	AuditLogMaxBackups int
+	// How many days rotated audit logs are kept. 0 keeps them regardless of
+	// their age. Defaults to 0
+	AuditLogMaxAgeDays int
+	// URL of the OpenTelemetry collector (e.g., "http://collector:4318") that
+	// receives the traces, through OTLP over HTTP. Tracing is disabled if
+	// empty.
+	OTLPEndpoint string
+	// Ratio of the requests that are traced, between 0 and 1. Requests whose
+	// caller is already traced follow the caller's decision.
+	TraceSampleRatio float64
+	// Comma separated list of origins (e.g., "https://example.com") allowed
+	// to make cross-origin requests from a browser. Use "*" to allow any
+	// origin. Leave empty to disable CORS
+	CORSOrigins string
+	// Comma separated list of methods allowed on cross-origin requests.
+	// Defaults to "GET,POST,DELETE"
+	CORSMethods string
+	// Comma separated list of headers allowed on cross-origin requests.
+	// Defaults to "Authorization,Content-Type,X-Request-Id,X-Delay-Seconds,X-Priority"
+	CORSHeaders string
+	// For how long, in seconds, browsers may cache the response to a
+	// preflight request. Defaults to 600
+	CORSMaxAgeS int
+	// Maximum size, in bytes, of request bodies, after being decompressed (if
+	// sent with "Content-Encoding: gzip"). Defaults to 1048576 (1 MiB)
+	MaxBodyBytes int
+	// Maximum time, in seconds, to read a request's headers. Disabled if 0.
+	ReadHeaderTimeoutS int
+	// Maximum time, in seconds, to read a whole request, including its body.
+	// Disabled if 0.
+	ReadTimeoutS int
+	// Maximum time, in seconds, to write a response, from the end of the
+	// request's headers. Streamed responses (e.g., on /events) extend it as
+	// they're written. Disabled if 0.
+	WriteTimeoutS int
+	// Maximum time, in seconds, that idle keep-alive connections are kept
+	// open. If 0, ReadTimeoutS is used instead.
+	IdleTimeoutS int
+	// Maximum size, in bytes, of a request's headers.
+	MaxHeaderBytes int
+	// Directory with a JSON Schema for each channel, named "<channel>.json".
+	// Messages sent to a channel with a schema are rejected unless they match
+	// it. Leave empty to accept any message
+	ChannelSchemaDir string
+	// JSON file mapping each channel to its quota (e.g., {"alerts":
+	// {"MaxPending": 1000, "Policy": "drop-oldest"}}). Fields omitted from a
+	// channel's quota are copied from ChannelMaxPending, ChannelMaxPerMinute and
+	// ChannelQuotaPolicy
+	ChannelQuotaFile string
+	// How many messages of each channel may be pending in the local storage at
+	// once, so a single channel can't fill it up. Set to 0 to disable it
+	ChannelMaxPending int
+	// How many messages each channel may receive per minute. Messages received
+	// faster are rejected with 429. Set to 0 to disable it
+	ChannelMaxPerMinute int
+	// What happens to new messages once a channel has ChannelMaxPending pending
+	// messages: either "reject" them (with 429) or "drop-oldest", removing the
+	// channel's oldest pending message. Defaults to "reject"
+	ChannelQuotaPolicy string
+	// For how long, in seconds, the response to a POST with an
+	// "Idempotency-Key" header is remembered, so retries with the same key get
+	// the original response without storing the message again. Set to 0 to
+	// ignore the header. Defaults to 86400 (1 day)
+	IdempotencyWindowS int
+	// Status replied to a POST on /message whose message was already stored
+	// (and so isn't stored again): either 200 (OK), with "Duplicate" set in
+	// the reply, or 409 (Conflict). Defaults to 200
+	DuplicateStatus int
+	// Interval, in seconds, between the statistics streamed to clients of
+	// /events that use Server-Sent Events.
+	EventsIntervalS int
+	// Secret used to verify the signature of GitHub's webhooks, received on
+	// /webhook/github. The webhook is disabled if empty.
+	GitHubWebhookSecret string `flag:",secret"`
+	// Secret token expected on GitLab's webhooks, received on /webhook/gitlab.
+	// The webhook is disabled if empty.
+	GitLabWebhookSecret string `flag:",secret"`
+	// Client secret of the Sentry integration that sends issue alerts to
+	// /webhook/sentry. The webhook is disabled if empty.
+	SentryWebhookSecret string `flag:",secret"`
+	// Signing secret of the Slack app that sends slash commands and events to
+	// /webhook/slack/command and /webhook/slack/events. Disabled if empty.
+	SlackSigningSecret string `flag:",secret"`
+	// Timeout for the server to check if there are any messages, in milliseconds. Defaults to 1 min (60000 ms)
+	TimeoutMS int
+	// Directory where the local storage saves messages temporarily. Will
+	// be created if it does not exist. Defaults to "/tmp/local-store"!
+	LocalStore string
+	// Keep messages rejected by the SQS in the local storage's dead-letter
+	// area, instead of discarding them. Defaults to true
+	DeadLetter bool
+	// URI where a custom AWS simulator (e.g., localstack) may be accessed.
+	// Should be left empty to use the AWS.
+	Endpoint string
+	// URI where the SQS may be accessed.
+	Queue string
+	// URI of the queue whose messages are delivered by the consume command
+	// (e.g., filed as issues). Defaults to Queue
+	ConsumeQueue string
+	// AWS region of the queue. If empty, it's inferred from the queue's
+	// URI, falling back to the environment (AWS_DEFAULT_REGION)
+	Region string
+	// Named AWS profile used to load credentials from the shared
+	// configuration. Defaults to the environment's profile
+	Profile string
+	// Timeout for each request made to AWS, in milliseconds. Set to 0 to
+	// disable it. Defaults to 10000 ms
+	AWSTimeoutMS int
+	// Create the queue on start up if it doesn't exist. Mostly useful
+	// for localstack. Defaults to false
+	CreateQueue bool
+	// S3 bucket where messages too large for the queue are uploaded, as
+	// done by the Amazon SQS Extended Client. Leave empty to disable it
+	LargePayloadBucket string
+	// Size, in bytes, above which messages are uploaded to S3. Defaults
+	// to the SQS limit (262144 bytes)
+	LargePayloadThreshold int
+	// Split messages too large for the queue into chunks, which must be
+	// reassembled by the consumer. Ignored if LargePayloadBucket is set.
+	// Defaults to false
+	ChunkMessages bool
+	// Address ("host:port") of a gRPC collector service that receives the
+	// messages instead of the SQS. Leave empty to use the SQS
+	CollectorAddr string
+	// Connect to the collector over TLS. Defaults to false
+	CollectorTLS bool
+	// PEM file with the CAs used to verify the collector. Defaults to the
+	// system's CAs
+	CollectorCAFile string
+	// Token used to file issues in the "github" destinations that don't set
+	// their own
+	GitHubToken string `flag:",secret"`
+	// Log messages instead of sending them to the SQS. Defaults to false
+	DryRun bool
+	// File where messages are appended on dry-run mode. If empty,
+	// messages are simply logged
+	DryRunFile string
+	// Maximum number of attempts for sending a message before giving up
+	// (until the next time the local storage is checked). Defaults to 3
+	RetryMaxAttempts int
+	// Delay before retrying to send a message, in milliseconds. Doubled
+	// after every failed attempt. Defaults to 200 ms
+	RetryBaseDelayMS int
+	// Maximum delay between attempts, in milliseconds. Defaults to 5000 ms
+	RetryMaxDelayMS int
+	// Fraction of each delay (between 0.0 and 1.0) that's randomized.
+	// Defaults to 0.2
+	RetryJitter float64
+	// Number of consecutive failures after which sending is suspended.
+	// Set to 0 to disable the circuit breaker. Defaults to 5
+	BreakerThreshold int
+	// For how long sending stays suspended after the circuit breaker
+	// opens, in milliseconds. Defaults to 30000 ms
+	BreakerCooldownMS int
+	// How long, in seconds, the forwarder may take to forward a single message
+	// (including its retries) before it's considered stuck, in which case
+	// systemd's watchdog (if enabled) stops being notified. 0 disables the check.
+	// Defaults to 300
+	ForwarderStuckS int
+	// Interval, in seconds, between the watchdog's checks of whether the
+	// forwarder stalled (i.e., the backlog isn't empty, but no message was
+	// retrieved or sent), in which case it's force-woken. Set to 0 to
+	// disable the watchdog. Defaults to 30
+	ForwarderWatchdogS int
+	// Number of consecutive watchdog intervals the forwarder must be
+	// inactive, with a non-empty backlog, before it's force-woken. Defaults
+	// to 3
+	ForwarderWatchdogIntervals int
+	// Maximum number of messages sent per second, on average. Set to 0
+	// to disable rate limiting. Defaults to 0
+	SendRate float64
+	// Maximum number of messages that may be sent at once, after idling
+	// for a while. Defaults to 10
+	SendBurst int
+	// Slow down whenever the SQS throttles messages, speeding back up as
+	// messages are accepted. Defaults to true
+	AdaptiveThrottle bool
+	// Slowest rate, in messages per second, used when throttled. Defaults
+	// to 1
+	AdaptiveMinRate float64
+	// Fastest rate, in messages per second, used when not throttled.
+	// Defaults to 100
+	AdaptiveMaxRate float64
+	// Requests per second, on average, accepted from each client (either
+	// an authenticated subject or an IP address). Set to 0 to disable it.
+	// Defaults to 0
+	ClientRate float64
+	// Requests accepted at once from each client. Defaults to 10
+	ClientBurst int
+	// Comma-separated list of networks, in CIDR notation (e.g., "10.0.0.0/8"),
+	// whose clients may access the server. Requests from other clients are
+	// rejected with 403. Leave empty to allow every client (except for those in
+	// IPDenyList)
+	IPAllowList string
+	// Comma-separated list of networks, in CIDR notation, whose clients may not
+	// access the server, even if they are in IPAllowList
+	IPDenyList string
+	// Comma-separated list of reverse proxies, in CIDR notation, trusted to set
+	// the X-Forwarded-For (or X-Real-IP) header. The client's address is only
+	// taken from the header on requests coming from these proxies. This
+	// address is used for logging, rate limiting and the IP filter
+	TrustedProxies string
+	// Maximum number of simultaneous connections accepted by the server. Once
+	// it's reached, new connections wait until another one is closed. 0 disables
+	// the limit
+	MaxConnections int
+	// Maximum number of POST requests handled at once. Requests over this limit
+	// are rejected with a 503. 0 disables the limit
+	MaxInFlightPosts int
+	// URL of the JWKS endpoint publishing the keys that sign the JWTs
+	// accepted by the server. Leave empty to disable authentication
+	AuthJWKSURL string
+	// Required issuer of the JWTs. Leave empty to accept any issuer
+	AuthJWTIssuer string
+	// Required audience of the JWTs. Leave empty to accept any audience
+	AuthJWTAudience string
+	// Claim listing the channels to which the JWT's subject may post.
+	// Defaults to "channels"
+	AuthJWTChannelsClaim string
+	// Claim that, when true, grants the JWT's subject administrative
+	// access. Defaults to "admin"
+	AuthJWTAdminClaim string
+	// For how long, in seconds, the keys from the JWKS endpoint are
+	// cached. Defaults to 3600
+	AuthJWKSCacheTTLS int
+	// Comma-separated list of users accepted through HTTP Basic authentication,
+	// as "<username>:<bcrypt hash>" (e.g., as generated by "htpasswd -nbB").
+	// These users may post to any channel. Can't be used along with
+	// AuthJWKSURL. Leave empty to disable it
+	AuthBasicUsers string `flag:",secret"`
+	// Comma-separated list of the users in AuthBasicUsers that may use
+	// administrative endpoints
+	AuthBasicAdmins string
+	// Interval, in minutes, between heartbeats verifying that messages
+	// may be forwarded. Defaults to 0 (disabled)
+	HeartbeatMinutes int
+	// How heartbeats are done: "check" verifies that the queue is
+	// reachable, and "message" sends a synthetic message. Defaults to
+	// "check"
+	HeartbeatMode string
+	// Number of messages waiting in the local storage from which an alert is
+	// sent. 0 disables it
+	AlertBacklog int
+	// Age, in seconds, of the oldest message waiting in the local storage
+	// from which an alert is sent. 0 disables it
+	AlertOldestAgeS int
+	// Number of consecutive messages that failed to be sent from which an
+	// alert is sent. 0 disables it
+	AlertSendFailures int
+	// Slack incoming webhook to which alerts are posted
+	AlertSlackWebhookURL string `flag:",secret"`
+	// Address ("host:port") of the SMTP server through which alerts are
+	// emailed. Leave empty to not email them
+	AlertSMTPAddr string
+	// User used to authenticate to the SMTP server. Leave empty to not
+	// authenticate
+	AlertSMTPUser string
+	// Password used to authenticate to the SMTP server
+	AlertSMTPPassword string `flag:",secret"`
+	// Address from which alerts are emailed
+	AlertEmailFrom string
+	// Comma-separated list of the addresses to which alerts are emailed
+	AlertEmailTo string
+	// Interval, in seconds, between checks of the alert thresholds. Defaults
+	// to 60
+	AlertIntervalS int
+	// For how long, in minutes, an alert stays raised before being sent
+	// again. 0 only sends it once. Defaults to 60
+	AlertRepeatMinutes int
+	// File with a Go template used to reshape each message before it's
+	// sent. Leave empty to send messages as received
+	MessageTemplateFile string
+	// Secret key used to sign messages (with HMAC-SHA256), so consumers
+	// may verify them. Leave empty to send unsigned messages
+	SigningKey string `flag:",secret"`
+	// ID, ARN or alias of the KMS key used to encrypt messages. Leave
+	// empty to send messages unencrypted
+	EncryptionKMSKeyID string
+	// File with a web identity token (e.g., EKS IRSA) exchanged for the
+	// base credentials. Defaults to $AWS_WEB_IDENTITY_TOKEN_FILE
+	WebIdentityTokenFile string
+	// ARN of the role associated with the web identity token. Defaults to
+	// $AWS_ROLE_ARN
+	WebIdentityRoleARN string
+	// ARN of an IAM role assumed for sending messages. Leave empty to use
+	// the credentials from the environment directly
+	AssumeRoleARN string
+	// External ID required by the assumed role, if any
+	AssumeRoleExternalID string `flag:",secret"`
+	// Session name used when assuming the role. Defaults to "sqs-issue-notifier"
+	AssumeRoleSessionName string
+	// Number of workers forwarding messages from the local storage at once.
+	// More than one may deliver messages out of order. Defaults to 1
+	ForwarderWorkers int
+	// Maximum number of messages being sent at once, across every worker, so
+	// the workers don't exceed the destination's quota. 0 only limits it by
+	// ForwarderWorkers
+	MaxInFlight int
+	// For how long the forwarder backs off after a message fails to be sent,
+	// in milliseconds, before retrieving the next one. Doubled after every
+	// consecutive failure, and reset once a message is sent. Set to 0 to
+	// disable backing off. Defaults to 1000 ms
+	ForwarderBackoffBaseMS int
+	// Maximum time the forwarder backs off, in milliseconds. Defaults to
+	// 60000 ms
+	ForwarderBackoffMaxMS int
+	// Fraction of each backoff (between 0.0 and 1.0) that's randomized.
+	// Defaults to 0.2
+	ForwarderBackoffJitter float64
+	// Start with forwarding paused, so messages are accepted but kept in the
+	// local storage (e.g., during the destination's maintenance) until
+	// resumed through admin/resume
+	Paused bool
+	// For how long, in seconds, the backlog is drained when the server is
+	// stopped (by SIGTERM or SIGINT), rejecting new messages meanwhile. 0
+	// exits immediately, keeping the backlog until the next start
+	DrainOnExitS int
+	// Address of the HashiCorp Vault server (e.g., "https://vault:8200"), from
+	// where "vault://" references and the AWS credentials may be read. Leave
+	// empty to not use Vault
+	VaultAddr string
+	// How the server authenticates to Vault: "token", "approle" or
+	// "kubernetes". Defaults to "token"
+	VaultAuthMethod string
+	// Token used to access Vault, for the "token" method
+	VaultToken string `flag:",secret"`
+	// The AppRole's role ID, for the "approle" method
+	VaultRoleID string
+	// The AppRole's secret ID, for the "approle" method
+	VaultSecretID string `flag:",secret"`
+	// Vault role bound to the service account, for the "kubernetes" method
+	VaultK8sRole string
+	// File with the service account's token, for the "kubernetes" method.
+	// Defaults to "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	VaultK8sTokenFile string
+	// Path where the authentication method is mounted in Vault. Defaults to
+	// the method's name
+	VaultAuthMount string
+	// Vault Enterprise namespace. Leave empty to use the root namespace
+	VaultNamespace string
+	// PEM file with the CAs used to verify Vault. Defaults to the system's CAs
+	VaultCAFile string
+	// Path, in Vault's AWS secrets engine, of the AWS credentials used as the
+	// base ones (e.g., "aws/creds/notifier"). Leave empty to use the
+	// credentials from the environment
+	VaultAWSCredsPath string
+	// Destinations that receive every message, instead of the single one set by
+	// Endpoint and Queue (or by CollectorAddr). Each destination is retried,
+	// rate limited and throttled on its own. May only be set in the
+	// configuration file
+	Destinations []Destination
+	// Routes selecting the destinations of each channel's messages, matched in
+	// order (the first route that matches the channel is used). Messages whose
+	// channel matches no route are rejected. If empty, every message is sent to
+	// every destination. May only be set in the configuration file
+	Routes []Route
+}
+
+// Route sends the messages of the channels that match it to some of the
+// destinations, optionally changing how they're sent.
+type Route struct {
+	// Channels matched by the route, where "*" matches any sequence of
+	// characters (e.g., "alerts-*", or "*" for every channel)
+	Channel string
+	// Names of the destinations, from Destinations, that receive the messages
+	Destinations []string
+	// Priority of the messages in the local storage ("low", "normal" or
+	// "high"), unless set by the client
+	Priority local_storage.Priority `json:",omitempty"`
+	// For how long, in seconds, the messages are hidden from consumers, unless
+	// set by the client
+	DelaySeconds int64
+	// Attributes sent along each message (e.g., {"Team": "ops"}). The
+	// message's own attributes take precedence
+	Attributes map[string]string
+}
+
+// Destination configures one of the destinations that receive the messages.
+// AWS options left empty are copied from the top-level options (e.g.,
+// Region).
+type Destination struct {
+	// Name that identifies the destination (e.g., in the logs). Must be unique
+	Name string
+	// Type of the destination: either "sqs", "grpc" (a collector service),
+	// "github" (issues filed in a repository) or "dry-run". Defaults to "sqs"
+	Type string
+	// For "sqs", URI where a custom AWS simulator (e.g., localstack) may be
+	// accessed. For "grpc", the collector's address ("host:port"). For
+	// "github", the API's URL, if not GitHub's (e.g., GitHub Enterprise
+	// Server's)
+	Endpoint string
+	// URI of the queue, for "sqs"
+	Queue string
+	// AWS region of the queue. If empty, it's inferred from the queue's URI
+	Region string
+	// Named AWS profile used to load the credentials
+	Profile string
+	// ARN of an IAM role assumed for sending messages to this destination
+	AssumeRoleARN string
+	// External ID required by the assumed role, if any
+	AssumeRoleExternalID string `flag:",secret"`
+	// Create the queue on start up if it doesn't exist
+	CreateQueue bool
+	// Connect to the collector over TLS, for "grpc"
+	TLS bool
+	// PEM file with the CAs used to verify the collector, for "grpc"
+	CAFile string
+	// File where messages are appended, for "dry-run". If empty, messages are
+	// simply logged
+	File string
+	// Repository ("owner/name") where issues are filed, for "github"
+	Repo string
+	// Labels applied to the issues filed, for "github"
+	Labels []string
+	// Token used to file issues, for "github". Defaults to GitHubToken
+	Token string `flag:",secret"`
+	// Timeout for each request made to the destination, in milliseconds.
+	// Defaults to AWSTimeoutMS for "sqs", and to 10000 ms otherwise
+	TimeoutMS int
+}
+
+// confFile is the configuration file set on the CLI, if any.
+var confFile string
+
+// envArgs are the options as set by their defaults and by the environment,
+// before the configuration file and the CLI are applied.
+var envArgs Args
+
+// commandFlags lists the flags registered by the command being run (e.g.,
+// send's -URL), which configure the command itself, rather than an option
+// in Args.
+var commandFlags []string
+
+// parseArgs either from the command line or from the supplied JSON file.
+//
+// If a JSON file is supplied, it's used as the default parameters, which may be overriden by CLI-supplied arguments.
+func parseArgs() Args {
+	var args Args
+	registerArgs(flag.CommandLine, &args)
+
+	flag.StringVar(&confFile, "confFile", "", "JSON, YAML or TOML file with the configuration options (according to its extension). May be overriden by other CLI arguments")
+
+	// Environment variables override the defaults, but may be overriden
+	// both by the configuration file and by the CLI.
+	applyEnv()
+	envArgs = args
+	flag.Parse()
+
+	if len(confFile) != 0 {
+		var err error
+
+		args, err = loadConfFile()
+		if err != nil {
+			log.Fatalf("Couldn't decode the configuration file '%+v': %+v", confFile, err)
+		}
+	}
+
+	return args
+}
+
+// registerArgs registers every option in fs, setting its default in args.
+func registerArgs(fs *flag.FlagSet, args *Args) {
+	const defaultIP = "0.0.0.0"
+	const defaultPort = 8888
+	const defaultTimeoutMS = 60000
+	const defaultCertReloadS = 60
+	const defaultDeadLetter = true
+	const defaultACMECacheDir = "/tmp/autocert"
+	const defaultACMEHTTPPort = 80
+	const defaultLocalStore = "/tmp/local-store"
+	const defaultWriteSize = 1024
+	const defaultIgnoreOrigin = true
+	const defaultDebug = true
+	const defaultAWSTimeoutMS = 10000
+	const defaultLargePayloadThreshold = 262144
+	const defaultRetryMaxAttempts = 3
+	const defaultRetryBaseDelayMS = 200
+	const defaultRetryMaxDelayMS = 5000
+	const defaultRetryJitter = 0.2
+	const defaultBreakerThreshold = 5
+	const defaultBreakerCooldownMS = 30000
+	const defaultSendRate = 0.0
+	const defaultSendBurst = 10
+	const defaultClientBurst = 10
+	const defaultHeartbeatMode = "check"
+	const defaultAuthJWTChannelsClaim = "channels"
+	const defaultAuthJWTAdminClaim = "admin"
+	const defaultAuthJWKSCacheTTLS = 3600
+	const defaultAdaptiveThrottle = true
+	const defaultAdaptiveMinRate = 1.0
+	const defaultAdaptiveMaxRate = 100.0
+	const defaultAssumeRoleSessionName = "sqs-issue-notifier"
+	const defaultVaultAuthMethod = vault.MethodToken
+	const defaultLogMaxSizeMB = 100
+	const defaultLogRotateHours = 24
+	const defaultLogMaxBackups = 7
+	const defaultLogMaxAgeDays = 30
+	const defaultAuditLogMaxSizeMB = 100
+	const defaultAuditLogRotateHours = 24
+	const defaultLogLevel = "info"
+	const defaultLogOutput = "stderr"
+	const defaultForwarderStuckS = 300
+	const defaultForwarderWatchdogS = 30
+	const defaultForwarderWatchdogIntervals = 3
+	const defaultForwarderWorkers = 1
+	const defaultForwarderBackoffBaseMS = 1000
+	const defaultForwarderBackoffMaxMS = 60000
+	const defaultForwarderBackoffJitter = 0.2
+	const defaultChannelQuotaPolicy = "reject"
+	const defaultTraceSampleRatio = 1.0
+	const defaultMaxHeaderBytes = 1048576
+	const defaultIdleTimeoutS = 120
+	const defaultWriteTimeoutS = 60
+	const defaultReadTimeoutS = 60
+	const defaultReadHeaderTimeoutS = 10
+	const defaultEventsIntervalS = 5
+	const defaultIdempotencyWindowS = 86400
+	const defaultDuplicateStatus = 200
+	const defaultMaxBodyBytes = 1048576
+	const defaultCORSMaxAgeS = 600
+	const defaultCORSHeaders = "Authorization,Content-Type,X-Request-Id,X-Delay-Seconds,X-Priority"
+	const defaultCORSMethods = "GET,POST,DELETE"
+	const defaultLogFormat = "text"
+	const defaultMetricsSink = metricsPrometheus
+	const defaultStatsDAddr = "127.0.0.1:8125"
+	const defaultStatsDIntervalS = 10
+	const defaultAlertIntervalS = 60
+	const defaultAlertRepeatMinutes = 60
+
+	fs.StringVar(&args.IP, "IP", defaultIP, "IP on which the server will accept connections")
+	fs.IntVar(&args.Port, "Port", defaultPort, "Port on which the server will accept connections")
+	fs.IntVar(&args.GRPCPort, "GRPCPort", 0, "Port of the gRPC ingest API, on the same IP as the web server (0 disables it)")
+	fs.StringVar(&args.CertFile, "CertFile", "", "PEM file with the TLS certificate chain (enables HTTPS)")
+	fs.StringVar(&args.KeyFile, "KeyFile", "", "PEM file with the TLS certificate's private key")
+	fs.IntVar(&args.CertReloadS, "CertReloadS", defaultCertReloadS, "Interval, in seconds, between checks for a rotated certificate (0 disables reloading)")
+	fs.StringVar(&args.ACMEHosts, "ACMEHosts", "", "Comma separated list of hostnames for which a certificate is obtained automatically")
+	fs.StringVar(&args.ACMEEmail, "ACMEEmail", "", "Contact email sent to the CA when obtaining certificates")
+	fs.StringVar(&args.ACMECacheDir, "ACMECacheDir", defaultACMECacheDir, "Directory where the obtained certificates are cached")
+	fs.IntVar(&args.ACMEHTTPPort, "ACMEHTTPPort", defaultACMEHTTPPort, "Port that answers the CA's HTTP challenges (0 disables it)")
+	fs.StringVar(&args.PprofAddr, "PprofAddr", "", "Address (\"host:port\") of an admin server exposing the pprof handlers")
+	fs.StringVar(&args.AdminAddr, "AdminAddr", "", "Address (e.g., 127.0.0.1:9090) of a separate listener for the administrative endpoints (disabled if empty)")
+	fs.StringVar(&args.AdminToken, "AdminToken", "", "Bearer token required by the admin listener (if empty, administrators are authenticated as on the main address)")
+	fs.BoolVar(&args.EnableCount, "EnableCount", true, "Serve the backlog's count (GET message) on the main address")
+	fs.BoolVar(&args.EnableListing, "EnableListing", true, "Serve stored messages (GET message/<id>) and the dead letters (GET deadletter) on the main address")
+	fs.BoolVar(&args.EnableAdmin, "EnableAdmin", true, "Serve the administrative endpoints on the main address (no effect if AdminAddr is set)")
+	fs.BoolVar(&args.EnableWebhooks, "EnableWebhooks", true, "Accept webhooks (on /webhook) and PagerDuty's events (on /v2/enqueue) on the main address")
+	fs.BoolVar(&args.EnableEvents, "EnableEvents", true, "Stream the pipeline's events (on /events) and serve the dashboard on the main address")
+	fs.BoolVar(&args.EnableMetrics, "EnableMetrics", true, "Export the local storage's and the forwarder's metrics (on /metrics) on the main address")
+	fs.StringVar(&args.MetricsSink, "MetricsSink", defaultMetricsSink, "Where the metrics are exported: either \"prometheus\" (on /metrics), \"statsd\" or \"dogstatsd\" (pushed to StatsDAddr)")
+	fs.StringVar(&args.StatsDAddr, "StatsDAddr", defaultStatsDAddr, "Address (\"host:port\") of the StatsD server receiving the metrics, over UDP")
+	fs.IntVar(&args.StatsDIntervalS, "StatsDIntervalS", defaultStatsDIntervalS, "Interval, in seconds, between the metrics pushed to StatsD")
+	fs.StringVar(&args.LogFormat, "LogFormat", defaultLogFormat, "Format of the log: either \"text\" or \"json\"")
+	fs.StringVar(&args.LogOutput, "LogOutput", defaultLogOutput, "Where the log is written: either \"stderr\" or \"stdout\"")
+	fs.StringVar(&args.LogLevel, "LogLevel", defaultLogLevel, "Minimum level of the logged entries: debug, info, warn or error")
+	fs.StringVar(&args.LogLevels, "LogLevels", "", "Comma separated list of <component>=<level>, overriding LogLevel for the entries of each component (web, store, sender or forwarder)")
+	fs.StringVar(&args.LogFile, "LogFile", "", "File where the server's log is written (instead of LogOutput), rotated as set by the LogMax* and LogRotateHours options")
+	fs.IntVar(&args.LogMaxSizeMB, "LogMaxSizeMB", defaultLogMaxSizeMB, "Size, in megabytes, after which LogFile is rotated. 0 disables it")
+	fs.IntVar(&args.LogRotateHours, "LogRotateHours", defaultLogRotateHours, "LogFile is rotated once every period of this many hours (e.g., on every UTC midnight, for 24 hours). 0 disables it")
+	fs.IntVar(&args.LogMaxBackups, "LogMaxBackups", defaultLogMaxBackups, "How many rotated log files are kept. 0 keeps every one")
+	fs.IntVar(&args.LogMaxAgeDays, "LogMaxAgeDays", defaultLogMaxAgeDays, "How many days rotated log files are kept. 0 keeps them regardless of their age")
+	fs.StringVar(&args.AuditLogFile, "AuditLogFile", "", "File where every event about each message is appended, as JSON lines (empty disables it)")
+	fs.IntVar(&args.AuditLogMaxSizeMB, "AuditLogMaxSizeMB", defaultAuditLogMaxSizeMB, "Size, in megabytes, after which AuditLogFile is rotated. 0 disables it")
+	fs.IntVar(&args.AuditLogRotateHours, "AuditLogRotateHours", defaultAuditLogRotateHours, "AuditLogFile is rotated once every period of this many hours. 0 disables it")
+	fs.IntVar(&args.AuditLogMaxBackups, "AuditLogMaxBackups", 0, "How many rotated audit logs are kept. 0 keeps every one")
+	fs.IntVar(&args.AuditLogMaxAgeDays, "AuditLogMaxAgeDays", 0, "How many days rotated audit logs are kept. 0 keeps them regardless of their age")
+	fs.StringVar(&args.OTLPEndpoint, "OTLPEndpoint", "", "URL of the OTLP/HTTP collector receiving traces, e.g. http://collector:4318 (tracing is disabled if empty)")
+	fs.Float64Var(&args.TraceSampleRatio, "TraceSampleRatio", defaultTraceSampleRatio, "Ratio of the requests that are traced, between 0 and 1")
+	fs.StringVar(&args.CORSOrigins, "CORSOrigins", "", "Comma separated list of origins allowed to make cross-origin requests (\"*\" allows any origin)")
+	fs.StringVar(&args.CORSMethods, "CORSMethods", defaultCORSMethods, "Comma separated list of methods allowed on cross-origin requests")
+	fs.StringVar(&args.CORSHeaders, "CORSHeaders", defaultCORSHeaders, "Comma separated list of headers allowed on cross-origin requests")
+	fs.IntVar(&args.CORSMaxAgeS, "CORSMaxAgeS", defaultCORSMaxAgeS, "For how long, in seconds, browsers may cache the response to a preflight request")
+	fs.IntVar(&args.MaxBodyBytes, "MaxBodyBytes", defaultMaxBodyBytes, "Maximum size, in bytes, of request bodies (after decompression)")
+	fs.IntVar(&args.ReadHeaderTimeoutS, "ReadHeaderTimeoutS", defaultReadHeaderTimeoutS, "Maximum time, in seconds, to read a request's headers (0 disables it)")
+	fs.IntVar(&args.ReadTimeoutS, "ReadTimeoutS", defaultReadTimeoutS, "Maximum time, in seconds, to read a whole request (0 disables it)")
+	fs.IntVar(&args.WriteTimeoutS, "WriteTimeoutS", defaultWriteTimeoutS, "Maximum time, in seconds, to write a response (0 disables it)")
+	fs.IntVar(&args.IdleTimeoutS, "IdleTimeoutS", defaultIdleTimeoutS, "Maximum time, in seconds, that idle keep-alive connections are kept open (0 uses ReadTimeoutS)")
+	fs.IntVar(&args.MaxHeaderBytes, "MaxHeaderBytes", defaultMaxHeaderBytes, "Maximum size, in bytes, of a request's headers")
+	fs.StringVar(&args.ChannelSchemaDir, "ChannelSchemaDir", "", "Directory with a JSON Schema for each channel, named \"<channel>.json\"")
+	fs.StringVar(&args.ChannelQuotaFile, "ChannelQuotaFile", "", "JSON file with the quota of each channel, overriding ChannelMaxPending, ChannelMaxPerMinute and ChannelQuotaPolicy")
+	fs.IntVar(&args.ChannelMaxPending, "ChannelMaxPending", 0, "Messages of each channel that may be pending at once (0 disables it)")
+	fs.IntVar(&args.ChannelMaxPerMinute, "ChannelMaxPerMinute", 0, "Messages each channel may receive per minute (0 disables it)")
+	fs.StringVar(&args.ChannelQuotaPolicy, "ChannelQuotaPolicy", defaultChannelQuotaPolicy, "What happens once a channel has ChannelMaxPending messages: either \"reject\" or \"drop-oldest\"")
+	fs.IntVar(&args.IdempotencyWindowS, "IdempotencyWindowS", defaultIdempotencyWindowS, "For how long, in seconds, responses to requests with an Idempotency-Key are remembered (0 disables it)")
+	fs.IntVar(&args.DuplicateStatus, "DuplicateStatus", defaultDuplicateStatus, "Status replied when the posted message was already stored: either 200 or 409")
+	fs.IntVar(&args.EventsIntervalS, "EventsIntervalS", defaultEventsIntervalS, "Interval, in seconds, between the statistics streamed as Server-Sent Events on /events")
+	fs.StringVar(&args.GitHubWebhookSecret, "GitHubWebhookSecret", "", "Secret used to verify GitHub's webhooks on /webhook/github (disabled if empty)")
+	fs.StringVar(&args.GitLabWebhookSecret, "GitLabWebhookSecret", "", "Secret token of GitLab's webhooks on /webhook/gitlab (disabled if empty)")
+	fs.StringVar(&args.SentryWebhookSecret, "SentryWebhookSecret", "", "Client secret of the Sentry integration sending webhooks to /webhook/sentry (disabled if empty)")
+	fs.StringVar(&args.SlackSigningSecret, "SlackSigningSecret", "", "Signing secret of the Slack app sending commands and events to /webhook/slack (disabled if empty)")
+	fs.IntVar(&args.TimeoutMS, "TimeoutMS", defaultTimeoutMS, "Timeout for the server to check if there are any messages, in milliseconds")
+	fs.StringVar(&args.LocalStore, "LocalStore", defaultLocalStore, "Directory where the local storage saves messages temporarily")
+	fs.BoolVar(&args.DeadLetter, "DeadLetter", defaultDeadLetter, "Keep messages rejected by the SQS in a dead-letter area, instead of discarding them")
+	fs.StringVar(&args.Endpoint, "Endpoint", "", "URI where a custom AWS simulator (e.g., localstack) may be accessed.")
+	fs.StringVar(&args.Queue, "Queue", "", "URI where the SQS may be accessed")
+	fs.StringVar(&args.ConsumeQueue, "ConsumeQueue", "", "URI of the queue whose messages are delivered by the consume command (defaults to Queue)")
+	fs.StringVar(&args.Region, "Region", "", "AWS region of the queue (inferred from the queue's URI if empty)")
+	fs.StringVar(&args.Profile, "Profile", "", "Named AWS profile used to load credentials from the shared configuration")
+	fs.BoolVar(&args.CreateQueue, "CreateQueue", false, "Create the queue on start up if it doesn't exist")
+	fs.StringVar(&args.LargePayloadBucket, "LargePayloadBucket", "", "S3 bucket where messages too large for the queue are uploaded")
+	fs.IntVar(&args.LargePayloadThreshold, "LargePayloadThreshold", defaultLargePayloadThreshold, "Size, in bytes, above which messages are uploaded to S3")
+	fs.BoolVar(&args.ChunkMessages, "ChunkMessages", false, "Split messages too large for the queue into chunks")
+	fs.IntVar(&args.AWSTimeoutMS, "AWSTimeoutMS", defaultAWSTimeoutMS, "Timeout for each request made to AWS, in milliseconds")
+	fs.StringVar(&args.CollectorAddr, "CollectorAddr", "", "Address of a gRPC collector service that receives the messages instead of the SQS")
+	fs.BoolVar(&args.CollectorTLS, "CollectorTLS", false, "Connect to the collector over TLS")
+	fs.StringVar(&args.CollectorCAFile, "CollectorCAFile", "", "PEM file with the CAs used to verify the collector")
+	fs.StringVar(&args.GitHubToken, "GitHubToken", "", "Token used to file issues in the 'github' destinations that don't set their own")
+	fs.BoolVar(&args.DryRun, "DryRun", false, "Log messages instead of sending them to the SQS")
+	fs.StringVar(&args.DryRunFile, "DryRunFile", "", "File where messages are appended on dry-run mode")
+	fs.IntVar(&args.RetryMaxAttempts, "RetryMaxAttempts", defaultRetryMaxAttempts, "Maximum number of attempts for sending a message before giving up")
+	fs.IntVar(&args.RetryBaseDelayMS, "RetryBaseDelayMS", defaultRetryBaseDelayMS, "Delay before retrying to send a message, in milliseconds")
+	fs.IntVar(&args.RetryMaxDelayMS, "RetryMaxDelayMS", defaultRetryMaxDelayMS, "Maximum delay between attempts, in milliseconds")
+	fs.Float64Var(&args.RetryJitter, "RetryJitter", defaultRetryJitter, "Fraction of each delay (between 0.0 and 1.0) that's randomized")
+	fs.IntVar(&args.BreakerThreshold, "BreakerThreshold", defaultBreakerThreshold, "Number of consecutive failures after which sending is suspended (0 disables it)")
+	fs.IntVar(&args.BreakerCooldownMS, "BreakerCooldownMS", defaultBreakerCooldownMS, "For how long sending stays suspended, in milliseconds")
+	fs.IntVar(&args.ForwarderStuckS, "ForwarderStuckS", defaultForwarderStuckS, "How long, in seconds, the forwarder may take to forward a single message before it's considered stuck (in which case systemd's watchdog stops being notified). 0 disables the check")
+	fs.IntVar(&args.ForwarderWatchdogS, "ForwarderWatchdogS", defaultForwarderWatchdogS, "Interval, in seconds, between checks of whether the forwarder stalled with a non-empty backlog (0 disables it)")
+	fs.IntVar(&args.ForwarderWatchdogIntervals, "ForwarderWatchdogIntervals", defaultForwarderWatchdogIntervals, "Number of intervals the forwarder must be inactive, with a non-empty backlog, before it's force-woken")
+	fs.Float64Var(&args.SendRate, "SendRate", defaultSendRate, "Maximum number of messages sent per second (0 disables it)")
+	fs.IntVar(&args.SendBurst, "SendBurst", defaultSendBurst, "Maximum number of messages that may be sent at once")
+	fs.BoolVar(&args.AdaptiveThrottle, "AdaptiveThrottle", defaultAdaptiveThrottle, "Slow down whenever the SQS throttles messages")
+	fs.Float64Var(&args.AdaptiveMinRate, "AdaptiveMinRate", defaultAdaptiveMinRate, "Slowest rate, in messages per second, used when throttled")
+	fs.Float64Var(&args.AdaptiveMaxRate, "AdaptiveMaxRate", defaultAdaptiveMaxRate, "Fastest rate, in messages per second, used when not throttled")
+	fs.Float64Var(&args.ClientRate, "ClientRate", 0, "Requests per second, on average, accepted from each client (0 disables it)")
+	fs.IntVar(&args.ClientBurst, "ClientBurst", defaultClientBurst, "Requests accepted at once from each client")
+	fs.StringVar(&args.IPAllowList, "IPAllowList", "", "Comma-separated list of networks (in CIDR notation) allowed to access the server (empty allows any)")
+	fs.StringVar(&args.IPDenyList, "IPDenyList", "", "Comma-separated list of networks (in CIDR notation) denied access to the server")
+	fs.StringVar(&args.TrustedProxies, "TrustedProxies", "", "Comma-separated list of reverse proxies (in CIDR notation) trusted to set X-Forwarded-For and X-Real-IP")
+	fs.IntVar(&args.MaxConnections, "MaxConnections", 0, "Maximum simultaneous connections to the server (0 disables it)")
+	fs.IntVar(&args.MaxInFlightPosts, "MaxInFlightPosts", 0, "Maximum POST requests handled at once (0 disables it)")
+	fs.StringVar(&args.AuthJWKSURL, "AuthJWKSURL", "", "URL of the JWKS endpoint with the keys that sign the accepted JWTs (empty disables authentication)")
+	fs.StringVar(&args.AuthJWTIssuer, "AuthJWTIssuer", "", "Required issuer of the JWTs")
+	fs.StringVar(&args.AuthJWTAudience, "AuthJWTAudience", "", "Required audience of the JWTs")
+	fs.StringVar(&args.AuthJWTChannelsClaim, "AuthJWTChannelsClaim", defaultAuthJWTChannelsClaim, "Claim listing the channels to which the JWT's subject may post")
+	fs.StringVar(&args.AuthJWTAdminClaim, "AuthJWTAdminClaim", defaultAuthJWTAdminClaim, "Claim that, when true, grants the JWT's subject administrative access")
+	fs.IntVar(&args.AuthJWKSCacheTTLS, "AuthJWKSCacheTTLS", defaultAuthJWKSCacheTTLS, "For how long, in seconds, the keys from the JWKS endpoint are cached")
+	fs.StringVar(&args.AuthBasicUsers, "AuthBasicUsers", "", "Comma-separated list of \"<username>:<bcrypt hash>\" accepted through HTTP Basic authentication")
+	fs.StringVar(&args.AuthBasicAdmins, "AuthBasicAdmins", "", "Comma-separated list of the AuthBasicUsers that may use administrative endpoints")
+	fs.IntVar(&args.HeartbeatMinutes, "HeartbeatMinutes", 0, "Interval, in minutes, between heartbeats (0 disables them)")
+	fs.StringVar(&args.HeartbeatMode, "HeartbeatMode", defaultHeartbeatMode, "How heartbeats are done: 'check' (the queue is reachable) or 'message' (a synthetic message is sent)")
+	fs.IntVar(&args.AlertBacklog, "AlertBacklog", 0, "Number of messages waiting in the local storage from which an alert is sent (0 disables it)")
+	fs.IntVar(&args.AlertOldestAgeS, "AlertOldestAgeS", 0, "Age, in seconds, of the oldest waiting message from which an alert is sent (0 disables it)")
+	fs.IntVar(&args.AlertSendFailures, "AlertSendFailures", 0, "Number of consecutive messages that failed to be sent from which an alert is sent (0 disables it)")
+	fs.StringVar(&args.AlertSlackWebhookURL, "AlertSlackWebhookURL", "", "Slack incoming webhook to which alerts are posted")
+	fs.StringVar(&args.AlertSMTPAddr, "AlertSMTPAddr", "", "Address (\"host:port\") of the SMTP server through which alerts are emailed")
+	fs.StringVar(&args.AlertSMTPUser, "AlertSMTPUser", "", "User used to authenticate to the SMTP server")
+	fs.StringVar(&args.AlertSMTPPassword, "AlertSMTPPassword", "", "Password used to authenticate to the SMTP server")
+	fs.StringVar(&args.AlertEmailFrom, "AlertEmailFrom", "", "Address from which alerts are emailed")
+	fs.StringVar(&args.AlertEmailTo, "AlertEmailTo", "", "Comma-separated list of the addresses to which alerts are emailed")
+	fs.IntVar(&args.AlertIntervalS, "AlertIntervalS", defaultAlertIntervalS, "Interval, in seconds, between checks of the alert thresholds")
+	fs.IntVar(&args.AlertRepeatMinutes, "AlertRepeatMinutes", defaultAlertRepeatMinutes, "For how long, in minutes, an alert stays raised before being sent again (0 only sends it once)")
+	fs.StringVar(&args.MessageTemplateFile, "MessageTemplateFile", "", "File with a Go template used to reshape each message before it's sent")
+	fs.StringVar(&args.SigningKey, "SigningKey", "", "Secret key used to sign messages (with HMAC-SHA256)")
+	fs.StringVar(&args.EncryptionKMSKeyID, "EncryptionKMSKeyID", "", "ID, ARN or alias of the KMS key used to encrypt messages")
+	fs.StringVar(&args.WebIdentityTokenFile, "WebIdentityTokenFile", "", "File with a web identity token exchanged for the base credentials (defaults to $AWS_WEB_IDENTITY_TOKEN_FILE)")
+	fs.StringVar(&args.WebIdentityRoleARN, "WebIdentityRoleARN", "", "ARN of the role associated with the web identity token (defaults to $AWS_ROLE_ARN)")
+	fs.StringVar(&args.AssumeRoleARN, "AssumeRoleARN", "", "ARN of an IAM role assumed for sending messages")
+	fs.StringVar(&args.AssumeRoleExternalID, "AssumeRoleExternalID", "", "External ID required by the assumed role, if any")
+	fs.StringVar(&args.AssumeRoleSessionName, "AssumeRoleSessionName", defaultAssumeRoleSessionName, "Session name used when assuming the role")
+	fs.IntVar(&args.ForwarderWorkers, "ForwarderWorkers", defaultForwarderWorkers, "Number of workers forwarding messages from the local storage at once")
+	fs.IntVar(&args.MaxInFlight, "MaxInFlight", 0, "Maximum number of messages being sent at once, across every worker (0 only limits it by ForwarderWorkers)")
+	fs.IntVar(&args.ForwarderBackoffBaseMS, "ForwarderBackoffBaseMS", defaultForwarderBackoffBaseMS, "For how long the forwarder backs off after a message fails to be sent, in milliseconds (0 disables it)")
+	fs.IntVar(&args.ForwarderBackoffMaxMS, "ForwarderBackoffMaxMS", defaultForwarderBackoffMaxMS, "Maximum time the forwarder backs off, in milliseconds")
+	fs.Float64Var(&args.ForwarderBackoffJitter, "ForwarderBackoffJitter", defaultForwarderBackoffJitter, "Fraction of each backoff (between 0.0 and 1.0) that's randomized")
+	fs.BoolVar(&args.Paused, "Paused", false, "Start with forwarding paused, until resumed through admin/resume")
+	fs.IntVar(&args.DrainOnExitS, "DrainOnExitS", 0, "For how long, in seconds, the backlog is drained when the server is stopped (0 exits immediately)")
+	fs.StringVar(&args.VaultAddr, "VaultAddr", "", "Address of the HashiCorp Vault server, from where secrets and AWS credentials may be read")
+	fs.StringVar(&args.VaultAuthMethod, "VaultAuthMethod", defaultVaultAuthMethod, "How the server authenticates to Vault: 'token', 'approle' or 'kubernetes'")
+	fs.StringVar(&args.VaultToken, "VaultToken", "", "Token used to access Vault, for the 'token' method")
+	fs.StringVar(&args.VaultRoleID, "VaultRoleID", "", "The AppRole's role ID, for the 'approle' method")
+	fs.StringVar(&args.VaultSecretID, "VaultSecretID", "", "The AppRole's secret ID, for the 'approle' method")
+	fs.StringVar(&args.VaultK8sRole, "VaultK8sRole", "", "Vault role bound to the service account, for the 'kubernetes' method")
+	fs.StringVar(&args.VaultK8sTokenFile, "VaultK8sTokenFile", vault.DefaultKubernetesTokenFile, "File with the service account's token, for the 'kubernetes' method")
+	fs.StringVar(&args.VaultAuthMount, "VaultAuthMount", "", "Path where the authentication method is mounted in Vault (defaults to the method's name)")
+	fs.StringVar(&args.VaultNamespace, "VaultNamespace", "", "Vault Enterprise namespace")
+	fs.StringVar(&args.VaultCAFile, "VaultCAFile", "", "PEM file with the CAs used to verify Vault")
+	fs.StringVar(&args.VaultAWSCredsPath, "VaultAWSCredsPath", "", "Path, in Vault's AWS secrets engine, of the AWS credentials used as the base ones")
+}
+
+// DefaultArgs retrieves the default value of every option, as used when
+// the server is run from the command line without any option.
+func DefaultArgs() Args {
+	var args Args
+	registerArgs(flag.NewFlagSet("notifier", flag.ContinueOnError), &args)
+	return args
+}
+
+// redactedValue replaces the value of secrets that are set, whenever the
+// options are displayed.
+const redactedValue = "<redacted>"
+
+// redactArgs replaces the value of every secret in args that is set (i.e.,
+// the options tagged as secrets) with redactedValue. The secrets of each
+// destination are also redacted.
+func redactArgs(args Args) Args {
+	redactFields(reflect.ValueOf(&args).Elem())
+	return args
+}
+
+// redactFields replaces the value of every secret field in v, a struct,
+// with redactedValue, recursing into slices of structs. Slices are copied
+// before being redacted, so the original values are kept.
+func redactFields(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		if flagoverride.Secret(v.Type().Field(i)) && f.Kind() == reflect.String && f.Len() > 0 {
+			f.SetString(redactedValue)
+		} else if f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.Struct && f.Len() > 0 {
+			dup := reflect.MakeSlice(f.Type(), f.Len(), f.Len())
+			reflect.Copy(dup, f)
+			f.Set(dup)
+			for j := 0; j < f.Len(); j++ {
+				redactFields(f.Index(j))
+			}
+		}
+	}
+}
+
+// logArgs logs every option in args, one per entry, redacting the secrets.
+func logArgs(args Args) {
+	slog.Info("Starting server with options:")
+
+	v := reflect.ValueOf(redactArgs(args))
+	for i := 0; i < v.NumField(); i++ {
+		slog.Info("Option", "name", v.Type().Field(i).Name, "value", v.Field(i).Interface())
+	}
+}
+
+// argNote is a message about how the options were set (e.g., which ones
+// were overridden by the CLI), kept until the logger is configured.
+type argNote struct {
+	// The entry's message.
+	msg string
+
+	// The entry's attributes, as in slog.Info.
+	attrs []any
+}
+
+// argNotes are the messages noted while parsing the options. They're only
+// logged by logArgNotes, as the logger is configured by the options
+// themselves (e.g., LogFormat).
+var argNotes []argNote
+
+// noteArg keeps msg (with attrs, as in slog.Info) until logArgNotes is
+// called.
+func noteArg(msg string, attrs ...any) {
+	argNotes = append(argNotes, argNote{msg, attrs})
+}
+
+// logArgNotes logs every message noted while parsing the options.
+func logArgNotes() {
+	for _, note := range argNotes {
+		slog.Info(note.msg, note.attrs...)
+	}
+	argNotes = nil
+}
+
+// loadConfFile loads the options in confFile over the ones set by their
+// defaults and by the environment, overriding them with the ones set on
+// the CLI. Options missing from the file keep their previous values.
+func loadConfFile() (Args, error) {
+	jsonArgs := envArgs
+
+	err := decodeConfFile(confFile, &jsonArgs)
+	if err != nil {
+		return jsonArgs, err
+	}
+
+	// Override the file with every option set on the CLI
+	overrides, err := flagoverride.Apply(&jsonArgs, flag.CommandLine, append([]string{"confFile"}, commandFlags...)...)
+	if err != nil {
+		return jsonArgs, err
+	}
+	for _, o := range overrides {
+		if o.Secret {
+			noteArg("Overriding the configuration file's option with the CLI's value", "option", o.Name)
+		} else {
+			noteArg("Overriding the configuration file's option with the CLI's value", "option", o.Name, "file", o.Old, "cli", o.New)
+		}
+	}
+
+	return jsonArgs, nil
+}
+
+// decodeConfFile decodes the configuration file at path into args. The
+// file's format is selected by its extension: ".yaml" (or ".yml") for
+// YAML, ".toml" for TOML, and JSON otherwise. Options are matched by their
+// names, case-insensitively, regardless of the format, and options missing
+// from the file are left unchanged.
+func decodeConfFile(path string, args *Args) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var fields map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &fields)
+	case ".toml":
+		err = toml.Unmarshal(data, &fields)
+	default:
+		return json.Unmarshal(data, args)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Decode the other formats through JSON, so options are named just
+	// like in the JSON file.
+	data, err = json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, args)
+}
+
+// envPrefix is prepended to the (upper case) name of each option, to get
+// the environment variable that sets it (e.g., SQSNOTIFIER_PORT).
+const envPrefix = "SQSNOTIFIER_"
+
+// applyEnv sets every option with its environment variable, if it's set.
+// It must be called before flag.Parse, so the options set in the CLI
+// override the environment.
+func applyEnv() {
+	flag.VisitAll(func (f *flag.Flag) {
+		name := envPrefix + strings.ToUpper(f.Name)
+
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+
+		err := f.Value.Set(val)
+		if err != nil {
+			log.Fatalf("Invalid value for '%s' (%s): %+v", f.Name, name, err)
+		}
+		noteArg("Set the option from the environment", "option", f.Name, "variable", name)
+	})
+}
+
+// splitList splits a comma separated list, as used by some arguments,
+// ignoring empty items and surrounding whitespace.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,100 @@
+package notifier
+
+import (
+	"encoding/json"
+	"github.com/SirGFM/sqs-issue-notifier/server/logrotate"
+	"log/slog"
+	"time"
+)
+
+// auditEntry is the format of each line in the audit log.
+type auditEntry struct {
+	// When the event happened.
+	Time time.Time
+
+	// What happened to the message: either a local_storage.EventType
+	// (e.g., "stored", "dead-lettered" or "purged"), or eventSent,
+	// eventFailed or eventDuplicate.
+	Event string
+
+	// Identifies the message in the local storage.
+	ID string
+
+	// The message's channel, if known.
+	Channel string `json:",omitempty"`
+
+	// Identifies the request that sent the message, if known.
+	RequestID string `json:",omitempty"`
+
+	// Identifier assigned to the message by the receiver, once sent.
+	MessageID string `json:",omitempty"`
+
+	// Why sending the message failed.
+	Error string `json:",omitempty"`
+}
+
+// auditLog appends every event about each message to a file, as JSON lines,
+// so what happened to any message may be reconstructed later (e.g., after
+// an incident). The file is only ever appended to, and it's rotated as
+// configured.
+type auditLog struct {
+	// The audit log, which is safe for concurrent use.
+	file *logrotate.File
+}
+
+// openAuditLog opens args.AuditLogFile, rotating it as set by the
+// args.AuditLogMax* and args.AuditLogRotateHours options. It returns nil
+// if the audit log is disabled.
+func openAuditLog(args Args) (*auditLog, error) {
+	if len(args.AuditLogFile) == 0 {
+		return nil, nil
+	}
+
+	f, err := logrotate.Open(args.AuditLogFile, logrotate.Options{
+		MaxSize: int64(args.AuditLogMaxSizeMB) << 20,
+		Period: time.Duration(args.AuditLogRotateHours) * time.Hour,
+		MaxBackups: args.AuditLogMaxBackups,
+		MaxAge: time.Duration(args.AuditLogMaxAgeDays) * 24 * time.Hour,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &auditLog{file: f}, nil
+}
+
+// Record ev in the audit log, unless it isn't about a message (e.g., the
+// current counts).
+func (a *auditLog) Record(ev pipelineEvent) {
+	if a == nil || len(ev.ID) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(&auditEntry{
+		Time: ev.Time,
+		Event: ev.Type,
+		ID: ev.ID,
+		Channel: ev.Channel,
+		RequestID: ev.RequestID,
+		MessageID: ev.MessageID,
+		Error: ev.Error,
+	})
+	if err != nil {
+		slog.Error("Failed to encode the audit entry", "id", ev.ID, "event", ev.Type, "err", err)
+		return
+	}
+
+	_, err = a.file.Write(append(data, '\n'))
+	if err != nil {
+		slog.Error("Failed to write the audit entry", "id", ev.ID, "event", ev.Type, "err", err)
+	}
+}
+
+// Close the audit log.
+func (a *auditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+
+	return a.file.Close()
+}
@@ -0,0 +1,521 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/SirGFM/sqs-issue-notifier/server/local_storage"
+	"github.com/SirGFM/sqs-issue-notifier/server/sendermw"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// command is one of the binary's subcommands (e.g., "serve" or "list").
+type command struct {
+	// Describes the command's arguments, for its usage.
+	usage string
+
+	// Briefly describes what the command does.
+	summary string
+
+	// Registers the command's own flags, if any, before the options are
+	// parsed.
+	flags func()
+
+	// Runs the command with the parsed options and its positional
+	// arguments.
+	run func(args Args, params []string)
+}
+
+// defaultCommand is run if the binary is started without a command, so
+// it's started just as before commands existed.
+const defaultCommand = "serve"
+
+// commands lists every subcommand, by name. It's set on init, as the help
+// command lists the commands.
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"serve": {
+			usage: "[options]",
+			summary: "Run the server (the default, if no command is given)",
+			run: runServe,
+		},
+		"send": {
+			usage: "[-URL <url> [-Token <token>]] [-Priority <priority>] [options] <channel> <message>",
+			summary: "Send a message through a running server, or store it directly in the local storage",
+			flags: func() {
+				remoteFlags()
+				flag.StringVar(&sendPriority, "Priority", "", "The message's priority: low, normal or high")
+				commandFlags = append(commandFlags, "Priority")
+			},
+			run: runSend,
+		},
+		"list": {
+			usage: "[-URL <url> [-Token <token>]] [options]",
+			summary: "List the messages in the backlog (or, with -URL, count them)",
+			flags: remoteFlags,
+			run: runList,
+		},
+		"drain": {
+			usage: "[-URL <url> [-Token <token>]] [-Wait <duration>] [options]",
+			summary: "Send every message in the backlog, then exit",
+			flags: func() {
+				remoteFlags()
+				flag.DurationVar(&drainWait, "Wait", maxFlushTimeout, "How long to wait for the backlog to drain")
+				commandFlags = append(commandFlags, "Wait")
+			},
+			run: runDrain,
+		},
+		"purge": {
+			usage: "[-URL <url> [-Token <token>]] [options] [<id>]",
+			summary: "Remove the message identified by id, or every message in the backlog",
+			flags: remoteFlags,
+			run: runPurge,
+		},
+		"dlq": {
+			usage: "[-URL <url> [-Token <token>]] [options] [list | requeue [<id>] | purge [<id>]]",
+			summary: "List, requeue or remove the dead-lettered messages",
+			flags: remoteFlags,
+			run: runDLQ,
+		},
+		"replay": {
+			usage: "[-From <queue>] [-To <queue> | -URL <url> [-Token <token>]] [-Max <count>] [-Wait <duration>] [options]",
+			summary: "Read the messages back from a queue (e.g., its dead-letter queue) and store them again, or send them to another queue",
+			flags: replayFlags,
+			run: runReplay,
+		},
+		"consume": {
+			usage: "[options]",
+			summary: "Deliver the messages in ConsumeQueue (e.g., as issues filed by \"github\" destinations) until interrupted",
+			run: runConsume,
+		},
+		"validate-config": {
+			usage: "[options]",
+			summary: "Validate the configuration and print it (with the secrets redacted), without starting the server",
+			run: runValidateConfig,
+		},
+		"doctor": {
+			usage: "[options]",
+			summary: "Check the configuration, the local storage, the AWS credentials, the destinations and the clock",
+			run: runDoctor,
+		},
+		"print-default-config": {
+			usage: "[-Format yaml|json]",
+			summary: "Print the default value of every option, as a configuration file to start from",
+			flags: func() {
+				flag.StringVar(&configFormat, "Format", configYAML, "The configuration's format: yaml (commented with each option's description) or json")
+				commandFlags = append(commandFlags, "Format")
+			},
+			run: runPrintDefaultConfig,
+		},
+		"version": {
+			usage: "",
+			summary: "Print the build's version, commit and date",
+			run: runVersion,
+		},
+		"help": {
+			usage: "",
+			summary: "List the commands",
+			run: func(Args, []string) {
+				printCommands(os.Stdout)
+			},
+		},
+	}
+}
+
+// printCommands writes the list of commands to w.
+func printCommands(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(w, "Usage: %s <command> [options]\n\nCommands:\n", os.Args[0])
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-16s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintf(w, "\nRun '%s <command> -h' for the command's options.\n", os.Args[0])
+}
+
+// runCommand runs the command named in the binary's first argument (or
+// defaultCommand, if the first argument is an option).
+func runCommand() {
+	name := defaultCommand
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		name = os.Args[1]
+		// Remove the command, so the options may be parsed as usual.
+		os.Args = append(os.Args[:1:1], os.Args[2:]...)
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command '%s'\n\n", name)
+		printCommands(os.Stderr)
+		os.Exit(2)
+	}
+
+	if cmd.flags != nil {
+		cmd.flags()
+	}
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s %s %s\n\n%s.\n\nOptions:\n", os.Args[0], name, cmd.usage, cmd.summary)
+		flag.PrintDefaults()
+	}
+
+	args := parseArgs()
+	// Anything logged through the log package is also formatted by slog.
+	slog.SetDefault(newLogger(args, logOutput(args)))
+	logArgNotes()
+	if err := resolveSecrets(&args); err != nil {
+		fatal("Couldn't resolve the secrets", "err", err)
+	}
+	cmd.run(args, flag.Args())
+}
+
+// The flags of the commands that may manage a running server.
+var (
+	// The running server's URL. If empty, the local storage is managed
+	// directly.
+	remoteURL string
+
+	// Bearer token sent to the running server.
+	remoteToken string
+)
+
+// remoteFlags registers the flags used to manage a running server.
+func remoteFlags() {
+	flag.StringVar(&remoteURL, "URL", "", "URL of the running server (e.g., http://localhost:8888). If empty, the local storage is managed directly, which requires the server to be stopped")
+	flag.StringVar(&remoteToken, "Token", "", "Bearer token sent to the running server. For HTTP Basic authentication, set the credentials in the URL instead")
+	commandFlags = append(commandFlags, "URL", "Token")
+}
+
+// remoteCall sends a request to the running server at remoteURL, printing
+// its reply. It exits if the server doesn't accept the request.
+func remoteCall(method, path string, body []byte) {
+	req, err := http.NewRequest(method, strings.TrimSuffix(remoteURL, "/") + path, bytes.NewReader(body))
+	if err != nil {
+		fatal("Invalid URL", "url", remoteURL, "err", err)
+	}
+	req.Header.Set("Accept", "text/plain")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if len(remoteToken) > 0 {
+		req.Header.Set("Authorization", "Bearer " + remoteToken)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fatal("Couldn't reach the server", "url", remoteURL, "err", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fatal("Couldn't read the server's reply", "err", err)
+	}
+	fmt.Println(strings.TrimSpace(string(data)))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		fatal("The server didn't accept the request", "status", resp.Status)
+	}
+}
+
+// openStore opens the local storage directly, for the commands that manage
+// it while the server is stopped. Call the returned function to close it.
+func openStore(args Args) (local_storage.Store, func()) {
+	lock, err := lockStore(args.LocalStore)
+	if err == errStoreInUse {
+		fatal("The local storage is in use (probably by a running server): stop it or set -URL", "dir", args.LocalStore)
+	} else if err != nil {
+		fatal("Couldn't lock the local storage", "dir", args.LocalStore, "err", err)
+	}
+
+	store := local_storage.NewFS(args.LocalStore, 0)
+	return store, func() {
+		store.Close()
+		lock.Close()
+	}
+}
+
+// runServe runs the server.
+func runServe(args Args, params []string) {
+	if len(params) > 0 {
+		fatal("Unexpected arguments", "args", params)
+	}
+
+	if len(args.LogFile) > 0 {
+		f, err := openLogFile(args)
+		if err != nil {
+			fatal("Couldn't open the log file", "file", args.LogFile, "err", err)
+		}
+		slog.Info("Logging to a file", "file", args.LogFile)
+		slog.SetDefault(newLogger(args, f))
+	}
+
+	bi := getBuildInfo()
+	slog.Info("Starting the server",
+			"version", bi.Version,
+			"commit", bi.Commit,
+			"build_date", bi.BuildDate,
+			"go_version", bi.GoVersion,
+	)
+	logArgs(args)
+	startServer(args)
+}
+
+// The priority of the message sent by the send command.
+var sendPriority string
+
+// runSend sends a message to a channel, either through the running server
+// or by storing it directly in the local storage. Messages stored directly
+// skip the server's policies (e.g., schemas and quotas).
+func runSend(args Args, params []string) {
+	if len(params) < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	msg := storedMessage{
+		message: message{
+			Channel: params[0],
+			Message: strings.Join(params[1:], " "),
+		},
+	}
+	if len(sendPriority) > 0 {
+		err := msg.Priority.UnmarshalText([]byte(sendPriority))
+		if err != nil {
+			fatal("Invalid priority", "priority", sendPriority, "err", err)
+		}
+	}
+
+	if len(remoteURL) > 0 {
+		data, err := json.Marshal(&msg)
+		if err != nil {
+			fatal("Failed to encode the message", "err", err)
+		}
+		remoteCall(http.MethodPost, "/message", data)
+		return
+	}
+
+	store, closeStore := openStore(args)
+	defer closeStore()
+
+	msg.RequestID = newRequestID()
+	data, err := json.Marshal(&msg)
+	if err != nil {
+		fatal("Failed to encode the message", "err", err)
+	}
+
+	id, err := store.StorePriority(data, msg.Priority)
+	if err == local_storage.ErrDuplicatedStore {
+		fmt.Printf("Duplicate: %s\n", id)
+	} else if err != nil {
+		fatal("Failed to store the message", "err", err)
+	} else {
+		fmt.Printf("Stored: %s\n", id)
+	}
+}
+
+// printEntries writes each entry in entries to stdout, on its own line.
+func printEntries(entries []local_storage.Entry) {
+	for _, entry := range entries {
+		fmt.Printf("%s\t%s\t%s\t%s\n", entry.ID, entry.StoredAt.Format(time.RFC3339), entry.Priority, entry.Bytes)
+	}
+}
+
+// runList lists the messages in the backlog. A running server only
+// reports how many messages are pending.
+func runList(args Args, params []string) {
+	if len(params) > 0 {
+		fatal("Unexpected arguments", "args", params)
+	} else if len(remoteURL) > 0 {
+		remoteCall(http.MethodGet, "/message", nil)
+		return
+	}
+
+	store, closeStore := openStore(args)
+	defer closeStore()
+
+	entries, err := store.Entries()
+	if err != nil {
+		fatal("Failed to list the messages", "err", err)
+	}
+	fmt.Printf("Pending messages: %d\n", len(entries))
+	printEntries(entries)
+}
+
+// How long the drain command waits for the backlog to drain.
+var drainWait time.Duration
+
+// runDrain sends every message in the backlog, exiting once it's empty. It
+// fails if the backlog doesn't drain within drainWait.
+func runDrain(args Args, params []string) {
+	if len(params) > 0 {
+		fatal("Unexpected arguments", "args", params)
+	} else if len(remoteURL) > 0 {
+		remoteCall(http.MethodPost, "/admin/flush?wait=" + url.QueryEscape(drainWait.String()), nil)
+		return
+	}
+
+	requireValidArgs(args)
+
+	store, closeStore := openStore(args)
+	defer closeStore()
+
+	var stats sendermw.Stats
+	p, err := newPipeline(args, nil, &stats, nil, nil)
+	if err != nil {
+		fatal("Couldn't create the pipeline", "err", err)
+	}
+	fw := startForwarder(args, store, p, nil, nil, nil)
+	result := fw.Drain(drainWait)
+	fw.Stop()
+	fmt.Printf("Sent: %d\nRemaining: %d\n", result.Sent, result.Remaining)
+
+	if !result.Drained {
+		fatal("The backlog didn't drain", "wait", drainWait)
+	}
+}
+
+// runPurge removes the message identified by params[0], or every message
+// in the backlog.
+func runPurge(args Args, params []string) {
+	if len(params) > 1 {
+		fatal("Unexpected arguments", "args", params[1:])
+	} else if len(remoteURL) > 0 {
+		path := "/message"
+		if len(params) == 1 {
+			path += "/" + url.PathEscape(params[0])
+		}
+		remoteCall(http.MethodDelete, path, nil)
+		return
+	}
+
+	store, closeStore := openStore(args)
+	defer closeStore()
+
+	if len(params) == 1 {
+		err := store.RemoveByID(params[0])
+		if err != nil {
+			fatal("Failed to remove the message", "id", params[0], "err", err)
+		}
+		fmt.Println("Removed messages: 1")
+		return
+	}
+
+	removed, err := store.Purge()
+	if err != nil {
+		fatal("Failed to purge the messages", "err", err)
+	}
+	fmt.Printf("Removed messages: %d\n", removed)
+}
+
+// runDLQ manages the dead-lettered messages: "list" (the default) lists
+// them, while "requeue" and "purge" move them back into the backlog or
+// remove them, respectively (either a single message, if its ID is given,
+// or every one).
+func runDLQ(args Args, params []string) {
+	action := "list"
+	if len(params) > 0 {
+		action, params = params[0], params[1:]
+	}
+	if len(params) > 1 || (action == "list" && len(params) > 0) {
+		fatal("Unexpected arguments", "args", params)
+	}
+
+	var id string
+	if len(params) == 1 {
+		id = params[0]
+	}
+
+	if len(remoteURL) > 0 {
+		switch action {
+		case "list":
+			remoteCall(http.MethodGet, "/deadletter", nil)
+		case "requeue":
+			if len(id) > 0 {
+				remoteCall(http.MethodPost, "/message/" + url.PathEscape(id) + "/requeue", nil)
+			} else {
+				remoteCall(http.MethodPost, "/deadletter/requeue", nil)
+			}
+		case "purge":
+			if len(id) > 0 {
+				remoteCall(http.MethodDelete, "/deadletter/" + url.PathEscape(id), nil)
+			} else {
+				remoteCall(http.MethodDelete, "/deadletter", nil)
+			}
+		default:
+			fatal("Unknown action (expected list, requeue or purge)", "action", action)
+		}
+		return
+	}
+
+	var fn func(id string) error
+	var all func() (int, error)
+	var field string
+
+	store, closeStore := openStore(args)
+	defer closeStore()
+
+	switch action {
+	case "list":
+		entries, err := store.DeadLetters()
+		if err != nil {
+			fatal("Failed to list the dead letters", "err", err)
+		}
+		fmt.Printf("Dead letters: %d\n", len(entries))
+		printEntries(entries)
+		return
+	case "requeue":
+		fn, all, field = store.Requeue, store.RequeueAll, "Requeued"
+	case "purge":
+		fn, all, field = store.RemoveDeadLetter, store.PurgeDeadLetters, "Removed"
+	default:
+		fatal("Unknown action (expected list, requeue or purge)", "action", action)
+	}
+
+	count := 1
+	var err error
+	if len(id) > 0 {
+		err = fn(id)
+	} else {
+		count, err = all()
+	}
+	if err != nil {
+		fatal("Failed to update the dead letters", "err", err)
+	}
+	fmt.Printf("%s: %d\n", field, count)
+}
+
+// runValidateConfig validates the configuration, printing the effective
+// options (i.e., after the environment, the configuration file and the
+// CLI are applied) as JSON. It exits with an error if the configuration
+// has any problem, so it may be used to check changes to the
+// configuration (e.g., in CI).
+func runValidateConfig(args Args, params []string) {
+	if len(params) > 0 {
+		fatal("Unexpected arguments", "args", params)
+	}
+
+	requireValidArgs(args)
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "\t")
+	err := enc.Encode(redactArgs(args))
+	if err != nil {
+		fatal("Failed to encode the configuration", "err", err)
+	}
+	slog.Info("The configuration is valid")
+}
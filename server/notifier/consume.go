@@ -0,0 +1,149 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/SirGFM/sqs-issue-notifier/server/sender"
+	"github.com/SirGFM/sqs-issue-notifier/server/sendermw"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// consumeWait is how long the consume command waits for messages on each
+// receive (i.e., the longest long polling allowed by SQS).
+const consumeWait = 20 * time.Second
+
+// consumeStats counts what happened to the consumed messages.
+type consumeStats struct {
+	// Messages delivered, and then removed from the queue.
+	Delivered int
+
+	// Messages rejected by their destinations, left in the queue to be
+	// moved to its dead-letter queue (if any).
+	Rejected int
+
+	// Messages that failed to be delivered, left in the queue to be
+	// received again.
+	Failed int
+}
+
+// consume delivers every message received from r through s (e.g., filing
+// an issue for each of them), until ctx is done. Messages are only deleted
+// from the queue once delivered, so the ones that fail are received again
+// once their visibility timeout expires. Rejected messages are left in the
+// queue as well, so its redrive policy moves them to its dead-letter queue.
+//
+// After a failure (either receiving or delivering), consuming backs off for
+// backoff, doubled after every consecutive failure up to maxBackoff.
+func consume(ctx context.Context, r sender.Receiver, s sender.Sender, wait, backoff, maxBackoff time.Duration) consumeStats {
+	var st consumeStats
+
+	delay := backoff
+	pause := func() {
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+		delay = min(delay * 2, maxBackoff)
+	}
+
+	for ctx.Err() == nil {
+		msgs, err := r.Receive(sender.MaxReceive, wait)
+		if err != nil {
+			slog.Warn("Couldn't receive the messages", "err", err)
+			pause()
+			continue
+		}
+
+		for _, msg := range msgs {
+			if ctx.Err() != nil {
+				// The remaining messages are received again later.
+				break
+			}
+
+			res, err := s.Send(msg.Message)
+			if errors.Is(err, sender.ErrRejected) || errors.Is(err, sender.ErrInvalidInput) {
+				slog.Warn("The message was rejected", "id", msg.MessageID, "receives", msg.ReceiveCount, "err", err)
+				st.Rejected++
+				continue
+			} else if err != nil {
+				// The remaining messages would likely fail as well.
+				slog.Warn("Couldn't deliver the message", "id", msg.MessageID, "err", err)
+				st.Failed++
+				pause()
+				break
+			}
+			delay = backoff
+
+			slog.Info("Delivered a message", "id", msg.MessageID, "result", res.MessageID)
+			st.Delivered++
+			err = r.Delete(msg)
+			if err != nil {
+				// The message will be received again, but its
+				// destinations should recognize it as a duplicate.
+				slog.Warn("Couldn't delete the delivered message", "id", msg.MessageID, "err", err)
+			}
+		}
+	}
+
+	return st
+}
+
+// runConsume delivers the messages in ConsumeQueue (e.g., as sent by
+// another server) to the configured destinations, usually filing them as
+// issues, until it's interrupted.
+func runConsume(args Args, params []string) {
+	if len(params) > 0 {
+		fatal("Unexpected arguments", "args", params)
+	}
+
+	from := args.ConsumeQueue
+	if len(from) == 0 {
+		from = args.Queue
+	}
+	if len(from) == 0 {
+		fatal("No queue to consume: set ConsumeQueue (or Queue)")
+	} else if err := checkQueueURL(from); err != nil {
+		fatal("Invalid ConsumeQueue", "queue", from, "err", err)
+	}
+	for _, d := range configuredDestinations(args) {
+		if (d.Type == "" || d.Type == destinationSQS) && d.Queue == from {
+			fatal("Can't consume a queue into itself (set Destinations)", "queue", from)
+		}
+	}
+	if len(args.Destinations) > 0 {
+		errs := validateDestinations(args)
+		for _, err := range errs {
+			slog.Error("Invalid configuration", "err", err)
+		}
+		if len(errs) > 0 {
+			fatal("Fix the configuration and try again", "problems", len(errs))
+		}
+	}
+
+	opts, err := awsOptions(args)
+	if err != nil {
+		fatal("Couldn't configure the access to AWS", "err", err)
+	}
+	r, err := sender.NewSQSReceiver(args.Endpoint, from, opts)
+	if err != nil {
+		fatal("Couldn't create the receiver", "queue", from, "err", err)
+	}
+	p, err := newPipeline(args, nil, &sendermw.Stats{}, nil, nil)
+	if err != nil {
+		fatal("Couldn't create the destinations", "err", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	slog.Info("Consuming the messages", "queue", from)
+	st := consume(ctx, r, p.sender, consumeWait,
+			time.Duration(args.ForwarderBackoffBaseMS) * time.Millisecond,
+			time.Duration(args.ForwarderBackoffMaxMS) * time.Millisecond)
+	fmt.Printf("Delivered: %d\nRejected: %d\nFailed: %d\n", st.Delivered, st.Rejected, st.Failed)
+}
@@ -0,0 +1,264 @@
+package notifier
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/SirGFM/sqs-issue-notifier/server/notifierpb"
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/vmihailenco/msgpack/v5/msgpcode"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Media types accepted on message bodies.
+const (
+	mediaJSON = "application/json"
+	mediaText = "text/plain"
+	mediaForm = "application/x-www-form-urlencoded"
+	mediaBinary = "application/octet-stream"
+	mediaProtobuf = "application/x-protobuf"
+	mediaMsgpack = "application/msgpack"
+	mediaXMsgpack = "application/x-msgpack"
+)
+
+// channelHeader is the header that selects the channel of messages sent
+// on non-JSON bodies. Alternatively, the "channel" query parameter may be
+// used.
+const channelHeader = "X-Channel"
+
+// priorityHeader is the header that sets the priority of messages, for
+// clients that can't set it in the message itself. Besides the priority's
+// name, it accepts the values of e-mail's X-Priority header (1 to 5).
+const priorityHeader = "X-Priority"
+
+// errUnsupportedMedia is returned by decodeMessage for bodies of unknown
+// media types.
+var errUnsupportedMedia = errors.New("unsupported media type")
+
+// errMissingChannel is returned by decodeMessage for non-JSON bodies
+// without a channel.
+var errMissingChannel = errors.New("missing channel (set it in the 'channel' query parameter or in the " + channelHeader + " header)")
+
+// errMalformedUTF8 is returned by decodeMessage for messages whose channel or
+// text isn't valid UTF-8. Those would be silently altered once the message
+// is encoded as JSON, so they're rejected instead.
+var errMalformedUTF8 = errors.New("malformed UTF-8 in the channel or in the message")
+
+// errTooNested is returned by decodeMessage for msgpack bodies nested deeper
+// than maxNesting.
+var errTooNested = errors.New("too deeply nested")
+
+// maxNesting limits how deeply the values of msgpack bodies may be nested.
+// No field of a message is nested deeper than a couple of levels, so this
+// only rejects bodies that would be expensive to decode (JSON bodies are
+// already limited by encoding/json).
+const maxNesting = 32
+
+// requestMediaType retrieves the media type of req's body. Requests
+// without a Content-Type are considered JSON, as that's what clients
+// always sent.
+//
+// Some clients (e.g., "curl --data") send JSON as
+// "application/x-www-form-urlencoded", so those bodies are also checked
+// for a JSON object. In that case, req's Content-Type is fixed, so the
+// rest of the server sees the actual media type.
+func requestMediaType(req *http.Request) string {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || len(mediaType) == 0 {
+		return mediaJSON
+	} else if mediaType != mediaForm || req.Body == nil {
+		return mediaType
+	}
+
+	br := bufio.NewReader(req.Body)
+	req.Body = struct {
+		io.Reader
+		io.Closer
+	}{br, req.Body}
+
+	for {
+		c, err := br.Peek(1)
+		if err != nil {
+			return mediaType
+		} else if c[0] != ' ' && c[0] != '\t' && c[0] != '\r' && c[0] != '\n' {
+			if c[0] == '{' {
+				req.Header.Set("Content-Type", mediaJSON)
+				return mediaJSON
+			}
+			return mediaType
+		}
+		br.ReadByte()
+	}
+}
+
+// decodeMessage decodes the message sent on req's body, according to its
+// Content-Type:
+//
+//   - application/json: a JSON object (see storedMessage);
+//   - application/x-www-form-urlencoded: the fields "channel", "message",
+//     "delaySeconds" and "priority";
+//   - text/plain: the body is the message itself;
+//   - application/octet-stream: the body is the message itself. If it
+//     isn't valid UTF-8, it's encoded as base64 (and the message's
+//     Encoding is set);
+//   - application/x-protobuf: a notifierpb.Message;
+//   - application/msgpack (or application/x-msgpack): a map with the same
+//     fields as the JSON object.
+//
+// Except for JSON, the channel may be sent either in the "channel" query
+// parameter or in the X-Channel header.
+func decodeMessage(req *http.Request) (storedMessage, error) {
+	var msg storedMessage
+
+	switch requestMediaType(req) {
+	case mediaJSON:
+		err := json.NewDecoder(req.Body).Decode(&msg)
+		return msg, err
+	case mediaForm:
+		err := req.ParseForm()
+		if err != nil {
+			return msg, err
+		}
+
+		msg.Message = req.PostForm.Get("message")
+		msg.Channel = req.PostForm.Get("channel")
+		if delay := req.PostForm.Get("delaySeconds"); len(delay) > 0 {
+			msg.DelaySeconds, err = strconv.ParseInt(delay, 10, 64)
+			if err != nil {
+				return msg, err
+			}
+		}
+		if priority := req.PostForm.Get("priority"); len(priority) > 0 {
+			err = msg.Priority.UnmarshalText([]byte(priority))
+			if err != nil {
+				return msg, err
+			}
+		}
+	case mediaProtobuf:
+		data, err := io.ReadAll(req.Body)
+		if err != nil {
+			return msg, err
+		}
+
+		var in notifierpb.Message
+		err = proto.Unmarshal(data, &in)
+		if err != nil {
+			return msg, err
+		}
+		msg, err = storedFromProto(&in)
+		if err != nil {
+			return msg, err
+		}
+	case mediaMsgpack, mediaXMsgpack:
+		fields, err := decodeMsgpackMap(msgpack.NewDecoder(req.Body), 0)
+		if err != nil {
+			return msg, err
+		}
+
+		// Decode the fields just like a JSON object, so they're
+		// also matched case-insensitively.
+		data, err := json.Marshal(fields)
+		if err != nil {
+			return msg, err
+		}
+		err = json.Unmarshal(data, &msg)
+		if err != nil {
+			return msg, err
+		}
+	case mediaText, mediaBinary:
+		data, err := io.ReadAll(req.Body)
+		if err != nil {
+			return msg, err
+		}
+
+		if utf8.Valid(data) {
+			msg.Message = string(data)
+		} else {
+			msg.Message = base64.StdEncoding.EncodeToString(data)
+			msg.Encoding = "base64"
+		}
+	default:
+		return msg, errUnsupportedMedia
+	}
+
+	if len(msg.Channel) == 0 {
+		msg.Channel = req.URL.Query().Get("channel")
+	}
+	if len(msg.Channel) == 0 {
+		msg.Channel = req.Header.Get(channelHeader)
+	}
+	if len(msg.Channel) == 0 {
+		return msg, errMissingChannel
+	} else if !utf8.ValidString(msg.Channel) || !utf8.ValidString(msg.Message) {
+		return msg, errMalformedUTF8
+	}
+
+	return msg, nil
+}
+
+// decodeMsgpackMap decodes a msgpack map with string keys from d, failing
+// if it's nested deeper than maxNesting. depth is how deeply nested the map
+// is itself.
+func decodeMsgpackMap(d *msgpack.Decoder, depth int) (map[string]any, error) {
+	n, err := d.DecodeMapLen()
+	if err != nil || n == -1 {
+		return nil, err
+	}
+
+	// Don't trust the length to allocate the map, as it comes from the
+	// client.
+	fields := make(map[string]any)
+	for i := 0; i < n; i++ {
+		key, err := d.DecodeString()
+		if err != nil {
+			return nil, err
+		}
+		fields[key], err = decodeMsgpackValue(d, depth + 1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fields, nil
+}
+
+// decodeMsgpackValue decodes any msgpack value from d, failing if it's
+// nested deeper than maxNesting. depth is how deeply nested the value is
+// itself.
+func decodeMsgpackValue(d *msgpack.Decoder, depth int) (any, error) {
+	if depth > maxNesting {
+		return nil, errTooNested
+	}
+
+	c, err := d.PeekCode()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case msgpcode.IsFixedMap(c) || c == msgpcode.Map16 || c == msgpcode.Map32:
+		return decodeMsgpackMap(d, depth)
+	case msgpcode.IsFixedArray(c) || c == msgpcode.Array16 || c == msgpcode.Array32:
+		n, err := d.DecodeArrayLen()
+		if err != nil || n == -1 {
+			return nil, err
+		}
+
+		var values []any
+		for i := 0; i < n; i++ {
+			v, err := decodeMsgpackValue(d, depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	default:
+		return d.DecodeInterface()
+	}
+}
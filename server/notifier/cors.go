@@ -0,0 +1,96 @@
+package notifier
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// corsPolicy configures which cross-origin requests browsers may make to
+// the server.
+type corsPolicy struct {
+	// Origins allowed to make cross-origin requests.
+	origins map[string]bool
+
+	// Whether any origin is allowed.
+	anyOrigin bool
+
+	// Value of the Access-Control-Allow-Methods header.
+	methods string
+
+	// Value of the Access-Control-Allow-Headers header.
+	headers string
+
+	// Value of the Access-Control-Max-Age header.
+	maxAge string
+}
+
+// newCORSPolicy creates the CORS policy configured in args. It returns nil
+// if CORS is disabled.
+func newCORSPolicy(args Args) *corsPolicy {
+	origins := splitList(args.CORSOrigins)
+	if len(origins) == 0 {
+		return nil
+	}
+
+	c := &corsPolicy{
+		origins: make(map[string]bool),
+		methods: strings.Join(splitList(args.CORSMethods), ", "),
+		headers: strings.Join(splitList(args.CORSHeaders), ", "),
+		maxAge: strconv.Itoa(args.CORSMaxAgeS),
+	}
+	for _, origin := range origins {
+		if origin == "*" {
+			c.anyOrigin = true
+		}
+		c.origins[strings.TrimSuffix(origin, "/")] = true
+	}
+
+	return c
+}
+
+// Allows checks whether requests from origin are allowed. Always false if
+// CORS is disabled (i.e., c is nil).
+func (c *corsPolicy) Allows(origin string) bool {
+	return c != nil && (c.anyOrigin || c.origins[strings.TrimSuffix(origin, "/")])
+}
+
+// wrap next, so cross-origin requests from allowed origins are accepted.
+// Preflight requests are answered directly, as browsers send them without
+// any credentials.
+func (c *corsPolicy) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		origin := req.Header.Get("Origin")
+		if len(origin) == 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		// The response depends on the origin, so it mustn't be cached
+		// for other origins.
+		w.Header().Add("Vary", "Origin")
+		if !c.Allows(origin) {
+			// Simply omit the CORS headers, so the browser blocks the
+			// response.
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		if c.anyOrigin {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+
+		if req.Method == http.MethodOptions && len(req.Header.Get("Access-Control-Request-Method")) > 0 {
+			w.Header().Set("Access-Control-Allow-Methods", c.methods)
+			w.Header().Set("Access-Control-Allow-Headers", c.headers)
+			w.Header().Set("Access-Control-Max-Age", c.maxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Access-Control-Expose-Headers", "Retry-After, " + requestIDHeader)
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,32 @@
+package notifier
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// The dashboard's resource: the server's root.
+const dashboardResource = ""
+
+// dashboardPage is a small page that displays the pipeline's statistics,
+// streamed from the 'events' resource, and lets administrators flush or
+// purge the backlog.
+//
+//go:embed dashboard.html
+var dashboardPage []byte
+
+// GetDashboard handles GET requests on the server's root, serving the
+// dashboard. The page itself doesn't require authentication, as it only
+// contains static content: it asks for a token, sent on every request it
+// makes to the other endpoints.
+func (s *server) GetDashboard(w http.ResponseWriter, req *http.Request, res []string) {
+	if len(res) > 1 {
+		httpTextReply(http.StatusNotFound, "Invalid resource", w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	writeData(dashboardPage, w)
+}
@@ -0,0 +1,166 @@
+package notifier
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// The formats printed by print-default-config.
+const (
+	configYAML = "yaml"
+	configJSON = "json"
+)
+
+// configFormat is the format printed by print-default-config.
+var configFormat string
+
+// configCommentWidth is the width where the options' descriptions are
+// wrapped.
+const configCommentWidth = 76
+
+// fileOnlyArgs describes the options that may only be set in the
+// configuration file, as they don't have a flag (and thus a usage).
+var fileOnlyArgs = map[string]string{
+	"Destinations": "Destinations that receive every message, instead of the single one set by Endpoint and Queue (or by CollectorAddr). Each one has a Name, a Type ('sqs', 'grpc', 'github' or 'dry-run'), its Endpoint and Queue, and optionally its own Region, Profile, AssumeRoleARN, AssumeRoleExternalID, CreateQueue, TLS, CAFile, File, Repo, Labels, Token and TimeoutMS",
+	"Routes": "Routes selecting the destinations of each channel's messages, matched in order. Each one has a Channel (where '*' matches anything), the names of its Destinations, and optionally a Priority, DelaySeconds and Attributes",
+}
+
+// defaultArgs retrieves the default value of every option, as registered
+// by parseArgs, regardless of how they were set in this run.
+func defaultArgs() (Args, error) {
+	var args Args
+
+	v := reflect.ValueOf(&args).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		name := v.Type().Field(i).Name
+
+		if f.Kind() == reflect.Slice {
+			// Encoded as an empty list, instead of null.
+			f.Set(reflect.MakeSlice(f.Type(), 0, 0))
+			continue
+		}
+
+		fl := flag.Lookup(name)
+		if fl == nil {
+			continue
+		}
+
+		var err error
+		switch f.Kind() {
+		case reflect.String:
+			f.SetString(fl.DefValue)
+		case reflect.Int:
+			var n int64
+			n, err = strconv.ParseInt(fl.DefValue, 0, 64)
+			f.SetInt(n)
+		case reflect.Float64:
+			var n float64
+			n, err = strconv.ParseFloat(fl.DefValue, 64)
+			f.SetFloat(n)
+		case reflect.Bool:
+			var b bool
+			b, err = strconv.ParseBool(fl.DefValue)
+			f.SetBool(b)
+		}
+		if err != nil {
+			return args, fmt.Errorf("invalid default for '%s': %w", name, err)
+		}
+	}
+
+	return args, nil
+}
+
+// argUsage retrieves the description of the option name.
+func argUsage(name string) string {
+	if fl := flag.Lookup(name); fl != nil {
+		return fl.Usage
+	}
+	return fileOnlyArgs[name] + " (may only be set in the configuration file)"
+}
+
+// wrapComment wraps text into lines of up to configCommentWidth characters.
+func wrapComment(text string) string {
+	var lines []string
+	var line string
+	for _, word := range strings.Fields(text) {
+		if len(line) > 0 && len(line) + 1 + len(word) > configCommentWidth {
+			lines = append(lines, line)
+			line = ""
+		}
+		if len(line) > 0 {
+			line += " "
+		}
+		line += word
+	}
+	return strings.Join(append(lines, line), "\n")
+}
+
+// encodeDefaultYAML encodes args as YAML, with every option preceded by its
+// description.
+func encodeDefaultYAML(args Args) ([]byte, error) {
+	root := &yaml.Node{
+		Kind: yaml.MappingNode,
+	}
+
+	v := reflect.ValueOf(args)
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Name
+
+		var value yaml.Node
+		err := value.Encode(v.Field(i).Interface())
+		if err != nil {
+			return nil, fmt.Errorf("'%s': %w", name, err)
+		}
+
+		key := &yaml.Node{
+			Kind: yaml.ScalarNode,
+			Value: name,
+			HeadComment: wrapComment(argUsage(name)),
+		}
+		root.Content = append(root.Content, key, &value)
+	}
+
+	doc := &yaml.Node{
+		Kind: yaml.DocumentNode,
+		HeadComment: wrapComment("Default configuration of the sqs-issue-notifier server. Load it with '-confFile <file>.yaml', after changing what's needed. Options may also be set by the environment (e.g., SQSNOTIFIER_PORT) and by the CLI, which override this file."),
+		Content: []*yaml.Node{root},
+	}
+	return yaml.Marshal(doc)
+}
+
+// runPrintDefaultConfig prints the default value of every option, in the
+// format selected by configFormat, so it may be used to start a
+// configuration file. YAML is commented with each option's description.
+func runPrintDefaultConfig(args Args, params []string) {
+	if len(params) > 0 {
+		fatal("Unexpected arguments", "args", params)
+	}
+
+	defaults, err := defaultArgs()
+	if err != nil {
+		fatal("Couldn't retrieve the defaults", "err", err)
+	}
+
+	var data []byte
+	switch configFormat {
+	case configYAML:
+		data, err = encodeDefaultYAML(defaults)
+	case configJSON:
+		data, err = json.MarshalIndent(defaults, "", "\t")
+		data = append(data, '\n')
+	default:
+		fatal("Invalid format, must be either 'yaml' or 'json'", "format", configFormat)
+	}
+	if err != nil {
+		fatal("Failed to encode the configuration", "err", err)
+	}
+
+	os.Stdout.Write(data)
+}
@@ -0,0 +1,299 @@
+package notifier
+
+import (
+	"errors"
+	"fmt"
+	"github.com/SirGFM/sqs-issue-notifier/server/sender"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// doctorMinFreeBytes is the free space, in the local storage's file
+// system, below which the doctor warns about it.
+const doctorMinFreeBytes = 100 * 1024 * 1024
+
+// How far the clock may be from AWS's before the doctor warns about it.
+const doctorWarnSkew = 30 * time.Second
+
+// How far the clock may be from AWS's before requests are rejected (as
+// their signatures expire).
+const doctorMaxSkew = 5 * time.Minute
+
+// doctorClockURL is the server whose clock is compared with the local one,
+// if no destination is reachable over HTTP.
+const doctorClockURL = "https://sts.amazonaws.com"
+
+// The result of each check done by the doctor.
+const (
+	doctorPass = "PASS"
+	doctorWarn = "WARN"
+	doctorFail = "FAIL"
+	doctorSkip = "SKIP"
+)
+
+// doctor checks the environment where the server runs, printing a report
+// with the result of each check.
+type doctor struct {
+	// Where the report is written.
+	out io.Writer
+
+	// Number of failed checks.
+	failed int
+
+	// Number of checks that passed with a warning.
+	warned int
+}
+
+// report the result of the check named name, described by the format
+// string and its arguments.
+func (d *doctor) report(result, name, format string, a ...any) {
+	switch result {
+	case doctorFail:
+		d.failed++
+	case doctorWarn:
+		d.warned++
+	}
+	fmt.Fprintf(d.out, "[%s] %s: %s\n", result, name, fmt.Sprintf(format, a...))
+}
+
+// runDoctor checks the most common environmental problems (i.e., the
+// configuration, the local storage, the AWS credentials, whether the
+// destinations are reachable and the clock), printing whether each check
+// passed. It exits with an error if any check failed.
+func runDoctor(args Args, params []string) {
+	if len(params) > 0 {
+		fatal("Unexpected arguments", "args", params)
+	}
+
+	d := doctor{out: os.Stdout}
+	d.checkConfig(args)
+	d.checkStorage(args)
+	d.checkDestinations(args)
+	d.checkClock(args)
+
+	fmt.Fprintf(d.out, "\n%d failed, %d warnings\n", d.failed, d.warned)
+	if d.failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// checkConfig reports every problem found in the configuration.
+func (d *doctor) checkConfig(args Args) {
+	const name = "Configuration"
+
+	errs := validateArgs(args)
+	for _, err := range errs {
+		d.report(doctorFail, name, "%v", err)
+	}
+	if len(errs) == 0 {
+		d.report(doctorPass, name, "valid")
+	}
+}
+
+// checkStorage checks whether the local storage exists, is writable and
+// has enough free space, and whether a server is using it.
+func (d *doctor) checkStorage(args Args) {
+	const name = "Local storage"
+
+	dir := args.LocalStore
+	if len(dir) == 0 {
+		d.report(doctorSkip, name, "LocalStore isn't set")
+		return
+	}
+
+	if info, err := os.Stat(dir); os.IsNotExist(err) {
+		d.report(doctorWarn, name, "'%s' doesn't exist (it's created on startup)", dir)
+	} else if err != nil {
+		d.report(doctorFail, name, "'%s' can't be read: %v", dir, err)
+		return
+	} else if !info.IsDir() {
+		d.report(doctorFail, name, "'%s' isn't a directory", dir)
+		return
+	}
+
+	if err := checkWritableDir(dir); err != nil {
+		d.report(doctorFail, name, "%v", err)
+		return
+	}
+	d.report(doctorPass, name, "'%s' is writable", dir)
+
+	// The space is checked on the closest existing directory, as dir may
+	// not have been created yet.
+	existing := dir
+	for {
+		if _, err := os.Stat(existing); err == nil || filepath.Dir(existing) == existing {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(existing, &fs); err != nil {
+		d.report(doctorWarn, name, "couldn't check the free space: %v", err)
+	} else if free := fs.Bavail * uint64(fs.Bsize); free < doctorMinFreeBytes {
+		d.report(doctorFail, name, "only %s free on '%s'", formatBytes(free), existing)
+	} else {
+		d.report(doctorPass, name, "%s free on '%s'", formatBytes(free), existing)
+	}
+
+	if _, err := os.Stat(dir); err == nil {
+		lock, err := lockStore(dir)
+		if err == errStoreInUse {
+			d.report(doctorPass, name, "in use by a running server")
+		} else if err != nil {
+			d.report(doctorFail, name, "couldn't be locked: %v", err)
+		} else {
+			lock.Close()
+		}
+	}
+}
+
+// formatBytes formats n bytes in a human readable unit (e.g., "1.5 GiB").
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n) / float64(div), "KMGTPE"[exp])
+}
+
+// checkDestinations checks whether the credentials of each destination may
+// be resolved, and whether the destination is reachable. Queues are never
+// created, even if CreateQueue is set.
+func (d *doctor) checkDestinations(args Args) {
+	if args.DryRun {
+		d.report(doctorSkip, "Destinations", "DryRun is set, so messages aren't delivered")
+		return
+	}
+
+	for _, dest := range configuredDestinations(args) {
+		name := fmt.Sprintf("Destination '%s'", dest.Name)
+
+		switch dest.Type {
+		case destinationDryRun:
+			d.report(doctorSkip, name, "messages aren't delivered")
+			continue
+		case destinationGRPC:
+			_, err := newSender(args, dest)
+			if err != nil {
+				d.report(doctorFail, name, "%v", err)
+			} else {
+				d.report(doctorPass, name, "the collector at '%s' is configured", dest.Endpoint)
+			}
+			continue
+		case destinationGitHub:
+			s, err := newSender(args, dest)
+			if err != nil {
+				d.report(doctorFail, name, "%v", err)
+				continue
+			}
+			switch err := s.(sender.Checker).Check(); {
+			case err == nil:
+				d.report(doctorPass, name, "the repository '%s' is accessible", dest.Repo)
+			case errors.Is(err, sender.ErrNotFound):
+				d.report(doctorFail, name, "the repository '%s' doesn't exist (or the token can't read it)", dest.Repo)
+			default:
+				d.report(doctorFail, name, "the repository '%s' isn't accessible: %v", dest.Repo, err)
+			}
+			continue
+		}
+
+		opts, err := destinationOptions(args, dest)
+		if err != nil {
+			d.report(doctorFail, name, "%v", err)
+			continue
+		}
+		opts.CreateQueue = false
+
+		sess, err := sender.NewAWSSession(dest.Endpoint, opts)
+		if err != nil {
+			d.report(doctorFail, name, "%v", err)
+			continue
+		}
+		creds, err := sess.Config.Credentials.Get()
+		if err != nil {
+			d.report(doctorFail, name, "couldn't resolve the AWS credentials: %v", err)
+			continue
+		}
+		d.report(doctorPass, name, "AWS credentials resolved by %s", creds.ProviderName)
+
+		s, err := sender.NewSQSSender(dest.Endpoint, dest.Queue, opts)
+		if err != nil {
+			d.report(doctorFail, name, "%v", err)
+			continue
+		}
+		checker, ok := s.(sender.Checker)
+		if !ok {
+			continue
+		}
+		switch err := checker.Check(); {
+		case err == nil:
+			d.report(doctorPass, name, "the queue '%s' is reachable", dest.Queue)
+		case errors.Is(err, sender.ErrNotFound):
+			d.report(doctorFail, name, "the queue '%s' doesn't exist", dest.Queue)
+		default:
+			d.report(doctorFail, name, "the queue '%s' isn't reachable: %v", dest.Queue, err)
+		}
+	}
+}
+
+// checkClock compares the local clock with the one of the first SQS
+// destination's endpoint (or AWS's), as AWS rejects requests signed too far
+// from its own time.
+func (d *doctor) checkClock(args Args) {
+	const name = "Clock"
+
+	target := doctorClockURL
+	for _, dest := range configuredDestinations(args) {
+		if dest.Type != "" && dest.Type != destinationSQS {
+			continue
+		}
+		raw := dest.Endpoint
+		if len(raw) == 0 {
+			raw = dest.Queue
+		}
+		if u, err := url.Parse(raw); err == nil && len(u.Host) > 0 {
+			target = u.Scheme + "://" + u.Host
+			break
+		}
+	}
+
+	client := http.Client{
+		Timeout: time.Duration(args.AWSTimeoutMS) * time.Millisecond,
+	}
+	start := time.Now()
+	resp, err := client.Head(target)
+	if err != nil {
+		d.report(doctorWarn, name, "couldn't reach '%s' to compare the clocks: %v", target, err)
+		return
+	}
+	resp.Body.Close()
+	// Assume the remote time was taken halfway through the request.
+	local := start.Add(time.Since(start) / 2)
+
+	remote, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		d.report(doctorWarn, name, "'%s' didn't report its time", target)
+		return
+	}
+
+	// The Date header is truncated to seconds.
+	skew := local.Sub(remote).Round(time.Second)
+	if skew.Abs() > doctorMaxSkew {
+		d.report(doctorFail, name, "%s off from '%s' (AWS rejects requests over %s off)", skew, target, doctorMaxSkew)
+	} else if skew.Abs() > doctorWarnSkew {
+		d.report(doctorWarn, name, "%s off from '%s'", skew, target)
+	} else {
+		d.report(doctorPass, name, "%s off from '%s'", skew, target)
+	}
+}
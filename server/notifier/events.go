@@ -0,0 +1,415 @@
+package notifier
+
+import (
+	"encoding/json"
+	"github.com/SirGFM/sqs-issue-notifier/server/local_storage"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Types of pipelineEvent other than the local storage's events.
+const (
+	// The current backlog, sent when a client subscribes.
+	eventCounts = "counts"
+	// The message was sent.
+	eventSent = "sent"
+	// Sending the message failed. It's retried later, unless it was
+	// rejected.
+	eventFailed = "failed"
+	// The message wasn't stored, as it was already stored.
+	eventDuplicate = "duplicate"
+)
+
+// How many events may be queued for each subscriber before events start
+// being dropped.
+const eventBufferSize = 64
+
+// How often WebSocket clients are pinged, to detect dead connections.
+const eventPingInterval = 30 * time.Second
+
+// How long writing each event may take.
+const eventWriteTimeout = 10 * time.Second
+
+// How many of the latest failures are kept in the statistics.
+const recentFailures = 10
+
+// pipelineEvent describes something that happened to a message in the
+// pipeline.
+type pipelineEvent struct {
+	// What happened: either a local_storage.EventType (e.g., "stored"), or
+	// eventCounts, eventSent, eventFailed or eventDuplicate.
+	Type string
+
+	// Identifies the message in the local storage.
+	ID string `json:",omitempty"`
+
+	// The message's channel, if known.
+	Channel string `json:",omitempty"`
+
+	// Identifies the request that sent the message, if known.
+	RequestID string `json:",omitempty"`
+
+	// Identifier assigned to the message by the receiver, on eventSent.
+	MessageID string `json:",omitempty"`
+
+	// Why sending the message failed, on eventFailed.
+	Error string `json:",omitempty"`
+
+	// Number of messages in the local storage, right after the event.
+	Backlog int
+
+	// When the event happened.
+	Time time.Time
+}
+
+// channelStats counts what happened to a channel's messages since the
+// server started.
+type channelStats struct {
+	// Messages stored in the local storage.
+	Stored int
+
+	// Messages sent.
+	Sent int
+
+	// Failed attempts to send a message.
+	Failed int
+
+	// Messages moved to the dead-letter area.
+	DeadLettered int
+
+	// Messages moved back from the dead-letter area.
+	Requeued int
+}
+
+// pipelineStats summarizes the pipeline's state.
+type pipelineStats struct {
+	// Number of messages in the local storage.
+	Backlog int
+
+	// Age, in seconds, of the oldest message in the local storage, or 0
+	// if it's empty.
+	OldestAgeS float64
+
+	// Statistics of each channel, since the server started.
+	Channels map[string]channelStats
+
+	// The latest failures to send a message, most recent first.
+	RecentFailures []pipelineEvent
+
+	// Whether forwarding is paused.
+	Paused bool
+
+	// When the statistics were collected.
+	Time time.Time
+}
+
+// eventHub broadcasts events to every subscriber, while collecting
+// statistics about them.
+type eventHub struct {
+	// The local storage whose events are published. Nil until attached.
+	store local_storage.Store
+
+	// Synchronizes access to the fields below.
+	mutex sync.Mutex
+
+	// Every subscriber's channel.
+	subscribers map[chan pipelineEvent]struct{}
+
+	// Channel of the messages seen so far, by their ID, so events that
+	// don't carry it (e.g., once the message got dead-lettered) may still
+	// be attributed to the channel. Forgotten once the message is
+	// removed.
+	channels map[string]string
+
+	// Statistics of each channel.
+	stats map[string]*channelStats
+
+	// The latest eventFailed events, oldest first.
+	failures []pipelineEvent
+
+	// Whether the hub was closed.
+	closed bool
+
+	// Records every event about a message. Nil if disabled.
+	audit *auditLog
+}
+
+// newEventHub creates an eventHub without any subscriber, recording every
+// event in audit (if not nil).
+func newEventHub(audit *auditLog) *eventHub {
+	return &eventHub{
+		audit: audit,
+		subscribers: make(map[chan pipelineEvent]struct{}),
+		channels: make(map[string]string),
+		stats: make(map[string]*channelStats),
+	}
+}
+
+// Publish ev to every subscriber. Subscribers that can't keep up miss the
+// event, instead of delaying the pipeline.
+func (h *eventHub) Publish(ev pipelineEvent) {
+	if h == nil {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if len(ev.ID) > 0 && len(ev.Channel) == 0 {
+		ev.Channel = h.channels[ev.ID]
+	} else if len(ev.ID) > 0 {
+		h.channels[ev.ID] = ev.Channel
+	}
+	h.record(ev)
+	// Recorded while holding the mutex, so the audit log keeps the order
+	// of the events.
+	h.audit.Record(ev)
+
+	for ch := range h.subscribers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// record ev in the statistics. Must be called with the mutex held.
+func (h *eventHub) record(ev pipelineEvent) {
+	switch ev.Type {
+	case local_storage.EventRemoved.String(), local_storage.EventPurged.String(), local_storage.EventDeadLetterRemoved.String():
+		delete(h.channels, ev.ID)
+	}
+
+	if len(ev.Channel) == 0 {
+		return
+	}
+	stats, ok := h.stats[ev.Channel]
+	if !ok {
+		stats = &channelStats{}
+		h.stats[ev.Channel] = stats
+	}
+
+	switch ev.Type {
+	case local_storage.EventStored.String():
+		stats.Stored++
+	case eventSent:
+		stats.Sent++
+	case eventFailed:
+		stats.Failed++
+		h.failures = append(h.failures, ev)
+		if len(h.failures) > recentFailures {
+			h.failures = h.failures[1:]
+		}
+	case local_storage.EventDeadLettered.String():
+		stats.DeadLettered++
+	case local_storage.EventRequeued.String():
+		stats.Requeued++
+	}
+}
+
+// Stats retrieves the current statistics.
+func (h *eventHub) Stats() pipelineStats {
+	stats := pipelineStats{
+		Channels: make(map[string]channelStats),
+		Time: time.Now(),
+	}
+	if h.store != nil {
+		stats.Backlog = h.store.Count()
+		if oldest, err := h.store.Oldest(); err == nil && !oldest.StoredAt.IsZero() {
+			stats.OldestAgeS = stats.Time.Sub(oldest.StoredAt).Seconds()
+		}
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for channel, cs := range h.stats {
+		stats.Channels[channel] = *cs
+	}
+	stats.RecentFailures = make([]pipelineEvent, 0, len(h.failures))
+	for i := len(h.failures) - 1; i >= 0; i-- {
+		stats.RecentFailures = append(stats.RecentFailures, h.failures[i])
+	}
+	return stats
+}
+
+// Attach the hub to store, publishing every event in the local storage.
+func (h *eventHub) Attach(store local_storage.Store) {
+	h.store = store
+	store.OnEvent(h.storeHook)
+}
+
+// storeHook publishes an event from the local storage, looking up the
+// channel of messages that were just stored.
+func (h *eventHub) storeHook(ev local_storage.Event) {
+	pe := pipelineEvent{
+		Type: ev.Type.String(),
+		ID: ev.ID,
+		Backlog: ev.Queued,
+		Time: ev.Time,
+	}
+
+	switch ev.Type {
+	case local_storage.EventStored, local_storage.EventRequeued:
+		entry, err := h.store.Lookup(ev.ID)
+		if err == nil {
+			var msg storedMessage
+			json.Unmarshal(entry.Bytes, &msg)
+			pe.Channel = msg.Channel
+			pe.RequestID = msg.RequestID
+		}
+	}
+
+	h.Publish(pe)
+}
+
+// Subscribe to the hub's events. The returned function must be called to
+// unsubscribe. The channel is closed once either happens, or once the hub
+// is closed.
+func (h *eventHub) Subscribe() (<-chan pipelineEvent, func()) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	ch := make(chan pipelineEvent, eventBufferSize)
+	if h.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	h.subscribers[ch] = struct{}{}
+
+	return ch, func() {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+
+		if _, ok := h.subscribers[ch]; ok {
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Close the hub, closing the channel of every subscriber.
+func (h *eventHub) Close() error {
+	if h == nil {
+		return nil
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.closed = true
+	for ch := range h.subscribers {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+	return nil
+}
+
+// checkOrigin accepts WebSocket connections either from the server's own
+// origin or from the origins allowed by the CORS policy.
+func (s *server) checkOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if len(origin) == 0 {
+		return true
+	} else if u, err := url.Parse(origin); err == nil && u.Host == req.Host {
+		return true
+	}
+	return s.cors.Allows(origin)
+}
+
+// GetEvents handles GET requests on the 'events' resource, upgrading the
+// connection to a WebSocket that streams every pipelineEvent, as JSON
+// messages. The current backlog is sent as soon as the client connects.
+//
+// Requests that don't ask for a WebSocket receive the pipeline's
+// statistics periodically, as Server-Sent Events, instead.
+func (s *server) GetEvents(w http.ResponseWriter, req *http.Request, res []string) {
+	if len(res) > 1 {
+		httpTextReply(http.StatusNotFound, "Invalid resource", w)
+		return
+	} else if s.events == nil {
+		httpTextReply(http.StatusNotFound, "Events are disabled", w)
+		return
+	} else if !websocket.IsWebSocketUpgrade(req) {
+		s.streamStats(w, req)
+		return
+	}
+
+	upgrader := websocket.Upgrader{
+		CheckOrigin: s.checkOrigin,
+	}
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		// Upgrade already replied to the request.
+		reqLogger(req).Info("Couldn't upgrade to WebSocket", "err", err)
+		return
+	}
+	defer conn.Close()
+
+	// The connection outlives the request, so it mustn't be limited by
+	// the server's ReadTimeout. Dead connections are detected by the
+	// pings, instead.
+	conn.SetReadDeadline(time.Time{})
+
+	events, unsubscribe := s.events.Subscribe()
+	defer unsubscribe()
+
+	// Read (and discard) everything sent by the client, so control
+	// messages are handled and closed connections are detected.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	} ()
+
+	reqLogger(req).Info("Streaming events")
+
+	ping := time.NewTicker(eventPingInterval)
+	defer ping.Stop()
+
+	ev := pipelineEvent{
+		Type: eventCounts,
+		Backlog: s.store.Count(),
+		Time: time.Now(),
+	}
+	for {
+		if len(ev.Type) > 0 {
+			conn.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+			err = conn.WriteJSON(&ev)
+			if err != nil {
+				reqLogger(req).Info("Stopped streaming events", "err", err)
+				return
+			}
+		}
+
+		ev = pipelineEvent{}
+		select {
+		case <-closed:
+			reqLogger(req).Info("Stopped streaming events")
+			return
+		case <-ping.C:
+			deadline := time.Now().Add(eventWriteTimeout)
+			err = conn.WriteControl(websocket.PingMessage, nil, deadline)
+			if err != nil {
+				reqLogger(req).Info("Stopped streaming events", "err", err)
+				return
+			}
+		case e, ok := <-events:
+			if !ok {
+				// The server is closing.
+				deadline := time.Now().Add(time.Second)
+				msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "")
+				conn.WriteControl(websocket.CloseMessage, msg, deadline)
+				return
+			}
+			ev = e
+		}
+	}
+}
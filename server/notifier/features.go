@@ -0,0 +1,80 @@
+package notifier
+
+import (
+	"net/http"
+)
+
+// features selects which optional resources are served on the main
+// address, so a deployment may only expose what it needs (e.g., only
+// accept messages). The admin listener serves every resource.
+type features struct {
+	// Whether the backlog's count (GET message) is served.
+	count bool
+
+	// Whether stored messages and dead letters are served.
+	listing bool
+
+	// Whether the administrative endpoints are served.
+	admin bool
+
+	// Whether webhooks and PagerDuty's events are accepted.
+	webhooks bool
+
+	// Whether the pipeline's events and the dashboard are served.
+	events bool
+
+	// Whether the store's and the forwarder's metrics are served.
+	metrics bool
+}
+
+// newFeatures selects the resources enabled by args.
+func newFeatures(args Args) features {
+	return features{
+		count: args.EnableCount,
+		listing: args.EnableListing,
+		admin: args.EnableAdmin,
+		webhooks: args.EnableWebhooks,
+		events: args.EnableEvents,
+		metrics: args.EnableMetrics && args.MetricsSink == metricsPrometheus,
+	}
+}
+
+// endpoints maps the endpoints that may be disabled as a whole to whether
+// they're enabled. The others are checked by their handlers.
+func (f features) endpoints() map[endpoint]bool {
+	return map[endpoint]bool{
+		endpoint{"deadletter", http.MethodGet}: f.listing,
+		endpoint{"events", http.MethodGet}: f.events,
+		endpoint{dashboardResource, http.MethodGet}: f.events,
+		endpoint{webhookResource, http.MethodPost}: f.webhooks,
+		endpoint{"v2", http.MethodPost}: f.webhooks,
+		endpoint{"metrics", http.MethodGet}: f.metrics,
+	}
+}
+
+// requireFeature checks whether a resource that's enabled (by one of the
+// features) may be served for req, replying as if it didn't exist
+// otherwise.
+func requireFeature(enabled bool, w http.ResponseWriter, req *http.Request) bool {
+	if enabled || fromAdminListener(req) {
+		return true
+	}
+
+	httpTextReply(http.StatusNotFound, "Invalid resource", w)
+	reqLogger(req).Info("The resource is disabled")
+	return false
+}
+
+// withFeature wraps h, so it's only served if enabled (or by the admin
+// listener).
+func withFeature(enabled bool, h endpointHandler) endpointHandler {
+	if enabled {
+		return h
+	}
+
+	return func(w http.ResponseWriter, req *http.Request, res []string) {
+		if requireFeature(enabled, w, req) {
+			h(w, req, res)
+		}
+	}
+}
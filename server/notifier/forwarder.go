@@ -0,0 +1,85 @@
+package notifier
+
+import (
+	"encoding/json"
+	"github.com/SirGFM/sqs-issue-notifier/server/forwarder"
+	"github.com/SirGFM/sqs-issue-notifier/server/health"
+	"github.com/SirGFM/sqs-issue-notifier/server/local_storage"
+	"github.com/SirGFM/sqs-issue-notifier/server/metrics"
+	"time"
+)
+
+// backoffPolicy creates the policy the forwarder backs off with after
+// consecutive failures, as configured in args.
+func backoffPolicy(args Args) forwarder.BackoffPolicy {
+	return forwarder.BackoffPolicy{
+		BaseDelay: time.Duration(args.ForwarderBackoffBaseMS) * time.Millisecond,
+		MaxDelay: time.Duration(args.ForwarderBackoffMaxMS) * time.Millisecond,
+		Jitter: args.ForwarderBackoffJitter,
+	}
+}
+
+// startForwarder launches the workers (args.ForwarderWorkers, at least one)
+// that forward every message in store through the pipeline, with at most
+// args.MaxInFlight messages being sent at once (if positive), backing off
+// after consecutive failures. Every message sent (or failed) is published
+// to events, the current backoff is exported through reg (if not nil) and,
+// if hr isn't nil, the forwarder's health is reported to it.
+func startForwarder(args Args, store local_storage.Store, p pipeline, events *eventHub, reg *metrics.Registry, hr *health.Registry) *forwarder.Forwarder {
+	fw := forwarder.New(store, p.sender, forwarder.Options{
+		Workers: args.ForwarderWorkers,
+		MaxInFlight: args.MaxInFlight,
+		DeadLetter: args.DeadLetter,
+		Paused: args.Paused,
+		Backoff: backoffPolicy(args),
+		Breaker: p.breaker,
+		Decode: decodeStored,
+		OnEvent: func(ev forwarder.Event) {
+			publishForwarded(events, store, ev)
+		},
+		RequestIDAttr: requestIDAttr,
+		Health: hr,
+		Component: componentForwarder,
+		Logger: componentLogger(componentForwarder),
+	})
+	fw.Start()
+
+	if reg != nil {
+		backoff := reg.Gauge(forwarderMetricsPrefix + "_backoff_seconds", "For how long the forwarder is backing off after consecutive failures.")
+		paused := reg.Gauge(forwarderMetricsPrefix + "_paused", "Whether forwarding is paused (1) or not (0).")
+		reg.OnCollect(func() {
+			backoff.Set(fw.Backoff().Seconds())
+			if fw.Paused() {
+				paused.Set(1)
+			} else {
+				paused.Set(0)
+			}
+		})
+	}
+
+	return fw
+}
+
+// publishForwarded publishes ev, about a message forwarded from store, to
+// events (if not nil).
+func publishForwarded(events *eventHub, store local_storage.Store, ev forwarder.Event) {
+	if events == nil {
+		return
+	}
+
+	var body message
+	json.Unmarshal([]byte(ev.Message.Body), &body)
+	pe := pipelineEvent{
+		Type: ev.Type.String(),
+		ID: ev.ID,
+		Channel: body.Channel,
+		RequestID: ev.Message.Attributes[requestIDAttr],
+		MessageID: ev.Result.MessageID,
+		Backlog: store.Count(),
+		Time: time.Now(),
+	}
+	if ev.Err != nil {
+		pe.Error = ev.Err.Error()
+	}
+	events.Publish(pe)
+}
@@ -0,0 +1,90 @@
+package notifier
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/SirGFM/sqs-issue-notifier/server/sender"
+	"github.com/SirGFM/sqs-issue-notifier/server/sendermw"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// issueSender files each message as an issue, through a GitHub sender,
+// writing the message's channel and request ID below its text.
+type issueSender struct {
+	// The sender that files the issues.
+	s sender.Sender
+}
+
+// newGitHubSender creates the sender for the "github" destination d. Its
+// token defaults to args.GitHubToken.
+func newGitHubSender(args Args, d Destination) (sender.Sender, error) {
+	token := d.Token
+	if len(token) == 0 {
+		token = args.GitHubToken
+	}
+
+	s, err := sender.NewGitHubSender(sender.GitHubOptions{
+		APIURL: d.Endpoint,
+		Token: token,
+		Repo: d.Repo,
+		Labels: d.Labels,
+		Timeout: destinationTimeout(d),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return issueSender{s: s}, nil
+}
+
+// issueText converts msg into the text of its issue. Bodies other than a
+// message (e.g., reshaped by MessageTemplateFile) are filed as they are.
+func issueText(msg sender.Message) (string, error) {
+	if _, ok := msg.Attributes[sendermw.EncryptionAttr]; ok {
+		return "", fmt.Errorf("%w: encrypted messages can't be filed as issues", sender.ErrRejected)
+	}
+
+	var body message
+	err := json.Unmarshal([]byte(msg.Body), &body)
+	if err != nil || len(body.Channel) == 0 {
+		return msg.Body, nil
+	}
+
+	text := body.Message
+	if len(body.Encoding) > 0 {
+		data, err := base64.StdEncoding.DecodeString(body.Message)
+		if body.Encoding != "base64" || err != nil || !utf8.Valid(data) {
+			return "", fmt.Errorf("%w: binary messages can't be filed as issues", sender.ErrRejected)
+		}
+		text = string(data)
+	}
+
+	var footer strings.Builder
+	fmt.Fprintf(&footer, "\n\n---\nChannel: `%s`", body.Channel)
+	if id := msg.Attributes[requestIDAttr]; len(id) > 0 {
+		fmt.Fprintf(&footer, "\nRequest ID: `%s`", id)
+	}
+	return text + footer.String(), nil
+}
+
+// Send files msg as an issue. Heartbeats only check that the repository is
+// accessible, so they don't file any issue.
+func (is issueSender) Send(msg sender.Message) (sender.SendResult, error) {
+	if _, ok := msg.Attributes[heartbeatAttr]; ok {
+		return sender.SendResult{SentAt: time.Now()}, is.Check()
+	}
+
+	text, err := issueText(msg)
+	if err != nil {
+		return sender.SendResult{}, err
+	}
+	msg.Body = text
+	return is.s.Send(msg)
+}
+
+// Check that the repository is accessible.
+func (is issueSender) Check() error {
+	return is.s.(sender.Checker).Check()
+}
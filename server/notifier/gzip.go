@@ -0,0 +1,125 @@
+package notifier
+
+import (
+	"bufio"
+	"compress/gzip"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// gzipResponseWriter compresses JSON responses with gzip. Whether the
+// response is compressed is only decided once its header is written, as
+// it depends on the response's Content-Type.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+
+	// Compresses the response. Nil if the response isn't compressed.
+	gz *gzip.Writer
+
+	// Whether the header was already written.
+	wroteHeader bool
+}
+
+func (gw *gzipResponseWriter) WriteHeader(status int) {
+	if gw.wroteHeader {
+		return
+	}
+	gw.wroteHeader = true
+
+	hdr := gw.Header()
+	if status != http.StatusNoContent && status != http.StatusNotModified &&
+			strings.HasPrefix(hdr.Get("Content-Type"), "application/json") &&
+			len(hdr.Get("Content-Encoding")) == 0 {
+		hdr.Set("Content-Encoding", "gzip")
+		hdr.Del("Content-Length")
+		gw.gz = gzip.NewWriter(gw.ResponseWriter)
+	}
+
+	gw.ResponseWriter.WriteHeader(status)
+}
+
+func (gw *gzipResponseWriter) Write(data []byte) (int, error) {
+	if !gw.wroteHeader {
+		gw.WriteHeader(http.StatusOK)
+	}
+
+	if gw.gz != nil {
+		return gw.gz.Write(data)
+	}
+	return gw.ResponseWriter.Write(data)
+}
+
+// Unwrap retrieves the wrapped http.ResponseWriter, for
+// http.ResponseController.
+func (gw *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return gw.ResponseWriter
+}
+
+// Hijack the connection (e.g., to upgrade it to a WebSocket).
+func (gw *gzipResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(gw.ResponseWriter).Hijack()
+}
+
+// Close flushes the compressed response, if any.
+func (gw *gzipResponseWriter) Close() error {
+	if gw.gz != nil {
+		return gw.gz.Close()
+	}
+	return nil
+}
+
+// acceptsGzip checks whether the client accepts gzip-encoded responses.
+func acceptsGzip(req *http.Request) bool {
+	for _, enc := range splitList(req.Header.Get("Accept-Encoding")) {
+		// Ignore the encoding's parameters (e.g., "gzip;q=0.8"), unless
+		// it's explicitly refused.
+		name, params, _ := strings.Cut(enc, ";")
+		if strings.TrimSpace(name) == "gzip" && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+// withGzip wraps next, decompressing gzip-encoded request bodies and
+// compressing JSON responses for clients that accept it. Request bodies
+// are limited to maxBody bytes, after being decompressed.
+func withGzip(next http.Handler, maxBody int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch enc := strings.TrimSpace(req.Header.Get("Content-Encoding")); enc {
+		case "", "identity":
+		case "gzip":
+			gz, err := gzip.NewReader(req.Body)
+			if err != nil {
+				reqLogger(req).Info("Invalid gzip body", "err", err)
+				httpTextReply(http.StatusBadRequest, "Invalid gzip body", w)
+				return
+			}
+			defer gz.Close()
+
+			req.Body = gz
+			req.ContentLength = -1
+			req.Header.Del("Content-Encoding")
+			req.Header.Del("Content-Length")
+		default:
+			reqLogger(req).Info("Unsupported Content-Encoding", "encoding", enc)
+			httpTextReply(http.StatusUnsupportedMediaType, "Unsupported Content-Encoding", w)
+			return
+		}
+		if maxBody > 0 {
+			req.Body = http.MaxBytesReader(w, req.Body, maxBody)
+		}
+
+		if !acceptsGzip(req) {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.Close()
+
+		next.ServeHTTP(gw, req)
+	})
+}
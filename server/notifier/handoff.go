@@ -0,0 +1,343 @@
+package notifier
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Environment variables that hand the listeners off to the upgraded
+// process. They aren't options, so they're never set by the user.
+const (
+	// Lists the inherited listeners, as "<addr>=<fd>,...".
+	envHandoffListeners = "SQSNOTIFIER_HANDOFF_LISTENERS"
+	// File descriptor of the pipe written to once the upgraded process is
+	// ready to take over.
+	envHandoffReadyFD = "SQSNOTIFIER_HANDOFF_READY_FD"
+)
+
+// handoffReady is written to the ready pipe by the upgraded process.
+const handoffReady = "ready"
+
+// How long the running process waits for the upgraded one to be ready.
+const handoffTimeout = 30 * time.Second
+
+// How long the running process waits, once it stops accepting connections,
+// for the ones already accepted to send their requests. Requests received
+// after it starts shutting down are dropped by net/http.
+const handoffAcceptGrace = time.Second
+
+// How long the running process waits for the requests being handled
+// before handing off.
+const handoffShutdownTimeout = 30 * time.Second
+
+// How long the upgraded process waits for the local storage to be
+// released by the previous one.
+const handoffLockTimeout = 2 * time.Minute
+
+// How often the upgraded process tries to lock the local storage.
+const handoffLockInterval = 100 * time.Millisecond
+
+// listeners keeps every listener opened by listen(), so they may be handed
+// off, along with the ones inherited from the previous process.
+var listeners struct {
+	// Synchronizes access to the other fields.
+	mutex sync.Mutex
+
+	// Every open listener, by its address.
+	open map[string]*handoffListener
+
+	// Listeners inherited from the previous process, by their address,
+	// until they're used. Nil until parsed from envHandoffListeners.
+	inherited map[string]*os.File
+}
+
+// handoffListener is a TCP listener that may stop accepting connections
+// without being closed, so they're left to the upgraded process.
+type handoffListener struct {
+	*net.TCPListener
+
+	// Whether connections are no longer accepted.
+	stopped atomic.Bool
+
+	// Closed once the listener is closed.
+	closed chan struct{}
+
+	// Closes the listener only once.
+	once sync.Once
+}
+
+// Accept waits for the next connection, blocking until the listener is
+// closed once it stops accepting connections.
+func (l *handoffListener) Accept() (net.Conn, error) {
+	c, err := l.TCPListener.Accept()
+	if ne, ok := err.(net.Error); ok && ne.Timeout() && l.stopped.Load() {
+		<-l.closed
+		return nil, net.ErrClosed
+	}
+	return c, err
+}
+
+// Close the listener. Connections waiting to be accepted are kept if the
+// listener was handed off.
+func (l *handoffListener) Close() error {
+	err := net.ErrClosed
+	l.once.Do(func() {
+		close(l.closed)
+		err = l.TCPListener.Close()
+	})
+	return err
+}
+
+// stopAccepting connections, so they're left in the listener's queue.
+func (l *handoffListener) stopAccepting() {
+	l.stopped.Store(true)
+	// Wake the pending Accept.
+	l.SetDeadline(time.Now())
+}
+
+// handedOff checks whether this process was started by upgrade(), taking
+// over the listeners of a running server.
+func handedOff() bool {
+	_, ok := os.LookupEnv(envHandoffListeners)
+	return ok
+}
+
+// inheritedListeners parses the listeners inherited from the previous
+// process. Must be called with listeners' mutex locked.
+func inheritedListeners() map[string]*os.File {
+	if listeners.inherited != nil {
+		return listeners.inherited
+	}
+
+	listeners.inherited = make(map[string]*os.File)
+	for _, item := range splitList(os.Getenv(envHandoffListeners)) {
+		addr, rawFD, ok := strings.Cut(item, "=")
+		fd, err := strconv.Atoi(rawFD)
+		if !ok || err != nil {
+			slog.Warn("Ignoring an invalid inherited listener", "listener", item)
+			continue
+		}
+		listeners.inherited[addr] = os.NewFile(uintptr(fd), addr)
+	}
+	return listeners.inherited
+}
+
+// listen for TCP connections on addr, reusing the listener inherited from
+// the previous process, if any. The listener is kept, so it may be handed
+// off to an upgraded process.
+func listen(addr string) (net.Listener, error) {
+	listeners.mutex.Lock()
+	defer listeners.mutex.Unlock()
+
+	var l net.Listener
+	var err error
+	if f, ok := inheritedListeners()[addr]; ok {
+		delete(listeners.inherited, addr)
+		l, err = net.FileListener(f)
+		f.Close()
+		if err == nil {
+			slog.Info("Inherited the listener", "addr", addr)
+		}
+	} else {
+		l, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tl, ok := l.(*net.TCPListener)
+	if !ok {
+		l.Close()
+		return nil, fmt.Errorf("'%s' isn't a TCP listener", addr)
+	}
+	hl := &handoffListener{
+		TCPListener: tl,
+		closed: make(chan struct{}),
+	}
+	if listeners.open == nil {
+		listeners.open = make(map[string]*handoffListener)
+	}
+	listeners.open[addr] = hl
+	return hl, nil
+}
+
+// stopAccepting connections on every listener, once handed off, so they're
+// accepted by the upgraded process. Connections already accepted are given
+// handoffAcceptGrace to send their requests.
+func stopAccepting() {
+	listeners.mutex.Lock()
+	for _, l := range listeners.open {
+		l.stopAccepting()
+	}
+	listeners.mutex.Unlock()
+
+	time.Sleep(handoffAcceptGrace)
+}
+
+// upgrade starts a new process from the binary on disk (which may have been
+// replaced), with the same arguments, handing off every listener to it. It
+// returns once the new process is ready to take over, so this one may stop
+// accepting connections and shut down gracefully. Connections received
+// meanwhile wait in the listeners' queues, so none is dropped.
+//
+// If the new process fails to start (e.g., its configuration is invalid),
+// an error is returned and this process should keep running.
+func upgrade() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	// The files are duplicates of the listeners, so closing them doesn't
+	// close the listeners.
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	} ()
+
+	// The child's file descriptors: stdin, stdout, stderr, the
+	// listeners and the ready pipe.
+	fds := []uintptr{os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd()}
+	var inherited []string
+
+	listeners.mutex.Lock()
+	for addr, l := range listeners.open {
+		f, err := l.File()
+		if err == nil {
+			files = append(files, f)
+			// f.Fd() would put the listener in blocking mode (as
+			// it's shared by the duplicate), so its Accept
+			// couldn't be interrupted.
+			err = rawFD(f, func(fd uintptr) {
+				inherited = append(inherited, fmt.Sprintf("%s=%d", addr, len(fds)))
+				fds = append(fds, fd)
+			})
+		}
+		if err != nil {
+			listeners.mutex.Unlock()
+			return fmt.Errorf("couldn't hand off '%s': %w", addr, err)
+		}
+	}
+	listeners.mutex.Unlock()
+
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+	readyFD := len(fds)
+	fds = append(fds, readyW.Fd())
+
+	var env []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		switch name {
+		case envHandoffListeners, envHandoffReadyFD:
+		case "WATCHDOG_PID":
+			// systemd's watchdog is meant for the new process, once
+			// it's the service's main process.
+		default:
+			env = append(env, kv)
+		}
+	}
+	env = append(env,
+		envHandoffListeners + "=" + strings.Join(inherited, ","),
+		envHandoffReadyFD + "=" + strconv.Itoa(readyFD),
+	)
+
+	// The process is started by syscall, instead of os/exec, as the
+	// latter would also put the listeners in blocking mode.
+	pid, err := syscall.ForkExec(exe, os.Args, &syscall.ProcAttr{
+		Env: env,
+		Files: fds,
+	})
+	readyW.Close()
+	if err != nil {
+		return err
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	slog.Info("Started the upgraded process", "pid", pid, "binary", exe)
+
+	// The pipe is closed without being written to if the new process
+	// exits.
+	done := make(chan error, 1)
+	go func() {
+		data, err := io.ReadAll(ready)
+		if err == nil && string(data) != handoffReady {
+			err = fmt.Errorf("the upgraded process exited before it was ready")
+		}
+		done <- err
+	} ()
+
+	select {
+	case err = <-done:
+	case <-time.After(handoffTimeout):
+		err = fmt.Errorf("the upgraded process wasn't ready within %s", handoffTimeout)
+	}
+	if err != nil {
+		proc.Kill()
+		go proc.Wait()
+		return err
+	}
+	return nil
+}
+
+// rawFD calls fn with f's file descriptor, without changing its mode (as
+// f.Fd() does).
+func rawFD(f *os.File, fn func(fd uintptr)) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	return rc.Control(fn)
+}
+
+// notifyHandoffReady tells the previous process, if this one was started by
+// upgrade(), that it's ready to take over (i.e., it only has to lock the
+// local storage).
+func notifyHandoffReady() {
+	raw, ok := os.LookupEnv(envHandoffReadyFD)
+	if !ok {
+		return
+	}
+
+	fd, err := strconv.Atoi(raw)
+	if err != nil {
+		slog.Warn("Invalid handoff pipe", "fd", raw)
+		return
+	}
+	f := os.NewFile(uintptr(fd), "handoff-ready")
+	defer f.Close()
+
+	_, err = f.WriteString(handoffReady)
+	if err != nil {
+		slog.Warn("Couldn't notify the previous process", "err", err)
+	}
+}
+
+// lockHandedOffStore locks the local storage once the previous process
+// releases it, waiting for up to handoffLockTimeout.
+func lockHandedOffStore(dir string) (*os.File, error) {
+	deadline := time.Now().Add(handoffLockTimeout)
+	for {
+		lock, err := lockStore(dir)
+		if err != errStoreInUse || time.Now().After(deadline) {
+			return lock, err
+		}
+		time.Sleep(handoffLockInterval)
+	}
+}
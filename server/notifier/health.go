@@ -0,0 +1,36 @@
+package notifier
+
+import (
+	"github.com/SirGFM/sqs-issue-notifier/server/health"
+	"github.com/SirGFM/sqs-issue-notifier/server/metrics"
+	"log/slog"
+	"time"
+)
+
+// newHealth creates the registry where the components report their health,
+// logging every change and exporting them through reg.
+func newHealth(reg *metrics.Registry) *health.Registry {
+	hr := health.NewRegistry()
+
+	hr.OnChange(func(r health.Report) {
+		switch r.Status {
+		case health.OK:
+			slog.Info("Component is healthy", "component", r.Component)
+		case health.Degraded:
+			slog.Warn("Component is degraded", "component", r.Component, "reason", r.Reason)
+		default:
+			slog.Error("Component failed", "component", r.Component, "reason", r.Reason)
+		}
+	})
+
+	status := reg.Gauge("sqsnotifier_health_status", "Health of each component: 0 if ok, 1 if degraded and 2 if failed.", "component")
+	since := reg.Gauge("sqsnotifier_health_status_seconds", "For how long each component has been on its current health status.", "component")
+	reg.OnCollect(func() {
+		for _, r := range hr.Reports() {
+			status.Set(float64(r.Status), r.Component)
+			since.Set(time.Since(r.Since).Seconds(), r.Component)
+		}
+	})
+
+	return hr
+}
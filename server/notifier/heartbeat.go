@@ -0,0 +1,164 @@
+package notifier
+
+import (
+	"encoding/json"
+	"github.com/SirGFM/sqs-issue-notifier/server/sender"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// heartbeatCheck verifies that the queue is reachable, without sending
+	// anything to it.
+	heartbeatCheck = "check"
+	// heartbeatMessage sends a synthetic message through the pipeline.
+	heartbeatMessage = "message"
+)
+
+// heartbeatAttr is the attribute set on heartbeat messages, so consumers
+// may tell them apart from actual messages.
+const heartbeatAttr = "Heartbeat"
+
+// heartbeatStatus describes the result of the latest heartbeats.
+type heartbeatStatus struct {
+	// How the heartbeat is done (either heartbeatCheck or
+	// heartbeatMessage).
+	Mode string
+
+	// When the latest heartbeat happened.
+	LastBeat time.Time
+
+	// When the latest heartbeat succeeded.
+	LastSuccess time.Time
+
+	// Why the latest heartbeat failed, if it did.
+	LastError string `json:",omitempty"`
+
+	// Number of consecutive failed heartbeats.
+	Failures int
+}
+
+// heartbeat periodically verifies that the pipeline is working, even while
+// no message is being sent, so a silently broken pipeline may be detected.
+type heartbeat struct {
+	// How the heartbeat is done.
+	mode string
+
+	// The pipeline being verified.
+	p pipeline
+
+	// Synchronizes access to status.
+	mutex sync.Mutex
+
+	// Result of the latest heartbeats.
+	status heartbeatStatus
+
+	// Closed to stop the heartbeat.
+	stop chan struct{}
+
+	// Ensures that the heartbeat is only stopped once.
+	closeOnce sync.Once
+}
+
+// startHeartbeat launches a goroutine that verifies the pipeline every
+// args.HeartbeatMinutes. It returns nil if the heartbeat is disabled.
+func startHeartbeat(args Args, p pipeline) *heartbeat {
+	if args.HeartbeatMinutes <= 0 {
+		return nil
+	}
+
+	mode := args.HeartbeatMode
+	if len(mode) == 0 {
+		mode = heartbeatCheck
+	} else if mode != heartbeatCheck && mode != heartbeatMessage {
+		slog.Warn("Invalid HeartbeatMode, using the default", "mode", mode, "default", heartbeatCheck)
+		mode = heartbeatCheck
+	}
+	if _, ok := p.base.(sender.Checker); mode == heartbeatCheck && !ok {
+		slog.Warn("The sender can't be checked, sending heartbeat messages instead")
+		mode = heartbeatMessage
+	}
+
+	hb := &heartbeat{
+		mode: mode,
+		p: p,
+		status: heartbeatStatus{
+			Mode: mode,
+		},
+		stop: make(chan struct{}),
+	}
+
+	interval := time.Duration(args.HeartbeatMinutes) * time.Minute
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-hb.stop:
+				return
+			case <-ticker.C:
+				hb.beat()
+			}
+		}
+	} ()
+
+	return hb
+}
+
+// beat verifies the pipeline once, recording the result.
+func (hb *heartbeat) beat() {
+	now := time.Now()
+
+	var err error
+	switch hb.mode {
+	case heartbeatCheck:
+		err = hb.p.base.(sender.Checker).Check()
+	case heartbeatMessage:
+		body, _ := json.Marshal(struct{ Heartbeat time.Time }{now})
+		_, err = hb.p.sender.Send(sender.Message{
+			Body: string(body),
+			Attributes: map[string]string{heartbeatAttr: "true"},
+		})
+	}
+
+	hb.mutex.Lock()
+	defer hb.mutex.Unlock()
+
+	hb.status.LastBeat = now
+	if err != nil {
+		slog.Warn("Heartbeat failed", "err", err)
+		hb.status.LastError = err.Error()
+		hb.status.Failures++
+	} else {
+		hb.status.LastSuccess = now
+		hb.status.LastError = ""
+		hb.status.Failures = 0
+	}
+}
+
+// Status retrieves the result of the latest heartbeats. ok is false if the
+// heartbeat is disabled.
+func (hb *heartbeat) Status() (status heartbeatStatus, ok bool) {
+	if hb == nil {
+		return heartbeatStatus{}, false
+	}
+
+	hb.mutex.Lock()
+	defer hb.mutex.Unlock()
+
+	return hb.status, true
+}
+
+// Close stops the heartbeat.
+func (hb *heartbeat) Close() error {
+	if hb == nil {
+		return nil
+	}
+
+	hb.closeOnce.Do(func() {
+		close(hb.stop)
+	})
+	return nil
+}
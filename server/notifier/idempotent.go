@@ -0,0 +1,130 @@
+package notifier
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/SirGFM/sqs-issue-notifier/server/idempotency"
+	"io"
+	"net/http"
+)
+
+// idempotencyHeader identifies a request, so retrying it is safe.
+const idempotencyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLen limits the size of idempotency keys.
+const maxIdempotencyKeyLen = 255
+
+// teeResponseWriter wraps a http.ResponseWriter, recording the response
+// as it's written.
+type teeResponseWriter struct {
+	http.ResponseWriter
+
+	// The recorded response.
+	resp idempotency.Response
+
+	// Whether the header was already written.
+	wroteHeader bool
+}
+
+func (tw *teeResponseWriter) WriteHeader(status int) {
+	if !tw.wroteHeader {
+		tw.wroteHeader = true
+		tw.resp.Status = status
+		tw.resp.Header = tw.Header().Clone()
+		// Replays are identified by their own request ID.
+		tw.resp.Header.Del(requestIDHeader)
+	}
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+func (tw *teeResponseWriter) Write(data []byte) (int, error) {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+	tw.resp.Body = append(tw.resp.Body, data...)
+	return tw.ResponseWriter.Write(data)
+}
+
+// withIdempotency wraps next, so requests with an Idempotency-Key are only
+// handled once by each client. Retries get the original response, as long
+// as it was successful. Otherwise, the request is handled again.
+func withIdempotency(c *idempotency.Cache, next endpointHandler) endpointHandler {
+	return func(w http.ResponseWriter, req *http.Request, res []string) {
+		key := req.Header.Get(idempotencyHeader)
+		if len(key) == 0 {
+			next(w, req, res)
+			return
+		} else if len(key) > maxIdempotencyKeyLen {
+			serr := fmt.Sprintf("The %s must have at most %d characters", idempotencyHeader, maxIdempotencyKeyLen)
+			httpTextReply(http.StatusBadRequest, serr, w)
+			reqLogger(req).Info(serr)
+			return
+		}
+
+		var tooLarge *http.MaxBytesError
+
+		body, err := io.ReadAll(req.Body)
+		if errors.As(err, &tooLarge) {
+			serr := fmt.Sprintf("The request body is larger than %d bytes", tooLarge.Limit)
+			httpTextReply(http.StatusRequestEntityTooLarge, serr, w)
+			reqLogger(req).Info(serr)
+			return
+		} else if err != nil {
+			serr := "Failed to read the request"
+			httpTextReply(http.StatusBadRequest, serr, w)
+			reqLogger(req).Error(serr, "err", err)
+			return
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+
+		// Keys are scoped to each client, and the request is identified
+		// by everything that changes how it's handled.
+		hash := sha256.New()
+		fmt.Fprintf(hash, "%s\x00%s\x00%s\x00", req.Method, req.URL.RequestURI(), req.Header.Get("Content-Type"))
+		hash.Write(body)
+		fingerprint := hex.EncodeToString(hash.Sum(nil))
+		scoped := clientKey(req) + "\x00" + key
+
+		resp, err := c.Begin(scoped, fingerprint)
+		if err == idempotency.ErrInFlight {
+			serr := fmt.Sprintf("A request with the same %s is still being handled", idempotencyHeader)
+			httpTextReply(http.StatusConflict, serr, w)
+			reqLogger(req).Info(serr, "key", key)
+			return
+		} else if err == idempotency.ErrMismatch {
+			serr := fmt.Sprintf("The %s was already used by a different request", idempotencyHeader)
+			httpTextReply(http.StatusUnprocessableEntity, serr, w)
+			reqLogger(req).Info(serr, "key", key)
+			return
+		} else if resp != nil {
+			reqLogger(req).Info("Replaying the original response", "key", key)
+			for name, values := range resp.Header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(resp.Status)
+			writeData(resp.Body, w)
+			return
+		}
+
+		// Unless the response is completed, the key is released (even if
+		// the handler panics), so the request may be retried.
+		completed := false
+		defer func() {
+			if !completed {
+				c.Abort(scoped)
+			}
+		} ()
+
+		tw := &teeResponseWriter{ResponseWriter: w}
+		next(tw, req, res)
+
+		if tw.resp.Status >= 200 && tw.resp.Status < 300 {
+			c.Complete(scoped, tw.resp)
+			completed = true
+		}
+	}
+}
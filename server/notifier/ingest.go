@@ -0,0 +1,385 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/SirGFM/sqs-issue-notifier/server/auth"
+	"github.com/SirGFM/sqs-issue-notifier/server/chanquota"
+	"github.com/SirGFM/sqs-issue-notifier/server/ipfilter"
+	"github.com/SirGFM/sqs-issue-notifier/server/local_storage"
+	"github.com/SirGFM/sqs-issue-notifier/server/msgschema"
+	"github.com/SirGFM/sqs-issue-notifier/server/notifierpb"
+	"github.com/SirGFM/sqs-issue-notifier/server/sender"
+	"golang.org/x/net/netutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"io"
+	"log/slog"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// ingestServer implements notifierpb.NotifierServer, storing the messages
+// received over gRPC in the same local storage as the HTTP API.
+type ingestServer struct {
+	notifierpb.UnimplementedNotifierServer
+
+	// The web server, which owns the local storage and the policies
+	// applied to every message (e.g., the channels' schemas and quotas).
+	srv *server
+}
+
+// startIngest launches the gRPC ingest API on args.GRPCPort. It's served
+// over TLS if the web server is (using the same certificate).
+func startIngest(args Args, srv *server) (*grpc.Server, error) {
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(srv.admitUnary),
+		grpc.StreamInterceptor(srv.admitStream),
+	}
+	if srv.httpServer.TLSConfig != nil {
+		cfg := srv.httpServer.TLSConfig.Clone()
+		cfg.NextProtos = []string{"h2"}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(cfg)))
+	}
+
+	gs := grpc.NewServer(opts...)
+	notifierpb.RegisterNotifierServer(gs, &ingestServer{srv: srv})
+
+	addr := fmt.Sprintf("%s:%d", args.IP, args.GRPCPort)
+	l, err := listen(addr)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't listen for gRPC requests on '%s': %w", addr, err)
+	}
+	if args.MaxConnections > 0 {
+		l = netutil.LimitListener(l, args.MaxConnections)
+	}
+
+	go func() {
+		slog.Info("Serving the gRPC ingest API", "addr", addr)
+		err := gs.Serve(l)
+		if err != nil && err != grpc.ErrServerStopped {
+			slog.Error("The gRPC server failed", "err", err)
+		}
+	} ()
+
+	return gs, nil
+}
+
+// callRequest builds an HTTP request describing the call on ctx (i.e., with
+// its peer's address and its metadata), so the policies that verify HTTP
+// requests (e.g., authenticators) may be applied to the call.
+func callRequest(ctx context.Context) *http.Request {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "/", nil)
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		req.RemoteAddr = p.Addr.String()
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, name := range []string{"authorization", "x-forwarded-for", "x-real-ip"} {
+		for _, value := range md.Get(name) {
+			req.Header.Add(name, value)
+		}
+	}
+	return req
+}
+
+// authenticateCall authenticates req, describing a call, with the server's
+// authenticator, from its "authorization" metadata, returning a context
+// with the client's principal.
+func (s *server) authenticateCall(req *http.Request) (context.Context, error) {
+	ctx := req.Context()
+	if s.auth == nil {
+		return ctx, nil
+	}
+
+	p, err := s.auth.Authenticate(req)
+	if err == auth.ErrNoCredentials {
+		return ctx, status.Error(codes.Unauthenticated, "Missing credentials")
+	} else if err != nil {
+		return ctx, status.Error(codes.Unauthenticated, "Invalid credentials")
+	}
+
+	return auth.WithPrincipal(ctx, p), nil
+}
+
+// rateLimitCall rejects a call (or a message of a stream) from the client
+// identified by key, if it exceeded its rate.
+func (s *server) rateLimitCall(key string) error {
+	limiter := s.limiter.Load()
+	if limiter == nil {
+		return nil
+	}
+
+	ok, retryAfter := limiter.Allow(key)
+	if !ok {
+		return status.Errorf(codes.ResourceExhausted, "Too many requests (retry after %s)", retryAfter.Round(time.Second))
+	}
+	return nil
+}
+
+// admitCall applies to the call on ctx the same policies applied to the
+// HTTP API's requests before they're handled: the IP filter (with the
+// client's address reported by the trusted proxies), authentication and
+// the limit of requests in flight. It returns the request describing the
+// call, whose context has the client's principal, and a function that
+// releases the call's slot once it's handled.
+func (s *server) admitCall(ctx context.Context) (*http.Request, func(), error) {
+	req := callRequest(ctx)
+	if len(s.trustedProxies) > 0 {
+		addr, err := ipfilter.ClientIP(req, s.trustedProxies)
+		if err == nil {
+			req.RemoteAddr = addr.String()
+		}
+	}
+
+	if s.ipFilter != nil {
+		addr, err := ipfilter.ClientIP(req, nil)
+		if err != nil || !s.ipFilter.Allows(addr) {
+			return nil, nil, status.Error(codes.PermissionDenied, "Forbidden")
+		}
+	}
+
+	ctx, err := s.authenticateCall(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	req = req.WithContext(ctx)
+
+	release := func() {}
+	if s.inFlight != nil {
+		select {
+		case s.inFlight <- struct{}{}:
+			release = func() { <-s.inFlight }
+		default:
+			return nil, nil, status.Error(codes.Unavailable, "Too many requests in flight")
+		}
+	}
+
+	return req, release, nil
+}
+
+// admitUnary rejects unary calls that the server's policies don't accept,
+// as done by admitCall, and those from clients that exceeded their rate.
+func (s *server) admitUnary(ctx context.Context, in any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	req, release, err := s.admitCall(ctx)
+	if err != nil {
+		slog.Info("Rejected a gRPC call", "method", info.FullMethod, "err", err)
+		return nil, err
+	}
+	defer release()
+
+	err = s.rateLimitCall(clientKey(req))
+	if err != nil {
+		slog.Info("Rejected a gRPC call", "method", info.FullMethod, "err", err)
+		return nil, err
+	}
+	return handler(req.Context(), in)
+}
+
+// admittedStream overrides the context of a grpc.ServerStream with the
+// authenticated one, and rate limits every message received on it.
+type admittedStream struct {
+	grpc.ServerStream
+
+	// The context with the client's principal.
+	ctx context.Context
+
+	// The server whose rate limit is applied.
+	srv *server
+
+	// Identifies the client, for rate limiting.
+	key string
+}
+
+func (as admittedStream) Context() context.Context {
+	return as.ctx
+}
+
+func (as admittedStream) RecvMsg(m any) error {
+	err := as.ServerStream.RecvMsg(m)
+	if err != nil {
+		return err
+	}
+	return as.srv.rateLimitCall(as.key)
+}
+
+// admitStream rejects streaming calls that the server's policies don't
+// accept, as done by admitCall, and rate limits every message received
+// on the accepted ones.
+func (s *server) admitStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	req, release, err := s.admitCall(ss.Context())
+	if err != nil {
+		slog.Info("Rejected a gRPC call", "method", info.FullMethod, "err", err)
+		return err
+	}
+	defer release()
+
+	return handler(srv, admittedStream{
+		ServerStream: ss,
+		ctx: req.Context(),
+		srv: s,
+		key: clientKey(req),
+	})
+}
+
+// Notify stores a single message.
+func (is *ingestServer) Notify(ctx context.Context, in *notifierpb.Message) (*notifierpb.Ack, error) {
+	return is.srv.ingest(ctx, in)
+}
+
+// NotifyBatch stores every message received on the stream, acknowledging
+// each one as soon as it's stored (or refused).
+func (is *ingestServer) NotifyBatch(stream notifierpb.Notifier_NotifyBatchServer) error {
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		ack, err := is.srv.ingest(stream.Context(), in)
+		if err != nil {
+			st := status.Convert(err)
+			ack = &notifierpb.Ack{
+				Code: uint32(st.Code()),
+				Error: st.Message(),
+			}
+		}
+
+		err = stream.Send(ack)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ingestPriority converts a notifierpb.Priority into the local storage's
+// priority.
+func ingestPriority(p notifierpb.Priority) (local_storage.Priority, error) {
+	switch p {
+	case notifierpb.Priority_PRIORITY_NORMAL:
+		return local_storage.PriorityNormal, nil
+	case notifierpb.Priority_PRIORITY_LOW:
+		return local_storage.PriorityLow, nil
+	case notifierpb.Priority_PRIORITY_HIGH:
+		return local_storage.PriorityHigh, nil
+	default:
+		return 0, local_storage.ErrInvalidPriority
+	}
+}
+
+// storedFromProto converts a notifierpb.Message into the message kept in
+// the local storage. It only fails if the message's priority is invalid.
+func storedFromProto(in *notifierpb.Message) (storedMessage, error) {
+	priority, err := ingestPriority(in.GetPriority())
+	if err != nil {
+		return storedMessage{}, err
+	}
+
+	return storedMessage{
+		message: message{
+			Channel: in.GetChannel(),
+			Message: in.GetMessage(),
+			Encoding: in.GetEncoding(),
+		},
+		DelaySeconds: in.GetDelaySeconds(),
+		Priority: priority,
+	}, nil
+}
+
+// ingest validates a message received over gRPC and keeps it in the local
+// storage, applying the same policies as PostMessage. Errors are returned
+// as gRPC statuses.
+func (s *server) ingest(ctx context.Context, in *notifierpb.Message) (*notifierpb.Ack, error) {
+	// Like X-Request-Id, clients may identify the call, so its retries
+	// are recognized as duplicates.
+	var id string
+	md, _ := metadata.FromIncomingContext(ctx)
+	if ids := md.Get("x-request-id"); len(ids) > 0 && validRequestID(ids[0]) {
+		id = ids[0]
+	} else {
+		id = newRequestID()
+	}
+	logger := slog.Default().With("request_id", id, "channel", in.GetChannel())
+
+	ctx, span := tracer.Start(ctx, "notifier.Notify")
+	defer span.End()
+
+	if s.draining.Load() {
+		logger.Info("Rejected a message: the server is shutting down")
+		return nil, status.Error(codes.Unavailable, "The server is shutting down")
+	}
+
+	msg, err := storedFromProto(in)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid priority")
+	}
+	msg.RequestID = id
+	if len(msg.Channel) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Missing channel")
+	}
+	if max := int64(sender.MaxDelay / time.Second); msg.DelaySeconds < 0 || msg.DelaySeconds > max {
+		return nil, status.Errorf(codes.InvalidArgument, "DelaySeconds must be between 0 and %d", max)
+	}
+
+	if p, ok := auth.FromContext(ctx); ok && !p.CanPost(msg.Channel) {
+		serr := fmt.Sprintf("Not allowed to post to '%s'", msg.Channel)
+		logger.Info(serr, "subject", p.Subject)
+		return nil, status.Error(codes.PermissionDenied, serr)
+	}
+
+	if s.schemas != nil {
+		err := s.schemas.Validate(msg.Channel, msg.Message)
+		if verr, ok := err.(*msgschema.Error); ok {
+			logger.Info("The message doesn't match the channel's schema", "err", err)
+			return nil, status.Errorf(codes.InvalidArgument, "The message doesn't match the schema of '%s': %s", msg.Channel, verr)
+		} else if err != nil {
+			logger.Error("Failed to validate the message", "err", err)
+			return nil, status.Error(codes.Internal, "Failed to validate the message")
+		}
+	}
+
+	_, err = s.admit(logger, msg.Channel)
+	if err == chanquota.ErrRateExceeded {
+		return nil, status.Errorf(codes.ResourceExhausted, "Too many messages sent to '%s'", msg.Channel)
+	} else if err == chanquota.ErrPendingExceeded {
+		return nil, status.Errorf(codes.ResourceExhausted, "Too many pending messages for '%s'", msg.Channel)
+	}
+
+	s.routes.Load().applyPriority(&msg)
+	msg.TraceContext = injectTrace(ctx)
+	data, err := json.Marshal(&msg)
+	if err != nil {
+		logger.Error("Failed to encode the message", "err", err)
+		return nil, status.Error(codes.Internal, "Failed to encode the message")
+	}
+
+	ack := &notifierpb.Ack{}
+	ack.Id, err = s.store.StorePriority(data, msg.Priority)
+	if err == local_storage.ErrDuplicatedStore {
+		logger.Info("The message was already stored", "id", ack.Id)
+		ack.Duplicate = true
+		s.recordDuplicate(ack.Id, msg)
+	} else if errors.Is(err, syscall.ENOSPC) {
+		logger.Error("The local storage is full", "err", err)
+		return nil, status.Error(codes.ResourceExhausted, "The local storage is full")
+	} else if err != nil {
+		logger.Error("Failed to store the message", "err", err)
+		return nil, status.Error(codes.Internal, "Failed to store the message")
+	}
+
+	position, err := s.store.Position(ack.Id)
+	if err == nil {
+		ack.Position = int32(position)
+	}
+
+	return ack, nil
+}
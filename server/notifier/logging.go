@@ -0,0 +1,203 @@
+package notifier
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/SirGFM/sqs-issue-notifier/server/loglevel"
+	"github.com/SirGFM/sqs-issue-notifier/server/logrotate"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// logText formats each log entry as key=value pairs.
+	logText = "text"
+	// logJSON formats each log entry as a JSON object.
+	logJSON = "json"
+)
+
+const (
+	// logStderr writes the log to the standard error.
+	logStderr = "stderr"
+	// logStdout writes the log to the standard output.
+	logStdout = "stdout"
+)
+
+// The components whose entries may be logged at their own level (see
+// LogLevels), as set in their loggers' loglevel.ComponentKey attribute.
+const (
+	// The web server (i.e., requests and replies).
+	componentWeb = "web"
+	// The local storage (local_storage).
+	componentStore = "store"
+	// The senders (sender and sendermw).
+	componentSender = "sender"
+	// The forwarder, which moves messages from the local storage to the
+	// senders.
+	componentForwarder = "forwarder"
+)
+
+// logComponents lists every component that may have its own level.
+var logComponents = []string{componentWeb, componentStore, componentSender, componentForwarder}
+
+// logHandler filters the server's log by the levels set in args.LogLevel
+// and args.LogLevels, so they may be changed when the configuration is
+// reloaded. It's set by newLogger.
+var logHandler *loglevel.Handler
+
+// logLevels parses the levels set in args.LogLevel and args.LogLevels.
+func logLevels(args Args) (loglevel.Levels, error) {
+	levels, err := loglevel.Parse(args.LogLevel, args.LogLevels)
+	if err != nil {
+		return levels, err
+	}
+
+	for name := range levels.Components {
+		if !slices.Contains(logComponents, name) {
+			return levels, fmt.Errorf("unknown component '%s' (expected one of %s)", name, strings.Join(logComponents, ", "))
+		}
+	}
+	return levels, nil
+}
+
+// logOutput retrieves where the log is written: either logStderr or
+// logStdout (args.LogOutput).
+func logOutput(args Args) io.Writer {
+	if args.LogOutput == logStdout {
+		return os.Stdout
+	}
+	return os.Stderr
+}
+
+// openLogFile opens args.LogFile, rotating it as set by the args.LogMax*
+// and args.LogRotateHours options.
+func openLogFile(args Args) (*logrotate.File, error) {
+	return logrotate.Open(args.LogFile, logrotate.Options{
+		MaxSize: int64(args.LogMaxSizeMB) << 20,
+		Period: time.Duration(args.LogRotateHours) * time.Hour,
+		MaxBackups: args.LogMaxBackups,
+		MaxAge: time.Duration(args.LogMaxAgeDays) * 24 * time.Hour,
+	})
+}
+
+// newLogger creates the server's logger, writing entries to w (e.g., the
+// one from logOutput), formatted as either logText or logJSON
+// (args.LogFormat), and filtered by their levels (args.LogLevel and
+// args.LogLevels).
+func newLogger(args Args, w io.Writer) *slog.Logger {
+	var h slog.Handler
+
+	// Entries are filtered by logHandler.
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	switch args.LogFormat {
+	case logJSON:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		h = slog.NewTextHandler(w, opts)
+	}
+
+	// Invalid levels are reported by validateArgs.
+	levels, err := logLevels(args)
+	if err != nil {
+		levels = loglevel.Levels{Default: slog.LevelInfo}
+	}
+	logHandler = loglevel.NewHandler(h, levels)
+
+	return slog.New(logHandler)
+}
+
+// componentLogger retrieves a logger for the entries of component (e.g.,
+// componentForwarder).
+func componentLogger(component string) *slog.Logger {
+	return slog.Default().With(loglevel.ComponentKey, component)
+}
+
+// fatal logs msg (with args, as in slog.Error) and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// reqLogger retrieves a logger that identifies req in every entry.
+func reqLogger(req *http.Request) *slog.Logger {
+	return componentLogger(componentWeb).With(
+		"request_id", requestID(req),
+		"method", req.Method,
+		"path", req.URL.Path,
+		"client", req.RemoteAddr,
+	)
+}
+
+// responseRecorder wraps a http.ResponseWriter, recording the response's
+// status and size.
+type responseRecorder struct {
+	http.ResponseWriter
+
+	// The response's status code.
+	status int
+
+	// Number of bytes written in the response's body.
+	bytes int
+
+	// Whether the header was already written.
+	wroteHeader bool
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if !rr.wroteHeader {
+		rr.status = status
+		rr.wroteHeader = true
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(data []byte) (int, error) {
+	rr.wroteHeader = true
+	n, err := rr.ResponseWriter.Write(data)
+	rr.bytes += n
+	return n, err
+}
+
+// Unwrap retrieves the wrapped http.ResponseWriter, for
+// http.ResponseController.
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
+// Hijack the connection (e.g., to upgrade it to a WebSocket).
+func (rr *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(rr.ResponseWriter).Hijack()
+	if err == nil {
+		rr.status = http.StatusSwitchingProtocols
+		rr.wroteHeader = true
+	}
+	return conn, brw, err
+}
+
+// accessLog wraps next, logging every request once it's replied. It also
+// assigns each request its ID (see withRequestID), so every entry logged
+// while handling the request may be traced back to it.
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{
+			ResponseWriter: w,
+			status: http.StatusOK,
+		}
+
+		req = withRequestID(rec, req)
+		next.ServeHTTP(rec, req)
+
+		reqLogger(req).Info("Request handled",
+			"status", rec.status,
+			"duration", time.Since(start),
+			"bytes", rec.bytes,
+		)
+	})
+}
@@ -0,0 +1,122 @@
+package notifier
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/SirGFM/sqs-issue-notifier/server/local_storage"
+	"github.com/SirGFM/sqs-issue-notifier/server/sender"
+	"github.com/SirGFM/sqs-issue-notifier/server/sendermw"
+	"strings"
+	"time"
+)
+
+// message is the body sent to the queue, as expected by the worker.
+type message struct {
+	// The channel that should receive the message.
+	Channel string
+
+	// The message itself.
+	Message string
+
+	// How Message is encoded, if it's not plain text (e.g., "base64").
+	Encoding string `json:",omitempty"`
+}
+
+// storedMessage is the format of messages kept in the local storage. Other
+// than the message itself, it keeps metadata used only while sending the
+// message.
+type storedMessage struct {
+	message
+
+	// Requested delivery delay, in seconds.
+	DelaySeconds int64 `json:",omitempty"`
+
+	// Priority of the message in the local storage, so urgent messages
+	// are sent before routine ones.
+	Priority local_storage.Priority `json:",omitempty"`
+
+	// ID of the request that received the message.
+	RequestID string `json:",omitempty"`
+
+	// Trace context of the request that received the message (e.g., its
+	// "traceparent"), so the message may be traced until it's delivered.
+	TraceContext map[string]string `json:",omitempty"`
+}
+
+// requestIDAttr is the attribute that carries the ID of the request that
+// received the message, so it may be traced end to end.
+const requestIDAttr = "RequestId"
+
+// decodeStored converts data retrieved from the local storage into a
+// message ready to be sent. Data that can't be decoded is forwarded as is.
+func decodeStored(data []byte) sender.Message {
+	var stored storedMessage
+
+	err := json.Unmarshal(data, &stored)
+	if err != nil {
+		return sender.Message{Body: string(data)}
+	}
+
+	body, err := json.Marshal(&stored.message)
+	if err != nil {
+		return sender.Message{Body: string(data)}
+	}
+
+	msg := sender.Message{
+		Body: string(body),
+		Delay: time.Duration(stored.DelaySeconds) * time.Second,
+	}
+	if len(stored.RequestID) > 0 {
+		msg.Attributes = map[string]string{requestIDAttr: stored.RequestID}
+	}
+	// Forward the trace context, so consumers may continue the trace.
+	for key, value := range stored.TraceContext {
+		if msg.Attributes == nil {
+			msg.Attributes = make(map[string]string)
+		}
+		msg.Attributes[key] = value
+	}
+
+	return msg
+}
+
+// errNotReplayable is returned for messages read back from a queue that
+// can't be replayed (e.g., because they were encrypted).
+var errNotReplayable = errors.New("the message can't be replayed")
+
+// storedFromSent converts a message sent by the server (as converted by
+// decodeStored), and then read back from the queue, into the format kept in
+// the local storage. Messages transformed before being sent (i.e.,
+// encrypted, split into chunks or rendered by a template) can't be
+// converted back, so they fail with errNotReplayable, as do heartbeats.
+//
+// The message's priority and delay aren't sent, so they're lost.
+func storedFromSent(msg sender.Message) (storedMessage, error) {
+	var stored storedMessage
+
+	if _, ok := msg.Attributes[heartbeatAttr]; ok {
+		return stored, fmt.Errorf("%w: it's a heartbeat", errNotReplayable)
+	} else if _, ok := msg.Attributes[sendermw.EncryptionAttr]; ok {
+		return stored, fmt.Errorf("%w: it's encrypted", errNotReplayable)
+	}
+
+	dec := json.NewDecoder(strings.NewReader(msg.Body))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&stored.message)
+	if err != nil || len(stored.Channel) == 0 {
+		return stored, fmt.Errorf("%w: its body isn't a message", errNotReplayable)
+	}
+
+	stored.RequestID = msg.Attributes[requestIDAttr]
+	for _, key := range tracePropagator.Fields() {
+		if value, ok := msg.Attributes[key]; ok {
+			if stored.TraceContext == nil {
+				stored.TraceContext = make(map[string]string)
+			}
+			stored.TraceContext[key] = value
+		}
+	}
+
+	return stored, nil
+}
@@ -0,0 +1,65 @@
+package notifier
+
+import (
+	"fmt"
+	"github.com/SirGFM/sqs-issue-notifier/server/metrics"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Where the metrics are exported (i.e., the values of MetricsSink).
+const (
+	// metricsPrometheus serves the metrics on the 'metrics' resource, to
+	// be scraped.
+	metricsPrometheus = "prometheus"
+	// metricsStatsD pushes the metrics to StatsD.
+	metricsStatsD = "statsd"
+	// metricsDogStatsD pushes the metrics to DogStatsD, with their labels
+	// as tags.
+	metricsDogStatsD = "dogstatsd"
+)
+
+// Prefixes of the metrics exported by each component.
+const (
+	storeMetricsPrefix = "sqsnotifier_store"
+	forwarderMetricsPrefix = "sqsnotifier_forwarder"
+)
+
+// GetMetrics handles GET requests on the 'metrics' resource, exporting the
+// local storage's and the forwarder's metrics in Prometheus' text format.
+func (s *server) GetMetrics(w http.ResponseWriter, req *http.Request, res []string) {
+	if len(res) > 1 {
+		httpTextReply(http.StatusNotFound, "Invalid resource", w)
+		return
+	}
+
+	w.Header().Set("Content-Type", metrics.ContentType)
+	w.WriteHeader(http.StatusOK)
+	err := s.metrics.WriteText(w)
+	if err != nil {
+		reqLogger(req).Warn("Failed to send the metrics", "err", err)
+	}
+}
+
+// startStatsD pushes the metrics in reg to StatsD, if so configured in args.
+// It returns a function that stops pushing them.
+func startStatsD(args Args, reg *metrics.Registry) (func(), error) {
+	if args.MetricsSink == metricsPrometheus {
+		return func() {}, nil
+	}
+
+	interval := time.Duration(args.StatsDIntervalS) * time.Second
+	statsd, err := metrics.NewStatsD(reg, args.StatsDAddr, args.MetricsSink == metricsDogStatsD, interval)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't connect to StatsD at '%s': %w", args.StatsDAddr, err)
+	}
+	slog.Info("Pushing the metrics to StatsD", "addr", args.StatsDAddr, "sink", args.MetricsSink, "interval", interval)
+
+	return func() {
+		err := statsd.Close()
+		if err != nil {
+			slog.Warn("Failed to push the metrics to StatsD", "err", err)
+		}
+	}, nil
+}
@@ -0,0 +1,225 @@
+package notifier
+
+import (
+	"fmt"
+	"github.com/SirGFM/sqs-issue-notifier/server/auth"
+	"github.com/SirGFM/sqs-issue-notifier/server/ipfilter"
+	"net/http"
+	"net/netip"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Middleware wraps an http.Handler, adding some behaviour to every request
+// (e.g., rejecting unauthenticated requests, or measuring every response).
+type Middleware func(next http.Handler) http.Handler
+
+// Use registers middleware to be run on every request, in the order they
+// were registered, after the middleware already registered. Must be called
+// before the server starts.
+func (s *server) Use(middleware ...Middleware) {
+	s.middleware = append(s.middleware, middleware...)
+}
+
+// chain wraps the server's router with every registered middleware, so
+// the first one registered is the first one to receive each request.
+func (s *server) chain() http.Handler {
+	return chainMiddleware(s, s.middleware)
+}
+
+// chainMiddleware wraps handler with every middleware, so the first one is
+// the first one to receive each request.
+func chainMiddleware(handler http.Handler, middleware []Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// authenticate rejects requests that the server's authenticator doesn't
+// accept, storing the principal of authenticated requests in their
+// context. Webhooks are authenticated by their handlers instead, and the
+// dashboard's page is public (its requests are authenticated).
+func (s *server) authenticate(next http.Handler) http.Handler {
+	return authenticateWith(s.auth)(next)
+}
+
+// authenticateWith creates a Middleware that authenticates requests with
+// a, as done by server.authenticate. If a is nil, every request is
+// accepted.
+func authenticateWith(a auth.Authenticator) Middleware {
+	return func(next http.Handler) http.Handler {
+		if a == nil {
+			return next
+		}
+		return authenticated(a, next)
+	}
+}
+
+// authenticated wraps next, so only requests accepted by a reach it.
+func authenticated(a auth.Authenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		res := strings.Split(cleanURL(req.URL), "/")
+		if res[0] == webhookResource || res[0] == dashboardResource || res[0] == readyzResource {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		p, err := a.Authenticate(req)
+		if err == auth.ErrKeysUnavailable {
+			httpTextReply(http.StatusServiceUnavailable, "Couldn't verify the credentials", w)
+			reqLogger(req).Warn("Couldn't verify the credentials", "err", err)
+			return
+		} else if err != nil {
+			challenge := `Bearer realm="sqs-issue-notifier"`
+			if c, ok := a.(auth.Challenger); ok && len(c.Challenge()) > 0 {
+				challenge = c.Challenge()
+			}
+			w.Header().Set("WWW-Authenticate", challenge)
+			httpTextReply(http.StatusUnauthorized, "Unauthorized", w)
+			reqLogger(req).Info("Unauthorized", "err", err)
+			return
+		}
+
+		req = req.WithContext(auth.WithPrincipal(req.Context(), p))
+		next.ServeHTTP(w, req)
+	})
+}
+
+// newIPFilter creates the filter of clients' addresses, along with the
+// trusted proxies, as configured by args. The filter is nil if every
+// client is accepted.
+func newIPFilter(args Args) (*ipfilter.Filter, []netip.Prefix, error) {
+	allow, err := ipfilter.ParsePrefixes(splitList(args.IPAllowList))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid IPAllowList: %w", err)
+	}
+	deny, err := ipfilter.ParsePrefixes(splitList(args.IPDenyList))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid IPDenyList: %w", err)
+	}
+	proxies, err := ipfilter.ParsePrefixes(splitList(args.TrustedProxies))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid TrustedProxies: %w", err)
+	}
+
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil, proxies, nil
+	}
+	return ipfilter.New(allow, deny), proxies, nil
+}
+
+// realClientIP replaces the address of requests that came through a trusted
+// proxy with the client's actual address, as reported by the proxy, so every
+// later middleware (and handler) sees the client instead of the proxy.
+func (s *server) realClientIP(next http.Handler) http.Handler {
+	if len(s.trustedProxies) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		addr, err := ipfilter.ClientIP(req, s.trustedProxies)
+		if err == nil && addr.String() != remoteHost(req) {
+			r := new(http.Request)
+			*r = *req
+			r.RemoteAddr = addr.String()
+			req = r
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// filterIPs rejects requests from clients whose address isn't accepted by
+// the server's IP filter. Clients behind a trusted proxy were already
+// identified by realClientIP.
+func (s *server) filterIPs(next http.Handler) http.Handler {
+	if s.ipFilter == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		addr, err := ipfilter.ClientIP(req, nil)
+		if err != nil || !s.ipFilter.Allows(addr) {
+			httpTextReply(http.StatusForbidden, "Forbidden", w)
+			reqLogger(req).Info("Rejected the client's address", "addr", addr, "err", err)
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// limitInFlight rejects POST requests while the server is already handling
+// as many POST requests as allowed, so a burst of clients can't exhaust the
+// server's memory and local storage.
+func (s *server) limitInFlight(next http.Handler) http.Handler {
+	if s.inFlight == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		select {
+		case s.inFlight <- struct{}{}:
+			defer func() { <-s.inFlight }()
+		default:
+			w.Header().Set("Retry-After", "1")
+			httpTextReply(http.StatusServiceUnavailable, "Too many requests in flight", w)
+			reqLogger(req).Warn("Rejected a request: too many requests in flight")
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// rateLimit rejects requests from clients that exceeded their rate.
+func (s *server) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		limiter := s.limiter.Load()
+		if limiter == nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		ok, retryAfter := limiter.Allow(clientKey(req))
+		if !ok {
+			secs := int64((retryAfter + time.Second - 1) / time.Second)
+			w.Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+			httpTextReply(http.StatusTooManyRequests, "Too many requests", w)
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// recoverPanics replies with 500 to requests whose handler (or any
+// middleware after recoverPanics) panicked, logging the panic, instead of
+// dropping the connection.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			} else if err == http.ErrAbortHandler {
+				// The handler asked for the connection to be dropped.
+				panic(err)
+			}
+
+			reqLogger(req).Error("The handler panicked", "err", err, "stack", string(debug.Stack()))
+			httpTextReply(http.StatusInternalServerError, "Internal server error", w)
+		} ()
+
+		next.ServeHTTP(w, req)
+	})
+}
+
@@ -0,0 +1,818 @@
+/*
+Package notifier implements the notifier's server: it receives messages
+over HTTP (and gRPC), keeps them in a local storage and forwards them to
+their destinations (e.g., an SQS queue).
+
+Besides being run from the command line (by "Main()"), the server may be
+embedded in another Go program by "Run()", configured through functional
+options. Any component not supplied by an option is created as configured
+in its Args (which default to "DefaultArgs()").
+
+Example:
+
+	args := notifier.DefaultArgs()
+	args.Port = 9999
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	err := notifier.Run(ctx,
+			notifier.WithArgs(args),
+			notifier.WithStore(local_storage.NewFS("some-dir", 0)),
+			notifier.WithSender(someSender))
+	if err != nil {
+		// handle err
+	}
+*/
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/SirGFM/sqs-issue-notifier/server/auth"
+	"github.com/SirGFM/sqs-issue-notifier/server/chanquota"
+	"github.com/SirGFM/sqs-issue-notifier/server/forwarder"
+	"github.com/SirGFM/sqs-issue-notifier/server/health"
+	"github.com/SirGFM/sqs-issue-notifier/server/local_storage"
+	"github.com/SirGFM/sqs-issue-notifier/server/metrics"
+	"github.com/SirGFM/sqs-issue-notifier/server/sender"
+	"github.com/SirGFM/sqs-issue-notifier/server/sendermw"
+	"github.com/SirGFM/sqs-issue-notifier/server/storemw"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// awsOptions configures how the AWS services are accessed, as configured in
+// args. If VaultAWSCredsPath is set, the base credentials are read from
+// Vault.
+func awsOptions(args Args) (sender.SQSOptions, error) {
+	opts := sender.SQSOptions{
+		Region: args.Region,
+		Profile: args.Profile,
+		HTTPTimeout: time.Duration(args.AWSTimeoutMS) * time.Millisecond,
+		WebIdentity: sender.WebIdentity{
+			TokenFile: args.WebIdentityTokenFile,
+			RoleARN: args.WebIdentityRoleARN,
+		},
+		AssumeRole: sender.AssumeRole{
+			RoleARN: args.AssumeRoleARN,
+			ExternalID: args.AssumeRoleExternalID,
+			SessionName: args.AssumeRoleSessionName,
+		},
+		CreateQueue: args.CreateQueue,
+		LargePayloadBucket: args.LargePayloadBucket,
+		LargePayloadThreshold: args.LargePayloadThreshold,
+	}
+
+	if len(args.VaultAWSCredsPath) > 0 {
+		creds, err := vaultAWSCredentials(args)
+		if err != nil {
+			return opts, err
+		}
+		opts.Credentials = creds
+	}
+	return opts, nil
+}
+
+// defaultDestination names the destination configured by the top-level
+// options (e.g., Queue), used when Destinations is empty.
+const defaultDestination = "default"
+
+// Types of destination, as set in Destination.Type.
+const (
+	destinationSQS = "sqs"
+	destinationGRPC = "grpc"
+	destinationDryRun = "dry-run"
+	destinationGitHub = "github"
+)
+
+// defaultDestinationTimeout is the timeout for each request made to a
+// "grpc" or "github" destination, unless its TimeoutMS is set.
+const defaultDestinationTimeout = 10 * time.Second
+
+// destinationTimeout retrieves the timeout for each request made to the
+// "grpc" or "github" destination d.
+func destinationTimeout(d Destination) time.Duration {
+	if d.TimeoutMS > 0 {
+		return time.Duration(d.TimeoutMS) * time.Millisecond
+	}
+	return defaultDestinationTimeout
+}
+
+// configuredDestinations retrieves the destinations configured in args. If
+// args.Destinations is empty, it's the single destination configured by the
+// top-level options (i.e., either Queue or CollectorAddr).
+func configuredDestinations(args Args) []Destination {
+	if len(args.Destinations) > 0 {
+		return args.Destinations
+	} else if len(args.CollectorAddr) > 0 {
+		return []Destination{{
+			Name: defaultDestination,
+			Type: destinationGRPC,
+			Endpoint: args.CollectorAddr,
+			TLS: args.CollectorTLS,
+			CAFile: args.CollectorCAFile,
+		}}
+	}
+	return []Destination{{
+		Name: defaultDestination,
+		Type: destinationSQS,
+		Endpoint: args.Endpoint,
+		Queue: args.Queue,
+	}}
+}
+
+// newDestinations creates the senders that deliver messages to each of
+// their destinations, as configured in args. If args.Destinations is empty,
+// the single destination configured by the top-level options is used
+// instead.
+func newDestinations(args Args) ([]sender.Destination, error) {
+	if args.DryRun {
+		slog.Warn("Running in dry-run mode! Messages won't be delivered")
+		s, err := sender.NewDryRunSender(args.DryRunFile)
+		if err != nil {
+			return nil, err
+		}
+		return []sender.Destination{{Name: destinationDryRun, Sender: s}}, nil
+	}
+
+	var ret []sender.Destination
+	for _, d := range configuredDestinations(args) {
+		s, err := newSender(args, d)
+		if err != nil {
+			return nil, fmt.Errorf("destination '%s': %w", d.Name, err)
+		}
+		ret = append(ret, sender.Destination{Name: d.Name, Sender: s})
+	}
+	return ret, nil
+}
+
+// newSender creates the sender that delivers messages to the destination
+// d. Options not set in d are copied from args.
+func newSender(args Args, d Destination) (sender.Sender, error) {
+	switch d.Type {
+	case destinationDryRun:
+		return sender.NewDryRunSender(d.File)
+	case destinationGitHub:
+		return newGitHubSender(args, d)
+	case destinationGRPC:
+		return sender.NewGRPCSender(d.Endpoint, sender.GRPCOptions{
+			TLS: d.TLS,
+			CAFile: d.CAFile,
+			Timeout: destinationTimeout(d),
+		})
+	case "", destinationSQS:
+	default:
+		return nil, fmt.Errorf("unknown destination type '%s'", d.Type)
+	}
+
+	opts, err := destinationOptions(args, d)
+	if err != nil {
+		return nil, err
+	}
+
+	sqs, err := sender.NewSQSSender(d.Endpoint, d.Queue, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// A missing queue is a configuration error, but an unreachable one
+	// may simply be temporary (and messages are kept locally meanwhile).
+	if checker, ok := sqs.(sender.Checker); ok {
+		err := checker.Check()
+		if errors.Is(err, sender.ErrNotFound) {
+			return nil, fmt.Errorf("the queue '%s' doesn't exist (create it or set CreateQueue): %w", d.Queue, err)
+		} else if err != nil {
+			slog.Warn("Couldn't verify the queue, messages will be kept locally until it's reachable", "queue", d.Queue, "err", err)
+		}
+	}
+
+	return sqs, nil
+}
+
+// destinationOptions configures how the SQS destination d is accessed.
+// Options not set in d are copied from args.
+func destinationOptions(args Args, d Destination) (sender.SQSOptions, error) {
+	opts, err := awsOptions(args)
+	if err != nil {
+		return opts, err
+	}
+	if len(d.Region) > 0 {
+		opts.Region = d.Region
+	}
+	if d.TimeoutMS > 0 {
+		opts.HTTPTimeout = time.Duration(d.TimeoutMS) * time.Millisecond
+	}
+	if len(d.Profile) > 0 {
+		opts.Profile = d.Profile
+	}
+	if len(d.AssumeRoleARN) > 0 {
+		opts.AssumeRole.RoleARN = d.AssumeRoleARN
+		opts.AssumeRole.ExternalID = d.AssumeRoleExternalID
+	}
+	opts.CreateQueue = opts.CreateQueue || d.CreateQueue
+	return opts, nil
+}
+
+// newAuthenticator creates the authenticator for the server's requests, as
+// configured in args. It returns nil if authentication is disabled.
+func newAuthenticator(args Args) (auth.Authenticator, error) {
+	if len(args.AuthJWKSURL) > 0 && len(args.AuthBasicUsers) > 0 {
+		return nil, fmt.Errorf("either AuthJWKSURL or AuthBasicUsers may be set, but not both")
+	} else if len(args.AuthBasicUsers) > 0 {
+		return newBasicAuthenticator(args)
+	} else if len(args.AuthJWKSURL) == 0 {
+		slog.Warn("Authentication is disabled! Anyone may post messages")
+		return nil, nil
+	}
+
+	return auth.NewJWT(auth.JWTOptions{
+		JWKSURL: args.AuthJWKSURL,
+		Issuer: args.AuthJWTIssuer,
+		Audience: args.AuthJWTAudience,
+		ChannelsClaim: args.AuthJWTChannelsClaim,
+		AdminClaim: args.AuthJWTAdminClaim,
+		CacheTTL: time.Duration(args.AuthJWKSCacheTTLS) * time.Second,
+	})
+}
+
+// newBasicAuthenticator creates an authenticator that accepts the users in
+// args.AuthBasicUsers, through HTTP Basic authentication.
+func newBasicAuthenticator(args Args) (auth.Authenticator, error) {
+	users := make(map[string]auth.BasicUser)
+	for _, entry := range splitList(args.AuthBasicUsers) {
+		username, hash, ok := strings.Cut(entry, ":")
+		if !ok || len(username) == 0 {
+			return nil, fmt.Errorf("invalid AuthBasicUsers entry (expected '<username>:<bcrypt hash>')")
+		}
+		users[username] = auth.BasicUser{PasswordHash: hash}
+	}
+
+	for _, username := range splitList(args.AuthBasicAdmins) {
+		u, ok := users[username]
+		if !ok {
+			return nil, fmt.Errorf("the admin '%s' isn't in AuthBasicUsers", username)
+		}
+		u.Admin = true
+		users[username] = u
+	}
+
+	return auth.NewBasic(users)
+}
+
+// pipeline is the chain of senders that forwards messages, along with the
+// components of the chain used elsewhere in the server.
+type pipeline struct {
+	// The sender at the top of the chain, used to send every message.
+	sender sender.Sender
+
+	// The sender at the bottom of the chain, that actually delivers the
+	// messages. If there are many destinations, it's a *sender.Fanout to
+	// each of them.
+	base sender.Sender
+
+	// The chain's circuit breaker. Nil if disabled.
+	breaker *sender.CircuitBreaker
+
+	// The policy every destination is retried with, replaced when the
+	// configuration is reloaded.
+	retry *atomic.Pointer[sendermw.RetryPolicy]
+
+	// Selects the destinations of each message. Nil if no route is
+	// configured.
+	router *routingSender
+}
+
+// retryPolicy creates the policy each destination is retried with, as
+// configured in args.
+func retryPolicy(args Args) sendermw.RetryPolicy {
+	return sendermw.RetryPolicy{
+		MaxAttempts: args.RetryMaxAttempts,
+		BaseDelay: time.Duration(args.RetryBaseDelayMS) * time.Millisecond,
+		MaxDelay: time.Duration(args.RetryMaxDelayMS) * time.Millisecond,
+		Jitter: args.RetryJitter,
+	}
+}
+
+// deliveryMiddleware creates the middleware applied to each destination on
+// its own, as configured in args, so every destination is retried (with
+// the policy in retry) and throttled independently of the others.
+func deliveryMiddleware(args Args, retry *atomic.Pointer[sendermw.RetryPolicy]) sendermw.Middleware {
+	// The rate limit is applied to each attempt, as each of them counts
+	// towards the SQS quota.
+	var rateLimit sendermw.Middleware
+	if args.SendRate > 0 {
+		rateLimit = sendermw.WithRateLimit(args.SendRate, args.SendBurst)
+	}
+	var throttle sendermw.Middleware
+	if args.AdaptiveThrottle {
+		throttle = sendermw.WithAdaptiveThrottle(sendermw.AIMDConfig{
+			MinRate: args.AdaptiveMinRate,
+			MaxRate: args.AdaptiveMaxRate,
+		})
+	}
+	retrying := sendermw.WithSwappableRetry(retry)
+
+	return func(s sender.Sender) sender.Sender {
+		return sendermw.Chain(s, rateLimit, throttle, retrying)
+	}
+}
+
+// newPipeline creates the chain of senders that forwards messages, as
+// configured in args, recording its metrics in stats and, if not nil, in
+// reg. If hr isn't nil, the sender's health is reported to it. If base
+// isn't nil, it delivers every message, instead of the destinations
+// configured in args.
+func newPipeline(args Args, base sender.Sender, stats *sendermw.Stats, reg *metrics.Registry, hr *health.Registry) (pipeline, error) {
+	var dests []sender.Destination
+	if base != nil {
+		dests = []sender.Destination{{Name: defaultDestination, Sender: base}}
+	} else {
+		var err error
+
+		dests, err = newDestinations(args)
+		if err != nil {
+			return pipeline{}, fmt.Errorf("couldn't create the sender: %w", err)
+		}
+	}
+
+	routes, err := newRouteTable(args)
+	if err != nil {
+		return pipeline{}, fmt.Errorf("couldn't load the routes: %w", err)
+	}
+	router := newRoutingSender(routes)
+
+	retry := new(atomic.Pointer[sendermw.RetryPolicy])
+	policy := retryPolicy(args)
+	retry.Store(&policy)
+
+	var sqs sender.Sender
+	if len(dests) == 1 && routes == nil {
+		base = dests[0].Sender
+		sqs = deliveryMiddleware(args, retry)(base)
+	} else {
+		delivery := make([]sender.Destination, len(dests))
+		for i, d := range dests {
+			delivery[i] = sender.Destination{
+				Name: d.Name,
+				Sender: deliveryMiddleware(args, retry)(d.Sender),
+			}
+		}
+		base = sender.NewFanout(dests...)
+		sqs = sender.NewFanout(delivery...)
+	}
+
+	var chunk sendermw.Middleware
+	if args.ChunkMessages && len(args.LargePayloadBucket) == 0 {
+		chunk = sendermw.WithChunking(sender.MaxSQSMessageSize)
+	}
+	// The template must be applied before chunking, so the chunks carry
+	// the transformed body.
+	var transform sendermw.Middleware
+	if len(args.MessageTemplateFile) > 0 {
+		text, err := os.ReadFile(args.MessageTemplateFile)
+		if err != nil {
+			return pipeline{}, fmt.Errorf("couldn't read the message template: %w", err)
+		}
+		tmpl, err := sendermw.ParseTemplate(string(text))
+		if err != nil {
+			return pipeline{}, fmt.Errorf("couldn't parse the message template: %w", err)
+		}
+		transform = sendermw.WithTemplate(tmpl)
+	}
+	// Messages are signed as sent (i.e., after being encrypted), but
+	// before being split into chunks.
+	var sign sendermw.Middleware
+	if len(args.SigningKey) > 0 {
+		sign = sendermw.WithSigning([]byte(args.SigningKey))
+	}
+	var encrypt sendermw.Middleware
+	if len(args.EncryptionKMSKeyID) > 0 {
+		opts, err := awsOptions(args)
+		if err != nil {
+			return pipeline{}, fmt.Errorf("couldn't configure the access to AWS: %w", err)
+		}
+		enc, err := sender.NewKMSEncrypter(args.Endpoint, args.EncryptionKMSKeyID, opts)
+		if err != nil {
+			return pipeline{}, fmt.Errorf("couldn't create the encrypter: %w", err)
+		}
+		encrypt = sendermw.WithEncryption(enc)
+	}
+	sqs = sendermw.Chain(sqs,
+		chunk,
+		sign,
+		encrypt,
+		transform,
+		router.wrap,
+	)
+
+	var breaker *sender.CircuitBreaker
+	if args.BreakerThreshold > 0 {
+		cooldown := time.Duration(args.BreakerCooldownMS) * time.Millisecond
+		breaker = sender.NewCircuitBreaker(sqs, args.BreakerThreshold, cooldown)
+		sqs = breaker
+	}
+
+	sqs = sendermw.WithMetrics(stats)(sqs)
+	if reg != nil {
+		sqs = sendermw.WithMetrics(sendermw.NewPrometheus(reg, forwarderMetricsPrefix))(sqs)
+	}
+	if hr != nil {
+		sqs = sendermw.WithHealth(hr, componentSender)(sqs)
+	}
+
+	return pipeline{
+		sender: sqs,
+		base: base,
+		breaker: breaker,
+		retry: retry,
+		router: router,
+	}, nil
+}
+
+// startStorage, exporting its metrics through reg and reporting its health
+// to hr, and launch a goroutine to forward requests through the pipeline.
+// If base isn't nil, it's used instead of the local storage in
+// args.LocalStore.
+func startStorage(args Args, base local_storage.Store, p pipeline, events *eventHub, quotas *chanquota.Quotas, reg *metrics.Registry, hr *health.Registry) (local_storage.Store, *forwarder.Forwarder) {
+	if base == nil {
+		timeout := time.Duration(args.TimeoutMS) * time.Millisecond
+		base = local_storage.NewFS(args.LocalStore, timeout)
+	}
+
+	store := storemw.Chain(base,
+		storemw.WithMetrics(reg, storeMetricsPrefix),
+		storemw.WithHealth(hr, componentStore),
+	)
+	events.Attach(store)
+	trackQuotas(quotas, store)
+	fw := startForwarder(args, store, p, events, reg, hr)
+
+	return store, fw
+}
+
+// errStoreInUse is returned by lockStore if another process is using the
+// local storage.
+var errStoreInUse = errors.New("the local storage is in use by another process")
+
+// lockStore locks the local storage's directory (creating it, if needed),
+// so it's only used by a single process at once (e.g., so a command doesn't
+// change the files of a running server). The lock is released once the
+// returned file is closed, or the process exits.
+func lockStore(dir string) (*os.File, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX | syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		f.Close()
+		return nil, errStoreInUse
+	} else if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// drain the backlog before exiting, for up to timeout, rejecting new
+// messages meanwhile. A signal received on abort stops draining immediately.
+func drain(srv *server, store local_storage.Store, fw *forwarder.Forwarder, timeout time.Duration, abort <-chan os.Signal) {
+	srv.Drain()
+	slog.Info("Draining the backlog before exiting...", "backlog", store.Count(), "timeout", timeout)
+
+	done := make(chan forwarder.DrainResult, 1)
+	go func() {
+		done <- fw.Drain(timeout)
+	} ()
+
+	select {
+	case res := <-done:
+		if res.Drained {
+			slog.Info("Drained the backlog", "sent", res.Sent)
+		} else {
+			slog.Warn("Couldn't drain the backlog, it's kept until the next start", "sent", res.Sent, "remaining", res.Remaining)
+		}
+	case <-abort:
+		slog.Warn("Stopped draining the backlog, it's kept until the next start", "remaining", store.Count())
+	}
+}
+
+// service is every component of a running server.
+type service struct {
+	// The options the server was started with.
+	args Args
+
+	// The web server.
+	srv *server
+
+	// The local storage.
+	store local_storage.Store
+
+	// Whether the local storage was supplied by the caller (through
+	// WithStore), in which case it's not closed along with the server.
+	ownStore bool
+
+	// Locks the local storage's directory. Nil if the local storage was
+	// supplied by the caller.
+	lock *os.File
+
+	// Forwards the messages in the local storage.
+	fw *forwarder.Forwarder
+
+	// Publishes the pipeline's events.
+	events *eventHub
+
+	// The audit log of the pipeline's events. Nil if disabled.
+	audit *auditLog
+
+	// Checks the destinations periodically. Nil if disabled.
+	hb *heartbeat
+
+	// Wakes the forwarder if it stalls. Nil if disabled.
+	wd *watchdog
+
+	// Raises alerts. Nil if disabled.
+	al *alerter
+
+	// Limits each channel's messages. Nil if no channel is limited.
+	quotas *chanquota.Quotas
+
+	// Authenticates the requests, replaced when the configuration is
+	// reloaded. Nil if authentication is disabled.
+	auth *auth.Swappable
+
+	// Routes the forwarded messages, replaced when the configuration is
+	// reloaded. Nil if no route is configured.
+	router *routingSender
+
+	// The pipeline's circuit breaker. Nil if disabled.
+	breaker *sender.CircuitBreaker
+
+	// The policy every destination is retried with, replaced when the
+	// configuration is reloaded.
+	retry *atomic.Pointer[sendermw.RetryPolicy]
+
+	// Statistics of the messages forwarded.
+	stats sendermw.Stats
+
+	// Stops pushing the metrics to StatsD.
+	stopStatsD func()
+
+	// Flushes the pending spans and stops exporting them. Nil if tracing
+	// is disabled.
+	shutdownTracing func(context.Context) error
+}
+
+// startService starts every component of the server, as configured in o,
+// stopping whatever was started if any of them fails.
+func startService(o options) (_ *service, err error) {
+	args := o.args
+	checked := args
+	if o.sender != nil {
+		// The destinations in args aren't used, so they needn't be
+		// set.
+		checked.DryRun = true
+	}
+	if errs := validateArgs(checked); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+
+	svc := &service{
+		args: args,
+		ownStore: o.store == nil,
+		stopStatsD: func() {},
+	}
+	defer func() {
+		if err != nil {
+			svc.close()
+		}
+	} ()
+
+	svc.shutdownTracing, err = setupTracing(args)
+	if err != nil {
+		return nil, err
+	}
+
+	reg := o.metrics
+	if reg == nil {
+		reg = metrics.NewRegistry()
+	}
+	hr := newHealth(reg)
+	p, err := newPipeline(args, o.sender, &svc.stats, reg, hr)
+	if err != nil {
+		return nil, err
+	}
+	svc.al, err = newAlerter(args)
+	if err != nil {
+		return nil, err
+	}
+	p.sender = svc.al.watch(p.sender)
+	svc.router = p.router
+	svc.breaker = p.breaker
+	svc.retry = p.retry
+	svc.audit, err = openAuditLog(args)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open the audit log '%s': %w", args.AuditLogFile, err)
+	}
+	svc.events = newEventHub(svc.audit)
+	svc.quotas, err = newChannelQuotas(args)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := newAuthenticator(args)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create the authenticator: %w", err)
+	}
+	if a != nil {
+		// The authenticator is replaced when the configuration is
+		// reloaded.
+		svc.auth = auth.NewSwappable(a)
+		a = svc.auth
+	}
+
+	if o.store == nil {
+		if handedOff() {
+			// The previous process keeps serving until this one
+			// is ready, releasing the local storage once it exits.
+			notifyHandoffReady()
+			svc.lock, err = lockHandedOffStore(args.LocalStore)
+		} else {
+			svc.lock, err = lockStore(args.LocalStore)
+		}
+		if err == errStoreInUse {
+			return nil, fmt.Errorf("the local storage '%s' is in use (is another server running?)", args.LocalStore)
+		} else if err != nil {
+			return nil, fmt.Errorf("couldn't lock the local storage '%s': %w", args.LocalStore, err)
+		}
+	}
+
+	svc.store, svc.fw = startStorage(args, o.store, p, svc.events, svc.quotas, reg, hr)
+	svc.hb = startHeartbeat(args, p)
+	svc.wd = startWatchdog(args, svc.store, svc.fw, p.breaker, reg)
+	svc.al.start(svc.store, time.Duration(args.AlertIntervalS) * time.Second)
+	svc.stopStatsD, err = startStatsD(args, reg)
+	if err != nil {
+		svc.stopStatsD = func() {}
+		return nil, err
+	}
+
+	svc.srv, err = runWeb(args, svc.store, svc.fw, svc.events, svc.hb, svc.quotas, a, reg, hr, o.middleware)
+	if err != nil {
+		return nil, err
+	}
+
+	return svc, nil
+}
+
+// stop the server, draining the backlog first if so configured. A signal
+// received on abort stops draining immediately.
+func (svc *service) stop(abort <-chan os.Signal) {
+	if svc.args.DrainOnExitS > 0 {
+		drain(svc.srv, svc.store, svc.fw, time.Duration(svc.args.DrainOnExitS) * time.Second, abort)
+	}
+	svc.close()
+	svc.logStats()
+}
+
+// handOff stops the server gracefully, keeping the backlog for the
+// upgraded process.
+func (svc *service) handOff() {
+	stopAccepting()
+	ctx, cancel := context.WithTimeout(context.Background(), handoffShutdownTimeout)
+	svc.srv.Shutdown(ctx)
+	cancel()
+	svc.close()
+	svc.logStats()
+}
+
+// close every component, stopping intake first. Components that weren't
+// started are skipped.
+func (svc *service) close() {
+	if svc.srv != nil {
+		svc.srv.Close()
+	}
+	// Then, whatever uses the local storage is stopped (the forwarder
+	// last, so it may finish the messages being sent), and only then is
+	// the local storage closed, along with the audit log of its events.
+	svc.events.Close()
+	svc.hb.Close()
+	svc.wd.Close()
+	svc.al.Close()
+	svc.stopStatsD()
+	if svc.fw != nil {
+		svc.fw.Stop()
+	}
+	if svc.store != nil && svc.ownStore {
+		svc.store.Close()
+	}
+	if svc.lock != nil {
+		svc.lock.Close()
+	}
+	svc.audit.Close()
+	if svc.shutdownTracing != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+		svc.shutdownTracing(ctx)
+		cancel()
+	}
+}
+
+// logStats logs the statistics of the messages forwarded since the server
+// started.
+func (svc *service) logStats() {
+	snap := svc.stats.Snapshot()
+	slog.Info("Done",
+			"sent", snap.Sent,
+			"rejected", snap.Rejected,
+			"failed", snap.Failed,
+			"duration", snap.TotalDuration,
+	)
+}
+
+// startServer with the options in args and configure its signal handler.
+func startServer(args Args) {
+	requireValidArgs(args)
+	svc, err := startService(options{args: args})
+	if err != nil {
+		fatal("Couldn't start the server", "err", err)
+	}
+
+	intHndlr := make(chan os.Signal, 1)
+	signal.Notify(intHndlr, os.Interrupt, syscall.SIGTERM)
+	hupHndlr := make(chan os.Signal, 1)
+	signal.Notify(hupHndlr, syscall.SIGHUP)
+	upgradeHndlr := make(chan os.Signal, 1)
+	signal.Notify(upgradeHndlr, syscall.SIGUSR2)
+
+	stopNotifying := notifySystemd(args, svc.fw)
+	r := reloader{
+		args: args,
+		srv: svc.srv,
+		quotas: svc.quotas,
+		auth: svc.auth,
+		router: svc.router,
+		store: svc.store,
+		fw: svc.fw,
+		breaker: svc.breaker,
+		retry: svc.retry,
+	}
+
+	upgraded := false
+	for waiting := true; waiting; {
+		select {
+		case <-hupHndlr:
+			slog.Info("Reloading the configuration...")
+			r.reload()
+		case <-upgradeHndlr:
+			slog.Info("Upgrading the server...")
+			err := upgrade()
+			if err != nil {
+				slog.Error("Couldn't upgrade the server, so it keeps running", "err", err)
+				continue
+			}
+			upgraded = true
+			waiting = false
+		case <-intHndlr:
+			waiting = false
+		}
+	}
+	if upgraded {
+		// The backlog is kept for the upgraded process.
+		slog.Info("Handing off to the upgraded process...")
+		stopNotifying(false)
+		svc.handOff()
+	} else {
+		slog.Info("Exiting...")
+		stopNotifying(true)
+		svc.stop(intHndlr)
+	}
+}
+
+// Main runs the notifier's command line: either the server or one of its
+// commands, as selected by the process' arguments.
+func Main() {
+	log.SetFlags(log.Lshortfile | log.Ldate | log.Ltime)
+
+	defer func() {
+		if r := recover(); r != nil {
+			fatal("Application panicked!", "panic", r)
+		}
+	} ()
+
+	runCommand()
+}
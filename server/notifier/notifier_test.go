@@ -0,0 +1,2993 @@
+package notifier
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/SirGFM/sqs-issue-notifier/server/auth"
+	"github.com/SirGFM/sqs-issue-notifier/server/client"
+	"github.com/SirGFM/sqs-issue-notifier/server/forwarder"
+	"github.com/SirGFM/sqs-issue-notifier/server/idempotency"
+	"github.com/SirGFM/sqs-issue-notifier/server/local_storage"
+	"github.com/SirGFM/sqs-issue-notifier/server/loglevel"
+	"github.com/SirGFM/sqs-issue-notifier/server/notifierpb"
+	"github.com/SirGFM/sqs-issue-notifier/server/sender"
+	"github.com/SirGFM/sqs-issue-notifier/server/sendermw"
+	"github.com/SirGFM/sqs-issue-notifier/server/sendertest"
+	"github.com/vmihailenco/msgpack/v5"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// freePort retrieves a free port on the loopback interface.
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: Couldn't find a free port: %+v", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// testArgs retrieves the default options, serving on a free port of the
+// loopback interface and keeping the messages in a temporary directory.
+func testArgs(t *testing.T) Args {
+	args := DefaultArgs()
+	args.IP = "127.0.0.1"
+	args.Port = freePort(t)
+	args.LocalStore = t.TempDir()
+	return args
+}
+
+// TestRun checks that an embedded server receives messages and forwards them
+// through the supplied sender, until its context is done.
+func TestRun(t *testing.T) {
+	args := testArgs(t)
+	store := local_storage.NewFS(t.TempDir(), 0)
+	defer store.Close()
+	s := sendertest.New()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- Run(ctx, WithArgs(args), WithStore(store), WithSender(s))
+	} ()
+
+	c, err := client.New(fmt.Sprintf("http://%s:%d", args.IP, args.Port), client.WithRetry(client.RetryPolicy{Attempts: 1}))
+	if err != nil {
+		t.Fatalf("New: Failed to create the client: %+v", err)
+	}
+	defer c.Close()
+
+	// Wait for the server to start listening.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		_, err = c.Notify(ctx, "general", "Calloo! Callay!")
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Notify: Failed to post the message: %+v", err)
+	}
+
+	if !s.WaitFor(1, 2 * time.Second) {
+		t.Fatalf("Send: The message wasn't forwarded")
+	} else if msg := s.Messages()[0]; msg.Body != `{"Channel":"general","Message":"Calloo! Callay!"}` {
+		t.Errorf("Send: Unexpected message '%s'", msg.Body)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run: Expected to stop cleanly but got %+v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Run: The server didn't stop")
+	}
+
+	// The supplied store is left open.
+	if err := store.Store([]byte("O frabjous day!")); err != nil {
+		t.Errorf("Store: Expected the store to be left open but got %+v", err)
+	}
+	_, err = c.Notify(context.Background(), "general", "He chortled in his joy.")
+	if !errors.Is(err, client.ErrUnreachable) {
+		t.Errorf("Notify: Expected the server to be stopped but got %+v", err)
+	}
+}
+
+// TestRunFailure checks that Run fails, instead of exiting, if the server
+// can't be started.
+func TestRunFailure(t *testing.T) {
+	args := testArgs(t)
+	args.Port = -1
+	if err := Run(context.Background(), WithArgs(args), WithSender(sendertest.New())); err == nil {
+		t.Errorf("Run: Expected an invalid configuration to fail")
+	}
+
+	args = testArgs(t)
+	l, err := net.Listen("tcp", fmt.Sprintf("%s:%d", args.IP, args.Port))
+	if err != nil {
+		t.Fatalf("Listen: Couldn't listen on the server's address: %+v", err)
+	}
+	defer l.Close()
+	if err := Run(context.Background(), WithArgs(args), WithSender(sendertest.New())); err == nil {
+		t.Errorf("Run: Expected an address in use to fail")
+	}
+
+	// The local storage was released, so the server may be started again.
+	l.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Run(ctx, WithArgs(args), WithSender(sendertest.New())); err != nil {
+		t.Errorf("Run: Expected the server to start once its address is free but got %+v", err)
+	}
+}
+
+// TestIngestPolicies checks that the gRPC API applies the same policies as
+// the HTTP API (the IP filter and the client's rate), both to unary and to
+// streaming calls.
+func TestIngestPolicies(t *testing.T) {
+	test_cases := []struct{
+		// Configures the server.
+		config func(args *Args)
+
+		// Whether the second message is sent through a stream.
+		stream bool
+
+		// Expected code of the first and of the second messages.
+		want [2]codes.Code
+	}{
+		{ config: func(args *Args) {}, want: [2]codes.Code{codes.OK, codes.OK} },
+		{ config: func(args *Args) { args.IPDenyList = "127.0.0.0/8" }, want: [2]codes.Code{codes.PermissionDenied, codes.PermissionDenied} },
+		{ config: func(args *Args) { args.IPAllowList = "10.0.0.0/8" }, want: [2]codes.Code{codes.PermissionDenied, codes.PermissionDenied} },
+		{ config: func(args *Args) { args.ClientRate, args.ClientBurst = 0.001, 1 }, want: [2]codes.Code{codes.OK, codes.ResourceExhausted} },
+		{ config: func(args *Args) { args.ClientRate, args.ClientBurst = 0.001, 1 }, stream: true, want: [2]codes.Code{codes.OK, codes.ResourceExhausted} },
+	}
+	for i, tc := range test_cases {
+		args := testArgs(t)
+		args.GRPCPort = testArgs(t).Port
+		tc.config(&args)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- Run(ctx, WithArgs(args), WithSender(sendertest.New()))
+		} ()
+
+		conn, err := grpc.NewClient(fmt.Sprintf("%s:%d", args.IP, args.GRPCPort), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			t.Fatalf("%d: NewClient: Failed to create the client: %+v", i, err)
+		}
+		c := notifierpb.NewNotifierClient(conn)
+		msg := &notifierpb.Message{Channel: "general", Message: "Calloo! Callay!"}
+
+		// Wait for the server to start listening.
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			_, err = c.Notify(ctx, msg)
+			if status.Code(err) != codes.Unavailable || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if got := status.Code(err); got != tc.want[0] {
+			t.Errorf("%d: Notify: Expected '%s' but got '%s' (%+v)", i, tc.want[0], got, err)
+		}
+
+		if tc.stream {
+			var stream notifierpb.Notifier_NotifyBatchClient
+			stream, err = c.NotifyBatch(ctx)
+			if err == nil {
+				err = stream.Send(msg)
+			}
+			if err == nil {
+				_, err = stream.Recv()
+			}
+		} else {
+			_, err = c.Notify(ctx, msg)
+		}
+		if got := status.Code(err); got != tc.want[1] {
+			t.Errorf("%d: Notify: Expected '%s' but got '%s' (%+v)", i, tc.want[1], got, err)
+		}
+
+		conn.Close()
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("%d: Run: Expected to stop cleanly but got %+v", i, err)
+		}
+	}
+}
+
+// TestIdempotency checks that retries of successful requests get the
+// original response (with their own request ID), and that requests that
+// failed (even by panicking) may be retried.
+func TestIdempotency(t *testing.T) {
+	calls := 0
+	handler := withIdempotency(idempotency.New(time.Minute), func(w http.ResponseWriter, req *http.Request, res []string) {
+		calls++
+		body, _ := io.ReadAll(req.Body)
+		switch string(body) {
+		case "panic":
+			panic("The Jabberwock, with eyes of flame")
+		case "fail":
+			httpTextReply(http.StatusInternalServerError, "Internal server error", w)
+		default:
+			w.Header().Set("Location", "/message/" + string(body))
+			httpTextReply(http.StatusOK, string(body), w)
+		}
+	})
+
+	test_cases := []struct{
+		key string
+		body string
+		status int
+		calls int
+		replayed bool
+	}{
+		{ key: "a", body: "burbled", status: http.StatusOK, calls: 1 },
+		{ key: "a", body: "burbled", status: http.StatusOK, calls: 1, replayed: true },
+		{ key: "a", body: "whiffling", status: http.StatusUnprocessableEntity, calls: 1 },
+		{ key: "b", body: "fail", status: http.StatusInternalServerError, calls: 2 },
+		{ key: "b", body: "fail", status: http.StatusInternalServerError, calls: 3 },
+		{ key: "c", body: "panic", calls: 4 },
+		{ key: "c", body: "panic", calls: 5 },
+		{ key: "", body: "burbled", status: http.StatusOK, calls: 6 },
+	}
+	for i, tc := range test_cases {
+		req := httptest.NewRequest(http.MethodPost, "/message/general", strings.NewReader(tc.body))
+		if len(tc.key) > 0 {
+			req.Header.Set(idempotencyHeader, tc.key)
+		}
+		w := httptest.NewRecorder()
+		id := fmt.Sprintf("req-%d", i)
+		w.Header().Set(requestIDHeader, id)
+
+		panicked := func() (panicked bool) {
+			defer func() {
+				panicked = recover() != nil
+			} ()
+			handler(w, req, nil)
+			return false
+		} ()
+
+		if tc.body == "panic" {
+			if !panicked {
+				t.Errorf("%d: Expected the handler to panic", i)
+			}
+		} else if w.Code != tc.status {
+			t.Errorf("%d: Expected the status %d but got %d", i, tc.status, w.Code)
+		}
+		if calls != tc.calls {
+			t.Errorf("%d: Expected the handler to be called %d times but got %d", i, tc.calls, calls)
+		}
+		if replayed := w.Header().Get("Idempotent-Replayed") == "true"; replayed != tc.replayed {
+			t.Errorf("%d: Expected the response to be replayed (%t) but got %t", i, tc.replayed, replayed)
+		}
+		if got := w.Header().Get(requestIDHeader); got != id {
+			t.Errorf("%d: Expected the request ID '%s' but got '%s'", i, id, got)
+		}
+		if tc.replayed && (w.Body.String() != tc.body || w.Header().Get("Location") != "/message/" + tc.body) {
+			t.Errorf("%d: Expected the original response but got '%s' (%+v)", i, w.Body.String(), w.Header())
+		}
+	}
+}
+
+// FuzzServeHTTP checks that every request is routed to a known resource,
+// and that the path handed to its handler is clean (i.e., it can't be used
+// to escape the resource).
+func FuzzServeHTTP(f *testing.F) {
+	f.Add(http.MethodGet, "/message")
+	f.Add(http.MethodDelete, "/message/2024-01-02-03-04-05-abc")
+	f.Add(http.MethodPost, "/message/2024-01-02-03-04-05-abc/requeue")
+	f.Add(http.MethodGet, "/../../etc/passwd")
+	f.Add(http.MethodGet, "//message//./x/")
+	f.Add(http.MethodGet, "/message/..%2f..%2fetc%2fpasswd")
+	f.Add(http.MethodGet, "/%2e%2e/message")
+	f.Add(http.MethodHead, "/message/%00")
+	f.Add(http.MethodOptions, "")
+
+	f.Fuzz(func(t *testing.T, method, target string) {
+		req, err := http.NewRequest(method, "http://localhost" + target, nil)
+		if err != nil {
+			t.Skip()
+		}
+
+		var got []string
+		s := &server{
+			handlers: map[endpoint]endpointHandler {
+				endpoint{"message", http.MethodGet}: func(w http.ResponseWriter, req *http.Request, res []string) {
+					got = res
+				},
+			},
+		}
+		s.ServeHTTP(httptest.NewRecorder(), req)
+
+		uri := cleanURL(req.URL)
+		if strings.HasPrefix(uri, "/") {
+			t.Errorf("cleanURL: '%s' wasn't stripped from its leading slash", uri)
+		}
+		if len(uri) > 0 {
+			for _, seg := range strings.Split(uri, "/") {
+				if seg == "" || seg == "." || seg == ".." {
+					t.Errorf("cleanURL: '%s' wasn't cleaned", uri)
+				}
+			}
+		}
+		if again, err := url.Parse("/" + uri); err != nil || cleanURL(again) != uri {
+			t.Errorf("cleanURL: '%s' isn't stable (%+v)", uri, err)
+		}
+
+		if got != nil && (got[0] != "message" || strings.Join(got, "/") != uri) {
+			t.Errorf("ServeHTTP: '%s' was routed as '%q'", target, got)
+		}
+	})
+}
+
+// FuzzDecodeMessage checks that arbitrary bodies are either rejected or
+// decoded into a message that's stored exactly as received.
+func FuzzDecodeMessage(f *testing.F) {
+	pb, _ := proto.Marshal(&notifierpb.Message{Channel: "general", Message: "Calloo! Callay!"})
+	mp, _ := msgpack.Marshal(map[string]any{"channel": "general", "message": "Calloo! Callay!"})
+	deep := append([]byte{0x81, 0xa7}, "Message"...)
+	deep = append(deep, bytes.Repeat([]byte{0x91}, 100000)...)
+	deep = append(deep, 0xc0)
+
+	f.Add(mediaJSON, []byte(`{"Channel": "general", "Message": "Calloo! Callay!", "Priority": "high"}`), "")
+	f.Add(mediaJSON, []byte(`{"Channel": "gen\xffral", "Message": "Calloo! Callay!"}`), "")
+	f.Add(mediaJSON, []byte(`{"Channel": "general", "Message": ` + strings.Repeat("[", 100000)), "")
+	f.Add(mediaForm, []byte("channel=general&message=Calloo%21+Callay%21&delaySeconds=5"), "")
+	f.Add(mediaForm, []byte("channel=gen%FFral&message=Calloo"), "")
+	f.Add(mediaText, []byte("Calloo! Callay!"), "general")
+	f.Add(mediaText, []byte("Calloo! \xff"), "general")
+	f.Add(mediaBinary, []byte("\xff\xfe"), "gen\xffral")
+	f.Add(mediaProtobuf, pb, "")
+	f.Add(mediaMsgpack, mp, "")
+	f.Add(mediaMsgpack, deep, "general")
+
+	f.Fuzz(func(t *testing.T, contentType string, body []byte, channel string) {
+		req := httptest.NewRequest(http.MethodPost, "/message", bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set(channelHeader, channel)
+		req.Body = http.MaxBytesReader(httptest.NewRecorder(), req.Body, 1 << 20)
+
+		msg, err := decodeMessage(req)
+		if err != nil {
+			return
+		}
+
+		if len(msg.Channel) == 0 {
+			t.Errorf("decodeMessage: Accepted a message without a channel")
+		}
+		if !utf8.ValidString(msg.Channel) || !utf8.ValidString(msg.Message) {
+			t.Errorf("decodeMessage: Accepted malformed UTF-8 in '%q'", msg.message)
+		}
+
+		data, err := json.Marshal(&msg)
+		if err != nil {
+			t.Fatalf("Marshal: Failed to encode the message: %+v", err)
+		}
+		var stored storedMessage
+		if err := json.Unmarshal(data, &stored); err != nil {
+			t.Fatalf("Unmarshal: Failed to decode the stored message: %+v", err)
+		} else if stored.message != msg.message {
+			t.Errorf("decodeMessage: Expected '%q' to be stored but got '%q'", msg.message, stored.message)
+		}
+	})
+}
+
+// TestDecodeMessage checks that messages are decoded from every accepted
+// Content-Type (with the channel in the body, in the query or in a header),
+// and that the handler replies accordingly.
+func TestDecodeMessage(t *testing.T) {
+	general := func(text string) storedMessage {
+		return storedMessage{message: message{Channel: "general", Message: text}}
+	}
+	encode := func(v any) string {
+		var data []byte
+		var err error
+		if m, ok := v.(proto.Message); ok {
+			data, err = proto.Marshal(m)
+		} else {
+			data, err = msgpack.Marshal(v)
+		}
+		if err != nil {
+			t.Fatalf("Marshal: Failed to encode %+v: %+v", v, err)
+		}
+		return string(data)
+	}
+	deep := append([]byte{0x82, 0xa7}, "Channel"...)
+	deep = append(deep, 0xa7)
+	deep = append(deep, "general"...)
+	deep = append(deep, 0xa7)
+	deep = append(deep, "Message"...)
+	deep = append(deep, bytes.Repeat([]byte{0x91}, maxNesting + 1)...)
+	deep = append(deep, 0xc0)
+	srv := testWeb(t, testArgs(t), nil)
+
+	test_cases := []struct{ contentType string; target string; channel string; body string; want storedMessage; err error; status int } {
+		{
+			contentType: mediaJSON,
+			body: `{"Channel": "general", "Message": "Callooh!", "Priority": "high", "DelaySeconds": 30}`,
+			want: storedMessage{message: message{Channel: "general", Message: "Callooh!"}, Priority: local_storage.PriorityHigh, DelaySeconds: 30},
+			status: http.StatusCreated,
+		},
+		{ contentType: "", body: `{"Channel": "general", "Message": "Callay!"}`, want: general("Callay!"), status: http.StatusCreated },
+		{ contentType: mediaJSON + "; charset=utf-8", body: `{"Channel": "general", "Message": "O frabjous day!"}`, want: general("O frabjous day!"), status: http.StatusCreated },
+		// "curl --data" sends JSON as a form.
+		{ contentType: mediaForm, body: ` {"Channel": "general", "Message": "He chortled"}`, want: general("He chortled"), status: http.StatusCreated },
+		{
+			contentType: mediaForm,
+			body: "channel=general&message=in+his+joy&delaySeconds=5&priority=4",
+			want: storedMessage{message: message{Channel: "general", Message: "in his joy"}, Priority: local_storage.PriorityLow, DelaySeconds: 5},
+			status: http.StatusCreated,
+		},
+		{ contentType: mediaForm, body: "channel=general&message=Beware&priority=urgent", err: local_storage.ErrInvalidPriority, status: http.StatusBadRequest },
+		{ contentType: mediaForm, body: "channel=general&message=Beware&delaySeconds=soon", err: strconv.ErrSyntax, status: http.StatusBadRequest },
+		{ contentType: mediaText, target: "?channel=general", body: "the Jabberwock", want: general("the Jabberwock"), status: http.StatusCreated },
+		{ contentType: mediaText + "; charset=utf-8", channel: "general", body: "my son!", want: general("my son!"), status: http.StatusCreated },
+		{ contentType: mediaText, body: "The jaws that bite", err: errMissingChannel, status: http.StatusBadRequest },
+		{ contentType: mediaText, target: "?channel=gen%FFral", body: "the claws that catch", err: errMalformedUTF8, status: http.StatusBadRequest },
+		{ contentType: mediaBinary, channel: "general", body: "Beware the Jubjub bird", want: general("Beware the Jubjub bird"), status: http.StatusCreated },
+		{
+			contentType: mediaBinary,
+			channel: "general",
+			body: "\xff\xfe",
+			want: storedMessage{message: message{Channel: "general", Message: "//4=", Encoding: "base64"}},
+			status: http.StatusCreated,
+		},
+		{
+			contentType: mediaProtobuf,
+			body: encode(&notifierpb.Message{Channel: "general", Message: "Callooh!", Encoding: "base64", DelaySeconds: 10, Priority: notifierpb.Priority_PRIORITY_HIGH}),
+			want: storedMessage{message: message{Channel: "general", Message: "Callooh!", Encoding: "base64"}, Priority: local_storage.PriorityHigh, DelaySeconds: 10},
+			status: http.StatusCreated,
+		},
+		{ contentType: mediaProtobuf, target: "?channel=general", body: encode(&notifierpb.Message{Message: "Callay!"}), want: general("Callay!"), status: http.StatusCreated },
+		{ contentType: mediaProtobuf, body: encode(&notifierpb.Message{Channel: "general", Message: "Beware", Priority: 7}), err: local_storage.ErrInvalidPriority, status: http.StatusBadRequest },
+		{ contentType: mediaProtobuf, channel: "general", body: "\xff\xff\xff", status: http.StatusBadRequest },
+		{
+			contentType: mediaMsgpack,
+			body: encode(map[string]any{"channel": "general", "message": "uffish thought", "priority": "high", "delaySeconds": 20}),
+			want: storedMessage{message: message{Channel: "general", Message: "uffish thought"}, Priority: local_storage.PriorityHigh, DelaySeconds: 20},
+			status: http.StatusCreated,
+		},
+		{ contentType: mediaXMsgpack, channel: "general", body: encode(map[string]any{"Message": "the Tumtum tree"}), want: general("the Tumtum tree"), status: http.StatusCreated },
+		{ contentType: mediaMsgpack, body: encode([]string{"general", "not a map"}), status: http.StatusBadRequest },
+		{ contentType: mediaMsgpack, body: string(deep), err: errTooNested, status: http.StatusBadRequest },
+		{ contentType: "application/xml", channel: "general", body: "<message>and shun</message>", err: errUnsupportedMedia, status: http.StatusUnsupportedMediaType },
+		{ contentType: "image/png", channel: "general", body: "the frumious Bandersnatch", err: errUnsupportedMedia, status: http.StatusUnsupportedMediaType },
+	}
+
+	for i, tc := range test_cases {
+		newRequest := func() *http.Request {
+			req := httptest.NewRequest(http.MethodPost, "/message" + tc.target, strings.NewReader(tc.body))
+			if len(tc.contentType) > 0 {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			if len(tc.channel) > 0 {
+				req.Header.Set(channelHeader, tc.channel)
+			}
+			return req
+		}
+
+		msg, err := decodeMessage(newRequest())
+		if tc.status != http.StatusCreated && tc.err == nil {
+			if err == nil {
+				t.Errorf("%d: decodeMessage: Expected to fail but got %+v", i, msg)
+			}
+		} else if tc.err != nil {
+			if !errors.Is(err, tc.err) {
+				t.Errorf("%d: decodeMessage: Expected the error '%+v' but got '%+v'", i, tc.err, err)
+			}
+		} else if err != nil {
+			t.Errorf("%d: decodeMessage: Failed to decode the message: %+v", i, err)
+		} else if !reflect.DeepEqual(msg, tc.want) {
+			t.Errorf("%d: decodeMessage: Expected %+v but got %+v", i, tc.want, msg)
+		}
+
+		if w := serve(srv, newRequest()); w.Code != tc.status {
+			t.Errorf("%d: POST: Expected %d but got %d '%s'", i, tc.status, w.Code, w.Body)
+		}
+	}
+}
+
+// fakeReceiver is a queue that returns every message that wasn't deleted
+// yet on each Receive (i.e., as if their visibility timeout was 0).
+type fakeReceiver struct {
+	// The messages in the queue.
+	msgs []sender.Received
+}
+
+func (f *fakeReceiver) Receive(max int, wait time.Duration) ([]sender.Received, error) {
+	return slices.Clone(f.msgs[:min(max, len(f.msgs))]), nil
+}
+
+func (f *fakeReceiver) Delete(msg sender.Received) error {
+	f.msgs = slices.DeleteFunc(f.msgs, func(m sender.Received) bool {
+		return m.MessageID == msg.MessageID
+	})
+	return nil
+}
+
+// TestReplay checks that messages sent by the server are stored back as
+// they were originally stored, and that any other message is left in the
+// queue.
+func TestReplay(t *testing.T) {
+	store := local_storage.NewFS(t.TempDir(), 0)
+	defer store.Close()
+
+	var want []storedMessage
+	r := &fakeReceiver{}
+	for i := 0; i < 12; i++ {
+		stored := storedMessage{
+			message: message{
+				Channel: "general",
+				Message: fmt.Sprintf("Beware the Jabberwock, my son! (%d)", i),
+			},
+			RequestID: fmt.Sprintf("req-%d", i),
+		}
+		if i == 1 {
+			stored.Encoding = "base64"
+			stored.TraceContext = map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}
+		}
+		data, err := json.Marshal(&stored)
+		if err != nil {
+			t.Fatalf("Marshal: Failed to encode the message: %+v", err)
+		}
+		want = append(want, stored)
+		r.msgs = append(r.msgs, sender.Received{Message: decodeStored(data), MessageID: fmt.Sprintf("msg-%d", i)})
+	}
+	skipped := []sender.Received{
+		{Message: sender.Message{Body: "The frumious Bandersnatch!"}, MessageID: "text"},
+		{Message: sender.Message{Body: `{"Channel": "general"}`, Attributes: map[string]string{sendermw.EncryptionAttr: sender.KMSEncryptionScheme}}, MessageID: "encrypted"},
+	}
+	r.msgs = append(skipped, r.msgs...)
+
+	st, err := replay(r, storeReplayer(store), 10, 0)
+	if err != nil {
+		t.Fatalf("replay: Failed to replay the messages: %+v", err)
+	} else if st.Replayed != 10 || st.Skipped != 2 {
+		t.Errorf("replay: Expected 10 messages to be replayed and 2 to be skipped, but got '%+v'", st)
+	}
+	if len(r.msgs) != 4 || r.msgs[0].MessageID != "text" || r.msgs[1].MessageID != "encrypted" {
+		t.Errorf("replay: Unexpected messages left in the queue '%+v'", r.msgs)
+	}
+
+	st, err = replay(r, storeReplayer(store), 0, 0)
+	if err != nil {
+		t.Fatalf("replay: Failed to replay the messages: %+v", err)
+	} else if st.Replayed != 2 || st.Skipped != 2 {
+		t.Errorf("replay: Expected 2 messages to be replayed and 2 to be skipped, but got '%+v'", st)
+	}
+
+	entries, err := store.Entries()
+	if err != nil {
+		t.Fatalf("Entries: Failed to list the messages: %+v", err)
+	} else if len(entries) != len(want) {
+		t.Fatalf("Entries: Expected %d messages but got %d", len(want), len(entries))
+	}
+	for _, entry := range entries {
+		var got storedMessage
+		if err := json.Unmarshal(entry.Bytes, &got); err != nil {
+			t.Fatalf("Unmarshal: Failed to decode the stored message: %+v", err)
+		}
+		var i int
+		fmt.Sscanf(got.RequestID, "req-%d", &i)
+		if !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("%d: replay: Expected '%+v' but got '%+v'", i, want[i], got)
+		}
+	}
+}
+
+// TestConsume checks that consumed messages are filed as issues (and
+// removed from the queue), that failed messages are retried and that
+// rejected messages are left in the queue.
+func TestConsume(t *testing.T) {
+	r := &fakeReceiver{msgs: []sender.Received{
+		{Message: sender.Message{Body: `{"Channel": "general", "Message": "//4=", "Encoding": "base64"}`}, MessageID: "binary"},
+		{Message: sender.Message{Body: `{"Channel": "general"}`, Attributes: map[string]string{sendermw.EncryptionAttr: sender.KMSEncryptionScheme}}, MessageID: "encrypted"},
+		{Message: sender.Message{Body: `{"Channel": "general", "Message": "The store is full\nat /var/lib"}`, Attributes: map[string]string{requestIDAttr: "req-1"}}, MessageID: "text"},
+		{Message: sender.Message{Body: `{"Channel": "alerts", "Message": "U25pY2tlci1zbmFjayE=", "Encoding": "base64"}`}, MessageID: "base64"},
+		{Message: sender.Message{Body: "The frumious Bandersnatch!"}, MessageID: "other"},
+	}}
+	want := []string{
+		"The store is full\nat /var/lib\n\n---\nChannel: `general`\nRequest ID: `req-1`",
+		"Snicker-snack!\n\n---\nChannel: `alerts`",
+		"The frumious Bandersnatch!",
+	}
+
+	fake := sendertest.New()
+	fake.FailNext(1, sender.ErrTemporary)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan consumeStats)
+	go func() {
+		done <- consume(ctx, r, issueSender{s: fake}, 0, 0, 0)
+	} ()
+	if !fake.WaitFor(len(want), time.Second) {
+		t.Errorf("consume: Expected %d messages to be delivered", len(want))
+	}
+	cancel()
+	st := <-done
+
+	if st.Delivered != len(want) || st.Failed != 1 || st.Rejected < 2 {
+		t.Errorf("consume: Expected %d messages to be delivered, 1 to fail and at least 2 to be rejected, but got '%+v'", len(want), st)
+	}
+	var got []string
+	for _, msg := range fake.Messages() {
+		got = append(got, msg.Body)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("consume: Expected the issues '%q' but got '%q'", want, got)
+	}
+	if len(r.msgs) != 2 || r.msgs[0].MessageID != "binary" || r.msgs[1].MessageID != "encrypted" {
+		t.Errorf("consume: Unexpected messages left in the queue '%+v'", r.msgs)
+	}
+}
+
+// TestDestinationTimeout checks that requests to a "github" destination
+// time out after the destination's own TimeoutMS, rather than
+// AWSTimeoutMS.
+func TestDestinationTimeout(t *testing.T) {
+	release := make(chan struct{})
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	defer api.Close()
+	defer close(release)
+
+	test_cases := []struct{ timeoutMS int; fails bool } {
+		{ timeoutMS: 50, fails: true },
+		{ timeoutMS: 0, fails: false },
+	}
+
+	for i, tc := range test_cases {
+		args := Args{AWSTimeoutMS: 50}
+		d := Destination{
+			Name: "issues",
+			Type: destinationGitHub,
+			Endpoint: api.URL,
+			Repo: "acme/backend",
+			Token: "token",
+			TimeoutMS: tc.timeoutMS,
+		}
+
+		s, err := newSender(args, d)
+		if err != nil {
+			t.Fatalf("%d: newSender: Failed to create the sender: %+v", i, err)
+		}
+		done := make(chan error, 1)
+		go func() {
+			done <- s.(sender.Checker).Check()
+		} ()
+
+		select {
+		case err := <-done:
+			if !tc.fails {
+				t.Errorf("%d: Check: Expected the request to outlast AWSTimeoutMS, but got '%+v'", i, err)
+			} else if !errors.Is(err, sender.ErrUnreachable) {
+				t.Errorf("%d: Check: Expected error '%+v' but got '%+v'", i, sender.ErrUnreachable, err)
+			}
+		case <-time.After(500 * time.Millisecond):
+			if tc.fails {
+				t.Errorf("%d: Check: The request didn't time out", i)
+			}
+		}
+	}
+}
+
+// checkableSender is a sendertest.Sender that may also be checked, failing
+// the check while its err is set.
+type checkableSender struct {
+	*sendertest.Sender
+	err error
+}
+
+func (c *checkableSender) Check() error {
+	return c.err
+}
+
+// TestHeartbeat checks that each heartbeat verifies the pipeline as
+// configured, and that its status tracks the consecutive failures.
+func TestHeartbeat(t *testing.T) {
+	test_cases := []struct{ mode string; checkable bool; want string; sent int } {
+		{ mode: "", checkable: true, want: heartbeatCheck, sent: 0 },
+		{ mode: heartbeatMessage, checkable: true, want: heartbeatMessage, sent: 1 },
+		// Senders that can't be checked must receive messages instead.
+		{ mode: heartbeatCheck, checkable: false, want: heartbeatMessage, sent: 1 },
+	}
+
+	for i, tc := range test_cases {
+		fake := sendertest.New()
+		fake.FailNext(1, sender.ErrTemporary)
+		checker := &checkableSender{Sender: fake, err: sender.ErrUnreachable}
+
+		var s sender.Sender = fake
+		if tc.checkable {
+			s = checker
+		}
+		hb := startHeartbeat(Args{HeartbeatMinutes: 60, HeartbeatMode: tc.mode}, pipeline{sender: s, base: s})
+
+		hb.beat()
+		status, ok := hb.Status()
+		if !ok {
+			t.Fatalf("%d: Status: Expected the heartbeat to be enabled", i)
+		} else if status.Mode != tc.want {
+			t.Errorf("%d: Status: Expected mode '%s' but got '%s'", i, tc.want, status.Mode)
+		} else if status.Failures != 1 || len(status.LastError) == 0 || !status.LastSuccess.IsZero() {
+			t.Errorf("%d: Status: Expected the heartbeat to fail but got '%+v'", i, status)
+		}
+
+		checker.err = nil
+		hb.beat()
+		hb.beat()
+		status, _ = hb.Status()
+		if status.Failures != 0 || len(status.LastError) > 0 || !status.LastSuccess.Equal(status.LastBeat) {
+			t.Errorf("%d: Status: Expected the heartbeat to recover but got '%+v'", i, status)
+		}
+
+		msgs := fake.Messages()
+		if want := 2 * tc.sent; len(msgs) != want {
+			t.Errorf("%d: Send: Expected %d heartbeat messages but got %d", i, want, len(msgs))
+		}
+		for _, msg := range msgs {
+			if _, ok := msg.Attributes[heartbeatAttr]; !ok {
+				t.Errorf("%d: Send: Expected the attribute '%s' in '%+v'", i, heartbeatAttr, msg)
+			}
+		}
+		hb.Close()
+	}
+
+	if hb := startHeartbeat(Args{}, pipeline{}); hb != nil {
+		t.Errorf("startHeartbeat: Expected the heartbeat to be disabled")
+	} else if _, ok := hb.Status(); ok {
+		t.Errorf("Status: Expected the heartbeat to be disabled")
+	}
+}
+
+// writeKeyPair writes a new self-signed certificate for cn, and its key, to
+// certFile and keyFile, dating them at modTime. If keyFile is empty, only
+// the certificate is written (so it doesn't match the key on disk).
+func writeKeyPair(t *testing.T, certFile, keyFile, cn string, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: Failed to generate the key: %+v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{CommonName: cn},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter: time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: Failed to create the certificate: %+v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey: Failed to encode the key: %+v", err)
+	}
+
+	files := map[string]*pem.Block{certFile: {Type: "CERTIFICATE", Bytes: der}}
+	if len(keyFile) > 0 {
+		files[keyFile] = &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}
+	}
+	for path, block := range files {
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatalf("WriteFile: Failed to write '%s': %+v", path, err)
+		} else if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Chtimes: Failed to date '%s': %+v", path, err)
+		}
+	}
+}
+
+// servedName retrieves the common name of the certificate served by cr.
+func servedName(t *testing.T, cr *certReloader) string {
+	cert, err := cr.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("GetCertificate: Failed to get the certificate: %+v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("ParseCertificate: Failed to parse the certificate: %+v", err)
+	}
+	return leaf.Subject.CommonName
+}
+
+// TestCertReloader checks that the certificate is reloaded once both of
+// its files are rewritten, and that the previous one keeps being served
+// while they don't match.
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	modTime := time.Now().Add(-time.Hour)
+	writeKeyPair(t, certFile, keyFile, "first", modTime)
+
+	cr, err := newCertReloader(certFile, keyFile, time.Nanosecond)
+	if err != nil {
+		t.Fatalf("newCertReloader: Failed to load the certificate: %+v", err)
+	}
+	frozen, err := newCertReloader(certFile, keyFile, 0)
+	if err != nil {
+		t.Fatalf("newCertReloader: Failed to load the certificate: %+v", err)
+	}
+	if want, got := "first", servedName(t, cr); want != got {
+		t.Errorf("GetCertificate: Expected '%s' but got '%s'", want, got)
+	}
+
+	test_cases := []struct{ cn string; withKey bool; want string } {
+		{ cn: "second", withKey: true, want: "second" },
+		// The key wasn't rotated (yet), so the certificate can't be loaded.
+		{ cn: "third", withKey: false, want: "second" },
+		{ cn: "fourth", withKey: true, want: "fourth" },
+	}
+
+	for i, tc := range test_cases {
+		modTime = modTime.Add(time.Minute)
+		if tc.withKey {
+			writeKeyPair(t, certFile, keyFile, tc.cn, modTime)
+		} else {
+			writeKeyPair(t, certFile, "", tc.cn, modTime)
+		}
+		time.Sleep(time.Millisecond)
+
+		if want, got := tc.want, servedName(t, cr); want != got {
+			t.Errorf("%d: GetCertificate: Expected '%s' but got '%s'", i, want, got)
+		}
+	}
+
+	// Without an interval, the certificate is never reloaded.
+	if want, got := "first", servedName(t, frozen); want != got {
+		t.Errorf("GetCertificate: Expected '%s' but got '%s'", want, got)
+	}
+}
+
+// TestACME checks that the server obtains certificates only for ACMEHosts,
+// answering the CA's challenges on ACMEHTTPPort and redirecting everything
+// else there to HTTPS.
+func TestACME(t *testing.T) {
+	args := testArgs(t)
+	args.ACMEHosts = "notify.example.com, hooks.example.com"
+	args.ACMECacheDir = t.TempDir()
+	args.ACMEHTTPPort = freePort(t)
+
+	m := newACMEManager(args)
+	test_cases := []struct{ host string; allowed bool } {
+		{ host: "notify.example.com", allowed: true },
+		{ host: "hooks.example.com", allowed: true },
+		{ host: "example.com", allowed: false },
+		{ host: "evil.example.org", allowed: false },
+	}
+	for i, tc := range test_cases {
+		err := m.HostPolicy(context.Background(), tc.host)
+		if tc.allowed && err != nil {
+			t.Errorf("%d: HostPolicy: Expected '%s' to be allowed but got '%+v'", i, tc.host, err)
+		} else if !tc.allowed && err == nil {
+			t.Errorf("%d: HostPolicy: Expected '%s' to be refused", i, tc.host)
+		}
+	}
+	if m.Cache == nil {
+		t.Errorf("newACMEManager: Expected the certificates to be cached in ACMECacheDir")
+	}
+
+	store := local_storage.NewFS(t.TempDir(), 0)
+	defer store.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- Run(ctx, WithArgs(args), WithStore(store), WithSender(sendertest.New()))
+	} ()
+	defer func() {
+		cancel()
+		<-done
+	} ()
+
+	c := http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("http://127.0.0.1:%d/notify", args.ACMEHTTPPort), nil)
+	req.Host = "notify.example.com"
+
+	var resp *http.Response
+	var err error
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		resp, err = c.Do(req)
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Get: Failed to reach the challenge server: %+v", err)
+	}
+	resp.Body.Close()
+
+	if want, got := http.StatusFound, resp.StatusCode; want != got {
+		t.Errorf("Get: Expected status %d but got %d", want, got)
+	} else if want, got := "https://notify.example.com/notify", resp.Header.Get("Location"); want != got {
+		t.Errorf("Get: Expected a redirect to '%s' but got '%s'", want, got)
+	}
+}
+
+// dupFD duplicates f's file descriptor, as inherited by an upgraded process.
+func dupFD(t *testing.T, f *os.File) int {
+	var fd int
+	var err error
+	rawFD(f, func(raw uintptr) {
+		fd, err = syscall.Dup(int(raw))
+	})
+	if err != nil {
+		t.Fatalf("Dup: Failed to duplicate '%s': %+v", f.Name(), err)
+	}
+	return fd
+}
+
+// TestHandoffListener checks that connections received once a listener
+// stops accepting them are left for the process that inherits it.
+func TestHandoffListener(t *testing.T) {
+	addr := fmt.Sprintf("127.0.0.1:%d", freePort(t))
+	old, err := listen(addr)
+	if err != nil {
+		t.Fatalf("listen: Failed to listen on '%s': %+v", addr, err)
+	}
+	defer func() {
+		listeners.mutex.Lock()
+		delete(listeners.open, addr)
+		listeners.inherited = nil
+		listeners.mutex.Unlock()
+	} ()
+
+	accepted := make(chan error, 1)
+	go func() {
+		c, err := old.Accept()
+		if err == nil {
+			c.Close()
+		}
+		accepted <- err
+	} ()
+	old.(*handoffListener).stopAccepting()
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial: Failed to connect to '%s': %+v", addr, err)
+	}
+	defer c.Close()
+
+	f, err := old.(*handoffListener).File()
+	if err != nil {
+		t.Fatalf("File: Failed to duplicate the listener: %+v", err)
+	}
+	fd := dupFD(t, f)
+	f.Close()
+
+	t.Setenv(envHandoffListeners, fmt.Sprintf("%s=%d", addr, fd))
+	listeners.mutex.Lock()
+	listeners.inherited = nil
+	listeners.mutex.Unlock()
+	if !handedOff() {
+		t.Errorf("handedOff: Expected the listeners to be handed off")
+	}
+
+	l, err := listen(addr)
+	if err != nil {
+		t.Fatalf("listen: Failed to inherit '%s': %+v", addr, err)
+	}
+	defer l.Close()
+	l.(*handoffListener).SetDeadline(time.Now().Add(2 * time.Second))
+	if conn, err := l.Accept(); err != nil {
+		t.Errorf("Accept: Expected the inherited listener to accept the connection but got '%+v'", err)
+	} else {
+		conn.Close()
+	}
+
+	select {
+	case err := <-accepted:
+		t.Fatalf("Accept: Expected the old listener to stop accepting but got '%+v'", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	old.Close()
+	if err := <-accepted; !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Accept: Expected error '%+v' but got '%+v'", net.ErrClosed, err)
+	}
+}
+
+// TestHandoffReady checks that the upgraded process tells the previous one
+// that it's ready, and then waits for it to release the local storage.
+func TestHandoffReady(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe: Failed to create the ready pipe: %+v", err)
+	}
+	defer r.Close()
+	t.Setenv(envHandoffReadyFD, strconv.Itoa(dupFD(t, w)))
+	w.Close()
+
+	notifyHandoffReady()
+	if data, err := io.ReadAll(r); err != nil || string(data) != handoffReady {
+		t.Errorf("notifyHandoffReady: Expected '%s' but got '%s' (%+v)", handoffReady, data, err)
+	}
+
+	dir := t.TempDir()
+	prev, err := lockStore(dir)
+	if err != nil {
+		t.Fatalf("lockStore: Failed to lock the local storage: %+v", err)
+	}
+	if _, err := lockStore(dir); err != errStoreInUse {
+		t.Errorf("lockStore: Expected error '%+v' but got '%+v'", errStoreInUse, err)
+	}
+
+	release := 3 * handoffLockInterval
+	time.AfterFunc(release, func() {
+		prev.Close()
+	})
+	start := time.Now()
+	lock, err := lockHandedOffStore(dir)
+	if err != nil {
+		t.Fatalf("lockHandedOffStore: Failed to lock the local storage: %+v", err)
+	}
+	lock.Close()
+	if waited := time.Since(start); waited < release {
+		t.Errorf("lockHandedOffStore: Locked the local storage after %s, before it was released", waited)
+	}
+}
+
+// TestAuditLog checks that the audit log keeps the identifier assigned to
+// each message by its receiver, along with its request ID.
+func TestAuditLog(t *testing.T) {
+	args := testArgs(t)
+	args.AuditLogFile = filepath.Join(t.TempDir(), "audit.log")
+	store := local_storage.NewFS(t.TempDir(), 0)
+	defer store.Close()
+	s := sendertest.New()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- Run(ctx, WithArgs(args), WithStore(store), WithSender(s))
+	} ()
+
+	c, err := client.New(fmt.Sprintf("http://%s:%d", args.IP, args.Port), client.WithRetry(client.RetryPolicy{Attempts: 1}))
+	if err != nil {
+		t.Fatalf("New: Failed to create the client: %+v", err)
+	}
+	defer c.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		_, err = c.Notify(ctx, "general", "Beware the Jubjub bird")
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Notify: Failed to post the message: %+v", err)
+	} else if !s.WaitFor(1, 2 * time.Second) {
+		t.Fatalf("Send: The message wasn't forwarded")
+	}
+	requestID := s.Messages()[0].Attributes[requestIDAttr]
+
+	// The audit log is closed (and so flushed) once the server stops.
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run: Expected to stop cleanly but got %+v", err)
+	}
+
+	data, err := os.ReadFile(args.AuditLogFile)
+	if err != nil {
+		t.Fatalf("ReadFile: Failed to read the audit log: %+v", err)
+	}
+	var sent []auditEntry
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var entry auditEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Unmarshal: Invalid audit entry '%s': %+v", line, err)
+		} else if entry.Event == eventSent {
+			sent = append(sent, entry)
+		}
+	}
+
+	if len(sent) != 1 {
+		t.Fatalf("Record: Expected a single '%s' entry but got '%+v'", eventSent, sent)
+	} else if want, got := "sendertest-1", sent[0].MessageID; want != got {
+		t.Errorf("Record: Expected the MessageID '%s' but got '%s'", want, got)
+	} else if sent[0].RequestID != requestID || len(requestID) == 0 {
+		t.Errorf("Record: Expected the request ID '%s' but got '%s'", requestID, sent[0].RequestID)
+	}
+}
+
+// startTestServer runs a server configured by args and opts, forwarding
+// through a sendertest.Sender, until the test ends. It returns the
+// server's URL once it accepts requests.
+func startTestServer(t *testing.T, args Args, opts ...Option) string {
+	store := local_storage.NewFS(t.TempDir(), 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	opts = append([]Option{WithArgs(args), WithStore(store), WithSender(sendertest.New())}, opts...)
+	go func() {
+		done <- Run(ctx, opts...)
+	} ()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		store.Close()
+	})
+
+	addr := net.JoinHostPort(args.IP, strconv.Itoa(args.Port))
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		c, err := net.Dial("tcp", addr)
+		if err == nil {
+			c.Close()
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("Run: The server didn't start: %+v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return "http://" + addr
+}
+
+// TestWithMiddleware checks that the embedding program's middleware runs,
+// in order, only on the requests accepted by the built-in middleware.
+func TestWithMiddleware(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cr3t"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword: Failed to hash the password: %+v", err)
+	}
+	args := testArgs(t)
+	args.AuthBasicUsers = "admin:" + string(hash)
+
+	var mutex sync.Mutex
+	var calls []string
+	record := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				mutex.Lock()
+				calls = append(calls, name)
+				mutex.Unlock()
+				next.ServeHTTP(w, req)
+			})
+		}
+	}
+	url := startTestServer(t, args, WithMiddleware(record("first")), WithMiddleware(record("second")))
+
+	test_cases := []struct{ user string; status int; calls []string } {
+		{ user: "", status: http.StatusUnauthorized, calls: nil },
+		{ user: "admin", status: http.StatusOK, calls: []string{"first", "second"} },
+	}
+	for i, tc := range test_cases {
+		calls = nil
+		req, _ := http.NewRequest(http.MethodGet, url + "/version", nil)
+		if len(tc.user) > 0 {
+			req.SetBasicAuth(tc.user, "s3cr3t")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%d: Do: Failed to send the request: %+v", i, err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != tc.status {
+			t.Errorf("%d: Do: Expected status %d but got %d", i, tc.status, resp.StatusCode)
+		}
+		mutex.Lock()
+		if !reflect.DeepEqual(calls, tc.calls) {
+			t.Errorf("%d: Middleware: Expected the calls '%v' but got '%v'", i, tc.calls, calls)
+		}
+		mutex.Unlock()
+	}
+}
+
+// panicAuthenticator is an auth.Authenticator that panics.
+type panicAuthenticator struct{}
+
+func (panicAuthenticator) Authenticate(req *http.Request) (*auth.Principal, error) {
+	panic("the authenticator is broken")
+}
+
+// testWeb starts the web server configured by args, without any other
+// component, until the test ends.
+func testWeb(t *testing.T, args Args, a auth.Authenticator, extra ...Middleware) *server {
+	store := local_storage.NewFS(t.TempDir(), 0)
+	srv, err := runWeb(args, store, nil, nil, nil, nil, a, nil, nil, extra)
+	if err != nil {
+		t.Fatalf("runWeb: Failed to start the web server: %+v", err)
+	}
+	t.Cleanup(func() {
+		srv.Close()
+		store.Close()
+	})
+	return srv
+}
+
+// serve req through srv's middleware and handlers.
+func serve(srv *server, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	srv.httpServer.Handler.ServeHTTP(w, req)
+	return w
+}
+
+// TestMiddlewareOrder checks that panics in any middleware (e.g., while
+// authenticating) are replied with 500 and logged, and that rate limited
+// requests never reach the extra middleware.
+func TestMiddlewareOrder(t *testing.T) {
+	var logs bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+	defer slog.SetDefault(prev)
+
+	args := testArgs(t)
+	srv := testWeb(t, args, panicAuthenticator{})
+	w := serve(srv, httptest.NewRequest(http.MethodGet, "/version", nil))
+	if want, got := http.StatusInternalServerError, w.Code; want != got {
+		t.Errorf("ServeHTTP: Expected status %d but got %d", want, got)
+	}
+	if !strings.Contains(logs.String(), `"msg":"Request handled"`) || !strings.Contains(logs.String(), `"status":500`) {
+		t.Errorf("accessLog: Expected the 500 reply to be logged, but got '%s'", logs.String())
+	}
+
+	var reached int
+	args = testArgs(t)
+	args.ClientRate = 0.001
+	args.ClientBurst = 1
+	srv = testWeb(t, args, nil, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			reached++
+			if req.URL.Path == "/status" {
+				panic("the middleware is broken")
+			}
+			next.ServeHTTP(w, req)
+		})
+	})
+
+	test_cases := []struct{ path string; status int; reached int } {
+		{ path: "/status", status: http.StatusInternalServerError, reached: 1 },
+		{ path: "/version", status: http.StatusTooManyRequests, reached: 1 },
+	}
+	for i, tc := range test_cases {
+		w := serve(srv, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if w.Code != tc.status {
+			t.Errorf("%d: ServeHTTP: Expected status %d but got %d", i, tc.status, w.Code)
+		} else if reached != tc.reached {
+			t.Errorf("%d: ServeHTTP: Expected the middleware to be reached %d times but got %d", i, tc.reached, reached)
+		}
+	}
+}
+
+// TestMethods checks that known resources reply to unsupported methods
+// with 405 and the methods they support, and that HEAD and OPTIONS are
+// handled for every resource.
+func TestMethods(t *testing.T) {
+	srv := testWeb(t, testArgs(t), nil)
+	get := serve(srv, httptest.NewRequest(http.MethodGet, "/version", nil))
+
+	test_cases := []struct{ method string; path string; status int; allow string; body bool } {
+		{ method: http.MethodPut, path: "/message", status: http.StatusMethodNotAllowed, allow: "DELETE, GET, HEAD, OPTIONS, POST", body: true },
+		{ method: http.MethodPatch, path: "/version", status: http.StatusMethodNotAllowed, allow: "GET, HEAD, OPTIONS", body: true },
+		{ method: http.MethodPost, path: "/openapi.json", status: http.StatusMethodNotAllowed, allow: "GET, HEAD, OPTIONS", body: true },
+		{ method: http.MethodPut, path: "/nothing", status: http.StatusNotFound, allow: "", body: true },
+		{ method: http.MethodGet, path: "/nothing", status: http.StatusNotFound, allow: "", body: true },
+		{ method: http.MethodOptions, path: "/message", status: http.StatusNoContent, allow: "DELETE, GET, HEAD, OPTIONS, POST", body: false },
+		{ method: http.MethodOptions, path: "/v2", status: http.StatusNoContent, allow: "OPTIONS, POST", body: false },
+		{ method: http.MethodHead, path: "/version", status: http.StatusOK, allow: "", body: false },
+		{ method: http.MethodHead, path: "/v2", status: http.StatusMethodNotAllowed, allow: "OPTIONS, POST", body: true },
+	}
+	for i, tc := range test_cases {
+		w := serve(srv, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != tc.status {
+			t.Errorf("%d: %s %s: Expected status %d but got %d", i, tc.method, tc.path, tc.status, w.Code)
+		}
+		if got := w.Header().Get("Allow"); got != tc.allow {
+			t.Errorf("%d: %s %s: Expected Allow '%s' but got '%s'", i, tc.method, tc.path, tc.allow, got)
+		}
+		if tc.body != (w.Body.Len() > 0) {
+			t.Errorf("%d: %s %s: Expected a body (%v) but got '%s'", i, tc.method, tc.path, tc.body, w.Body)
+		}
+	}
+
+	// HEAD replies just like GET, only without the body.
+	head := serve(srv, httptest.NewRequest(http.MethodHead, "/version", nil))
+	if want, got := get.Header().Get("Content-Type"), head.Header().Get("Content-Type"); want != got {
+		t.Errorf("HEAD: Expected Content-Type '%s' but got '%s'", want, got)
+	} else if get.Body.Len() == 0 {
+		t.Errorf("GET: Expected a body")
+	}
+}
+
+// TestCORS checks that cross-origin requests are only allowed from the
+// configured origins, and that preflight requests are answered without
+// credentials.
+func TestCORS(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cr3t"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword: Failed to hash the password: %+v", err)
+	}
+	args := testArgs(t)
+	args.AuthBasicUsers = "admin:" + string(hash)
+	a, err := newAuthenticator(args)
+	if err != nil {
+		t.Fatalf("newAuthenticator: Failed to create the authenticator: %+v", err)
+	}
+
+	args.CORSOrigins = "https://app.example.com, https://admin.example.com/"
+	wildcard := testArgs(t)
+	wildcard.CORSOrigins = "*"
+	origins := map[string]*server{
+		"listed": testWeb(t, args, a),
+		"*": testWeb(t, wildcard, nil),
+	}
+
+	test_cases := []struct{ policy string; method string; origin string; preflight bool; user string; status int; allowOrigin string } {
+		// Preflight requests never carry credentials.
+		{ policy: "listed", method: http.MethodOptions, origin: "https://app.example.com", preflight: true, status: http.StatusNoContent, allowOrigin: "https://app.example.com" },
+		{ policy: "listed", method: http.MethodOptions, origin: "https://admin.example.com", preflight: true, status: http.StatusNoContent, allowOrigin: "https://admin.example.com" },
+		{ policy: "listed", method: http.MethodOptions, origin: "https://evil.example.com", preflight: true, status: http.StatusUnauthorized, allowOrigin: "" },
+		// The browser may read why the request was refused.
+		{ policy: "listed", method: http.MethodGet, origin: "https://app.example.com", status: http.StatusUnauthorized, allowOrigin: "https://app.example.com" },
+		{ policy: "listed", method: http.MethodGet, origin: "https://app.example.com", user: "admin", status: http.StatusOK, allowOrigin: "https://app.example.com" },
+		{ policy: "listed", method: http.MethodGet, origin: "https://evil.example.com", user: "admin", status: http.StatusOK, allowOrigin: "" },
+		{ policy: "*", method: http.MethodOptions, origin: "https://any.example.com", preflight: true, status: http.StatusNoContent, allowOrigin: "*" },
+		{ policy: "*", method: http.MethodGet, origin: "https://any.example.com", status: http.StatusOK, allowOrigin: "*" },
+	}
+	for i, tc := range test_cases {
+		req := httptest.NewRequest(tc.method, "/version", nil)
+		req.Header.Set("Origin", tc.origin)
+		if tc.preflight {
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			req.Header.Set("Access-Control-Request-Headers", "authorization, content-type")
+		}
+		if len(tc.user) > 0 {
+			req.SetBasicAuth(tc.user, "s3cr3t")
+		}
+
+		w := serve(origins[tc.policy], req)
+		hdr := w.Header()
+		if w.Code != tc.status {
+			t.Errorf("%d: %s: Expected status %d but got %d", i, tc.method, tc.status, w.Code)
+		}
+		if got := hdr.Get("Access-Control-Allow-Origin"); got != tc.allowOrigin {
+			t.Errorf("%d: %s: Expected Access-Control-Allow-Origin '%s' but got '%s'", i, tc.method, tc.allowOrigin, got)
+		}
+		if !slices.Contains(hdr.Values("Vary"), "Origin") {
+			t.Errorf("%d: %s: Expected 'Vary: Origin' but got '%v'", i, tc.method, hdr.Values("Vary"))
+		}
+		// Credentials are sent explicitly (in the Authorization header),
+		// never as the browser's cookies.
+		if got := hdr.Get("Access-Control-Allow-Credentials"); len(got) > 0 {
+			t.Errorf("%d: %s: Expected no Access-Control-Allow-Credentials but got '%s'", i, tc.method, got)
+		}
+
+		if tc.preflight && len(tc.allowOrigin) > 0 {
+			if got := hdr.Get("Access-Control-Allow-Headers"); !strings.Contains(got, "Authorization") {
+				t.Errorf("%d: %s: Expected the Authorization header to be allowed, but got '%s'", i, tc.method, got)
+			} else if want, got := "GET, POST, DELETE", hdr.Get("Access-Control-Allow-Methods"); want != got {
+				t.Errorf("%d: %s: Expected Access-Control-Allow-Methods '%s' but got '%s'", i, tc.method, want, got)
+			} else if want, got := "600", hdr.Get("Access-Control-Max-Age"); want != got {
+				t.Errorf("%d: %s: Expected Access-Control-Max-Age '%s' but got '%s'", i, tc.method, want, got)
+			}
+		}
+	}
+
+	// Requests without an Origin aren't cross-origin.
+	w := serve(origins["*"], httptest.NewRequest(http.MethodGet, "/version", nil))
+	if got := w.Header().Get("Access-Control-Allow-Origin"); len(got) > 0 || slices.Contains(w.Header().Values("Vary"), "Origin") {
+		t.Errorf("GET: Expected no CORS headers but got '%v'", w.Header())
+	}
+}
+
+// gzipped compresses data.
+func gzipped(t *testing.T, data string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(data))
+	if err := gz.Close(); err != nil {
+		t.Fatalf("Close: Failed to compress the data: %+v", err)
+	}
+	return buf.Bytes()
+}
+
+// TestGzip checks that gzip-encoded request bodies are decompressed, and
+// limited by their decompressed size, and that JSON responses are only
+// compressed for clients that accept it.
+func TestGzip(t *testing.T) {
+	args := testArgs(t)
+	args.MaxBodyBytes = 64 * 1024
+	srv := testWeb(t, args, nil)
+
+	// The bomb is far smaller than MaxBodyBytes, until decompressed.
+	bomb := gzipped(t, `{"Channel": "general", "Message": "` + strings.Repeat("a", 16 * args.MaxBodyBytes) + `"}`)
+	if len(bomb) >= args.MaxBodyBytes {
+		t.Fatalf("gzipped: Expected the bomb to be compressed to less than %d bytes, but got %d", args.MaxBodyBytes, len(bomb))
+	}
+
+	post := []struct{ encoding string; body []byte; status int } {
+		{ encoding: "gzip", body: gzipped(t, `{"Channel": "general", "Message": "Callooh! Callay!"}`), status: http.StatusCreated },
+		{ encoding: "", body: []byte(`{"Channel": "general", "Message": "O frabjous day!"}`), status: http.StatusCreated },
+		{ encoding: "gzip", body: bomb, status: http.StatusRequestEntityTooLarge },
+		{ encoding: "gzip", body: []byte(`{"Channel": "general", "Message": "not gzip"}`), status: http.StatusBadRequest },
+		{ encoding: "br", body: []byte("anything"), status: http.StatusUnsupportedMediaType },
+	}
+	for i, tc := range post {
+		req := httptest.NewRequest(http.MethodPost, "/message", bytes.NewReader(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+		if len(tc.encoding) > 0 {
+			req.Header.Set("Content-Encoding", tc.encoding)
+		}
+		if w := serve(srv, req); w.Code != tc.status {
+			t.Errorf("%d: POST: Expected status %d but got %d (%s)", i, tc.status, w.Code, w.Body)
+		}
+	}
+	if want, got := 2, srv.store.Count(); want != got {
+		t.Errorf("POST: Expected %d messages to be stored but got %d", want, got)
+	}
+
+	get := []struct{ accept string; compressed bool } {
+		{ accept: "gzip", compressed: true },
+		{ accept: "deflate, gzip;q=0.5", compressed: true },
+		{ accept: "gzip;q=0", compressed: false },
+		{ accept: "deflate", compressed: false },
+		{ accept: "", compressed: false },
+	}
+	for i, tc := range get {
+		req := httptest.NewRequest(http.MethodGet, "/openapi.json", nil)
+		if len(tc.accept) > 0 {
+			req.Header.Set("Accept-Encoding", tc.accept)
+		}
+		w := serve(srv, req)
+
+		body := w.Body.Bytes()
+		if compressed := w.Header().Get("Content-Encoding") == "gzip"; compressed != tc.compressed {
+			t.Errorf("%d: GET: Expected the reply to be compressed (%v) but got '%v'", i, tc.compressed, w.Header())
+			continue
+		} else if compressed {
+			gz, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Errorf("%d: GET: Invalid gzip reply: %+v", i, err)
+				continue
+			}
+			body, _ = io.ReadAll(gz)
+		}
+		if !json.Valid(body) {
+			t.Errorf("%d: GET: Expected a JSON reply but got '%s'", i, body)
+		}
+	}
+
+	// Only JSON replies are compressed.
+	req := httptest.NewRequest(http.MethodGet, "/version", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	if w := serve(srv, req); len(w.Header().Get("Content-Encoding")) > 0 {
+		t.Errorf("GET: Expected the text reply not to be compressed, but got '%v'", w.Header())
+	}
+}
+
+// TestOpenAPIValidation checks that request bodies that don't match the
+// OpenAPI document are rejected with the invalid values, and that valid
+// ones reach their handler.
+func TestOpenAPIValidation(t *testing.T) {
+	srv := testWeb(t, testArgs(t), nil)
+
+	test_cases := []struct{ body string; status int; locations []string } {
+		{ body: `{"Channel": "general", "Message": "Beware the Jabberwock"}`, status: http.StatusCreated },
+		// Properties are matched case-insensitively.
+		{ body: `{"channel": "general", "MESSAGE": "my son!", "delaySeconds": 5, "Priority": "high"}`, status: http.StatusCreated },
+		{ body: `{"Channel": "general"}`, status: http.StatusBadRequest, locations: []string{"/"} },
+		{ body: `{"Channel": "", "Message": "The jaws that bite"}`, status: http.StatusBadRequest, locations: []string{"/channel"} },
+		{ body: `{"Channel": "general", "Message": 42}`, status: http.StatusBadRequest, locations: []string{"/message"} },
+		{ body: `{"Channel": "general", "Message": "the claws that catch", "DelaySeconds": 901, "Priority": "urgent"}`, status: http.StatusBadRequest, locations: []string{"/delaySeconds", "/priority"} },
+		{ body: `["general", "Beware the Jubjub bird"]`, status: http.StatusBadRequest, locations: []string{"/"} },
+		{ body: `{"Channel": "general",`, status: http.StatusBadRequest, locations: nil },
+	}
+	for i, tc := range test_cases {
+		req := httptest.NewRequest(http.MethodPost, "/message", strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+		w := serve(srv, req)
+		if w.Code != tc.status {
+			t.Errorf("%d: POST: Expected status %d but got %d (%s)", i, tc.status, w.Code, w.Body)
+			continue
+		} else if w.Code != http.StatusBadRequest {
+			continue
+		}
+
+		var resp validationError
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.Error) == 0 {
+			t.Errorf("%d: POST: Expected a validationError but got '%s' (%+v)", i, w.Body, err)
+			continue
+		}
+		var locations []string
+		for _, d := range resp.Details {
+			locations = append(locations, d.Location)
+		}
+		slices.Sort(locations)
+		if !reflect.DeepEqual(locations, tc.locations) {
+			t.Errorf("%d: POST: Expected the invalid values at '%v' but got '%+v'", i, tc.locations, resp.Details)
+		}
+	}
+	if want, got := 2, srv.store.Count(); want != got {
+		t.Errorf("POST: Expected %d messages to be stored but got %d", want, got)
+	}
+}
+
+// reloadFrom writes next to a configuration file and reloads r from it,
+// as if no option was set on the CLI.
+func reloadFrom(t *testing.T, r *reloader, next Args) {
+	data, err := json.Marshal(next)
+	if err != nil {
+		t.Fatalf("Marshal: Failed to encode the configuration: %+v", err)
+	}
+	path := filepath.Join(t.TempDir(), "conf.json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("WriteFile: Failed to write the configuration: %+v", err)
+	}
+
+	prev, prevFlags := confFile, flag.CommandLine
+	confFile, flag.CommandLine = path, flag.NewFlagSet("reload", flag.ContinueOnError)
+	defer func() {
+		confFile, flag.CommandLine = prev, prevFlags
+	} ()
+	r.reload()
+}
+
+// TestReloadTimeouts checks that the local storage's timeout, the
+// forwarder's backoff, the retries and the circuit breaker's cool-down are
+// applied when the configuration is reloaded.
+func TestReloadTimeouts(t *testing.T) {
+	args := testArgs(t)
+	args.DryRun = true
+	args.TimeoutMS = 60 * 60 * 1000
+	args.BreakerCooldownMS = 60 * 60 * 1000
+
+	store := local_storage.NewFS(t.TempDir(), time.Duration(args.TimeoutMS) * time.Millisecond)
+	defer store.Close()
+	s := sendertest.New()
+	breaker := sender.NewCircuitBreaker(s, 1, time.Duration(args.BreakerCooldownMS) * time.Millisecond)
+	fw := forwarder.New(store, breaker, forwarder.Options{Backoff: backoffPolicy(args)})
+	retry := new(atomic.Pointer[sendermw.RetryPolicy])
+	policy := retryPolicy(args)
+	retry.Store(&policy)
+
+	r := &reloader{
+		args: args,
+		store: store,
+		fw: fw,
+		breaker: breaker,
+		retry: retry,
+	}
+
+	next := args
+	next.TimeoutMS = 10
+	next.ForwarderBackoffBaseMS = 300
+	next.ForwarderBackoffJitter = 0
+	next.RetryMaxAttempts = args.RetryMaxAttempts + 1
+	next.BreakerCooldownMS = 1
+	reloadFrom(t, r, next)
+
+	if want, got := retryPolicy(next), *retry.Load(); want != got {
+		t.Errorf("reload: Expected the retry policy %+v but got %+v", want, got)
+	}
+	if r.args.TimeoutMS != next.TimeoutMS {
+		t.Errorf("reload: Expected the TimeoutMS to be %d but got %d", next.TimeoutMS, r.args.TimeoutMS)
+	}
+
+	// Only the reloaded timeout makes Wait time out.
+	done := make(chan error, 1)
+	go func() {
+		done <- store.Wait()
+	} ()
+	select {
+	case err := <-done:
+		if want, got := local_storage.ErrTimedOut, err; want != got {
+			t.Errorf("Wait: Expected error '%+v' but got '%+v'", want, got)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Wait: Didn't time out after the reload")
+	}
+
+	// Only the reloaded cool-down closes the breaker this fast.
+	s.FailNext(1, sender.ErrUnreachable)
+	breaker.Send(sender.Message{Body: "Long time the manxome foe he sought"})
+	time.Sleep(10 * time.Millisecond)
+	if want, got := sender.BreakerHalfOpen, breaker.State(); want != got {
+		t.Errorf("State: Expected '%s' but got '%s'", want, got)
+	}
+
+	// Only the reloaded backoff makes the forwarder back off for 300ms.
+	s.FailNext(1, sender.ErrUnreachable)
+	if err := store.Store([]byte("So rested he by the Tumtum tree")); err != nil {
+		t.Fatalf("Store: Failed to store the message: %+v", err)
+	}
+	fw.Start()
+	defer fw.Stop()
+	deadline := time.Now().Add(time.Second)
+	for fw.Backoff() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if want, got := 300 * time.Millisecond, fw.Backoff(); want != got {
+		t.Errorf("Backoff: Expected %s but got %s", want, got)
+	}
+}
+
+// TestReloadLogLevels checks that the reloaded log levels are applied along
+// with the other options.
+func TestReloadLogLevels(t *testing.T) {
+	prev := logHandler
+	logHandler = loglevel.NewHandler(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelDebug}), loglevel.Levels{Default: slog.LevelInfo})
+	defer func() {
+		logHandler = prev
+	} ()
+
+	args := testArgs(t)
+	args.DryRun = true
+	args.LogLevel = "info"
+	r := &reloader{args: args}
+
+	next := args
+	next.LogLevel = "debug"
+	next.LogLevels = componentWeb + "=error"
+	reloadFrom(t, r, next)
+
+	ctx := context.Background()
+	if !logHandler.Enabled(ctx, slog.LevelDebug) {
+		t.Errorf("reload: Expected the debug level to be enabled")
+	} else if web := logHandler.WithAttrs([]slog.Attr{slog.String(loglevel.ComponentKey, componentWeb)}); web.Enabled(ctx, slog.LevelWarn) {
+		t.Errorf("reload: Expected the web's warnings to be disabled")
+	}
+	if want, got := next.LogLevels, r.args.LogLevels; want != got {
+		t.Errorf("reload: Expected LogLevels to be '%s' but got '%s'", want, got)
+	}
+}
+
+// TestReloadDisabled checks that the options of the features disabled on
+// startup aren't recorded as applied when the configuration is reloaded,
+// while the other options are.
+func TestReloadDisabled(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("jabberwock"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword: Failed to hash the password: %+v", err)
+	}
+
+	args := testArgs(t)
+	args.DryRun = true
+	r := &reloader{
+		args: args,
+		srv: &server{},
+	}
+
+	next := args
+	next.AuthBasicUsers = "alice:" + string(hash)
+	next.ChannelMaxPending = 10
+	next.ClientRate = 5
+	next.ClientBurst = 10
+	reloadFrom(t, r, next)
+
+	if len(r.args.AuthBasicUsers) != 0 {
+		t.Errorf("reload: Expected AuthBasicUsers to be kept empty but got '%s'", r.args.AuthBasicUsers)
+	}
+	if want, got := args.ChannelMaxPending, r.args.ChannelMaxPending; want != got {
+		t.Errorf("reload: Expected ChannelMaxPending to be kept at %d but got %d", want, got)
+	}
+	if want, got := next.ClientRate, r.args.ClientRate; want != got {
+		t.Errorf("reload: Expected ClientRate to be %v but got %v", want, got)
+	}
+	if r.srv.limiter.Load() == nil {
+		t.Errorf("reload: Expected the client rate limit to be applied")
+	}
+}
+
+// parseArgsFrom parses the options as if the server was started with cli,
+// restoring the CLI's state once the test ends.
+func parseArgsFrom(t *testing.T, cli ...string) Args {
+	prevArgs, prevFlags := os.Args, flag.CommandLine
+	prevConf, prevEnv, prevCommand := confFile, envArgs, commandFlags
+	t.Cleanup(func() {
+		os.Args, flag.CommandLine = prevArgs, prevFlags
+		confFile, envArgs, commandFlags = prevConf, prevEnv, prevCommand
+		argNotes = nil
+	})
+
+	os.Args = append([]string{"notifier"}, cli...)
+	flag.CommandLine = flag.NewFlagSet("notifier", flag.ContinueOnError)
+	confFile, commandFlags = "", nil
+	return parseArgs()
+}
+
+// writeConf writes data to a configuration file named name, retrieving its
+// path.
+func writeConf(t *testing.T, name, data string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("WriteFile: Failed to write the configuration: %+v", err)
+	}
+	return path
+}
+
+// TestEnvArgs checks that the options may be set by the environment, and
+// that the configuration file and the CLI override them (in this order).
+func TestEnvArgs(t *testing.T) {
+	conf := writeConf(t, "conf.json", `{"Port": 9002, "Region": "file-region"}`)
+
+	test_cases := []struct{
+		env map[string]string
+		cli []string
+		port int
+		queue string
+		region string
+	} {
+		{ port: 8888 },
+		{
+			env: map[string]string{"SQSNOTIFIER_PORT": "9001", "SQSNOTIFIER_QUEUE": "env-queue"},
+			port: 9001,
+			queue: "env-queue",
+		},
+		{
+			env: map[string]string{"SQSNOTIFIER_PORT": "9001", "SQSNOTIFIER_QUEUE": "env-queue", "SQSNOTIFIER_REGION": "env-region"},
+			cli: []string{"-confFile", conf},
+			port: 9002,
+			queue: "env-queue",
+			region: "file-region",
+		},
+		{
+			env: map[string]string{"SQSNOTIFIER_PORT": "9001", "SQSNOTIFIER_QUEUE": "env-queue"},
+			cli: []string{"-confFile", conf, "-Port", "9003", "-Queue", "cli-queue"},
+			port: 9003,
+			queue: "cli-queue",
+			region: "file-region",
+		},
+		{
+			env: map[string]string{"SQSNOTIFIER_PORT": "9001"},
+			cli: []string{"-Port", "9003"},
+			port: 9003,
+		},
+	}
+
+	// Register the variables to be restored once the test ends.
+	names := []string{"SQSNOTIFIER_PORT", "SQSNOTIFIER_QUEUE", "SQSNOTIFIER_REGION"}
+	for _, name := range names {
+		t.Setenv(name, "")
+	}
+
+	for i, tc := range test_cases {
+		for _, name := range names {
+			os.Unsetenv(name)
+		}
+		for name, val := range tc.env {
+			os.Setenv(name, val)
+		}
+
+		args := parseArgsFrom(t, tc.cli...)
+		if want, got := tc.port, args.Port; want != got {
+			t.Errorf("%d: Port: Expected %d but got %d", i, want, got)
+		}
+		if want, got := tc.queue, args.Queue; want != got {
+			t.Errorf("%d: Queue: Expected '%s' but got '%s'", i, want, got)
+		}
+		if want, got := tc.region, args.Region; want != got {
+			t.Errorf("%d: Region: Expected '%s' but got '%s'", i, want, got)
+		}
+	}
+}
+
+// TestConfFile checks that the configuration file is decoded according to
+// its format, that it's overridden by the CLI, and that malformed files are
+// rejected.
+func TestConfFile(t *testing.T) {
+	test_cases := []struct{
+		name string
+		data string
+		err bool
+	} {
+		{
+			name: "conf.json",
+			data: `{"Port": 9001, "queue": "the-queue", "Routes": [{"Channel": "alerts-*", "Destinations": ["a", "b"]}]}`,
+		},
+		{
+			name: "conf.yaml",
+			data: "# The server's port.\nPort: 9001\nqueue: the-queue\nRoutes:\n  - Channel: alerts-*\n    Destinations: [a, b]\n",
+		},
+		{
+			name: "conf.YML",
+			data: "Port: 9001\nQueue: the-queue\nRoutes:\n  - channel: alerts-*\n    destinations:\n      - a\n      - b\n",
+		},
+		{
+			name: "conf.toml",
+			data: "# The server's port.\nPort = 9001\nQueue = \"the-queue\"\n\n[[Routes]]\nChannel = \"alerts-*\"\nDestinations = [\"a\", \"b\"]\n",
+		},
+		{ name: "conf.json", data: `{"Port": 9001,`, err: true },
+		{ name: "conf.json", data: `{"Port": "not a port"}`, err: true },
+		{ name: "conf.yaml", data: "Port: [9001\n", err: true },
+		{ name: "conf.yaml", data: "Port: not a port\n", err: true },
+		{ name: "conf.toml", data: "Port = \n", err: true },
+		{ name: "conf.toml", data: "Port = \"not a port\"\n", err: true },
+	}
+
+	wantRoutes := []Route{{Channel: "alerts-*", Destinations: []string{"a", "b"}}}
+	for i, tc := range test_cases {
+		args := DefaultArgs()
+		err := decodeConfFile(writeConf(t, tc.name, tc.data), &args)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%d: decodeConfFile: Expected '%s' to be rejected", i, tc.data)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%d: decodeConfFile: Failed to decode the file: %+v", i, err)
+			continue
+		}
+
+		if want, got := 9001, args.Port; want != got {
+			t.Errorf("%d: Port: Expected %d but got %d", i, want, got)
+		}
+		if want, got := "the-queue", args.Queue; want != got {
+			t.Errorf("%d: Queue: Expected '%s' but got '%s'", i, want, got)
+		}
+		if !reflect.DeepEqual(wantRoutes, args.Routes) {
+			t.Errorf("%d: Routes: Expected %+v but got %+v", i, wantRoutes, args.Routes)
+		}
+		// Options missing from the file keep their defaults.
+		if want, got := DefaultArgs().TimeoutMS, args.TimeoutMS; want != got {
+			t.Errorf("%d: TimeoutMS: Expected %d but got %d", i, want, got)
+		}
+	}
+
+	// The CLI overrides the file, whatever its format.
+	conf := writeConf(t, "conf.yaml", "Port: 9001\nQueue: the-queue\n")
+	args := parseArgsFrom(t, "-confFile", conf, "-Port", "9003")
+	if want, got := 9003, args.Port; want != got {
+		t.Errorf("Port: Expected the CLI's %d but got %d", want, got)
+	}
+	if want, got := "the-queue", args.Queue; want != got {
+		t.Errorf("Queue: Expected the file's '%s' but got '%s'", want, got)
+	}
+
+	// Malformed files are reported when reloaded.
+	confFile = writeConf(t, "conf.toml", "Port = \n")
+	if _, err := loadConfFile(); err == nil {
+		t.Errorf("loadConfFile: Expected the malformed file to be rejected")
+	}
+}
+
+// TestValidateArgs checks that every problem in the options is reported,
+// all at once.
+func TestValidateArgs(t *testing.T) {
+	file := writeConf(t, "file", "Some file")
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing")
+
+	base := testArgs(t)
+	base.DryRun = true
+	if errs := validateArgs(base); len(errs) > 0 {
+		t.Fatalf("validateArgs: Expected the base options to be valid but got %+v", errs)
+	}
+
+	test_cases := []struct{
+		set func(args *Args)
+		errs []string
+	} {
+		{ set: func(args *Args) { args.Port = 0 }, errs: []string{"Port must be between"} },
+		{ set: func(args *Args) { args.GRPCPort = -1 }, errs: []string{"GRPCPort must be between"} },
+		{ set: func(args *Args) { args.GRPCPort = args.Port }, errs: []string{"GRPCPort must be different"} },
+		{
+			set: func(args *Args) { args.ACMEHosts, args.ACMEHTTPPort = "example.com", 65536 },
+			errs: []string{"ACMEHTTPPort must be between"},
+		},
+		{
+			set: func(args *Args) { args.ACMEHosts, args.CertFile, args.KeyFile = "example.com", file, file },
+			errs: []string{"Either ACMEHosts or CertFile/KeyFile"},
+		},
+		{ set: func(args *Args) { args.CertFile = file }, errs: []string{"CertFile and KeyFile must be set together"} },
+		{
+			set: func(args *Args) { args.AuthJWKSURL, args.AuthBasicUsers = "https://idp.example.com/jwks", "alice:hash" },
+			errs: []string{"Either AuthJWKSURL or AuthBasicUsers"},
+		},
+		{ set: func(args *Args) { args.AuthBasicUsers = "alice" }, errs: []string{"Invalid AuthBasicUsers/AuthBasicAdmins"} },
+		{ set: func(args *Args) { args.AuthBasicAdmins = "alice" }, errs: []string{"AuthBasicAdmins requires AuthBasicUsers"} },
+		{ set: func(args *Args) { args.AuthJWKSURL = "ftp://idp.example.com" }, errs: []string{"Invalid AuthJWKSURL"} },
+		{ set: func(args *Args) { args.LocalStore = "" }, errs: []string{"LocalStore must be set"} },
+		{ set: func(args *Args) { args.LocalStore = file }, errs: []string{"LocalStore isn't usable"} },
+		{
+			set: func(args *Args) {
+				args.Destinations = []Destination{{Name: "a", Type: destinationDryRun}}
+				args.Queue = "https://sqs.us-east-1.amazonaws.com/123456789012/queue"
+			},
+			errs: []string{"Either Destinations or Queue/CollectorAddr"},
+		},
+		{ set: func(args *Args) { args.DryRun = false }, errs: []string{"Invalid Queue"} },
+		{
+			set: func(args *Args) { args.DryRun, args.Queue = false, "https://sqs.us-east-1.amazonaws.com/queue" },
+			errs: []string{"Invalid Queue"},
+		},
+		{
+			set: func(args *Args) { args.Routes = []Route{{Channel: "*", Destinations: []string{"missing"}}} },
+			errs: []string{"Invalid Routes"},
+		},
+		{ set: func(args *Args) { args.Endpoint = "localhost:4566" }, errs: []string{"Invalid Endpoint"} },
+		{
+			set: func(args *Args) { args.VaultAddr, args.VaultAuthMethod, args.VaultToken = "vault:8200", "token", "secret" },
+			errs: []string{"Invalid VaultAddr"},
+		},
+		{
+			set: func(args *Args) { args.VaultAddr, args.VaultAuthMethod = "https://vault:8200", "token" },
+			errs: []string{"VaultToken must be set"},
+		},
+		{
+			set: func(args *Args) { args.VaultAddr, args.VaultAuthMethod, args.VaultRoleID = "https://vault:8200", "approle", "role" },
+			errs: []string{"VaultRoleID and VaultSecretID must be set"},
+		},
+		{
+			set: func(args *Args) { args.VaultAddr, args.VaultAuthMethod = "https://vault:8200", "kubernetes" },
+			errs: []string{"VaultK8sRole must be set"},
+		},
+		{
+			set: func(args *Args) { args.VaultAddr, args.VaultAuthMethod = "https://vault:8200", "magic" },
+			errs: []string{"VaultAuthMethod must be either"},
+		},
+		{ set: func(args *Args) { args.VaultAWSCredsPath = "aws/creds/role" }, errs: []string{"VaultAWSCredsPath requires VaultAddr"} },
+		{ set: func(args *Args) { args.AlertBacklog = 1 }, errs: []string{"Alert thresholds require"} },
+		{ set: func(args *Args) { args.AlertSlackWebhookURL = "hooks.slack.com" }, errs: []string{"AlertSlackWebhookURL must be"} },
+		{
+			set: func(args *Args) { args.AlertSMTPAddr = "smtp.example.com" },
+			errs: []string{"AlertSMTPAddr must be a", "AlertSMTPAddr requires AlertEmailFrom and AlertEmailTo"},
+		},
+		{
+			set: func(args *Args) {
+				args.AlertBacklog, args.AlertSlackWebhookURL = 1, "https://hooks.slack.com/services/T0"
+				args.AlertIntervalS = 0
+			},
+			errs: []string{"AlertIntervalS must be at least 1"},
+		},
+		{
+			set: func(args *Args) { args.CertFile, args.KeyFile = file, missing },
+			errs: []string{"KeyFile can't be read"},
+		},
+		{ set: func(args *Args) { args.ChannelSchemaDir = file }, errs: []string{"ChannelSchemaDir must be a directory"} },
+		{ set: func(args *Args) { args.MessageTemplateFile = dir }, errs: []string{"MessageTemplateFile must be a file"} },
+		{ set: func(args *Args) { args.LogFormat = "xml" }, errs: []string{"LogFormat must be either"} },
+		{ set: func(args *Args) { args.LogOutput = "stdlog" }, errs: []string{"LogOutput must be either"} },
+		{ set: func(args *Args) { args.LogLevel = "loud" }, errs: []string{"Invalid LogLevel/LogLevels"} },
+		{ set: func(args *Args) { args.LogLevels = "nowhere=debug" }, errs: []string{"Invalid LogLevel/LogLevels"} },
+		{ set: func(args *Args) { args.MetricsSink = "graphite" }, errs: []string{"MetricsSink must be either"} },
+		{ set: func(args *Args) { args.DuplicateStatus = http.StatusCreated }, errs: []string{"DuplicateStatus must be either"} },
+		{ set: func(args *Args) { args.HeartbeatMode = "ping" }, errs: []string{"HeartbeatMode must be either"} },
+		{ set: func(args *Args) { args.ChannelQuotaPolicy = "bogus" }, errs: []string{"Invalid channel quotas"} },
+		{ set: func(args *Args) { args.IPAllowList = "10.0.0.0/33" }, errs: []string{"IPAllowList must be"} },
+		{ set: func(args *Args) { args.IPDenyList = "nope" }, errs: []string{"IPDenyList must be"} },
+		{ set: func(args *Args) { args.TrustedProxies = "10.0.0.1,proxy" }, errs: []string{"TrustedProxies must be"} },
+		{ set: func(args *Args) { args.ForwarderWorkers = 0 }, errs: []string{"ForwarderWorkers must be at least 1"} },
+		{
+			set: func(args *Args) { args.ForwarderWatchdogS, args.ForwarderWatchdogIntervals = 1, 0 },
+			errs: []string{"ForwarderWatchdogIntervals must be at least 1"},
+		},
+		{ set: func(args *Args) { args.RetryJitter = 1.5 }, errs: []string{"RetryJitter must be between"} },
+		{ set: func(args *Args) { args.ForwarderBackoffJitter = -0.5 }, errs: []string{"ForwarderBackoffJitter must be between"} },
+		{
+			set: func(args *Args) { args.MetricsSink, args.StatsDIntervalS = metricsStatsD, 0 },
+			errs: []string{"StatsDIntervalS must be at least 1"},
+		},
+		{ set: func(args *Args) { args.TraceSampleRatio = 2 }, errs: []string{"TraceSampleRatio must be between"} },
+		// The destinations.
+		{
+			set: func(args *Args) {
+				args.Destinations = []Destination{
+					{ Type: destinationDryRun },
+					{ Name: "a", Type: destinationDryRun, TimeoutMS: -1 },
+					{ Name: "a", Type: destinationDryRun },
+				}
+			},
+			errs: []string{"Destinations[0]: Name must be set", "Destinations[1]: TimeoutMS must not be negative", "Destinations[2]: Name must be unique"},
+		},
+		{
+			set: func(args *Args) {
+				args.Destinations = []Destination{{ Name: "a", Queue: "queue", Endpoint: "localhost" }}
+			},
+			errs: []string{"Destinations[0]: Invalid Queue", "Destinations[0]: Invalid Endpoint"},
+		},
+		{
+			set: func(args *Args) {
+				args.Destinations = []Destination{{ Name: "a", Type: destinationGRPC, CAFile: missing }}
+			},
+			errs: []string{"Destinations[0]: Endpoint must be set", "Destinations[0]: CAFile can't be read"},
+		},
+		{
+			set: func(args *Args) {
+				args.Destinations = []Destination{{ Name: "a", Type: destinationGitHub, Repo: "owner", Endpoint: "github" }}
+				args.ChunkMessages = true
+			},
+			errs: []string{
+				"Destinations[0]: Repo must be set",
+				"Destinations[0]: Token (or GitHubToken) must be set",
+				"Destinations[0]: Invalid Endpoint",
+				"Destinations[0]: Issues can't be filed",
+			},
+		},
+		{
+			set: func(args *Args) {
+				args.Destinations = []Destination{{ Name: "a", Type: "carrier-pigeon" }}
+			},
+			errs: []string{"Destinations[0]: Type must be either"},
+		},
+		// Many problems are reported at once, in order.
+		{
+			set: func(args *Args) {
+				args.Port = 0
+				args.LocalStore = ""
+				args.LogFormat = "xml"
+				args.ForwarderWorkers = 0
+				args.TimeoutMS = -1
+			},
+			errs: []string{
+				"Port must be between",
+				"LocalStore must be set",
+				"LogFormat must be either",
+				"ForwarderWorkers must be at least 1",
+				"TimeoutMS must not be negative",
+			},
+		},
+	}
+
+	// Every option that must not be negative.
+	for _, name := range []string{
+		"TimeoutMS", "AWSTimeoutMS", "MaxBodyBytes", "ClientRate", "SendRate",
+		"MaxConnections", "MaxInFlightPosts", "ChannelMaxPending",
+		"ChannelMaxPerMinute", "HeartbeatMinutes", "AlertBacklog",
+		"AlertOldestAgeS", "AlertSendFailures", "AlertRepeatMinutes",
+		"IdempotencyWindowS", "ForwarderStuckS", "ForwarderWatchdogS",
+		"DrainOnExitS", "MaxInFlight", "ForwarderBackoffBaseMS",
+		"ForwarderBackoffMaxMS", "LogMaxSizeMB", "LogRotateHours",
+		"LogMaxBackups", "LogMaxAgeDays", "AuditLogMaxSizeMB",
+		"AuditLogRotateHours", "AuditLogMaxBackups", "AuditLogMaxAgeDays",
+	} {
+		name := name
+		test_cases = append(test_cases, struct{
+			set func(args *Args)
+			errs []string
+		} {
+			set: func(args *Args) {
+				field := reflect.ValueOf(args).Elem().FieldByName(name)
+				if field.CanInt() {
+					field.SetInt(-1)
+				} else {
+					field.SetFloat(-1)
+				}
+				// Negative thresholds are still thresholds.
+				args.AlertSlackWebhookURL = "https://hooks.slack.com/services/T0"
+			},
+			errs: []string{name + " must not be negative"},
+		})
+	}
+
+	for i, tc := range test_cases {
+		args := base
+		tc.set(&args)
+
+		errs := validateArgs(args)
+		if len(errs) != len(tc.errs) {
+			t.Errorf("%d: validateArgs: Expected %d problem(s) but got %+v", i, len(tc.errs), errs)
+			continue
+		}
+		for j, err := range errs {
+			if !strings.HasPrefix(err.Error(), tc.errs[j]) {
+				t.Errorf("%d: validateArgs: Expected problem %d to start with '%s' but got '%+v'", i, j, tc.errs[j], err)
+			}
+		}
+	}
+}
+
+// envTestCommand is set, in the subprocesses started by runCLI, to the
+// JSON-encoded arguments of the command run by TestCommandProcess.
+const envTestCommand = "NOTIFIER_TEST_COMMAND"
+
+// TestCommandProcess runs the command set in envTestCommand, just as the
+// binary would, exiting once it's done. It does nothing unless started by
+// runCLI.
+func TestCommandProcess(t *testing.T) {
+	raw, ok := os.LookupEnv(envTestCommand)
+	if !ok {
+		return
+	}
+
+	var cli []string
+	if err := json.Unmarshal([]byte(raw), &cli); err != nil {
+		t.Fatalf("Unmarshal: Failed to decode the command: %+v", err)
+	}
+	os.Args = append([]string{"notifier"}, cli...)
+	flag.CommandLine = flag.NewFlagSet("notifier", flag.ExitOnError)
+	Main()
+	os.Exit(0)
+}
+
+// runCLI runs the binary with cli, in a subprocess (as the commands exit
+// on failure), retrieving what it printed and its exit code.
+func runCLI(t *testing.T, cli ...string) (stdout string, stderr string, code int) {
+	data, err := json.Marshal(cli)
+	if err != nil {
+		t.Fatalf("Marshal: Failed to encode the command: %+v", err)
+	}
+
+	var out, errOut bytes.Buffer
+	cmd := exec.Command(os.Args[0], "-test.run=^TestCommandProcess$")
+	cmd.Env = append(os.Environ(), envTestCommand + "=" + string(data))
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+
+	var exitErr *exec.ExitError
+	err = cmd.Run()
+	if errors.As(err, &exitErr) {
+		code = exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatalf("Run: Failed to run '%v': %+v", cli, err)
+	}
+	return out.String(), errOut.String(), code
+}
+
+// TestCommands checks that the binary's arguments select the command that
+// is run, and that each command manages the local storage directly, or a
+// running server.
+func TestCommands(t *testing.T) {
+	dir := t.TempDir()
+
+	type request struct{ method, uri, auth, body string }
+	var lock sync.Mutex
+	var requests []request
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		lock.Lock()
+		requests = append(requests, request{req.Method, req.URL.RequestURI(), req.Header.Get("Authorization"), string(body)})
+		lock.Unlock()
+
+		if strings.HasSuffix(req.URL.Path, "/fail") {
+			http.Error(w, "Failed", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("Done"))
+	}))
+	defer ts.Close()
+
+	test_cases := []struct{
+		cli []string
+		code int
+		stdout string
+		stderr string
+		req *request
+	} {
+		// Dispatching the commands.
+		{ cli: []string{"bogus"}, code: 2, stderr: "Unknown command 'bogus'" },
+		{ cli: []string{"help"}, stdout: "Commands:\n  consume" },
+		{ cli: []string{"-Port", "0", "-LocalStore", dir}, code: 1, stderr: "Port must be between" },
+		{ cli: []string{"serve", "unexpected"}, code: 1, stderr: "Unexpected arguments" },
+		{ cli: []string{"list", "-Bogus"}, code: 2, stderr: "flag provided but not defined: -Bogus" },
+		// Managing the local storage directly.
+		{ cli: []string{"send", "-LocalStore", dir, "general"}, code: 2, stderr: "Usage: notifier send" },
+		{ cli: []string{"send", "-LocalStore", dir, "-Priority", "urgent", "general", "Beware"}, code: 1, stderr: "Invalid priority" },
+		{ cli: []string{"send", "-LocalStore", dir, "-Priority", "high", "general", "Beware", "the", "Jabberwock"}, stdout: "Stored: " },
+		{ cli: []string{"list", "-LocalStore", dir}, stdout: "Pending messages: 1\n" },
+		{ cli: []string{"list", "-LocalStore", dir, "unexpected"}, code: 1, stderr: "Unexpected arguments" },
+		{ cli: []string{"purge", "-LocalStore", dir, "missing-id"}, code: 1, stderr: "Failed to remove the message" },
+		{ cli: []string{"purge", "-LocalStore", dir}, stdout: "Removed messages: 1\n" },
+		{ cli: []string{"send", "-LocalStore", dir, "general", "The", "jaws", "that", "bite"}, stdout: "Stored: " },
+		{ cli: []string{"drain", "-LocalStore", dir, "-DryRun", "-Wait", "5s"}, stdout: "Sent: 1\nRemaining: 0\n" },
+		{ cli: []string{"list", "-LocalStore", dir}, stdout: "Pending messages: 0\n" },
+		{ cli: []string{"dlq", "-LocalStore", dir}, stdout: "Dead letters: 0\n" },
+		{ cli: []string{"dlq", "-LocalStore", dir, "requeue"}, stdout: "Requeued: 0\n" },
+		{ cli: []string{"dlq", "-LocalStore", dir, "purge"}, stdout: "Removed: 0\n" },
+		{ cli: []string{"dlq", "-LocalStore", dir, "shred"}, code: 1, stderr: "Unknown action" },
+		{ cli: []string{"dlq", "-LocalStore", dir, "list", "unexpected"}, code: 1, stderr: "Unexpected arguments" },
+		{ cli: []string{"validate-config", "-LocalStore", dir, "-DryRun"}, stdout: "\"LocalStore\": \"" + dir + "\"" },
+		{ cli: []string{"validate-config", "-LocalStore", dir, "-DryRun", "-Port", "0"}, code: 1, stderr: "Port must be between" },
+		{ cli: []string{"print-default-config", "-Format", "json"}, stdout: "\"Port\": 8888" },
+		{ cli: []string{"print-default-config", "-Format", "xml"}, code: 1, stderr: "Invalid format" },
+		// Managing a running server.
+		{
+			cli: []string{"send", "-URL", ts.URL, "-Token", "secret", "general", "Hello"},
+			stdout: "Done\n",
+			req: &request{http.MethodPost, "/message", "Bearer secret", `{"Channel":"general","Message":"Hello"}`},
+		},
+		{ cli: []string{"list", "-URL", ts.URL}, stdout: "Done\n", req: &request{http.MethodGet, "/message", "", ""} },
+		{ cli: []string{"drain", "-URL", ts.URL, "-Wait", "1m"}, req: &request{http.MethodPost, "/admin/flush?wait=1m0s", "", ""} },
+		{ cli: []string{"purge", "-URL", ts.URL, "an id"}, req: &request{http.MethodDelete, "/message/an%20id", "", ""} },
+		{ cli: []string{"purge", "-URL", ts.URL}, req: &request{http.MethodDelete, "/message", "", ""} },
+		{ cli: []string{"dlq", "-URL", ts.URL}, req: &request{http.MethodGet, "/deadletter", "", ""} },
+		{ cli: []string{"dlq", "-URL", ts.URL, "requeue", "id"}, req: &request{http.MethodPost, "/message/id/requeue", "", ""} },
+		{ cli: []string{"dlq", "-URL", ts.URL, "requeue"}, req: &request{http.MethodPost, "/deadletter/requeue", "", ""} },
+		{ cli: []string{"dlq", "-URL", ts.URL, "purge", "id"}, req: &request{http.MethodDelete, "/deadletter/id", "", ""} },
+		{ cli: []string{"dlq", "-URL", ts.URL, "purge"}, req: &request{http.MethodDelete, "/deadletter", "", ""} },
+		{
+			cli: []string{"purge", "-URL", ts.URL, "fail"},
+			code: 1,
+			stderr: "The server didn't accept the request",
+			req: &request{http.MethodDelete, "/message/fail", "", ""},
+		},
+	}
+
+	for i, tc := range test_cases {
+		lock.Lock()
+		requests = nil
+		lock.Unlock()
+
+		stdout, stderr, code := runCLI(t, tc.cli...)
+		if code != tc.code {
+			t.Errorf("%d: %v: Expected exit code %d but got %d (%s)", i, tc.cli, tc.code, code, stderr)
+			continue
+		}
+		if !strings.Contains(stdout, tc.stdout) {
+			t.Errorf("%d: %v: Expected the output to contain '%s' but got '%s'", i, tc.cli, tc.stdout, stdout)
+		}
+		if !strings.Contains(stderr, tc.stderr) {
+			t.Errorf("%d: %v: Expected the errors to contain '%s' but got '%s'", i, tc.cli, tc.stderr, stderr)
+		}
+
+		lock.Lock()
+		if tc.req != nil && (len(requests) != 1 || requests[0] != *tc.req) {
+			t.Errorf("%d: %v: Expected the request %+v but got %+v", i, tc.cli, *tc.req, requests)
+		} else if tc.req == nil && len(requests) > 0 {
+			t.Errorf("%d: %v: Expected no request but got %+v", i, tc.cli, requests)
+		}
+		lock.Unlock()
+	}
+}
+
+// clockServer starts a server whose clock is skew off from the local one.
+// If date is false, it doesn't report its time.
+func clockServer(t *testing.T, skew time.Duration, date bool) string {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if date {
+			w.Header().Set("Date", time.Now().Add(skew).UTC().Format(http.TimeFormat))
+		} else {
+			w.Header()["Date"] = nil
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+// TestDoctor checks each of the doctor's checks, and that it fails if any
+// of them failed.
+func TestDoctor(t *testing.T) {
+	dir := t.TempDir()
+	file := writeConf(t, "file", "Not a directory")
+	inUse := t.TempDir()
+	lock, err := lockStore(inUse)
+	if err != nil {
+		t.Fatalf("lockStore: Failed to lock the local storage: %+v", err)
+	}
+	defer lock.Close()
+
+	github := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/repos/owner/repo" {
+			http.Error(w, `{"message": "Not Found"}`, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"full_name": "owner/repo"}`))
+	}))
+	defer github.Close()
+	closed := httptest.NewServer(nil)
+	unreachable := closed.URL
+	closed.Close()
+
+	base := testArgs(t)
+	base.DryRun = true
+	base.LocalStore = dir
+	base.AWSTimeoutMS = 1000
+	// The destinations whose clock is compared.
+	clock := func(url string) func(args *Args) {
+		return func(args *Args) {
+			args.Destinations = []Destination{{Name: "sqs", Endpoint: url, Queue: url + "/000000000000/queue"}}
+		}
+	}
+
+	test_cases := []struct{
+		check func(d *doctor, args Args)
+		set func(args *Args)
+		lines []string
+		failed int
+		warned int
+	} {
+		{ check: (*doctor).checkConfig, lines: []string{"[PASS] Configuration: valid"} },
+		{
+			check: (*doctor).checkConfig,
+			set: func(args *Args) { args.Port, args.LogFormat = 0, "xml" },
+			lines: []string{"[FAIL] Configuration: Port must be", "[FAIL] Configuration: LogFormat must be"},
+			failed: 2,
+		},
+		{
+			check: (*doctor).checkStorage,
+			set: func(args *Args) { args.LocalStore = "" },
+			lines: []string{"[SKIP] Local storage: LocalStore isn't set"},
+		},
+		{
+			check: (*doctor).checkStorage,
+			lines: []string{"[PASS] Local storage: '" + dir + "' is writable", "[PASS] Local storage: "},
+		},
+		{
+			check: (*doctor).checkStorage,
+			set: func(args *Args) { args.LocalStore = filepath.Join(dir, "new") },
+			lines: []string{
+				"[WARN] Local storage: '" + filepath.Join(dir, "new") + "' doesn't exist",
+				"[PASS] Local storage: '" + filepath.Join(dir, "new") + "' is writable",
+				"[PASS] Local storage: ",
+			},
+			warned: 1,
+		},
+		{
+			check: (*doctor).checkStorage,
+			set: func(args *Args) { args.LocalStore = file },
+			lines: []string{"[FAIL] Local storage: '" + file + "' isn't a directory"},
+			failed: 1,
+		},
+		{
+			check: (*doctor).checkStorage,
+			set: func(args *Args) { args.LocalStore = inUse },
+			lines: []string{"[PASS] Local storage: '" + inUse + "' is writable", "[PASS] Local storage: ", "[PASS] Local storage: in use by a running server"},
+		},
+		{ check: (*doctor).checkDestinations, lines: []string{"[SKIP] Destinations: DryRun is set"} },
+		{
+			check: (*doctor).checkDestinations,
+			set: func(args *Args) {
+				args.DryRun = false
+				args.Destinations = []Destination{
+					{ Name: "log", Type: destinationDryRun },
+					{ Name: "collector", Type: destinationGRPC, Endpoint: "localhost:50051" },
+					{ Name: "issues", Type: destinationGitHub, Endpoint: github.URL, Repo: "owner/repo", Token: "token" },
+					{ Name: "missing", Type: destinationGitHub, Endpoint: github.URL, Repo: "owner/missing", Token: "token" },
+				}
+			},
+			lines: []string{
+				"[SKIP] Destination 'log': messages aren't delivered",
+				"[PASS] Destination 'collector': the collector at 'localhost:50051' is configured",
+				"[PASS] Destination 'issues': the repository 'owner/repo' is accessible",
+				"[FAIL] Destination 'missing': the repository 'owner/missing' doesn't exist",
+			},
+			failed: 1,
+		},
+		{ check: (*doctor).checkClock, set: clock(clockServer(t, 0, true)), lines: []string{"[PASS] Clock: "} },
+		{ check: (*doctor).checkClock, set: clock(clockServer(t, -time.Minute, true)), lines: []string{"[WARN] Clock: "}, warned: 1 },
+		{
+			check: (*doctor).checkClock,
+			set: clock(clockServer(t, time.Hour, true)),
+			lines: []string{"[FAIL] Clock: "},
+			failed: 1,
+		},
+		{ check: (*doctor).checkClock, set: clock(clockServer(t, 0, false)), lines: []string{"[WARN] Clock: "}, warned: 1 },
+		{ check: (*doctor).checkClock, set: clock(unreachable), lines: []string{"[WARN] Clock: couldn't reach"}, warned: 1 },
+	}
+
+	for i, tc := range test_cases {
+		args := base
+		if tc.set != nil {
+			tc.set(&args)
+		}
+
+		var out bytes.Buffer
+		d := doctor{out: &out}
+		tc.check(&d, args)
+
+		lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+		if len(lines) != len(tc.lines) {
+			t.Errorf("%d: Expected %d line(s) but got '%s'", i, len(tc.lines), out.String())
+			continue
+		}
+		for j, line := range lines {
+			if !strings.HasPrefix(line, tc.lines[j]) {
+				t.Errorf("%d: Expected line %d to start with '%s' but got '%s'", i, j, tc.lines[j], line)
+			}
+		}
+		if d.failed != tc.failed || d.warned != tc.warned {
+			t.Errorf("%d: Expected %d failure(s) and %d warning(s) but got %d and %d", i, tc.failed, tc.warned, d.failed, d.warned)
+		}
+	}
+
+	// The doctor only fails if any check failed.
+	stdout, stderr, code := runCLI(t, "doctor", "-DryRun", "-LocalStore", dir, "-AWSTimeoutMS", "100")
+	if code != 0 || !strings.Contains(stdout, "\n0 failed, ") {
+		t.Errorf("doctor: Expected it to pass but it exited with %d: %s%s", code, stdout, stderr)
+	}
+	stdout, stderr, code = runCLI(t, "doctor", "-DryRun", "-LocalStore", dir, "-AWSTimeoutMS", "100", "-Port", "0")
+	if code != 1 || !strings.Contains(stdout, "[FAIL] Configuration: Port must be") {
+		t.Errorf("doctor: Expected it to fail but it exited with %d: %s%s", code, stdout, stderr)
+	}
+	_, stderr, code = runCLI(t, "doctor", "unexpected")
+	if code != 1 || !strings.Contains(stderr, "Unexpected arguments") {
+		t.Errorf("doctor: Expected the arguments to be rejected but it exited with %d: %s", code, stderr)
+	}
+}
+
+// TestFormatBytes checks that sizes are formatted in the largest unit
+// below them.
+func TestFormatBytes(t *testing.T) {
+	test_cases := []struct{ n uint64; s string } {
+		{ n: 0, s: "0 B" },
+		{ n: 1023, s: "1023 B" },
+		{ n: 1024, s: "1.0 KiB" },
+		{ n: 1536, s: "1.5 KiB" },
+		{ n: 100 * 1024 * 1024, s: "100.0 MiB" },
+		{ n: 3 << 40, s: "3.0 TiB" },
+	}
+
+	for i, tc := range test_cases {
+		if want, got := tc.s, formatBytes(tc.n); want != got {
+			t.Errorf("%d: formatBytes(%d): Expected '%s' but got '%s'", i, tc.n, want, got)
+		}
+	}
+}
+
+// TestVersion checks that the build's information prefers the values set
+// when building, and that it's reported by the version command and by GET
+// /version.
+func TestVersion(t *testing.T) {
+	defer SetBuildInfo(version, commit, buildDate)
+
+	test_cases := []struct{
+		info buildInfo
+		s string
+	} {
+		{ info: buildInfo{Version: "devel", GoVersion: "go1.21"}, s: "devel with go1.21" },
+		{
+			info: buildInfo{Version: "v1.2.3", Commit: "abc123", BuildDate: "2024-05-10T00:00:00Z", GoVersion: "go1.21"},
+			s: "v1.2.3 (abc123) built on 2024-05-10T00:00:00Z with go1.21",
+		},
+		{
+			info: buildInfo{Version: "v1.2.3", Commit: "abc123", Modified: true, GoVersion: "go1.21"},
+			s: "v1.2.3 (abc123, modified) with go1.21",
+		},
+	}
+	for i, tc := range test_cases {
+		if want, got := tc.s, tc.info.String(); want != got {
+			t.Errorf("%d: String: Expected '%s' but got '%s'", i, want, got)
+		}
+	}
+
+	SetBuildInfo("v1.2.3", "abc123", "2024-05-10T00:00:00Z")
+	want := buildInfo{
+		Version: "v1.2.3",
+		Commit: "abc123",
+		BuildDate: "2024-05-10T00:00:00Z",
+		GoVersion: runtime.Version(),
+	}
+	if got := getBuildInfo(); got != want {
+		t.Errorf("getBuildInfo: Expected %+v but got %+v", want, got)
+	}
+
+	// Without a version, it's retrieved from the toolchain (which doesn't
+	// know it, in tests).
+	SetBuildInfo("", "abc123", "")
+	if got := getBuildInfo(); got.Version != "devel" || got.Commit != "abc123" || got.Modified {
+		t.Errorf("getBuildInfo: Expected the version to be 'devel' at commit 'abc123' but got %+v", got)
+	}
+
+	SetBuildInfo(want.Version, want.Commit, want.BuildDate)
+	srv := testWeb(t, testArgs(t), nil)
+	w := serve(srv, httptest.NewRequest(http.MethodGet, "/version", nil))
+	if w.Code != http.StatusOK || w.Body.String() != want.String() {
+		t.Errorf("GET /version: Expected '%s' but got %d '%s'", want, w.Code, w.Body)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/version", nil)
+	req.Header.Set("Accept", "application/json")
+	w = serve(srv, req)
+	var got buildInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || got != want {
+		t.Errorf("GET /version: Expected %+v but got '%s' (%+v)", want, w.Body, err)
+	} else if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("GET /version: Expected the Content-Type 'application/json' but got '%s'", ct)
+	}
+	w = serve(srv, httptest.NewRequest(http.MethodGet, "/version/extra", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("GET /version/extra: Expected status %d but got %d", http.StatusNotFound, w.Code)
+	}
+
+	// The command reports the toolchain's version, as the subprocess
+	// doesn't set the build's.
+	stdout, stderr, code := runCLI(t, "version")
+	if code != 0 || !strings.HasSuffix(stdout, " with " + runtime.Version() + "\n") {
+		t.Errorf("version: Expected the build's information but it exited with %d: %s%s", code, stdout, stderr)
+	}
+	_, stderr, code = runCLI(t, "version", "unexpected")
+	if code != 1 || !strings.Contains(stderr, "Unexpected arguments") {
+		t.Errorf("version: Expected the arguments to be rejected but it exited with %d: %s", code, stderr)
+	}
+}
+
+// TestDuplicateStatus checks that posting the same message twice replies
+// with the configured status, identifying the message already stored.
+func TestDuplicateStatus(t *testing.T) {
+	for i, status := range []int{http.StatusOK, http.StatusConflict} {
+		args := testArgs(t)
+		args.DuplicateStatus = status
+		srv := testWeb(t, args, nil)
+
+		post := func() (*httptest.ResponseRecorder, storedReply) {
+			req := httptest.NewRequest(http.MethodPost, "/message", strings.NewReader(`{"Channel": "general", "Message": "Callooh! Callay!"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(requestIDHeader, "frabjous-day")
+			w := serve(srv, req)
+
+			var reply storedReply
+			if err := json.Unmarshal(w.Body.Bytes(), &reply); err != nil {
+				t.Errorf("%d: POST: Failed to decode the reply '%s': %+v", i, w.Body, err)
+			}
+			return w, reply
+		}
+
+		// Messages are only identical within the same second, so start
+		// on a new one.
+		time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+		w, first := post()
+		if w.Code != http.StatusCreated || first.Duplicate {
+			t.Errorf("%d: POST: Expected the message to be stored but got %d '%s'", i, w.Code, w.Body)
+		}
+		w, second := post()
+		if w.Code != status || !second.Duplicate {
+			t.Errorf("%d: POST: Expected the duplicate to be replied with %d but got %d '%s'", i, status, w.Code, w.Body)
+		}
+		if second.ID != first.ID {
+			t.Errorf("%d: POST: Expected the duplicate to be identified as '%s' but got '%s'", i, first.ID, second.ID)
+		} else if want, got := "/message/" + url.PathEscape(first.ID), w.Header().Get("Location"); want != got {
+			t.Errorf("%d: POST: Expected the Location '%s' but got '%s'", i, want, got)
+		}
+		if want, got := 1, srv.store.Count(); want != got {
+			t.Errorf("%d: POST: Expected %d message to be stored but got %d", i, want, got)
+		}
+	}
+}
+
+// TestPause checks that forwarding may be paused from the start and resumed
+// (and paused again) by an administrator, and that /readyz and the metrics
+// report it.
+func TestPause(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cr3t"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword: Failed to hash the password: %+v", err)
+	}
+	args := testArgs(t)
+	args.AuthBasicUsers = "admin:" + string(hash) + ",user:" + string(hash)
+	args.AuthBasicAdmins = "admin"
+	args.Paused = true
+	s := sendertest.New()
+	url := startTestServer(t, args, WithSender(s))
+
+	do := func(method, path, user, body string) (int, string) {
+		req, _ := http.NewRequest(method, url + path, strings.NewReader(body))
+		req.SetBasicAuth(user, "s3cr3t")
+		req.Header.Set("Accept", "application/json")
+		if len(body) > 0 {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Do: Failed to send the request: %+v", err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+	check := func(step string, paused bool) {
+		var ready struct{ Paused bool }
+		code, body := do(http.MethodGet, "/readyz", "", "")
+		if err := json.Unmarshal([]byte(body), &ready); err != nil {
+			t.Fatalf("%s: GET /readyz: Failed to decode '%s': %+v", step, body, err)
+		} else if code != http.StatusOK || ready.Paused != paused {
+			t.Errorf("%s: GET /readyz: Expected 200 and paused %t but got %d '%s'", step, paused, code, body)
+		}
+
+		want := forwarderMetricsPrefix + "_paused 0"
+		if paused {
+			want = forwarderMetricsPrefix + "_paused 1"
+		}
+		if code, body := do(http.MethodGet, "/metrics", "admin", ""); code != http.StatusOK || !strings.Contains(body, want) {
+			t.Errorf("%s: GET /metrics: Expected '%s' but got %d '%s'", step, want, code, body)
+		}
+	}
+
+	check("start", true)
+	if code, body := do(http.MethodPost, "/message", "user", `{"Channel": "general", "Message": "Callooh! Callay!"}`); code != http.StatusCreated {
+		t.Fatalf("POST /message: Expected 201 but got %d '%s'", code, body)
+	}
+	if s.WaitFor(1, 200 * time.Millisecond) {
+		t.Errorf("Send: Expected the message to be kept while paused")
+	}
+
+	if code, body := do(http.MethodPost, "/admin/resume", "user", ""); code != http.StatusForbidden {
+		t.Errorf("POST /admin/resume: Expected a user to be forbidden but got %d '%s'", code, body)
+	}
+	check("forbidden", true)
+
+	test_cases := []struct{ path string; paused bool } {
+		{ path: "/admin/resume", paused: false },
+		{ path: "/admin/pause", paused: true },
+		{ path: "/admin/resume", paused: false },
+	}
+	for i, tc := range test_cases {
+		var state pauseState
+		code, body := do(http.MethodPost, tc.path, "admin", "")
+		if err := json.Unmarshal([]byte(body), &state); err != nil {
+			t.Fatalf("%d: POST %s: Failed to decode '%s': %+v", i, tc.path, body, err)
+		} else if code != http.StatusOK || state.Paused != tc.paused {
+			t.Errorf("%d: POST %s: Expected 200 and paused %t but got %d '%s'", i, tc.path, tc.paused, code, body)
+		}
+		check(fmt.Sprintf("%d", i), tc.paused)
+	}
+
+	if !s.WaitFor(1, 2 * time.Second) {
+		t.Errorf("Send: Expected the message to be forwarded once resumed")
+	}
+}
+
+// TestFeatures checks that each disabled feature's resources are replied
+// with 404 on the main address, but still served by the admin listener.
+func TestFeatures(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cr3t"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword: Failed to hash the password: %+v", err)
+	}
+
+	resources := map[string][]endpoint {
+		"count": { {"/message", http.MethodGet} },
+		"listing": { {"/message?list", http.MethodGet}, {"/deadletter", http.MethodGet} },
+		"admin": { {"/admin/pause", http.MethodPost} },
+		"webhooks": { {"/" + webhookResource + "/github", http.MethodPost}, {"/v2/enqueue", http.MethodPost} },
+		"events": { {"/events", http.MethodGet}, {"/" + dashboardResource, http.MethodGet} },
+		"metrics": { {"/metrics", http.MethodGet} },
+	}
+	disable := map[string]func(*Args) {
+		"count": func(args *Args) { args.EnableCount = false },
+		"listing": func(args *Args) { args.EnableListing = false },
+		"admin": func(args *Args) { args.EnableAdmin = false },
+		"webhooks": func(args *Args) { args.EnableWebhooks = false },
+		"events": func(args *Args) { args.EnableEvents = false },
+		"metrics": func(args *Args) { args.EnableMetrics = false },
+	}
+
+	// Messages and dead letters are only listed to administrators.
+	requires := map[string]string {
+		"listing": "admin",
+	}
+
+	// Replies with the status of requesting e from url (or 0, if it
+	// failed), without waiting for streams to end.
+	status := func(url string, e endpoint, user string) int {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, e.method, url + e.resource, nil)
+		if len(user) > 0 {
+			req.SetBasicAuth(user, "s3cr3t")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("Do: Failed to %s %s: %+v", e.method, e.resource, err)
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	test_cases := []struct{ disabled string } {
+		{ disabled: "" },
+		{ disabled: "count" },
+		{ disabled: "listing" },
+		{ disabled: "admin" },
+		{ disabled: "webhooks" },
+		{ disabled: "events" },
+		{ disabled: "metrics" },
+	}
+	for i, tc := range test_cases {
+		args := testArgs(t)
+		args.AuthBasicUsers = "admin:" + string(hash)
+		args.AuthBasicAdmins = "admin"
+		if len(tc.disabled) > 0 {
+			disable[tc.disabled](&args)
+		}
+		url := startTestServer(t, args)
+
+		for name, list := range resources {
+			for _, e := range list {
+				code := status(url, e, "admin")
+				disabled := len(tc.disabled) > 0 && (name == tc.disabled || requires[name] == tc.disabled)
+				if disabled && code != http.StatusNotFound {
+					t.Errorf("%d: %s %s: Expected the disabled %s to be replied with 404 but got %d", i, e.method, e.resource, name, code)
+				} else if !disabled && code == http.StatusNotFound {
+					t.Errorf("%d: %s %s: Expected the enabled %s to be served but got 404", i, e.method, e.resource, name)
+				}
+			}
+		}
+	}
+
+	// Everything is disabled on the main address, but not on the admin
+	// listener.
+	args := testArgs(t)
+	args.AdminAddr = net.JoinHostPort(args.IP, strconv.Itoa(freePort(t)))
+	for _, fn := range disable {
+		fn(&args)
+	}
+	url := startTestServer(t, args)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		c, err := net.Dial("tcp", args.AdminAddr)
+		if err == nil {
+			c.Close()
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("Run: The admin listener didn't start: %+v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for name, list := range resources {
+		for _, e := range list {
+			if code := status(url, e, ""); code != http.StatusNotFound {
+				t.Errorf("%s %s: Expected the disabled %s to be replied with 404 on the main address but got %d", e.method, e.resource, name, code)
+			}
+			if code := status("http://" + args.AdminAddr, e, ""); code == http.StatusNotFound {
+				t.Errorf("%s %s: Expected the admin listener to serve the disabled %s but got 404", e.method, e.resource, name)
+			}
+		}
+	}
+}
+
+// TestStatus checks that /status is only replied to administrators, with
+// every field of the snapshot.
+func TestStatus(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cr3t"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword: Failed to hash the password: %+v", err)
+	}
+	args := testArgs(t)
+	args.AuthBasicUsers = "admin:" + string(hash) + ",user:" + string(hash)
+	args.AuthBasicAdmins = "admin"
+	args.Paused = true
+	url := startTestServer(t, args)
+
+	do := func(method, path, user, body string) (int, []byte) {
+		req, _ := http.NewRequest(method, url + path, strings.NewReader(body))
+		if len(user) > 0 {
+			req.SetBasicAuth(user, "s3cr3t")
+		}
+		if len(body) > 0 {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Do: Failed to send the request: %+v", err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, data
+	}
+
+	// Lists the fields of the JSON object in data, sorted.
+	fields := func(name string, data []byte) []string {
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(data, &obj); err != nil {
+			t.Fatalf("%s: Failed to decode '%s': %+v", name, data, err)
+		}
+		var list []string
+		for k := range obj {
+			list = append(list, k)
+		}
+		slices.Sort(list)
+		return list
+	}
+
+	if code, body := do(http.MethodPost, "/message", "user", `{"Channel": "general", "Message": "Callooh! Callay!"}`); code != http.StatusCreated {
+		t.Fatalf("POST /message: Expected 201 but got %d '%s'", code, body)
+	}
+
+	test_cases := []struct{ user string; status int } {
+		{ user: "", status: http.StatusUnauthorized },
+		{ user: "user", status: http.StatusForbidden },
+		{ user: "admin", status: http.StatusOK },
+	}
+	var body []byte
+	for i, tc := range test_cases {
+		var code int
+		code, body = do(http.MethodGet, "/status", tc.user, "")
+		if code != tc.status {
+			t.Errorf("%d: GET /status: Expected %d but got %d '%s'", i, tc.status, code, body)
+		}
+	}
+
+	var st struct {
+		Build buildInfo
+		StartedAt time.Time
+		UptimeS float64
+		Config json.RawMessage
+		Store json.RawMessage
+		Channels map[string]channelStats
+		Health string
+		Components []struct{ Component string; Status string }
+		Forwarder json.RawMessage
+		Draining bool
+		Time time.Time
+	}
+	if err := json.Unmarshal(body, &st); err != nil {
+		t.Fatalf("GET /status: Failed to decode '%s': %+v", body, err)
+	}
+
+	shapes := []struct{ name string; data []byte; fields []string } {
+		{ name: "status", data: body, fields: []string{"Build", "Channels", "Components", "Config", "Draining", "Forwarder", "Health", "RecentFailures", "StartedAt", "Store", "Time", "UptimeS"} },
+		{ name: "Config", data: st.Config, fields: []string{"BreakerThreshold", "DeadLetter", "Destinations", "DrainOnExitS", "DryRun", "ForwarderWorkers", "LocalStore", "MaxInFlight"} },
+		{ name: "Store", data: st.Store, fields: []string{"Backlog", "Bytes", "DeadLetters", "OldestAgeS", "Quarantined"} },
+		{ name: "Forwarder", data: st.Forwarder, fields: []string{"BackoffS", "BusyS", "InFlight", "LastActive", "Paused", "Sent"} },
+	}
+	for _, tc := range shapes {
+		if got := fields(tc.name, tc.data); !slices.Equal(got, tc.fields) {
+			t.Errorf("%s: Expected the fields %v but got %v", tc.name, tc.fields, got)
+		}
+	}
+
+	var cfg statusConfig
+	var store storeStatus
+	var fw forwarderStatus
+	if err := json.Unmarshal(st.Config, &cfg); err != nil {
+		t.Errorf("Config: Failed to decode '%s': %+v", st.Config, err)
+	} else if err := json.Unmarshal(st.Store, &store); err != nil {
+		t.Errorf("Store: Failed to decode '%s': %+v", st.Store, err)
+	} else if err := json.Unmarshal(st.Forwarder, &fw); err != nil {
+		t.Errorf("Forwarder: Failed to decode '%s': %+v", st.Forwarder, err)
+	}
+
+	if st.Build != getBuildInfo() {
+		t.Errorf("Build: Expected %+v but got %+v", getBuildInfo(), st.Build)
+	}
+	if st.StartedAt.IsZero() || st.UptimeS <= 0 || st.Time.Before(st.StartedAt) {
+		t.Errorf("Uptime: Expected to be up since %s for %fs, at %s", st.StartedAt, st.UptimeS, st.Time)
+	}
+	if cfg.LocalStore != args.LocalStore || cfg.ForwarderWorkers != args.ForwarderWorkers || cfg.DrainOnExitS != args.DrainOnExitS {
+		t.Errorf("Config: Unexpected summary %+v", cfg)
+	}
+	if store.Backlog != 1 || store.Bytes <= 0 || store.OldestAgeS <= 0 {
+		t.Errorf("Store: Expected the message to be kept but got %+v", store)
+	}
+	if want := (channelStats{Stored: 1}); st.Channels["general"] != want {
+		t.Errorf("Channels: Expected %+v but got %+v", want, st.Channels)
+	}
+	if st.Health != "degraded" || len(st.Components) != 3 {
+		t.Errorf("Health: Expected the paused forwarder to be degraded but got '%s' %+v", st.Health, st.Components)
+	}
+	if !fw.Paused || fw.InFlight != 0 || fw.Sent != 0 {
+		t.Errorf("Forwarder: Expected to be paused but got %+v", fw)
+	}
+	if st.Draining {
+		t.Errorf("Draining: Expected not to be draining")
+	}
+}
+
+// gatedSender is a sendertest.Sender whose attempts, after the first one,
+// wait for its gate to be closed.
+type gatedSender struct {
+	*sendertest.Sender
+	gate chan struct{}
+}
+
+func (g *gatedSender) Send(msg sender.Message) (sender.SendResult, error) {
+	if g.Attempts() > 0 {
+		<-g.gate
+	}
+	return g.Sender.Send(msg)
+}
+
+// TestDrainOnExit checks that, if DrainOnExitS is set, stopping the server
+// refuses new messages and sends the backlog (even while backing off)
+// before returning, and that it's kept otherwise.
+func TestDrainOnExit(t *testing.T) {
+	test_cases := []struct{ drainS int; sent int; kept int } {
+		{ drainS: 0, sent: 0, kept: 1 },
+		{ drainS: 5, sent: 1, kept: 0 },
+	}
+
+	for i, tc := range test_cases {
+		args := testArgs(t)
+		args.DrainOnExitS = tc.drainS
+		args.RetryMaxAttempts = 1
+		args.ForwarderBackoffBaseMS = 60000
+		args.ForwarderBackoffMaxMS = 60000
+		args.ForwarderBackoffJitter = 0
+		store := local_storage.NewFS(t.TempDir(), 0)
+		defer store.Close()
+		s := &gatedSender{Sender: sendertest.New(), gate: make(chan struct{})}
+		s.FailNext(1, sender.ErrTemporary)
+		url := "http://" + net.JoinHostPort(args.IP, strconv.Itoa(args.Port))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- Run(ctx, WithArgs(args), WithStore(store), WithSender(s))
+		} ()
+
+		post := func() int {
+			resp, err := http.Post(url + "/message", "application/json", strings.NewReader(`{"Channel": "general", "Message": "Callooh! Callay!"}`))
+			if err != nil {
+				return 0
+			}
+			resp.Body.Close()
+			return resp.StatusCode
+		}
+
+		// Wait for the server to start, and then for the forwarder to
+		// fail and back off.
+		deadline := time.Now().Add(2 * time.Second)
+		for post() != http.StatusCreated {
+			if time.Now().After(deadline) {
+				t.Fatalf("%d: POST: The server didn't start", i)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		for s.Attempts() == 0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("%d: Send: The message wasn't attempted", i)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		cancel()
+		if tc.drainS > 0 {
+			// While draining, new messages are refused.
+			for code := post(); code != http.StatusServiceUnavailable; code = post() {
+				if time.Now().After(deadline) {
+					t.Fatalf("%d: POST: Expected 503 while draining but got %d", i, code)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			select {
+			case <-done:
+				t.Fatalf("%d: Run: Expected to wait for the backlog to be sent", i)
+			default:
+			}
+		}
+		close(s.gate)
+
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("%d: Run: Expected to stop cleanly but got %+v", i, err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%d: Run: The server didn't stop", i)
+		}
+
+		if got := len(s.Messages()); got != tc.sent {
+			t.Errorf("%d: Send: Expected %d message to be sent but got %d", i, tc.sent, got)
+		}
+		if got := store.Count(); got != tc.kept {
+			t.Errorf("%d: Count: Expected %d message to be kept but got %d", i, tc.kept, got)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package notifier
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	jsonschema "github.com/santhosh-tekuri/jsonschema/v5"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// openAPISpec is the OpenAPI document describing the server's API. It must
+// be kept up to date whenever an endpoint changes, as it's also used to
+// validate the requests.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// The URL used to identify the OpenAPI document within the schema
+// compiler.
+const openAPIURL = "openapi.json"
+
+// validationDetail describes a single invalid value in a request.
+type validationDetail struct {
+	// JSON pointer to the invalid value.
+	Location string
+
+	// Why the value is invalid.
+	Message string
+}
+
+// validationError is the response sent for requests that don't match the
+// OpenAPI document.
+type validationError struct {
+	// Summary of the error.
+	Error string
+
+	// Every invalid value in the request.
+	Details []validationDetail `json:",omitempty"`
+}
+
+// validationRoute associates a path (and method) in the OpenAPI document
+// to the schema of its request body.
+type validationRoute struct {
+	// The HTTP method.
+	method string
+
+	// The path, split on each "/". Path parameters (e.g., "{id}") match
+	// any segment.
+	path []string
+
+	// The schema of the request body.
+	schema *jsonschema.Schema
+}
+
+// requestValidator validates request bodies against the OpenAPI document.
+type requestValidator struct {
+	routes []validationRoute
+}
+
+// newRequestValidator compiles the request body schema of every operation
+// in the OpenAPI document spec.
+func newRequestValidator(spec []byte) (*requestValidator, error) {
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage
+	}
+	err := json.Unmarshal(spec, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse the OpenAPI document: %w", err)
+	}
+
+	c := jsonschema.NewCompiler()
+	c.Draft = jsonschema.Draft2020
+	err = c.AddResource(openAPIURL, bytes.NewReader(spec))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load the OpenAPI document: %w", err)
+	}
+
+	var v requestValidator
+	for path, ops := range doc.Paths {
+		for method, raw := range ops {
+			var op struct {
+				RequestBody struct {
+					Content map[string]json.RawMessage
+				}
+			}
+			// Skip anything that isn't an operation (e.g., the path's
+			// parameters).
+			if json.Unmarshal(raw, &op) != nil {
+				continue
+			} else if _, ok := op.RequestBody.Content["application/json"]; !ok {
+				continue
+			}
+
+			ptr := fmt.Sprintf("%s#/paths/%s/%s/requestBody/content/application~1json/schema",
+					openAPIURL, escapePointer(path), method)
+			schema, err := c.Compile(ptr)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't compile the schema for '%s %s': %w", method, path, err)
+			}
+
+			v.routes = append(v.routes, validationRoute{
+				method: strings.ToUpper(method),
+				path: strings.Split(strings.Trim(path, "/"), "/"),
+				schema: schema,
+			})
+		}
+	}
+
+	return &v, nil
+}
+
+// escapePointer escapes s to be used as a token in a JSON pointer.
+func escapePointer(s string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(s)
+}
+
+// match retrieves the schema of the request body for method on res. It
+// returns nil if the request body isn't described.
+func (v *requestValidator) match(method string, res []string) *jsonschema.Schema {
+	for _, route := range v.routes {
+		if route.method != method || len(route.path) != len(res) {
+			continue
+		}
+
+		ok := true
+		for i, seg := range route.path {
+			if !strings.HasPrefix(seg, "{") && seg != res[i] {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return route.schema
+		}
+	}
+
+	return nil
+}
+
+// wrap next, so its request body is validated before it's called. Invalid
+// requests are replied with a validationError.
+func (v *requestValidator) wrap(next endpointHandler) endpointHandler {
+	return func(w http.ResponseWriter, req *http.Request, res []string) {
+		// Other media types are validated by the handler itself.
+		schema := v.match(req.Method, res)
+		if schema == nil || requestMediaType(req) != mediaJSON {
+			next(w, req, res)
+			return
+		}
+
+		var tooLarge *http.MaxBytesError
+
+		body, err := io.ReadAll(req.Body)
+		if errors.As(err, &tooLarge) {
+			serr := fmt.Sprintf("The request body is larger than %d bytes", tooLarge.Limit)
+			httpTextReply(http.StatusRequestEntityTooLarge, serr, w)
+			reqLogger(req).Info(serr)
+			return
+		} else if err != nil {
+			serr := "Failed to read the request"
+			httpTextReply(http.StatusBadRequest, serr, w)
+			reqLogger(req).Error(serr, "err", err)
+			return
+		}
+
+		var data interface{}
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		err = dec.Decode(&data)
+		if err != nil {
+			validationReply(w, req, res, validationError{
+				Error: fmt.Sprintf("The request body isn't valid JSON: %+v", err),
+			})
+			return
+		}
+
+		err = schema.Validate(foldKeys(data, schema))
+		if ve, ok := err.(*jsonschema.ValidationError); ok {
+			resp := validationError{
+				Error: "The request body doesn't match the schema",
+			}
+			collectDetails(ve, &resp.Details)
+			validationReply(w, req, res, resp)
+			return
+		} else if err != nil {
+			serr := "Failed to validate the request"
+			httpTextReply(http.StatusInternalServerError, serr, w)
+			reqLogger(req).Error(serr, "err", err)
+			return
+		}
+
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		next(w, req, res)
+	}
+}
+
+// foldKeys renames the keys of every object in data to match the
+// properties in schema case-insensitively, as done by encoding/json when
+// decoding the request.
+func foldKeys(data interface{}, schema *jsonschema.Schema) interface{} {
+	if schema == nil {
+		return data
+	}
+	for schema.Ref != nil {
+		schema = schema.Ref
+	}
+
+	switch v := data.(type) {
+	case map[string]interface{}:
+		folded := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			prop := schema.Properties[key]
+			if prop == nil {
+				for name, s := range schema.Properties {
+					if strings.EqualFold(name, key) {
+						key, prop = name, s
+						break
+					}
+				}
+			}
+			folded[key] = foldKeys(val, prop)
+		}
+		return folded
+	case []interface{}:
+		for i := range v {
+			v[i] = foldKeys(v[i], schema.Items2020)
+		}
+		return v
+	default:
+		return data
+	}
+}
+
+// collectDetails appends the innermost causes of ve to details, as those
+// describe what's actually wrong with the request.
+func collectDetails(ve *jsonschema.ValidationError, details *[]validationDetail) {
+	if len(ve.Causes) == 0 {
+		loc := ve.InstanceLocation
+		if len(loc) == 0 {
+			loc = "/"
+		}
+		*details = append(*details, validationDetail{
+			Location: loc,
+			Message: ve.Message,
+		})
+		return
+	}
+
+	for _, cause := range ve.Causes {
+		collectDetails(cause, details)
+	}
+}
+
+// validationReply replies to an invalid request with resp.
+func validationReply(w http.ResponseWriter, req *http.Request, res []string, resp validationError) {
+	reqLogger(req).Info(resp.Error, "details", resp.Details)
+
+	data, err := json.Marshal(&resp)
+	if err != nil {
+		httpTextReply(http.StatusBadRequest, resp.Error, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	writeData(data, w)
+}
+
+// GetOpenAPI handles GET requests on the 'openapi.json' resource, replying
+// with the OpenAPI document describing the server's API.
+func (s *server) GetOpenAPI(w http.ResponseWriter, req *http.Request, res []string) {
+	if len(res) > 1 {
+		httpTextReply(http.StatusNotFound, "Invalid resource", w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	writeData(openAPISpec, w)
+}
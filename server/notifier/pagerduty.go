@@ -0,0 +1,95 @@
+package notifier
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/SirGFM/sqs-issue-notifier/server/auth"
+	"github.com/SirGFM/sqs-issue-notifier/server/webhook"
+	"net/http"
+)
+
+// pagerDutyReply is the reply of PagerDuty's Events API v2.
+type pagerDutyReply struct {
+	Status string `json:"status"`
+	Message string `json:"message"`
+	DedupKey string `json:"dedup_key,omitempty"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// writePagerDutyReply sends reply, as JSON, with the given status code.
+func writePagerDutyReply(w http.ResponseWriter, req *http.Request, status int, reply pagerDutyReply) {
+	data, err := json.Marshal(&reply)
+	if err != nil {
+		reqLogger(req).Error("Failed to encode the reply", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	writeData(data, w)
+}
+
+// PostPagerDutyEvent handles POST requests on 'v2/enqueue', accepting
+// events in the format of PagerDuty's Events API v2, so tools that send
+// their alerts to PagerDuty may send them through the notifier instead.
+// The event's routing key is used as the channel.
+func (s *server) PostPagerDutyEvent(w http.ResponseWriter, req *http.Request, res []string) {
+	if len(res) != 2 || res[1] != "enqueue" {
+		httpTextReply(http.StatusNotFound, "Invalid resource", w)
+		return
+	}
+
+	var ev webhook.PagerDutyEvent
+	err := json.NewDecoder(req.Body).Decode(&ev)
+	if tooLarge := (*http.MaxBytesError)(nil); errors.As(err, &tooLarge) {
+		serr := fmt.Sprintf("The request body is larger than %d bytes", tooLarge.Limit)
+		httpTextReply(http.StatusRequestEntityTooLarge, serr, w)
+		reqLogger(req).Info(serr)
+		return
+	} else if err != nil {
+		reqLogger(req).Info("Failed to parse the event", "err", err)
+		writePagerDutyReply(w, req, http.StatusBadRequest, pagerDutyReply{
+			Status: "invalid event",
+			Message: "Event object is invalid",
+			Errors: []string{"Invalid JSON."},
+		})
+		return
+	}
+
+	if problems := ev.Validate(); len(problems) > 0 {
+		reqLogger(req).Info("Invalid event", "errors", problems)
+		writePagerDutyReply(w, req, http.StatusBadRequest, pagerDutyReply{
+			Status: "invalid event",
+			Message: "Event object is invalid",
+			Errors: problems,
+		})
+		return
+	}
+
+	if p, ok := auth.FromContext(req.Context()); ok && !p.CanPost(ev.RoutingKey) {
+		serr := fmt.Sprintf("Not allowed to post to '%s'", ev.RoutingKey)
+		httpTextReply(http.StatusForbidden, serr, w)
+		reqLogger(req).Info(serr, "subject", p.Subject)
+		return
+	}
+
+	// As done by PagerDuty, new alerts are identified by a random key, so
+	// they may be resolved later.
+	if len(ev.DedupKey) == 0 {
+		var key [16]byte
+		rand.Read(key[:])
+		ev.DedupKey = hex.EncodeToString(key[:])
+	}
+
+	if _, ok := s.storeMessage(w, req, res, notificationMessage(ev.Notification())); ok {
+		writePagerDutyReply(w, req, http.StatusAccepted, pagerDutyReply{
+			Status: "success",
+			Message: "Event processed",
+			DedupKey: ev.DedupKey,
+		})
+	}
+}
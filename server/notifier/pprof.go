@@ -0,0 +1,42 @@
+package notifier
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+)
+
+// pprofMux creates a mux exposing the net/http/pprof handlers. A dedicated
+// mux is used, so the profiles are never reachable through the main
+// server.
+func pprofMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// startPprof launches an HTTP server on addr exposing the net/http/pprof
+// handlers.
+func startPprof(addr string) *http.Server {
+	srv := &http.Server {
+		Addr: addr,
+		Handler: pprofMux(),
+	}
+
+	go func() {
+		slog.Info("Serving pprof", "addr", addr)
+		l, err := listen(addr)
+		if err == nil {
+			err = srv.Serve(l)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			slog.Error("The pprof server failed", "err", err)
+		}
+	} ()
+
+	return srv
+}
@@ -0,0 +1,144 @@
+package notifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/SirGFM/sqs-issue-notifier/server/chanquota"
+	"github.com/SirGFM/sqs-issue-notifier/server/local_storage"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// newChannelQuotas creates the quotas that limit each channel's messages,
+// as configured by args. It returns nil if no channel is limited.
+func newChannelQuotas(args Args) (*chanquota.Quotas, error) {
+	defaults, channels, err := channelLimits(args)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load the channel quotas: %w", err)
+	}
+
+	if defaults.MaxPending <= 0 && defaults.MaxPerMinute <= 0 && len(channels) == 0 {
+		return nil, nil
+	}
+	return chanquota.New(defaults, channels), nil
+}
+
+// channelLimits retrieves the limits of every channel, as configured by
+// args: the default limits, and the limits of each channel in
+// args.ChannelQuotaFile.
+func channelLimits(args Args) (chanquota.Limits, map[string]chanquota.Limits, error) {
+	defaults := chanquota.Limits{
+		MaxPending: args.ChannelMaxPending,
+		MaxPerMinute: args.ChannelMaxPerMinute,
+	}
+	if len(args.ChannelQuotaPolicy) > 0 {
+		err := defaults.Policy.UnmarshalText([]byte(args.ChannelQuotaPolicy))
+		if err != nil {
+			return defaults, nil, fmt.Errorf("invalid ChannelQuotaPolicy '%s': %w", args.ChannelQuotaPolicy, err)
+		}
+	}
+
+	var channels map[string]chanquota.Limits
+	if len(args.ChannelQuotaFile) > 0 {
+		var err error
+
+		channels, err = chanquota.Load(args.ChannelQuotaFile, defaults)
+		if err != nil {
+			return defaults, nil, err
+		}
+		slog.Info("Loaded the channel quotas", "channels", len(channels))
+	}
+
+	return defaults, channels, nil
+}
+
+// trackQuotas keeps q up to date with the messages pending in store,
+// starting with the messages already stored. It must be called before the
+// messages start being sent.
+func trackQuotas(q *chanquota.Quotas, store local_storage.Store) {
+	if q == nil {
+		return
+	}
+
+	channelOf := func(data []byte) string {
+		var msg message
+		json.Unmarshal(data, &msg)
+		return msg.Channel
+	}
+
+	store.OnEvent(func(ev local_storage.Event) {
+		switch ev.Type {
+		case local_storage.EventStored, local_storage.EventRequeued:
+			entry, err := store.Lookup(ev.ID)
+			if err == nil {
+				q.Add(channelOf(entry.Bytes), ev.ID)
+			}
+		case local_storage.EventRemoved, local_storage.EventPurged, local_storage.EventDeadLettered, local_storage.EventQuarantined:
+			q.Remove(ev.ID)
+		}
+	})
+
+	entries, err := store.Entries()
+	if err != nil {
+		slog.Warn("Couldn't count each channel's pending messages", "err", err)
+		return
+	}
+	for _, entry := range entries {
+		q.Add(channelOf(entry.Bytes), entry.ID)
+	}
+}
+
+// admitMessage checks whether a new message may be accepted for channel,
+// according to its quota, replying with 429 otherwise. If the channel's
+// oldest pending messages must be dropped to make room for the new one,
+// they're removed from the local storage.
+func (s *server) admitMessage(w http.ResponseWriter, req *http.Request, channel string) bool {
+	retryAfter, err := s.admit(reqLogger(req), channel)
+	if err == chanquota.ErrRateExceeded {
+		secs := int64((retryAfter + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+		serr := fmt.Sprintf("Too many messages sent to '%s'", channel)
+		httpTextReply(http.StatusTooManyRequests, serr, w)
+		reqLogger(req).Info(serr)
+		return false
+	} else if err == chanquota.ErrPendingExceeded {
+		serr := fmt.Sprintf("Too many pending messages for '%s'", channel)
+		httpTextReply(http.StatusTooManyRequests, serr, w)
+		reqLogger(req).Info(serr)
+		return false
+	}
+
+	return true
+}
+
+// admit checks whether a new message may be accepted for channel,
+// according to its quota, failing with the quota's error otherwise (along
+// with when the client may retry, if it exceeded the channel's rate). If
+// the channel's oldest pending messages must be dropped to make room for
+// the new one, they're removed from the local storage, logged to logger.
+func (s *server) admit(logger *slog.Logger, channel string) (time.Duration, error) {
+	if s.quotas == nil {
+		return 0, nil
+	}
+
+	drop, retryAfter, err := s.quotas.Admit(channel)
+	if err != nil {
+		return retryAfter, err
+	}
+
+	for _, id := range drop {
+		err := s.store.RemoveByID(id)
+		if err == local_storage.ErrNotFound {
+			// It was removed without the quotas noticing it.
+			s.quotas.Remove(id)
+		} else if err != nil {
+			logger.Warn("Couldn't drop the channel's oldest message", "channel", channel, "id", id, "err", err)
+		} else {
+			logger.Warn("Dropped the channel's oldest message", "channel", channel, "id", id)
+		}
+	}
+
+	return 0, nil
+}
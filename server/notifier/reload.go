@@ -0,0 +1,238 @@
+package notifier
+
+import (
+	"github.com/SirGFM/sqs-issue-notifier/server/auth"
+	"github.com/SirGFM/sqs-issue-notifier/server/chanquota"
+	"github.com/SirGFM/sqs-issue-notifier/server/clientlimit"
+	"github.com/SirGFM/sqs-issue-notifier/server/forwarder"
+	"github.com/SirGFM/sqs-issue-notifier/server/local_storage"
+	"github.com/SirGFM/sqs-issue-notifier/server/loglevel"
+	"github.com/SirGFM/sqs-issue-notifier/server/sender"
+	"github.com/SirGFM/sqs-issue-notifier/server/sendermw"
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// reloadableArgs lists the options that may be changed at runtime, when
+// the configuration is reloaded. Changes to other options are only applied
+// once the server is restarted. That includes the remaining timeouts:
+//   - ReadHeaderTimeoutS, ReadTimeoutS, WriteTimeoutS and IdleTimeoutS are
+//     read by the http.Server from every connection's goroutine, without
+//     any synchronization, so they can't be changed while it's serving;
+//   - AWSTimeoutMS is the timeout of the AWS session's HTTP client, shared
+//     by every AWS client created from it (and likewise unsynchronized);
+//   - the destinations' TimeoutMS is part of Destinations, whose senders
+//     are only created on startup;
+//   - ForwarderStuckS and ForwarderWatchdogS enable (or disable) the
+//     goroutines that watch the forwarder, which are only started on
+//     startup.
+var reloadableArgs = map[string]bool{
+	"ClientRate": true,
+	"ClientBurst": true,
+	"ChannelQuotaFile": true,
+	"ChannelMaxPending": true,
+	"ChannelMaxPerMinute": true,
+	"ChannelQuotaPolicy": true,
+	"AuthJWKSURL": true,
+	"AuthJWTIssuer": true,
+	"AuthJWTAudience": true,
+	"AuthJWTChannelsClaim": true,
+	"AuthJWTAdminClaim": true,
+	"AuthJWKSCacheTTLS": true,
+	"AuthBasicUsers": true,
+	"AuthBasicAdmins": true,
+	"LogLevel": true,
+	"LogLevels": true,
+	"Routes": true,
+	"TimeoutMS": true,
+	"ForwarderBackoffBaseMS": true,
+	"ForwarderBackoffMaxMS": true,
+	"ForwarderBackoffJitter": true,
+	"RetryMaxAttempts": true,
+	"RetryBaseDelayMS": true,
+	"RetryMaxDelayMS": true,
+	"RetryJitter": true,
+	"BreakerCooldownMS": true,
+}
+
+// reloader applies a reloaded configuration to the running server.
+type reloader struct {
+	// The options currently in use.
+	args Args
+
+	// The web server.
+	srv *server
+
+	// The channels' quotas. Nil if disabled.
+	quotas *chanquota.Quotas
+
+	// The server's authenticator. Nil if disabled.
+	auth *auth.Swappable
+
+	// Routes the forwarded messages. Nil if no route is configured.
+	router *routingSender
+
+	// The local storage, whose Wait times out every TimeoutMS.
+	store local_storage.Store
+
+	// Forwards the messages in the local storage.
+	fw *forwarder.Forwarder
+
+	// The pipeline's circuit breaker. Nil if disabled.
+	breaker *sender.CircuitBreaker
+
+	// The policy every destination is retried with.
+	retry *atomic.Pointer[sendermw.RetryPolicy]
+}
+
+// disabled checks whether the reloadable option name configures a feature
+// that was disabled on startup, so it can't be applied until the server is
+// restarted.
+func (r *reloader) disabled(name string) bool {
+	switch {
+	case strings.HasPrefix(name, "Auth"):
+		return r.auth == nil
+	case strings.HasPrefix(name, "Channel"):
+		return r.quotas == nil
+	case name == "Routes":
+		return r.router == nil
+	case name == "BreakerCooldownMS":
+		return r.breaker == nil
+	default:
+		return false
+	}
+}
+
+// reload re-reads the configuration file and applies the options that may
+// be changed at runtime (see reloadableArgs). If any of them is invalid,
+// nothing is changed.
+func (r *reloader) reload() {
+	if len(confFile) == 0 {
+		slog.Warn("Ignoring the reload: the server wasn't started with a configuration file")
+		return
+	}
+
+	loaded, err := loadConfFile()
+	logArgNotes()
+	if err != nil {
+		slog.Error("Couldn't reload the configuration file", "file", confFile, "err", err)
+		return
+	} else if err := resolveSecrets(&loaded); err != nil {
+		slog.Error("Couldn't resolve the reloaded secrets", "file", confFile, "err", err)
+		return
+	} else if errs := validateArgs(loaded); len(errs) > 0 {
+		for _, err := range errs {
+			slog.Error("Invalid configuration", "err", err)
+		}
+		slog.Error("Couldn't reload the configuration file: fix it and try again", "file", confFile, "problems", len(errs))
+		return
+	}
+
+	// Keep the options that can't be changed, so they're still reported
+	// as changed on the next reload.
+	next := r.args
+	cur := reflect.ValueOf(&next).Elem()
+	in := reflect.ValueOf(loaded)
+	var changed []string
+	for i := 0; i < cur.NumField(); i++ {
+		name := cur.Type().Field(i).Name
+		if reflect.DeepEqual(cur.Field(i).Interface(), in.Field(i).Interface()) {
+			continue
+		} else if !reloadableArgs[name] {
+			slog.Warn("The option changed, but it's only applied on restart", "option", name)
+			continue
+		} else if r.disabled(name) {
+			slog.Warn("The option changed, but it was disabled on startup, so it's only applied on restart", "option", name)
+			continue
+		}
+
+		cur.Field(i).Set(in.Field(i))
+		changed = append(changed, name)
+	}
+	if len(changed) == 0 {
+		slog.Info("Reloaded the configuration: nothing to apply")
+		return
+	}
+
+	// Create everything before applying anything, so the server isn't left
+	// with part of the configuration.
+	defaults, channels, err := channelLimits(next)
+	if err != nil {
+		slog.Error("Couldn't reload the channel quotas", "err", err)
+		return
+	}
+
+	var a auth.Authenticator
+	if r.auth != nil {
+		a, err = newAuthenticator(next)
+		if err != nil {
+			slog.Error("Couldn't reload the authenticator", "err", err)
+			return
+		} else if a == nil {
+			slog.Error("Couldn't reload the authenticator: authentication can only be disabled on restart")
+			return
+		}
+	}
+
+	routes, err := newRouteTable(next)
+	if err != nil {
+		slog.Error("Couldn't reload the routes", "err", err)
+		return
+	} else if r.router != nil && routes == nil {
+		slog.Error("Couldn't reload the routes: routing can only be disabled on restart")
+		return
+	}
+
+	var levels *loglevel.Levels
+	if next.LogLevel != r.args.LogLevel || next.LogLevels != r.args.LogLevels {
+		parsed, err := logLevels(next)
+		if err != nil {
+			slog.Error("Couldn't reload the log levels", "err", err)
+			return
+		}
+		levels = &parsed
+	}
+
+	if r.quotas != nil {
+		r.quotas.SetLimits(defaults, channels)
+	}
+	if a != nil {
+		r.auth.Swap(a)
+	}
+	if next.ClientRate != r.args.ClientRate || next.ClientBurst != r.args.ClientBurst {
+		var limiter *clientlimit.Limiter
+		if next.ClientRate > 0 {
+			limiter = clientlimit.New(next.ClientRate, next.ClientBurst)
+		}
+		r.srv.limiter.Store(limiter)
+	}
+
+	if r.router != nil {
+		r.router.routes.Store(routes)
+		r.srv.routes.Store(routes)
+	}
+
+	if next.TimeoutMS != r.args.TimeoutMS {
+		r.store.SetTimeout(time.Duration(next.TimeoutMS) * time.Millisecond)
+	}
+	if backoffPolicy(next) != backoffPolicy(r.args) {
+		r.fw.SetBackoff(backoffPolicy(next))
+	}
+	if retryPolicy(next) != retryPolicy(r.args) {
+		policy := retryPolicy(next)
+		r.retry.Store(&policy)
+	}
+	if r.breaker != nil && next.BreakerCooldownMS != r.args.BreakerCooldownMS {
+		r.breaker.SetCooldown(time.Duration(next.BreakerCooldownMS) * time.Millisecond)
+	}
+
+	if levels != nil {
+		logHandler.SetLevels(*levels)
+	}
+
+	r.args = next
+	slog.Info("Reloaded the configuration", "changed", changed)
+}
@@ -0,0 +1,235 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/SirGFM/sqs-issue-notifier/server/client"
+	"github.com/SirGFM/sqs-issue-notifier/server/local_storage"
+	"github.com/SirGFM/sqs-issue-notifier/server/sender"
+	"log/slog"
+	"time"
+)
+
+// The flags of the replay command.
+var (
+	// URL of the queue whose messages are replayed. Defaults to Queue.
+	replayFrom string
+
+	// URL of the queue that receives the replayed messages. If empty,
+	// they're stored again (either in the local storage or through the
+	// running server).
+	replayTo string
+
+	// Most messages replayed. Non-positive to replay every message.
+	replayMax int
+
+	// How long to wait for messages, before considering the queue empty.
+	replayWait time.Duration
+)
+
+// defaultReplayWait is how long the replay command waits for messages, by
+// default, before considering the queue empty.
+const defaultReplayWait = 2 * time.Second
+
+// replayFlags registers the flags of the replay command.
+func replayFlags() {
+	remoteFlags()
+	flag.StringVar(&replayFrom, "From", "", "URL of the queue whose messages are replayed (e.g., its dead-letter queue). Defaults to Queue")
+	flag.StringVar(&replayTo, "To", "", "URL of the queue that receives the replayed messages, as they were received. If empty, they're stored again, either in the local storage or through the server at -URL")
+	flag.IntVar(&replayMax, "Max", 0, "Most messages replayed. 0 replays every message in the queue")
+	flag.DurationVar(&replayWait, "Wait", defaultReplayWait, "How long to wait for messages before considering the queue empty (at most 20s)")
+	commandFlags = append(commandFlags, "From", "To", "Max", "Wait")
+}
+
+// replayer re-injects a message read back from a queue. It fails with
+// errNotReplayable if the message can't be re-injected, in which case the
+// message is skipped.
+type replayer func(msg sender.Received) error
+
+// replayStats counts what happened to the replayed messages.
+type replayStats struct {
+	// Messages re-injected, and then removed from the queue.
+	Replayed int
+
+	// Messages that couldn't be re-injected, left in the queue.
+	Skipped int
+}
+
+// replay re-injects, through inject, every message received from r (or up
+// to max messages, if it's positive). Messages are only deleted from the
+// queue once they're re-injected, so the ones that were skipped (or that
+// failed) are received again once their visibility timeout expires.
+//
+// It stops once no new message is received within wait, or on the first
+// error other than errNotReplayable.
+func replay(r sender.Receiver, inject replayer, max int, wait time.Duration) (replayStats, error) {
+	var st replayStats
+
+	// Skipped messages may be received again, so they're only
+	// considered once.
+	seen := make(map[string]struct{})
+	for max <= 0 || st.Replayed < max {
+		// Always receive as many messages as possible, as some may have
+		// been skipped already. Messages received after the last one
+		// replayed are simply left in the queue.
+		msgs, err := r.Receive(sender.MaxReceive, wait)
+		if err != nil {
+			return st, fmt.Errorf("couldn't receive the messages: %w", err)
+		}
+
+		received := false
+		for _, msg := range msgs {
+			if max > 0 && st.Replayed >= max {
+				break
+			} else if _, ok := seen[msg.MessageID]; ok {
+				continue
+			}
+			seen[msg.MessageID] = struct{}{}
+			received = true
+
+			err := inject(msg)
+			if errors.Is(err, errNotReplayable) {
+				slog.Warn("Skipping a message", "id", msg.MessageID, "err", err)
+				st.Skipped++
+				continue
+			} else if err != nil {
+				return st, fmt.Errorf("couldn't replay the message '%s': %w", msg.MessageID, err)
+			}
+
+			err = r.Delete(msg)
+			if err != nil {
+				// The message will be received again, and thus
+				// replayed twice.
+				return st, fmt.Errorf("replayed the message '%s', but couldn't delete it from the queue: %w", msg.MessageID, err)
+			}
+			st.Replayed++
+		}
+		if !received {
+			break
+		}
+	}
+
+	return st, nil
+}
+
+// storeReplayer stores every replayed message in store, to be sent again.
+func storeReplayer(store local_storage.Store) replayer {
+	return func(msg sender.Received) error {
+		stored, err := storedFromSent(msg.Message)
+		if err != nil {
+			return err
+		}
+		if len(stored.RequestID) == 0 {
+			stored.RequestID = newRequestID()
+		}
+
+		data, err := json.Marshal(&stored)
+		if err != nil {
+			return err
+		}
+
+		_, err = store.StorePriority(data, stored.Priority)
+		if err == local_storage.ErrDuplicatedStore {
+			return nil
+		}
+		return err
+	}
+}
+
+// remoteReplayer posts every replayed message to the running server, through
+// c. Retries of the same message are recognized as duplicates by the server,
+// as long as the message was received with its request ID.
+func remoteReplayer(c *client.Client) replayer {
+	return func(msg sender.Received) error {
+		stored, err := storedFromSent(msg.Message)
+		if err != nil {
+			return err
+		} else if len(stored.Encoding) > 0 {
+			return fmt.Errorf("%w: its encoding (%s) can't be posted to the server (stop it, and replay into the local storage instead)", errNotReplayable, stored.Encoding)
+		}
+
+		_, err = c.Send(context.Background(), client.Message{
+			Channel: stored.Channel,
+			Message: stored.Message,
+			RequestID: stored.RequestID,
+		})
+		if errors.Is(err, client.ErrRejected) {
+			return fmt.Errorf("%w: %w", errNotReplayable, err)
+		}
+		return err
+	}
+}
+
+// queueReplayer sends every replayed message to s, exactly as it was
+// received.
+func queueReplayer(s sender.Sender) replayer {
+	return func(msg sender.Received) error {
+		_, err := s.Send(sender.Message{
+			Body: msg.Body,
+			Attributes: msg.Attributes,
+		})
+		return err
+	}
+}
+
+// runReplay reads the messages back from a queue (e.g., its dead-letter
+// queue, after a bad deployment of its consumer), and either stores them
+// again (directly in the local storage, or through the running server) or
+// sends them to another queue. Messages are removed from the queue once
+// they're replayed.
+func runReplay(args Args, params []string) {
+	if len(params) > 0 {
+		fatal("Unexpected arguments", "args", params)
+	}
+
+	from := replayFrom
+	if len(from) == 0 {
+		from = args.Queue
+	}
+	if len(from) == 0 {
+		fatal("No queue to replay: set -From (or Queue)")
+	} else if len(replayTo) > 0 && len(remoteURL) > 0 {
+		fatal("Set either -To or -URL, but not both")
+	} else if replayTo == from {
+		fatal("Can't replay a queue into itself", "queue", from)
+	}
+
+	opts, err := awsOptions(args)
+	if err != nil {
+		fatal("Couldn't configure the access to AWS", "err", err)
+	}
+	r, err := sender.NewSQSReceiver(args.Endpoint, from, opts)
+	if err != nil {
+		fatal("Couldn't create the receiver", "queue", from, "err", err)
+	}
+
+	var inject replayer
+	switch {
+	case len(replayTo) > 0:
+		s, err := sender.NewSQSSender(args.Endpoint, replayTo, opts)
+		if err != nil {
+			fatal("Couldn't create the sender", "queue", replayTo, "err", err)
+		}
+		inject = queueReplayer(s)
+	case len(remoteURL) > 0:
+		c, err := client.New(remoteURL, client.WithToken(remoteToken))
+		if err != nil {
+			fatal("Invalid URL", "url", remoteURL, "err", err)
+		}
+		defer c.Close()
+		inject = remoteReplayer(c)
+	default:
+		store, closeStore := openStore(args)
+		defer closeStore()
+		inject = storeReplayer(store)
+	}
+
+	st, err := replay(r, inject, replayMax, replayWait)
+	fmt.Printf("Replayed: %d\nSkipped: %d\n", st.Replayed, st.Skipped)
+	if err != nil {
+		fatal("Failed to replay the messages", "queue", from, "err", err)
+	}
+}
@@ -0,0 +1,63 @@
+package notifier
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// requestIDHeader is the header that identifies a request, both in the
+// request itself (if the client supplies one) and in its response.
+const requestIDHeader = "X-Request-Id"
+
+// maxRequestIDLen limits the size of request IDs supplied by clients.
+const maxRequestIDLen = 128
+
+// requestIDKey is the context key used to store the request ID.
+type requestIDKey struct{}
+
+// withRequestID associates req with a request ID, either honoring the one
+// supplied by the client or generating a new one. The ID is also set in
+// the response's headers.
+func withRequestID(w http.ResponseWriter, req *http.Request) *http.Request {
+	id := req.Header.Get(requestIDHeader)
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+
+	w.Header().Set(requestIDHeader, id)
+	return req.WithContext(context.WithValue(req.Context(), requestIDKey{}, id))
+}
+
+// requestID retrieves the ID associated with req by withRequestID. It
+// returns an empty string if req has no ID.
+func requestID(req *http.Request) string {
+	id, _ := req.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// newRequestID generates a random request ID.
+func newRequestID() string {
+	var buf [16]byte
+
+	// crypto/rand never fails on supported platforms.
+	rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
+
+// validRequestID checks whether id may be used as a request ID: it must be
+// non-empty, reasonably sized and only have printable ASCII characters, so
+// it may be safely logged and forwarded.
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > maxRequestIDLen {
+		return false
+	}
+
+	for _, c := range []byte(id) {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
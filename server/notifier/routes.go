@@ -0,0 +1,171 @@
+package notifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/SirGFM/sqs-issue-notifier/server/local_storage"
+	"github.com/SirGFM/sqs-issue-notifier/server/sender"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// route is a Route ready to be matched against channels.
+type route struct {
+	Route
+
+	// Matches the channels of the route.
+	pattern *regexp.Regexp
+}
+
+// routeTable selects the destinations of each message by its channel, as
+// configured by Routes. Routes are matched in order, and the first one that
+// matches the message's channel is used.
+type routeTable struct {
+	routes []route
+}
+
+// compileChannel converts pattern, where "*" matches any sequence of
+// characters (including "/", as in "group/project"), into a regexp.
+func compileChannel(pattern string) (*regexp.Regexp, error) {
+	parts := strings.Split(pattern, "*")
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	return regexp.Compile("^" + strings.Join(parts, ".*") + "$")
+}
+
+// newRouteTable creates the routes configured in args. It returns nil if
+// no route is configured, in which case every message is sent to every
+// destination.
+func newRouteTable(args Args) (*routeTable, error) {
+	if len(args.Routes) == 0 {
+		return nil, nil
+	}
+
+	names := make(map[string]bool)
+	for _, d := range args.Destinations {
+		names[d.Name] = true
+	}
+
+	var rt routeTable
+	for i, r := range args.Routes {
+		if len(r.Channel) == 0 {
+			return nil, fmt.Errorf("Routes[%d]: Channel must be set (use \"*\" to match every channel)", i)
+		} else if len(r.Destinations) == 0 {
+			return nil, fmt.Errorf("Routes[%d]: Destinations must name at least one destination", i)
+		}
+		for _, name := range r.Destinations {
+			if !names[name] {
+				return nil, fmt.Errorf("Routes[%d]: unknown destination '%s' (it must be in Destinations)", i, name)
+			}
+		}
+		if max := int64(sender.MaxDelay / time.Second); r.DelaySeconds < 0 || r.DelaySeconds > max {
+			return nil, fmt.Errorf("Routes[%d]: DelaySeconds must be between 0 and %d (got %d)", i, max, r.DelaySeconds)
+		}
+		if r.Priority < local_storage.PriorityLow || r.Priority > local_storage.PriorityHigh {
+			return nil, fmt.Errorf("Routes[%d]: invalid Priority", i)
+		}
+
+		pattern, err := compileChannel(r.Channel)
+		if err != nil {
+			return nil, fmt.Errorf("Routes[%d]: invalid Channel '%s': %w", i, r.Channel, err)
+		}
+		rt.routes = append(rt.routes, route{Route: r, pattern: pattern})
+	}
+
+	return &rt, nil
+}
+
+// match retrieves the first route that matches channel. ok is false if no
+// route matches it.
+func (rt *routeTable) match(channel string) (r Route, ok bool) {
+	if rt == nil {
+		return Route{}, false
+	}
+
+	for _, r := range rt.routes {
+		if r.pattern.MatchString(channel) {
+			return r.Route, true
+		}
+	}
+	return Route{}, false
+}
+
+// applyPriority sets the priority of the route that matches msg's channel,
+// unless msg already has a priority other than the default one.
+func (rt *routeTable) applyPriority(msg *storedMessage) {
+	if r, ok := rt.match(msg.Channel); ok && msg.Priority == local_storage.PriorityNormal {
+		msg.Priority = r.Priority
+	}
+}
+
+// routingSender selects the destinations of each message, as configured by
+// its routes, before sending it through the wrapped sender (usually a
+// sender.Fanout). Messages that match no route are rejected, so they are
+// dead-lettered (if enabled) until the routes are fixed.
+type routingSender struct {
+	// The sender that delivers the routed messages.
+	s sender.Sender
+
+	// Selects each message's destinations. It's replaced when the
+	// configuration is reloaded.
+	routes atomic.Pointer[routeTable]
+}
+
+// newRoutingSender creates a routingSender for rt, to be placed in the chain
+// by wrap. It returns nil if no route is configured.
+func newRoutingSender(rt *routeTable) *routingSender {
+	if rt == nil {
+		return nil
+	}
+
+	rs := &routingSender{}
+	rs.routes.Store(rt)
+	return rs
+}
+
+// wrap s in the routingSender, unless rs is nil.
+func (rs *routingSender) wrap(s sender.Sender) sender.Sender {
+	if rs == nil {
+		return s
+	}
+	rs.s = s
+	return rs
+}
+
+// Send msg to the destinations of the route that matches its channel,
+// applying the route's delay and attributes. Heartbeats aren't routed, so
+// they verify every destination.
+func (rs *routingSender) Send(msg sender.Message) (sender.SendResult, error) {
+	if _, ok := msg.Attributes[heartbeatAttr]; ok {
+		return rs.s.Send(msg)
+	}
+
+	var body message
+	json.Unmarshal([]byte(msg.Body), &body)
+	r, ok := rs.routes.Load().match(body.Channel)
+	if !ok {
+		componentLogger(componentSender).Warn("No route matches the message's channel", "channel", body.Channel)
+		return sender.SendResult{}, sender.ErrRejected
+	}
+
+	msg.Destinations = r.Destinations
+	if msg.Delay == 0 && r.DelaySeconds > 0 {
+		msg.Delay = time.Duration(r.DelaySeconds) * time.Second
+	}
+	if len(r.Attributes) > 0 {
+		attrs := make(map[string]string, len(msg.Attributes) + len(r.Attributes))
+		for name, value := range r.Attributes {
+			attrs[name] = value
+		}
+		// The message's own attributes (e.g., its request ID) are kept.
+		for name, value := range msg.Attributes {
+			attrs[name] = value
+		}
+		msg.Attributes = attrs
+	}
+
+	return rs.s.Send(msg)
+}
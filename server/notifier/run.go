@@ -0,0 +1,100 @@
+package notifier
+
+import (
+	"context"
+	"github.com/SirGFM/sqs-issue-notifier/server/local_storage"
+	"github.com/SirGFM/sqs-issue-notifier/server/metrics"
+	"github.com/SirGFM/sqs-issue-notifier/server/sender"
+)
+
+// options configures the server started by Run.
+type options struct {
+	// The server's options.
+	args Args
+
+	// The local storage, used instead of the one in args.LocalStore. Nil
+	// to use args.LocalStore.
+	store local_storage.Store
+
+	// Delivers every message, instead of the destinations in args. Nil to
+	// use args' destinations.
+	sender sender.Sender
+
+	// Registry where the metrics are recorded. Nil to create a new one.
+	metrics *metrics.Registry
+
+	// Run on every request, after the built-in middleware.
+	middleware []Middleware
+}
+
+// Option configures the server started by Run.
+type Option func(*options)
+
+// WithArgs configures the server with args, instead of DefaultArgs(). Any
+// other option takes precedence over the equivalent field of args.
+func WithArgs(args Args) Option {
+	return func(o *options) {
+		o.args = args
+	}
+}
+
+// WithStore keeps the messages in store, instead of in the local storage
+// in Args.LocalStore. The store isn't closed once the server stops, and it
+// must not be used by anyone else meanwhile.
+func WithStore(store local_storage.Store) Option {
+	return func(o *options) {
+		o.store = store
+	}
+}
+
+// WithSender delivers every message through s, instead of the destinations
+// in Args. The messages are still retried, throttled and transformed as
+// configured in Args.
+func WithSender(s sender.Sender) Option {
+	return func(o *options) {
+		o.sender = s
+	}
+}
+
+// WithMetrics records the server's metrics in reg (e.g., so they're also
+// exported by the calling program), instead of in a registry of its own.
+func WithMetrics(reg *metrics.Registry) Option {
+	return func(o *options) {
+		o.metrics = reg
+	}
+}
+
+// WithMiddleware runs middleware on every request, in the given order,
+// after the built-in middleware (so requests were already authenticated
+// and rate limited) and right before the request's handler. It may be
+// given more than once, appending to the middleware already given.
+func WithMiddleware(middleware ...Middleware) Option {
+	return func(o *options) {
+		o.middleware = append(o.middleware, middleware...)
+	}
+}
+
+// Run the server, configured by opts, until ctx is done. It then stops the
+// server, draining the backlog first if Args.DrainOnExitS is set. Unlike
+// running the server from the command line, it doesn't handle any signal
+// (e.g., to reload the configuration).
+//
+// It fails if the server couldn't be started (e.g., its configuration is
+// invalid, or its address is in use).
+func Run(ctx context.Context, opts ...Option) error {
+	o := options{
+		args: DefaultArgs(),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	svc, err := startService(o)
+	if err != nil {
+		return err
+	}
+
+	<-ctx.Done()
+	svc.stop(nil)
+	return nil
+}
@@ -0,0 +1,243 @@
+package notifier
+
+import (
+	"fmt"
+	"github.com/SirGFM/sqs-issue-notifier/server/secretref"
+	"github.com/SirGFM/sqs-issue-notifier/server/sender"
+	"github.com/SirGFM/sqs-issue-notifier/server/vault"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// fileArgs lists the options that hold the path to a file. If they're set to
+// a reference, the secret is written to a private file, whose path is set
+// instead.
+var fileArgs = map[string]bool{
+	"CertFile": true,
+	"KeyFile": true,
+	"CollectorCAFile": true,
+}
+
+// accessArgs lists the options used to access the services that keep the
+// secrets (i.e., AWS and Vault), which may not be references themselves.
+var accessArgs = []string{
+	"Endpoint",
+	"Region",
+	"Profile",
+	"WebIdentityTokenFile",
+	"WebIdentityRoleARN",
+	"AssumeRoleARN",
+	"AssumeRoleExternalID",
+	"AssumeRoleSessionName",
+	"VaultAddr",
+	"VaultAuthMethod",
+	"VaultToken",
+	"VaultRoleID",
+	"VaultSecretID",
+	"VaultK8sRole",
+	"VaultK8sTokenFile",
+	"VaultAuthMount",
+	"VaultNamespace",
+	"VaultCAFile",
+	"VaultAWSCredsPath",
+}
+
+// secretsDir is the private directory where the secrets of fileArgs are
+// written. It's created once per process, so a reloaded secret replaces the
+// previous one, keeping its path.
+var secretsDir string
+
+// schemeVault identifies references to Vault, as "vault://<path>[#<key>]".
+const schemeVault = "vault"
+
+// vaultState is the Vault client created for the current Vault options. It's
+// reused while the options don't change, so the token and the AWS
+// credentials are shared by every user (e.g., every destination).
+var vaultState struct {
+	// Synchronizes access to the other fields.
+	mutex sync.Mutex
+
+	// The options used to create client.
+	opts vault.Options
+
+	// The client, if already created.
+	client *vault.Client
+
+	// AWS credentials read from Vault, by their path.
+	awsCreds map[string]*credentials.Credentials
+}
+
+// vaultOptions configures how Vault is accessed, as configured in args.
+func vaultOptions(args Args) vault.Options {
+	return vault.Options{
+		Addr: args.VaultAddr,
+		Method: args.VaultAuthMethod,
+		Token: args.VaultToken,
+		RoleID: args.VaultRoleID,
+		SecretID: args.VaultSecretID,
+		Role: args.VaultK8sRole,
+		JWTFile: args.VaultK8sTokenFile,
+		Mount: args.VaultAuthMount,
+		Namespace: args.VaultNamespace,
+		CAFile: args.VaultCAFile,
+		Timeout: time.Duration(args.AWSTimeoutMS) * time.Millisecond,
+	}
+}
+
+// vaultClient retrieves the Vault client, as configured in args, creating
+// it if the options changed. Must be called with vaultState's mutex locked.
+func vaultClient(args Args) (*vault.Client, error) {
+	opts := vaultOptions(args)
+	if vaultState.client != nil && vaultState.opts == opts {
+		return vaultState.client, nil
+	}
+
+	c, err := vault.New(opts)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't configure Vault: %w", err)
+	}
+	vaultState.opts = opts
+	vaultState.client = c
+	vaultState.awsCreds = make(map[string]*credentials.Credentials)
+	return c, nil
+}
+
+// vaultAWSCredentials retrieves the AWS credentials read from
+// args.VaultAWSCredsPath, in Vault.
+func vaultAWSCredentials(args Args) (*credentials.Credentials, error) {
+	vaultState.mutex.Lock()
+	defer vaultState.mutex.Unlock()
+
+	c, err := vaultClient(args)
+	if err != nil {
+		return nil, err
+	}
+	creds, ok := vaultState.awsCreds[args.VaultAWSCredsPath]
+	if !ok {
+		creds = c.AWSCredentials(args.VaultAWSCredsPath)
+		vaultState.awsCreds[args.VaultAWSCredsPath] = creds
+	}
+	return creds, nil
+}
+
+// newSecretResolver creates the resolver of the references in args. The
+// services are only accessed if referenced, and Vault may only be referenced
+// if VaultAddr is set.
+func newSecretResolver(args Args) *secretref.Resolver {
+	r := secretref.New()
+	r.Register(secretref.SchemeSecretsManager, func() (secretref.Fetcher, error) {
+		s, err := newSecretsAWSSession(args)
+		if err != nil {
+			return nil, err
+		}
+		return secretref.NewSecretsManager(s), nil
+	})
+	r.Register(secretref.SchemeParameterStore, func() (secretref.Fetcher, error) {
+		s, err := newSecretsAWSSession(args)
+		if err != nil {
+			return nil, err
+		}
+		return secretref.NewParameterStore(s), nil
+	})
+	if len(args.VaultAddr) > 0 {
+		r.Register(schemeVault, func() (secretref.Fetcher, error) {
+			vaultState.mutex.Lock()
+			defer vaultState.mutex.Unlock()
+
+			return vaultClient(args)
+		})
+	}
+	return r
+}
+
+// newSecretsAWSSession creates the AWS session used to fetch the secrets.
+func newSecretsAWSSession(args Args) (*session.Session, error) {
+	opts, err := awsOptions(args)
+	if err != nil {
+		return nil, err
+	}
+	return sender.NewAWSSession(args.Endpoint, opts)
+}
+
+// resolveSecrets replaces every option in args written as a reference to a
+// secret (e.g., "aws-sm://prod/notifier#github", "ssm://prod/notifier/key" or
+// "vault://secret/data/notifier#github") with the secret itself. Options in
+// fileArgs are set to the path of a private file with the secret.
+func resolveSecrets(args *Args) error {
+	r := newSecretResolver(*args)
+
+	v := reflect.ValueOf(args).Elem()
+	for _, name := range accessArgs {
+		if r.IsRef(v.FieldByName(name).String()) {
+			return fmt.Errorf("'%s' can't be a reference, as it's used to fetch the secrets", name)
+		}
+	}
+
+	var skip []string
+	for name := range fileArgs {
+		skip = append(skip, name)
+
+		f := v.FieldByName(name)
+		if !r.IsRef(f.String()) {
+			continue
+		}
+		secret, err := r.Fetch(f.String())
+		if err != nil {
+			return fmt.Errorf("'%s': %w", name, err)
+		}
+		path, err := writeSecretFile(name, secret)
+		if err != nil {
+			return fmt.Errorf("'%s': %w", name, err)
+		}
+		f.SetString(path)
+		slog.Info("Resolved the option's secret into a file", "option", name, "file", path)
+	}
+
+	resolved, err := r.Resolve(args, skip...)
+	if err != nil {
+		return err
+	}
+	for _, name := range resolved {
+		slog.Info("Resolved the option's secret", "option", name)
+	}
+	return nil
+}
+
+// writeSecretFile writes secret to the file named name in secretsDir
+// (creating it, if needed), readable only by the current user, returning
+// the file's path.
+func writeSecretFile(name, secret string) (string, error) {
+	if len(secretsDir) == 0 {
+		dir, err := os.MkdirTemp("", "sqs-notifier-secrets-*")
+		if err != nil {
+			return "", err
+		}
+		secretsDir = dir
+	}
+
+	// Write to a temporary file, so the secret is replaced atomically.
+	path := filepath.Join(secretsDir, name)
+	tmp, err := os.CreateTemp(secretsDir, name + ".*")
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.WriteString(secret)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	return path, nil
+}
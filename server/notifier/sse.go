@@ -0,0 +1,62 @@
+package notifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// The type of the Server-Sent Events with the pipeline's statistics.
+const sseStatsEvent = "stats"
+
+// Interval between the statistics, if EventsIntervalS isn't positive.
+const defaultEventsInterval = 5 * time.Second
+
+// streamStats streams the pipeline's statistics as Server-Sent Events,
+// every s.eventsInterval, until the client disconnects. This is meant for
+// clients that can't use WebSockets (e.g., browsers' EventSource).
+func (s *server) streamStats(w http.ResponseWriter, req *http.Request) {
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if req.Method == http.MethodHead {
+		return
+	}
+
+	reqLogger(req).Info("Streaming statistics")
+
+	ticker := time.NewTicker(s.eventsInterval)
+	defer ticker.Stop()
+
+	for {
+		stats := s.events.Stats()
+		stats.Paused = s.forwarder.Paused()
+		data, err := json.Marshal(stats)
+		if err != nil {
+			reqLogger(req).Error("Couldn't encode the statistics", "err", err)
+			return
+		}
+
+		// Each event may take up to an interval to be written, regardless
+		// of the server's WriteTimeout.
+		rc.SetWriteDeadline(time.Now().Add(s.eventsInterval + eventWriteTimeout))
+		_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", sseStatsEvent, data)
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			reqLogger(req).Info("Stopped streaming statistics", "err", err)
+			return
+		}
+
+		select {
+		case <-req.Context().Done():
+			reqLogger(req).Info("Stopped streaming statistics")
+			return
+		case <-ticker.C:
+		}
+	}
+}
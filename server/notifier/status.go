@@ -0,0 +1,220 @@
+package notifier
+
+import (
+	"encoding/json"
+	"github.com/SirGFM/sqs-issue-notifier/server/health"
+	"net/http"
+	"time"
+)
+
+// statusConfig summarizes how the server is configured. The full
+// configuration (with the secrets redacted) is printed by the 'config'
+// command.
+type statusConfig struct {
+	// Where messages are sent: either the name of each destination, or
+	// the queue.
+	Destinations []string
+
+	// Whether messages are logged instead of sent.
+	DryRun bool
+
+	// Directory where messages are kept until they're sent.
+	LocalStore string
+
+	// Number of workers forwarding messages at once.
+	ForwarderWorkers int
+
+	// Maximum number of messages being sent at once, or 0 if only limited
+	// by the number of workers.
+	MaxInFlight int
+
+	// Whether rejected messages are dead-lettered, instead of discarded.
+	DeadLetter bool
+
+	// Consecutive failures after which sending is suspended, or 0 if the
+	// circuit breaker is disabled.
+	BreakerThreshold int
+
+	// For how long, in seconds, the backlog is drained on exit.
+	DrainOnExitS int
+}
+
+// newStatusConfig summarizes args.
+func newStatusConfig(args Args) statusConfig {
+	cfg := statusConfig{
+		DryRun: args.DryRun,
+		LocalStore: args.LocalStore,
+		ForwarderWorkers: args.ForwarderWorkers,
+		MaxInFlight: args.MaxInFlight,
+		DeadLetter: args.DeadLetter,
+		BreakerThreshold: args.BreakerThreshold,
+		DrainOnExitS: args.DrainOnExitS,
+	}
+	for _, d := range args.Destinations {
+		cfg.Destinations = append(cfg.Destinations, d.Name)
+	}
+	if len(cfg.Destinations) == 0 && len(args.Queue) > 0 {
+		cfg.Destinations = []string{args.Queue}
+	}
+
+	return cfg
+}
+
+// storeStatus describes the local storage.
+type storeStatus struct {
+	// Number of messages waiting to be sent.
+	Backlog int
+
+	// Age, in seconds, of the oldest message, or 0 if there's none.
+	OldestAgeS float64
+
+	// Total size, in bytes, of the messages waiting to be sent.
+	Bytes int64
+
+	// Number of messages in the dead-letter area.
+	DeadLetters int
+
+	// Number of corrupted files moved to the quarantine area.
+	Quarantined int
+}
+
+// forwarderStatus describes what the forwarder is doing.
+type forwarderStatus struct {
+	// Whether forwarding is paused.
+	Paused bool
+
+	// Number of messages currently being forwarded.
+	InFlight int
+
+	// For how long, in seconds, the forwarder has been forwarding its
+	// current message, or 0 if it's waiting for messages.
+	BusyS float64
+
+	// For how long, in seconds, the forwarder is backing off after
+	// consecutive failures, or 0.
+	BackoffS float64
+
+	// When a message was last retrieved or sent.
+	LastActive time.Time
+
+	// Number of messages sent since the server started.
+	Sent int
+}
+
+// serverStatus is a snapshot of the server's internals, to be inspected
+// during incidents.
+type serverStatus struct {
+	// The running build.
+	Build buildInfo
+
+	// When the server started.
+	StartedAt time.Time
+
+	// For how long, in seconds, the server has been running.
+	UptimeS float64
+
+	// Summary of the server's configuration.
+	Config statusConfig
+
+	// The local storage.
+	Store storeStatus
+
+	// Statistics of each channel, since the server started. Empty if
+	// events are disabled.
+	Channels map[string]channelStats
+
+	// The latest failures to send a message, most recent first. Empty if
+	// events are disabled.
+	RecentFailures []pipelineEvent
+
+	// The health of the least healthy component.
+	Health health.Status
+
+	// The health reported by each component (e.g., the sender).
+	Components []health.Report
+
+	// Result of the latest heartbeats. Nil if the heartbeat is disabled.
+	Heartbeat *heartbeatStatus `json:",omitempty"`
+
+	// The forwarder.
+	Forwarder forwarderStatus
+
+	// Whether the server is draining the backlog before exiting.
+	Draining bool
+
+	// When the snapshot was taken.
+	Time time.Time
+}
+
+// status takes a snapshot of the server's internals.
+func (s *server) status() serverStatus {
+	now := time.Now()
+	st := serverStatus{
+		Build: getBuildInfo(),
+		StartedAt: s.startedAt,
+		UptimeS: now.Sub(s.startedAt).Seconds(),
+		Config: s.config,
+		Health: s.health.Overall(),
+		Components: s.health.Reports(),
+		Draining: s.draining.Load(),
+		Time: now,
+	}
+
+	st.Store.Backlog = s.store.Count()
+	if oldest, err := s.store.Oldest(); err == nil && !oldest.StoredAt.IsZero() {
+		st.Store.OldestAgeS = now.Sub(oldest.StoredAt).Seconds()
+	}
+	if stats, err := s.store.Stats(); err == nil {
+		st.Store.Bytes = stats.Bytes
+		st.Store.Quarantined = stats.Quarantined
+	}
+	if dl, err := s.store.DeadLetters(); err == nil {
+		st.Store.DeadLetters = len(dl)
+	}
+
+	if s.events != nil {
+		stats := s.events.Stats()
+		st.Channels = stats.Channels
+		st.RecentFailures = stats.RecentFailures
+	}
+	if hb, ok := s.heartbeat.Status(); ok {
+		st.Heartbeat = &hb
+	}
+
+	st.Forwarder = forwarderStatus{
+		Paused: s.forwarder.Paused(),
+		InFlight: s.forwarder.InFlight(),
+		BusyS: s.forwarder.Busy().Seconds(),
+		BackoffS: s.forwarder.Backoff().Seconds(),
+		LastActive: s.forwarder.LastActive(),
+		Sent: s.forwarder.Sent(),
+	}
+
+	return st
+}
+
+// GetStatus handles GET requests on the 'status' resource, returning a
+// snapshot of the server's internals (its configuration, the local
+// storage, each channel, the components' health and the forwarder), as
+// JSON. Only administrators may inspect it.
+func (s *server) GetStatus(w http.ResponseWriter, req *http.Request, res []string) {
+	if len(res) > 1 {
+		httpTextReply(http.StatusNotFound, "Invalid resource", w)
+		return
+	} else if !s.requireAdmin(w, req, res) {
+		return
+	}
+
+	st := s.status()
+	data, err := json.Marshal(&st)
+	if err != nil {
+		serr := "Failed to encode the response"
+		httpTextReply(http.StatusInternalServerError, serr, w)
+		reqLogger(req).Error(serr, "err", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	writeData(data, w)
+}
@@ -0,0 +1,78 @@
+package notifier
+
+import (
+	"github.com/SirGFM/sqs-issue-notifier/server/forwarder"
+	"github.com/SirGFM/sqs-issue-notifier/server/sdnotify"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// notifySystemd reports to systemd (if it supervises the server, as a
+// Type=notify service) that the server is ready. If the unit enables the
+// watchdog (WatchdogSec), it's notified for as long as the forwarder isn't
+// stuck on a single message for longer than args.ForwarderStuckS, so
+// systemd restarts a wedged server.
+//
+// If the server took over from an upgraded one, systemd is also told that
+// this is now the service's main process (which requires NotifyAccess=all).
+//
+// Call the returned function once the server starts shutting down, with
+// stopping false if it's handing off to an upgraded process (so the
+// service doesn't stop).
+func notifySystemd(args Args, fw *forwarder.Forwarder) func(stopping bool) {
+	state := sdnotify.Ready
+	if handedOff() {
+		state = sdnotify.MainPID(os.Getpid()) + "\n" + state
+	}
+	sent, err := sdnotify.Notify(state)
+	if err != nil {
+		slog.Warn("Couldn't notify systemd", "err", err)
+		return func(bool) {}
+	} else if !sent {
+		return func(bool) {}
+	}
+	slog.Info("Notified systemd that the server is ready")
+
+	interval, err := sdnotify.WatchdogInterval()
+	if err != nil {
+		slog.Warn("Ignoring systemd's watchdog", "err", err)
+	}
+
+	done := make(chan struct{})
+	if interval > 0 {
+		maxBusy := time.Duration(args.ForwarderStuckS) * time.Second
+		slog.Info("Notifying systemd's watchdog", "interval", interval)
+
+		go func() {
+			// Notify twice per interval, so a late notification doesn't
+			// trigger the watchdog.
+			ticker := time.NewTicker(interval / 2)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C:
+				case <-done:
+					return
+				}
+
+				if busy := fw.Busy(); maxBusy > 0 && busy > maxBusy {
+					slog.Error("The forwarder seems to be stuck, so systemd's watchdog isn't notified", "busy", busy)
+					continue
+				}
+				_, err := sdnotify.Notify(sdnotify.Watchdog)
+				if err != nil {
+					slog.Warn("Couldn't notify systemd's watchdog", "err", err)
+				}
+			}
+		} ()
+	}
+
+	return func(stopping bool) {
+		close(done)
+		if stopping {
+			sdnotify.Notify(sdnotify.Stopping)
+		}
+	}
+}
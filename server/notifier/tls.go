@@ -0,0 +1,137 @@
+package notifier
+
+import (
+	"crypto/tls"
+	"golang.org/x/crypto/acme/autocert"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloader serves a TLS certificate loaded from a pair of files,
+// reloading it whenever the files change (e.g., once the certificate is
+// rotated by an external tool).
+type certReloader struct {
+	// PEM file with the certificate chain.
+	certFile string
+
+	// PEM file with the certificate's private key.
+	keyFile string
+
+	// Minimum interval between checking whether the files changed. If 0,
+	// the certificate is never reloaded.
+	interval time.Duration
+
+	// Synchronizes access to the fields below.
+	mutex sync.Mutex
+
+	// The current certificate.
+	cert *tls.Certificate
+
+	// When the files were last modified, as of the last load.
+	certMod, keyMod time.Time
+
+	// When the files were last checked.
+	checkedAt time.Time
+}
+
+// newCertReloader loads the certificate from certFile and keyFile, checking
+// whether they changed at most once every interval.
+func newCertReloader(certFile, keyFile string, interval time.Duration) (*certReloader, error) {
+	cr := &certReloader{
+		certFile: certFile,
+		keyFile: keyFile,
+		interval: interval,
+	}
+
+	err := cr.load()
+	if err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// modTimes retrieves when the certificate's files were last modified.
+func (cr *certReloader) modTimes() (certMod, keyMod time.Time, err error) {
+	info, err := os.Stat(cr.certFile)
+	if err != nil {
+		return
+	}
+	certMod = info.ModTime()
+
+	info, err = os.Stat(cr.keyFile)
+	if err != nil {
+		return
+	}
+	keyMod = info.ModTime()
+	return
+}
+
+// load the certificate from its files. Must be called with the mutex held
+// (or before the reloader is shared).
+func (cr *certReloader) load() error {
+	certMod, keyMod, err := cr.modTimes()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+
+	cr.cert = &cert
+	cr.certMod = certMod
+	cr.keyMod = keyMod
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate, reloading the
+// certificate if its files changed. If the new certificate can't be
+// loaded (e.g., because only one of the files was updated so far), the
+// previous one keeps being used.
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+
+	if cr.interval > 0 && time.Since(cr.checkedAt) >= cr.interval {
+		cr.checkedAt = time.Now()
+
+		certMod, keyMod, err := cr.modTimes()
+		if err != nil {
+			slog.Warn("Couldn't check the TLS certificate", "err", err)
+		} else if !certMod.Equal(cr.certMod) || !keyMod.Equal(cr.keyMod) {
+			err = cr.load()
+			if err != nil {
+				slog.Error("Couldn't reload the TLS certificate", "err", err)
+			} else {
+				slog.Info("Reloaded the TLS certificate", "file", cr.certFile)
+			}
+		}
+	}
+
+	return cr.cert, nil
+}
+
+// newACMEManager creates a manager that obtains (and renews) certificates
+// for args.ACMEHosts from an ACME CA (by default, Let's Encrypt). The
+// certificates are kept in args.ACMECacheDir, so they survive restarts.
+//
+// The CA verifies the hosts either through TLS-ALPN-01 challenges, which
+// requires the server to be reachable on port 443, or through HTTP-01
+// challenges, which requires args.ACMEHTTPPort to be reachable on port 80.
+func newACMEManager(args Args) *autocert.Manager {
+	m := &autocert.Manager{
+		Prompt: autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(splitList(args.ACMEHosts)...),
+		Email: args.ACMEEmail,
+	}
+	if len(args.ACMECacheDir) > 0 {
+		m.Cache = autocert.DirCache(args.ACMECacheDir)
+	} else {
+		slog.Warn("ACMECacheDir isn't set! Certificates will be requested again on every start")
+	}
+
+	return m
+}
@@ -0,0 +1,110 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// Name of the service, as reported in traces.
+const serviceName = "sqs-issue-notifier"
+
+// Creates every span in the server. Until tracing is set up, its spans
+// are discarded.
+var tracer = otel.Tracer("github.com/SirGFM/sqs-issue-notifier/server")
+
+// Propagates the trace context through HTTP headers and through the
+// messages' metadata, in the W3C Trace Context format.
+var tracePropagator = propagation.TraceContext{}
+
+// setupTracing exports the spans to the OTLP collector at
+// args.OTLPEndpoint, returning a function that flushes the pending spans
+// and stops exporting them. If tracing is disabled, it returns nil.
+func setupTracing(args Args) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(tracePropagator)
+	if len(args.OTLPEndpoint) == 0 {
+		return nil, nil
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(),
+			otlptracehttp.WithEndpointURL(strings.TrimSuffix(args.OTLPEndpoint, "/") + "/v1/traces"))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create the trace exporter: %w", err)
+	}
+
+	res := resource.NewSchemaless(semconv.ServiceName(serviceName))
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(args.TraceSampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		slog.Warn("Tracing failed", "err", err)
+	}))
+
+	slog.Info("Exporting traces", "endpoint", args.OTLPEndpoint, "sample_ratio", args.TraceSampleRatio)
+	return tp.Shutdown, nil
+}
+
+// traceRequests starts a span for every request, continuing the caller's
+// trace if the request carries its context.
+func traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := tracePropagator.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+
+		res := strings.Split(cleanURL(req.URL), "/")
+		ctx, span := tracer.Start(ctx, req.Method + " /" + res[0],
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(req.Method),
+				semconv.URLPath(req.URL.Path),
+				attribute.String("request_id", requestID(req)),
+			),
+		)
+		defer span.End()
+
+		rec := &responseRecorder{
+			ResponseWriter: w,
+			status: http.StatusOK,
+		}
+		next.ServeHTTP(rec, req.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
+
+// injectTrace stores the trace context of ctx in a map, so it may be kept
+// with a message. It returns nil if ctx isn't traced.
+func injectTrace(ctx context.Context) map[string]string {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return nil
+	}
+
+	carrier := propagation.MapCarrier{}
+	tracePropagator.Inject(ctx, carrier)
+	return carrier
+}
+
+// endSpan ends span, recording err (if any).
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,393 @@
+package notifier
+
+import (
+	"fmt"
+	"github.com/SirGFM/sqs-issue-notifier/server/ipfilter"
+	"github.com/SirGFM/sqs-issue-notifier/server/vault"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// validateArgs checks args for problems that would otherwise only be
+// noticed once the server is running (or not at all), returning every
+// problem found, so they may all be fixed at once.
+func validateArgs(args Args) []error {
+	var errs []error
+	fail := func(format string, a ...any) {
+		errs = append(errs, fmt.Errorf(format, a...))
+	}
+
+	// Ports.
+	if args.Port < 1 || args.Port > 65535 {
+		fail("Port must be between 1 and 65535 (got %d)", args.Port)
+	}
+	if args.GRPCPort < 0 || args.GRPCPort > 65535 {
+		fail("GRPCPort must be between 1 and 65535, or 0 to disable the gRPC API (got %d)", args.GRPCPort)
+	} else if args.GRPCPort > 0 && args.GRPCPort == args.Port {
+		fail("GRPCPort must be different from Port (both are %d)", args.Port)
+	}
+	if len(args.ACMEHosts) > 0 && (args.ACMEHTTPPort < 0 || args.ACMEHTTPPort > 65535) {
+		fail("ACMEHTTPPort must be between 1 and 65535, or 0 to disable HTTP-01 challenges (got %d)", args.ACMEHTTPPort)
+	}
+
+	// Mutually exclusive options.
+	if len(args.ACMEHosts) > 0 && (len(args.CertFile) > 0 || len(args.KeyFile) > 0) {
+		fail("Either ACMEHosts or CertFile/KeyFile may be set, but not both")
+	} else if (len(args.CertFile) > 0) != (len(args.KeyFile) > 0) {
+		fail("CertFile and KeyFile must be set together, to enable TLS")
+	}
+	if len(args.AuthJWKSURL) > 0 && len(args.AuthBasicUsers) > 0 {
+		fail("Either AuthJWKSURL or AuthBasicUsers may be set, but not both")
+	} else if len(args.AuthBasicUsers) > 0 {
+		if _, err := newBasicAuthenticator(args); err != nil {
+			fail("Invalid AuthBasicUsers/AuthBasicAdmins: %v", err)
+		}
+	} else if len(args.AuthBasicAdmins) > 0 {
+		fail("AuthBasicAdmins requires AuthBasicUsers")
+	}
+	if len(args.AuthJWKSURL) > 0 {
+		if err := checkURL(args.AuthJWKSURL); err != nil {
+			fail("Invalid AuthJWKSURL: %v", err)
+		}
+	}
+
+	// The local storage.
+	if len(args.LocalStore) == 0 {
+		fail("LocalStore must be set to the directory where messages are kept until they're sent")
+	} else if err := checkWritableDir(args.LocalStore); err != nil {
+		fail("LocalStore isn't usable: %v", err)
+	}
+
+	// The destination.
+	if len(args.Destinations) > 0 {
+		if len(args.Queue) > 0 || len(args.CollectorAddr) > 0 {
+			fail("Either Destinations or Queue/CollectorAddr may be set, but not both")
+		}
+		errs = append(errs, validateDestinations(args)...)
+	} else if !args.DryRun && len(args.CollectorAddr) == 0 {
+		if err := checkQueueURL(args.Queue); err != nil {
+			fail("Invalid Queue: %v", err)
+		}
+	}
+	if _, err := newRouteTable(args); err != nil {
+		fail("Invalid Routes: %v", err)
+	}
+	if len(args.Endpoint) > 0 {
+		if err := checkURL(args.Endpoint); err != nil {
+			fail("Invalid Endpoint: %v", err)
+		}
+	}
+
+	// Vault.
+	if len(args.VaultAddr) > 0 {
+		if err := checkURL(args.VaultAddr); err != nil {
+			fail("Invalid VaultAddr: %v", err)
+		}
+		switch args.VaultAuthMethod {
+		case vault.MethodToken:
+			if len(args.VaultToken) == 0 {
+				fail("VaultToken must be set, for the '%s' method", args.VaultAuthMethod)
+			}
+		case vault.MethodAppRole:
+			if len(args.VaultRoleID) == 0 || len(args.VaultSecretID) == 0 {
+				fail("VaultRoleID and VaultSecretID must be set, for the '%s' method", args.VaultAuthMethod)
+			}
+		case vault.MethodKubernetes:
+			if len(args.VaultK8sRole) == 0 {
+				fail("VaultK8sRole must be set, for the '%s' method", args.VaultAuthMethod)
+			}
+		default:
+			fail("VaultAuthMethod must be either '%s', '%s' or '%s' (got '%s')", vault.MethodToken, vault.MethodAppRole, vault.MethodKubernetes, args.VaultAuthMethod)
+		}
+	} else if len(args.VaultAWSCredsPath) > 0 {
+		fail("VaultAWSCredsPath requires VaultAddr")
+	}
+
+	// Alerts.
+	alerting := args.AlertBacklog > 0 || args.AlertOldestAgeS > 0 || args.AlertSendFailures > 0
+	if alerting && len(args.AlertSlackWebhookURL) == 0 && len(args.AlertSMTPAddr) == 0 {
+		fail("Alert thresholds require AlertSlackWebhookURL and/or AlertSMTPAddr")
+	}
+	if len(args.AlertSlackWebhookURL) > 0 {
+		// The URL itself is a secret, so it isn't reported.
+		if err := checkURL(args.AlertSlackWebhookURL); err != nil {
+			fail("AlertSlackWebhookURL must be a http:// or https:// URL")
+		}
+	}
+	if len(args.AlertSMTPAddr) > 0 {
+		if _, _, err := net.SplitHostPort(args.AlertSMTPAddr); err != nil {
+			fail("AlertSMTPAddr must be a \"host:port\" address (got '%s')", args.AlertSMTPAddr)
+		}
+		if len(args.AlertEmailFrom) == 0 || len(splitList(args.AlertEmailTo)) == 0 {
+			fail("AlertSMTPAddr requires AlertEmailFrom and AlertEmailTo")
+		}
+	}
+	if alerting && args.AlertIntervalS < 1 {
+		fail("AlertIntervalS must be at least 1 (got %d)", args.AlertIntervalS)
+	}
+
+	// Files that must already exist.
+	files := []struct{
+		name, path string
+		dir bool
+	}{
+		{ name: "CertFile", path: args.CertFile },
+		{ name: "KeyFile", path: args.KeyFile },
+		{ name: "ChannelQuotaFile", path: args.ChannelQuotaFile },
+		{ name: "ChannelSchemaDir", path: args.ChannelSchemaDir, dir: true },
+		{ name: "MessageTemplateFile", path: args.MessageTemplateFile },
+		{ name: "CollectorCAFile", path: args.CollectorCAFile },
+		{ name: "WebIdentityTokenFile", path: args.WebIdentityTokenFile },
+		{ name: "VaultCAFile", path: args.VaultCAFile },
+	}
+	for _, f := range files {
+		if len(f.path) == 0 {
+			continue
+		}
+
+		info, err := os.Stat(f.path)
+		if err != nil {
+			fail("%s can't be read: %v", f.name, err)
+		} else if f.dir && !info.IsDir() {
+			fail("%s must be a directory ('%s' isn't)", f.name, f.path)
+		} else if !f.dir && info.IsDir() {
+			fail("%s must be a file ('%s' is a directory)", f.name, f.path)
+		}
+	}
+
+	// Options with a fixed set of values.
+	if args.LogFormat != logText && args.LogFormat != logJSON {
+		fail("LogFormat must be either '%s' or '%s' (got '%s')", logText, logJSON, args.LogFormat)
+	}
+	if args.LogOutput != logStderr && args.LogOutput != logStdout {
+		fail("LogOutput must be either '%s' or '%s' (got '%s')", logStderr, logStdout, args.LogOutput)
+	}
+	if _, err := logLevels(args); err != nil {
+		fail("Invalid LogLevel/LogLevels: %v", err)
+	}
+	switch args.MetricsSink {
+	case metricsPrometheus, metricsStatsD, metricsDogStatsD:
+	default:
+		fail("MetricsSink must be either '%s', '%s' or '%s' (got '%s')", metricsPrometheus, metricsStatsD, metricsDogStatsD, args.MetricsSink)
+	}
+	if args.DuplicateStatus != http.StatusOK && args.DuplicateStatus != http.StatusConflict {
+		fail("DuplicateStatus must be either %d or %d (got %d)", http.StatusOK, http.StatusConflict, args.DuplicateStatus)
+	}
+	if args.HeartbeatMode != "check" && args.HeartbeatMode != "message" {
+		fail("HeartbeatMode must be either 'check' or 'message' (got '%s')", args.HeartbeatMode)
+	}
+	if _, _, err := channelLimits(args); err != nil {
+		fail("Invalid channel quotas: %v", err)
+	}
+	for _, list := range []struct{ name, value string }{
+		{ "IPAllowList", args.IPAllowList },
+		{ "IPDenyList", args.IPDenyList },
+		{ "TrustedProxies", args.TrustedProxies },
+	} {
+		if _, err := ipfilter.ParsePrefixes(splitList(list.value)); err != nil {
+			fail("%s must be a comma-separated list of addresses or CIDR blocks (got '%s')", list.name, list.value)
+		}
+	}
+
+	// Numeric ranges.
+	if args.ForwarderWorkers < 1 {
+		fail("ForwarderWorkers must be at least 1 (got %d)", args.ForwarderWorkers)
+	}
+	if args.ForwarderWatchdogS > 0 && args.ForwarderWatchdogIntervals < 1 {
+		fail("ForwarderWatchdogIntervals must be at least 1 (got %d)", args.ForwarderWatchdogIntervals)
+	}
+	if args.RetryJitter < 0 || args.RetryJitter > 1 {
+		fail("RetryJitter must be between 0 and 1 (got %v)", args.RetryJitter)
+	}
+	if args.ForwarderBackoffJitter < 0 || args.ForwarderBackoffJitter > 1 {
+		fail("ForwarderBackoffJitter must be between 0 and 1 (got %v)", args.ForwarderBackoffJitter)
+	}
+	if args.MetricsSink != metricsPrometheus && args.StatsDIntervalS < 1 {
+		fail("StatsDIntervalS must be at least 1 (got %d)", args.StatsDIntervalS)
+	}
+	if args.TraceSampleRatio < 0 || args.TraceSampleRatio > 1 {
+		fail("TraceSampleRatio must be between 0 and 1 (got %v)", args.TraceSampleRatio)
+	}
+	nonNegative := []struct{
+		name string
+		value float64
+	}{
+		{ "TimeoutMS", float64(args.TimeoutMS) },
+		{ "AWSTimeoutMS", float64(args.AWSTimeoutMS) },
+		{ "MaxBodyBytes", float64(args.MaxBodyBytes) },
+		{ "ClientRate", args.ClientRate },
+		{ "SendRate", args.SendRate },
+		{ "MaxConnections", float64(args.MaxConnections) },
+		{ "MaxInFlightPosts", float64(args.MaxInFlightPosts) },
+		{ "ChannelMaxPending", float64(args.ChannelMaxPending) },
+		{ "ChannelMaxPerMinute", float64(args.ChannelMaxPerMinute) },
+		{ "HeartbeatMinutes", float64(args.HeartbeatMinutes) },
+		{ "AlertBacklog", float64(args.AlertBacklog) },
+		{ "AlertOldestAgeS", float64(args.AlertOldestAgeS) },
+		{ "AlertSendFailures", float64(args.AlertSendFailures) },
+		{ "AlertRepeatMinutes", float64(args.AlertRepeatMinutes) },
+		{ "IdempotencyWindowS", float64(args.IdempotencyWindowS) },
+		{ "ForwarderStuckS", float64(args.ForwarderStuckS) },
+		{ "ForwarderWatchdogS", float64(args.ForwarderWatchdogS) },
+		{ "DrainOnExitS", float64(args.DrainOnExitS) },
+		{ "MaxInFlight", float64(args.MaxInFlight) },
+		{ "ForwarderBackoffBaseMS", float64(args.ForwarderBackoffBaseMS) },
+		{ "ForwarderBackoffMaxMS", float64(args.ForwarderBackoffMaxMS) },
+		{ "LogMaxSizeMB", float64(args.LogMaxSizeMB) },
+		{ "LogRotateHours", float64(args.LogRotateHours) },
+		{ "LogMaxBackups", float64(args.LogMaxBackups) },
+		{ "LogMaxAgeDays", float64(args.LogMaxAgeDays) },
+		{ "AuditLogMaxSizeMB", float64(args.AuditLogMaxSizeMB) },
+		{ "AuditLogRotateHours", float64(args.AuditLogRotateHours) },
+		{ "AuditLogMaxBackups", float64(args.AuditLogMaxBackups) },
+		{ "AuditLogMaxAgeDays", float64(args.AuditLogMaxAgeDays) },
+	}
+	for _, opt := range nonNegative {
+		if opt.value < 0 {
+			fail("%s must not be negative (got %v)", opt.name, opt.value)
+		}
+	}
+
+	return errs
+}
+
+// validateDestinations checks every destination in args.Destinations,
+// returning every problem found.
+func validateDestinations(args Args) []error {
+	var errs []error
+	fail := func(i int, format string, a ...any) {
+		errs = append(errs, fmt.Errorf("Destinations[%d]: " + format, append([]any{i}, a...)...))
+	}
+
+	names := make(map[string]bool)
+	for i, d := range args.Destinations {
+		if len(d.Name) == 0 {
+			fail(i, "Name must be set")
+		} else if names[d.Name] {
+			fail(i, "Name must be unique ('%s' is repeated)", d.Name)
+		}
+		names[d.Name] = true
+		if d.TimeoutMS < 0 {
+			fail(i, "TimeoutMS must not be negative (got %d)", d.TimeoutMS)
+		}
+
+		switch d.Type {
+		case "", destinationSQS:
+			if err := checkQueueURL(d.Queue); err != nil {
+				fail(i, "Invalid Queue: %v", err)
+			}
+			if len(d.Endpoint) > 0 {
+				if err := checkURL(d.Endpoint); err != nil {
+					fail(i, "Invalid Endpoint: %v", err)
+				}
+			}
+		case destinationGRPC:
+			if len(d.Endpoint) == 0 {
+				fail(i, "Endpoint must be set to the collector's address")
+			}
+			if len(d.CAFile) > 0 {
+				if _, err := os.Stat(d.CAFile); err != nil {
+					fail(i, "CAFile can't be read: %v", err)
+				}
+			}
+		case destinationGitHub:
+			if owner, name, ok := strings.Cut(d.Repo, "/"); !ok || len(owner) == 0 || len(name) == 0 {
+				fail(i, "Repo must be set to the repository, as \"owner/name\"")
+			}
+			if len(d.Token) == 0 && len(args.GitHubToken) == 0 {
+				fail(i, "Token (or GitHubToken) must be set")
+			}
+			if len(d.Endpoint) > 0 {
+				if err := checkURL(d.Endpoint); err != nil {
+					fail(i, "Invalid Endpoint: %v", err)
+				}
+			}
+			// Issues would hold the encrypted (or partial) messages.
+			if len(args.EncryptionKMSKeyID) > 0 || args.ChunkMessages {
+				fail(i, "Issues can't be filed while EncryptionKMSKeyID or ChunkMessages is set")
+			}
+		case destinationDryRun:
+		default:
+			fail(i, "Type must be either '%s', '%s', '%s' or '%s' (got '%s')", destinationSQS, destinationGRPC, destinationGitHub, destinationDryRun, d.Type)
+		}
+	}
+
+	return errs
+}
+
+// requireValidArgs logs every problem in args, as found by validateArgs,
+// and exits if there's any.
+func requireValidArgs(args Args) {
+	errs := validateArgs(args)
+	for _, err := range errs {
+		slog.Error("Invalid configuration", "err", err)
+	}
+	if len(errs) > 0 {
+		fatal("Fix the configuration and try again", "problems", len(errs))
+	}
+}
+
+// checkURL checks whether raw is an absolute HTTP(S) URL.
+func checkURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("'%s' must start with http:// or https://", raw)
+	} else if len(u.Host) == 0 {
+		return fmt.Errorf("'%s' doesn't have a host", raw)
+	}
+	return nil
+}
+
+// checkQueueURL checks whether raw looks like a SQS queue's URL:
+// "https://sqs.<region>.amazonaws.com/<account ID>/<queue name>", or the
+// equivalent in a simulator (e.g., localstack).
+func checkQueueURL(raw string) error {
+	const shape = "(expected e.g. 'https://sqs.us-east-1.amazonaws.com/123456789012/issues-queue')"
+
+	if len(raw) == 0 {
+		return fmt.Errorf("the queue's URL must be set, unless DryRun or CollectorAddr is set %s", shape)
+	} else if err := checkURL(raw); err != nil {
+		return fmt.Errorf("%w %s", err, shape)
+	}
+
+	u, _ := url.Parse(raw)
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return fmt.Errorf("'%s' must end with '/<account ID>/<queue name>' %s", raw, shape)
+	}
+	return nil
+}
+
+// checkWritableDir checks whether files may be created in dir. If dir
+// doesn't exist, its closest existing parent must be writable, so dir may
+// be created.
+func checkWritableDir(dir string) error {
+	for {
+		info, err := os.Stat(dir)
+		if os.IsNotExist(err) && filepath.Dir(dir) != dir {
+			dir = filepath.Dir(dir)
+			continue
+		} else if err != nil {
+			return err
+		} else if !info.IsDir() {
+			return fmt.Errorf("'%s' isn't a directory", dir)
+		}
+		break
+	}
+
+	// Create a directory, instead of a file, as the local storage ignores
+	// directories, even if it's already running.
+	tmp, err := os.MkdirTemp(dir, ".validate-*")
+	if err != nil {
+		return fmt.Errorf("'%s' isn't writable: %w", dir, err)
+	}
+	os.Remove(tmp)
+	return nil
+}
@@ -0,0 +1,140 @@
+package notifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+)
+
+// The build's version, commit and date, set by SetBuildInfo. If they aren't
+// set, they're retrieved from the build information embedded by the Go
+// toolchain.
+var (
+	version string
+	commit string
+	buildDate string
+)
+
+// SetBuildInfo sets the build's version, commit and date (e.g., as set
+// when building the binary), reported on startup, by the version command
+// and on GET /version. Empty values are retrieved from the build
+// information embedded by the Go toolchain.
+func SetBuildInfo(buildVersion, buildCommit, date string) {
+	version = buildVersion
+	commit = buildCommit
+	buildDate = date
+}
+
+// buildInfo identifies the running build.
+type buildInfo struct {
+	// The build's version (e.g., "v1.2.3"), or "devel" if unknown.
+	Version string
+
+	// The commit the build was made from. Empty if unknown.
+	Commit string
+
+	// When the build was made (or, if unknown, when the commit was made).
+	// Empty if unknown.
+	BuildDate string
+
+	// Whether the build had uncommitted changes.
+	Modified bool `json:",omitempty"`
+
+	// The Go version used for the build.
+	GoVersion string
+}
+
+// getBuildInfo retrieves the running build's information, preferring the
+// values set when building over the ones embedded by the Go toolchain.
+func getBuildInfo() buildInfo {
+	info := buildInfo{
+		Version: version,
+		Commit: commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if len(info.Version) == 0 && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if len(info.Commit) == 0 {
+					info.Commit = s.Value
+				}
+			case "vcs.time":
+				if len(info.BuildDate) == 0 {
+					info.BuildDate = s.Value
+				}
+			case "vcs.modified":
+				// Only meaningful for the embedded commit.
+				info.Modified = len(commit) == 0 && s.Value == "true"
+			}
+		}
+	}
+	if len(info.Version) == 0 {
+		info.Version = "devel"
+	}
+
+	return info
+}
+
+// String formats the build's information in a single line.
+func (bi buildInfo) String() string {
+	s := bi.Version
+	if len(bi.Commit) > 0 {
+		s += " (" + bi.Commit
+		if bi.Modified {
+			s += ", modified"
+		}
+		s += ")"
+	}
+	if len(bi.BuildDate) > 0 {
+		s += " built on " + bi.BuildDate
+	}
+	return s + " with " + bi.GoVersion
+}
+
+// runVersion prints the build's information.
+func runVersion(args Args, params []string) {
+	if len(params) > 0 {
+		fatal("Unexpected arguments", "args", params)
+	}
+
+	fmt.Fprintln(os.Stdout, getBuildInfo())
+}
+
+// GetVersion handles GET requests on the 'version' resource, returning the
+// running build's information.
+func (s *server) GetVersion(w http.ResponseWriter, req *http.Request, res []string) {
+	if len(res) > 1 {
+		httpTextReply(http.StatusNotFound, "Invalid resource", w)
+		return
+	}
+
+	info := getBuildInfo()
+	switch req.Header.Get("Accept") {
+	case "application/json":
+		data, err := json.Marshal(&info)
+		if err != nil {
+			serr := "Failed to encode the response"
+			httpTextReply(http.StatusInternalServerError, serr, w)
+			reqLogger(req).Error(serr, "err", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		writeData(data, w)
+	default:
+		// By default, force "text/plain"
+		fallthrough
+	case "text/plain":
+		httpTextReply(http.StatusOK, info.String(), w)
+	}
+}
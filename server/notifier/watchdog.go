@@ -0,0 +1,121 @@
+package notifier
+
+import (
+	"github.com/SirGFM/sqs-issue-notifier/server/forwarder"
+	"github.com/SirGFM/sqs-issue-notifier/server/local_storage"
+	"github.com/SirGFM/sqs-issue-notifier/server/metrics"
+	"github.com/SirGFM/sqs-issue-notifier/server/sender"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// watchdog periodically checks whether the forwarder stalled: the backlog
+// isn't empty, but no message was retrieved or sent for a few intervals
+// (e.g., if the workers missed being woken by the local storage). A
+// stalled forwarder is logged, counted and force-woken.
+type watchdog struct {
+	// The local storage whose backlog is watched.
+	store local_storage.Store
+
+	// The forwarder being watched.
+	fw *forwarder.Forwarder
+
+	// The circuit breaker, reported in the diagnostics. Nil if disabled.
+	breaker *sender.CircuitBreaker
+
+	// For how long the forwarder may be inactive, with a non-empty
+	// backlog, before it's considered stalled.
+	maxIdle time.Duration
+
+	// Times the forwarder was found stalled. Nil if the metrics are
+	// disabled.
+	stalls *metrics.Counter
+
+	// Closed to stop the watchdog.
+	stop chan struct{}
+
+	// Ensures that the watchdog is only stopped once.
+	closeOnce sync.Once
+}
+
+// startWatchdog launches a goroutine that checks fw every
+// args.ForwarderWatchdogS, force-waking it after it's been inactive for
+// args.ForwarderWatchdogIntervals checks while store has messages. The
+// stalls are counted in reg (if not nil). It returns nil if the watchdog
+// is disabled.
+func startWatchdog(args Args, store local_storage.Store, fw *forwarder.Forwarder, breaker *sender.CircuitBreaker, reg *metrics.Registry) *watchdog {
+	if args.ForwarderWatchdogS <= 0 {
+		return nil
+	}
+
+	interval := time.Duration(args.ForwarderWatchdogS) * time.Second
+	wd := &watchdog{
+		store: store,
+		fw: fw,
+		breaker: breaker,
+		maxIdle: time.Duration(max(args.ForwarderWatchdogIntervals, 1)) * interval,
+		stop: make(chan struct{}),
+	}
+	if reg != nil {
+		wd.stalls = reg.Counter(forwarderMetricsPrefix + "_stalls_total", "Times the forwarder was found inactive with a non-empty backlog, and force-woken.")
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-wd.stop:
+				return
+			case <-ticker.C:
+				wd.check()
+			}
+		}
+	} ()
+
+	return wd
+}
+
+// check whether the forwarder stalled, force-waking it if so.
+func (wd *watchdog) check() {
+	backlog := wd.store.Count()
+	idle := time.Since(wd.fw.LastActive())
+	if backlog == 0 || idle < wd.maxIdle || wd.fw.Paused() || wd.fw.Busy() > 0 {
+		// Either there's nothing to forward, the forwarder is
+		// working, or it was paused on purpose.
+		return
+	}
+
+	diag := []any{
+		"backlog", backlog,
+		"idle", idle.Truncate(time.Second),
+		"backoff", wd.fw.Backoff(),
+		"sent", wd.fw.Sent(),
+	}
+	if entry, err := wd.store.Oldest(); err == nil {
+		diag = append(diag, "oldest_id", entry.ID, "oldest_age", time.Since(entry.StoredAt).Truncate(time.Second))
+	}
+	if wd.breaker != nil {
+		diag = append(diag, "breaker", wd.breaker.State().String(), "breaker_retry_in", wd.breaker.RetryIn())
+	}
+	slog.Error("The forwarder seems to have stalled, waking it", diag...)
+
+	if wd.stalls != nil {
+		wd.stalls.Inc()
+	}
+	wd.fw.Wake()
+}
+
+// Close stops the watchdog.
+func (wd *watchdog) Close() error {
+	if wd == nil {
+		return nil
+	}
+
+	wd.closeOnce.Do(func() {
+		close(wd.stop)
+	})
+	return nil
+}
@@ -0,0 +1,1465 @@
+package notifier
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/SirGFM/sqs-issue-notifier/server/auth"
+	"github.com/SirGFM/sqs-issue-notifier/server/chanquota"
+	"github.com/SirGFM/sqs-issue-notifier/server/clientlimit"
+	"github.com/SirGFM/sqs-issue-notifier/server/forwarder"
+	"github.com/SirGFM/sqs-issue-notifier/server/health"
+	"github.com/SirGFM/sqs-issue-notifier/server/idempotency"
+	"github.com/SirGFM/sqs-issue-notifier/server/ipfilter"
+	"github.com/SirGFM/sqs-issue-notifier/server/local_storage"
+	"github.com/SirGFM/sqs-issue-notifier/server/metrics"
+	"github.com/SirGFM/sqs-issue-notifier/server/msgschema"
+	"github.com/SirGFM/sqs-issue-notifier/server/sender"
+	"golang.org/x/net/netutil"
+	"google.golang.org/grpc"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// endpoint allows associating a given (resource, method) to its handler in
+// a map.
+type endpoint struct {
+	// The resource (i.e., the first field in the URL).
+	resource string
+
+	// The HTTP method associated with the method.
+	method string
+}
+
+// endpointHandler defines a function used to handle HTTP requests, much
+// like http.HandlerFunc. Differently from that, this type also accepts
+// the decoded and split path as res.
+type endpointHandler func(w http.ResponseWriter, req *http.Request, res []string)
+
+// server wraps the httpServer and all of its components, so it may be
+// gracefully stopped.
+type server struct {
+	// The server's HTTP server.
+	httpServer *http.Server
+
+	// Handlers for each endpoint.
+	handlers map[endpoint]endpointHandler
+
+	// Run on every request, before its handler.
+	middleware []Middleware
+
+	// The local storage where messages are stored.
+	store local_storage.Store
+
+	// Forwards the messages in the local storage.
+	forwarder *forwarder.Forwarder
+
+	// Broadcasts the pipeline's events.
+	events *eventHub
+
+	// Interval between the statistics streamed as Server-Sent Events.
+	eventsInterval time.Duration
+
+	// Verifies GitHub's webhooks. Empty if disabled.
+	githubSecret []byte
+
+	// Verifies GitLab's webhooks. Empty if disabled.
+	gitlabSecret []byte
+
+	// Verifies Sentry's webhooks. Empty if disabled.
+	sentrySecret []byte
+
+	// Verifies Slack's requests. Empty if disabled.
+	slackSecret []byte
+
+	// Which cross-origin requests are accepted. Nil if disabled.
+	cors *corsPolicy
+
+	// The pipeline's heartbeat. Nil if disabled.
+	heartbeat *heartbeat
+
+	// Authenticates requests. Nil if authentication is disabled.
+	auth auth.Authenticator
+
+	// HTTP server answering ACME challenges. Nil if disabled.
+	acmeServer *http.Server
+
+	// HTTP server exposing the pprof handlers. Nil if disabled.
+	pprofServer *http.Server
+
+	// HTTP server exposing the administrative endpoints. Nil if disabled.
+	adminServer *http.Server
+
+	// gRPC server exposing the ingest API. Nil if disabled.
+	grpcServer *grpc.Server
+
+	// Authenticates the requests to adminServer. Nil if not
+	// authenticated.
+	adminAuth auth.Authenticator
+
+	// Limits the rate of requests from each client. Nil if disabled. It
+	// may be replaced when the configuration is reloaded.
+	limiter atomic.Pointer[clientlimit.Limiter]
+
+	// Slots of the POST requests that may be handled at once. Nil if
+	// unlimited.
+	inFlight chan struct{}
+
+	// Validates messages against their channel's schema. Nil if disabled.
+	schemas *msgschema.Validator
+
+	// Limits the messages accepted for each channel. Nil if disabled.
+	quotas *chanquota.Quotas
+
+	// Sets the priority of each channel's messages. Nil if disabled. It's
+	// replaced when the configuration is reloaded.
+	routes atomic.Pointer[routeTable]
+
+	// Accepts clients based on their address. Nil if every client is
+	// accepted.
+	ipFilter *ipfilter.Filter
+
+	// Reverse proxies trusted to set the X-Forwarded-For header.
+	trustedProxies []netip.Prefix
+
+	// Whether the server is draining the backlog before exiting, so new
+	// messages are rejected.
+	draining atomic.Bool
+
+	// Which optional resources are served on the main address.
+	features features
+
+	// The metrics exported on the 'metrics' resource.
+	metrics *metrics.Registry
+
+	// Messages rejected as already stored, by their channel.
+	duplicates *metrics.Counter
+
+	// Status replied to a POST on 'message' whose message was already
+	// stored.
+	duplicateStatus int
+
+	// Where the components report their health.
+	health *health.Registry
+
+	// When the server started.
+	startedAt time.Time
+
+	// Ensures that the servers are only closed once.
+	closeOnce sync.Once
+
+	// Summary of the server's configuration, reported on 'status'.
+	config statusConfig
+}
+
+// Drain stops accepting new messages (which are answered with 503 Service
+// Unavailable, or Unavailable over gRPC), so the backlog may be drained
+// before the server exits. Every other request is still handled.
+func (s *server) Drain() {
+	s.draining.Store(true)
+}
+
+// Close the running servers immediately, dropping the requests being
+// handled. It's safe to call more than once, and from any goroutine. The
+// servers are kept set (although closed), as requests still being handled
+// may check them (e.g., whether there's an admin listener).
+func (s *server) Close() error {
+	s.closeOnce.Do(func() {
+		for _, hs := range s.httpServers() {
+			hs.Close()
+		}
+		if s.grpcServer != nil {
+			s.grpcServer.Stop()
+		}
+	})
+
+	return nil
+}
+
+// httpServers retrieves every HTTP server that was started.
+func (s *server) httpServers() []*http.Server {
+	var list []*http.Server
+	for _, hs := range []*http.Server{s.httpServer, s.acmeServer, s.pprofServer, s.adminServer} {
+		if hs != nil {
+			list = append(list, hs)
+		}
+	}
+	return list
+}
+
+// Shutdown the running servers gracefully: they stop accepting connections
+// and wait for the requests being handled, until ctx is done (at which
+// point they're closed), so none is dropped.
+func (s *server) Shutdown(ctx context.Context) error {
+	for _, hs := range s.httpServers() {
+		hs.Shutdown(ctx)
+	}
+	if s.grpcServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			s.grpcServer.GracefulStop()
+			close(stopped)
+		} ()
+
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+		}
+	}
+
+	// Close whatever didn't finish in time.
+	return s.Close()
+}
+
+// ServeHTTP routes each request to its endpoint's handler, once it went
+// through every middleware.
+func (s *server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	uri := cleanURL(req.URL)
+	res := strings.Split(uri, "/") 
+
+	if len(res) == 0 {
+		httpTextReply(http.StatusNotFound, "No resource was specified", w)
+		return
+	}
+
+	f, ok := s.handlers[endpoint{res[0], req.Method}]
+	if !ok || f == nil {
+		s.unhandledMethod(w, req, res)
+		return
+	}
+
+	f(w, req, res)
+}
+
+// allowedMethods lists the methods accepted by resource, including HEAD (if
+// it accepts GET) and OPTIONS. It's empty if the resource doesn't exist.
+func (s *server) allowedMethods(resource string) []string {
+	var methods []string
+	for e, f := range s.handlers {
+		if e.resource == resource && f != nil {
+			methods = append(methods, e.method)
+		}
+	}
+	if len(methods) == 0 {
+		return nil
+	}
+
+	if slices.Contains(methods, http.MethodGet) {
+		methods = append(methods, http.MethodHead)
+	}
+	methods = append(methods, http.MethodOptions)
+	slices.Sort(methods)
+	return methods
+}
+
+// unhandledMethod handles requests whose method doesn't have its own
+// handler: HEAD requests are handled as GET requests, without the body,
+// and OPTIONS requests list the resource's methods. Otherwise, it replies
+// with either 404, if the resource doesn't exist, or 405.
+func (s *server) unhandledMethod(w http.ResponseWriter, req *http.Request, res []string) {
+	methods := s.allowedMethods(res[0])
+	if len(methods) == 0 {
+		httpTextReply(http.StatusNotFound, "Invalid resource", w)
+		return
+	}
+	allow := strings.Join(methods, ", ")
+
+	switch req.Method {
+	case http.MethodHead:
+		if f := s.handlers[endpoint{res[0], http.MethodGet}]; f != nil {
+			f(headResponseWriter{w}, req, res)
+			return
+		}
+	case http.MethodOptions:
+		w.Header().Set("Allow", allow)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Allow", allow)
+	httpTextReply(http.StatusMethodNotAllowed, "Method not allowed", w)
+}
+
+// headResponseWriter discards the body of replies to HEAD requests, which
+// are handled by the resource's GET handler.
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+// Write discards b.
+func (hw headResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// Unwrap retrieves the wrapped http.ResponseWriter, for
+// http.ResponseController.
+func (hw headResponseWriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
+
+// clientKey identifies the client that sent req, for rate limiting:
+// authenticated clients are identified by their subject, and anonymous ones
+// by their IP address.
+func clientKey(req *http.Request) string {
+	if p, ok := auth.FromContext(req.Context()); ok && len(p.Subject) > 0 {
+		return "subject:" + p.Subject
+	}
+
+	return "ip:" + remoteHost(req)
+}
+
+// remoteHost retrieves the host in req.RemoteAddr, without its port.
+func remoteHost(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// requireAdmin checks whether the request was authenticated by an
+// administrator, replying with an error otherwise. Administrative endpoints
+// are disabled if authentication is disabled.
+//
+// If the admin listener is enabled, administrative endpoints are only
+// served by it, and are disabled only if it isn't authenticated at all.
+// Otherwise, they may be disabled by EnableAdmin.
+func (s *server) requireAdmin(w http.ResponseWriter, req *http.Request, res []string) bool {
+	if (s.adminServer != nil || !s.features.admin) && !fromAdminListener(req) {
+		httpTextReply(http.StatusNotFound, "Invalid resource", w)
+		return false
+	} else if s.adminServer != nil && s.adminAuth == nil {
+		return true
+	}
+
+	p, ok := auth.FromContext(req.Context())
+	if !ok {
+		httpTextReply(http.StatusForbidden, "Administrative endpoints require authentication", w)
+		reqLogger(req).Info("Forbidden: authentication is disabled")
+		return false
+	} else if !p.Admin {
+		httpTextReply(http.StatusForbidden, "Forbidden", w)
+		reqLogger(req).Info("Forbidden: not an administrator", "subject", p.Subject)
+		return false
+	}
+
+	return true
+}
+
+// storedEntry is the representation of a message kept in the local
+// storage, as returned by the server.
+type storedEntry struct {
+	// Identifies the message in the local storage.
+	ID string
+
+	// When the message was received.
+	StoredAt time.Time
+
+	// Whether the message is currently being sent.
+	InFlight bool
+
+	// The message, as stored.
+	Body string
+}
+
+// storedReply is the response to a message that was stored.
+type storedReply struct {
+	// Identifies the message in the local storage (e.g., on
+	// 'message/<id>').
+	ID string
+
+	// Whether an identical message was already stored, in which case it
+	// wasn't stored again.
+	Duplicate bool
+
+	// How many pending messages will be sent before this one.
+	Position int
+}
+
+// getMessageByID handles GET requests on 'message/<id>', returning the
+// message identified by id (and its metadata). Only administrators may
+// inspect messages.
+func (s *server) getMessageByID(w http.ResponseWriter, req *http.Request, res []string) {
+	if !requireFeature(s.features.listing, w, req) || !s.requireAdmin(w, req, res) {
+		return
+	}
+
+	id, err := url.PathUnescape(res[1])
+	if err != nil {
+		httpTextReply(http.StatusBadRequest, "Invalid ID", w)
+		reqLogger(req).Info("Invalid ID", "err", err)
+		return
+	}
+
+	entry, err := s.store.Lookup(id)
+	if err == local_storage.ErrNotFound || err == local_storage.ErrInvalidID {
+		httpTextReply(http.StatusNotFound, "Message not found", w)
+		return
+	} else if err != nil {
+		serr := "Failed to retrieve the message"
+		httpTextReply(http.StatusInternalServerError, serr, w)
+		reqLogger(req).Error(serr, "err", err)
+		return
+	}
+
+	resp := storedEntry{
+		ID: entry.ID,
+		StoredAt: entry.StoredAt,
+		InFlight: entry.InFlight,
+		Body: string(entry.Bytes),
+	}
+
+	switch req.Header.Get("Accept") {
+	case "application/json":
+		data, err := json.Marshal(&resp)
+		if err != nil {
+			serr := "Failed to encode the response"
+			httpTextReply(http.StatusInternalServerError, serr, w)
+			reqLogger(req).Error(serr, "err", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		writeData(data, w)
+	default:
+		// By default, force "text/plain"
+		fallthrough
+	case "text/plain":
+		msg := fmt.Sprintf("ID: %s\nStored at: %s\nIn flight: %+v\n\n%s",
+				resp.ID, resp.StoredAt.Format(time.RFC3339), resp.InFlight, resp.Body)
+		httpTextReply(http.StatusOK, msg, w)
+	}
+}
+
+// listMessages lists every message waiting to be sent, oldest first. Only
+// administrators may list them.
+func (s *server) listMessages(w http.ResponseWriter, req *http.Request, res []string) {
+	if !requireFeature(s.features.listing, w, req) || !s.requireAdmin(w, req, res) {
+		return
+	}
+
+	entries, err := s.store.Entries()
+	if err != nil {
+		serr := "Failed to list the messages"
+		httpTextReply(http.StatusInternalServerError, serr, w)
+		reqLogger(req).Error(serr, "err", err)
+		return
+	}
+
+	resp := make([]storedEntry, 0, len(entries))
+	for _, entry := range entries {
+		resp = append(resp, storedEntry{
+			ID: entry.ID,
+			StoredAt: entry.StoredAt,
+			InFlight: entry.InFlight,
+			Body: string(entry.Bytes),
+		})
+	}
+
+	switch req.Header.Get("Accept") {
+	case "application/json":
+		data, err := json.Marshal(&resp)
+		if err != nil {
+			serr := "Failed to encode the response"
+			httpTextReply(http.StatusInternalServerError, serr, w)
+			reqLogger(req).Error(serr, "err", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		writeData(data, w)
+	default:
+		// By default, force "text/plain"
+		fallthrough
+	case "text/plain":
+		var msg strings.Builder
+
+		fmt.Fprintf(&msg, "Messages: %d\n", len(resp))
+		for _, entry := range resp {
+			fmt.Fprintf(&msg, "%s (%s): %s\n", entry.ID, entry.StoredAt.Format(time.RFC3339), entry.Body)
+		}
+		httpTextReply(http.StatusOK, msg.String(), w)
+	}
+}
+
+// GetMessage handles GET requests on the 'message' resource, returning the
+// number of messages currently stored in the server. Administrators may
+// also inspect a single message on 'message/<id>', or list every message
+// on 'message?list'.
+func (s *server) GetMessage(w http.ResponseWriter, req *http.Request, res []string) {
+	num := s.store.Count()
+
+	if len(res) == 2 {
+		s.getMessageByID(w, req, res)
+		return
+	} else if len(res) > 2 {
+		httpTextReply(http.StatusNotFound, "Invalid resource", w)
+		return
+	} else if req.URL.Query().Has("list") {
+		s.listMessages(w, req, res)
+		return
+	} else if !requireFeature(s.features.count, w, req) {
+		return
+	}
+
+	switch req.Header.Get("Accept") {
+	case "application/json":
+		resp := struct{MessageCount int}{num}
+		data, err := json.Marshal(&resp)
+		if err != nil {
+			serr := "Failed to encode the response"
+			httpTextReply(http.StatusInternalServerError, serr, w)
+			reqLogger(req).Error(serr, "err", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		writeData(data, w)
+	default:
+		// By default, force "text/plain"
+		fallthrough
+	case "text/plain":
+		msg := fmt.Sprintf("Local message count: %d", num)
+		httpTextReply(http.StatusOK, msg, w)
+	}
+}
+
+// PostMessage handles POST requests on the 'message' resource, accepting a
+// single message (in any format accepted by decodeMessage) and forwarding it
+// to the local storage. Administrators may also requeue a dead-lettered
+// message on 'message/<id>/requeue'.
+//
+// The message may be delayed in the queue by setting either its
+// DelaySeconds field or the X-Delay-Seconds header. Likewise, its priority
+// may be set either in its Priority field or in the X-Priority header.
+func (s *server) PostMessage(w http.ResponseWriter, req *http.Request, res []string) {
+	if len(res) == 3 && res[2] == "requeue" {
+		s.requeueMessage(w, req, res)
+		return
+	} else if len(res) > 1 {
+		httpTextReply(http.StatusNotFound, "Invalid resource", w)
+		return
+	}
+
+	msg, err := decodeMessage(req)
+	if err == errUnsupportedMedia {
+		reqLogger(req).Info("Unsupported Content-Type", "content_type", req.Header.Get("Content-Type"))
+		httpTextReply(http.StatusUnsupportedMediaType, "Unsupported Content-Type", w)
+		return
+	} else if tooLarge := (*http.MaxBytesError)(nil); errors.As(err, &tooLarge) {
+		serr := fmt.Sprintf("The request body is larger than %d bytes", tooLarge.Limit)
+		httpTextReply(http.StatusRequestEntityTooLarge, serr, w)
+		reqLogger(req).Info(serr)
+		return
+	} else if err == errMissingChannel {
+		reqLogger(req).Info("Failed to parse request", "err", err)
+		httpTextReply(http.StatusBadRequest, "Missing channel", w)
+		return
+	} else if err != nil {
+		reqLogger(req).Info("Failed to parse request", "err", err)
+		httpTextReply(http.StatusBadRequest, "Invalid data", w)
+		return
+	}
+
+	if p, ok := auth.FromContext(req.Context()); ok && !p.CanPost(msg.Channel) {
+		serr := fmt.Sprintf("Not allowed to post to '%s'", msg.Channel)
+		httpTextReply(http.StatusForbidden, serr, w)
+		reqLogger(req).Info(serr, "subject", p.Subject)
+		return
+	}
+
+	// The delay may also be requested in a header, for clients that can't
+	// modify the message itself.
+	if hdr := req.Header.Get("X-Delay-Seconds"); len(hdr) > 0 && msg.DelaySeconds == 0 {
+		msg.DelaySeconds, err = strconv.ParseInt(hdr, 10, 64)
+		if err != nil {
+			reqLogger(req).Info("Invalid X-Delay-Seconds", "err", err)
+			httpTextReply(http.StatusBadRequest, "Invalid X-Delay-Seconds", w)
+			return
+		}
+	}
+	if max := int64(sender.MaxDelay / time.Second); msg.DelaySeconds < 0 || msg.DelaySeconds > max {
+		serr := fmt.Sprintf("DelaySeconds must be between 0 and %d", max)
+		httpTextReply(http.StatusBadRequest, serr, w)
+		reqLogger(req).Info(serr, "delay_seconds", msg.DelaySeconds)
+		return
+	}
+
+	if hdr := req.Header.Get(priorityHeader); len(hdr) > 0 && msg.Priority == local_storage.PriorityNormal {
+		err = msg.Priority.UnmarshalText([]byte(hdr))
+		if err != nil {
+			reqLogger(req).Info("Invalid X-Priority", "err", err)
+			httpTextReply(http.StatusBadRequest, "Invalid X-Priority", w)
+			return
+		}
+	}
+	if msg.Priority < local_storage.PriorityLow || msg.Priority > local_storage.PriorityHigh {
+		httpTextReply(http.StatusBadRequest, "Invalid priority", w)
+		reqLogger(req).Info("Invalid priority", "priority", int(msg.Priority))
+		return
+	}
+
+	stored, ok := s.storeMessage(w, req, res, msg)
+	if !ok {
+		return
+	}
+
+	position, err := s.store.Position(stored.ID)
+	if err == nil {
+		stored.Position = position
+	}
+
+	data, err := json.Marshal(&stored)
+	if err != nil {
+		serr := "Failed to encode the response"
+		httpTextReply(http.StatusInternalServerError, serr, w)
+		reqLogger(req).Error(serr, "err", err)
+		return
+	}
+
+	status := http.StatusCreated
+	if stored.Duplicate {
+		status = s.duplicateStatus
+	}
+	w.Header().Set("Location", "/message/" + url.PathEscape(stored.ID))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	writeData(data, w)
+}
+
+// storeMessage validates msg against its channel's schema and keeps it in
+// the local storage, to be sent later. Duplicated messages aren't stored
+// again, but are still considered successful. On failure, it replies with
+// the error and returns false.
+func (s *server) storeMessage(w http.ResponseWriter, req *http.Request, res []string, msg storedMessage) (storedReply, bool) {
+	if s.draining.Load() {
+		w.Header().Set("Retry-After", "1")
+		httpTextReply(http.StatusServiceUnavailable, "The server is shutting down", w)
+		reqLogger(req).Info("Rejected a message: the server is shutting down")
+		return storedReply{}, false
+	}
+
+	if s.schemas != nil {
+		err := s.schemas.Validate(msg.Channel, msg.Message)
+		if verr, ok := err.(*msgschema.Error); ok {
+			resp := validationError{
+				Error: fmt.Sprintf("The message doesn't match the schema of '%s'", msg.Channel),
+			}
+			for _, d := range verr.Details {
+				resp.Details = append(resp.Details, validationDetail(d))
+			}
+			validationReply(w, req, res, resp)
+			return storedReply{}, false
+		} else if err != nil {
+			serr := "Failed to validate the message"
+			httpTextReply(http.StatusInternalServerError, serr, w)
+			reqLogger(req).Error(serr, "err", err)
+			return storedReply{}, false
+		}
+	}
+
+	if !s.admitMessage(w, req, msg.Channel) {
+		return storedReply{}, false
+	}
+
+	s.routes.Load().applyPriority(&msg)
+
+	// Keep the request's ID and trace context, so the message may be
+	// traced until it's delivered.
+	msg.RequestID = requestID(req)
+	msg.TraceContext = injectTrace(req.Context())
+
+	// Re-encode the message, to possibly add more fields.
+	data, err := json.Marshal(&msg)
+	if err != nil {
+		serr := "Failed to encode the message"
+		httpTextReply(http.StatusInternalServerError, serr, w)
+		reqLogger(req).Error(serr, "err", err)
+		return storedReply{}, false
+	}
+
+	_, span := tracer.Start(req.Context(), "local_storage.Store")
+	id, err := s.store.StorePriority(data, msg.Priority)
+	if err == local_storage.ErrDuplicatedStore {
+		span.End()
+		reqLogger(req).Info("The message was already stored", "id", id, "channel", msg.Channel)
+		s.recordDuplicate(id, msg)
+		return storedReply{ID: id, Duplicate: true}, true
+	}
+	endSpan(span, err)
+	if errors.Is(err, syscall.ENOSPC) {
+		serr := "The local storage is full"
+		httpTextReply(http.StatusInsufficientStorage, serr, w)
+		reqLogger(req).Error(serr, "err", err)
+		return storedReply{}, false
+	} else if err != nil {
+		serr := "Failed to store the message"
+		httpTextReply(http.StatusInternalServerError, serr, w)
+		reqLogger(req).Error(serr, "err", err)
+		return storedReply{}, false
+	}
+	reqLogger(req).Info("Stored the message", "id", id, "channel", msg.Channel, "priority", msg.Priority)
+
+	return storedReply{ID: id}, true
+}
+
+// recordDuplicate counts msg, which was rejected as a duplicate of the
+// message identified by id, and publishes it to the events.
+func (s *server) recordDuplicate(id string, msg storedMessage) {
+	if s.duplicates != nil {
+		s.duplicates.Inc(msg.Channel)
+	}
+	s.events.Publish(pipelineEvent{
+		Type: eventDuplicate,
+		ID: id,
+		Channel: msg.Channel,
+		RequestID: msg.RequestID,
+		Backlog: s.store.Count(),
+		Time: time.Now(),
+	})
+}
+
+// readyzResource is the resource polled by readiness probes, which isn't
+// authenticated.
+const readyzResource = "readyz"
+
+// readiness reports whether the server accepts messages.
+type readiness struct {
+	// Whether new messages are accepted.
+	Ready bool
+
+	// Whether forwarding is paused (in which case, messages are still
+	// accepted, but kept in the local storage).
+	Paused bool
+
+	// Number of messages in the local storage.
+	Backlog int
+
+	// The health of the least healthy component.
+	Health health.Status
+
+	// The health reported by each component.
+	Components []health.Report
+}
+
+// GetReadyz handles GET requests on the 'readyz' resource, reporting
+// whether the server accepts messages, along with the health of its
+// components. It replies with 503 while the server is shutting down, or
+// while the local storage has failed, so load balancers stop sending it
+// messages. A paused (or otherwise degraded) server is still ready, as it
+// keeps accepting messages.
+func (s *server) GetReadyz(w http.ResponseWriter, req *http.Request, res []string) {
+	if len(res) > 1 {
+		httpTextReply(http.StatusNotFound, "Invalid resource", w)
+		return
+	}
+
+	state := readiness{
+		Ready: !s.draining.Load(),
+		Paused: s.forwarder.Paused(),
+		Backlog: s.store.Count(),
+		Health: s.health.Overall(),
+		Components: s.health.Reports(),
+	}
+	if r, ok := s.health.Get(componentStore); ok && r.Status == health.Failed {
+		state.Ready = false
+	}
+	code := http.StatusOK
+	if !state.Ready {
+		code = http.StatusServiceUnavailable
+	}
+
+	switch req.Header.Get("Accept") {
+	case "application/json":
+		data, err := json.Marshal(&state)
+		if err != nil {
+			serr := "Failed to encode the response"
+			httpTextReply(http.StatusInternalServerError, serr, w)
+			reqLogger(req).Error(serr, "err", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		writeData(data, w)
+	default:
+		// By default, force "text/plain"
+		fallthrough
+	case "text/plain":
+		msg := fmt.Sprintf("Ready: %t\nPaused: %t\nBacklog: %d\nHealth: %s", state.Ready, state.Paused, state.Backlog, state.Health)
+		for _, r := range state.Components {
+			msg += "\n  " + r.String()
+		}
+		httpTextReply(code, msg, w)
+	}
+}
+
+// GetHeartbeat handles GET requests on the 'heartbeat' resource, returning
+// the result of the latest heartbeats. If the latest heartbeat failed, it
+// replies with 503, so it may be polled by monitoring tools.
+func (s *server) GetHeartbeat(w http.ResponseWriter, req *http.Request, res []string) {
+	if len(res) > 1 {
+		httpTextReply(http.StatusNotFound, "Invalid resource", w)
+		return
+	}
+
+	status, ok := s.heartbeat.Status()
+	if !ok {
+		httpTextReply(http.StatusNotFound, "The heartbeat is disabled", w)
+		return
+	}
+
+	code := http.StatusOK
+	if status.Failures > 0 {
+		code = http.StatusServiceUnavailable
+	}
+
+	switch req.Header.Get("Accept") {
+	case "application/json":
+		data, err := json.Marshal(&status)
+		if err != nil {
+			serr := "Failed to encode the response"
+			httpTextReply(http.StatusInternalServerError, serr, w)
+			reqLogger(req).Error(serr, "err", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		writeData(data, w)
+	default:
+		// By default, force "text/plain"
+		fallthrough
+	case "text/plain":
+		msg := fmt.Sprintf("Heartbeat (%s): last beat: %s, last success: %s, consecutive failures: %d",
+				status.Mode, status.LastBeat.Format(time.RFC3339), status.LastSuccess.Format(time.RFC3339), status.Failures)
+		if len(status.LastError) > 0 {
+			msg += fmt.Sprintf(", last error: %s", status.LastError)
+		}
+		httpTextReply(code, msg, w)
+	}
+}
+
+// DeleteMessage handles DELETE requests on the 'message' resource, removing
+// either the message identified on 'message/<id>', or every pending message
+// on 'message'. It replies with how many messages were removed. Messages
+// currently being sent can't be removed. Only administrators may remove
+// messages.
+func (s *server) DeleteMessage(w http.ResponseWriter, req *http.Request, res []string) {
+	if len(res) > 2 {
+		httpTextReply(http.StatusNotFound, "Invalid resource", w)
+		return
+	} else if !s.requireAdmin(w, req, res) {
+		return
+	}
+
+	var removed int
+	if len(res) == 2 {
+		id, err := url.PathUnescape(res[1])
+		if err == nil {
+			err = s.store.RemoveByID(id)
+		}
+
+		switch err {
+		case nil:
+			removed = 1
+		case local_storage.ErrNotFound, local_storage.ErrInvalidID:
+			httpTextReply(http.StatusNotFound, "Message not found", w)
+			return
+		case local_storage.ErrInFlight:
+			httpTextReply(http.StatusConflict, "The message is being sent", w)
+			return
+		default:
+			serr := "Failed to remove the message"
+			httpTextReply(http.StatusInternalServerError, serr, w)
+			reqLogger(req).Error(serr, "err", err)
+			return
+		}
+	} else {
+		var err error
+
+		removed, err = s.store.Purge()
+		if err != nil {
+			serr := "Failed to purge the messages"
+			httpTextReply(http.StatusInternalServerError, serr, w)
+			reqLogger(req).Error(serr, "err", err)
+			return
+		}
+	}
+	reqLogger(req).Info("Removed messages", "count", removed)
+
+	switch req.Header.Get("Accept") {
+	case "application/json":
+		resp := struct{Removed int}{removed}
+		data, err := json.Marshal(&resp)
+		if err != nil {
+			serr := "Failed to encode the response"
+			httpTextReply(http.StatusInternalServerError, serr, w)
+			reqLogger(req).Error(serr, "err", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		writeData(data, w)
+	default:
+		// By default, force "text/plain"
+		fallthrough
+	case "text/plain":
+		msg := fmt.Sprintf("Removed messages: %d", removed)
+		httpTextReply(http.StatusOK, msg, w)
+	}
+}
+
+// countReply replies with the number of messages affected by a request
+// (e.g., removed or requeued), as either "<Field>: <count>" or
+// {"<Field>": <count>}.
+func countReply(w http.ResponseWriter, req *http.Request, res []string, field string, count int) {
+	switch req.Header.Get("Accept") {
+	case "application/json":
+		data, err := json.Marshal(map[string]int{field: count})
+		if err != nil {
+			serr := "Failed to encode the response"
+			httpTextReply(http.StatusInternalServerError, serr, w)
+			reqLogger(req).Error(serr, "err", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		writeData(data, w)
+	default:
+		// By default, force "text/plain"
+		fallthrough
+	case "text/plain":
+		msg := fmt.Sprintf("%s: %d", field, count)
+		httpTextReply(http.StatusOK, msg, w)
+	}
+}
+
+// deadLetterReply replies to a request that changed the dead letters
+// identified on res[1] (or every one, if res has a single element) with fn
+// or all, respectively.
+func (s *server) deadLetterReply(w http.ResponseWriter, req *http.Request, res []string, field string, fn func(id string) error, all func() (int, error)) {
+	var count int
+	var err error
+
+	if len(res) > 1 {
+		var id string
+
+		id, err = url.PathUnescape(res[1])
+		if err == nil {
+			err = fn(id)
+		}
+		if err == nil {
+			count = 1
+		}
+	} else {
+		count, err = all()
+	}
+
+	switch err {
+	case nil:
+		reqLogger(req).Info("Updated the dead letters", strings.ToLower(field), count)
+		countReply(w, req, res, field, count)
+	case local_storage.ErrNotFound, local_storage.ErrInvalidID:
+		httpTextReply(http.StatusNotFound, "Message not found", w)
+	default:
+		serr := "Failed to update the dead letters"
+		httpTextReply(http.StatusInternalServerError, serr, w)
+		reqLogger(req).Error(serr, "err", err)
+	}
+}
+
+// requeueMessage handles POST requests on 'message/<id>/requeue', moving
+// the dead-lettered message identified by id back into the backlog. Only
+// administrators may requeue messages.
+func (s *server) requeueMessage(w http.ResponseWriter, req *http.Request, res []string) {
+	if !s.requireAdmin(w, req, res) {
+		return
+	}
+
+	s.deadLetterReply(w, req, res[:2], "Requeued", s.store.Requeue, s.store.RequeueAll)
+}
+
+// GetDeadLetter handles GET requests on the 'deadletter' resource, listing
+// every dead-lettered message. Only administrators may list them.
+func (s *server) GetDeadLetter(w http.ResponseWriter, req *http.Request, res []string) {
+	if len(res) > 1 {
+		httpTextReply(http.StatusNotFound, "Invalid resource", w)
+		return
+	} else if !s.requireAdmin(w, req, res) {
+		return
+	}
+
+	entries, err := s.store.DeadLetters()
+	if err != nil {
+		serr := "Failed to list the dead letters"
+		httpTextReply(http.StatusInternalServerError, serr, w)
+		reqLogger(req).Error(serr, "err", err)
+		return
+	}
+
+	resp := make([]storedEntry, 0, len(entries))
+	for _, entry := range entries {
+		resp = append(resp, storedEntry{
+			ID: entry.ID,
+			StoredAt: entry.StoredAt,
+			Body: string(entry.Bytes),
+		})
+	}
+
+	switch req.Header.Get("Accept") {
+	case "application/json":
+		data, err := json.Marshal(&resp)
+		if err != nil {
+			serr := "Failed to encode the response"
+			httpTextReply(http.StatusInternalServerError, serr, w)
+			reqLogger(req).Error(serr, "err", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		writeData(data, w)
+	default:
+		// By default, force "text/plain"
+		fallthrough
+	case "text/plain":
+		var msg strings.Builder
+
+		fmt.Fprintf(&msg, "Dead letters: %d\n", len(resp))
+		for _, entry := range resp {
+			fmt.Fprintf(&msg, "%s (%s): %s\n", entry.ID, entry.StoredAt.Format(time.RFC3339), entry.Body)
+		}
+		httpTextReply(http.StatusOK, msg.String(), w)
+	}
+}
+
+// PostDeadLetter handles POST requests on 'deadletter/requeue', moving
+// every dead-lettered message back into the backlog. Only administrators
+// may requeue messages.
+func (s *server) PostDeadLetter(w http.ResponseWriter, req *http.Request, res []string) {
+	if len(res) != 2 || res[1] != "requeue" {
+		httpTextReply(http.StatusNotFound, "Invalid resource", w)
+		return
+	} else if !s.requireAdmin(w, req, res) {
+		return
+	}
+
+	s.deadLetterReply(w, req, res[:1], "Requeued", s.store.Requeue, s.store.RequeueAll)
+}
+
+// DeleteDeadLetter handles DELETE requests on the 'deadletter' resource,
+// removing either the dead letter identified on 'deadletter/<id>', or
+// every dead letter on 'deadletter'. Only administrators may remove them.
+func (s *server) DeleteDeadLetter(w http.ResponseWriter, req *http.Request, res []string) {
+	if len(res) > 2 {
+		httpTextReply(http.StatusNotFound, "Invalid resource", w)
+		return
+	} else if !s.requireAdmin(w, req, res) {
+		return
+	}
+
+	s.deadLetterReply(w, req, res, "Removed", s.store.RemoveDeadLetter, s.store.PurgeDeadLetters)
+}
+
+// maxFlushTimeout limits for how long a flush may block.
+const maxFlushTimeout = 5 * time.Minute
+
+// How long the reply to admin/flush may take to be written, after
+// waiting for the backlog.
+const flushReplyTimeout = 10 * time.Second
+
+// PostAdmin handles POST requests on the 'admin' resource:
+//
+//   - 'admin/flush' wakes the forwarder right away. If the query parameter
+//     'wait' is set to a duration (e.g., "30s"), the request blocks until
+//     either the backlog drains or the duration expires.
+//   - 'admin/pause' pauses forwarding, while messages are still accepted.
+//   - 'admin/resume' resumes forwarding, once paused.
+//
+// Only administrators may use these endpoints.
+func (s *server) PostAdmin(w http.ResponseWriter, req *http.Request, res []string) {
+	if len(res) != 2 {
+		httpTextReply(http.StatusNotFound, "Invalid resource", w)
+		return
+	}
+
+	switch res[1] {
+	case "flush":
+		if s.requireAdmin(w, req, res) {
+			s.flush(w, req)
+		}
+	case "pause", "resume":
+		if s.requireAdmin(w, req, res) {
+			s.pause(w, req, res[1] == "pause")
+		}
+	default:
+		httpTextReply(http.StatusNotFound, "Invalid resource", w)
+	}
+}
+
+// pauseState reports whether forwarding is paused.
+type pauseState struct {
+	// Whether forwarding is paused.
+	Paused bool
+
+	// Number of messages in the local storage.
+	Backlog int
+}
+
+// pause (or, if paused is false, resume) forwarding messages, replying with
+// the resulting state.
+func (s *server) pause(w http.ResponseWriter, req *http.Request, paused bool) {
+	if paused {
+		s.forwarder.Pause()
+		reqLogger(req).Info("Paused forwarding")
+	} else {
+		s.forwarder.Resume()
+		reqLogger(req).Info("Resumed forwarding")
+	}
+
+	state := pauseState{
+		Paused: s.forwarder.Paused(),
+		Backlog: s.store.Count(),
+	}
+	switch req.Header.Get("Accept") {
+	case "application/json":
+		data, err := json.Marshal(&state)
+		if err != nil {
+			serr := "Failed to encode the response"
+			httpTextReply(http.StatusInternalServerError, serr, w)
+			reqLogger(req).Error(serr, "err", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		writeData(data, w)
+	default:
+		// By default, force "text/plain"
+		fallthrough
+	case "text/plain":
+		msg := fmt.Sprintf("Paused: %t\nBacklog: %d", state.Paused, state.Backlog)
+		httpTextReply(http.StatusOK, msg, w)
+	}
+}
+
+// flush the backlog, as described by PostAdmin.
+func (s *server) flush(w http.ResponseWriter, req *http.Request) {
+	var timeout time.Duration
+	if wait := req.URL.Query().Get("wait"); len(wait) > 0 {
+		var err error
+
+		timeout, err = time.ParseDuration(wait)
+		if err != nil || timeout < 0 || timeout > maxFlushTimeout {
+			serr := fmt.Sprintf("Invalid 'wait': must be a duration up to %s", maxFlushTimeout)
+			httpTextReply(http.StatusBadRequest, serr, w)
+			reqLogger(req).Error(serr, "err", err)
+			return
+		}
+	}
+
+	// Waiting may take longer than the server's WriteTimeout.
+	if timeout > 0 {
+		rc := http.NewResponseController(w)
+		rc.SetWriteDeadline(time.Now().Add(timeout + flushReplyTimeout))
+	}
+
+	result := s.forwarder.Drain(timeout)
+	reqLogger(req).Info("Flushed the backlog", "sent", result.Sent, "remaining", result.Remaining)
+
+	switch req.Header.Get("Accept") {
+	case "application/json":
+		data, err := json.Marshal(&result)
+		if err != nil {
+			serr := "Failed to encode the response"
+			httpTextReply(http.StatusInternalServerError, serr, w)
+			reqLogger(req).Error(serr, "err", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		writeData(data, w)
+	default:
+		// By default, force "text/plain"
+		fallthrough
+	case "text/plain":
+		msg := fmt.Sprintf("Sent: %d\nRemaining: %d", result.Sent, result.Remaining)
+		httpTextReply(http.StatusOK, msg, w)
+	}
+}
+
+// cleanURL so everything is properly escaped/encoded and so it may be split into each of its components.
+//
+// Use `url.Unescape` to retrieve the unescaped path, if so desired.
+func cleanURL(uri *url.URL) string {
+	// Normalize and strip the URL from its leading prefix (and slash)
+	resUrl := path.Clean(uri.EscapedPath())
+	if len(resUrl) > 0 && resUrl[0] == '/' {
+		resUrl = resUrl[1:]
+	} else if len(resUrl) == 1 && resUrl[0] == '.' {
+		// Clean converts an empty path into a single "."
+		resUrl = ""
+	}
+
+	return resUrl
+}
+
+// httpTextReply send a simple HTTP response as a plain text.
+func httpTextReply(status int, msg string, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(status)
+
+	writeData([]byte(msg), w)
+}
+
+// writeData, account for incomplete writes.
+func writeData(data []byte, w io.Writer) {
+	for len(data) > 0 {
+		n, err := w.Write(data)
+		if err != nil {
+			slog.Warn("Failed to send", "err", err)
+			return
+		}
+		data = data[n:]
+	}
+}
+
+// runWeb starts the web server, returning it so it may be stopped. The
+// extra middleware runs after the built-in one. If it fails, every server
+// started meanwhile (e.g., the admin listener) is stopped.
+func runWeb(args Args, store local_storage.Store, fw *forwarder.Forwarder, events *eventHub, hb *heartbeat, quotas *chanquota.Quotas, a auth.Authenticator, reg *metrics.Registry, hr *health.Registry, extra []Middleware) (*server, error) {
+	var srv server
+
+	srv.httpServer = &http.Server {
+		Addr: fmt.Sprintf("%s:%d", args.IP, args.Port),
+		ReadHeaderTimeout: time.Duration(args.ReadHeaderTimeoutS) * time.Second,
+		ReadTimeout: time.Duration(args.ReadTimeoutS) * time.Second,
+		WriteTimeout: time.Duration(args.WriteTimeoutS) * time.Second,
+		IdleTimeout: time.Duration(args.IdleTimeoutS) * time.Second,
+		MaxHeaderBytes: args.MaxHeaderBytes,
+	}
+	srv.features = newFeatures(args)
+	srv.handlers = map[endpoint]endpointHandler {
+		endpoint{"message", http.MethodGet}: srv.GetMessage,
+		endpoint{"message", http.MethodPost}: srv.PostMessage,
+		endpoint{"message", http.MethodDelete}: srv.DeleteMessage,
+		endpoint{"deadletter", http.MethodGet}: srv.GetDeadLetter,
+		endpoint{"deadletter", http.MethodPost}: srv.PostDeadLetter,
+		endpoint{"deadletter", http.MethodDelete}: srv.DeleteDeadLetter,
+		endpoint{"heartbeat", http.MethodGet}: srv.GetHeartbeat,
+		endpoint{readyzResource, http.MethodGet}: srv.GetReadyz,
+		endpoint{"version", http.MethodGet}: srv.GetVersion,
+		endpoint{"status", http.MethodGet}: srv.GetStatus,
+		endpoint{"events", http.MethodGet}: srv.GetEvents,
+		endpoint{webhookResource, http.MethodPost}: srv.PostWebhook,
+		endpoint{"v2", http.MethodPost}: srv.PostPagerDutyEvent,
+		endpoint{"admin", http.MethodPost}: srv.PostAdmin,
+		endpoint{"openapi.json", http.MethodGet}: srv.GetOpenAPI,
+		endpoint{dashboardResource, http.MethodGet}: srv.GetDashboard,
+		endpoint{"metrics", http.MethodGet}: srv.GetMetrics,
+	}
+
+	if args.IdempotencyWindowS > 0 {
+		window := time.Duration(args.IdempotencyWindowS) * time.Second
+		e := endpoint{"message", http.MethodPost}
+		srv.handlers[e] = withIdempotency(idempotency.New(window), srv.handlers[e])
+	}
+
+	// Validate every request body described in the OpenAPI document.
+	validator, err := newRequestValidator(openAPISpec)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load the OpenAPI document: %w", err)
+	}
+	for e, h := range srv.handlers {
+		srv.handlers[e] = validator.wrap(h)
+	}
+
+	// Disabled resources are rejected before their requests are validated.
+	for e, enabled := range srv.features.endpoints() {
+		srv.handlers[e] = withFeature(enabled, srv.handlers[e])
+	}
+
+	srv.store = store
+	srv.forwarder = fw
+	srv.metrics = reg
+	if reg != nil {
+		srv.duplicates = reg.Counter("sqsnotifier_duplicates_total", "Messages rejected as already stored, by their channel.", "channel")
+	}
+	srv.duplicateStatus = args.DuplicateStatus
+	srv.startedAt = time.Now()
+	srv.config = newStatusConfig(args)
+	srv.health = hr
+	srv.events = events
+	srv.githubSecret = []byte(args.GitHubWebhookSecret)
+	srv.gitlabSecret = []byte(args.GitLabWebhookSecret)
+	srv.sentrySecret = []byte(args.SentryWebhookSecret)
+	srv.slackSecret = []byte(args.SlackSigningSecret)
+	srv.eventsInterval = time.Duration(args.EventsIntervalS) * time.Second
+	if srv.eventsInterval <= 0 {
+		srv.eventsInterval = defaultEventsInterval
+	}
+	srv.heartbeat = hb
+	srv.quotas = quotas
+	routes, err := newRouteTable(args)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load the routes: %w", err)
+	}
+	srv.routes.Store(routes)
+	srv.auth = a
+	if len(args.ChannelSchemaDir) > 0 {
+		srv.schemas, err = msgschema.Load(args.ChannelSchemaDir)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't load the channel schemas: %w", err)
+		}
+		slog.Info("Loaded the channel schemas", "channels", srv.schemas.Channels())
+	}
+	if args.ClientRate > 0 {
+		srv.limiter.Store(clientlimit.New(args.ClientRate, args.ClientBurst))
+	}
+	if args.MaxInFlightPosts > 0 {
+		srv.inFlight = make(chan struct{}, args.MaxInFlightPosts)
+	}
+
+	// The middleware runs in this order:
+	//  1. The client's address is resolved (through the trusted proxies),
+	//     and every request is identified and logged, even if it's
+	//     rejected by a later middleware.
+	//  2. Panics are recovered, replying with 500 (which is logged), so a
+	//     panic in any later middleware (e.g., authentication) doesn't
+	//     drop the connection.
+	//  3. Clients are filtered by their address, and cross-origin
+	//     preflight requests are answered before authentication, since
+	//     browsers don't send credentials on them.
+	//  4. Request bodies are decompressed, and the requests are
+	//     authenticated and rate limited.
+	//  5. The extra middleware (see WithMiddleware) runs last, right
+	//     before the request's handler.
+	srv.ipFilter, srv.trustedProxies, err = newIPFilter(args)
+	if err != nil {
+		return nil, err
+	}
+	srv.Use(srv.realClientIP, accessLog, traceRequests, recoverPanics, srv.filterIPs)
+	srv.cors = newCORSPolicy(args)
+	if srv.cors != nil {
+		srv.Use(srv.cors.wrap)
+	}
+	maxBody := int64(args.MaxBodyBytes)
+	srv.Use(
+		func(next http.Handler) http.Handler {
+			return withGzip(next, maxBody)
+		},
+		srv.authenticate,
+		srv.rateLimit,
+		srv.limitInFlight,
+	)
+	srv.Use(extra...)
+	srv.httpServer.Handler = srv.chain()
+
+	if len(args.ACMEHosts) > 0 && (len(args.CertFile) > 0 || len(args.KeyFile) > 0) {
+		return nil, errors.New("either ACMEHosts or CertFile/KeyFile may be set, but not both")
+	}
+
+	if len(args.PprofAddr) > 0 {
+		srv.pprofServer = startPprof(args.PprofAddr)
+	}
+	if len(args.AdminAddr) > 0 {
+		srv.adminServer = srv.startAdmin(args, srv.httpServer)
+	}
+
+	if len(args.ACMEHosts) > 0 {
+		m := newACMEManager(args)
+		srv.httpServer.TLSConfig = m.TLSConfig()
+		srv.httpServer.TLSConfig.MinVersion = tls.VersionTLS12
+
+		if args.ACMEHTTPPort > 0 {
+			// Answer HTTP-01 challenges, redirecting everything else
+			// to HTTPS.
+			srv.acmeServer = &http.Server {
+				Addr: fmt.Sprintf("%s:%d", args.IP, args.ACMEHTTPPort),
+				Handler: m.HTTPHandler(nil),
+				ReadHeaderTimeout: srv.httpServer.ReadHeaderTimeout,
+				ReadTimeout: srv.httpServer.ReadTimeout,
+				WriteTimeout: srv.httpServer.WriteTimeout,
+				IdleTimeout: srv.httpServer.IdleTimeout,
+				MaxHeaderBytes: srv.httpServer.MaxHeaderBytes,
+			}
+			go func() {
+				l, err := listen(srv.acmeServer.Addr)
+				if err == nil {
+					err = srv.acmeServer.Serve(l)
+				}
+				if err != nil && err != http.ErrServerClosed {
+					slog.Error("The ACME challenge server failed", "err", err)
+				}
+			} ()
+		}
+	} else if len(args.CertFile) > 0 || len(args.KeyFile) > 0 {
+		interval := time.Duration(args.CertReloadS) * time.Second
+		cr, err := newCertReloader(args.CertFile, args.KeyFile, interval)
+		if err != nil {
+			srv.Close()
+			return nil, fmt.Errorf("couldn't load the TLS certificate: %w", err)
+		}
+
+		srv.httpServer.TLSConfig = &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			GetCertificate: cr.GetCertificate,
+		}
+	}
+
+	if args.GRPCPort > 0 {
+		srv.grpcServer, err = startIngest(args, &srv)
+		if err != nil {
+			srv.Close()
+			return nil, err
+		}
+	}
+
+	// Listen before returning, so the server is ready once it returns.
+	l, err := listen(srv.httpServer.Addr)
+	if err != nil {
+		srv.Close()
+		return nil, fmt.Errorf("couldn't listen for requests on '%s': %w", srv.httpServer.Addr, err)
+	}
+	if args.MaxConnections > 0 {
+		l = netutil.LimitListener(l, args.MaxConnections)
+	}
+
+	go func() {
+		slog.Info("Waiting...", "addr", srv.httpServer.Addr)
+
+		var err error
+		if srv.httpServer.TLSConfig != nil {
+			// The certificate is supplied by TLSConfig.GetCertificate.
+			err = srv.httpServer.ServeTLS(l, "", "")
+		} else {
+			err = srv.httpServer.Serve(l)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			slog.Error("The web server failed", "err", err)
+		}
+	} ()
+
+	return &srv, nil
+}
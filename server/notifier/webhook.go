@@ -0,0 +1,213 @@
+package notifier
+
+import (
+	"errors"
+	"fmt"
+	"github.com/SirGFM/sqs-issue-notifier/server/webhook"
+	"io"
+	"net/http"
+	"time"
+)
+
+// webhookResource is the resource on which third-party services send
+// their webhooks. Webhooks are authenticated by each service's own
+// mechanism, instead of by the server's authenticator.
+const webhookResource = "webhook"
+
+// PostWebhook handles POST requests on 'webhook/<service>', converting the
+// service's webhook into a message and storing it.
+func (s *server) PostWebhook(w http.ResponseWriter, req *http.Request, res []string) {
+	if len(res) == 3 && res[1] == "slack" {
+		s.postSlackWebhook(w, req, res)
+		return
+	} else if len(res) != 2 {
+		httpTextReply(http.StatusNotFound, "Invalid resource", w)
+		return
+	}
+
+	switch res[1] {
+	case "github":
+		s.postGitHubWebhook(w, req, res)
+	case "gitlab":
+		s.postGitLabWebhook(w, req, res)
+	case "sentry":
+		s.postSentryWebhook(w, req, res)
+	default:
+		httpTextReply(http.StatusNotFound, "Invalid resource", w)
+	}
+}
+
+// readWebhook reads the webhook's body, replying with an error if that
+// fails.
+func readWebhook(w http.ResponseWriter, req *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(req.Body)
+	if tooLarge := (*http.MaxBytesError)(nil); errors.As(err, &tooLarge) {
+		serr := fmt.Sprintf("The request body is larger than %d bytes", tooLarge.Limit)
+		httpTextReply(http.StatusRequestEntityTooLarge, serr, w)
+		reqLogger(req).Info(serr)
+		return nil, false
+	} else if err != nil {
+		httpTextReply(http.StatusBadRequest, "Failed to read the request", w)
+		reqLogger(req).Info("Failed to read the webhook", "err", err)
+		return nil, false
+	}
+
+	return body, true
+}
+
+// storeWebhook stores the notification n (or err, if the webhook couldn't
+// be converted), replying to the request.
+func (s *server) storeWebhook(w http.ResponseWriter, req *http.Request, res []string, n webhook.Notification, err error) {
+	if err == webhook.ErrIgnoredEvent {
+		// Accept the event, so the service doesn't report the webhook as
+		// failing.
+		reqLogger(req).Info("Ignoring the webhook's event")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	} else if err != nil {
+		httpTextReply(http.StatusBadRequest, "Invalid payload", w)
+		reqLogger(req).Info("Invalid webhook", "err", err)
+		return
+	}
+
+	if _, ok := s.storeMessage(w, req, res, notificationMessage(n)); ok {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// notificationMessage converts the notification n into a message.
+func notificationMessage(n webhook.Notification) storedMessage {
+	return storedMessage{
+		message: message{
+			Channel: n.Channel,
+			Message: n.Message,
+		},
+	}
+}
+
+// postGitHubWebhook handles GitHub's webhooks, storing its issues and pull
+// requests events for the repository's channel.
+func (s *server) postGitHubWebhook(w http.ResponseWriter, req *http.Request, res []string) {
+	if len(s.githubSecret) == 0 {
+		httpTextReply(http.StatusNotFound, "The GitHub webhook is disabled", w)
+		return
+	}
+
+	body, ok := readWebhook(w, req)
+	if !ok {
+		return
+	}
+
+	err := webhook.VerifyGitHub(s.githubSecret, body, req.Header.Get(webhook.GitHubSignatureHeader))
+	if err != nil {
+		httpTextReply(http.StatusUnauthorized, "Invalid signature", w)
+		reqLogger(req).Info("Invalid GitHub signature", "err", err)
+		return
+	}
+
+	n, err := webhook.ParseGitHub(req.Header.Get(webhook.GitHubEventHeader), body)
+	s.storeWebhook(w, req, res, n, err)
+}
+
+// postGitLabWebhook handles GitLab's webhooks, storing its issue and merge
+// request events for the project's channel.
+func (s *server) postGitLabWebhook(w http.ResponseWriter, req *http.Request, res []string) {
+	if len(s.gitlabSecret) == 0 {
+		httpTextReply(http.StatusNotFound, "The GitLab webhook is disabled", w)
+		return
+	}
+
+	err := webhook.VerifyGitLab(s.gitlabSecret, req.Header.Get(webhook.GitLabTokenHeader))
+	if err != nil {
+		httpTextReply(http.StatusUnauthorized, "Invalid token", w)
+		reqLogger(req).Info("Invalid GitLab token", "err", err)
+		return
+	}
+
+	body, ok := readWebhook(w, req)
+	if !ok {
+		return
+	}
+
+	n, err := webhook.ParseGitLab(req.Header.Get(webhook.GitLabEventHeader), body)
+	s.storeWebhook(w, req, res, n, err)
+}
+
+// postSentryWebhook handles Sentry's webhooks, storing its issue alerts for
+// the project's channel.
+func (s *server) postSentryWebhook(w http.ResponseWriter, req *http.Request, res []string) {
+	if len(s.sentrySecret) == 0 {
+		httpTextReply(http.StatusNotFound, "The Sentry webhook is disabled", w)
+		return
+	}
+
+	body, ok := readWebhook(w, req)
+	if !ok {
+		return
+	}
+
+	err := webhook.VerifySentry(s.sentrySecret, body, req.Header.Get(webhook.SentrySignatureHeader))
+	if err != nil {
+		httpTextReply(http.StatusUnauthorized, "Invalid signature", w)
+		reqLogger(req).Info("Invalid Sentry signature", "err", err)
+		return
+	}
+
+	n, err := webhook.ParseSentry(req.Header.Get(webhook.SentryResourceHeader), body)
+	s.storeWebhook(w, req, res, n, err)
+}
+
+// slackUsage is sent back to Slack when a command can't be understood.
+const slackUsage = "Usage: <channel> <message>"
+
+// postSlackWebhook handles Slack's slash commands, on
+// 'webhook/slack/command', and Events API requests, on
+// 'webhook/slack/events', storing the message in their text (as
+// "<channel> <message>").
+func (s *server) postSlackWebhook(w http.ResponseWriter, req *http.Request, res []string) {
+	if res[2] != "command" && res[2] != "events" {
+		httpTextReply(http.StatusNotFound, "Invalid resource", w)
+		return
+	} else if len(s.slackSecret) == 0 {
+		httpTextReply(http.StatusNotFound, "The Slack webhook is disabled", w)
+		return
+	}
+
+	body, ok := readWebhook(w, req)
+	if !ok {
+		return
+	}
+
+	err := webhook.VerifySlack(s.slackSecret, body,
+			req.Header.Get(webhook.SlackTimestampHeader),
+			req.Header.Get(webhook.SlackSignatureHeader),
+			time.Now())
+	if err != nil {
+		httpTextReply(http.StatusUnauthorized, "Invalid signature", w)
+		reqLogger(req).Info("Invalid Slack signature", "err", err)
+		return
+	}
+
+	if res[2] == "events" {
+		n, challenge, err := webhook.ParseSlackEvent(body)
+		if err == nil && len(challenge) > 0 {
+			httpTextReply(http.StatusOK, challenge, w)
+			return
+		}
+		s.storeWebhook(w, req, res, n, err)
+		return
+	}
+
+	// Slack shows the reply to commands to whoever sent them, so errors
+	// in the command itself are sent back successfully.
+	n, err := webhook.ParseSlackCommand(body)
+	if err != nil {
+		httpTextReply(http.StatusOK, slackUsage, w)
+		reqLogger(req).Info("Invalid Slack command", "err", err)
+		return
+	}
+
+	if _, ok := s.storeMessage(w, req, res, notificationMessage(n)); ok {
+		httpTextReply(http.StatusOK, fmt.Sprintf("Queued the message for '%s'", n.Channel), w)
+	}
+}
@@ -0,0 +1,274 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v3.19.4
+// source: notifierpb/notifier.proto
+
+package notifierpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// CollectorClient is the client API for Collector service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type CollectorClient interface {
+	// Collect a single message. Messages that the collector will never
+	// accept should be refused with INVALID_ARGUMENT, so the notifier
+	// discards them instead of retrying them forever.
+	Collect(ctx context.Context, in *CollectRequest, opts ...grpc.CallOption) (*CollectResponse, error)
+}
+
+type collectorClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCollectorClient(cc grpc.ClientConnInterface) CollectorClient {
+	return &collectorClient{cc}
+}
+
+func (c *collectorClient) Collect(ctx context.Context, in *CollectRequest, opts ...grpc.CallOption) (*CollectResponse, error) {
+	out := new(CollectResponse)
+	err := c.cc.Invoke(ctx, "/notifier.Collector/Collect", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CollectorServer is the server API for Collector service.
+// All implementations must embed UnimplementedCollectorServer
+// for forward compatibility
+type CollectorServer interface {
+	// Collect a single message. Messages that the collector will never
+	// accept should be refused with INVALID_ARGUMENT, so the notifier
+	// discards them instead of retrying them forever.
+	Collect(context.Context, *CollectRequest) (*CollectResponse, error)
+	mustEmbedUnimplementedCollectorServer()
+}
+
+// UnimplementedCollectorServer must be embedded to have forward compatible implementations.
+type UnimplementedCollectorServer struct {
+}
+
+func (UnimplementedCollectorServer) Collect(context.Context, *CollectRequest) (*CollectResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Collect not implemented")
+}
+func (UnimplementedCollectorServer) mustEmbedUnimplementedCollectorServer() {}
+
+// UnsafeCollectorServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CollectorServer will
+// result in compilation errors.
+type UnsafeCollectorServer interface {
+	mustEmbedUnimplementedCollectorServer()
+}
+
+func RegisterCollectorServer(s grpc.ServiceRegistrar, srv CollectorServer) {
+	s.RegisterService(&Collector_ServiceDesc, srv)
+}
+
+func _Collector_Collect_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CollectRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CollectorServer).Collect(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/notifier.Collector/Collect",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CollectorServer).Collect(ctx, req.(*CollectRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Collector_ServiceDesc is the grpc.ServiceDesc for Collector service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Collector_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "notifier.Collector",
+	HandlerType: (*CollectorServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Collect",
+			Handler:    _Collector_Collect_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "notifierpb/notifier.proto",
+}
+
+// NotifierClient is the client API for Notifier service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type NotifierClient interface {
+	// Store a single message, to be forwarded later.
+	Notify(ctx context.Context, in *Message, opts ...grpc.CallOption) (*Ack, error)
+	// Store a stream of messages, acknowledging each one in the order
+	// they were received. A message that's refused doesn't end the
+	// stream: its Ack carries the error instead.
+	NotifyBatch(ctx context.Context, opts ...grpc.CallOption) (Notifier_NotifyBatchClient, error)
+}
+
+type notifierClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewNotifierClient(cc grpc.ClientConnInterface) NotifierClient {
+	return &notifierClient{cc}
+}
+
+func (c *notifierClient) Notify(ctx context.Context, in *Message, opts ...grpc.CallOption) (*Ack, error) {
+	out := new(Ack)
+	err := c.cc.Invoke(ctx, "/notifier.Notifier/Notify", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *notifierClient) NotifyBatch(ctx context.Context, opts ...grpc.CallOption) (Notifier_NotifyBatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Notifier_ServiceDesc.Streams[0], "/notifier.Notifier/NotifyBatch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &notifierNotifyBatchClient{stream}
+	return x, nil
+}
+
+type Notifier_NotifyBatchClient interface {
+	Send(*Message) error
+	Recv() (*Ack, error)
+	grpc.ClientStream
+}
+
+type notifierNotifyBatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *notifierNotifyBatchClient) Send(m *Message) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *notifierNotifyBatchClient) Recv() (*Ack, error) {
+	m := new(Ack)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// NotifierServer is the server API for Notifier service.
+// All implementations must embed UnimplementedNotifierServer
+// for forward compatibility
+type NotifierServer interface {
+	// Store a single message, to be forwarded later.
+	Notify(context.Context, *Message) (*Ack, error)
+	// Store a stream of messages, acknowledging each one in the order
+	// they were received. A message that's refused doesn't end the
+	// stream: its Ack carries the error instead.
+	NotifyBatch(Notifier_NotifyBatchServer) error
+	mustEmbedUnimplementedNotifierServer()
+}
+
+// UnimplementedNotifierServer must be embedded to have forward compatible implementations.
+type UnimplementedNotifierServer struct {
+}
+
+func (UnimplementedNotifierServer) Notify(context.Context, *Message) (*Ack, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Notify not implemented")
+}
+func (UnimplementedNotifierServer) NotifyBatch(Notifier_NotifyBatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method NotifyBatch not implemented")
+}
+func (UnimplementedNotifierServer) mustEmbedUnimplementedNotifierServer() {}
+
+// UnsafeNotifierServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to NotifierServer will
+// result in compilation errors.
+type UnsafeNotifierServer interface {
+	mustEmbedUnimplementedNotifierServer()
+}
+
+func RegisterNotifierServer(s grpc.ServiceRegistrar, srv NotifierServer) {
+	s.RegisterService(&Notifier_ServiceDesc, srv)
+}
+
+func _Notifier_Notify_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Message)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NotifierServer).Notify(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/notifier.Notifier/Notify",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NotifierServer).Notify(ctx, req.(*Message))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Notifier_NotifyBatch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(NotifierServer).NotifyBatch(&notifierNotifyBatchServer{stream})
+}
+
+type Notifier_NotifyBatchServer interface {
+	Send(*Ack) error
+	Recv() (*Message, error)
+	grpc.ServerStream
+}
+
+type notifierNotifyBatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *notifierNotifyBatchServer) Send(m *Ack) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *notifierNotifyBatchServer) Recv() (*Message, error) {
+	m := new(Message)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Notifier_ServiceDesc is the grpc.ServiceDesc for Notifier service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Notifier_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "notifier.Notifier",
+	HandlerType: (*NotifierServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Notify",
+			Handler:    _Notifier_Notify_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "NotifyBatch",
+			Handler:       _Notifier_NotifyBatch_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "notifierpb/notifier.proto",
+}
@@ -0,0 +1,17 @@
+package sdnotify
+
+type error_code uint
+
+const (
+	// WATCHDOG_USEC isn't a positive number of microseconds.
+	ErrInvalidWatchdog error_code = iota
+)
+
+func (e error_code) Error() string {
+	switch e {
+	case ErrInvalidWatchdog:
+		return "WATCHDOG_USEC isn't a positive number of microseconds."
+	default:
+		return "Invalid sdnotify error."
+	}
+}
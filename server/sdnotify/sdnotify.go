@@ -0,0 +1,94 @@
+/*
+Package sdnotify implements systemd's service notification protocol (see
+sd_notify(3)), so a service started with Type=notify may report once it's
+ready, and, if the unit sets WatchdogSec, keep systemd's watchdog from
+restarting it.
+
+Notifications are sent to the socket in $NOTIFY_SOCKET, which is only set
+if the service is supervised by systemd. Otherwise, nothing is sent.
+
+Example:
+
+	sdnotify.Notify(sdnotify.Ready)
+
+	interval, err := sdnotify.WatchdogInterval()
+	if err != nil {
+		// handle err
+	} else if interval > 0 {
+		for range time.Tick(interval / 2) {
+			if healthy() {
+				sdnotify.Notify(sdnotify.Watchdog)
+			}
+		}
+	}
+*/
+package sdnotify
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// The service finished starting up.
+	Ready = "READY=1"
+	// The service is shutting down.
+	Stopping = "STOPPING=1"
+	// The service is alive, resetting the watchdog's timer.
+	Watchdog = "WATCHDOG=1"
+)
+
+// MainPID builds the state telling systemd that pid is now the service's
+// main process (e.g., once it took over from the previous one). It may be
+// sent along with another state, separated by a newline.
+func MainPID(pid int) string {
+	return "MAINPID=" + strconv.Itoa(pid)
+}
+
+// Notify sends state (e.g., Ready) to systemd. It returns false, without
+// an error, if the service isn't supervised by systemd.
+func Notify(state string) (bool, error) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if len(path) == 0 {
+		return false, nil
+	}
+	// Abstract sockets are written with a leading '@'.
+	if path[0] == '@' {
+		path = "\x00" + path[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WatchdogInterval retrieves the interval of systemd's watchdog: if the
+// service doesn't notify Watchdog within this interval, it's considered
+// failed. It returns 0 if the watchdog isn't enabled for this process.
+func WatchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if len(usec) == 0 {
+		return 0, nil
+	}
+
+	// The watchdog may be meant for another process (e.g., the parent).
+	if pid := os.Getenv("WATCHDOG_PID"); len(pid) > 0 && pid != strconv.Itoa(os.Getpid()) {
+		return 0, nil
+	}
+
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, ErrInvalidWatchdog
+	}
+	return time.Duration(n) * time.Microsecond, nil
+}
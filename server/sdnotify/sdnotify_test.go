@@ -0,0 +1,77 @@
+package sdnotify
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestNotify checks that states are sent to $NOTIFY_SOCKET, and only if
+// it's set.
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	ok, err := Notify(Ready)
+	if ok || err != nil {
+		t.Errorf("Notify: Expected 'false, <nil>' without a socket but got '%+v, %+v'", ok, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("ListenUnixgram: Failed to listen on '%s': %+v", path, err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	for _, state := range []string{Ready, Watchdog, Stopping, MainPID(42) + "\n" + Ready} {
+		ok, err := Notify(state)
+		if !ok || err != nil {
+			t.Errorf("Notify(%s): Expected 'true, <nil>' but got '%+v, %+v'", state, ok, err)
+			continue
+		}
+
+		buf := make([]byte, 64)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Errorf("Notify(%s): Failed to read the notification: %+v", state, err)
+		} else if got := string(buf[:n]); got != state {
+			t.Errorf("Notify(%s): Expected '%s' but got '%s'", state, state, got)
+		}
+	}
+}
+
+// TestWatchdogInterval checks that the watchdog is only enabled for the
+// process that it targets.
+func TestWatchdogInterval(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+
+	test_cases := []struct{
+		usec string
+		pid string
+		want time.Duration
+		err error
+	}{
+		{ usec: "", want: 0 },
+		{ usec: "30000000", want: 30 * time.Second },
+		{ usec: "500000", pid: pid, want: 500 * time.Millisecond },
+		{ usec: "30000000", pid: "1", want: 0 },
+		{ usec: "0", err: ErrInvalidWatchdog },
+		{ usec: "30s", err: ErrInvalidWatchdog },
+	}
+
+	for i, tc := range test_cases {
+		t.Setenv("WATCHDOG_USEC", tc.usec)
+		t.Setenv("WATCHDOG_PID", tc.pid)
+
+		got, err := WatchdogInterval()
+		if err != tc.err {
+			t.Errorf("%d: WatchdogInterval: Expected error '%+v' but got '%+v'", i, tc.err, err)
+		} else if got != tc.want {
+			t.Errorf("%d: WatchdogInterval: Expected '%s' but got '%s'", i, tc.want, got)
+		}
+	}
+}
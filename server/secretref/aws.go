@@ -0,0 +1,97 @@
+package secretref
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"strings"
+)
+
+const (
+	// SchemeSecretsManager identifies references to AWS Secrets Manager,
+	// as "aws-sm://<name>[#<key>]".
+	SchemeSecretsManager = "aws-sm"
+	// SchemeParameterStore identifies references to the SSM Parameter
+	// Store, as "ssm://<path>".
+	SchemeParameterStore = "ssm"
+)
+
+// secretsManager fetches secrets from AWS Secrets Manager.
+type secretsManager struct {
+	svc *secretsmanager.SecretsManager
+}
+
+// NewSecretsManager creates a Fetcher for AWS Secrets Manager, using the
+// session p. Names are the secrets' names (or ARNs), optionally followed by
+// "#<key>" to select a key of a secret stored as a JSON object (e.g.,
+// "prod/notifier#github").
+func NewSecretsManager(p client.ConfigProvider) Fetcher {
+	return secretsManager{svc: secretsmanager.New(p)}
+}
+
+func (s secretsManager) Fetch(name string) (string, error) {
+	id, key, hasKey := strings.Cut(name, "#")
+
+	out, err := s.svc.GetSecretValue(&secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(id),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == secretsmanager.ErrCodeResourceNotFoundException {
+		return "", fmt.Errorf("secret '%s': %w", id, ErrNotFound)
+	} else if err != nil {
+		return "", fmt.Errorf("secret '%s': %w", id, err)
+	}
+
+	value := aws.StringValue(out.SecretString)
+	if out.SecretString == nil {
+		value = string(out.SecretBinary)
+	}
+	if !hasKey {
+		return value, nil
+	}
+
+	var fields map[string]any
+	err = json.Unmarshal([]byte(value), &fields)
+	if err != nil {
+		return "", fmt.Errorf("secret '%s': %w", id, ErrNoKey)
+	}
+	field, ok := fields[key].(string)
+	if !ok {
+		return "", fmt.Errorf("secret '%s', key '%s': %w", id, key, ErrNoKey)
+	}
+	return field, nil
+}
+
+// parameterStore fetches parameters from the SSM Parameter Store.
+type parameterStore struct {
+	svc *ssm.SSM
+}
+
+// NewParameterStore creates a Fetcher for the SSM Parameter Store, using the
+// session p. Names are the parameters' paths, with or without the leading
+// "/" (e.g., "ssm://prod/notifier/token" fetches "/prod/notifier/token").
+// SecureString parameters are decrypted.
+func NewParameterStore(p client.ConfigProvider) Fetcher {
+	return parameterStore{svc: ssm.New(p)}
+}
+
+func (s parameterStore) Fetch(name string) (string, error) {
+	if strings.Contains(name, "/") && !strings.HasPrefix(name, "/") {
+		name = "/" + name
+	}
+
+	out, err := s.svc.GetParameter(&ssm.GetParameterInput{
+		Name: aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ssm.ErrCodeParameterNotFound {
+		return "", fmt.Errorf("parameter '%s': %w", name, ErrNotFound)
+	} else if err != nil {
+		return "", fmt.Errorf("parameter '%s': %w", name, err)
+	}
+
+	return aws.StringValue(out.Parameter.Value), nil
+}
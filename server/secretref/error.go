@@ -0,0 +1,29 @@
+package secretref
+
+type error_code uint
+
+const (
+	// The destination isn't a pointer to a struct.
+	ErrNotStruct error_code = iota
+	// The reference's scheme isn't registered.
+	ErrUnknownScheme
+	// The referenced secret doesn't exist.
+	ErrNotFound
+	// The secret isn't a JSON object with the referenced key.
+	ErrNoKey
+)
+
+func (e error_code) Error() string {
+	switch e {
+	case ErrNotStruct:
+		return "The destination isn't a pointer to a struct."
+	case ErrUnknownScheme:
+		return "The reference's scheme isn't registered."
+	case ErrNotFound:
+		return "The referenced secret doesn't exist."
+	case ErrNoKey:
+		return "The secret isn't a JSON object with the referenced key."
+	default:
+		return "Invalid secretref error."
+	}
+}
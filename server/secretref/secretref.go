@@ -0,0 +1,188 @@
+/*
+Package secretref resolves references to secrets written in place of the
+values of a struct's fields (e.g., a configuration loaded from a file), so
+the secrets themselves don't have to be kept along with the rest of the
+values.
+
+A reference is written as "<scheme>://<name>", and is fetched by the
+Fetcher registered for its scheme. Fetchers are only created once a
+reference to their scheme is found, so no service is contacted if nothing
+references it. This package implements fetchers for AWS Secrets Manager and
+for the SSM Parameter Store.
+
+Every string field is resolved, including the ones of structs in slices.
+Fields of other types are left unchanged.
+
+Example:
+
+	type Options struct {
+		Token string
+	}
+
+	opts := Options{Token: "aws-sm://prod/notifier#token"}
+
+	r := secretref.New()
+	r.Register(secretref.SchemeSecretsManager, func() (secretref.Fetcher, error) {
+		return secretref.NewSecretsManager(awsSession), nil
+	})
+	resolved, err := r.Resolve(&opts)
+	if err != nil {
+		// handle err
+	}
+	log.Printf("Resolved %v", resolved)
+*/
+package secretref
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Fetcher fetches secrets by their names.
+type Fetcher interface {
+	// Fetch the secret identified by name (i.e., the reference without
+	// its scheme). Returns ErrNotFound if the secret doesn't exist.
+	Fetch(name string) (string, error)
+}
+
+// Opener creates the Fetcher of a scheme, once it's first needed.
+type Opener func() (Fetcher, error)
+
+// scheme is a registered scheme and its fetcher.
+type scheme struct {
+	// Creates the fetcher.
+	open Opener
+
+	// The fetcher, once it's created.
+	fetcher Fetcher
+}
+
+// Resolver resolves the references of every registered scheme. It must be
+// created by New().
+type Resolver struct {
+	// Synchronizes access to schemes.
+	mutex sync.Mutex
+
+	// Every registered scheme, by name (e.g., "ssm").
+	schemes map[string]*scheme
+}
+
+// New creates a Resolver without any scheme.
+func New() *Resolver {
+	return &Resolver{
+		schemes: make(map[string]*scheme),
+	}
+}
+
+// Register the scheme (e.g., "ssm", for references such as "ssm://name"),
+// whose fetcher is created by open once the first reference is fetched.
+func (r *Resolver) Register(name string, open Opener) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.schemes[name] = &scheme{open: open}
+}
+
+// split a reference into its scheme and its name. ok is false if value
+// isn't a reference to a registered scheme.
+func (r *Resolver) split(value string) (s *scheme, name string, ok bool) {
+	prefix, name, ok := strings.Cut(value, "://")
+	if !ok {
+		return nil, "", false
+	}
+	s, ok = r.schemes[prefix]
+	return s, name, ok
+}
+
+// IsRef checks whether value is a reference to a registered scheme.
+func (r *Resolver) IsRef(value string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	_, _, ok := r.split(value)
+	return ok
+}
+
+// Fetch the secret referenced by ref. Returns ErrUnknownScheme if ref
+// isn't a reference to a registered scheme.
+func (r *Resolver) Fetch(ref string) (string, error) {
+	r.mutex.Lock()
+	s, name, ok := r.split(ref)
+	if !ok {
+		r.mutex.Unlock()
+		return "", ErrUnknownScheme
+	}
+	if s.fetcher == nil {
+		f, err := s.open()
+		if err != nil {
+			r.mutex.Unlock()
+			return "", err
+		}
+		s.fetcher = f
+	}
+	f := s.fetcher
+	r.mutex.Unlock()
+
+	return f.Fetch(name)
+}
+
+// Resolve replaces the value of every field of dst (a pointer to a struct)
+// that holds a reference with the referenced secret, returning the names of
+// the resolved fields (e.g., "Token", or "Items[1].Token" for a struct in a
+// slice). Fields of dst listed in skip are left unchanged.
+//
+// If any reference can't be resolved, dst may be left with only some of
+// the references resolved.
+func (r *Resolver) Resolve(dst any, skip ...string) ([]string, error) {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return nil, ErrNotStruct
+	}
+
+	var resolved []string
+	err := r.resolveStruct(v.Elem(), "", skip, &resolved)
+	return resolved, err
+}
+
+// resolveStruct resolves every field of v, a struct, recursing into slices
+// of structs. Fields are named after prefix, and the ones listed in skip are
+// ignored. The names of the resolved fields are appended to resolved.
+func (r *Resolver) resolveStruct(v reflect.Value, prefix string, skip []string, resolved *[]string) error {
+	t := v.Type()
+
+fields:
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		for _, name := range skip {
+			if sf.Name == name {
+				continue fields
+			}
+		}
+
+		f := v.Field(i)
+		name := prefix + sf.Name
+		switch {
+		case f.Kind() == reflect.String && r.IsRef(f.String()):
+			secret, err := r.Fetch(f.String())
+			if err != nil {
+				return fmt.Errorf("'%s': %w", name, err)
+			}
+			f.SetString(secret)
+			*resolved = append(*resolved, name)
+		case f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.Struct:
+			for j := 0; j < f.Len(); j++ {
+				err := r.resolveStruct(f.Index(j), fmt.Sprintf("%s[%d].", name, j), nil, resolved)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package secretref
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type item struct {
+	Key string
+}
+
+type options struct {
+	Token string
+	Plain string
+	Skipped string
+	Port int
+	Items []item
+	hidden string
+}
+
+// mapFetcher fetches secrets from a map, counting the fetches.
+type mapFetcher struct {
+	secrets map[string]string
+	fetched int
+}
+
+func (m *mapFetcher) Fetch(name string) (string, error) {
+	m.fetched++
+	secret, ok := m.secrets[name]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return secret, nil
+}
+
+// TestResolve checks that every reference is resolved, recursing into
+// slices, and that other values are left unchanged.
+func TestResolve(t *testing.T) {
+	f := &mapFetcher{secrets: map[string]string{
+		"token": "s3cr3t",
+		"key": "k3y",
+	}}
+	opened := 0
+
+	r := New()
+	r.Register("test", func() (Fetcher, error) {
+		opened++
+		return f, nil
+	})
+
+	opts := options{
+		Token: "test://token",
+		Plain: "other://token",
+		Skipped: "test://token",
+		Port: 80,
+		Items: []item{{Key: "plain"}, {Key: "test://key"}},
+		hidden: "test://token",
+	}
+	resolved, err := r.Resolve(&opts, "Skipped")
+	if err != nil {
+		t.Fatalf("Resolve: Failed to resolve the references: %+v", err)
+	}
+
+	want := options{
+		Token: "s3cr3t",
+		Plain: "other://token",
+		Skipped: "test://token",
+		Port: 80,
+		Items: []item{{Key: "plain"}, {Key: "k3y"}},
+		hidden: "test://token",
+	}
+	if !reflect.DeepEqual(want, opts) {
+		t.Errorf("Resolve: Expected '%+v' but got '%+v'", want, opts)
+	}
+	if want := []string{"Token", "Items[1].Key"}; !reflect.DeepEqual(want, resolved) {
+		t.Errorf("Resolve: Expected the resolved fields '%+v' but got '%+v'", want, resolved)
+	}
+	if opened != 1 || f.fetched != 2 {
+		t.Errorf("Resolve: Expected the fetcher to be opened once and to fetch twice, but got %d and %d", opened, f.fetched)
+	}
+}
+
+// TestResolveErrors checks that missing secrets and invalid destinations
+// are reported, and that fetchers are only opened when referenced.
+func TestResolveErrors(t *testing.T) {
+	r := New()
+	r.Register("test", func() (Fetcher, error) {
+		return &mapFetcher{}, nil
+	})
+	r.Register("unused", func() (Fetcher, error) {
+		t.Errorf("Opened a fetcher that wasn't referenced")
+		return nil, ErrUnknownScheme
+	})
+
+	opts := options{Token: "test://missing"}
+	if _, err := r.Resolve(&opts); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Resolve: Expected error '%+v' but got '%+v'", ErrNotFound, err)
+	}
+	if _, err := r.Resolve(opts); err != ErrNotStruct {
+		t.Errorf("Resolve: Expected error '%+v' but got '%+v'", ErrNotStruct, err)
+	}
+	if _, err := r.Fetch("other://token"); err != ErrUnknownScheme {
+		t.Errorf("Fetch: Expected error '%+v' but got '%+v'", ErrUnknownScheme, err)
+	}
+}
@@ -0,0 +1,180 @@
+package sender
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of a CircuitBreaker.
+type BreakerState int
+
+const (
+	// Messages are sent normally.
+	BreakerClosed BreakerState = iota
+	// Sending is suspended until the cool-down period expires.
+	BreakerOpen
+	// The cool-down period expired and a single message is being sent to
+	// check whether the receiver recovered.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "invalid"
+	}
+}
+
+// CircuitBreaker wraps a Sender, suspending every send for a cool-down
+// period after too many consecutive failures. While suspended, Send fails
+// immediately with ErrCircuitOpen.
+type CircuitBreaker struct {
+	// The wrapped sender.
+	sender Sender
+
+	// Number of consecutive failures that opens the breaker.
+	threshold int
+
+	// Synchronizes access to the fields below.
+	mutex sync.Mutex
+
+	// For how long the breaker stays open.
+	cooldown time.Duration
+
+	// The breaker's current state.
+	state BreakerState
+
+	// Number of consecutive failures.
+	failures int
+
+	// When the breaker was last opened.
+	openedAt time.Time
+}
+
+// NewCircuitBreaker wraps s in a CircuitBreaker that opens after threshold
+// consecutive failures and stays open for cooldown.
+func NewCircuitBreaker(s Sender, threshold int, cooldown time.Duration) *CircuitBreaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+
+	return &CircuitBreaker{
+		sender: s,
+		threshold: threshold,
+		cooldown: cooldown,
+	}
+}
+
+// State retrieves the breaker's current state.
+func (cb *CircuitBreaker) State() BreakerState {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if cb.state == BreakerOpen && time.Since(cb.openedAt) >= cb.cooldown {
+		return BreakerHalfOpen
+	}
+	return cb.state
+}
+
+// RetryIn retrieves for how long the breaker will stay open. It returns 0
+// if the breaker accepts messages.
+func (cb *CircuitBreaker) RetryIn() time.Duration {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if cb.state != BreakerOpen {
+		return 0
+	}
+
+	remaining := cb.cooldown - time.Since(cb.openedAt)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// Probe expires the breaker's cool-down period, if it's open, so the next
+// message probes the receiver right away.
+func (cb *CircuitBreaker) Probe() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if cb.state == BreakerOpen {
+		cb.openedAt = time.Now().Add(-cb.cooldown)
+	}
+}
+
+// SetCooldown changes for how long the breaker stays open, including the
+// cool-down period in progress (if it's open).
+func (cb *CircuitBreaker) SetCooldown(cooldown time.Duration) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.cooldown = cooldown
+}
+
+// acquire checks whether a message may be sent right now, transitioning
+// the breaker from open to half-open if the cool-down period expired.
+func (cb *CircuitBreaker) acquire() bool {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	switch cb.state {
+	case BreakerOpen:
+		if time.Since(cb.openedAt) < cb.cooldown {
+			return false
+		}
+		logger().Info("sender/CircuitBreaker: Cool-down expired, probing the receiver")
+		cb.state = BreakerHalfOpen
+		return true
+	case BreakerHalfOpen:
+		// Only a single probe is allowed at a time.
+		return false
+	default:
+		return true
+	}
+}
+
+// release updates the breaker's state with the result of a send.
+func (cb *CircuitBreaker) release(err error) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	// Rejected messages are caused by the message itself, and throttled
+	// ones by sending too fast. Neither says anything about the receiver's
+	// health.
+	if err != nil && !errors.Is(err, ErrRejected) && !errors.Is(err, ErrInvalidInput) && !errors.Is(err, ErrThrottled) {
+		cb.failures++
+		if cb.state == BreakerHalfOpen || cb.failures >= cb.threshold {
+			if cb.state != BreakerOpen {
+				logger().Warn("sender/CircuitBreaker: Opening after consecutive failures", "failures", cb.failures)
+			}
+			cb.state = BreakerOpen
+			cb.openedAt = time.Now()
+		}
+		return
+	}
+
+	if cb.state != BreakerClosed {
+		logger().Info("sender/CircuitBreaker: Receiver recovered, closing")
+	}
+	cb.state = BreakerClosed
+	cb.failures = 0
+}
+
+func (cb *CircuitBreaker) Send(msg Message) (SendResult, error) {
+	if !cb.acquire() {
+		return SendResult{}, ErrCircuitOpen
+	}
+
+	res, err := cb.sender.Send(msg)
+	cb.release(err)
+	return res, err
+}
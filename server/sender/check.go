@@ -0,0 +1,76 @@
+package sender
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"net/url"
+	"path"
+)
+
+// Checker is implemented by senders able to verify that their receiver
+// exists and is reachable, without sending any message.
+type Checker interface {
+	// Check that the receiver is ready to accept messages. Returns
+	// ErrNotFound if the receiver doesn't exist, and ErrUnreachable if it
+	// couldn't be contacted.
+	Check() error
+}
+
+// isQueueMissing checks whether err was caused by a non-existing queue.
+func isQueueMissing(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == sqs.ErrCodeQueueDoesNotExist
+}
+
+// createQueue creates the sender's queue, named after the last component
+// of its URL.
+func (s sqsSender) createQueue(svc *sqs.SQS) error {
+	u, err := url.Parse(s.queue)
+	if err != nil {
+		logger().Error("sender/Check: Invalid queue URL", "queue", s.queue, "err", err)
+		return ErrInvalidInput
+	}
+
+	input := &sqs.CreateQueueInput{
+		QueueName: aws.String(path.Base(u.Path)),
+	}
+	if path.Ext(u.Path) == ".fifo" {
+		input.Attributes = map[string]*string{
+			sqs.QueueAttributeNameFifoQueue: aws.String("true"),
+		}
+	}
+
+	out, err := svc.CreateQueue(input)
+	if err != nil {
+		logger().Error("sender/Check: Failed to create the queue", "queue", s.queue, "err", err)
+		return wrap("Check", ErrUnreachable, err)
+	}
+
+	logger().Info("sender/Check: Created the queue", "queue", aws.StringValue(out.QueueUrl))
+	return nil
+}
+
+func (s sqsSender) Check() error {
+	svc := sqs.New(s.awsSession)
+
+	input := &sqs.GetQueueAttributesInput{
+		QueueUrl: aws.String(s.queue),
+		AttributeNames: []*string{
+			aws.String(sqs.QueueAttributeNameQueueArn),
+		},
+	}
+
+	_, err := svc.GetQueueAttributes(input)
+	if isQueueMissing(err) && s.createMissing {
+		return s.createQueue(svc)
+	} else if isQueueMissing(err) {
+		logger().Error("sender/Check: The queue doesn't exist", "queue", s.queue, "err", err)
+		return wrap("Check", ErrNotFound, err)
+	} else if err != nil {
+		logger().Warn("sender/Check: Couldn't reach the queue", "queue", s.queue, "err", err)
+		return wrap("Check", ErrUnreachable, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package sender
+
+import (
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"net/http"
+)
+
+// rejectedCodes lists the SQS error codes caused by the message itself.
+// Retrying those would never succeed, so the message must be discarded.
+var rejectedCodes = map[string]struct{}{
+	"InvalidMessageContents": {},
+	"InvalidParameterValue": {},
+	"InvalidAttributeName": {},
+	"InvalidAttributeValue": {},
+	"MissingParameter": {},
+	"ValidationError": {},
+}
+
+// isRejected checks whether err was caused by an invalid message.
+func isRejected(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+
+	_, ok = rejectedCodes[aerr.Code()]
+	return ok
+}
+
+// kmsThrottleCodes lists the error codes returned by SQS when the KMS key
+// used for server-side encryption is being throttled.
+var kmsThrottleCodes = map[string]struct{}{
+	"KMS.ThrottlingException": {},
+	"KmsThrottled": {},
+}
+
+// isThrottled checks whether err was caused by the receiver throttling
+// requests.
+func isThrottled(err error) bool {
+	if request.IsErrorThrottle(err) {
+		return true
+	}
+
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+
+	_, ok = kmsThrottleCodes[aerr.Code()]
+	return ok
+}
+
+// isRetryable checks whether err is a transient failure (e.g., throttling,
+// a 5xx response or a network error) that may succeed if retried.
+func isRetryable(err error) bool {
+	if request.IsErrorThrottle(err) {
+		return true
+	} else if isRejected(err) {
+		return false
+	} else if reqErr, ok := err.(awserr.RequestFailure); ok {
+		status := reqErr.StatusCode()
+		return status >= 500 || status == http.StatusTooManyRequests
+	}
+
+	return request.IsErrorRetryable(err)
+}
@@ -0,0 +1,106 @@
+package sender
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// dryRunSender implements Sender by logging messages instead of
+// delivering them.
+type dryRunSender struct {
+	// Synchronizes writing to the file and the message counter.
+	mutex *sync.Mutex
+
+	// File where messages are appended, as JSON lines. If nil, messages
+	// are logged instead.
+	file *os.File
+
+	// Number of messages "sent" so far, used to generate message IDs.
+	count *int
+}
+
+// dryRunEntry is the format of each line written by a dry-run sender.
+type dryRunEntry struct {
+	// Identifier assigned to the message.
+	MessageID string
+
+	// When the message was "sent".
+	Time time.Time
+
+	// The message's contents.
+	Body string
+
+	// The requested delay, in seconds.
+	DelaySeconds int64 `json:",omitempty"`
+
+	// Metadata sent alongside the message.
+	Attributes map[string]string `json:",omitempty"`
+}
+
+func (d dryRunSender) Send(msg Message) (SendResult, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	*d.count++
+	entry := dryRunEntry{
+		MessageID: fmt.Sprintf("dry-run-%d", *d.count),
+		Time: time.Now(),
+		Body: msg.Body,
+		DelaySeconds: int64(msg.Delay / time.Second),
+		Attributes: msg.Attributes,
+	}
+
+	res := SendResult{
+		MessageID: entry.MessageID,
+		SentAt: entry.Time,
+		Attempts: 1,
+	}
+
+	if d.file == nil {
+		logger().Info("sender/DryRun: Sent the message", "message_id", entry.MessageID, "delay_seconds", entry.DelaySeconds, "body", entry.Body)
+		return res, nil
+	}
+
+	data, err := json.Marshal(&entry)
+	if err != nil {
+		logger().Error("sender/DryRun: Failed to encode the message", "err", err)
+		return res, wrap("DryRun", ErrInvalidInput, err)
+	}
+
+	_, err = d.file.Write(append(data, '\n'))
+	if err != nil {
+		logger().Error("sender/DryRun: Failed to write the message", "err", err)
+		return res, wrap("DryRun", ErrSendFailed, err)
+	}
+
+	return res, nil
+}
+
+// NewDryRunSender creates a sender that never delivers any message.
+// Instead, messages are appended to the file at path, as JSON lines, or
+// simply logged if path is empty.
+//
+// If the file can't be opened, a *ConfigError is returned.
+func NewDryRunSender(path string) (Sender, error) {
+	d := dryRunSender{
+		mutex: &sync.Mutex{},
+		count: new(int),
+	}
+
+	if len(path) > 0 {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, &ConfigError{
+				Op: "NewDryRunSender",
+				Msg: fmt.Sprintf("Failed to open '%s'", path),
+				Err: err,
+			}
+		}
+		d.file = file
+	}
+
+	return d, nil
+}
@@ -0,0 +1,125 @@
+package sender
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// EmailSubjectAttr is the message attribute used as the subject of emails.
+const EmailSubjectAttr = "Subject"
+
+// EmailOptions configures an email sender.
+type EmailOptions struct {
+	// Address ("host:port") of the SMTP server. STARTTLS is used if the
+	// server supports it.
+	Addr string
+
+	// User used to authenticate to the SMTP server (with PLAIN). Leave
+	// empty to not authenticate.
+	User string
+
+	// Password used to authenticate to the SMTP server.
+	Password string
+
+	// Address from which the emails are sent.
+	From string
+
+	// Addresses that receive every email.
+	To []string
+
+	// Subject of emails whose message doesn't set EmailSubjectAttr.
+	// Defaults to "sqs-issue-notifier".
+	Subject string
+}
+
+// emailSender implements Sender by emailing each message through SMTP.
+type emailSender struct {
+	// How the emails are sent.
+	opts EmailOptions
+
+	// Authenticates to the SMTP server, if set.
+	auth smtp.Auth
+}
+
+func (e emailSender) Send(msg Message) (SendResult, error) {
+	res := SendResult{Attempts: 1}
+	start := time.Now()
+
+	subject := msg.Attributes[EmailSubjectAttr]
+	if len(subject) == 0 {
+		subject = e.opts.Subject
+	}
+
+	var id [16]byte
+	rand.Read(id[:])
+	res.MessageID = fmt.Sprintf("<%s@sqs-issue-notifier>", hex.EncodeToString(id[:]))
+
+	var data bytes.Buffer
+	fmt.Fprintf(&data, "From: %s\r\n", e.opts.From)
+	fmt.Fprintf(&data, "To: %s\r\n", strings.Join(e.opts.To, ", "))
+	fmt.Fprintf(&data, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&data, "Date: %s\r\n", start.Format(time.RFC1123Z))
+	fmt.Fprintf(&data, "Message-ID: %s\r\n", res.MessageID)
+	data.WriteString("MIME-Version: 1.0\r\n")
+	data.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	data.WriteString("\r\n")
+	data.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	err := smtp.SendMail(e.opts.Addr, e.auth, e.opts.From, e.opts.To, data.Bytes())
+	var protoErr *textproto.Error
+	switch {
+	case err == nil:
+		res.SentAt = time.Now()
+		res.Duration = res.SentAt.Sub(start)
+		return res, nil
+	case errors.As(err, &protoErr) && protoErr.Code >= 500:
+		logger().Error("sender/Email: The email was rejected", "err", err)
+		return res, wrap("Email", ErrRejected, err)
+	case errors.As(err, &protoErr):
+		logger().Error("sender/Email: Failed to send the email", "err", err)
+		return res, wrap("Email", ErrTemporary, err)
+	default:
+		logger().Error("sender/Email: Failed to contact the SMTP server", "err", err)
+		return res, wrap("Email", ErrUnreachable, err)
+	}
+}
+
+// NewEmailSender creates a sender that emails each message's body, as plain
+// text, to every address in opts.To. The subject is taken from the message's
+// EmailSubjectAttr attribute, and the other attributes are ignored.
+//
+// If opts is incomplete, a *ConfigError is returned.
+func NewEmailSender(opts EmailOptions) (Sender, error) {
+	host, _, err := net.SplitHostPort(opts.Addr)
+	if err != nil {
+		return nil, &ConfigError{
+			Op: "NewEmailSender",
+			Msg: fmt.Sprintf("Invalid SMTP address '%s'", opts.Addr),
+			Err: err,
+		}
+	}
+	if len(opts.From) == 0 || len(opts.To) == 0 {
+		return nil, &ConfigError{Op: "NewEmailSender", Msg: "Both the sender and the recipients must be specified"}
+	}
+	if len(opts.Subject) == 0 {
+		opts.Subject = "sqs-issue-notifier"
+	}
+
+	e := emailSender{
+		opts: opts,
+	}
+	if len(opts.User) > 0 {
+		e.auth = smtp.PlainAuth("", opts.User, opts.Password, host)
+	}
+
+	return e, nil
+}
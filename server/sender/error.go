@@ -1,5 +1,9 @@
 package sender
 
+import (
+	"fmt"
+)
+
 type error_code uint
 
 const (
@@ -7,6 +11,22 @@ const (
 	ErrInvalidInput error_code = iota
 	// Failed to send the message.
 	ErrSendFailed
+	// The message was permanently rejected by the receiver.
+	ErrRejected
+	// The circuit breaker is open, so the message wasn't sent.
+	ErrCircuitOpen
+	// The receiver doesn't exist.
+	ErrNotFound
+	// Couldn't contact the receiver.
+	ErrUnreachable
+	// Failed to send the message, but retrying may succeed.
+	ErrTemporary
+	// The sender's configuration is invalid.
+	ErrInvalidConfig
+	// The receiver is throttling messages, so they should be sent slower.
+	ErrThrottled
+	// Failed to receive (or to delete) a message from a queue.
+	ErrReceiveFailed
 )
 
 func (e error_code) Error() string {
@@ -15,7 +35,88 @@ func (e error_code) Error() string {
 		return "Invalid input."
 	case ErrSendFailed:
 		return "Failed to send the message."
+	case ErrRejected:
+		return "The message was permanently rejected by the receiver."
+	case ErrCircuitOpen:
+		return "The circuit breaker is open, so the message wasn't sent."
+	case ErrNotFound:
+		return "The receiver doesn't exist."
+	case ErrUnreachable:
+		return "Couldn't contact the receiver."
+	case ErrTemporary:
+		return "Temporarily failed to send the message."
+	case ErrInvalidConfig:
+		return "The sender's configuration is invalid."
+	case ErrThrottled:
+		return "The receiver is throttling messages."
+	case ErrReceiveFailed:
+		return "Failed to receive the message."
 	default:
 		return "Invalid local_storage error."
 	}
 }
+
+// ConfigError reports why a sender couldn't be created. It matches
+// ErrInvalidConfig on errors.Is, and unwraps to its underlying cause (if
+// any).
+type ConfigError struct {
+	// The constructor that failed.
+	Op string
+
+	// What exactly is wrong.
+	Msg string
+
+	// The underlying cause, if any.
+	Err error
+}
+
+func (e *ConfigError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("sender/%s: %s: %+v", e.Op, e.Msg, e.Err)
+	}
+	return fmt.Sprintf("sender/%s: %s", e.Op, e.Msg)
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ConfigError) Is(target error) bool {
+	return target == ErrInvalidConfig
+}
+
+// Error reports why a sender failed. It matches its Code on errors.Is
+// (e.g., ErrTemporary, as returned before), and unwraps to its underlying
+// cause (e.g., the awserr.Error returned by AWS), so callers may tell
+// failures apart.
+type Error struct {
+	// The operation that failed.
+	Op string
+
+	// How it failed.
+	Code error_code
+
+	// The underlying cause.
+	Err error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("sender/%s: %s %+v", e.Op, e.Code, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Code
+}
+
+// wrap err, the reason why op failed with code.
+func wrap(op string, code error_code, err error) error {
+	return &Error{
+		Op: op,
+		Code: code,
+		Err: err,
+	}
+}
@@ -0,0 +1,132 @@
+package sender
+
+import (
+	"errors"
+	"time"
+)
+
+// Destination is a sender identified by a name (e.g., in the logs).
+type Destination struct {
+	// The destination's name.
+	Name string
+
+	// The sender that delivers messages to the destination.
+	Sender Sender
+}
+
+// Fanout sends every message to each of its destinations, in order. If the
+// message names its destinations, it's only sent to those.
+//
+// The message is only sent once every destination accepts it. If any
+// destination fails, the whole Send fails, so retrying it also resends the
+// message to the destinations that already accepted it. Consumers must
+// tolerate duplicates anyway, as SQS standard queues deliver messages at
+// least once.
+type Fanout struct {
+	// Every destination, in order.
+	destinations []Destination
+}
+
+// NewFanout creates a Fanout to every destination in destinations.
+func NewFanout(destinations ...Destination) *Fanout {
+	return &Fanout{
+		destinations: destinations,
+	}
+}
+
+// Destinations retrieves every destination of the Fanout, in order.
+func (f *Fanout) Destinations() []Destination {
+	return append([]Destination(nil), f.destinations...)
+}
+
+// isPermanent checks whether err means that the message will never be
+// accepted, so retrying it is pointless.
+func isPermanent(err error) bool {
+	return errors.Is(err, ErrRejected) || errors.Is(err, ErrInvalidInput)
+}
+
+// route retrieves the destinations named in msg.Destinations, in the
+// order they were given, or every destination if msg doesn't name any.
+// Returns ErrInvalidInput if any name is unknown.
+func (f *Fanout) route(msg Message) ([]Destination, error) {
+	if len(msg.Destinations) == 0 {
+		return f.destinations, nil
+	}
+
+	var dests []Destination
+	for _, name := range msg.Destinations {
+		found := false
+		for _, d := range f.destinations {
+			if d.Name == name {
+				dests = append(dests, d)
+				found = true
+				break
+			}
+		}
+		if !found {
+			logger().Warn("sender/Fanout: Unknown destination", "destination", name)
+			return nil, ErrInvalidInput
+		}
+	}
+	return dests, nil
+}
+
+// Send msg to every destination (or to the ones it names), returning the
+// result of the first one (but with the duration and attempts of the whole
+// fan-out).
+//
+// If some destinations fail, the error of the last one that may succeed on
+// a retry is returned, so the message is only considered permanently
+// rejected if every failure is permanent.
+func (f *Fanout) Send(msg Message) (SendResult, error) {
+	var ret SendResult
+	var retErr error
+	start := time.Now()
+
+	dests, err := f.route(msg)
+	if err != nil {
+		return ret, err
+	}
+	for i, d := range dests {
+		res, err := d.Sender.Send(msg)
+		ret.Attempts += res.Attempts
+		if err != nil {
+			logger().Warn("sender/Fanout: Failed to send the message to a destination", "destination", d.Name, "err", err)
+			if retErr == nil || !isPermanent(err) {
+				retErr = err
+			}
+			continue
+		}
+
+		if i == 0 {
+			ret.MessageID = res.MessageID
+			ret.SequenceNumber = res.SequenceNumber
+		}
+	}
+	if retErr != nil {
+		return SendResult{}, retErr
+	}
+
+	ret.SentAt = time.Now()
+	ret.Duration = ret.SentAt.Sub(start)
+	return ret, nil
+}
+
+// Check every destination that implements Checker, returning the first
+// error. Destinations that can't be checked are skipped.
+func (f *Fanout) Check() error {
+	for _, d := range f.destinations {
+		checker, ok := d.Sender.(Checker)
+		if !ok {
+			continue
+		}
+
+		err := checker.Check()
+		if err != nil {
+			logger().Warn("sender/Fanout: Failed to check a destination", "destination", d.Name, "err", err)
+			return err
+		}
+	}
+
+	return nil
+}
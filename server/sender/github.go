@@ -0,0 +1,335 @@
+package sender
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultGitHubAPIURL is the API where issues are filed, unless another one
+// (e.g., GitHub Enterprise Server's) is configured.
+const DefaultGitHubAPIURL = "https://api.github.com"
+
+const (
+	// Longest title of an issue, in characters.
+	maxIssueTitle = 256
+	// Longest body of an issue, in characters.
+	maxIssueBody = 65536
+	// For how long filed issues are remembered, so duplicates are
+	// recognized even before GitHub's search indexes them.
+	issueDedupTTL = time.Hour
+	// Prefix of the hidden marker, in the issue's body, that identifies
+	// the issue by the hash of its title.
+	issueMarkerPrefix = "sqs-issue-notifier:title="
+)
+
+// GitHubOptions configures a GitHub sender.
+type GitHubOptions struct {
+	// URL of GitHub's REST API. Defaults to DefaultGitHubAPIURL.
+	APIURL string
+
+	// Token used to authenticate to the API. It must be allowed to read
+	// and to create issues in Repo.
+	Token string
+
+	// Repository, as "owner/name", where issues are filed.
+	Repo string
+
+	// Labels applied to every issue filed.
+	Labels []string
+
+	// Timeout for each request to the API. Zero means no timeout.
+	Timeout time.Duration
+}
+
+// githubSender implements Sender by filing an issue in a GitHub repository.
+type githubSender struct {
+	// How the sender was configured.
+	opts GitHubOptions
+
+	// Client used to access the API.
+	client *http.Client
+
+	// Serializes sending, so concurrent duplicates aren't filed twice.
+	mutex sync.Mutex
+
+	// The issues recently filed (or found), by the hash of their title.
+	recent map[string]filedIssue
+}
+
+// filedIssue is an issue recently filed (or found) by the sender.
+type filedIssue struct {
+	// URL of the issue's page.
+	URL string
+
+	// When the issue was filed (or found).
+	At time.Time
+}
+
+// githubIssue is the subset of GitHub's issues used by the sender.
+type githubIssue struct {
+	// URL of the issue's page.
+	HTMLURL string `json:"html_url"`
+
+	// The issue's body.
+	Body string `json:"body"`
+}
+
+// newIssue is the payload posted to create an issue.
+type newIssue struct {
+	Title string `json:"title"`
+	Body string `json:"body"`
+	Labels []string `json:"labels,omitempty"`
+}
+
+// issueText splits text into an issue's title (its first non-empty line)
+// and body (the remaining lines).
+func issueText(text string) (title, body string) {
+	text = strings.TrimSpace(text)
+	title, body, _ = strings.Cut(text, "\n")
+	title = strings.TrimSpace(title)
+	if utf8.RuneCountInString(title) > maxIssueTitle {
+		title = string([]rune(title)[:maxIssueTitle - 1]) + "…"
+	}
+	return title, strings.TrimSpace(body)
+}
+
+// issueHash identifies the issues with the given title.
+func issueHash(title string) string {
+	sum := sha256.Sum256([]byte(title))
+	return hex.EncodeToString(sum[:16])
+}
+
+// issueMarker is appended, hidden, to the body of the issues with the given
+// hash, so they may be searched for.
+func issueMarker(hash string) string {
+	return "<!-- " + issueMarkerPrefix + hash + " -->"
+}
+
+// do sends a request to the API, decoding its reply into out (if not nil).
+func (s *githubSender) do(op, method, path string, query url.Values, in, out any) error {
+	u := strings.TrimSuffix(s.opts.APIURL, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return wrap(op, ErrInvalidInput, err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return wrap(op, ErrInvalidInput, err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer " + s.opts.Token)
+	req.Header.Set("User-Agent", "sqs-issue-notifier")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		logger().Error("sender/GitHub: Failed to contact the API", "op", op, "err", err)
+		return wrap(op, ErrUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if out == nil {
+			return nil
+		}
+		err = json.NewDecoder(resp.Body).Decode(out)
+		if err != nil {
+			return wrap(op, ErrTemporary, fmt.Errorf("invalid reply: %w", err))
+		}
+		return nil
+	}
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests,
+			resp.StatusCode == http.StatusForbidden && resp.Header.Get("X-RateLimit-Remaining") == "0",
+			resp.StatusCode == http.StatusForbidden && len(resp.Header.Get("Retry-After")) > 0:
+		return wrap(op, ErrThrottled, err)
+	case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden:
+		// The token is invalid (or lacks permissions), which must be fixed
+		// before the message may be sent.
+		return wrap(op, ErrSendFailed, err)
+	case resp.StatusCode == http.StatusNotFound, resp.StatusCode == http.StatusGone:
+		// The repository doesn't exist (or has issues disabled).
+		return wrap(op, ErrNotFound, err)
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return wrap(op, ErrRejected, err)
+	default:
+		return wrap(op, ErrTemporary, err)
+	}
+}
+
+// findIssue searches for an open issue with the given hash. It returns an
+// empty URL if there's none.
+func (s *githubSender) findIssue(hash string) (string, error) {
+	var res struct {
+		Items []githubIssue `json:"items"`
+	}
+
+	query := url.Values{
+		"q": {fmt.Sprintf("%s repo:%s is:issue is:open in:body", hash, s.opts.Repo)},
+		"per_page": {"10"},
+	}
+	err := s.do("GitHub", http.MethodGet, "/search/issues", query, nil, &res)
+	if err != nil {
+		return "", err
+	}
+
+	// The search matches words, so the marker must be verified.
+	marker := issueMarker(hash)
+	for _, issue := range res.Items {
+		if strings.Contains(issue.Body, marker) {
+			return issue.HTMLURL, nil
+		}
+	}
+	return "", nil
+}
+
+// Send files msg's body as an issue: its first line is the title and the
+// remaining lines are the body. If an open issue with the same title was
+// already filed by the sender, no issue is filed, and the existing one is
+// returned as the MessageID. Attributes are ignored.
+func (s *githubSender) Send(msg Message) (SendResult, error) {
+	res := SendResult{Attempts: 1}
+	start := time.Now()
+
+	title, body := issueText(msg.Body)
+	if len(title) == 0 {
+		return res, wrap("GitHub", ErrInvalidInput, errors.New("the message is empty"))
+	}
+	hash := issueHash(title)
+	marker := issueMarker(hash)
+	if maxBody := maxIssueBody - len(marker) - 2; utf8.RuneCountInString(body) > maxBody {
+		body = string([]rune(body)[:maxBody - 1]) + "…"
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for h, issue := range s.recent {
+		if now.Sub(issue.At) > issueDedupTTL {
+			delete(s.recent, h)
+		}
+	}
+
+	issue, ok := s.recent[hash]
+	if !ok {
+		u, err := s.findIssue(hash)
+		if err != nil {
+			return res, err
+		}
+		issue = filedIssue{URL: u, At: now}
+	}
+
+	if len(issue.URL) == 0 {
+		var created githubIssue
+		err := s.do("GitHub", http.MethodPost, "/repos/" + s.opts.Repo + "/issues", nil, &newIssue{
+			Title: title,
+			Body: strings.TrimSpace(body + "\n\n" + marker),
+			Labels: s.opts.Labels,
+		}, &created)
+		if err != nil {
+			return res, err
+		}
+		issue.URL = created.HTMLURL
+		logger().Info("sender/GitHub: Filed an issue", "issue", issue.URL)
+	} else {
+		logger().Info("sender/GitHub: The issue was already filed", "issue", issue.URL)
+	}
+	s.recent[hash] = issue
+
+	res.MessageID = issue.URL
+	res.SentAt = time.Now()
+	res.Duration = res.SentAt.Sub(start)
+	return res, nil
+}
+
+// Check that the repository exists and is readable with the token.
+func (s *githubSender) Check() error {
+	return s.do("Check", http.MethodGet, "/repos/" + s.opts.Repo, nil, nil, nil)
+}
+
+// NewGitHubSender creates a sender that files an issue for each message in
+// the repository opts.Repo, titled after the first line of the message's
+// body. Issues are identified by the hash of their title, hidden in their
+// body, so a message whose title matches an open issue doesn't file a new
+// one (e.g., when it's delivered twice, or when the same problem is
+// reported again). Once the issue is closed, the next message files a new
+// issue.
+//
+// The sender also implements Checker, which verifies that the repository
+// is accessible.
+//
+// If the options are invalid, a *ConfigError is returned.
+func NewGitHubSender(opts GitHubOptions) (Sender, error) {
+	if len(opts.APIURL) == 0 {
+		opts.APIURL = DefaultGitHubAPIURL
+	}
+
+	u, err := url.Parse(opts.APIURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || len(u.Host) == 0 {
+		return nil, &ConfigError{
+			Op: "NewGitHubSender",
+			Msg: "The API's URL must be an absolute HTTP(S) URL",
+			Err: err,
+		}
+	} else if len(opts.Token) == 0 {
+		return nil, &ConfigError{
+			Op: "NewGitHubSender",
+			Msg: "A token is required",
+		}
+	} else if err := checkRepo(opts.Repo); err != nil {
+		return nil, &ConfigError{
+			Op: "NewGitHubSender",
+			Msg: "Invalid repository",
+			Err: err,
+		}
+	}
+
+	return &githubSender{
+		opts: opts,
+		client: &http.Client{Timeout: opts.Timeout},
+		recent: make(map[string]filedIssue),
+	}, nil
+}
+
+// checkRepo checks whether repo is a GitHub repository, as "owner/name".
+func checkRepo(repo string) error {
+	owner, name, ok := strings.Cut(repo, "/")
+	if !ok || len(owner) == 0 || len(name) == 0 {
+		return fmt.Errorf("'%s' must be \"owner/name\"", repo)
+	}
+
+	for _, c := range owner + name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.", c)) {
+			return fmt.Errorf("'%s' has an invalid character (%s)", repo, strconv.QuoteRune(c))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package sender
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/SirGFM/sqs-issue-notifier/server/notifierpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"os"
+	"time"
+)
+
+// GRPCOptions configures optional behaviour of a gRPC sender.
+type GRPCOptions struct {
+	// Connect to the collector over TLS.
+	TLS bool
+
+	// PEM file with the certificate authorities used to verify the
+	// collector. Defaults to the system's pool.
+	CAFile string
+
+	// Timeout for each call to the collector. Defaults to no timeout.
+	Timeout time.Duration
+}
+
+// grpcSender implements Sender for a notifierpb.Collector service.
+type grpcSender struct {
+	// The collector's client.
+	client notifierpb.CollectorClient
+
+	// Timeout for each call.
+	timeout time.Duration
+}
+
+func (g grpcSender) Send(msg Message) (SendResult, error) {
+	res := SendResult{Attempts: 1}
+	start := time.Now()
+
+	ctx := context.Background()
+	if g.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.timeout)
+		defer cancel()
+	}
+
+	out, err := g.client.Collect(ctx, &notifierpb.CollectRequest{
+		Body: msg.Body,
+		DelaySeconds: int64(msg.Delay / time.Second),
+		Attributes: msg.Attributes,
+	})
+	switch status.Code(err) {
+	case codes.OK:
+		res.MessageID = out.GetMessageId()
+		res.SentAt = time.Now()
+		res.Duration = res.SentAt.Sub(start)
+		return res, nil
+	case codes.InvalidArgument, codes.OutOfRange:
+		logger().Warn("sender/Send: The message was rejected", "body", msg.Body, "err", err)
+		return res, wrap("Send", ErrRejected, err)
+	case codes.ResourceExhausted:
+		logger().Warn("sender/Send: Throttled while sending the message", "body", msg.Body, "err", err)
+		return res, wrap("Send", ErrThrottled, err)
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Aborted:
+		logger().Warn("sender/Send: Temporarily failed to send the message", "body", msg.Body, "err", err)
+		return res, wrap("Send", ErrTemporary, err)
+	default:
+		logger().Error("sender/Send: Failed to send the message", "body", msg.Body, "err", err)
+		return res, wrap("Send", ErrSendFailed, err)
+	}
+}
+
+// NewGRPCSender creates a sender that delivers messages to the
+// notifierpb.Collector service listening on target (e.g., "host:port").
+//
+// The connection is established lazily, so this succeeds even if the
+// collector is currently unreachable. If the sender can't be created, a
+// *ConfigError describing the problem is returned.
+func NewGRPCSender(target string, opts GRPCOptions) (Sender, error) {
+	if len(target) == 0 {
+		return nil, &ConfigError{Op: "NewGRPCSender", Msg: "No target was specified"}
+	}
+
+	creds := insecure.NewCredentials()
+	if opts.TLS {
+		var cfg tls.Config
+
+		if len(opts.CAFile) > 0 {
+			pem, err := os.ReadFile(opts.CAFile)
+			if err != nil {
+				return nil, &ConfigError{
+					Op: "NewGRPCSender",
+					Msg: fmt.Sprintf("Failed to read '%s'", opts.CAFile),
+					Err: err,
+				}
+			}
+
+			cfg.RootCAs = x509.NewCertPool()
+			if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, &ConfigError{
+					Op: "NewGRPCSender",
+					Msg: fmt.Sprintf("No certificate found in '%s'", opts.CAFile),
+				}
+			}
+		}
+
+		creds = credentials.NewTLS(&cfg)
+	}
+
+	conn, err := grpc.Dial(target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, &ConfigError{
+			Op: "NewGRPCSender",
+			Msg: fmt.Sprintf("Failed to configure the connection to '%s'", target),
+			Err: err,
+		}
+	}
+
+	return grpcSender{
+		client: notifierpb.NewCollectorClient(conn),
+		timeout: opts.Timeout,
+	}, nil
+}
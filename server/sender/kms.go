@@ -0,0 +1,143 @@
+package sender
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+// KMSEncryptionScheme identifies bodies encrypted by a KMSEncrypter: the
+// body is the base64 encoding of a random nonce followed by the body
+// encrypted with AES-256-GCM, and the data key, encrypted by KMS, is
+// supplied in the attribute KMSDataKeyAttr.
+const KMSEncryptionScheme = "kms-aes-256-gcm"
+
+// KMSDataKeyAttr is the message attribute with the base64 encoded data key,
+// encrypted by KMS, used to encrypt the message. Consumers must decrypt it
+// with KMS to decrypt the body.
+const KMSDataKeyAttr = "EncryptedDataKey"
+
+// KMSEncrypter encrypts messages with a data key generated by AWS KMS
+// (i.e., envelope encryption), so messages of any size may be encrypted and
+// only consumers allowed to use the KMS key may decrypt them.
+type KMSEncrypter struct {
+	// The AWS session for sending requests.
+	awsSession *session.Session
+
+	// The KMS key used to encrypt the data keys.
+	keyID string
+}
+
+// NewKMSEncrypter creates an encrypter for the KMS key keyID (its ID, ARN
+// or alias). The AWS region and credentials are configured as done by
+// NewSQSSender, and endpoint may be used to target localstack.
+//
+// If the encrypter can't be created, a *ConfigError describing the problem
+// is returned.
+func NewKMSEncrypter(endpoint, keyID string, opts SQSOptions) (*KMSEncrypter, error) {
+	if len(keyID) == 0 {
+		return nil, &ConfigError{Op: "NewKMSEncrypter", Msg: "No key was specified"}
+	}
+
+	awsSession, err := newAWSSession("NewKMSEncrypter", endpoint, opts.Region, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KMSEncrypter{
+		awsSession: awsSession,
+		keyID: keyID,
+	}, nil
+}
+
+// Scheme identifies how bodies are encrypted.
+func (k *KMSEncrypter) Scheme() string {
+	return KMSEncryptionScheme
+}
+
+// Encrypt plaintext with a new data key, returning the encrypted body and
+// the attributes that must be sent alongside it.
+func (k *KMSEncrypter) Encrypt(plaintext []byte) ([]byte, map[string]string, error) {
+	svc := kms.New(k.awsSession)
+
+	out, err := svc.GenerateDataKey(&kms.GenerateDataKeyInput{
+		KeyId: aws.String(k.keyID),
+		KeySpec: aws.String(kms.DataKeySpecAes256),
+	})
+	if isThrottled(err) {
+		logger().Warn("sender/KMSEncrypter: Throttled while generating a data key", "err", err)
+		return nil, nil, wrap("KMSEncrypter", ErrThrottled, err)
+	} else if isRetryable(err) {
+		logger().Warn("sender/KMSEncrypter: Temporarily failed to generate a data key", "err", err)
+		return nil, nil, wrap("KMSEncrypter", ErrTemporary, err)
+	} else if err != nil {
+		logger().Error("sender/KMSEncrypter: Failed to generate a data key", "err", err)
+		return nil, nil, wrap("KMSEncrypter", ErrSendFailed, err)
+	}
+
+	body, err := sealEnvelope(out.Plaintext, plaintext)
+	if err != nil {
+		logger().Error("sender/KMSEncrypter: Failed to encrypt the message", "err", err)
+		return nil, nil, wrap("KMSEncrypter", ErrSendFailed, err)
+	}
+
+	attrs := map[string]string{
+		KMSDataKeyAttr: base64.StdEncoding.EncodeToString(out.CiphertextBlob),
+	}
+	return body, attrs, nil
+}
+
+// sealEnvelope encrypts plaintext with key, as described by
+// KMSEncryptionScheme.
+func sealEnvelope(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, plaintext, nil)
+	out := make([]byte, base64.StdEncoding.EncodedLen(len(sealed)))
+	base64.StdEncoding.Encode(out, sealed)
+	return out, nil
+}
+
+// openEnvelope decrypts a body encrypted by sealEnvelope.
+func openEnvelope(key, body []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := make([]byte, base64.StdEncoding.DecodedLen(len(body)))
+	n, err := base64.StdEncoding.Decode(sealed, body)
+	if err != nil {
+		return nil, err
+	}
+	sealed = sealed[:n]
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("The body is too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+// newGCM creates an AES-GCM cipher for key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
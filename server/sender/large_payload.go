@@ -0,0 +1,161 @@
+package sender
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"io"
+	"strconv"
+)
+
+// MaxSQSMessageSize is the largest message accepted by a SQS, in bytes.
+// It includes the message's attributes (see AttributesSize).
+const MaxSQSMessageSize = 256 * 1024
+
+// AttributesSize is how many bytes attrs count towards MaxSQSMessageSize,
+// as sent by the SQS sender: each attribute counts its name, its data type
+// ("String") and its value.
+func AttributesSize(attrs map[string]string) int {
+	var size int
+	for name, value := range attrs {
+		size += len(name) + len("String") + len(value)
+	}
+	return size
+}
+
+// The class name used by the Amazon SQS Extended Client to identify
+// messages whose payload was offloaded to S3.
+const s3PointerClass = "software.amazon.payloadoffloading.PayloadS3Pointer"
+
+// The message attribute, set by the Amazon SQS Extended Client, with the
+// size of the offloaded payload.
+const extendedPayloadSizeAttr = "ExtendedPayloadSize"
+
+// s3Pointer references a payload offloaded to S3.
+type s3Pointer struct {
+	// The bucket where the payload was stored.
+	Bucket string `json:"s3BucketName"`
+
+	// The object's key.
+	Key string `json:"s3Key"`
+}
+
+// newPayloadKey generates a random (version 4) UUID, used as the key of
+// offloaded payloads.
+func newPayloadKey() (string, error) {
+	var uuid [16]byte
+
+	_, err := rand.Read(uuid[:])
+	if err != nil {
+		return "", err
+	}
+	uuid[6] = (uuid[6] & 0x0f) | 0x40
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:]), nil
+}
+
+// offloadPayload uploads the input's body to the large payload bucket,
+// replacing the body with a pointer to the uploaded object, in the format
+// used by the Amazon SQS Extended Client.
+func (s sqsSender) offloadPayload(input *sqs.SendMessageInput) error {
+	body := aws.StringValue(input.MessageBody)
+
+	key, err := newPayloadKey()
+	if err != nil {
+		logger().Error("sender/Send: Failed to generate the payload's key", "err", err)
+		return wrap("Send", ErrSendFailed, err)
+	}
+
+	svc := s3.New(s.awsSession)
+	_, err = svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(s.largePayloadBucket),
+		Key: aws.String(key),
+		Body: bytes.NewReader([]byte(body)),
+	})
+	if isRetryable(err) {
+		logger().Warn("sender/Send: Temporarily failed to upload the payload to S3", "err", err)
+		return wrap("Send", ErrTemporary, err)
+	} else if err != nil {
+		logger().Error("sender/Send: Failed to upload the payload to S3", "err", err)
+		return wrap("Send", ErrSendFailed, err)
+	}
+
+	ptr, err := json.Marshal([]interface{}{
+		s3PointerClass,
+		s3Pointer{
+			Bucket: s.largePayloadBucket,
+			Key: key,
+		},
+	})
+	if err != nil {
+		logger().Error("sender/Send: Failed to encode the payload's pointer", "err", err)
+		return wrap("Send", ErrSendFailed, err)
+	}
+
+	input.MessageBody = aws.String(string(ptr))
+	if input.MessageAttributes == nil {
+		input.MessageAttributes = make(map[string]*sqs.MessageAttributeValue)
+	}
+	input.MessageAttributes[extendedPayloadSizeAttr] = &sqs.MessageAttributeValue{
+		DataType: aws.String("Number"),
+		StringValue: aws.String(strconv.Itoa(len(body))),
+	}
+
+	logger().Debug("sender/Send: Offloaded the payload to S3", "bytes", len(body), "bucket", s.largePayloadBucket, "key", key)
+	return nil
+}
+
+// fetchPayload downloads the payload referenced by body, a pointer in the
+// format used by the Amazon SQS Extended Client (as sent by offloadPayload).
+func fetchPayload(awsSession *session.Session, body string) (string, error) {
+	var ptr []json.RawMessage
+	var class string
+	var obj s3Pointer
+
+	err := json.Unmarshal([]byte(body), &ptr)
+	if err == nil && len(ptr) != 2 {
+		err = fmt.Errorf("expected a class and a pointer but got %d values", len(ptr))
+	}
+	if err == nil {
+		err = json.Unmarshal(ptr[0], &class)
+	}
+	if err == nil && class != s3PointerClass {
+		err = fmt.Errorf("unknown pointer class '%s'", class)
+	}
+	if err == nil {
+		err = json.Unmarshal(ptr[1], &obj)
+	}
+	if err != nil {
+		logger().Error("sender/Receive: Invalid pointer to the payload", "body", body, "err", err)
+		return "", wrap("Receive", ErrInvalidInput, err)
+	}
+
+	svc := s3.New(awsSession)
+	out, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(obj.Bucket),
+		Key: aws.String(obj.Key),
+	})
+	if isRetryable(err) {
+		logger().Warn("sender/Receive: Temporarily failed to download the payload from S3", "err", err)
+		return "", wrap("Receive", ErrTemporary, err)
+	} else if err != nil {
+		logger().Error("sender/Receive: Failed to download the payload from S3", "bucket", obj.Bucket, "key", obj.Key, "err", err)
+		return "", wrap("Receive", ErrReceiveFailed, err)
+	}
+	defer out.Body.Close()
+
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		logger().Error("sender/Receive: Failed to read the payload from S3", "bucket", obj.Bucket, "key", obj.Key, "err", err)
+		return "", wrap("Receive", ErrTemporary, err)
+	}
+
+	logger().Debug("sender/Receive: Downloaded the payload from S3", "bytes", len(data), "bucket", obj.Bucket, "key", obj.Key)
+	return string(data), nil
+}
@@ -0,0 +1,173 @@
+package sender
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Receiver reads messages back from a queue (e.g., to replay the messages
+// in a dead-letter queue).
+type Receiver interface {
+	// Receive up to max messages, waiting up to wait for any message to
+	// arrive. Returns an empty list if no message arrived in time.
+	Receive(max int, wait time.Duration) ([]Received, error)
+
+	// Delete msg from the queue, so it isn't received again.
+	Delete(msg Received) error
+}
+
+// Received is a message read back from a queue. Once it's handled, it must
+// be deleted from the queue, otherwise it's received again once its
+// visibility timeout expires.
+type Received struct {
+	// The message's body and attributes. Payloads offloaded to S3 are
+	// downloaded back into the body.
+	Message
+
+	// Identifier assigned to the message by the queue.
+	MessageID string
+
+	// How many times the message was received, including this time.
+	ReceiveCount int
+
+	// Identifies this receipt of the message, for deleting it.
+	receiptHandle string
+}
+
+// MaxReceive is the most messages received from a SQS at once.
+const MaxReceive = 10
+
+// maxReceiveWait is the longest a SQS waits for messages to arrive.
+const maxReceiveWait = 20 * time.Second
+
+// sqsReceiver implements Receiver for a AWS SQS.
+type sqsReceiver struct {
+	// The AWS session for sending requests.
+	awsSession *session.Session
+
+	// The queue's URL.
+	queue string
+}
+
+// NewSQSReceiver creates a receiver for the messages in a SQS. Its
+// arguments are just like NewSQSSender's, so it may access the queue the
+// same way.
+//
+// If the receiver can't be created, a *ConfigError describing the problem
+// is returned.
+func NewSQSReceiver(endpoint, queue string, opts SQSOptions) (Receiver, error) {
+	if len(queue) == 0 {
+		return nil, &ConfigError{Op: "NewSQSReceiver", Msg: "No queue was specified"}
+	} else if _, err := url.Parse(queue); err != nil {
+		return nil, &ConfigError{Op: "NewSQSReceiver", Msg: "Invalid queue URL", Err: err}
+	}
+
+	region := opts.Region
+	if len(region) == 0 {
+		region = regionFromQueue(queue)
+	}
+	awsSession, err := newAWSSession("NewSQSReceiver", endpoint, region, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return sqsReceiver {
+		awsSession: awsSession,
+		queue: queue,
+	}, nil
+}
+
+func (r sqsReceiver) Receive(max int, wait time.Duration) ([]Received, error) {
+	svc := sqs.New(r.awsSession)
+
+	if max <= 0 || max > MaxReceive {
+		max = MaxReceive
+	}
+	if wait > maxReceiveWait {
+		wait = maxReceiveWait
+	}
+	input := &sqs.ReceiveMessageInput{
+		QueueUrl: aws.String(r.queue),
+		MaxNumberOfMessages: aws.Int64(int64(max)),
+		WaitTimeSeconds: aws.Int64(int64(wait / time.Second)),
+		MessageAttributeNames: []*string{
+			aws.String(sqs.QueueAttributeNameAll),
+		},
+		AttributeNames: []*string{
+			aws.String(sqs.MessageSystemAttributeNameApproximateReceiveCount),
+		},
+	}
+
+	out, err := svc.ReceiveMessage(input)
+	if isQueueMissing(err) {
+		logger().Error("sender/Receive: The queue doesn't exist", "queue", r.queue, "err", err)
+		return nil, wrap("Receive", ErrNotFound, err)
+	} else if isThrottled(err) {
+		logger().Warn("sender/Receive: Throttled while receiving messages", "queue", r.queue, "err", err)
+		return nil, wrap("Receive", ErrThrottled, err)
+	} else if isRetryable(err) {
+		logger().Warn("sender/Receive: Temporarily failed to receive messages", "queue", r.queue, "err", err)
+		return nil, wrap("Receive", ErrTemporary, err)
+	} else if err != nil {
+		logger().Error("sender/Receive: Failed to receive messages", "queue", r.queue, "err", err)
+		return nil, wrap("Receive", ErrReceiveFailed, err)
+	}
+
+	msgs := make([]Received, 0, len(out.Messages))
+	for _, m := range out.Messages {
+		msg := Received{
+			Message: Message{
+				Body: aws.StringValue(m.Body),
+			},
+			MessageID: aws.StringValue(m.MessageId),
+			receiptHandle: aws.StringValue(m.ReceiptHandle),
+		}
+		msg.ReceiveCount, _ = strconv.Atoi(aws.StringValue(m.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]))
+
+		// Only string (and number) attributes are sent by Send.
+		for name, value := range m.MessageAttributes {
+			if value.StringValue == nil {
+				continue
+			}
+			if msg.Attributes == nil {
+				msg.Attributes = make(map[string]string)
+			}
+			msg.Attributes[name] = aws.StringValue(value.StringValue)
+		}
+
+		if _, ok := msg.Attributes[extendedPayloadSizeAttr]; ok {
+			msg.Body, err = fetchPayload(r.awsSession, msg.Body)
+			if err != nil {
+				return nil, err
+			}
+			// The body is no longer a pointer to the payload.
+			delete(msg.Attributes, extendedPayloadSizeAttr)
+		}
+
+		msgs = append(msgs, msg)
+	}
+
+	return msgs, nil
+}
+
+func (r sqsReceiver) Delete(msg Received) error {
+	svc := sqs.New(r.awsSession)
+
+	_, err := svc.DeleteMessage(&sqs.DeleteMessageInput{
+		QueueUrl: aws.String(r.queue),
+		ReceiptHandle: aws.String(msg.receiptHandle),
+	})
+	if isRetryable(err) {
+		logger().Warn("sender/Delete: Temporarily failed to delete the message", "queue", r.queue, "id", msg.MessageID, "err", err)
+		return wrap("Delete", ErrTemporary, err)
+	} else if err != nil {
+		logger().Error("sender/Delete: Failed to delete the message", "queue", r.queue, "id", msg.MessageID, "err", err)
+		return wrap("Delete", ErrReceiveFailed, err)
+	}
+
+	return nil
+}
@@ -11,31 +11,144 @@ then call "Send()" for each message. To send messages to a localstack
 service, be sure to specify it's URL in the endpoint, as it will otherwise
 fail!
 
+Transient failures (e.g., 5xx responses) are reported as ErrTemporary, and
+throttling is reported as ErrThrottled. Both may be retried by wrapping the
+sender with "sendermw.WithRetry()", and "sendermw.WithAdaptiveThrottle()"
+slows down sending while the receiver throttles messages. Messages rejected
+by the SQS itself (e.g., because of invalid contents) are reported as
+ErrRejected, so the caller may discard them.
+
+Failures with an underlying cause (e.g., an error returned by AWS) are
+reported as an *Error, so they should be checked through "errors.Is()"
+(e.g., errors.Is(err, sender.ErrTemporary)), which also reaches the cause.
+
+To avoid hammering an unreachable receiver, any Sender may be wrapped in a
+CircuitBreaker, which fails fast with ErrCircuitOpen for a while after too
+many consecutive failures. Other behaviours (e.g., rate limiting and
+metrics) may be layered onto any Sender through the decorators in package
+sendermw.
+
+Messages may also be delivered to a downstream service implementing the
+notifierpb.Collector gRPC service, through a sender created by
+"NewGRPCSender()".
+
+Messages may be sent to many receivers at once (e.g., queues in distinct
+regions) through a Fanout, which only succeeds once every destination
+accepts the message. Messages may also be routed to only some of the
+destinations, by naming them in the message's Destinations.
+
+For staging environments (or for testing), a sender created through
+"NewDryRunSender()" logs every message instead of delivering it.
+
+Messages meant to be read by people (e.g., alerts) may be posted to a Slack
+incoming webhook, through "NewSlackSender()", or emailed, through
+"NewEmailSender()". Messages may also be filed as issues in a GitHub
+repository, through "NewGitHubSender()", which doesn't file a message whose
+title matches an open issue.
+
+When running on Kubernetes (e.g., EKS with IAM roles for service accounts),
+the sender authenticates with the projected web identity token, so no
+long-lived access keys are needed. See WebIdentity.
+
+The SQS sender also implements Checker, which may be used to verify that
+the queue exists on start up (optionally creating it, which is useful for
+localstack).
+
+Messages larger than the SQS limit may be offloaded to a S3 bucket by
+setting LargePayloadBucket in SQSOptions. In this case, the message sent to
+the queue is a pointer to the S3 object, compatible with the Amazon SQS
+Extended Client.
+
+Messages may be read back from a SQS (e.g., to replay the messages in its
+dead-letter queue) through a Receiver created by "NewSQSReceiver()".
+Offloaded payloads are downloaded back from S3 when received.
+
 Example (localstack):
 
 	// Create a sender for "http://localhost:4566/000000000000/test-queue"
-	s := sender.NewSQSSender("http://localhost:4566",
-			"http://localhost:4566/000000000000/test-queue")
+	s, err := sender.NewSQSSender("http://localhost:4566",
+			"http://localhost:4566/000000000000/test-queue",
+			sender.SQSOptions{})
+	if err != nil {
+		// handle err
+	}
 
 	// Send a simple message
-	err := s.Send("hello")
+	res, err := s.Send(sender.Message{Body: "hello"})
 	if err != nil {
 		// handle err
 	}
+	log.Printf("Sent as %s", res.MessageID)
 */
 package sender
 
 import (
+	"github.com/SirGFM/sqs-issue-notifier/server/loglevel"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
-	"log"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
 )
 
+// logger retrieves the logger for the senders' entries, identified as the
+// "sender" component.
+func logger() *slog.Logger {
+	return slog.Default().With(loglevel.ComponentKey, "sender")
+}
+
 // Sender interface for sending messages to a receiver.
 type Sender interface {
-	// Send the given msg.
-	Send(msg string) error
+	// Send the given msg, returning how the receiver identified it.
+	Send(msg Message) (SendResult, error)
+}
+
+// Message is a single message to be sent.
+type Message struct {
+	// The message's contents.
+	Body string
+
+	// For how long the message should be hidden from consumers after it's
+	// sent. SQS accepts up to 15 minutes, with a granularity of seconds,
+	// and doesn't support per-message delays on FIFO queues.
+	Delay time.Duration
+
+	// Metadata sent alongside the body (e.g., its signature). SQS senders
+	// send them as string message attributes, so at most 10 may be set.
+	Attributes map[string]string
+
+	// Names of the destinations that should receive the message, for
+	// senders with many destinations (i.e., Fanout). If empty, the message
+	// is sent to every destination.
+	Destinations []string
+}
+
+// MaxDelay is the longest delay accepted by a SQS.
+const MaxDelay = 15 * time.Minute
+
+// SendResult describes a message accepted by a receiver.
+type SendResult struct {
+	// Identifier assigned to the message by the receiver.
+	MessageID string
+
+	// Sequence number assigned to the message. Only set by FIFO queues.
+	SequenceNumber string
+
+	// When the message was accepted.
+	SentAt time.Time
+
+	// How long sending the message took, including retries.
+	Duration time.Duration
+
+	// How many attempts were needed to send the message.
+	Attempts int
 }
 
 // sqsSender implements Sender for a AWS SQS.
@@ -45,47 +158,321 @@ type sqsSender struct {
 
 	// The queue's URL for sending messages (without the URL).
 	queue string
+
+	// Whether Check should create the queue if it doesn't exist.
+	createMissing bool
+
+	// S3 bucket where large payloads are offloaded. Empty if disabled.
+	largePayloadBucket string
+
+	// Size, in bytes, above which payloads are offloaded.
+	largePayloadThreshold int
 }
 
-func (s sqsSender) Send(msg string) error {
+// AssumeRole configures an IAM role assumed by the sender. The role's
+// temporary credentials are automatically refreshed before they expire.
+type AssumeRole struct {
+	// The ARN of the role. Leave it empty to use the base credentials
+	// directly.
+	RoleARN string
+
+	// External ID required by the role's trust policy, if any.
+	ExternalID string
+
+	// Name that identifies the session in CloudTrail. Defaults to the
+	// AWS SDK's generated name.
+	SessionName string
+
+	// For how long the assumed credentials are valid. Defaults to the
+	// AWS SDK's default (15 minutes).
+	Duration time.Duration
+}
+
+// WebIdentity configures credentials obtained by exchanging a web identity
+// token (e.g., an EKS service account token, a.k.a. IRSA) for a role's
+// temporary credentials. The token file is re-read, and the credentials
+// refreshed, before they expire.
+type WebIdentity struct {
+	// Path to the file with the web identity token. If empty, it's read
+	// from the environment variable AWS_WEB_IDENTITY_TOKEN_FILE.
+	TokenFile string
+
+	// The ARN of the role. If empty, it's read from the environment
+	// variable AWS_ROLE_ARN.
+	RoleARN string
+
+	// Name that identifies the session in CloudTrail. If empty, it's read
+	// from the environment variable AWS_ROLE_SESSION_NAME.
+	SessionName string
+}
+
+// resolve fills the fields missing from w with the environment variables
+// used by the AWS SDK.
+func (w WebIdentity) resolve() WebIdentity {
+	if len(w.TokenFile) == 0 {
+		w.TokenFile = os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	}
+	if len(w.RoleARN) == 0 {
+		w.RoleARN = os.Getenv("AWS_ROLE_ARN")
+	}
+	if len(w.SessionName) == 0 {
+		w.SessionName = os.Getenv("AWS_ROLE_SESSION_NAME")
+	}
+	return w
+}
+
+// SQSOptions configures optional behaviour of a SQS sender. Each sender has
+// its own options, so senders for queues in distinct regions (or accounts)
+// may coexist.
+type SQSOptions struct {
+	// The AWS region of the queue. If empty, it's inferred from the queue's
+	// URL, falling back to the environment and the shared configuration.
+	Region string
+
+	// Named profile, from the shared configuration, used to load the
+	// credentials and the region. Defaults to the environment's profile.
+	Profile string
+
+	// Timeout for each HTTP request made to AWS. Defaults to no timeout.
+	HTTPTimeout time.Duration
+
+	// Base credentials, instead of the ones from the environment (or from
+	// the shared configuration), e.g., the ones read from Vault. Takes
+	// precedence over WebIdentity.
+	Credentials *credentials.Credentials
+
+	// Web identity used for the base credentials, instead of the ones from
+	// the environment (or from the shared configuration). The token file
+	// and the role must both be set, either here or in the environment, to
+	// be used.
+	WebIdentity WebIdentity
+
+	// Role assumed for sending messages, using the credentials from the
+	// environment (or from the shared configuration) as the base ones.
+	AssumeRole AssumeRole
+
+	// Create the queue, on Check(), if it doesn't exist. Mostly useful
+	// for development environments (e.g., localstack).
+	CreateQueue bool
+
+	// S3 bucket where payloads too large for the queue are uploaded. The
+	// message sent to the queue then carries a pointer to the payload,
+	// in the format used by the Amazon SQS Extended Client. Leave empty
+	// to send every payload directly to the queue.
+	LargePayloadBucket string
+
+	// Size, in bytes, above which payloads are offloaded to S3, counting
+	// the message's attributes (see AttributesSize). Defaults to (and is
+	// capped at) MaxSQSMessageSize.
+	LargePayloadThreshold int
+}
+
+// assumedRoleExpiryWindow specifies how long before expiring the assumed
+// credentials are refreshed.
+const assumedRoleExpiryWindow = time.Minute
+
+func (s sqsSender) Send(msg Message) (SendResult, error) {
+	var res SendResult
+	start := time.Now()
+
 	svc := sqs.New(s.awsSession)
 
 	input := &sqs.SendMessageInput{
-		MessageBody: aws.String(msg),
+		MessageBody: aws.String(msg.Body),
 		QueueUrl: aws.String(s.queue),
 	}
+	for name, value := range msg.Attributes {
+		if input.MessageAttributes == nil {
+			input.MessageAttributes = make(map[string]*sqs.MessageAttributeValue)
+		}
+		input.MessageAttributes[name] = &sqs.MessageAttributeValue{
+			DataType: aws.String("String"),
+			StringValue: aws.String(value),
+		}
+	}
+	// The attributes count towards the size of the message.
+	if len(s.largePayloadBucket) > 0 && len(msg.Body) + AttributesSize(msg.Attributes) > s.largePayloadThreshold {
+		err := s.offloadPayload(input)
+		if err != nil {
+			return res, err
+		}
+	}
+	if msg.Delay > 0 && strings.HasSuffix(s.queue, ".fifo") {
+		// Otherwise, the queue would reject the message.
+		logger().Debug("sender/Send: Ignoring the delay for a FIFO queue", "queue", s.queue)
+	} else if msg.Delay > 0 {
+		input.DelaySeconds = aws.Int64(int64(msg.Delay / time.Second))
+	}
 	if err := input.Validate(); err != nil {
-		log.Printf("sender/Send: Invalid input: %+v\n", err)
-		return ErrInvalidInput
+		logger().Warn("sender/Send: Invalid input", "err", err)
+		return res, wrap("Send", ErrInvalidInput, err)
 	}
 
-	_, err := svc.SendMessage(input)
+	res.Attempts = 1
+	out, err := svc.SendMessage(input)
+	if isRejected(err) {
+		logger().Warn("sender/Send: The message was rejected", "body", msg.Body, "err", err)
+		return res, wrap("Send", ErrRejected, err)
+	} else if isThrottled(err) {
+		logger().Warn("sender/Send: Throttled while sending the message", "body", msg.Body, "err", err)
+		return res, wrap("Send", ErrThrottled, err)
+	} else if isRetryable(err) {
+		logger().Warn("sender/Send: Temporarily failed to send the message", "body", msg.Body, "err", err)
+		return res, wrap("Send", ErrTemporary, err)
+	} else if err != nil {
+		logger().Error("sender/Send: Failed to send the message", "body", msg.Body, "err", err)
+		return res, wrap("Send", ErrSendFailed, err)
+	}
+
+	res.MessageID = aws.StringValue(out.MessageId)
+	res.SequenceNumber = aws.StringValue(out.SequenceNumber)
+	res.SentAt = time.Now()
+	res.Duration = res.SentAt.Sub(start)
+	return res, nil
+}
+
+// regionFromQueue extracts the region from an AWS queue URL (i.e.,
+// "https://sqs.<region>.amazonaws.com/<account>/<queue>"). It returns the
+// empty string for any other URL (e.g., localstack's).
+func regionFromQueue(queue string) string {
+	u, err := url.Parse(queue)
 	if err != nil {
-		log.Printf("sender/Send: Failed to send the message '%s': %+v\n", msg, err)
-		return ErrSendFailed
+		return ""
 	}
 
-	return nil
+	host := strings.Split(u.Hostname(), ".")
+	if len(host) < 4 || host[0] != "sqs" || host[2] != "amazonaws" {
+		return ""
+	}
+	return host[1]
 }
 
-// Create a new sender ready to send requests to a SQS service. To simplify
-// simulating a AWS on localstack, endpoint may be supplied to define a
-// custom SQS handler. Passing endpoint as the empty string will default to
-// using the actual AWS. The queue URI must be specified as its full path,
-// regardless of whether or not an endpoint was specified.
-func NewSQSSender(endpoint, queue string) Sender {
+// newAWSSession creates the AWS session used by op, configured as described
+// by opts. If region is empty, it's loaded from the environment (or from
+// the shared configuration).
+//
+// If the session can't be created, a *ConfigError describing the problem
+// is returned.
+func newAWSSession(op, endpoint, region string, opts SQSOptions) (*session.Session, error) {
 	config := aws.Config{}
 	if len(endpoint) > 0 {
 		config.Endpoint = aws.String(endpoint)
 	}
+	if len(region) > 0 {
+		config.Region = aws.String(region)
+	}
+	if len(endpoint) > 0 {
+		// Custom endpoints (i.e., localstack) don't resolve buckets as
+		// sub-domains. Besides offloading payloads, buckets are also
+		// accessed to receive offloaded payloads back.
+		config.S3ForcePathStyle = aws.Bool(true)
+	}
+	if opts.HTTPTimeout > 0 {
+		config.HTTPClient = &http.Client{
+			Timeout: opts.HTTPTimeout,
+		}
+	}
+	// Retrying is left to sendermw.WithRetry, so the SDK's own retrying
+	// doesn't multiply the number of attempts.
+	config.MaxRetries = aws.Int(0)
 
-	awsSession := session.Must(session.NewSessionWithOptions(session.Options{
+	awsSession, err := session.NewSessionWithOptions(session.Options{
 		SharedConfigState: session.SharedConfigEnable,
+		Profile: opts.Profile,
 		Config: config,
-	}))
+	})
+	if err != nil {
+		return nil, &ConfigError{Op: op, Msg: "Failed to configure the AWS session", Err: err}
+	}
 
-	return sqsSender {
+	if opts.Credentials != nil {
+		config.Credentials = opts.Credentials
+
+		awsSession = awsSession.Copy(&config)
+	} else if wi := opts.WebIdentity.resolve(); len(wi.TokenFile) > 0 && len(wi.RoleARN) > 0 {
+		if _, err := os.Stat(wi.TokenFile); err != nil {
+			return nil, &ConfigError{Op: op, Msg: "Can't access the web identity token file", Err: err}
+		}
+
+		provider := stscreds.NewWebIdentityRoleProviderWithOptions(sts.New(awsSession),
+			wi.RoleARN,
+			wi.SessionName,
+			stscreds.FetchTokenPath(wi.TokenFile),
+			func(p *stscreds.WebIdentityRoleProvider) {
+				p.ExpiryWindow = assumedRoleExpiryWindow
+			})
+		config.Credentials = credentials.NewCredentials(provider)
+
+		awsSession = awsSession.Copy(&config)
+	}
+
+	if role := opts.AssumeRole; len(role.RoleARN) > 0 {
+		config.Credentials = stscreds.NewCredentials(awsSession, role.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+			if len(role.ExternalID) > 0 {
+				p.ExternalID = aws.String(role.ExternalID)
+			}
+			if len(role.SessionName) > 0 {
+				p.RoleSessionName = role.SessionName
+			}
+			if role.Duration > 0 {
+				p.Duration = role.Duration
+			}
+			p.ExpiryWindow = assumedRoleExpiryWindow
+		})
+
+		awsSession = awsSession.Copy(&config)
+	}
+
+	return awsSession, nil
+}
+
+// NewAWSSession creates an AWS session configured as done by NewSQSSender
+// (i.e., with the same region, credentials and endpoint), so other AWS
+// services (e.g., for fetching secrets) may be accessed just like the queue.
+//
+// If the session can't be created, a *ConfigError describing the problem
+// is returned.
+func NewAWSSession(endpoint string, opts SQSOptions) (*session.Session, error) {
+	return newAWSSession("NewAWSSession", endpoint, opts.Region, opts)
+}
+
+// Create a new sender ready to send requests to a SQS service. To simplify
+// simulating a AWS on localstack, endpoint may be supplied to define a
+// custom SQS handler. Passing endpoint as the empty string will default to
+// using the actual AWS. The queue URI must be specified as its full path,
+// regardless of whether or not an endpoint was specified. If a web identity
+// is configured in opts (or in the environment), it's exchanged for the base
+// credentials. If a role is configured in opts, it's assumed through STS
+// before sending any message.
+//
+// If the sender can't be created, a *ConfigError describing the problem is
+// returned.
+func NewSQSSender(endpoint, queue string, opts SQSOptions) (Sender, error) {
+	if len(queue) == 0 {
+		return nil, &ConfigError{Op: "NewSQSSender", Msg: "No queue was specified"}
+	} else if _, err := url.Parse(queue); err != nil {
+		return nil, &ConfigError{Op: "NewSQSSender", Msg: "Invalid queue URL", Err: err}
+	}
+
+	region := opts.Region
+	if len(region) == 0 {
+		region = regionFromQueue(queue)
+	}
+	awsSession, err := newAWSSession("NewSQSSender", endpoint, region, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := sqsSender {
 		awsSession: awsSession,
 		queue: queue,
+		createMissing: opts.CreateQueue,
+		largePayloadBucket: opts.LargePayloadBucket,
+		largePayloadThreshold: opts.LargePayloadThreshold,
+	}
+	if threshold := ret.largePayloadThreshold; threshold <= 0 || threshold > MaxSQSMessageSize {
+		ret.largePayloadThreshold = MaxSQSMessageSize
 	}
+
+	return ret, nil
 }
@@ -1,9 +1,26 @@
 package sender
 
 import (
+	"context"
+	"crypto/md5"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/SirGFM/sqs-issue-notifier/server/notifierpb"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 )
 
 // TestSQSSend tests sending a simple message to a queue using the
@@ -16,10 +33,15 @@ func TestSQSSend(t *testing.T) {
 		t.Fatal("No queue was specified! Set the queue's address in the environment variable SQS_QUEUE. Optionally, set the endpoint in SQS_ENDPOINT.")
 	}
 
-	s := NewSQSSender(endpoint, queue)
-	err := s.Send("this is a test")
+	s, err := NewSQSSender(endpoint, queue, SQSOptions{})
+	if err != nil {
+		t.Fatalf("NewSQSSender: Failed to create the sender: %+v", err)
+	}
+	res, err := s.Send(Message{Body: "this is a test"})
 	if err != nil {
 		t.Errorf("Send: Failed to send a test message: %+v", err)
+	} else if len(res.MessageID) == 0 {
+		t.Errorf("Send: The queue didn't assign an ID to the test message")
 	}
 
 	dummy_struct := struct {
@@ -34,8 +56,682 @@ func TestSQSSend(t *testing.T) {
 		t.Fatalf("Failed to encode the struct as a JSON: %+v", err)
 	}
 
-	err = s.Send(string(data))
+	_, err = s.Send(Message{Body: string(data)})
 	if err != nil {
 		t.Errorf("Send: Failed to send a test struct: %+v", err)
 	}
 }
+
+// failSender is a Sender that fails while its err is set.
+type failSender struct {
+	err error
+	sent int
+}
+
+func (f *failSender) Send(msg Message) (SendResult, error) {
+	f.sent++
+	return SendResult{}, f.err
+}
+
+// send msg through s, discarding its result.
+func send(s Sender, msg string) error {
+	_, err := s.Send(Message{Body: msg})
+	return err
+}
+
+// TestCircuitBreaker checks that the breaker opens after consecutive
+// failures, fails fast while open and closes once the receiver recovers.
+func TestCircuitBreaker(t *testing.T) {
+	fs := &failSender{err: ErrSendFailed}
+	cooldown := 10 * time.Millisecond
+	cb := NewCircuitBreaker(fs, 2, cooldown)
+
+	// Rejected messages must not open the breaker.
+	fs.err = ErrRejected
+	for i := 0; i < 3; i++ {
+		send(cb, "rejected")
+	}
+	if want, got := BreakerClosed, cb.State(); want != got {
+		t.Errorf("State: Expected '%s' but got '%s'", want, got)
+	}
+
+	fs.err = ErrSendFailed
+	for i := 0; i < 2; i++ {
+		if want, got := ErrSendFailed, send(cb, "fail"); want != got {
+			t.Errorf("%d: Send: Expected error '%+v' but got '%+v'", i, want, got)
+		}
+	}
+	if want, got := BreakerOpen, cb.State(); want != got {
+		t.Errorf("State: Expected '%s' but got '%s'", want, got)
+	}
+
+	sent := fs.sent
+	if want, got := ErrCircuitOpen, send(cb, "fast fail"); want != got {
+		t.Errorf("Send: Expected error '%+v' but got '%+v'", want, got)
+	} else if sent != fs.sent {
+		t.Errorf("Send: The wrapped sender was called while the breaker was open")
+	}
+
+	// A failed probe must re-open the breaker, and a successful one must
+	// close it.
+	time.Sleep(cooldown)
+	if want, got := ErrSendFailed, send(cb, "probe"); want != got {
+		t.Errorf("Send: Expected error '%+v' but got '%+v'", want, got)
+	}
+	if want, got := BreakerOpen, cb.State(); want != got {
+		t.Errorf("State: Expected '%s' but got '%s'", want, got)
+	}
+
+	time.Sleep(cooldown)
+	fs.err = nil
+	if err := send(cb, "probe"); err != nil {
+		t.Errorf("Send: Failed to send the probe: %+v", err)
+	}
+	if want, got := BreakerClosed, cb.State(); want != got {
+		t.Errorf("State: Expected '%s' but got '%s'", want, got)
+	}
+}
+
+// TestCircuitBreakerProbe checks that probing an open breaker expires its
+// cool-down right away.
+func TestCircuitBreakerProbe(t *testing.T) {
+	fs := &failSender{err: ErrSendFailed}
+	cb := NewCircuitBreaker(fs, 1, time.Hour)
+
+	send(cb, "fail")
+	if want, got := BreakerOpen, cb.State(); want != got {
+		t.Errorf("State: Expected '%s' but got '%s'", want, got)
+	}
+
+	cb.Probe()
+	if want, got := BreakerHalfOpen, cb.State(); want != got {
+		t.Errorf("Probe: Expected '%s' but got '%s'", want, got)
+	}
+
+	fs.err = nil
+	if err := send(cb, "probe"); err != nil {
+		t.Errorf("Send: Failed to send the probe: %+v", err)
+	}
+	if want, got := BreakerClosed, cb.State(); want != got {
+		t.Errorf("State: Expected '%s' but got '%s'", want, got)
+	}
+
+	// Probing a closed breaker must not change it.
+	cb.Probe()
+	if want, got := BreakerClosed, cb.State(); want != got {
+		t.Errorf("Probe: Expected '%s' but got '%s'", want, got)
+	}
+}
+
+// TestCircuitBreakerSetCooldown checks that changing the cool-down period
+// applies to the one in progress.
+func TestCircuitBreakerSetCooldown(t *testing.T) {
+	fs := &failSender{err: ErrSendFailed}
+	cb := NewCircuitBreaker(fs, 1, time.Hour)
+
+	send(cb, "fail")
+	if got := cb.RetryIn(); got <= 59 * time.Minute {
+		t.Errorf("RetryIn: Expected about 1h but got %s", got)
+	}
+
+	cb.SetCooldown(time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	if want, got := BreakerHalfOpen, cb.State(); want != got {
+		t.Errorf("State: Expected '%s' but got '%s'", want, got)
+	}
+	if got := cb.RetryIn(); got != 0 {
+		t.Errorf("RetryIn: Expected 0 but got %s", got)
+	}
+}
+
+// TestRegionFromQueue checks that the region is only extracted from AWS
+// queue URLs.
+func TestRegionFromQueue(t *testing.T) {
+	test_cases := []struct{ queue string; region string } {
+		{ queue: "https://sqs.us-east-2.amazonaws.com/000000000000/queue", region: "us-east-2" },
+		{ queue: "https://sqs.cn-north-1.amazonaws.com.cn/000000000000/queue", region: "cn-north-1" },
+		{ queue: "http://localhost:4566/000000000000/queue", region: "" },
+		{ queue: "not a url", region: "" },
+	}
+
+	for i, tc := range test_cases {
+		if want, got := tc.region, regionFromQueue(tc.queue); want != got {
+			t.Errorf("%d: regionFromQueue: Expected '%s' but got '%s'", i, want, got)
+		}
+	}
+}
+
+// TestDryRun checks that the dry-run sender writes every message to its
+// file.
+func TestDryRun(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "dry-run*")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "messages.jsonl")
+	s, err := NewDryRunSender(path)
+	if err != nil {
+		t.Fatalf("NewDryRunSender: Failed to create the sender: %+v", err)
+	}
+
+	test_cases := []Message{
+		{ Body: "first" },
+		{ Body: "second", Delay: 10 * time.Second },
+	}
+	for i, msg := range test_cases {
+		res, err := s.Send(msg)
+		if err != nil {
+			t.Errorf("%d: Send: Failed to send '%s': %+v", i, msg.Body, err)
+		} else if len(res.MessageID) == 0 {
+			t.Errorf("%d: Send: No ID was assigned to '%s'", i, msg.Body)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open the dry-run file: %+v", err)
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	for i, msg := range test_cases {
+		var entry dryRunEntry
+		err := dec.Decode(&entry)
+		if err != nil {
+			t.Errorf("%d: Failed to decode the entry: %+v", i, err)
+		} else if entry.Body != msg.Body || entry.DelaySeconds != int64(msg.Delay / time.Second) {
+			t.Errorf("%d: Expected '%+v' but got '%+v'", i, msg, entry)
+		}
+	}
+}
+
+// TestNewPayloadKey checks that offloaded payloads get valid, unique keys.
+func TestNewPayloadKey(t *testing.T) {
+	re := regexp.MustCompile("^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$")
+
+	keys := make(map[string]struct{})
+	for i := 0; i < 16; i++ {
+		key, err := newPayloadKey()
+		if err != nil {
+			t.Fatalf("%d: newPayloadKey: Failed to generate a key: %+v", i, err)
+		} else if !re.MatchString(key) {
+			t.Errorf("%d: newPayloadKey: Invalid key '%s'", i, key)
+		} else if _, ok := keys[key]; ok {
+			t.Errorf("%d: newPayloadKey: Duplicated key '%s'", i, key)
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+// collector is a notifierpb.CollectorServer that rejects messages whose
+// body is "reject".
+type collector struct {
+	notifierpb.UnimplementedCollectorServer
+	received []*notifierpb.CollectRequest
+}
+
+func (c *collector) Collect(ctx context.Context, req *notifierpb.CollectRequest) (*notifierpb.CollectResponse, error) {
+	if req.GetBody() == "reject" {
+		return nil, status.Error(codes.InvalidArgument, "rejected")
+	}
+
+	c.received = append(c.received, req)
+	return &notifierpb.CollectResponse{MessageId: fmt.Sprintf("id-%d", len(c.received))}, nil
+}
+
+// TestGRPCSend tests sending messages to a collector running locally.
+func TestGRPCSend(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %+v", err)
+	}
+
+	var c collector
+	srv := grpc.NewServer()
+	notifierpb.RegisterCollectorServer(srv, &c)
+	go srv.Serve(l)
+	defer srv.Stop()
+
+	s, err := NewGRPCSender(l.Addr().String(), GRPCOptions{Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("NewGRPCSender: Failed to create the sender: %+v", err)
+	}
+
+	res, err := s.Send(Message{Body: "collected", Delay: 2 * time.Second})
+	if err != nil {
+		t.Errorf("Send: Failed to send a test message: %+v", err)
+	} else if want, got := "id-1", res.MessageID; want != got {
+		t.Errorf("Send: Expected ID '%s' but got '%s'", want, got)
+	} else if len(c.received) != 1 || c.received[0].GetDelaySeconds() != 2 {
+		t.Errorf("Send: The collector received '%+v'", c.received)
+	}
+
+	_, err = s.Send(Message{Body: "reject"})
+	if want, got := ErrRejected, err; !errors.Is(got, want) {
+		t.Errorf("Send: Expected error '%+v' but got '%+v'", want, got)
+	} else if want, got := codes.InvalidArgument, status.Code(errors.Unwrap(err)); want != got {
+		t.Errorf("Send: Expected the error to wrap the code '%s' but got '%s'", want, got)
+	}
+
+	srv.Stop()
+	_, err = s.Send(Message{Body: "unavailable"})
+	if want, got := ErrTemporary, err; !errors.Is(got, want) {
+		t.Errorf("Send: Expected error '%+v' but got '%+v'", want, got)
+	}
+}
+
+// TestConfigError checks that invalid configurations are reported as
+// errors wrapping their cause.
+func TestConfigError(t *testing.T) {
+	_, err := NewSQSSender("", "", SQSOptions{})
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("NewSQSSender: Expected error '%+v' but got '%+v'", ErrInvalidConfig, err)
+	}
+
+	_, err = NewSQSSender("", "http://localhost:4566/000000000000/test-queue", SQSOptions{
+		WebIdentity: WebIdentity{
+			TokenFile: filepath.Join(os.TempDir(), "missing-dir", "missing-token"),
+			RoleARN: "arn:aws:iam::000000000000:role/test-role",
+		},
+	})
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("NewSQSSender: Expected error '%+v' but got '%+v'", ErrInvalidConfig, err)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("NewSQSSender: Expected the error to wrap '%+v' but got '%+v'", fs.ErrNotExist, err)
+	}
+
+	_, err = NewDryRunSender(filepath.Join(os.TempDir(), "missing-dir", "missing-file"))
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("NewDryRunSender: Expected error '%+v' but got '%+v'", ErrInvalidConfig, err)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("NewDryRunSender: Expected the error to wrap '%+v' but got '%+v'", fs.ErrNotExist, err)
+	}
+
+	_, err = NewGRPCSender("localhost:1", GRPCOptions{TLS: true, CAFile: "/missing-ca.pem"})
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("NewGRPCSender: Expected error '%+v' but got '%+v'", ErrInvalidConfig, err)
+	}
+}
+
+// TestWebIdentityResolve checks that the web identity falls back to the
+// environment, without overriding explicit settings.
+func TestWebIdentityResolve(t *testing.T) {
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", "/env/token")
+	t.Setenv("AWS_ROLE_ARN", "arn:aws:iam::000000000000:role/env-role")
+	t.Setenv("AWS_ROLE_SESSION_NAME", "env-session")
+
+	test_cases := []struct{ in, out WebIdentity } {
+		{
+			out: WebIdentity{
+				TokenFile: "/env/token",
+				RoleARN: "arn:aws:iam::000000000000:role/env-role",
+				SessionName: "env-session",
+			},
+		},
+		{
+			in: WebIdentity{
+				TokenFile: "/var/run/secrets/token",
+				RoleARN: "arn:aws:iam::000000000000:role/role",
+			},
+			out: WebIdentity{
+				TokenFile: "/var/run/secrets/token",
+				RoleARN: "arn:aws:iam::000000000000:role/role",
+				SessionName: "env-session",
+			},
+		},
+	}
+	for i, tc := range test_cases {
+		if got := tc.in.resolve(); got != tc.out {
+			t.Errorf("%d: Expected '%+v' but got '%+v'", i, tc.out, got)
+		}
+	}
+}
+
+// TestEnvelope checks that bodies encrypted with a data key may only be
+// decrypted with the same key.
+func TestEnvelope(t *testing.T) {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	plaintext := []byte(`{"Channel":"general","Message":"secret"}`)
+
+	body, err := sealEnvelope(key, plaintext)
+	if err != nil {
+		t.Fatalf("sealEnvelope: Failed to encrypt: %+v", err)
+	} else if strings.Contains(string(body), "secret") {
+		t.Errorf("sealEnvelope: The body wasn't encrypted: %s", body)
+	}
+
+	got, err := openEnvelope(key, body)
+	if err != nil {
+		t.Errorf("openEnvelope: Failed to decrypt: %+v", err)
+	} else if want := string(plaintext); want != string(got) {
+		t.Errorf("openEnvelope: Expected '%s' but got '%s'", want, got)
+	}
+
+	key[0]++
+	if _, err := openEnvelope(key, body); err == nil {
+		t.Errorf("openEnvelope: Decrypted the body with the wrong key")
+	}
+}
+
+// TestFanout checks that messages are sent to every destination, and that
+// the message is only considered rejected if every failure is permanent.
+func TestFanout(t *testing.T) {
+	a, b := &failSender{}, &failSender{}
+	f := NewFanout(Destination{Name: "a", Sender: a}, Destination{Name: "b", Sender: b})
+
+	if err := send(f, "both"); err != nil {
+		t.Errorf("Send: Failed to send the message: %+v", err)
+	} else if a.sent != 1 || b.sent != 1 {
+		t.Errorf("Send: Expected the message on every destination but got %d and %d", a.sent, b.sent)
+	}
+
+	test_cases := []struct{ a, b, want error } {
+		{ a: ErrRejected, b: nil, want: ErrRejected },
+		{ a: ErrRejected, b: ErrTemporary, want: ErrTemporary },
+		{ a: ErrTemporary, b: ErrRejected, want: ErrTemporary },
+		{ a: ErrRejected, b: ErrInvalidInput, want: ErrRejected },
+	}
+	for i, tc := range test_cases {
+		a.err, b.err = tc.a, tc.b
+		if got := send(f, "fail"); tc.want != got {
+			t.Errorf("%d: Send: Expected error '%+v' but got '%+v'", i, tc.want, got)
+		}
+	}
+}
+
+// TestFanoutRoute checks that messages naming their destinations are only
+// sent to those.
+func TestFanoutRoute(t *testing.T) {
+	a, b := &failSender{}, &failSender{}
+	f := NewFanout(Destination{Name: "a", Sender: a}, Destination{Name: "b", Sender: b})
+
+	_, err := f.Send(Message{Body: "b only", Destinations: []string{"b"}})
+	if err != nil {
+		t.Errorf("Send: Failed to send the message: %+v", err)
+	} else if a.sent != 0 || b.sent != 1 {
+		t.Errorf("Send: Expected the message only on 'b' but got %d and %d", a.sent, b.sent)
+	}
+
+	_, err = f.Send(Message{Body: "unknown", Destinations: []string{"b", "c"}})
+	if want, got := ErrInvalidInput, err; want != got {
+		t.Errorf("Send: Expected error '%+v' but got '%+v'", want, got)
+	} else if b.sent != 1 {
+		t.Errorf("Send: Sent a message with an unknown destination")
+	}
+}
+
+// TestSlackSend checks that messages are posted to the webhook, and that
+// its failures are classified.
+func TestSlackSend(t *testing.T) {
+	var got slackMessage
+	code := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		json.NewDecoder(req.Body).Decode(&got)
+		w.WriteHeader(code)
+	}))
+	defer srv.Close()
+
+	s, err := NewSlackSender(srv.URL, time.Second)
+	if err != nil {
+		t.Fatalf("NewSlackSender: Failed to create the sender: %+v", err)
+	}
+
+	_, err = s.Send(Message{Body: "*The store is full*"})
+	if err != nil {
+		t.Errorf("Send: Failed to send the message: %+v", err)
+	} else if got.Text != "*The store is full*" {
+		t.Errorf("Send: Expected '*The store is full*' but got '%s'", got.Text)
+	}
+
+	test_cases := []struct{
+		code int
+		want error
+	}{
+		{ code: http.StatusBadRequest, want: ErrRejected },
+		{ code: http.StatusNotFound, want: ErrNotFound },
+		{ code: http.StatusTooManyRequests, want: ErrThrottled },
+		{ code: http.StatusBadGateway, want: ErrTemporary },
+	}
+	for i, tc := range test_cases {
+		code = tc.code
+		if _, err := s.Send(Message{Body: "fail"}); !errors.Is(err, tc.want) {
+			t.Errorf("%d: Send: Expected error '%+v' but got '%+v'", i, tc.want, err)
+		}
+	}
+
+	if _, err := NewSlackSender("hooks.slack.com/services/x", time.Second); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("NewSlackSender: Expected error '%+v' but got '%+v'", ErrInvalidConfig, err)
+	}
+}
+
+// fakeSQS imitates the SQS actions used by the receiver, and the S3 bucket
+// "payloads" with the object "big" (an offloaded payload).
+type fakeSQS struct {
+	// The receipt handles of the deleted messages.
+	deleted []string
+}
+
+func (f *fakeSQS) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodGet && req.URL.Path == "/payloads/big" {
+		w.Write([]byte("The vorpal blade went snicker-snack!"))
+		return
+	}
+
+	req.ParseForm()
+	w.Header().Set("Content-Type", "text/xml")
+	switch req.Form.Get("Action") {
+	case "ReceiveMessage":
+		// The SDK checks the MD5 of the bodies.
+		plain := `{"Channel":"general","Message":"One, two!"}`
+		ptr := `["software.amazon.payloadoffloading.PayloadS3Pointer",{"s3BucketName":"payloads","s3Key":"big"}]`
+		fmt.Fprintf(w, `<ReceiveMessageResponse><ReceiveMessageResult>
+<Message>
+	<MessageId>msg-1</MessageId>
+	<ReceiptHandle>handle-1</ReceiptHandle>
+	<Body>%s</Body>
+	<MD5OfBody>%x</MD5OfBody>
+	<Attribute><Name>ApproximateReceiveCount</Name><Value>3</Value></Attribute>
+	<MessageAttribute><Name>RequestId</Name><Value><DataType>String</DataType><StringValue>req-1</StringValue></Value></MessageAttribute>
+</Message>
+<Message>
+	<MessageId>msg-2</MessageId>
+	<ReceiptHandle>handle-2</ReceiptHandle>
+	<Body>%s</Body>
+	<MD5OfBody>%x</MD5OfBody>
+	<MessageAttribute><Name>ExtendedPayloadSize</Name><Value><DataType>Number</DataType><StringValue>36</StringValue></Value></MessageAttribute>
+</Message>
+</ReceiveMessageResult></ReceiveMessageResponse>`, plain, md5.Sum([]byte(plain)), ptr, md5.Sum([]byte(ptr)))
+	case "DeleteMessage":
+		f.deleted = append(f.deleted, req.Form.Get("ReceiptHandle"))
+		fmt.Fprint(w, `<DeleteMessageResponse></DeleteMessageResponse>`)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `<ErrorResponse><Error><Type>Sender</Type><Code>AWS.SimpleQueueService.NonExistentQueue</Code><Message>missing</Message></Error></ErrorResponse>`)
+	}
+}
+
+// TestSQSReceive checks that messages are received with their attributes,
+// that offloaded payloads are downloaded and that received messages may be
+// deleted.
+func TestSQSReceive(t *testing.T) {
+	f := &fakeSQS{}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	r, err := NewSQSReceiver(srv.URL, srv.URL + "/000000000000/test-queue", SQSOptions{
+		Region: "us-east-1",
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	})
+	if err != nil {
+		t.Fatalf("NewSQSReceiver: Failed to create the receiver: %+v", err)
+	}
+
+	msgs, err := r.Receive(MaxReceive, 0)
+	if err != nil {
+		t.Fatalf("Receive: Failed to receive the messages: %+v", err)
+	} else if len(msgs) != 2 {
+		t.Fatalf("Receive: Expected 2 messages but got %d", len(msgs))
+	}
+
+	if msg := msgs[0]; msg.MessageID != "msg-1" || msg.Body != `{"Channel":"general","Message":"One, two!"}` || msg.ReceiveCount != 3 || msg.Attributes["RequestId"] != "req-1" {
+		t.Errorf("Receive: Unexpected message '%+v'", msg)
+	}
+	if msg := msgs[1]; msg.Body != "The vorpal blade went snicker-snack!" || len(msg.Attributes) != 0 {
+		t.Errorf("Receive: Expected the offloaded payload to be downloaded but got '%+v'", msg)
+	}
+
+	for _, msg := range msgs {
+		if err := r.Delete(msg); err != nil {
+			t.Errorf("Delete: Failed to delete '%s': %+v", msg.MessageID, err)
+		}
+	}
+	if len(f.deleted) != 2 || f.deleted[0] != "handle-1" || f.deleted[1] != "handle-2" {
+		t.Errorf("Delete: Unexpected deleted messages '%+v'", f.deleted)
+	}
+
+	_, err = NewSQSReceiver("", "", SQSOptions{})
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("NewSQSReceiver: Expected error '%+v' but got '%+v'", ErrInvalidConfig, err)
+	}
+}
+
+// fakeGitHub imitates the GitHub API endpoints used by the GitHub sender,
+// for the repository "owner/repo".
+type fakeGitHub struct {
+	// The issues filed.
+	issues []githubIssue
+
+	// Whether the search doesn't find any issue, as if it weren't indexed
+	// yet.
+	stale bool
+
+	// If not 0, every request fails with this status.
+	code int
+}
+
+func (f *fakeGitHub) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if f.code != 0 {
+		w.WriteHeader(f.code)
+		return
+	} else if req.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case req.Method == http.MethodGet && req.URL.Path == "/repos/owner/repo":
+		w.Write([]byte(`{"full_name":"owner/repo"}`))
+	case req.Method == http.MethodGet && req.URL.Path == "/search/issues":
+		hash, _, _ := strings.Cut(req.URL.Query().Get("q"), " ")
+		var res struct {
+			Items []githubIssue `json:"items"`
+		}
+		for _, issue := range f.issues {
+			if !f.stale && strings.Contains(issue.Body, hash) {
+				res.Items = append(res.Items, issue)
+			}
+		}
+		json.NewEncoder(w).Encode(&res)
+	case req.Method == http.MethodPost && req.URL.Path == "/repos/owner/repo/issues":
+		var in newIssue
+		json.NewDecoder(req.Body).Decode(&in)
+		issue := githubIssue{
+			HTMLURL: fmt.Sprintf("https://github.com/owner/repo/issues/%d", len(f.issues) + 1),
+			Body: in.Body,
+		}
+		f.issues = append(f.issues, issue)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(&issue)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestGitHubSend(t *testing.T) {
+	fake := &fakeGitHub{stale: true}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	opts := GitHubOptions{
+		APIURL: srv.URL,
+		Token: "secret",
+		Repo: "owner/repo",
+		Labels: []string{"bug"},
+		Timeout: time.Second,
+	}
+	s, err := NewGitHubSender(opts)
+	if err != nil {
+		t.Fatalf("NewGitHubSender: Failed to create the sender: %+v", err)
+	}
+	if err := s.(Checker).Check(); err != nil {
+		t.Errorf("Check: Failed to check the repository: %+v", err)
+	}
+
+	test_cases := []struct{
+		body string
+		want string
+	}{
+		// Filed, even if the search doesn't find it yet.
+		{ body: "\nThe store is full\nat /var/lib", want: "https://github.com/owner/repo/issues/1" },
+		// Recently filed.
+		{ body: "The store is full", want: "https://github.com/owner/repo/issues/1" },
+		{ body: "The store is empty", want: "https://github.com/owner/repo/issues/2" },
+	}
+	for i, tc := range test_cases {
+		res, err := s.Send(Message{Body: tc.body})
+		if err != nil {
+			t.Errorf("%d: Send: Failed to send the message: %+v", i, err)
+		} else if res.MessageID != tc.want {
+			t.Errorf("%d: Send: Expected '%s' but got '%s'", i, tc.want, res.MessageID)
+		}
+	}
+	if want := "at /var/lib\n\n" + issueMarker(issueHash("The store is full")); fake.issues[0].Body != want {
+		t.Errorf("Send: Expected the body '%s' but got '%s'", want, fake.issues[0].Body)
+	}
+
+	// A new sender only finds the issue through the search.
+	fake.stale = false
+	s, _ = NewGitHubSender(opts)
+	res, err := s.Send(Message{Body: "The store is empty\nagain"})
+	if err != nil {
+		t.Errorf("Send: Failed to send the message: %+v", err)
+	} else if want := "https://github.com/owner/repo/issues/2"; res.MessageID != want {
+		t.Errorf("Send: Expected '%s' but got '%s'", want, res.MessageID)
+	}
+	if len(fake.issues) != 2 {
+		t.Errorf("Send: Expected 2 issues but got %d", len(fake.issues))
+	}
+
+	if _, err := s.Send(Message{Body: " \n "}); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Send: Expected error '%+v' but got '%+v'", ErrInvalidInput, err)
+	}
+
+	errs := []struct{
+		code int
+		want error
+	}{
+		{ code: http.StatusUnprocessableEntity, want: ErrRejected },
+		{ code: http.StatusUnauthorized, want: ErrSendFailed },
+		{ code: http.StatusNotFound, want: ErrNotFound },
+		{ code: http.StatusTooManyRequests, want: ErrThrottled },
+		{ code: http.StatusBadGateway, want: ErrTemporary },
+	}
+	for i, tc := range errs {
+		fake.code = tc.code
+		if _, err := s.Send(Message{Body: "fail"}); !errors.Is(err, tc.want) {
+			t.Errorf("%d: Send: Expected error '%+v' but got '%+v'", i, tc.want, err)
+		}
+	}
+
+	for _, repo := range []string{"", "owner", "owner/", "/repo", "owner/repo/x", "owner/re po"} {
+		opts.Repo = repo
+		if _, err := NewGitHubSender(opts); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("NewGitHubSender: Expected error '%+v' for '%s' but got '%+v'", ErrInvalidConfig, repo, err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package sender
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// slackSender implements Sender for a Slack incoming webhook.
+type slackSender struct {
+	// URL of the incoming webhook.
+	webhookURL string
+
+	// Client used to post the messages.
+	client *http.Client
+}
+
+// slackMessage is the payload posted to a Slack incoming webhook.
+type slackMessage struct {
+	// The message's contents, in Slack's mrkdwn.
+	Text string `json:"text"`
+}
+
+func (s slackSender) Send(msg Message) (SendResult, error) {
+	res := SendResult{Attempts: 1}
+	start := time.Now()
+
+	data, err := json.Marshal(&slackMessage{Text: msg.Body})
+	if err != nil {
+		return res, wrap("Slack", ErrInvalidInput, err)
+	}
+
+	resp, err := s.client.Post(s.webhookURL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		logger().Error("sender/Slack: Failed to post the message", "err", err)
+		return res, wrap("Slack", ErrUnreachable, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	err = fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(body))
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		res.SentAt = time.Now()
+		res.Duration = res.SentAt.Sub(start)
+		return res, nil
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return res, wrap("Slack", ErrThrottled, err)
+	case resp.StatusCode == http.StatusNotFound, resp.StatusCode == http.StatusGone:
+		// The webhook (or its channel) was removed.
+		return res, wrap("Slack", ErrNotFound, err)
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return res, wrap("Slack", ErrRejected, err)
+	default:
+		return res, wrap("Slack", ErrTemporary, err)
+	}
+}
+
+// NewSlackSender creates a sender that posts each message's body, as text,
+// to the Slack incoming webhook at webhookURL. Attributes are ignored, and
+// Slack doesn't identify the messages, so the results have no MessageID.
+//
+// If webhookURL isn't a valid URL, a *ConfigError is returned.
+func NewSlackSender(webhookURL string, timeout time.Duration) (Sender, error) {
+	u, err := url.Parse(webhookURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || len(u.Host) == 0 {
+		return nil, &ConfigError{
+			Op: "NewSlackSender",
+			Msg: "The webhook's URL must be an absolute HTTP(S) URL",
+			Err: err,
+		}
+	}
+
+	return slackSender{
+		webhookURL: webhookURL,
+		client: &http.Client{Timeout: timeout},
+	}, nil
+}
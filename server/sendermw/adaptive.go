@@ -0,0 +1,121 @@
+package sendermw
+
+import (
+	"context"
+	"errors"
+	"github.com/SirGFM/sqs-issue-notifier/server/sender"
+	"golang.org/x/time/rate"
+	"sync"
+)
+
+// AIMDConfig configures an AdaptiveThrottle.
+type AIMDConfig struct {
+	// The slowest rate, in messages per second, ever used.
+	MinRate float64
+
+	// The fastest rate, in messages per second, ever used. It's also the
+	// initial rate.
+	MaxRate float64
+
+	// How much the rate increases, in messages per second, after every
+	// message sent successfully. Defaults to 1.
+	Increase float64
+
+	// By how much the rate is multiplied after the receiver throttles a
+	// message. Defaults to 0.5.
+	Decrease float64
+}
+
+// AdaptiveThrottle limits the rate at which messages are sent, adapting it
+// to the receiver's capacity: the rate is decreased multiplicatively
+// whenever the receiver throttles a message (i.e., fails with
+// sender.ErrThrottled), and increased additively after every success
+// (AIMD).
+type AdaptiveThrottle struct {
+	// The throttle's configuration.
+	cfg AIMDConfig
+
+	// Limits how fast messages are sent.
+	limiter *rate.Limiter
+
+	// Synchronizes updating the rate.
+	mutex sync.Mutex
+
+	// The current rate.
+	rate float64
+}
+
+// NewAdaptiveThrottle creates an AdaptiveThrottle starting at cfg.MaxRate.
+func NewAdaptiveThrottle(cfg AIMDConfig) *AdaptiveThrottle {
+	if cfg.MinRate <= 0 {
+		cfg.MinRate = 1
+	}
+	if cfg.MaxRate < cfg.MinRate {
+		cfg.MaxRate = cfg.MinRate
+	}
+	if cfg.Increase <= 0 {
+		cfg.Increase = 1
+	}
+	if cfg.Decrease <= 0 || cfg.Decrease >= 1 {
+		cfg.Decrease = 0.5
+	}
+
+	return &AdaptiveThrottle{
+		cfg: cfg,
+		limiter: rate.NewLimiter(rate.Limit(cfg.MaxRate), 1),
+		rate: cfg.MaxRate,
+	}
+}
+
+// Rate retrieves the current rate, in messages per second.
+func (at *AdaptiveThrottle) Rate() float64 {
+	at.mutex.Lock()
+	defer at.mutex.Unlock()
+
+	return at.rate
+}
+
+// update the rate after a message was sent with the given error.
+func (at *AdaptiveThrottle) update(err error) {
+	at.mutex.Lock()
+	defer at.mutex.Unlock()
+
+	prev := at.rate
+	if errors.Is(err, sender.ErrThrottled) {
+		at.rate *= at.cfg.Decrease
+		if at.rate < at.cfg.MinRate {
+			at.rate = at.cfg.MinRate
+		}
+		if at.rate != prev {
+			logger().Info("sendermw/AdaptiveThrottle: Throttled, slowing down", "rate", at.rate)
+		}
+	} else if err == nil {
+		at.rate += at.cfg.Increase
+		if at.rate > at.cfg.MaxRate {
+			at.rate = at.cfg.MaxRate
+		}
+	}
+
+	if at.rate != prev {
+		at.limiter.SetLimit(rate.Limit(at.rate))
+	}
+}
+
+// Middleware limits the wrapped sender to the throttle's current rate.
+// Every sender wrapped by the same throttle shares its rate.
+func (at *AdaptiveThrottle) Middleware() Middleware {
+	return func(s sender.Sender) sender.Sender {
+		return senderFunc(func(msg sender.Message) (sender.SendResult, error) {
+			at.limiter.Wait(context.Background())
+			res, err := s.Send(msg)
+			at.update(err)
+			return res, err
+		})
+	}
+}
+
+// WithAdaptiveThrottle limits the rate at which messages are sent, adapting
+// it as done by an AdaptiveThrottle.
+func WithAdaptiveThrottle(cfg AIMDConfig) Middleware {
+	return NewAdaptiveThrottle(cfg).Middleware()
+}
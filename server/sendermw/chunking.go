@@ -0,0 +1,56 @@
+package sendermw
+
+import (
+	"github.com/SirGFM/sqs-issue-notifier/server/chunking"
+	"github.com/SirGFM/sqs-issue-notifier/server/sender"
+	"strings"
+	"time"
+)
+
+// WithChunking splits messages larger than maxSize bytes into chunks, as
+// done by chunking.Split, sending each chunk as a separate message. The
+// message's attributes, sent along each chunk, count towards maxSize (as
+// measured by sender.AttributesSize). Consumers must reassemble them with a
+// chunking.Reassembler.
+//
+// If any chunk fails, the error is returned right away. Since chunks are
+// generated deterministically, sending the message again is safe.
+func WithChunking(maxSize int) Middleware {
+	return func(s sender.Sender) sender.Sender {
+		return senderFunc(func(msg sender.Message) (sender.SendResult, error) {
+			size := maxSize - sender.AttributesSize(msg.Attributes)
+			if len(msg.Body) <= size {
+				return s.Send(msg)
+			}
+
+			chunks, err := chunking.Split(msg.Body, size)
+			if err != nil {
+				logger().Error("sendermw/WithChunking: Failed to split the message", "err", err)
+				return sender.SendResult{}, sender.ErrInvalidInput
+			}
+
+			var total sender.SendResult
+			var ids []string
+			start := time.Now()
+			for _, chunk := range chunks {
+				part := msg
+				part.Body = chunk
+
+				res, err := s.Send(part)
+				total.Attempts += res.Attempts
+				if err != nil {
+					total.Duration = time.Since(start)
+					return total, err
+				}
+
+				ids = append(ids, res.MessageID)
+				total.SequenceNumber = res.SequenceNumber
+				total.SentAt = res.SentAt
+			}
+
+			total.MessageID = strings.Join(ids, ",")
+			total.Duration = time.Since(start)
+			return total, nil
+		})
+	}
+}
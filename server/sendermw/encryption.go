@@ -0,0 +1,44 @@
+package sendermw
+
+import (
+	"github.com/SirGFM/sqs-issue-notifier/server/sender"
+)
+
+// EncryptionAttr is the message attribute identifying how the message was
+// encrypted (e.g., sender.KMSEncryptionScheme).
+const EncryptionAttr = "Encryption"
+
+// Encrypter encrypts the body of messages, such as a sender.KMSEncrypter.
+type Encrypter interface {
+	// Scheme identifies how bodies are encrypted, so consumers know how to
+	// decrypt them.
+	Scheme() string
+
+	// Encrypt plaintext, returning the encrypted body (which must be valid
+	// text) and the attributes needed to decrypt it. On failure, it
+	// should return one of the sender's errors, so the message is handled
+	// accordingly (e.g., retried on sender.ErrTemporary).
+	Encrypt(plaintext []byte) ([]byte, map[string]string, error)
+}
+
+// WithEncryption encrypts the body of every message with enc. The
+// encryption scheme is sent in the attribute EncryptionAttr, alongside the
+// attributes returned by the Encrypter.
+func WithEncryption(enc Encrypter) Middleware {
+	return func(s sender.Sender) sender.Sender {
+		return senderFunc(func(msg sender.Message) (sender.SendResult, error) {
+			body, attrs, err := enc.Encrypt([]byte(msg.Body))
+			if err != nil {
+				logger().Error("sendermw/WithEncryption: Failed to encrypt the message", "err", err)
+				return sender.SendResult{}, err
+			}
+
+			msg.Body = string(body)
+			msg.Attributes = withAttribute(msg.Attributes, EncryptionAttr, enc.Scheme())
+			for name, value := range attrs {
+				msg.Attributes[name] = value
+			}
+			return s.Send(msg)
+		})
+	}
+}
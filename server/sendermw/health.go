@@ -0,0 +1,32 @@
+package sendermw
+
+import (
+	"errors"
+	"github.com/SirGFM/sqs-issue-notifier/server/health"
+	"github.com/SirGFM/sqs-issue-notifier/server/sender"
+)
+
+// WithHealth reports the sender's health to reg, as component, after every
+// Send: it's failed while the circuit breaker is open, degraded while
+// messages fail to be sent (and so are kept locally), and OK once they're
+// delivered. Messages rejected by the receiver don't change its health, as
+// they say nothing about the receiver.
+func WithHealth(reg *health.Registry, component string) Middleware {
+	return func(s sender.Sender) sender.Sender {
+		reg.Set(component, health.OK, "")
+
+		return senderFunc(func(msg sender.Message) (sender.SendResult, error) {
+			res, err := s.Send(msg)
+			switch {
+			case err == nil:
+				reg.Set(component, health.OK, "")
+			case errors.Is(err, sender.ErrRejected), errors.Is(err, sender.ErrInvalidInput):
+			case errors.Is(err, sender.ErrCircuitOpen):
+				reg.Set(component, health.Failed, "buffering locally, the circuit breaker is open after too many failures")
+			default:
+				reg.Set(component, health.Degraded, "buffering locally, sending failed: " + err.Error())
+			}
+			return res, err
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package sendermw
+
+import (
+	"errors"
+	"github.com/SirGFM/sqs-issue-notifier/server/metrics"
+	"github.com/SirGFM/sqs-issue-notifier/server/sender"
+	"sync"
+	"time"
+)
+
+// MetricsRecorder receives the outcome of every message sent.
+type MetricsRecorder interface {
+	// ObserveSend is called after every Send, with its result, its error
+	// and how long it took.
+	ObserveSend(res sender.SendResult, err error, took time.Duration)
+}
+
+// Stats is a MetricsRecorder that keeps simple counters in memory.
+type Stats struct {
+	// Synchronizes access to the counters.
+	mutex sync.Mutex
+
+	// The current counters.
+	snapshot StatsSnapshot
+}
+
+// StatsSnapshot is a copy of the counters kept by Stats.
+type StatsSnapshot struct {
+	// Number of messages sent successfully.
+	Sent uint64
+
+	// Number of messages rejected by the receiver.
+	Rejected uint64
+
+	// Number of messages that failed to be sent.
+	Failed uint64
+
+	// Number of messages throttled by the receiver (also counted as
+	// failures).
+	Throttled uint64
+
+	// Total time spent sending messages.
+	TotalDuration time.Duration
+
+	// When the last message was sent successfully.
+	LastSent time.Time
+
+	// The last error returned by Send.
+	LastError error
+}
+
+func (st *Stats) ObserveSend(res sender.SendResult, err error, took time.Duration) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	st.snapshot.TotalDuration += took
+	switch {
+	case err == nil:
+		st.snapshot.Sent++
+		st.snapshot.LastSent = res.SentAt
+	case errors.Is(err, sender.ErrRejected), errors.Is(err, sender.ErrInvalidInput):
+		st.snapshot.Rejected++
+		st.snapshot.LastError = err
+	case errors.Is(err, sender.ErrThrottled):
+		st.snapshot.Throttled++
+		fallthrough
+	default:
+		st.snapshot.Failed++
+		st.snapshot.LastError = err
+	}
+}
+
+// Snapshot retrieves a copy of the current counters.
+func (st *Stats) Snapshot() StatsSnapshot {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	return st.snapshot
+}
+
+// drainWindow is the number of seconds over which the drain rate is
+// averaged.
+const drainWindow = 60
+
+// errorClasses names each error returned by senders, as reported by
+// Prometheus. Any other error is reported as "other".
+var errorClasses = []struct{
+	err error
+	class string
+}{
+	{sender.ErrRejected, "rejected"},
+	{sender.ErrInvalidInput, "invalid_input"},
+	{sender.ErrThrottled, "throttled"},
+	{sender.ErrCircuitOpen, "circuit_open"},
+	{sender.ErrUnreachable, "unreachable"},
+	{sender.ErrTemporary, "temporary"},
+	{sender.ErrNotFound, "not_found"},
+	{sender.ErrInvalidConfig, "invalid_config"},
+	{sender.ErrSendFailed, "send_failed"},
+}
+
+// errorClass names the class of err, as reported by Prometheus.
+func errorClass(err error) string {
+	for _, ec := range errorClasses {
+		if errors.Is(err, ec.err) {
+			return ec.class
+		}
+	}
+	return "other"
+}
+
+// Prometheus is a MetricsRecorder that exports the outcome of every
+// message through a metrics.Registry.
+type Prometheus struct {
+	// Messages sent, by their result ("sent", "rejected" or "failed").
+	sends *metrics.Counter
+
+	// Attempts beyond the first one of every message.
+	retries *metrics.Counter
+
+	// Messages that weren't sent, by the class of their error.
+	failures *metrics.Counter
+
+	// Total time spent sending messages.
+	duration *metrics.Counter
+
+	// Messages sent per second, over the last drainWindow seconds.
+	drainRate *metrics.Gauge
+
+	// Synchronizes access to sentAt and sentPerSecond.
+	mutex sync.Mutex
+
+	// The second (as a Unix time) counted by each slot of sentPerSecond.
+	sentAt [drainWindow]int64
+
+	// Messages sent during each second, in a ring indexed by the second.
+	sentPerSecond [drainWindow]uint64
+}
+
+// NewPrometheus registers the forwarding metrics in reg, named after
+// prefix (e.g., "app_forwarder" exports "app_forwarder_sends_total").
+func NewPrometheus(reg *metrics.Registry, prefix string) *Prometheus {
+	p := &Prometheus{
+		sends: reg.Counter(prefix + "_sends_total", "Messages sent, by their result (sent, rejected or failed).", "result"),
+		retries: reg.Counter(prefix + "_retries_total", "Attempts to send a message beyond the first one."),
+		failures: reg.Counter(prefix + "_failures_total", "Messages that weren't sent, by the class of their error.", "class"),
+		duration: reg.Counter(prefix + "_send_seconds_total", "Total time spent sending messages."),
+		drainRate: reg.Gauge(prefix + "_drain_rate", "Messages sent per second, over the last minute."),
+	}
+	reg.OnCollect(func() {
+		p.drainRate.Set(p.rate(time.Now()))
+	})
+
+	return p
+}
+
+func (p *Prometheus) ObserveSend(res sender.SendResult, err error, took time.Duration) {
+	p.duration.Add(took.Seconds())
+	if res.Attempts > 1 {
+		p.retries.Add(float64(res.Attempts - 1))
+	}
+
+	switch {
+	case err == nil:
+		p.sends.Inc("sent")
+		p.countSent(time.Now())
+		return
+	case errors.Is(err, sender.ErrRejected), errors.Is(err, sender.ErrInvalidInput):
+		p.sends.Inc("rejected")
+	default:
+		p.sends.Inc("failed")
+	}
+	p.failures.Inc(errorClass(err))
+}
+
+// countSent counts a message sent at now towards the drain rate.
+func (p *Prometheus) countSent(now time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	sec := now.Unix()
+	i := sec % drainWindow
+	if p.sentAt[i] != sec {
+		p.sentAt[i] = sec
+		p.sentPerSecond[i] = 0
+	}
+	p.sentPerSecond[i]++
+}
+
+// rate retrieves how many messages were sent per second, over the
+// drainWindow seconds before now.
+func (p *Prometheus) rate(now time.Time) float64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var sent uint64
+	oldest := now.Unix() - drainWindow
+	for i, sec := range p.sentAt {
+		if sec > oldest {
+			sent += p.sentPerSecond[i]
+		}
+	}
+	return float64(sent) / drainWindow
+}
+
+// WithMetrics reports the outcome of every Send to r.
+func WithMetrics(r MetricsRecorder) Middleware {
+	return func(s sender.Sender) sender.Sender {
+		return senderFunc(func(msg sender.Message) (sender.SendResult, error) {
+			start := time.Now()
+			res, err := s.Send(msg)
+			r.ObserveSend(res, err, time.Since(start))
+			return res, err
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package sendermw
+
+import (
+	"context"
+	"github.com/SirGFM/sqs-issue-notifier/server/sender"
+	"golang.org/x/time/rate"
+)
+
+// WithRateLimit limits how many messages may be sent per second, on
+// average. Up to burst messages may be sent at once after the sender has
+// been idle for a while. Send blocks until it's allowed to proceed.
+//
+// The limit is shared by every sender wrapped by the returned Middleware.
+func WithRateLimit(perSecond float64, burst int) Middleware {
+	if burst < 1 {
+		burst = 1
+	}
+	limiter := rate.NewLimiter(rate.Limit(perSecond), burst)
+
+	return func(s sender.Sender) sender.Sender {
+		return senderFunc(func(msg sender.Message) (sender.SendResult, error) {
+			// Wait only fails if the context is cancelled, or if burst
+			// is exceeded (which is never the case, as a single token is
+			// requested).
+			limiter.Wait(context.Background())
+			return s.Send(msg)
+		})
+	}
+}
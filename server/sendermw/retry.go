@@ -0,0 +1,104 @@
+package sendermw
+
+import (
+	"errors"
+	"github.com/SirGFM/sqs-issue-notifier/server/sender"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// RetryPolicy defines how many times, and how often, a transient failure
+// is retried before giving up on sending a message.
+//
+// The zero value disables retrying (i.e., a single attempt is done).
+type RetryPolicy struct {
+	// Maximum number of attempts, including the first one. Values lower
+	// than 1 are treated as a single attempt.
+	MaxAttempts int
+
+	// Delay before the first retry. It's doubled after every failed
+	// attempt.
+	BaseDelay time.Duration
+
+	// Upper bound for the delay between attempts. Ignored if 0.
+	MaxDelay time.Duration
+
+	// Fraction of each delay, between 0.0 and 1.0, that's randomized to
+	// avoid every sender retrying in lockstep.
+	Jitter float64
+}
+
+// attempts returns the number of times a message should be sent.
+func (p RetryPolicy) attempts() int {
+	if p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+// delay returns how long to wait before retrying after the given failed
+// attempt (starting at 1).
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.BaseDelay
+	// Stop doubling before it overflows, as MaxDelay may be unbounded.
+	for i := 1; i < attempt && d > 0 && d <= math.MaxInt64 / 2 && (p.MaxDelay == 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay != 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+
+	jitter := p.Jitter
+	if jitter > 1.0 {
+		jitter = 1.0
+	}
+	if jitter > 0.0 && d > 0 {
+		// Remove up to 'jitter' of the delay, so it's never exceeded.
+		d -= time.Duration(float64(d) * jitter * rand.Float64())
+	}
+
+	return d
+}
+
+// WithRetry retries sending messages that failed with sender.ErrTemporary
+// or sender.ErrThrottled, as configured by p. Any other error is returned
+// immediately.
+//
+// The returned sender.SendResult reports the total number of attempts and
+// the time spent on all of them.
+func WithRetry(p RetryPolicy) Middleware {
+	var policy atomic.Pointer[RetryPolicy]
+	policy.Store(&p)
+	return WithSwappableRetry(&policy)
+}
+
+// WithSwappableRetry is WithRetry, but the policy is loaded from p whenever
+// a message is sent, so it may be replaced while messages are sent. Each
+// message is retried with the policy loaded when it started being sent.
+func WithSwappableRetry(p *atomic.Pointer[RetryPolicy]) Middleware {
+	return func(s sender.Sender) sender.Sender {
+		return senderFunc(func(msg sender.Message) (sender.SendResult, error) {
+			start := time.Now()
+			policy := *p.Load()
+			attempts := policy.attempts()
+
+			for i := 1; ; i++ {
+				res, err := s.Send(msg)
+				res.Attempts = i
+				res.Duration = time.Since(start)
+				if !errors.Is(err, sender.ErrTemporary) && !errors.Is(err, sender.ErrThrottled) {
+					return res, err
+				} else if i >= attempts {
+					logger().Warn("sendermw/WithRetry: Giving up", "attempts", i)
+					return res, err
+				}
+
+				delay := policy.delay(i)
+				logger().Debug("sendermw/WithRetry: Attempt failed, retrying", "attempt", i, "attempts", attempts, "delay", delay)
+				time.Sleep(delay)
+			}
+		})
+	}
+}
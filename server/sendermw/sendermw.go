@@ -0,0 +1,60 @@
+/*
+Package sendermw implements composable decorators for senders.
+
+Each decorator is a Middleware that wraps a sender.Sender, layering some
+behaviour (e.g., retrying, rate limiting or metrics) onto it. This way,
+every sender backend gets the same behaviour without re-implementing it.
+
+Decorators may be applied individually, or combined through "Chain()".
+
+Example:
+
+	s := sendermw.Chain(sender.NewSQSSender(endpoint, queue, opts),
+		sendermw.WithRateLimit(10, 1),
+		sendermw.WithRetry(sendermw.RetryPolicy{
+			MaxAttempts: 3,
+			BaseDelay: 100 * time.Millisecond,
+		}),
+		sendermw.WithMetrics(stats),
+	)
+
+	res, err := s.Send(sender.Message{Body: "hello"})
+	if err != nil {
+		// handle err
+	}
+*/
+package sendermw
+
+import (
+	"github.com/SirGFM/sqs-issue-notifier/server/loglevel"
+	"github.com/SirGFM/sqs-issue-notifier/server/sender"
+	"log/slog"
+)
+
+// logger retrieves the logger for the middlewares' entries, identified as
+// the "sender" component.
+func logger() *slog.Logger {
+	return slog.Default().With(loglevel.ComponentKey, "sender")
+}
+
+// Middleware wraps a sender, layering some behaviour onto it.
+type Middleware func(s sender.Sender) sender.Sender
+
+// senderFunc adapts a function into a sender.Sender.
+type senderFunc func(msg sender.Message) (sender.SendResult, error)
+
+func (f senderFunc) Send(msg sender.Message) (sender.SendResult, error) {
+	return f(msg)
+}
+
+// Chain wraps s with every middleware, in order. So, the first middleware
+// is the innermost one (i.e., the closest to s), and the last middleware
+// is the first one called on every Send.
+func Chain(s sender.Sender, mws ...Middleware) sender.Sender {
+	for _, mw := range mws {
+		if mw != nil {
+			s = mw(s)
+		}
+	}
+	return s
+}
@@ -0,0 +1,445 @@
+package sendermw
+
+import (
+	"bytes"
+	"github.com/SirGFM/sqs-issue-notifier/server/chunking"
+	"github.com/SirGFM/sqs-issue-notifier/server/health"
+	"github.com/SirGFM/sqs-issue-notifier/server/metrics"
+	"github.com/SirGFM/sqs-issue-notifier/server/sender"
+	"github.com/SirGFM/sqs-issue-notifier/server/sendertest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newScripted creates a sendertest.Sender that fails with each of errs in
+// order, succeeding once they are exhausted.
+func newScripted(errs ...error) *sendertest.Sender {
+	s := sendertest.New()
+	for _, err := range errs {
+		s.FailNext(1, err)
+	}
+	return s
+}
+
+// TestRetryPolicy checks that the delay between attempts grows
+// exponentially, respects the upper bound and is only ever reduced by the
+// jitter.
+func TestRetryPolicy(t *testing.T) {
+	p := RetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay: 100 * time.Millisecond,
+		MaxDelay: 300 * time.Millisecond,
+	}
+
+	test_cases := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		300 * time.Millisecond,
+		300 * time.Millisecond,
+	}
+	for i, want := range test_cases {
+		if got := p.delay(i + 1); want != got {
+			t.Errorf("%d: delay: Expected '%s' but got '%s'", i, want, got)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i, max := range test_cases {
+		got := p.delay(i + 1)
+		if min := max / 2; got < min || got > max {
+			t.Errorf("%d: delay: Expected a value between '%s' and '%s' but got '%s'", i, min, max, got)
+		}
+	}
+
+	// Without an upper bound, the delay stops doubling before it
+	// overflows.
+	unbounded := RetryPolicy{BaseDelay: time.Second}
+	if want, got := time.Second << 33, unbounded.delay(100); want != got {
+		t.Errorf("delay: Expected '%s' but got '%s'", want, got)
+	}
+
+	if want, got := 1, (RetryPolicy{}).attempts(); want != got {
+		t.Errorf("attempts: Expected '%d' but got '%d'", want, got)
+	}
+}
+
+// TestWithRetry checks that only temporary failures are retried, and only
+// up to the configured number of attempts.
+func TestWithRetry(t *testing.T) {
+	test_cases := []struct{
+		errs []error
+		sent int
+		err error
+	} {
+		{ errs: []error{sender.ErrTemporary, sender.ErrTemporary}, sent: 3 },
+		{ errs: []error{sender.ErrTemporary, sender.ErrRejected}, sent: 2, err: sender.ErrRejected },
+		{ errs: []error{sender.ErrSendFailed}, sent: 1, err: sender.ErrSendFailed },
+		{ errs: []error{sender.ErrTemporary, sender.ErrTemporary, sender.ErrTemporary}, sent: 3, err: sender.ErrTemporary },
+	}
+
+	mw := WithRetry(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})
+	for i, tc := range test_cases {
+		ss := newScripted(tc.errs...)
+
+		res, err := mw(ss).Send(sender.Message{Body: "retried"})
+		if want, got := tc.err, err; want != got {
+			t.Errorf("%d: Send: Expected error '%+v' but got '%+v'", i, want, got)
+		}
+		if want, got := tc.sent, ss.Attempts(); want != got {
+			t.Errorf("%d: Send: Expected '%d' attempts but got '%d'", i, want, got)
+		} else if want, got := tc.sent, res.Attempts; want != got {
+			t.Errorf("%d: Send: Expected the result to report '%d' attempts but got '%d'", i, want, got)
+		}
+	}
+}
+
+// TestWithSwappableRetry checks that replacing the policy applies to the
+// messages sent afterwards.
+func TestWithSwappableRetry(t *testing.T) {
+	var policy atomic.Pointer[RetryPolicy]
+	policy.Store(&RetryPolicy{MaxAttempts: 1})
+	mw := WithSwappableRetry(&policy)
+
+	ss := newScripted(sender.ErrTemporary, sender.ErrTemporary)
+	s := mw(ss)
+	if _, err := s.Send(sender.Message{Body: "not retried"}); err != sender.ErrTemporary {
+		t.Errorf("Send: Expected error '%+v' but got '%+v'", sender.ErrTemporary, err)
+	}
+
+	policy.Store(&RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})
+	res, err := s.Send(sender.Message{Body: "retried"})
+	if err != nil {
+		t.Errorf("Send: Failed to send the message: %+v", err)
+	}
+	if want, got := 2, res.Attempts; want != got {
+		t.Errorf("Send: Expected '%d' attempts but got '%d'", want, got)
+	}
+}
+
+// TestWithMetrics checks that every outcome is counted, even when combined
+// with other middlewares.
+func TestWithMetrics(t *testing.T) {
+	var stats Stats
+
+	ss := newScripted(sender.ErrTemporary, sender.ErrRejected, sender.ErrSendFailed)
+	s := Chain(ss,
+		WithRateLimit(1000, 10),
+		WithRetry(RetryPolicy{MaxAttempts: 2}),
+		WithMetrics(&stats),
+	)
+
+	for i := 0; i < 4; i++ {
+		s.Send(sender.Message{Body: "counted"})
+	}
+
+	snap := stats.Snapshot()
+	if want, got := uint64(2), snap.Sent; want != got {
+		t.Errorf("Sent: Expected '%d' but got '%d'", want, got)
+	}
+	if want, got := uint64(1), snap.Rejected; want != got {
+		t.Errorf("Rejected: Expected '%d' but got '%d'", want, got)
+	}
+	if want, got := uint64(1), snap.Failed; want != got {
+		t.Errorf("Failed: Expected '%d' but got '%d'", want, got)
+	}
+	if want, got := sender.ErrSendFailed, snap.LastError; want != got {
+		t.Errorf("LastError: Expected '%+v' but got '%+v'", want, got)
+	}
+}
+
+// TestPrometheus checks that every outcome is exported, with the retries
+// and the class of each error, and that the drain rate only counts the last
+// minute.
+func TestPrometheus(t *testing.T) {
+	reg := metrics.NewRegistry()
+	p := NewPrometheus(reg, "test")
+
+	ss := newScripted(sender.ErrTemporary, sender.ErrRejected, sender.ErrSendFailed)
+	s := Chain(ss,
+		WithRetry(RetryPolicy{MaxAttempts: 2}),
+		WithMetrics(p),
+	)
+	for i := 0; i < 4; i++ {
+		s.Send(sender.Message{Body: "counted"})
+	}
+
+	var buf bytes.Buffer
+	reg.WriteText(&buf)
+	for _, want := range []string{
+		`test_sends_total{result="sent"} 2`,
+		`test_sends_total{result="rejected"} 1`,
+		`test_sends_total{result="failed"} 1`,
+		`test_retries_total 1`,
+		`test_failures_total{class="rejected"} 1`,
+		`test_failures_total{class="send_failed"} 1`,
+	} {
+		if !strings.Contains(buf.String(), want + "\n") {
+			t.Errorf("WriteText: Expected '%s' in:\n%s", want, buf.String())
+		}
+	}
+
+	now := time.Now()
+	p.countSent(now.Add(-(drainWindow + drainWindow / 2) * time.Second))
+	if got, want := p.rate(now), 2.0 / drainWindow; got != want {
+		t.Errorf("rate: Expected '%f' but got '%f'", want, got)
+	}
+}
+
+// TestWithHealth checks that the sender's health follows the outcome of
+// each Send, ignoring rejected messages.
+func TestWithHealth(t *testing.T) {
+	reg := health.NewRegistry()
+	s := WithHealth(reg, "sender")(newScripted(sender.ErrTemporary, sender.ErrRejected, sender.ErrCircuitOpen))
+
+	for i, want := range []health.Status{health.Degraded, health.Degraded, health.Failed, health.OK} {
+		s.Send(sender.Message{Body: "checked"})
+		if got, _ := reg.Get("sender"); got.Status != want {
+			t.Errorf("%d: Send: Expected the sender to be '%s' but got '%+v'", i, want, got)
+		}
+	}
+}
+
+// TestWithChunking checks that large messages are split into chunks that
+// reassemble into the original message.
+func TestWithChunking(t *testing.T) {
+	ss := sendertest.New()
+	s := WithChunking(128)(ss)
+
+	msg := strings.Repeat("All mimsy were the borogoves, ", 20)
+	res, err := s.Send(sender.Message{Body: msg})
+	if err != nil {
+		t.Fatalf("Send: Failed to send the message: %+v", err)
+	}
+
+	sent := ss.Messages()
+	if len(sent) < 2 {
+		t.Fatalf("Send: Expected the message to be split, but got %d chunks", len(sent))
+	} else if want, got := len(sent), len(strings.Split(res.MessageID, ",")); want != got {
+		t.Errorf("Send: Expected '%d' IDs but got '%d'", want, got)
+	}
+
+	r := chunking.NewReassembler(0)
+	var got string
+	for _, chunk := range sent {
+		out, ok, err := r.Add(chunk.Body)
+		if err != nil {
+			t.Errorf("Add: Failed to add a chunk: %+v", err)
+		} else if ok {
+			got = out
+		}
+	}
+	if want := msg; want != got {
+		t.Errorf("Reassembled message doesn't match! Want '%s' but got '%s'", want, got)
+	}
+}
+
+// TestWithChunkingAttributes checks that the attributes sent along each
+// chunk count towards the chunk's size.
+func TestWithChunkingAttributes(t *testing.T) {
+	attrs := map[string]string{
+		"RequestId": "0123456789abcdef",
+		"Signature": strings.Repeat("s", 64),
+	}
+
+	test_cases := []string{
+		// Fits in maxSize, but not along with its attributes.
+		strings.Repeat("a", 256),
+		strings.Repeat("Beware the Jabberwock, my son! ", 40),
+	}
+	for i, tc := range test_cases {
+		ss := sendertest.New()
+		s := WithChunking(256)(ss)
+
+		_, err := s.Send(sender.Message{Body: tc, Attributes: attrs})
+		if err != nil {
+			t.Fatalf("%d: Send: Failed to send the message: %+v", i, err)
+		}
+
+		sent := ss.Messages()
+		if len(sent) < 2 {
+			t.Errorf("%d: Send: Expected the message to be split, but got %d chunks", i, len(sent))
+		}
+		r := chunking.NewReassembler(0)
+		var got string
+		for _, chunk := range sent {
+			if size := len(chunk.Body) + sender.AttributesSize(chunk.Attributes); size > 256 {
+				t.Errorf("%d: Send: Expected chunks of at most 256 bytes but got %d", i, size)
+			}
+			if out, ok, _ := r.Add(chunk.Body); ok {
+				got = out
+			}
+		}
+		if got != tc {
+			t.Errorf("%d: Reassembled message doesn't match! Want '%s' but got '%s'", i, tc, got)
+		}
+	}
+}
+
+// TestWithTemplate checks that messages are reshaped by the template, and
+// that messages that can't be transformed are rejected.
+func TestWithTemplate(t *testing.T) {
+	tmpl, err := ParseTemplate(`{"text":{{json .Message}},"to":{{json .Channel}}}`)
+	if err != nil {
+		t.Fatalf("ParseTemplate: Failed to parse the template: %+v", err)
+	}
+
+	ss := sendertest.New()
+	s := WithTemplate(tmpl)(ss)
+
+	test_cases := []struct{ in, out string; err error } {
+		{
+			in: `{"Channel":"general","Message":"say \"hi\""}`,
+			out: `{"text":"say \"hi\"","to":"general"}`,
+		},
+		{
+			in: `{"Channel":"general"}`,
+			out: `{"text":null,"to":"general"}`,
+		},
+		{
+			in: "not a json",
+			err: sender.ErrInvalidInput,
+		},
+	}
+	for i, tc := range test_cases {
+		ss.Reset()
+
+		_, err := s.Send(sender.Message{Body: tc.in})
+		if want, got := tc.err, err; want != got {
+			t.Errorf("%d: Send: Expected error '%+v' but got '%+v'", i, want, got)
+			continue
+		} else if err != nil {
+			continue
+		}
+
+		sent := ss.Messages()
+		if len(sent) != 1 {
+			t.Errorf("%d: Send: Expected a single message but got %d", i, len(sent))
+		} else if want, got := tc.out, sent[0].Body; want != got {
+			t.Errorf("%d: Send: Expected '%s' but got '%s'", i, want, got)
+		}
+	}
+}
+
+// TestWithSigning checks that signed messages may be verified, and that
+// tampered ones can't.
+func TestWithSigning(t *testing.T) {
+	key := []byte("shared secret")
+
+	ss := sendertest.New()
+	s := WithSigning(key)(ss)
+
+	attrs := map[string]string{"Other": "kept"}
+	_, err := s.Send(sender.Message{Body: "signed", Attributes: attrs})
+	if err != nil {
+		t.Fatalf("Send: Failed to send the message: %+v", err)
+	} else if _, ok := attrs[SignatureAttr]; ok {
+		t.Errorf("Send: The original attributes were modified")
+	}
+
+	msg := ss.Messages()[0]
+	sig := msg.Attributes[SignatureAttr]
+	if want, got := "kept", msg.Attributes["Other"]; want != got {
+		t.Errorf("Send: Expected attribute 'Other' to be '%s' but got '%s'", want, got)
+	}
+	if !Verify(key, msg.Body, sig) {
+		t.Errorf("Verify: Failed to verify the signature '%s'", sig)
+	}
+	if Verify(key, "tampered", sig) {
+		t.Errorf("Verify: Verified a tampered message")
+	}
+	if Verify([]byte("other secret"), msg.Body, sig) {
+		t.Errorf("Verify: Verified the message with the wrong key")
+	}
+}
+
+// reverseEncrypter is an Encrypter that "encrypts" bodies by reversing
+// them.
+type reverseEncrypter struct {
+	err error
+}
+
+func (reverseEncrypter) Scheme() string {
+	return "reverse"
+}
+
+func (r reverseEncrypter) Encrypt(plaintext []byte) ([]byte, map[string]string, error) {
+	if r.err != nil {
+		return nil, nil, r.err
+	}
+
+	out := make([]byte, len(plaintext))
+	for i, b := range plaintext {
+		out[len(out) - 1 - i] = b
+	}
+	return out, map[string]string{"Key": "none"}, nil
+}
+
+// TestWithEncryption checks that messages are sent encrypted, along with
+// the attributes needed to decrypt them.
+func TestWithEncryption(t *testing.T) {
+	ss := sendertest.New()
+	s := WithEncryption(reverseEncrypter{})(ss)
+
+	_, err := s.Send(sender.Message{Body: "secret"})
+	if err != nil {
+		t.Fatalf("Send: Failed to send the message: %+v", err)
+	}
+
+	msg := ss.Messages()[0]
+	if want, got := "terces", msg.Body; want != got {
+		t.Errorf("Send: Expected body '%s' but got '%s'", want, got)
+	}
+	if want, got := "reverse", msg.Attributes[EncryptionAttr]; want != got {
+		t.Errorf("Send: Expected scheme '%s' but got '%s'", want, got)
+	}
+	if want, got := "none", msg.Attributes["Key"]; want != got {
+		t.Errorf("Send: Expected attribute 'Key' to be '%s' but got '%s'", want, got)
+	}
+
+	s = WithEncryption(reverseEncrypter{err: sender.ErrTemporary})(ss)
+	if _, err := s.Send(sender.Message{Body: "secret"}); err != sender.ErrTemporary {
+		t.Errorf("Send: Expected error '%+v' but got '%+v'", sender.ErrTemporary, err)
+	}
+}
+
+// TestAdaptiveThrottle checks that the rate halves on throttling and grows
+// back linearly on success, always within the configured bounds.
+func TestAdaptiveThrottle(t *testing.T) {
+	ss := sendertest.New()
+	at := NewAdaptiveThrottle(AIMDConfig{
+		MinRate: 100,
+		MaxRate: 1000,
+		Increase: 100,
+		Decrease: 0.5,
+	})
+	s := at.Middleware()(ss)
+
+	test_cases := []struct{ err error; rate float64 } {
+		{ err: sender.ErrThrottled, rate: 500 },
+		{ err: sender.ErrThrottled, rate: 250 },
+		{ err: sender.ErrThrottled, rate: 125 },
+		{ err: sender.ErrThrottled, rate: 100 },
+		{ err: sender.ErrSendFailed, rate: 100 },
+		{ rate: 200 },
+		{ rate: 300 },
+	}
+	for i, tc := range test_cases {
+		if tc.err != nil {
+			ss.FailNext(1, tc.err)
+		}
+		s.Send(sender.Message{Body: "throttled"})
+
+		if want, got := tc.rate, at.Rate(); want != got {
+			t.Errorf("%d: Rate: Expected '%.2f' but got '%.2f'", i, want, got)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		s.Send(sender.Message{Body: "fast"})
+	}
+	if want, got := 1000.0, at.Rate(); want != got {
+		t.Errorf("Rate: Expected '%.2f' but got '%.2f'", want, got)
+	}
+}
@@ -0,0 +1,63 @@
+package sendermw
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/SirGFM/sqs-issue-notifier/server/sender"
+	"strings"
+)
+
+// SignatureAttr is the message attribute with the message's signature, as
+// generated by Sign.
+const SignatureAttr = "Signature"
+
+// signaturePrefix identifies the algorithm used to sign messages.
+const signaturePrefix = "hmac-sha256="
+
+// Sign generates the signature of body using key: the hex encoded
+// HMAC-SHA256 of the body, prefixed by "hmac-sha256=".
+func Sign(key []byte, body string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(body))
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks, in constant time, whether signature was generated by Sign
+// for body using key.
+func Verify(key []byte, body, signature string) bool {
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return false
+	}
+
+	want := Sign(key, body)
+	return hmac.Equal([]byte(want), []byte(signature))
+}
+
+// WithSigning signs every message with key, so consumers sharing the key
+// may verify that the message was sent by the notifier. The signature is
+// sent in the attribute SignatureAttr.
+//
+// Messages are signed as sent to the receiver (e.g., after being
+// encrypted), but before being split into chunks, so consumers must verify
+// the reassembled message.
+func WithSigning(key []byte) Middleware {
+	return func(s sender.Sender) sender.Sender {
+		return senderFunc(func(msg sender.Message) (sender.SendResult, error) {
+			msg.Attributes = withAttribute(msg.Attributes, SignatureAttr, Sign(key, msg.Body))
+			return s.Send(msg)
+		})
+	}
+}
+
+// withAttribute copies attrs, adding the attribute name to the copy. The
+// original attributes are left untouched, as they may be shared by messages
+// being retried.
+func withAttribute(attrs map[string]string, name, value string) map[string]string {
+	cp := make(map[string]string, len(attrs) + 1)
+	for k, v := range attrs {
+		cp[k] = v
+	}
+	cp[name] = value
+	return cp
+}
@@ -0,0 +1,60 @@
+package sendermw
+
+import (
+	"encoding/json"
+	"github.com/SirGFM/sqs-issue-notifier/server/sender"
+	"strings"
+	"text/template"
+)
+
+// templateFuncs are the functions available to message templates, other
+// than text/template's builtins.
+var templateFuncs = template.FuncMap{
+	// json encodes a value as JSON (e.g., to embed a string in a JSON
+	// body, properly escaped).
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// ParseTemplate parses text as a template for WithTemplate. Other than
+// text/template's builtins, templates may call "json" to encode a value as
+// JSON.
+func ParseTemplate(text string) (*template.Template, error) {
+	return template.New("message").Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+}
+
+// WithTemplate reshapes each message before it's sent by executing tmpl
+// over the message's body, decoded as a JSON object, and sending the output
+// instead. For example, the template:
+//
+//	{"text": {{json .Message}}, "channel": {{json .Channel}}}
+//
+// renames the fields of the server's messages.
+//
+// Messages that aren't JSON objects, or that fail to be transformed, are
+// rejected with sender.ErrInvalidInput.
+func WithTemplate(tmpl *template.Template) Middleware {
+	return func(s sender.Sender) sender.Sender {
+		return senderFunc(func(msg sender.Message) (sender.SendResult, error) {
+			var fields map[string]interface{}
+
+			err := json.Unmarshal([]byte(msg.Body), &fields)
+			if err != nil {
+				logger().Error("sendermw/WithTemplate: Failed to decode the message", "body", msg.Body, "err", err)
+				return sender.SendResult{}, sender.ErrInvalidInput
+			}
+
+			var body strings.Builder
+			err = tmpl.Execute(&body, fields)
+			if err != nil {
+				logger().Error("sendermw/WithTemplate: Failed to transform the message", "body", msg.Body, "err", err)
+				return sender.SendResult{}, sender.ErrInvalidInput
+			}
+
+			msg.Body = body.String()
+			return s.Send(msg)
+		})
+	}
+}
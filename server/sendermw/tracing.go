@@ -0,0 +1,24 @@
+package sendermw
+
+import (
+	"github.com/SirGFM/sqs-issue-notifier/server/sender"
+)
+
+// Tracer starts a span for every message sent.
+type Tracer interface {
+	// StartSend is called before sending msg. The returned function is
+	// called once the message is sent, with the outcome of Send.
+	StartSend(msg sender.Message) func(res sender.SendResult, err error)
+}
+
+// WithTracing wraps every Send in a span started by t.
+func WithTracing(t Tracer) Middleware {
+	return func(s sender.Sender) sender.Sender {
+		return senderFunc(func(msg sender.Message) (sender.SendResult, error) {
+			end := t.StartSend(msg)
+			res, err := s.Send(msg)
+			end(res, err)
+			return res, err
+		})
+	}
+}
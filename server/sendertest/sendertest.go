@@ -0,0 +1,132 @@
+/*
+Package sendertest implements an in-memory sender.Sender for tests.
+
+The Sender records every message it accepts, and may be programmed to fail
+a given number of times, so code that depends on a sender (e.g., the
+forwarder and its retry logic) may be tested without a SQS.
+
+Example:
+
+	s := sendertest.New()
+
+	// Fail the next 2 messages as if the SQS were unreachable.
+	s.FailNext(2, sender.ErrTemporary)
+
+	// ... exercise the code under test ...
+
+	if !s.WaitFor(1, time.Second) {
+		t.Errorf("Message wasn't sent")
+	}
+	msgs := s.Messages()
+*/
+package sendertest
+
+import (
+	"fmt"
+	"github.com/SirGFM/sqs-issue-notifier/server/sender"
+	"sync"
+	"time"
+)
+
+// Sender is an in-memory sender.Sender. It must be created by New().
+type Sender struct {
+	// Synchronizes access to the fields below, and signals whenever a
+	// message is accepted.
+	cond *sync.Cond
+
+	// Every message accepted, in order.
+	messages []sender.Message
+
+	// Errors returned by the next calls to Send, in order.
+	failures []error
+
+	// Number of calls to Send, including failed ones.
+	attempts int
+}
+
+// New creates an empty Sender that accepts every message.
+func New() *Sender {
+	return &Sender{
+		cond: sync.NewCond(&sync.Mutex{}),
+	}
+}
+
+// FailNext programs the next n calls to Send to fail with err.
+func (s *Sender) FailNext(n int, err error) {
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+
+	for i := 0; i < n; i++ {
+		s.failures = append(s.failures, err)
+	}
+}
+
+func (s *Sender) Send(msg sender.Message) (sender.SendResult, error) {
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+
+	s.attempts++
+	res := sender.SendResult{
+		SentAt: time.Now(),
+		Attempts: 1,
+	}
+
+	if len(s.failures) > 0 {
+		err := s.failures[0]
+		s.failures = s.failures[1:]
+		return res, err
+	}
+
+	s.messages = append(s.messages, msg)
+	res.MessageID = fmt.Sprintf("sendertest-%d", len(s.messages))
+	s.cond.Broadcast()
+	return res, nil
+}
+
+// Messages retrieves a copy of every message accepted so far.
+func (s *Sender) Messages() []sender.Message {
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+
+	return append([]sender.Message{}, s.messages...)
+}
+
+// Attempts retrieves how many times Send was called, including the calls
+// that failed.
+func (s *Sender) Attempts() int {
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+
+	return s.attempts
+}
+
+// Reset forgets every message, attempt and programmed failure.
+func (s *Sender) Reset() {
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+
+	s.messages = nil
+	s.failures = nil
+	s.attempts = 0
+}
+
+// WaitFor blocks until at least n messages were accepted, or until timeout
+// expires. It reports whether the messages were accepted in time.
+func (s *Sender) WaitFor(n int, timeout time.Duration) bool {
+	expired := false
+	timer := time.AfterFunc(timeout, func() {
+		s.cond.L.Lock()
+		expired = true
+		s.cond.L.Unlock()
+		s.cond.Broadcast()
+	})
+	defer timer.Stop()
+
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+
+	for len(s.messages) < n && !expired {
+		s.cond.Wait()
+	}
+	return len(s.messages) >= n
+}
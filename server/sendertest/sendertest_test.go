@@ -0,0 +1,50 @@
+package sendertest
+
+import (
+	"github.com/SirGFM/sqs-issue-notifier/server/sender"
+	"testing"
+	"time"
+)
+
+// TestSender checks that programmed failures are returned in order, and
+// that only accepted messages are recorded.
+func TestSender(t *testing.T) {
+	s := New()
+	s.FailNext(1, sender.ErrTemporary)
+	s.FailNext(1, sender.ErrRejected)
+
+	test_cases := []error{sender.ErrTemporary, sender.ErrRejected, nil}
+	for i, want := range test_cases {
+		res, err := s.Send(sender.Message{Body: "test"})
+		if got := err; want != got {
+			t.Errorf("%d: Send: Expected error '%+v' but got '%+v'", i, want, got)
+		} else if err == nil && len(res.MessageID) == 0 {
+			t.Errorf("%d: Send: No ID was assigned to the message", i)
+		}
+	}
+
+	if want, got := 3, s.Attempts(); want != got {
+		t.Errorf("Attempts: Expected '%d' but got '%d'", want, got)
+	}
+	if want, got := 1, len(s.Messages()); want != got {
+		t.Errorf("Messages: Expected '%d' messages but got '%d'", want, got)
+	}
+
+	// WaitFor must return as soon as a message arrives, and must time out
+	// if none does.
+	go func() {
+		time.Sleep(time.Millisecond)
+		s.Send(sender.Message{Body: "late"})
+	} ()
+	if !s.WaitFor(2, time.Second) {
+		t.Errorf("WaitFor: Timed out waiting for the second message")
+	}
+	if s.WaitFor(3, time.Millisecond) {
+		t.Errorf("WaitFor: Expected to time out waiting for a third message")
+	}
+
+	s.Reset()
+	if want, got := 0, len(s.Messages()); want != got {
+		t.Errorf("Reset: Expected '%d' messages but got '%d'", want, got)
+	}
+}
@@ -0,0 +1,95 @@
+package storemw
+
+import (
+	"errors"
+	"github.com/SirGFM/sqs-issue-notifier/server/health"
+	"github.com/SirGFM/sqs-issue-notifier/server/local_storage"
+	"sync"
+	"syscall"
+)
+
+// healthStore reports the health of the wrapped store.
+type healthStore struct {
+	wrapped
+
+	// Where the store's health is reported.
+	reg *health.Registry
+
+	// The name of the reporting component.
+	component string
+
+	// Synchronizes access to writeErr and readErr.
+	mutex sync.Mutex
+
+	// Why the latest attempt to store data failed. Nil if it succeeded.
+	writeErr error
+
+	// Why the latest attempt to retrieve data failed. Nil if it succeeded.
+	readErr error
+}
+
+// WithHealth reports the store's health to reg, as component: it's failed
+// while data can't be either stored (e.g., if the disk is full) or
+// retrieved, and OK otherwise.
+func WithHealth(reg *health.Registry, component string) Middleware {
+	return func(s local_storage.Store) local_storage.Store {
+		reg.Set(component, health.OK, "")
+		return &healthStore{
+			wrapped: s,
+			reg: reg,
+			component: component,
+		}
+	}
+}
+
+// report the store's health after an attempt to either store (if write) or
+// retrieve data, which failed with err.
+func (hs *healthStore) report(write bool, err error) {
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+
+	if write {
+		hs.writeErr = err
+	} else {
+		hs.readErr = err
+	}
+
+	switch {
+	case errors.Is(hs.writeErr, syscall.ENOSPC):
+		hs.reg.Set(hs.component, health.Failed, "the disk is full, so messages can't be stored")
+	case hs.writeErr != nil:
+		hs.reg.Set(hs.component, health.Failed, "messages can't be stored: " + hs.writeErr.Error())
+	case hs.readErr != nil:
+		hs.reg.Set(hs.component, health.Failed, "messages can't be retrieved: " + hs.readErr.Error())
+	default:
+		hs.reg.Set(hs.component, health.OK, "")
+	}
+}
+
+func (hs *healthStore) Store(data []byte) error {
+	_, err := hs.StorePriority(data, local_storage.PriorityNormal)
+	return err
+}
+
+func (hs *healthStore) StorePriority(data []byte, priority local_storage.Priority) (string, error) {
+	id, err := hs.wrapped.StorePriority(data, priority)
+	switch err {
+	case nil, local_storage.ErrDuplicatedStore:
+		hs.report(true, nil)
+	case local_storage.ErrInvalidPriority:
+		// The request itself is invalid.
+	default:
+		hs.report(true, err)
+	}
+	return id, err
+}
+
+func (hs *healthStore) Get() (local_storage.Data, error) {
+	data, err := hs.wrapped.Get()
+	if err == local_storage.ErrGetEmpty {
+		hs.report(false, nil)
+	} else {
+		hs.report(false, err)
+	}
+	return data, err
+}
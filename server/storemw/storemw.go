@@ -0,0 +1,130 @@
+/*
+Package storemw implements composable decorators for local storages.
+
+Each decorator is a Middleware that wraps a local_storage.Store, layering
+some behaviour (e.g., metrics) onto it, without changing the local storage
+itself. Every method that isn't decorated is forwarded to the wrapped
+store.
+
+Decorators may be applied individually, or combined through "Chain()".
+
+Example:
+
+	reg := metrics.NewRegistry()
+	store := storemw.Chain(local_storage.NewFS(dir, timeout),
+		storemw.WithMetrics(reg, "app_store"),
+	)
+
+	err := store.Store([]byte("some-data"))
+	if err != nil {
+		// handle err
+	}
+*/
+package storemw
+
+import (
+	"github.com/SirGFM/sqs-issue-notifier/server/local_storage"
+	"github.com/SirGFM/sqs-issue-notifier/server/metrics"
+	"time"
+)
+
+// Middleware wraps a local storage, layering some behaviour onto it.
+type Middleware func(s local_storage.Store) local_storage.Store
+
+// Chain wraps s with every middleware, in order. So, the first middleware
+// is the innermost one (i.e., the closest to s).
+func Chain(s local_storage.Store, mws ...Middleware) local_storage.Store {
+	for _, mw := range mws {
+		if mw != nil {
+			s = mw(s)
+		}
+	}
+	return s
+}
+
+// wrapped is the store wrapped by a decorator. It's embedded under this
+// name, as local_storage.Store would conflict with its Store method.
+type wrapped = local_storage.Store
+
+// metricsStore exports the metrics of the wrapped store.
+type metricsStore struct {
+	wrapped
+
+	// Data stored, by its priority.
+	stored *metrics.Counter
+
+	// Data that wasn't stored, as it was already stored.
+	duplicates *metrics.Counter
+
+	// Operations that failed, by the operation ("store" or "get").
+	errors *metrics.Counter
+}
+
+// WithMetrics exports the store's metrics through reg, named after prefix
+// (e.g., "app_store" exports "app_store_queued"): how much is stored, the
+// age of the oldest data, deduplicated data, quarantined files, lock
+// contention, failures and every event.
+func WithMetrics(reg *metrics.Registry, prefix string) Middleware {
+	return func(s local_storage.Store) local_storage.Store {
+		ms := &metricsStore{
+			wrapped: s,
+			stored: reg.Counter(prefix + "_stored_total", "Data stored, by its priority.", "priority"),
+			duplicates: reg.Counter(prefix + "_duplicates_total", "Data that wasn't stored, as it was already stored."),
+			errors: reg.Counter(prefix + "_errors_total", "Operations that failed, by the operation (store or get).", "op"),
+		}
+
+		events := reg.Counter(prefix + "_events_total", "Changes to the stored data, by their type.", "type")
+		s.OnEvent(func(ev local_storage.Event) {
+			events.Inc(ev.Type.String())
+		})
+
+		queued := reg.Gauge(prefix + "_queued", "Data waiting to be retrieved.")
+		oldest := reg.Gauge(prefix + "_oldest_age_seconds", "Age of the oldest data waiting to be retrieved (0 if there's none).")
+		size := reg.Gauge(prefix + "_bytes", "Total size of the stored data, in bytes.")
+		quarantined := reg.Gauge(prefix + "_quarantined", "Corrupted files moved to the quarantine area.")
+		contention := reg.Counter(prefix + "_lock_contention_total", "Times data couldn't be retrieved because another consumer had it locked.")
+		reg.OnCollect(func() {
+			queued.Set(float64(s.Count()))
+
+			if entry, err := s.Oldest(); err == nil {
+				oldest.Set(time.Since(entry.StoredAt).Seconds())
+			} else {
+				oldest.Set(0)
+			}
+
+			if st, err := s.Stats(); err == nil {
+				size.Set(float64(st.Bytes))
+				quarantined.Set(float64(st.Quarantined))
+				contention.Set(float64(st.LockContention))
+			}
+		})
+
+		return ms
+	}
+}
+
+func (ms *metricsStore) Store(data []byte) error {
+	_, err := ms.StorePriority(data, local_storage.PriorityNormal)
+	return err
+}
+
+func (ms *metricsStore) StorePriority(data []byte, priority local_storage.Priority) (string, error) {
+	id, err := ms.wrapped.StorePriority(data, priority)
+	switch err {
+	case nil:
+		ms.stored.Inc(priority.String())
+	case local_storage.ErrDuplicatedStore:
+		ms.duplicates.Inc()
+	default:
+		ms.errors.Inc("store")
+	}
+	return id, err
+}
+
+func (ms *metricsStore) Get() (local_storage.Data, error) {
+	data, err := ms.wrapped.Get()
+	if err != nil && err != local_storage.ErrGetEmpty {
+		ms.errors.Inc("get")
+	}
+	return data, err
+}
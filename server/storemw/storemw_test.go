@@ -0,0 +1,99 @@
+package storemw
+
+import (
+	"bytes"
+	"github.com/SirGFM/sqs-issue-notifier/server/health"
+	"github.com/SirGFM/sqs-issue-notifier/server/local_storage"
+	"github.com/SirGFM/sqs-issue-notifier/server/metrics"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestWithMetrics checks that the store's operations, events and internals
+// are exported.
+func TestWithMetrics(t *testing.T) {
+	reg := metrics.NewRegistry()
+	store := Chain(local_storage.NewFS(t.TempDir(), 0),
+		WithMetrics(reg, "test"),
+	)
+	defer store.Close()
+
+	data := []byte("One, two! One, two! And through and through")
+	if err := store.Store(data); err != nil {
+		t.Fatalf("Store: Failed to store the message: %+v", err)
+	}
+	if _, err := store.StorePriority(data, local_storage.PriorityNormal); err != local_storage.ErrDuplicatedStore {
+		t.Errorf("StorePriority: Expected error '%+v' but got '%+v'", local_storage.ErrDuplicatedStore, err)
+	}
+	if _, err := store.StorePriority([]byte("The vorpal blade went snicker-snack!"), local_storage.PriorityHigh); err != nil {
+		t.Fatalf("StorePriority: Failed to store the message: %+v", err)
+	}
+
+	got, err := store.Get()
+	if err != nil {
+		t.Fatalf("Get: Failed to retrieve the message: %+v", err)
+	}
+	got.Remove()
+
+	var buf bytes.Buffer
+	reg.WriteText(&buf)
+	for _, want := range []string{
+		`test_stored_total{priority="high"} 1`,
+		`test_stored_total{priority="normal"} 1`,
+		`test_duplicates_total 1`,
+		`test_events_total{type="removed"} 1`,
+		`test_events_total{type="stored"} 2`,
+		`test_queued 1`,
+		`test_bytes 43`,
+		`test_quarantined 0`,
+		`test_lock_contention_total 0`,
+	} {
+		if !strings.Contains(buf.String(), want + "\n") {
+			t.Errorf("WriteText: Expected '%s' in:\n%s", want, buf.String())
+		}
+	}
+	if !strings.Contains(buf.String(), "test_oldest_age_seconds ") || strings.Contains(buf.String(), "test_oldest_age_seconds 0\n") {
+		t.Errorf("WriteText: Expected the oldest data's age in:\n%s", buf.String())
+	}
+}
+
+// TestWithHealth checks that the store is failed while data can't be
+// stored, and OK once it can.
+func TestWithHealth(t *testing.T) {
+	dir := t.TempDir()
+	reg := health.NewRegistry()
+	store := Chain(local_storage.NewFS(dir, 0),
+		WithHealth(reg, "store"),
+	)
+	defer store.Close()
+
+	if got, _ := reg.Get("store"); got.Status != health.OK {
+		t.Errorf("WithHealth: Expected the store to be '%s' but got '%+v'", health.OK, got)
+	}
+
+	// Without its directory, nothing can be stored.
+	err := os.RemoveAll(dir)
+	if err != nil {
+		t.Fatalf("Failed to remove the directory: %+v", err)
+	}
+	data := []byte("And, as in uffish thought he stood")
+	if err := store.Store(data); err == nil {
+		t.Errorf("Store: Expected the message not to be stored")
+	}
+	if got, _ := reg.Get("store"); got.Status != health.Failed {
+		t.Errorf("Store: Expected the store to be '%s' but got '%+v'", health.Failed, got)
+	}
+
+	err = os.MkdirAll(filepath.Join(dir, ".lock"), 0755)
+	if err != nil {
+		t.Fatalf("Failed to recreate the directory: %+v", err)
+	}
+	if err := store.Store(data); err != nil {
+		t.Errorf("Store: Failed to store the message: %+v", err)
+	}
+	if got, _ := reg.Get("store"); got.Status != health.OK {
+		t.Errorf("Store: Expected the store to be '%s' but got '%+v'", health.OK, got)
+	}
+}
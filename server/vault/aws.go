@@ -0,0 +1,61 @@
+package vault
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"time"
+)
+
+// ProviderName identifies the credentials retrieved from Vault.
+const ProviderName = "VaultProvider"
+
+// awsExpiryWindow specifies how long before their lease expires the AWS
+// credentials are refreshed.
+const awsExpiryWindow = time.Minute
+
+// neverExpires is used as the lease of credentials without any, as the
+// AWS SDK considers credentials without an expiration always expired.
+const neverExpires = 100 * 365 * 24 * time.Hour
+
+// awsProvider retrieves AWS credentials from Vault's AWS secrets engine.
+type awsProvider struct {
+	credentials.Expiry
+
+	// Reads from Vault.
+	c *Client
+
+	// Path of the credentials (e.g., "aws/creds/notifier").
+	path string
+}
+
+// AWSCredentials creates AWS credentials read from path (e.g.,
+// "aws/creds/notifier" or "aws/sts/notifier"), in Vault's AWS secrets
+// engine. The credentials are read once they're first used, and read again
+// shortly before their lease expires.
+func (c *Client) AWSCredentials(path string) *credentials.Credentials {
+	return credentials.NewCredentials(&awsProvider{
+		c: c,
+		path: path,
+	})
+}
+
+func (p *awsProvider) Retrieve() (credentials.Value, error) {
+	data, lease, err := p.c.read(p.path)
+	if err != nil {
+		return credentials.Value{ProviderName: ProviderName}, err
+	}
+
+	value := credentials.Value{ProviderName: ProviderName}
+	value.AccessKeyID, _ = data["access_key"].(string)
+	value.SecretAccessKey, _ = data["secret_key"].(string)
+	value.SessionToken, _ = data["security_token"].(string)
+	if len(value.AccessKeyID) == 0 || len(value.SecretAccessKey) == 0 {
+		return value, fmt.Errorf("'%s', key 'access_key'/'secret_key': %w", p.path, ErrNoKey)
+	}
+
+	if lease <= 0 {
+		lease = neverExpires
+	}
+	p.SetExpiration(time.Now().Add(lease), awsExpiryWindow)
+	return value, nil
+}
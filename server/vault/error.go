@@ -0,0 +1,37 @@
+package vault
+
+type error_code uint
+
+const (
+	// The authentication method isn't one of token, approle or kubernetes.
+	ErrInvalidMethod error_code = iota
+	// Vault didn't accept the credentials.
+	ErrLoginFailed
+	// The path doesn't exist in Vault.
+	ErrNotFound
+	// The client isn't allowed to read the path.
+	ErrForbidden
+	// Vault replied with an unexpected error.
+	ErrUnexpected
+	// The secret doesn't have the requested key.
+	ErrNoKey
+)
+
+func (e error_code) Error() string {
+	switch e {
+	case ErrInvalidMethod:
+		return "The authentication method isn't one of token, approle or kubernetes."
+	case ErrLoginFailed:
+		return "Vault didn't accept the credentials."
+	case ErrNotFound:
+		return "The path doesn't exist in Vault."
+	case ErrForbidden:
+		return "The client isn't allowed to read the path."
+	case ErrUnexpected:
+		return "Vault replied with an unexpected error."
+	case ErrNoKey:
+		return "The secret doesn't have the requested key."
+	default:
+		return "Invalid vault error."
+	}
+}
@@ -0,0 +1,362 @@
+/*
+Package vault reads secrets and short-lived AWS credentials from HashiCorp
+Vault, through its HTTP API.
+
+The client authenticates either with a fixed token, with an AppRole (a role
+ID and a secret ID), or with a Kubernetes service account (whose token is
+re-read on every login, as it's rotated by Kubernetes). Tokens are renewed
+(or, if that fails, obtained again) before they expire, so a long running
+process may keep reading from Vault.
+
+Secrets are read from any engine that replies with the secret's fields in
+its data, including both versions of the KV engine. AWS credentials are
+read from the AWS secrets engine, and are refreshed before their lease
+expires, through the AWS SDK's credentials.
+
+Example:
+
+	c, err := vault.New(vault.Options{
+		Addr: "https://vault:8200",
+		Method: vault.MethodAppRole,
+		RoleID: roleID,
+		SecretID: secretID,
+	})
+	if err != nil {
+		// handle err
+	}
+
+	// Read the field "github" of the KV (version 2) secret "notifier".
+	secret, err := c.Fetch("secret/data/notifier#github")
+	if err != nil {
+		// handle err
+	}
+
+	// Credentials for the AWS SDK, refreshed automatically.
+	creds := c.AWSCredentials("aws/creds/notifier")
+*/
+package vault
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// MethodToken authenticates with a fixed token.
+	MethodToken = "token"
+	// MethodAppRole authenticates with an AppRole's role ID and secret ID.
+	MethodAppRole = "approle"
+	// MethodKubernetes authenticates with a Kubernetes service account.
+	MethodKubernetes = "kubernetes"
+)
+
+// DefaultKubernetesTokenFile is where Kubernetes mounts the service
+// account's token, used by MethodKubernetes.
+const DefaultKubernetesTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// DefaultKey is the secret's field read if the reference doesn't select
+// any.
+const DefaultKey = "value"
+
+// tokenExpiryWindow specifies how long before expiring the token is
+// renewed.
+const tokenExpiryWindow = time.Minute
+
+// Options configures how Vault is accessed.
+type Options struct {
+	// Vault's address (e.g., "https://vault:8200").
+	Addr string
+
+	// How the client authenticates: either MethodToken, MethodAppRole or
+	// MethodKubernetes.
+	Method string
+
+	// The token, for MethodToken.
+	Token string
+
+	// The AppRole's role ID, for MethodAppRole.
+	RoleID string
+
+	// The AppRole's secret ID, for MethodAppRole.
+	SecretID string
+
+	// The Vault role bound to the service account, for MethodKubernetes.
+	Role string
+
+	// File with the service account's token, for MethodKubernetes.
+	// Defaults to DefaultKubernetesTokenFile.
+	JWTFile string
+
+	// Path where the authentication method is mounted. Defaults to the
+	// method's name (e.g., "approle").
+	Mount string
+
+	// Vault Enterprise namespace of the requests. Leave it empty to use
+	// the root namespace.
+	Namespace string
+
+	// PEM file with the CAs used to verify Vault. Defaults to the
+	// system's CAs.
+	CAFile string
+
+	// Timeout for each request made to Vault. Defaults to no timeout.
+	Timeout time.Duration
+}
+
+// Client reads from Vault. It must be created by New(), and may be used by
+// many goroutines at once.
+type Client struct {
+	// How Vault is accessed.
+	opts Options
+
+	// Sends the requests to Vault.
+	http *http.Client
+
+	// Synchronizes access to token, renewable and expires.
+	mutex sync.Mutex
+
+	// The current token. Empty until the first login.
+	token string
+
+	// Whether the token may be renewed.
+	renewable bool
+
+	// When the token expires. Zero if it never expires.
+	expires time.Time
+}
+
+// New creates a client for Vault, as configured by opts. Vault isn't
+// contacted until something is read.
+func New(opts Options) (*Client, error) {
+	switch opts.Method {
+	case MethodToken, MethodAppRole, MethodKubernetes:
+	default:
+		return nil, ErrInvalidMethod
+	}
+	if len(opts.Mount) == 0 {
+		opts.Mount = opts.Method
+	}
+	if len(opts.JWTFile) == 0 {
+		opts.JWTFile = DefaultKubernetesTokenFile
+	}
+	opts.Addr = strings.TrimSuffix(opts.Addr, "/")
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if len(opts.CAFile) > 0 {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in '%s'", opts.CAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	c := &Client{
+		opts: opts,
+		http: &http.Client{
+			Transport: transport,
+			Timeout: opts.Timeout,
+		},
+	}
+	if opts.Method == MethodToken {
+		c.token = opts.Token
+	}
+	return c, nil
+}
+
+// response is the body of Vault's replies.
+type response struct {
+	// The secret's fields.
+	Data map[string]any `json:"data"`
+
+	// For how long, in seconds, the secret is valid.
+	LeaseDuration int `json:"lease_duration"`
+
+	// The token obtained by a login (or renewed).
+	Auth *struct {
+		ClientToken string `json:"client_token"`
+		LeaseDuration int `json:"lease_duration"`
+		Renewable bool `json:"renewable"`
+	} `json:"auth"`
+}
+
+// do sends a request to Vault, on path (e.g., "auth/approle/login"), with
+// token (if any), decoding the reply into resp.
+func (c *Client) do(method, path, token string, body any, resp *response) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.opts.Addr + "/v1/" + strings.TrimPrefix(path, "/"), reader)
+	if err != nil {
+		return err
+	}
+	if len(token) > 0 {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if len(c.opts.Namespace) > 0 {
+		req.Header.Set("X-Vault-Namespace", c.opts.Namespace)
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusForbidden:
+		return ErrForbidden
+	default:
+		return fmt.Errorf("%s %s: %s: %w", method, path, res.Status, ErrUnexpected)
+	}
+
+	return json.NewDecoder(res.Body).Decode(resp)
+}
+
+// login obtains a new token. Must be called with the mutex locked.
+func (c *Client) login() error {
+	var body map[string]string
+	switch c.opts.Method {
+	case MethodToken:
+		// The token is fixed, so there's nothing to do.
+		return nil
+	case MethodAppRole:
+		body = map[string]string{
+			"role_id": c.opts.RoleID,
+			"secret_id": c.opts.SecretID,
+		}
+	case MethodKubernetes:
+		jwt, err := os.ReadFile(c.opts.JWTFile)
+		if err != nil {
+			return err
+		}
+		body = map[string]string{
+			"role": c.opts.Role,
+			"jwt": strings.TrimSpace(string(jwt)),
+		}
+	}
+
+	var resp response
+	err := c.do(http.MethodPost, "auth/" + c.opts.Mount + "/login", "", body, &resp)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrLoginFailed, err)
+	}
+	c.setToken(resp)
+	return nil
+}
+
+// setToken keeps the token from resp, a login's (or renewal's) reply. Must
+// be called with the mutex locked.
+func (c *Client) setToken(resp response) {
+	if resp.Auth == nil {
+		return
+	}
+
+	c.token = resp.Auth.ClientToken
+	c.renewable = resp.Auth.Renewable
+	c.expires = time.Time{}
+	if resp.Auth.LeaseDuration > 0 {
+		c.expires = time.Now().Add(time.Duration(resp.Auth.LeaseDuration) * time.Second)
+	}
+}
+
+// currentToken retrieves a valid token, renewing it (or logging in again)
+// if it's about to expire.
+func (c *Client) currentToken() (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.token) > 0 && (c.expires.IsZero() || time.Until(c.expires) > tokenExpiryWindow) {
+		return c.token, nil
+	}
+
+	if len(c.token) > 0 && c.renewable && time.Now().Before(c.expires) {
+		var resp response
+		err := c.do(http.MethodPost, "auth/token/renew-self", c.token, struct{}{}, &resp)
+		if err == nil && resp.Auth != nil {
+			c.setToken(resp)
+			return c.token, nil
+		}
+	}
+
+	err := c.login()
+	if err != nil {
+		return "", err
+	}
+	return c.token, nil
+}
+
+// read the secret at path, retrieving its fields and for how long it's
+// valid. A KV (version 2) secret's fields are unwrapped from its metadata.
+func (c *Client) read(path string) (map[string]any, time.Duration, error) {
+	token, err := c.currentToken()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var resp response
+	err = c.do(http.MethodGet, path, token, nil, &resp)
+	if err == ErrForbidden && c.opts.Method != MethodToken {
+		// The token may have been revoked, so log in again.
+		c.mutex.Lock()
+		err = c.login()
+		token = c.token
+		c.mutex.Unlock()
+		if err == nil {
+			err = c.do(http.MethodGet, path, token, nil, &resp)
+		}
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("'%s': %w", path, err)
+	}
+
+	data := resp.Data
+	if inner, ok := data["data"].(map[string]any); ok {
+		if _, ok := data["metadata"]; ok {
+			data = inner
+		}
+	}
+	return data, time.Duration(resp.LeaseDuration) * time.Second, nil
+}
+
+// Fetch the field of a secret identified by name, as "<path>#<key>" (e.g.,
+// "secret/data/notifier#github"). If the key is omitted, DefaultKey is
+// read.
+func (c *Client) Fetch(name string) (string, error) {
+	path, key, ok := strings.Cut(name, "#")
+	if !ok {
+		key = DefaultKey
+	}
+
+	data, _, err := c.read(path)
+	if err != nil {
+		return "", err
+	}
+
+	value, ok := data[key].(string)
+	if !ok {
+		return "", fmt.Errorf("'%s', key '%s': %w", path, key, ErrNoKey)
+	}
+	return value, nil
+}
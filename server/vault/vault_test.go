@@ -0,0 +1,178 @@
+package vault
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeVault simulates Vault, accepting a single AppRole and counting the
+// logins.
+type fakeVault struct {
+	logins int
+	leaseSeconds int
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	reply := func(v any) {
+		json.NewEncoder(w).Encode(v)
+	}
+
+	if req.URL.Path == "/v1/auth/approle/login" {
+		var body map[string]string
+		json.NewDecoder(req.Body).Decode(&body)
+		if body["role_id"] != "role" || body["secret_id"] != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.logins++
+		reply(map[string]any{"auth": map[string]any{
+			"client_token": "token",
+			"lease_duration": f.leaseSeconds,
+		}})
+		return
+	}
+
+	if req.Header.Get("X-Vault-Token") != "token" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	switch req.URL.Path {
+	case "/v1/secret/data/notifier":
+		reply(map[string]any{"data": map[string]any{
+			"data": map[string]any{"github": "gh-token", "value": "default"},
+			"metadata": map[string]any{"version": 1},
+		}})
+	case "/v1/kv/notifier":
+		reply(map[string]any{"data": map[string]any{"value": "v1"}})
+	case "/v1/aws/creds/notifier":
+		reply(map[string]any{
+			"lease_duration": 3600,
+			"data": map[string]any{
+				"access_key": "AKID",
+				"secret_key": "SECRET",
+				"security_token": "SESSION",
+			},
+		})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// TestFetch checks that secrets are read from both versions of the KV
+// engine, logging in only once while the token is valid.
+func TestFetch(t *testing.T) {
+	f := &fakeVault{leaseSeconds: 3600}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	c, err := New(Options{
+		Addr: srv.URL,
+		Method: MethodAppRole,
+		RoleID: "role",
+		SecretID: "secret",
+	})
+	if err != nil {
+		t.Fatalf("Couldn't create the client: %+v", err)
+	}
+
+	for _, tc := range []struct{
+		name, want string
+	}{
+		{ "secret/data/notifier#github", "gh-token" },
+		{ "secret/data/notifier", "default" },
+		{ "kv/notifier#value", "v1" },
+	} {
+		got, err := c.Fetch(tc.name)
+		if err != nil {
+			t.Errorf("Couldn't fetch '%s': %+v", tc.name, err)
+		} else if got != tc.want {
+			t.Errorf("Fetched '%s' from '%s', expected '%s'", got, tc.name, tc.want)
+		}
+	}
+	if f.logins != 1 {
+		t.Errorf("Logged in %d times, expected 1", f.logins)
+	}
+
+	_, err = c.Fetch("secret/data/missing")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Fetching a missing secret failed with '%v', expected '%v'", err, ErrNotFound)
+	}
+	_, err = c.Fetch("kv/notifier#missing")
+	if !errors.Is(err, ErrNoKey) {
+		t.Errorf("Fetching a missing key failed with '%v', expected '%v'", err, ErrNoKey)
+	}
+}
+
+// TestLogin checks that an expiring token is replaced, and that invalid
+// credentials are reported.
+func TestLogin(t *testing.T) {
+	// The lease is shorter than the expiry window, so every read logs in.
+	f := &fakeVault{leaseSeconds: 1}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	c, _ := New(Options{
+		Addr: srv.URL,
+		Method: MethodAppRole,
+		RoleID: "role",
+		SecretID: "secret",
+	})
+	for i := 0; i < 2; i++ {
+		if _, err := c.Fetch("kv/notifier"); err != nil {
+			t.Fatalf("Couldn't fetch the secret: %+v", err)
+		}
+	}
+	if f.logins != 2 {
+		t.Errorf("Logged in %d times, expected 2", f.logins)
+	}
+
+	c, _ = New(Options{
+		Addr: srv.URL,
+		Method: MethodAppRole,
+		RoleID: "role",
+		SecretID: "wrong",
+	})
+	_, err := c.Fetch("kv/notifier")
+	if !errors.Is(err, ErrLoginFailed) {
+		t.Errorf("Fetching with invalid credentials failed with '%v', expected '%v'", err, ErrLoginFailed)
+	}
+
+	_, err = New(Options{Addr: srv.URL, Method: "ldap"})
+	if err != ErrInvalidMethod {
+		t.Errorf("Creating a client for 'ldap' failed with '%v', expected '%v'", err, ErrInvalidMethod)
+	}
+}
+
+// TestAWSCredentials checks that AWS credentials are read from the AWS
+// secrets engine.
+func TestAWSCredentials(t *testing.T) {
+	srv := httptest.NewServer(&fakeVault{leaseSeconds: 3600})
+	defer srv.Close()
+
+	c, _ := New(Options{
+		Addr: srv.URL,
+		Method: MethodToken,
+		Token: "token",
+	})
+	creds := c.AWSCredentials("aws/creds/notifier")
+
+	v, err := creds.Get()
+	if err != nil {
+		t.Fatalf("Couldn't get the credentials: %+v", err)
+	}
+	if v.AccessKeyID != "AKID" || v.SecretAccessKey != "SECRET" || v.SessionToken != "SESSION" {
+		t.Errorf("Got unexpected credentials: %+v", v)
+	}
+	if creds.IsExpired() {
+		t.Errorf("The credentials expired right after being read")
+	}
+
+	_, err = c.AWSCredentials("aws/creds/missing").Get()
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Getting missing credentials failed with '%v', expected '%v'", err, ErrNotFound)
+	}
+}
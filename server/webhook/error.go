@@ -0,0 +1,33 @@
+package webhook
+
+type error_code uint
+
+const (
+	// The request isn't signed.
+	ErrMissingSignature error_code = iota
+	// The request's signature doesn't match its body.
+	ErrInvalidSignature
+	// The webhook's payload couldn't be decoded.
+	ErrInvalidPayload
+	// The event isn't converted into notifications.
+	ErrIgnoredEvent
+	// The request's timestamp is too old (or in the future).
+	ErrStaleRequest
+)
+
+func (e error_code) Error() string {
+	switch e {
+	case ErrMissingSignature:
+		return "The request isn't signed."
+	case ErrInvalidSignature:
+		return "The request's signature doesn't match its body."
+	case ErrInvalidPayload:
+		return "The webhook's payload couldn't be decoded."
+	case ErrIgnoredEvent:
+		return "The event isn't converted into notifications."
+	case ErrStaleRequest:
+		return "The request's timestamp is too old (or in the future)."
+	default:
+		return "Invalid webhook error."
+	}
+}
@@ -0,0 +1,114 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	// Header with the HMAC-SHA256 of GitHub's webhooks, as
+	// "sha256=<hex digest>".
+	GitHubSignatureHeader = "X-Hub-Signature-256"
+
+	// Header with the type of GitHub's events (e.g., "issues").
+	GitHubEventHeader = "X-GitHub-Event"
+)
+
+// VerifyGitHub checks that signature, retrieved from the
+// GitHubSignatureHeader, is the HMAC-SHA256 of body using secret.
+func VerifyGitHub(secret, body []byte, signature string) error {
+	if len(signature) == 0 {
+		return ErrMissingSignature
+	}
+
+	digest, ok := strings.CutPrefix(signature, "sha256=")
+	if !ok {
+		return ErrInvalidSignature
+	}
+	got, err := hex.DecodeString(digest)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// githubItem is the part of GitHub's issues and pull requests used in
+// notifications.
+type githubItem struct {
+	Number int `json:"number"`
+	Title string `json:"title"`
+	HTMLURL string `json:"html_url"`
+	Merged bool `json:"merged"`
+}
+
+// githubPayload is the part of GitHub's issues and pull_request events
+// used in notifications.
+type githubPayload struct {
+	Action string `json:"action"`
+	Issue *githubItem `json:"issue"`
+	PullRequest *githubItem `json:"pull_request"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+	Sender struct {
+		Login string `json:"login"`
+	} `json:"sender"`
+}
+
+// ParseGitHub converts GitHub's issues and pull_request events into a
+// notification for the event's repository (e.g., "octocat/hello-world").
+// Other events (e.g., the "ping" sent once the webhook is created) fail
+// with ErrIgnoredEvent.
+func ParseGitHub(event string, body []byte) (Notification, error) {
+	var kind string
+	switch event {
+	case "issues":
+		kind = "Issue"
+	case "pull_request":
+		kind = "Pull request"
+	default:
+		return Notification{}, ErrIgnoredEvent
+	}
+
+	var payload githubPayload
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		return Notification{}, ErrInvalidPayload
+	}
+
+	item := payload.Issue
+	if event == "pull_request" {
+		item = payload.PullRequest
+	}
+	if item == nil || len(payload.Repository.FullName) == 0 || len(payload.Action) == 0 {
+		return Notification{}, ErrInvalidPayload
+	}
+
+	action := strings.ReplaceAll(payload.Action, "_", " ")
+	if payload.Action == "closed" && item.Merged {
+		action = "merged"
+	}
+
+	msg := fmt.Sprintf("%s #%d %s: %s", kind, item.Number, action, item.Title)
+	if len(payload.Sender.Login) > 0 {
+		msg += fmt.Sprintf(" (by %s)", payload.Sender.Login)
+	}
+	if len(item.HTMLURL) > 0 {
+		msg += "\n" + item.HTMLURL
+	}
+
+	return Notification{
+		Channel: payload.Repository.FullName,
+		Message: msg,
+	}, nil
+}
@@ -0,0 +1,101 @@
+package webhook
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// Header with the secret token configured on GitLab's webhooks.
+	GitLabTokenHeader = "X-Gitlab-Token"
+
+	// Header with the type of GitLab's events (e.g., "Issue Hook").
+	GitLabEventHeader = "X-Gitlab-Event"
+)
+
+// VerifyGitLab checks that token, retrieved from the GitLabTokenHeader,
+// matches secret.
+func VerifyGitLab(secret []byte, token string) error {
+	if len(token) == 0 {
+		return ErrMissingSignature
+	} else if subtle.ConstantTimeCompare(secret, []byte(token)) != 1 {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// gitlabPayload is the part of GitLab's issue and merge request events
+// used in notifications.
+type gitlabPayload struct {
+	ObjectAttributes *struct {
+		IID int `json:"iid"`
+		Title string `json:"title"`
+		URL string `json:"url"`
+		Action string `json:"action"`
+	} `json:"object_attributes"`
+	Project struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+	} `json:"project"`
+	User struct {
+		Username string `json:"username"`
+	} `json:"user"`
+}
+
+// gitlabActions describes the actions of GitLab's events in the past
+// tense, as used by GitHub.
+var gitlabActions = map[string]string{
+	"open": "opened",
+	"close": "closed",
+	"reopen": "reopened",
+	"update": "updated",
+	"merge": "merged",
+	"approved": "approved",
+	"unapproved": "unapproved",
+}
+
+// ParseGitLab converts GitLab's issue and merge request events into a
+// notification for the event's project (e.g., "group/project"). Other
+// events fail with ErrIgnoredEvent.
+func ParseGitLab(event string, body []byte) (Notification, error) {
+	var kind, ref string
+	switch event {
+	case "Issue Hook", "Confidential Issue Hook":
+		kind, ref = "Issue", "#"
+	case "Merge Request Hook":
+		kind, ref = "Merge request", "!"
+	default:
+		return Notification{}, ErrIgnoredEvent
+	}
+
+	var payload gitlabPayload
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		return Notification{}, ErrInvalidPayload
+	}
+
+	attrs := payload.ObjectAttributes
+	if attrs == nil || len(payload.Project.PathWithNamespace) == 0 {
+		return Notification{}, ErrInvalidPayload
+	}
+
+	action, ok := gitlabActions[attrs.Action]
+	if !ok && len(attrs.Action) > 0 {
+		action = attrs.Action
+	} else if !ok {
+		action = "updated"
+	}
+
+	msg := fmt.Sprintf("%s %s%d %s: %s", kind, ref, attrs.IID, action, attrs.Title)
+	if len(payload.User.Username) > 0 {
+		msg += fmt.Sprintf(" (by %s)", payload.User.Username)
+	}
+	if len(attrs.URL) > 0 {
+		msg += "\n" + attrs.URL
+	}
+
+	return Notification{
+		Channel: payload.Project.PathWithNamespace,
+		Message: msg,
+	}, nil
+}
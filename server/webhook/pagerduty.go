@@ -0,0 +1,92 @@
+package webhook
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PagerDutyEvent is an event in the format of PagerDuty's Events API v2.
+// Fields not used in notifications (e.g., links and images) are omitted.
+type PagerDutyEvent struct {
+	// Identifies the channel that should receive the event.
+	RoutingKey string `json:"routing_key"`
+
+	// Either "trigger", "acknowledge" or "resolve".
+	EventAction string `json:"event_action"`
+
+	// Identifies the alert that the event refers to. Required to
+	// acknowledge or resolve an alert.
+	DedupKey string `json:"dedup_key"`
+
+	// Describes the alert. Required to trigger an alert.
+	Payload *struct {
+		Summary string `json:"summary"`
+		Source string `json:"source"`
+		Severity string `json:"severity"`
+		Component string `json:"component"`
+	} `json:"payload"`
+}
+
+// Validate checks whether the event is valid, as done by PagerDuty,
+// describing every problem found.
+func (ev PagerDutyEvent) Validate() []string {
+	var problems []string
+
+	if len(ev.RoutingKey) == 0 {
+		problems = append(problems, "'routing_key' is missing.")
+	}
+
+	switch ev.EventAction {
+	case "trigger":
+		p := ev.Payload
+		if p == nil {
+			problems = append(problems, "'payload' is missing.")
+			break
+		}
+		if len(p.Summary) == 0 {
+			problems = append(problems, "'payload.summary' is missing.")
+		}
+		if len(p.Source) == 0 {
+			problems = append(problems, "'payload.source' is missing.")
+		}
+		switch p.Severity {
+		case "critical", "error", "warning", "info":
+		default:
+			problems = append(problems, "'payload.severity' must be one of critical, error, warning or info.")
+		}
+	case "acknowledge", "resolve":
+		if len(ev.DedupKey) == 0 {
+			problems = append(problems, "'dedup_key' is required to " + ev.EventAction + " an alert.")
+		}
+	default:
+		problems = append(problems, "'event_action' must be one of trigger, acknowledge or resolve.")
+	}
+
+	return problems
+}
+
+// Notification converts a valid event into a notification for the
+// routing key's channel.
+func (ev PagerDutyEvent) Notification() Notification {
+	var msg string
+	switch ev.EventAction {
+	case "trigger":
+		msg = fmt.Sprintf("[%s] %s (source: %s", strings.ToUpper(ev.Payload.Severity), ev.Payload.Summary, ev.Payload.Source)
+		if len(ev.Payload.Component) > 0 {
+			msg += ", component: " + ev.Payload.Component
+		}
+		msg += ")"
+	case "acknowledge":
+		msg = "Acknowledged"
+	case "resolve":
+		msg = "Resolved"
+	}
+	if len(ev.DedupKey) > 0 {
+		msg += "\ndedup_key: " + ev.DedupKey
+	}
+
+	return Notification{
+		Channel: ev.RoutingKey,
+		Message: msg,
+	}
+}
@@ -0,0 +1,108 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"strings"
+)
+
+const (
+	// Header with the HMAC-SHA256 of Sentry's webhooks, as a hex digest.
+	SentrySignatureHeader = "Sentry-Hook-Signature"
+
+	// Header with the type of Sentry's webhooks (e.g., "event_alert").
+	SentryResourceHeader = "Sentry-Hook-Resource"
+)
+
+// VerifySentry checks that signature, retrieved from the
+// SentrySignatureHeader, is the HMAC-SHA256 of body using the
+// integration's client secret.
+func VerifySentry(secret, body []byte, signature string) error {
+	if len(signature) == 0 {
+		return ErrMissingSignature
+	}
+
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// sentryPayload is the part of Sentry's issue alerts used in
+// notifications.
+type sentryPayload struct {
+	Data *struct {
+		Event *struct {
+			Title string `json:"title"`
+			Culprit string `json:"culprit"`
+			Project json.Number `json:"project"`
+			URL string `json:"url"`
+			WebURL string `json:"web_url"`
+		} `json:"event"`
+	} `json:"data"`
+}
+
+// sentryProject retrieves the project's slug from the event's API URL
+// (".../projects/<organization>/<project>/events/<id>/").
+func sentryProject(apiURL string) string {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return ""
+	}
+
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i := range parts {
+		if parts[i] == "projects" && i + 2 < len(parts) {
+			return parts[i + 2]
+		}
+	}
+	return ""
+}
+
+// ParseSentry converts Sentry's issue alerts (the "event_alert" resource)
+// into a notification for the event's project, identified by its slug
+// (or by its ID, if the slug isn't available). Other resources fail with
+// ErrIgnoredEvent.
+func ParseSentry(resource string, body []byte) (Notification, error) {
+	if resource != "event_alert" {
+		return Notification{}, ErrIgnoredEvent
+	}
+
+	var payload sentryPayload
+	err := json.Unmarshal(body, &payload)
+	if err != nil || payload.Data == nil || payload.Data.Event == nil {
+		return Notification{}, ErrInvalidPayload
+	}
+	event := payload.Data.Event
+
+	channel := sentryProject(event.URL)
+	if len(channel) == 0 {
+		channel = event.Project.String()
+	}
+	if len(channel) == 0 || len(event.Title) == 0 {
+		return Notification{}, ErrInvalidPayload
+	}
+
+	msg := event.Title
+	if len(event.Culprit) > 0 {
+		msg += " in " + event.Culprit
+	}
+	if len(event.WebURL) > 0 {
+		msg += "\n" + event.WebURL
+	}
+
+	return Notification{
+		Channel: channel,
+		Message: msg,
+	}, nil
+}
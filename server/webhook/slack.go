@@ -0,0 +1,149 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Header with the HMAC-SHA256 of Slack's requests, as
+	// "v0=<hex digest>".
+	SlackSignatureHeader = "X-Slack-Signature"
+
+	// Header with the time, in seconds since the epoch, when Slack sent
+	// the request.
+	SlackTimestampHeader = "X-Slack-Request-Timestamp"
+)
+
+// How far from the current time Slack's requests may have been sent,
+// to prevent replay attacks.
+const SlackMaxSkew = 5 * time.Minute
+
+// VerifySlack checks that signature, retrieved from the
+// SlackSignatureHeader, is the HMAC-SHA256 of timestamp and body, using
+// the app's signing secret, and that timestamp, retrieved from the
+// SlackTimestampHeader, is within SlackMaxSkew of now.
+func VerifySlack(secret, body []byte, timestamp, signature string, now time.Time) error {
+	if len(signature) == 0 || len(timestamp) == 0 {
+		return ErrMissingSignature
+	}
+
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	} else if skew := now.Sub(time.Unix(secs, 0)); skew > SlackMaxSkew || skew < -SlackMaxSkew {
+		return ErrStaleRequest
+	}
+
+	digest, ok := strings.CutPrefix(signature, "v0=")
+	if !ok {
+		return ErrInvalidSignature
+	}
+	got, err := hex.DecodeString(digest)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Matches the users, channels and links mentioned in Slack's messages
+// (e.g., "<@U0123>").
+var slackMention = regexp.MustCompile(`<[@#!][^>]*>`)
+
+// parseSlackText converts text, as "<channel> <message>", into a
+// notification. Mentions (e.g., of the app itself) are ignored.
+func parseSlackText(text string) (Notification, error) {
+	text = strings.TrimSpace(slackMention.ReplaceAllString(text, ""))
+
+	channel, msg, _ := strings.Cut(text, " ")
+	msg = strings.TrimSpace(msg)
+	if len(channel) == 0 || len(msg) == 0 {
+		return Notification{}, ErrInvalidPayload
+	}
+
+	return Notification{
+		Channel: channel,
+		Message: msg,
+	}, nil
+}
+
+// ParseSlackCommand converts a slash command, sent as a form with the
+// command's text as "<channel> <message>", into a notification.
+func ParseSlackCommand(body []byte) (Notification, error) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return Notification{}, ErrInvalidPayload
+	}
+
+	return parseSlackText(form.Get("text"))
+}
+
+// slackPayload is the part of Slack's Events API requests used in
+// notifications.
+type slackPayload struct {
+	Type string `json:"type"`
+	Challenge string `json:"challenge"`
+	Event *struct {
+		Type string `json:"type"`
+		Subtype string `json:"subtype"`
+		ChannelType string `json:"channel_type"`
+		BotID string `json:"bot_id"`
+		Text string `json:"text"`
+	} `json:"event"`
+}
+
+// ParseSlackEvent converts the Events API's app mentions and direct
+// messages, with the text as "<channel> <message>", into a notification.
+//
+// If Slack is verifying the endpoint, the returned challenge must be sent
+// back. Other events (including messages sent by bots) fail with
+// ErrIgnoredEvent.
+func ParseSlackEvent(body []byte) (n Notification, challenge string, err error) {
+	var payload slackPayload
+	err = json.Unmarshal(body, &payload)
+	if err != nil {
+		return Notification{}, "", ErrInvalidPayload
+	}
+
+	switch payload.Type {
+	case "url_verification":
+		if len(payload.Challenge) == 0 {
+			return Notification{}, "", ErrInvalidPayload
+		}
+		return Notification{}, payload.Challenge, nil
+	case "event_callback":
+	default:
+		return Notification{}, "", ErrIgnoredEvent
+	}
+
+	event := payload.Event
+	if event == nil {
+		return Notification{}, "", ErrInvalidPayload
+	} else if len(event.BotID) > 0 || len(event.Subtype) > 0 {
+		return Notification{}, "", ErrIgnoredEvent
+	}
+
+	switch {
+	case event.Type == "app_mention":
+	case event.Type == "message" && event.ChannelType == "im":
+	default:
+		return Notification{}, "", ErrIgnoredEvent
+	}
+
+	n, err = parseSlackText(event.Text)
+	return n, "", err
+}
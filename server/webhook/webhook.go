@@ -0,0 +1,20 @@
+// Package webhook converts the webhooks sent by third-party services into
+// notifications, so they may be delivered like any other message.
+//
+// Each service has its own way of authenticating its webhooks (e.g., an
+// HMAC of the body, or a shared token), verified by the service's Verify
+// function, and its own payloads, converted by the service's Parse
+// function.
+//
+// PagerDutyEvent, on the other hand, is the format of PagerDuty's Events
+// API, used by tools that would otherwise send their alerts to PagerDuty.
+package webhook
+
+// Notification is a webhook converted into a message.
+type Notification struct {
+	// The channel that should receive the message.
+	Channel string
+
+	// The message itself.
+	Message string
+}
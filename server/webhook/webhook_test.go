@@ -0,0 +1,338 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// sign body as GitHub does.
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// TestVerifyGitHub checks that only bodies signed with the secret are
+// accepted.
+func TestVerifyGitHub(t *testing.T) {
+	body := `{"action":"opened"}`
+
+	test_cases := []struct{
+		name string
+		signature string
+		err error
+	}{
+		{"valid", sign("secret", body), nil},
+		{"missing", "", ErrMissingSignature},
+		{"wrong secret", sign("other", body), ErrInvalidSignature},
+		{"wrong body", sign("secret", body + " "), ErrInvalidSignature},
+		{"no prefix", sign("secret", body)[len("sha256="):], ErrInvalidSignature},
+		{"not hex", "sha256=zz", ErrInvalidSignature},
+	}
+
+	for _, tc := range test_cases {
+		err := VerifyGitHub([]byte("secret"), []byte(body), tc.signature)
+		if err != tc.err {
+			t.Errorf("(%s) VerifyGitHub: Expected error '%+v' but got '%+v'", tc.name, tc.err, err)
+		}
+	}
+}
+
+// TestParseGitHub checks that issues and pull requests are converted into
+// notifications for their repository.
+func TestParseGitHub(t *testing.T) {
+	test_cases := []struct{
+		event string
+		body string
+		n Notification
+		err error
+	}{
+		{
+			"issues",
+			`{"action":"opened","issue":{"number":7,"title":"Crash","html_url":"https://github.com/o/r/issues/7"},"repository":{"full_name":"o/r"},"sender":{"login":"u"}}`,
+			Notification{"o/r", "Issue #7 opened: Crash (by u)\nhttps://github.com/o/r/issues/7"},
+			nil,
+		},
+		{
+			"pull_request",
+			`{"action":"closed","pull_request":{"number":8,"title":"Fix","merged":true},"repository":{"full_name":"o/r"}}`,
+			Notification{"o/r", "Pull request #8 merged: Fix"},
+			nil,
+		},
+		{
+			"pull_request",
+			`{"action":"ready_for_review","pull_request":{"number":9,"title":"WIP"},"repository":{"full_name":"o/r"}}`,
+			Notification{"o/r", "Pull request #9 ready for review: WIP"},
+			nil,
+		},
+		{"ping", `{"zen":"Keep it simple."}`, Notification{}, ErrIgnoredEvent},
+		{"issues", `{"action":"opened"`, Notification{}, ErrInvalidPayload},
+		{"issues", `{"action":"opened","repository":{"full_name":"o/r"}}`, Notification{}, ErrInvalidPayload},
+	}
+
+	for i, tc := range test_cases {
+		n, err := ParseGitHub(tc.event, []byte(tc.body))
+		if err != tc.err {
+			t.Errorf("(%d) ParseGitHub: Expected error '%+v' but got '%+v'", i, tc.err, err)
+		} else if n != tc.n {
+			t.Errorf("(%d) ParseGitHub: Expected '%+v' but got '%+v'", i, tc.n, n)
+		}
+	}
+}
+
+// TestVerifyGitLab checks that only requests with the secret token are
+// accepted.
+func TestVerifyGitLab(t *testing.T) {
+	test_cases := []struct{
+		token string
+		err error
+	}{
+		{"secret", nil},
+		{"", ErrMissingSignature},
+		{"secre", ErrInvalidSignature},
+		{"secret ", ErrInvalidSignature},
+	}
+
+	for _, tc := range test_cases {
+		err := VerifyGitLab([]byte("secret"), tc.token)
+		if err != tc.err {
+			t.Errorf("(%s) VerifyGitLab: Expected error '%+v' but got '%+v'", tc.token, tc.err, err)
+		}
+	}
+}
+
+// TestParseGitLab checks that issues and merge requests are converted into
+// notifications for their project.
+func TestParseGitLab(t *testing.T) {
+	test_cases := []struct{
+		event string
+		body string
+		n Notification
+		err error
+	}{
+		{
+			"Issue Hook",
+			`{"object_kind":"issue","object_attributes":{"iid":3,"title":"Crash","url":"https://gitlab.com/g/p/-/issues/3","action":"open"},"project":{"path_with_namespace":"g/p"},"user":{"username":"u"}}`,
+			Notification{"g/p", "Issue #3 opened: Crash (by u)\nhttps://gitlab.com/g/p/-/issues/3"},
+			nil,
+		},
+		{
+			"Merge Request Hook",
+			`{"object_kind":"merge_request","object_attributes":{"iid":4,"title":"Fix","action":"merge"},"project":{"path_with_namespace":"g/sub/p"}}`,
+			Notification{"g/sub/p", "Merge request !4 merged: Fix"},
+			nil,
+		},
+		{
+			"Issue Hook",
+			`{"object_attributes":{"iid":5,"title":"Old"},"project":{"path_with_namespace":"g/p"}}`,
+			Notification{"g/p", "Issue #5 updated: Old"},
+			nil,
+		},
+		{"Push Hook", `{"object_kind":"push"}`, Notification{}, ErrIgnoredEvent},
+		{"Issue Hook", `[]`, Notification{}, ErrInvalidPayload},
+		{"Issue Hook", `{"project":{"path_with_namespace":"g/p"}}`, Notification{}, ErrInvalidPayload},
+	}
+
+	for i, tc := range test_cases {
+		n, err := ParseGitLab(tc.event, []byte(tc.body))
+		if err != tc.err {
+			t.Errorf("(%d) ParseGitLab: Expected error '%+v' but got '%+v'", i, tc.err, err)
+		} else if n != tc.n {
+			t.Errorf("(%d) ParseGitLab: Expected '%+v' but got '%+v'", i, tc.n, n)
+		}
+	}
+}
+
+// TestVerifySentry checks that only bodies signed with the client secret
+// are accepted.
+func TestVerifySentry(t *testing.T) {
+	body := `{"action":"triggered"}`
+	valid := sign("secret", body)[len("sha256="):]
+
+	test_cases := []struct{
+		name string
+		signature string
+		err error
+	}{
+		{"valid", valid, nil},
+		{"missing", "", ErrMissingSignature},
+		{"wrong secret", sign("other", body)[len("sha256="):], ErrInvalidSignature},
+		{"prefixed", "sha256=" + valid, ErrInvalidSignature},
+	}
+
+	for _, tc := range test_cases {
+		err := VerifySentry([]byte("secret"), []byte(body), tc.signature)
+		if err != tc.err {
+			t.Errorf("(%s) VerifySentry: Expected error '%+v' but got '%+v'", tc.name, tc.err, err)
+		}
+	}
+}
+
+// TestParseSentry checks that issue alerts are converted into
+// notifications for their project.
+func TestParseSentry(t *testing.T) {
+	test_cases := []struct{
+		resource string
+		body string
+		n Notification
+		err error
+	}{
+		{
+			"event_alert",
+			`{"action":"triggered","data":{"event":{"title":"ReferenceError: x is not defined","culprit":"?(runner)","project":1,"url":"https://sentry.io/api/0/projects/org/front-end/events/abc/","web_url":"https://sentry.io/organizations/org/issues/1/events/abc/"},"triggered_rule":"Rule"}}`,
+			Notification{"front-end", "ReferenceError: x is not defined in ?(runner)\nhttps://sentry.io/organizations/org/issues/1/events/abc/"},
+			nil,
+		},
+		{
+			"event_alert",
+			`{"data":{"event":{"title":"Error","project":42}}}`,
+			Notification{"42", "Error"},
+			nil,
+		},
+		{"installation", `{"action":"created"}`, Notification{}, ErrIgnoredEvent},
+		{"event_alert", `{"data":{}}`, Notification{}, ErrInvalidPayload},
+		{"event_alert", `{"data":{"event":{"title":"Error"}}}`, Notification{}, ErrInvalidPayload},
+	}
+
+	for i, tc := range test_cases {
+		n, err := ParseSentry(tc.resource, []byte(tc.body))
+		if err != tc.err {
+			t.Errorf("(%d) ParseSentry: Expected error '%+v' but got '%+v'", i, tc.err, err)
+		} else if n != tc.n {
+			t.Errorf("(%d) ParseSentry: Expected '%+v' but got '%+v'", i, tc.n, n)
+		}
+	}
+}
+
+// TestVerifySlack checks that only recent requests signed with the
+// signing secret are accepted.
+func TestVerifySlack(t *testing.T) {
+	body := "command=%2Fnotify&text=alerts+hi"
+	now := time.Unix(1700000000, 0)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	old := strconv.FormatInt(now.Add(-SlackMaxSkew - time.Second).Unix(), 10)
+
+	slackSign := func(secret, ts string) string {
+		return "v0=" + sign(secret, "v0:" + ts + ":" + body)[len("sha256="):]
+	}
+
+	test_cases := []struct{
+		name string
+		timestamp string
+		signature string
+		err error
+	}{
+		{"valid", ts, slackSign("secret", ts), nil},
+		{"missing signature", ts, "", ErrMissingSignature},
+		{"missing timestamp", "", slackSign("secret", ts), ErrMissingSignature},
+		{"wrong secret", ts, slackSign("other", ts), ErrInvalidSignature},
+		{"other timestamp", ts, slackSign("secret", old), ErrInvalidSignature},
+		{"stale", old, slackSign("secret", old), ErrStaleRequest},
+		{"invalid timestamp", "now", slackSign("secret", "now"), ErrInvalidSignature},
+	}
+
+	for _, tc := range test_cases {
+		err := VerifySlack([]byte("secret"), []byte(body), tc.timestamp, tc.signature, now)
+		if err != tc.err {
+			t.Errorf("(%s) VerifySlack: Expected error '%+v' but got '%+v'", tc.name, tc.err, err)
+		}
+	}
+}
+
+// TestParseSlack checks that slash commands and events are converted
+// into notifications for the channel in their text.
+func TestParseSlack(t *testing.T) {
+	n, err := ParseSlackCommand([]byte("command=%2Fnotify&text=alerts+Deploy+finished&channel_name=ops"))
+	if want := (Notification{"alerts", "Deploy finished"}); err != nil || n != want {
+		t.Errorf("ParseSlackCommand: Expected '%+v' but got '%+v' (%+v)", want, n, err)
+	}
+	_, err = ParseSlackCommand([]byte("command=%2Fnotify&text=alerts"))
+	if err != ErrInvalidPayload {
+		t.Errorf("ParseSlackCommand: Expected error '%+v' but got '%+v'", ErrInvalidPayload, err)
+	}
+
+	test_cases := []struct{
+		body string
+		n Notification
+		challenge string
+		err error
+	}{
+		{`{"type":"url_verification","challenge":"c4"}`, Notification{}, "c4", nil},
+		{
+			`{"type":"event_callback","event":{"type":"app_mention","text":"<@U01> alerts  Disk is full"}}`,
+			Notification{"alerts", "Disk is full"},
+			"",
+			nil,
+		},
+		{
+			`{"type":"event_callback","event":{"type":"message","channel_type":"im","text":"alerts hi"}}`,
+			Notification{"alerts", "hi"},
+			"",
+			nil,
+		},
+		{`{"type":"event_callback","event":{"type":"message","channel_type":"channel","text":"alerts hi"}}`, Notification{}, "", ErrIgnoredEvent},
+		{`{"type":"event_callback","event":{"type":"app_mention","bot_id":"B1","text":"alerts hi"}}`, Notification{}, "", ErrIgnoredEvent},
+		{`{"type":"event_callback","event":{"type":"app_mention","text":"<@U01>"}}`, Notification{}, "", ErrInvalidPayload},
+		{`{"type":"app_rate_limited"}`, Notification{}, "", ErrIgnoredEvent},
+		{`{"type":`, Notification{}, "", ErrInvalidPayload},
+	}
+
+	for i, tc := range test_cases {
+		n, challenge, err := ParseSlackEvent([]byte(tc.body))
+		if err != tc.err {
+			t.Errorf("(%d) ParseSlackEvent: Expected error '%+v' but got '%+v'", i, tc.err, err)
+		} else if n != tc.n || challenge != tc.challenge {
+			t.Errorf("(%d) ParseSlackEvent: Expected '%+v' ('%s') but got '%+v' ('%s')", i, tc.n, tc.challenge, n, challenge)
+		}
+	}
+}
+
+// TestPagerDutyEvent checks that PagerDuty's events are validated and
+// converted into notifications for their routing key.
+func TestPagerDutyEvent(t *testing.T) {
+	test_cases := []struct{
+		body string
+		problems int
+		n Notification
+	}{
+		{
+			`{"routing_key":"ops","event_action":"trigger","dedup_key":"k1","payload":{"summary":"Disk full","source":"db1","severity":"critical","component":"postgres"}}`,
+			0,
+			Notification{"ops", "[CRITICAL] Disk full (source: db1, component: postgres)\ndedup_key: k1"},
+		},
+		{
+			`{"routing_key":"ops","event_action":"resolve","dedup_key":"k1"}`,
+			0,
+			Notification{"ops", "Resolved\ndedup_key: k1"},
+		},
+		{`{"routing_key":"ops","event_action":"acknowledge"}`, 1, Notification{}},
+		{`{"event_action":"trigger","payload":{"summary":"s","source":"s","severity":"fatal"}}`, 2, Notification{}},
+		{`{"routing_key":"ops","event_action":"trigger","payload":{}}`, 3, Notification{}},
+		{`{"routing_key":"ops","event_action":"trigger"}`, 1, Notification{}},
+		{`{"routing_key":"ops"}`, 1, Notification{}},
+	}
+
+	for i, tc := range test_cases {
+		var ev PagerDutyEvent
+		err := json.Unmarshal([]byte(tc.body), &ev)
+		if err != nil {
+			t.Fatalf("(%d) Failed to decode the event: %+v", i, err)
+		}
+
+		problems := ev.Validate()
+		if len(problems) != tc.problems {
+			t.Errorf("(%d) Validate: Expected '%d' problems but got '%+v'", i, tc.problems, problems)
+		} else if len(problems) > 0 {
+			continue
+		}
+
+		if n := ev.Notification(); n != tc.n {
+			t.Errorf("(%d) Notification: Expected '%+v' but got '%+v'", i, tc.n, n)
+		}
+	}
+}